@@ -0,0 +1,56 @@
+package bench_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/PCS-Indonesia/pcs-oidc/oidc/bench"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRun(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("fixed number of operations", func(t *testing.T) {
+		res := bench.Run(ctx, bench.Config{Concurrency: 4, Ops: 100}, func(ctx context.Context) error {
+			time.Sleep(time.Millisecond)
+			return nil
+		})
+		require.Equal(t, 100, res.Ops)
+		require.Zero(t, res.Errors)
+		require.GreaterOrEqual(t, res.P50, time.Millisecond)
+		require.LessOrEqual(t, res.P50, res.P90)
+		require.LessOrEqual(t, res.P90, res.P99)
+		require.LessOrEqual(t, res.P99, res.Max)
+		require.Positive(t, res.OpsPerSecond())
+	})
+
+	t.Run("errors are counted", func(t *testing.T) {
+		boom := errors.New("boom")
+		res := bench.Run(ctx, bench.Config{Concurrency: 2, Ops: 10}, func(ctx context.Context) error { return boom })
+		require.Equal(t, 10, res.Errors)
+		require.ErrorIs(t, res.FirstError, boom)
+	})
+
+	t.Run("duration", func(t *testing.T) {
+		start := time.Now()
+		res := bench.Run(ctx, bench.Config{Concurrency: 2, Duration: 50 * time.Millisecond}, func(ctx context.Context) error {
+			time.Sleep(time.Millisecond)
+			return nil
+		})
+		require.Less(t, time.Since(start), time.Second)
+		require.Positive(t, res.Ops)
+		require.Zero(t, res.Errors)
+	})
+
+	t.Run("latency bookkeeping does not allocate", func(t *testing.T) {
+		res := bench.Run(ctx, bench.Config{Concurrency: 4, Ops: 200000}, func(ctx context.Context) error { return nil })
+		require.Equal(t, 200000, res.Ops)
+		require.Zero(t, res.AllocsPerOp)
+		require.Zero(t, res.BytesPerOp)
+		require.LessOrEqual(t, res.P99, res.Max)
+	})
+}
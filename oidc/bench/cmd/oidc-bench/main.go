@@ -0,0 +1,79 @@
+// Command oidc-bench runs the soak test scenarios of package bench and prints one result line per
+// scenario, e.g.
+//
+//	go run ./oidc/bench/cmd/oidc-bench -scenario cache,wif -concurrency 64 -duration 5m -lifetime 65s
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/PCS-Indonesia/pcs-oidc/oidc/bench"
+)
+
+func main() {
+	var (
+		cfg      bench.Config
+		mock     bench.MockConfig
+		names    string
+		list     bool
+		failures bool
+	)
+	flag.StringVar(&names, "scenario", "all", "comma separated scenarios to run, or all")
+	flag.BoolVar(&list, "list", false, "list the scenarios and exit")
+	flag.IntVar(&cfg.Concurrency, "concurrency", 0, "parallel workers, default GOMAXPROCS")
+	flag.DurationVar(&cfg.Duration, "duration", 0, "run time per scenario, default 10s")
+	flag.IntVar(&cfg.Ops, "ops", 0, "operations per scenario instead of a run time")
+	flag.DurationVar(&mock.TokenLifetime, "lifetime", 0, "lifetime of mock tokens, default 5m; just above 1m forces churn")
+	flag.DurationVar(&mock.Latency, "latency", 0, "latency added to every mock response")
+	flag.Float64Var(&mock.FailureRate, "failure-rate", 0, "fraction of mock requests answered with 503")
+	flag.BoolVar(&failures, "fail-on-error", false, "exit with status 1 when an operation failed")
+	flag.Parse()
+
+	if list {
+		for _, s := range bench.Scenarios() {
+			fmt.Printf("%-12s %s\n", s.Name, s.Description)
+		}
+		return
+	}
+
+	var scenarios []bench.Scenario
+	if names == "all" {
+		scenarios = bench.Scenarios()
+	} else {
+		for _, name := range strings.Split(names, ",") {
+			s, ok := bench.LookupScenario(strings.TrimSpace(name))
+			if !ok {
+				fmt.Fprintf(os.Stderr, "unknown scenario %q, see -list\n", name)
+				os.Exit(2)
+			}
+			scenarios = append(scenarios, s)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	failed := false
+	for _, s := range scenarios {
+		res, err := bench.RunScenario(ctx, s, cfg, mock)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Println(res)
+		if res.FirstError != nil {
+			fmt.Fprintf(os.Stderr, "%s: first error: %v\n", s.Name, res.FirstError)
+			failed = true
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	if failures && failed {
+		os.Exit(1)
+	}
+}
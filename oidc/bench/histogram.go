@@ -0,0 +1,80 @@
+package bench
+
+import (
+	"math/bits"
+	"time"
+)
+
+// subBits sets the resolution of a histogram: every power of two is split into 1<<subBits buckets, so
+// a reported percentile is at most 1/16 (6.25%) above the true latency.
+const (
+	subBits     = 4
+	subBuckets  = 1 << subBits
+	histBuckets = (64 - subBits + 1) * subBuckets
+)
+
+// histogram counts latencies in fixed log-linear buckets. Its size does not depend on the number of
+// samples and recording never allocates, so long soak runs neither grow the heap nor add to the
+// allocation counts of a Result.
+type histogram struct {
+	counts [histBuckets]uint64
+	total  uint64
+	max    time.Duration
+}
+
+// record adds one latency.
+func (h *histogram) record(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	h.counts[bucketOf(uint64(d))]++
+	h.total++
+	h.max = max(h.max, d)
+}
+
+// merge adds the samples of other.
+func (h *histogram) merge(other *histogram) {
+	for i, n := range other.counts {
+		h.counts[i] += n
+	}
+	h.total += other.total
+	h.max = max(h.max, other.max)
+}
+
+// percentile returns the upper bound of the bucket holding the p-th percentile (nearest rank), capped
+// at the largest recorded latency.
+func (h *histogram) percentile(p float64) time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	rank := uint64(float64(h.total)*p + 0.5)
+	rank = max(1, min(rank, h.total))
+	var seen uint64
+	for i, n := range h.counts {
+		seen += n
+		if seen >= rank {
+			return min(time.Duration(bucketMax(i)), h.max)
+		}
+	}
+	return h.max
+}
+
+// bucketOf returns the bucket of v: values below 2*subBuckets have a bucket each, larger values keep
+// their subBits+1 most significant bits.
+func bucketOf(v uint64) int {
+	if v < 2*subBuckets {
+		return int(v)
+	}
+	shift := bits.Len64(v) - subBits - 1
+	return shift*subBuckets + int(v>>shift)
+}
+
+// bucketMax returns the largest value of bucket i.
+func bucketMax(i int) uint64 {
+	if i < 2*subBuckets {
+		return uint64(i)
+	}
+	shift := i/subBuckets - 1
+	top := uint64(i%subBuckets + subBuckets)
+	return (top+1)<<shift - 1
+}
@@ -0,0 +1,128 @@
+package bench
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"time"
+)
+
+// MockRealm is the Keycloak realm served by Mock.
+const MockRealm = "bench"
+
+// MockConfig shapes the responses of Mock.
+type MockConfig struct {
+	// TokenLifetime is the lifetime of issued tokens, default 5 minutes. The caches refresh a minute
+	// before expiry, so lifetimes just above a minute force a refresh every few seconds.
+	TokenLifetime time.Duration
+	Latency       time.Duration // added to every response, e.g. to model a remote IdP
+	FailureRate   float64       // fraction of requests answered with 503 and Retry-After
+}
+
+// Mock is an in-process Keycloak token endpoint and Google STS. Tokens are unsigned JWTs, so it is
+// only suitable for caches, providers and exchanges that do not verify signatures.
+type Mock struct {
+	cfg    MockConfig
+	server *httptest.Server
+
+	idpRequests atomic.Int64
+	stsRequests atomic.Int64
+	failures    atomic.Int64
+	issued      atomic.Int64
+}
+
+// NewMock starts a mock IdP and STS; Close stops it.
+func NewMock(cfg MockConfig) *Mock {
+	if cfg.TokenLifetime <= 0 {
+		cfg.TokenLifetime = 5 * time.Minute
+	}
+	m := &Mock{cfg: cfg}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/realms/"+MockRealm+"/protocol/openid-connect/token", m.token)
+	mux.HandleFunc("/v1/token", m.sts)
+	m.server = httptest.NewServer(mux)
+	return m
+}
+
+// RealmURL returns the Keycloak realm URL of the mock.
+func (m *Mock) RealmURL() string { return m.server.URL + "/realms/" + MockRealm }
+
+// STSURL returns the STS token URL of the mock.
+func (m *Mock) STSURL() string { return m.server.URL + "/v1/token" }
+
+// IdPRequests returns the number of requests served by the token endpoint.
+func (m *Mock) IdPRequests() int64 { return m.idpRequests.Load() }
+
+// STSRequests returns the number of requests served by the STS.
+func (m *Mock) STSRequests() int64 { return m.stsRequests.Load() }
+
+// Failures returns the number of requests answered with an injected failure.
+func (m *Mock) Failures() int64 { return m.failures.Load() }
+
+// Close stops the mock.
+func (m *Mock) Close() { m.server.Close() }
+
+func (m *Mock) token(w http.ResponseWriter, r *http.Request) {
+	m.idpRequests.Add(1)
+	if !m.serve(w) {
+		return
+	}
+	m.writeJSON(w, map[string]interface{}{
+		"access_token": m.jwt(),
+		"id_token":     m.jwt(),
+		"token_type":   "Bearer",
+		"expires_in":   int(m.cfg.TokenLifetime / time.Second),
+	})
+}
+
+func (m *Mock) sts(w http.ResponseWriter, r *http.Request) {
+	m.stsRequests.Add(1)
+	if !m.serve(w) {
+		return
+	}
+	m.writeJSON(w, map[string]interface{}{
+		"access_token":      fmt.Sprintf("ya29.bench-%d", m.issued.Add(1)),
+		"issued_token_type": "urn:ietf:params:oauth:token-type:access_token",
+		"token_type":        "Bearer",
+		"expires_in":        int(m.cfg.TokenLifetime / time.Second),
+	})
+}
+
+// serve applies the configured latency and failure rate, it reports whether to answer normally.
+func (m *Mock) serve(w http.ResponseWriter) bool {
+	if m.cfg.Latency > 0 {
+		time.Sleep(m.cfg.Latency)
+	}
+	if m.cfg.FailureRate > 0 && rand.Float64() < m.cfg.FailureRate {
+		m.failures.Add(1)
+		w.Header().Set("Retry-After", "1")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte(`{"error":"temporarily_unavailable"}`))
+		return false
+	}
+	return true
+}
+
+// jwt returns a fresh unsigned JWT expiring after TokenLifetime.
+func (m *Mock) jwt() string {
+	now := time.Now()
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss": m.RealmURL(),
+		"sub": "bench",
+		"aud": "bench",
+		"iat": now.Unix(),
+		"exp": now.Add(m.cfg.TokenLifetime).Unix(),
+		"jti": fmt.Sprintf("bench-%d", m.issued.Add(1)),
+	})
+	enc := base64.RawURLEncoding.EncodeToString
+	return enc([]byte(`{"alg":"none","typ":"JWT"}`)) + "." + enc(claims) + ".sig"
+}
+
+func (m *Mock) writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,47 @@
+package bench_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/PCS-Indonesia/pcs-oidc/oidc/bench"
+	oidcprovider "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMock(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("issues tokens with the configured lifetime", func(t *testing.T) {
+		m := bench.NewMock(bench.MockConfig{TokenLifetime: 2 * time.Minute})
+		t.Cleanup(m.Close)
+		p := &oidcprovider.KeycloakTokenProvider{Config: &oidcprovider.ConfigKeyCloak{KeycloakRealmURL: m.RealmURL(), KeycloakClientID: "c", KeycloakClientSecret: "s"}}
+		token, err := p.FetchToken(ctx)
+		require.NoError(t, err)
+		claims, err := oidcprovider.DecodeJWTClaims(token, false)
+		require.NoError(t, err)
+		require.InDelta(t, time.Now().Add(2*time.Minute).Unix(), claims["exp"], 2)
+		require.EqualValues(t, 1, m.IdPRequests())
+
+		resp, err := http.PostForm(m.STSURL(), nil)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.EqualValues(t, 1, m.STSRequests())
+	})
+
+	t.Run("injects failures", func(t *testing.T) {
+		m := bench.NewMock(bench.MockConfig{FailureRate: 1})
+		t.Cleanup(m.Close)
+		p := &oidcprovider.KeycloakTokenProvider{Config: &oidcprovider.ConfigKeyCloak{KeycloakRealmURL: m.RealmURL(), KeycloakClientID: "c", KeycloakClientSecret: "s"}}
+		_, err := p.FetchToken(ctx)
+		var tErr *oidcprovider.TokenError
+		require.True(t, errors.As(err, &tErr))
+		require.Equal(t, http.StatusServiceUnavailable, tErr.StatusCode)
+		require.Positive(t, m.Failures())
+	})
+}
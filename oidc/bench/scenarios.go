@@ -0,0 +1,132 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	gcpwif "github.com/PCS-Indonesia/pcs-oidc/oidc/google"
+	oidcprovider "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+)
+
+// Scenario is a named workload run against a Mock.
+type Scenario struct {
+	Name        string
+	Description string
+	// Setup builds the operation under test against m; cleanup, when not nil, runs after the load run.
+	Setup func(m *Mock) (op Op, cleanup func(), err error)
+}
+
+// Scenarios returns the built-in scenarios.
+func Scenarios() []Scenario {
+	return []Scenario{
+		{
+			Name:        "provider",
+			Description: "KeycloakTokenProvider.FetchToken without cache, every call reaches the IdP",
+			Setup: func(m *Mock) (Op, func(), error) {
+				p := mockKeycloak(m)
+				return func(ctx context.Context) error {
+					_, err := p.FetchToken(ctx)
+					return err
+				}, nil, nil
+			},
+		},
+		{
+			Name:        "cache",
+			Description: "one TokenCache shared by all workers",
+			Setup: func(m *Mock) (Op, func(), error) {
+				cache := oidcprovider.NewTokenCache(mockKeycloak(m))
+				return cacheOp(cache), func() { cache.Close() }, nil
+			},
+		},
+		{
+			Name:        "cache-store",
+			Description: "one TokenCache persisting every fetched token to a FileCacheStore",
+			Setup: func(m *Mock) (Op, func(), error) {
+				dir, err := os.MkdirTemp("", "pcs-oidc-bench-")
+				if err != nil {
+					return nil, nil, err
+				}
+				store, err := oidcprovider.NewFileCacheStore(dir)
+				if err != nil {
+					os.RemoveAll(dir)
+					return nil, nil, err
+				}
+				cache := oidcprovider.NewTokenCache(mockKeycloak(m), oidcprovider.WithStore(store, "bench"))
+				return cacheOp(cache), func() {
+					cache.Close()
+					os.RemoveAll(dir)
+				}, nil
+			},
+		},
+		{
+			Name:        "wif",
+			Description: "GCP token source exchanging a cached Keycloak token at the STS",
+			Setup: func(m *Mock) (Op, func(), error) {
+				cache := oidcprovider.NewTokenCache(mockKeycloak(m))
+				ts, err := gcpwif.GetGCPTokenSource(context.Background(), gcpwif.NewWIFConfig(
+					"//iam.googleapis.com/projects/1/locations/global/workloadIdentityPools/bench/providers/keycloak",
+					"urn:ietf:params:oauth:token-type:jwt",
+					m.STSURL(),
+					[]string{"https://www.googleapis.com/auth/cloud-platform"},
+					"",
+					&gcpwif.TokenCacheSupplier{Cache: cache},
+				))
+				if err != nil {
+					cache.Close()
+					return nil, nil, err
+				}
+				return func(ctx context.Context) error {
+					_, err := ts.Token()
+					return err
+				}, func() { cache.Close() }, nil
+			},
+		},
+	}
+}
+
+// LookupScenario returns the built-in scenario called name.
+func LookupScenario(name string) (Scenario, bool) {
+	for _, s := range Scenarios() {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return Scenario{}, false
+}
+
+// RunScenario starts a Mock configured by mock, runs s against it with cfg and reports the requests
+// the mock served during the run.
+func RunScenario(ctx context.Context, s Scenario, cfg Config, mock MockConfig) (Result, error) {
+	m := NewMock(mock)
+	defer m.Close()
+	op, cleanup, err := s.Setup(m)
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to set up scenario %s: %w", s.Name, err)
+	}
+	if cleanup != nil {
+		defer cleanup()
+	}
+	res := Run(ctx, cfg, op)
+	res.Scenario = s.Name
+	res.IdPRequests = m.IdPRequests()
+	res.STSRequests = m.STSRequests()
+	return res, nil
+}
+
+// mockKeycloak returns a client credentials provider of the mock realm.
+func mockKeycloak(m *Mock) *oidcprovider.KeycloakTokenProvider {
+	return &oidcprovider.KeycloakTokenProvider{Config: &oidcprovider.ConfigKeyCloak{
+		KeycloakRealmURL:     m.RealmURL(),
+		KeycloakClientID:     "bench",
+		KeycloakClientSecret: "bench",
+	}}
+}
+
+// cacheOp returns an operation reading a valid token from cache.
+func cacheOp(cache *oidcprovider.TokenCache) Op {
+	return func(ctx context.Context) error {
+		_, err := cache.GetValidToken(ctx)
+		return err
+	}
+}
@@ -0,0 +1,51 @@
+package bench_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/PCS-Indonesia/pcs-oidc/oidc/bench"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestScenarios(t *testing.T) {
+	ctx := context.Background()
+	cfg := bench.Config{Concurrency: 8, Ops: 200}
+
+	for _, s := range bench.Scenarios() {
+		t.Run(s.Name, func(t *testing.T) {
+			res, err := bench.RunScenario(ctx, s, cfg, bench.MockConfig{})
+			require.NoError(t, err)
+			require.NoError(t, res.FirstError)
+			require.Equal(t, 200, res.Ops)
+			require.Equal(t, s.Name, res.Scenario)
+			switch s.Name {
+			case "provider":
+				require.EqualValues(t, 200, res.IdPRequests)
+			case "wif":
+				// The cached Google token is reused, so the chain is exchanged once
+				require.EqualValues(t, 1, res.IdPRequests)
+				require.EqualValues(t, 1, res.STSRequests)
+			default:
+				require.EqualValues(t, 1, res.IdPRequests)
+			}
+		})
+	}
+
+	t.Run("short lifetimes churn the cache", func(t *testing.T) {
+		s, ok := bench.LookupScenario("cache")
+		require.True(t, ok)
+		// Tokens are refreshed a minute before expiry, so every call fetches a new one
+		res, err := bench.RunScenario(ctx, s, bench.Config{Concurrency: 4, Ops: 20}, bench.MockConfig{TokenLifetime: time.Minute})
+		require.NoError(t, err)
+		require.NoError(t, res.FirstError)
+		require.EqualValues(t, 20, res.IdPRequests)
+	})
+
+	t.Run("unknown scenario", func(t *testing.T) {
+		_, ok := bench.LookupScenario("nope")
+		require.False(t, ok)
+	})
+}
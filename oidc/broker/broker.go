@@ -0,0 +1,220 @@
+// Package broker serves the credentials of an oidcprovider.Manager to local processes, e.g. as a
+// sidecar, so services written in any language can obtain fresh tokens over HTTP or gRPC.
+package broker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	oidcprovider "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+)
+
+// DefaultKeepAlive is the interval of the comments keeping idle SSE streams open.
+const DefaultKeepAlive = 15 * time.Second
+
+// TokenResponse is the JSON document returned for a credential, also the data of SSE token events.
+// Timestamp and Signature are only set by a broker with a Secret, see VerifyTokenResponse.
+type TokenResponse struct {
+	Token     string    `json:"token"`
+	TokenType string    `json:"token_type"`
+	Expiry    time.Time `json:"expiry"`
+	Timestamp int64     `json:"timestamp,omitempty"`
+	Signature string    `json:"signature,omitempty"`
+}
+
+// ErrorResponse is returned (or streamed as an SSE error event) when no token is available.
+// Timestamp and Signature are only set by a broker with a Secret, see VerifyErrorResponse.
+type ErrorResponse struct {
+	Error     string `json:"error"`
+	Timestamp int64  `json:"timestamp,omitempty"`
+	Signature string `json:"signature,omitempty"`
+}
+
+// Broker exposes the credentials of a Manager:
+//
+//	GET /token/{name}         current token as TokenResponse
+//	GET /token/{name}/stream  Server-Sent Events, a "token" event for every refreshed token and an
+//	                          "error" event for failed refreshes
+//	GET /token/{name}/capabilities
+//	                          the provider's oidcprovider.Capabilities, so clients can skip unsupported operations
+//
+// The stream lets clients without polling or backoff logic (shell, Python, ...) get rotation for free.
+//
+// With Secret set every token and error response is signed (HMAC-SHA256), so clients holding the same
+// secret can detect a spoofed broker, e.g. another container sharing the network namespace.
+type Broker struct {
+	Manager   *oidcprovider.Manager
+	KeepAlive time.Duration // default DefaultKeepAlive
+	Secret    []byte        // optional shared local secret signing token and error responses
+}
+
+// New returns a broker serving the credentials of manager.
+func New(manager *oidcprovider.Manager) *Broker {
+	return &Broker{Manager: manager}
+}
+
+// Handler returns the HTTP handler of the broker endpoints.
+func (b *Broker) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /token/{name}", b.serveToken)
+	mux.HandleFunc("GET /token/{name}/stream", b.serveStream)
+	mux.HandleFunc("GET /token/{name}/capabilities", b.serveCapabilities)
+	return b.recoverPanics(mux)
+}
+
+// recoverPanics answers 500 with an ErrorResponse when a handler panics, e.g. on a token a provider
+// fails to parse, and reports the panic to the oidcprovider panic handler (see SetPanicHandler).
+func (b *Broker) recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rw := &responseWriter{ResponseWriter: w}
+		var err error
+		defer func() {
+			// A stream that already sent its header can only be closed
+			if err != nil && !rw.wroteHeader {
+				writeJSON(w, http.StatusInternalServerError, b.errorResponse(r.PathValue("name"), err.Error()))
+			}
+		}()
+		defer oidcprovider.Recover("broker "+r.Method+" "+r.URL.Path, &err)
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// responseWriter records whether the response header was sent.
+type responseWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *responseWriter) WriteHeader(code int) {
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, e.g. to flush SSE events.
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// serveToken answers with the current token of the credential.
+func (b *Broker) serveToken(w http.ResponseWriter, r *http.Request) {
+	cache, ok := b.cache(w, r)
+	if !ok {
+		return
+	}
+	token, expiry, err := cache.GetValidTokenExpiry(r.Context())
+	if err != nil {
+		writeJSON(w, http.StatusBadGateway, b.errorResponse(r.PathValue("name"), err.Error()))
+		return
+	}
+	writeJSON(w, http.StatusOK, b.tokenResponse(r.PathValue("name"), token, expiry))
+}
+
+// serveCapabilities answers with what the credential's provider supports.
+func (b *Broker) serveCapabilities(w http.ResponseWriter, r *http.Request) {
+	cache, ok := b.cache(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, cache.Capabilities())
+}
+
+// serveStream pushes every token of the credential as an SSE event until the client disconnects.
+func (b *Broker) serveStream(w http.ResponseWriter, r *http.Request) {
+	cache, ok := b.cache(w, r)
+	if !ok {
+		return
+	}
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	keepAlive := b.KeepAlive
+	if keepAlive <= 0 {
+		keepAlive = DefaultKeepAlive
+	}
+	ticker := time.NewTicker(keepAlive)
+	defer ticker.Stop()
+	name := r.PathValue("name")
+	updates := cache.Watch(r.Context())
+	id := 0
+	for {
+		var err error
+		select {
+		case update, ok := <-updates:
+			if !ok {
+				return
+			}
+			id++
+			if update.Err != nil {
+				err = writeEvent(w, id, "error", b.errorResponse(name, update.Err.Error()))
+			} else {
+				err = writeEvent(w, id, "token", b.tokenResponse(name, update.Token, update.Expiry))
+			}
+		case <-ticker.C:
+			_, err = fmt.Fprint(w, ": keep-alive\n\n")
+		}
+		if err == nil {
+			err = rc.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// tokenResponse builds the response for token of credential name, signed when the broker has a secret.
+func (b *Broker) tokenResponse(name, token string, expiry time.Time) TokenResponse {
+	resp := TokenResponse{Token: token, TokenType: "Bearer", Expiry: expiry}
+	if len(b.Secret) > 0 {
+		SignTokenResponse(b.Secret, name, &resp, time.Now())
+	}
+	return resp
+}
+
+// errorResponse builds the error response for credential name, signed when the broker has a secret.
+func (b *Broker) errorResponse(name, msg string) ErrorResponse {
+	resp := ErrorResponse{Error: msg}
+	if len(b.Secret) > 0 {
+		SignErrorResponse(b.Secret, name, &resp, time.Now())
+	}
+	return resp
+}
+
+// cache looks up the credential named in the request path, answering 404 when it is unknown.
+func (b *Broker) cache(w http.ResponseWriter, r *http.Request) (*oidcprovider.TokenCache, bool) {
+	name := r.PathValue("name")
+	cache, ok := b.Manager.Cache(name)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, b.errorResponse(name, fmt.Sprintf("unknown credential %q", name)))
+	}
+	return cache, ok
+}
+
+// writeEvent writes one SSE event with a JSON payload.
+func writeEvent(w http.ResponseWriter, id int, event string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", id, event, data)
+	return err
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,239 @@
+package broker_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/PCS-Indonesia/pcs-oidc/oidc/broker"
+	oidcprovider "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// counterProvider returns a new unsigned JWT on every fetch
+type counterProvider struct {
+	calls atomic.Int32
+}
+
+func (p *counterProvider) FetchToken(context.Context) (string, error) {
+	n := p.calls.Add(1)
+	payload := fmt.Sprintf(`{"exp":%d,"n":%d}`, time.Now().Add(time.Hour).Unix(), n)
+	return "eyJhbGciOiJub25lIn0." + base64.RawURLEncoding.EncodeToString([]byte(payload)) + ".sig", nil
+}
+
+func newBroker(t *testing.T) (*httptest.Server, *oidcprovider.TokenCache) {
+	t.Helper()
+	cache := oidcprovider.NewTokenCache(&counterProvider{})
+	m := oidcprovider.NewManager()
+	require.NoError(t, m.Add(oidcprovider.ManagedCredential{Name: "orders", Cache: cache}))
+	srv := httptest.NewServer(broker.New(m).Handler())
+	t.Cleanup(srv.Close)
+	return srv, cache
+}
+
+func TestBrokerToken(t *testing.T) {
+	srv, _ := newBroker(t)
+
+	resp, err := http.Get(srv.URL + "/token/orders")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var tok broker.TokenResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&tok))
+	require.NotEmpty(t, tok.Token)
+	require.Equal(t, "Bearer", tok.TokenType)
+	require.WithinDuration(t, time.Now().Add(time.Hour), tok.Expiry, 5*time.Second)
+
+	resp, err = http.Get(srv.URL + "/token/billing")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestBrokerCapabilities(t *testing.T) {
+	srv, _ := newBroker(t)
+
+	resp, err := http.Get(srv.URL + "/token/orders/capabilities")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var caps oidcprovider.Capabilities
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&caps))
+	require.Equal(t, oidcprovider.Capabilities{AccessToken: true}, caps)
+
+	resp, err = http.Get(srv.URL + "/token/billing/capabilities")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+// panicStore panics when a fetched token is saved
+type panicStore struct{}
+
+func (panicStore) Load(context.Context, string) (oidcprovider.StoredToken, error) {
+	return oidcprovider.StoredToken{}, oidcprovider.ErrCacheMiss
+}
+
+func (panicStore) Save(context.Context, string, oidcprovider.StoredToken) error {
+	panic("store is broken")
+}
+
+func TestBrokerRecoversPanics(t *testing.T) {
+	var panics atomic.Int32
+	oidcprovider.SetPanicHandler(func(*oidcprovider.PanicError) { panics.Add(1) })
+	t.Cleanup(func() { oidcprovider.SetPanicHandler(nil) })
+
+	cache := oidcprovider.NewTokenCache(&counterProvider{}, oidcprovider.WithStore(panicStore{}, "orders"))
+	m := oidcprovider.NewManager()
+	require.NoError(t, m.Add(oidcprovider.ManagedCredential{Name: "orders", Cache: cache}))
+	srv := httptest.NewServer(broker.New(m).Handler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/token/orders")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	var body broker.ErrorResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	require.Contains(t, body.Error, "store is broken")
+	require.EqualValues(t, 1, panics.Load())
+}
+
+// sseEvent is one parsed Server-Sent Event
+type sseEvent struct {
+	Event string
+	Data  string
+}
+
+func readEvent(t *testing.T, r *bufio.Reader) sseEvent {
+	t.Helper()
+	var ev sseEvent
+	for {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimRight(line, "\n")
+		switch {
+		case line == "" && ev.Event != "":
+			return ev
+		case strings.HasPrefix(line, "event: "):
+			ev.Event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			ev.Data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func TestBrokerStream(t *testing.T) {
+	srv, cache := newBroker(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/token/orders/stream", nil)
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	r := bufio.NewReader(resp.Body)
+
+	var first, second broker.TokenResponse
+	ev := readEvent(t, r)
+	require.Equal(t, "token", ev.Event)
+	require.NoError(t, json.Unmarshal([]byte(ev.Data), &first))
+
+	// A rotation is pushed without the client polling
+	cache.ForceExpire(time.Now())
+	rotated, err := cache.GetValidToken(ctx)
+	require.NoError(t, err)
+	ev = readEvent(t, r)
+	require.Equal(t, "token", ev.Event)
+	require.NoError(t, json.Unmarshal([]byte(ev.Data), &second))
+	require.Equal(t, rotated, second.Token)
+	require.NotEqual(t, first.Token, second.Token)
+}
+
+func TestBrokerGRPC(t *testing.T) {
+	ctx := context.Background()
+	cache := oidcprovider.NewTokenCache(&counterProvider{})
+	broken := oidcprovider.NewTokenCache(&counterProvider{}, oidcprovider.WithStore(panicStore{}, "broken"))
+	m := oidcprovider.NewManager()
+	require.NoError(t, m.Add(oidcprovider.ManagedCredential{Name: "orders", Cache: cache}))
+	require.NoError(t, m.Add(oidcprovider.ManagedCredential{Name: "broken", Cache: broken}))
+	b := broker.New(m)
+	b.Secret = []byte("local-secret")
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := grpc.NewServer()
+	b.RegisterGRPC(srv)
+	go func() { _ = srv.Serve(lis) }()
+	t.Cleanup(srv.Stop)
+	conn, err := grpc.NewClient(lis.Addr().String(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.CallContentSubtype(broker.GRPCContentSubtype)))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	t.Run("token", func(t *testing.T) {
+		var tok broker.TokenResponse
+		require.NoError(t, conn.Invoke(ctx, broker.GRPCTokenMethod, &broker.TokenRequest{Name: "orders"}, &tok))
+		require.NotEmpty(t, tok.Token)
+		require.NoError(t, broker.VerifyTokenResponse(b.Secret, "orders", &tok, time.Minute))
+	})
+
+	t.Run("unknown credential", func(t *testing.T) {
+		var trailer metadata.MD
+		err := conn.Invoke(ctx, broker.GRPCTokenMethod, &broker.TokenRequest{Name: "billing"}, &broker.TokenResponse{}, grpc.Trailer(&trailer))
+		require.Equal(t, codes.NotFound, status.Code(err))
+		values := trailer.Get(broker.GRPCErrorTrailer)
+		require.Len(t, values, 1)
+		var e broker.ErrorResponse
+		require.NoError(t, json.Unmarshal([]byte(values[0]), &e))
+		require.Contains(t, e.Error, "billing")
+		require.NoError(t, broker.VerifyErrorResponse(b.Secret, "billing", &e, time.Minute))
+	})
+
+	t.Run("panic", func(t *testing.T) {
+		oidcprovider.SetPanicHandler(func(*oidcprovider.PanicError) {})
+		t.Cleanup(func() { oidcprovider.SetPanicHandler(nil) })
+		err := conn.Invoke(ctx, broker.GRPCTokenMethod, &broker.TokenRequest{Name: "broken"}, &broker.TokenResponse{})
+		require.Equal(t, codes.Internal, status.Code(err))
+		require.Contains(t, status.Convert(err).Message(), "store is broken")
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		streamCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		stream, err := conn.NewStream(streamCtx, &grpc.StreamDesc{ServerStreams: true}, broker.GRPCStreamMethod)
+		require.NoError(t, err)
+		require.NoError(t, stream.SendMsg(&broker.TokenRequest{Name: "orders"}))
+		require.NoError(t, stream.CloseSend())
+
+		var first broker.StreamEvent
+		require.NoError(t, stream.RecvMsg(&first))
+		require.NotNil(t, first.Token)
+		cache.ForceExpire(time.Now())
+		rotated, err := cache.GetValidToken(ctx)
+		require.NoError(t, err)
+		var second broker.StreamEvent
+		require.NoError(t, stream.RecvMsg(&second))
+		require.NotNil(t, second.Token)
+		require.Equal(t, rotated, second.Token.Token)
+		require.NotEqual(t, first.Token.Token, second.Token.Token)
+	})
+}
@@ -0,0 +1,174 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	oidcprovider "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// gRPC names of the broker service, see RegisterGRPC.
+const (
+	GRPCServiceName  = "pcsoidc.broker.v1.Broker"
+	GRPCTokenMethod  = "/" + GRPCServiceName + "/Token"
+	GRPCStreamMethod = "/" + GRPCServiceName + "/Stream"
+	// GRPCContentSubtype selects the JSON codec of the service, clients send it with
+	// grpc.CallContentSubtype (content type "application/grpc+json").
+	GRPCContentSubtype = "json"
+	// GRPCErrorTrailer is the trailer carrying the (signed) ErrorResponse of a failed call as JSON.
+	GRPCErrorTrailer = "pcs-oidc-error-bin"
+)
+
+// TokenRequest names the credential of a gRPC Token or Stream call.
+type TokenRequest struct {
+	Name string `json:"name"`
+}
+
+// StreamEvent is one message of the gRPC stream, holding either a refreshed token or the error of a
+// failed refresh like the SSE events.
+type StreamEvent struct {
+	Token *TokenResponse `json:"token,omitempty"`
+	Error *ErrorResponse `json:"error,omitempty"`
+}
+
+// jsonCodec marshals the messages of the broker service as JSON, so the service needs no generated
+// protobuf code and clients in any language can call it.
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (jsonCodec) Name() string {
+	return GRPCContentSubtype
+}
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+// grpcBroker is the handler type of the service, implemented by *Broker.
+type grpcBroker interface {
+	grpcToken(ctx context.Context, req *TokenRequest) (*TokenResponse, error)
+	grpcStream(req *TokenRequest, stream grpc.ServerStream) error
+}
+
+var grpcServiceDesc = grpc.ServiceDesc{
+	ServiceName: GRPCServiceName,
+	HandlerType: (*grpcBroker)(nil),
+	Methods:     []grpc.MethodDesc{{MethodName: "Token", Handler: grpcTokenHandler}},
+	Streams:     []grpc.StreamDesc{{StreamName: "Stream", Handler: grpcStreamHandler, ServerStreams: true}},
+}
+
+// RegisterGRPC registers the broker service on s, next to any other service of the server:
+//
+//	Token   unary, TokenRequest -> TokenResponse
+//	Stream  server streaming, TokenRequest -> StreamEvent for every refreshed token or failed refresh
+//
+// Messages are JSON (see GRPCContentSubtype). A failed call returns NotFound for an unknown credential,
+// Unavailable when no token could be fetched and Internal for a panic, with the ErrorResponse in the
+// GRPCErrorTrailer trailer, signed like the HTTP responses when the broker has a Secret.
+func (b *Broker) RegisterGRPC(s grpc.ServiceRegistrar) {
+	s.RegisterService(&grpcServiceDesc, b)
+}
+
+func grpcTokenHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	var req TokenRequest
+	if err := dec(&req); err != nil {
+		return nil, err
+	}
+	b := srv.(grpcBroker)
+	if interceptor == nil {
+		return b.grpcToken(ctx, &req)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: GRPCTokenMethod}
+	return interceptor(ctx, &req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return b.grpcToken(ctx, req.(*TokenRequest))
+	})
+}
+
+func grpcStreamHandler(srv interface{}, stream grpc.ServerStream) error {
+	var req TokenRequest
+	if err := stream.RecvMsg(&req); err != nil {
+		return err
+	}
+	return srv.(grpcBroker).grpcStream(&req, stream)
+}
+
+// grpcToken answers with the current token of the credential.
+func (b *Broker) grpcToken(ctx context.Context, req *TokenRequest) (resp *TokenResponse, err error) {
+	defer b.grpcPanic(ctx, req.Name, &err)
+	defer oidcprovider.Recover("broker gRPC Token", &err)
+	cache, err := b.grpcCache(ctx, req.Name)
+	if err != nil {
+		return nil, err
+	}
+	token, expiry, err := cache.GetValidTokenExpiry(ctx)
+	if err != nil {
+		return nil, b.grpcError(ctx, codes.Unavailable, req.Name, err.Error())
+	}
+	tr := b.tokenResponse(req.Name, token, expiry)
+	return &tr, nil
+}
+
+// grpcStream sends every token of the credential until the client cancels the call.
+func (b *Broker) grpcStream(req *TokenRequest, stream grpc.ServerStream) (err error) {
+	ctx := stream.Context()
+	defer b.grpcPanic(ctx, req.Name, &err)
+	defer oidcprovider.Recover("broker gRPC Stream", &err)
+	cache, err := b.grpcCache(ctx, req.Name)
+	if err != nil {
+		return err
+	}
+	for update := range cache.Watch(ctx) {
+		var event StreamEvent
+		if update.Err != nil {
+			resp := b.errorResponse(req.Name, update.Err.Error())
+			event.Error = &resp
+		} else {
+			resp := b.tokenResponse(req.Name, update.Token, update.Expiry)
+			event.Token = &resp
+		}
+		if err := stream.SendMsg(&event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// grpcCache looks up the credential name, answering NotFound when it is unknown.
+func (b *Broker) grpcCache(ctx context.Context, name string) (*oidcprovider.TokenCache, error) {
+	cache, ok := b.Manager.Cache(name)
+	if !ok {
+		return nil, b.grpcError(ctx, codes.NotFound, name, fmt.Sprintf("unknown credential %q", name))
+	}
+	return cache, nil
+}
+
+// grpcPanic turns the PanicError recovered from a handler into an Internal status.
+func (b *Broker) grpcPanic(ctx context.Context, name string, errp *error) {
+	var pErr *oidcprovider.PanicError
+	if errors.As(*errp, &pErr) {
+		*errp = b.grpcError(ctx, codes.Internal, name, pErr.Error())
+	}
+}
+
+// grpcError returns the status of a failed call and sets the error response of credential name as trailer.
+func (b *Broker) grpcError(ctx context.Context, code codes.Code, name, msg string) error {
+	if data, err := json.Marshal(b.errorResponse(name, msg)); err == nil {
+		_ = grpc.SetTrailer(ctx, metadata.Pairs(GRPCErrorTrailer, string(data)))
+	}
+	return status.Error(code, msg)
+}
@@ -0,0 +1,88 @@
+package broker
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrInvalidSignature is returned by VerifyTokenResponse and VerifyErrorResponse when a response was not
+// signed with the secret.
+var ErrInvalidSignature = errors.New("broker response signature is invalid")
+
+// tokenSigningInput is the string signed for a token response of credential name:
+// "token.<timestamp>.<len(name)>:<name>.<len(token)>:<token>.<expiry unix>", lengths in bytes.
+// It is simple to rebuild in any language, unlike a signature over re-serialized JSON. The name binds
+// the response to the credential, so a response for one credential cannot be passed off as another's,
+// and the length prefixes keep a dot inside the name or token from shifting bytes between the fields.
+func tokenSigningInput(name string, r *TokenResponse) string {
+	return fmt.Sprintf("token.%d.%s.%s.%d", r.Timestamp, lengthPrefixed(name), lengthPrefixed(r.Token), r.Expiry.Unix())
+}
+
+// errorSigningInput is the string signed for an error response of credential name:
+// "error.<timestamp>.<len(name)>:<name>.<len(error)>:<error>". The leading kind keeps it distinct from
+// token inputs.
+func errorSigningInput(name string, r *ErrorResponse) string {
+	return fmt.Sprintf("error.%d.%s.%s", r.Timestamp, lengthPrefixed(name), lengthPrefixed(r.Error))
+}
+
+// lengthPrefixed returns s prefixed with its length in bytes, as "<len>:<s>".
+func lengthPrefixed(s string) string {
+	return fmt.Sprintf("%d:%s", len(s), s)
+}
+
+// SignTokenResponse sets Timestamp and the hex encoded HMAC-SHA256 Signature of r, the token of
+// credential name, with secret.
+func SignTokenResponse(secret []byte, name string, r *TokenResponse, now time.Time) {
+	r.Timestamp = now.Unix()
+	r.Signature = sign(secret, tokenSigningInput(name, r))
+}
+
+// VerifyTokenResponse checks that r was signed for credential name by a broker sharing secret at most
+// maxAge ago, so a captured response cannot be replayed later. maxAge must be positive.
+func VerifyTokenResponse(secret []byte, name string, r *TokenResponse, maxAge time.Duration) error {
+	return verify(secret, tokenSigningInput(name, r), r.Signature, r.Timestamp, maxAge)
+}
+
+// SignErrorResponse sets Timestamp and Signature of r, an error answered for credential name, like
+// SignTokenResponse does for tokens.
+func SignErrorResponse(secret []byte, name string, r *ErrorResponse, now time.Time) {
+	r.Timestamp = now.Unix()
+	r.Signature = sign(secret, errorSigningInput(name, r))
+}
+
+// VerifyErrorResponse checks an error response like VerifyTokenResponse checks token responses.
+func VerifyErrorResponse(secret []byte, name string, r *ErrorResponse, maxAge time.Duration) error {
+	return verify(secret, errorSigningInput(name, r), r.Signature, r.Timestamp, maxAge)
+}
+
+func sign(secret []byte, input string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(input))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func verify(secret []byte, input, signature string, timestamp int64, maxAge time.Duration) error {
+	if maxAge <= 0 {
+		return errors.New("broker response verification requires a positive maxAge")
+	}
+	if signature == "" {
+		return fmt.Errorf("%w: response is not signed", ErrInvalidSignature)
+	}
+	got, err := hex.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidSignature, err)
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(input))
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return ErrInvalidSignature
+	}
+	if age := time.Since(time.Unix(timestamp, 0)); age > maxAge || age < -maxAge {
+		return fmt.Errorf("%w: signed %s ago, allowed %s", ErrInvalidSignature, age.Round(time.Second), maxAge)
+	}
+	return nil
+}
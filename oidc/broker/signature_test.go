@@ -0,0 +1,101 @@
+package broker_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/PCS-Indonesia/pcs-oidc/oidc/broker"
+	oidcprovider "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignedTokenResponse(t *testing.T) {
+	secret := []byte("local-secret")
+	resp := broker.TokenResponse{Token: "tok", TokenType: "Bearer", Expiry: time.Now().Add(time.Hour)}
+	broker.SignTokenResponse(secret, "orders", &resp, time.Now())
+	require.NoError(t, broker.VerifyTokenResponse(secret, "orders", &resp, time.Minute))
+
+	t.Run("tampered", func(t *testing.T) {
+		spoofed := resp
+		spoofed.Token = "attacker"
+		require.ErrorIs(t, broker.VerifyTokenResponse(secret, "orders", &spoofed, time.Minute), broker.ErrInvalidSignature)
+	})
+
+	t.Run("other credential", func(t *testing.T) {
+		require.ErrorIs(t, broker.VerifyTokenResponse(secret, "billing", &resp, time.Minute), broker.ErrInvalidSignature)
+	})
+
+	t.Run("wrong secret", func(t *testing.T) {
+		require.ErrorIs(t, broker.VerifyTokenResponse([]byte("other"), "orders", &resp, time.Minute), broker.ErrInvalidSignature)
+	})
+
+	t.Run("unsigned", func(t *testing.T) {
+		unsigned := broker.TokenResponse{Token: "tok"}
+		require.ErrorIs(t, broker.VerifyTokenResponse(secret, "orders", &unsigned, time.Minute), broker.ErrInvalidSignature)
+	})
+
+	t.Run("replayed", func(t *testing.T) {
+		old := broker.TokenResponse{Token: "tok", Expiry: time.Now().Add(time.Hour)}
+		broker.SignTokenResponse(secret, "orders", &old, time.Now().Add(-time.Hour))
+		require.NoError(t, broker.VerifyTokenResponse(secret, "orders", &old, 2*time.Hour))
+		require.ErrorIs(t, broker.VerifyTokenResponse(secret, "orders", &old, time.Minute), broker.ErrInvalidSignature)
+	})
+
+	t.Run("fields cannot shift", func(t *testing.T) {
+		// Without length prefixes both would sign "token.<ts>.a.b.c.<exp>"
+		dotted := broker.TokenResponse{Token: "b.c", Expiry: resp.Expiry}
+		broker.SignTokenResponse(secret, "a", &dotted, time.Now())
+		shifted := dotted
+		shifted.Token = "c"
+		require.ErrorIs(t, broker.VerifyTokenResponse(secret, "a.b", &shifted, time.Minute), broker.ErrInvalidSignature)
+	})
+
+	t.Run("maxAge required", func(t *testing.T) {
+		require.ErrorContains(t, broker.VerifyTokenResponse(secret, "orders", &resp, 0), "positive maxAge")
+	})
+}
+
+func TestSignedErrorResponse(t *testing.T) {
+	secret := []byte("local-secret")
+	resp := broker.ErrorResponse{Error: "idp unavailable"}
+	broker.SignErrorResponse(secret, "orders", &resp, time.Now())
+	require.NoError(t, broker.VerifyErrorResponse(secret, "orders", &resp, time.Minute))
+
+	spoofed := resp
+	spoofed.Error = "attacker"
+	require.ErrorIs(t, broker.VerifyErrorResponse(secret, "orders", &spoofed, time.Minute), broker.ErrInvalidSignature)
+
+	// An error signature is no token signature
+	asToken := broker.TokenResponse{Token: resp.Error, Timestamp: resp.Timestamp, Signature: resp.Signature}
+	require.ErrorIs(t, broker.VerifyTokenResponse(secret, "orders", &asToken, time.Minute), broker.ErrInvalidSignature)
+}
+
+func TestBrokerSignsResponses(t *testing.T) {
+	secret := []byte("local-secret")
+	m := oidcprovider.NewManager()
+	require.NoError(t, m.Add(oidcprovider.ManagedCredential{Name: "orders", Cache: oidcprovider.NewTokenCache(&counterProvider{})}))
+	b := broker.New(m)
+	b.Secret = secret
+	srv := httptest.NewServer(b.Handler())
+	t.Cleanup(srv.Close)
+
+	resp, err := http.Get(srv.URL + "/token/orders")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	var tok broker.TokenResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&tok))
+	require.NotEmpty(t, tok.Signature)
+	require.NoError(t, broker.VerifyTokenResponse(secret, "orders", &tok, time.Minute))
+
+	resp, err = http.Get(srv.URL + "/token/unknown")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	var e broker.ErrorResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&e))
+	require.NoError(t, broker.VerifyErrorResponse(secret, "unknown", &e, time.Minute))
+}
@@ -0,0 +1,310 @@
+// Package brokerclient lets Go services obtain tokens from a token broker (see package broker), e.g. a
+// sidecar holding the credentials, over HTTP, a Unix socket or gRPC.
+package brokerclient
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PCS-Indonesia/pcs-oidc/oidc/broker"
+	oidcprovider "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"golang.org/x/oauth2"
+	"google.golang.org/grpc"
+)
+
+// DefaultLeeway is how long before expiry a cached token is fetched again.
+const DefaultLeeway = 30 * time.Second
+
+// DefaultMaxAge is the default maximum age of a signed response, see Client.MaxAge.
+const DefaultMaxAge = time.Minute
+
+// unixPrefix marks a Unix socket address.
+const unixPrefix = "unix://"
+
+// defaultReconnect spaces the reconnects of Run.
+var defaultReconnect = oidcprovider.RetryPolicy{BaseDelay: time.Second, MaxDelay: 30 * time.Second}
+
+// Client obtains the token of one broker credential and caches it until shortly before its expiry.
+// Client implements oidcprovider.TokenProvider and oauth2.TokenSource. Run keeps the cache updated
+// from the broker's event stream, reconnecting after failures, so token rotation costs no request.
+type Client struct {
+	// Address is the broker base URL, e.g. "http://127.0.0.1:8099", a Unix socket such as
+	// "unix:///run/pcs-oidc/broker.sock", or the gRPC service of the broker (see broker.RegisterGRPC)
+	// as "grpc://127.0.0.1:8098" or "grpc+unix:///run/pcs-oidc/broker-grpc.sock".
+	Address string
+	Name    string // credential name
+	// Secret verifies the signature of every token and error response (see broker.VerifyTokenResponse),
+	// optional.
+	Secret []byte
+	MaxAge time.Duration // maximum age of signed responses, default DefaultMaxAge
+	Leeway time.Duration // default DefaultLeeway
+	// Reconnect spaces the reconnects of Run, default 1s doubling up to 30s with jitter.
+	Reconnect *oidcprovider.RetryPolicy
+	// HTTPClient sends the requests to an http:// or https:// Address, default a client with a timeout.
+	// It cannot be combined with a Unix socket or gRPC Address, which are dialed by the client itself.
+	HTTPClient *http.Client
+	// DialOptions are added to the options of the gRPC connection, e.g. grpc.WithTransportCredentials
+	// for a broker behind TLS, default a plaintext connection.
+	DialOptions []grpc.DialOption
+
+	mu      sync.Mutex
+	current *broker.TokenResponse
+	client  *http.Client
+	baseURL string
+	conn    *grpc.ClientConn
+}
+
+// New returns a client for the credential name of the broker at address.
+func New(address, name string) *Client {
+	return &Client{Address: address, Name: name}
+}
+
+// Kind returns the provider kind reported in snapshots.
+func (c *Client) Kind() string {
+	return "broker"
+}
+
+// FetchToken returns the cached token or fetches it from the broker when it is about to expire.
+func (c *Client) FetchToken(ctx context.Context) (string, error) {
+	resp, err := c.tokenResponse(ctx)
+	if err != nil {
+		return "", err
+	}
+	oidcprovider.ReportExpiry(ctx, resp.Expiry)
+	return resp.Token, nil
+}
+
+// Token implements oauth2.TokenSource.
+func (c *Client) Token() (*oauth2.Token, error) {
+	resp, err := c.tokenResponse(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return &oauth2.Token{AccessToken: resp.Token, TokenType: resp.TokenType, Expiry: resp.Expiry}, nil
+}
+
+// tokenResponse returns the cached response while it is valid, otherwise a new one from the broker.
+func (c *Client) tokenResponse(ctx context.Context) (*broker.TokenResponse, error) {
+	c.mu.Lock()
+	current := c.current
+	c.mu.Unlock()
+	if c.valid(current) {
+		return current, nil
+	}
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	if target, ok := grpcTarget(c.Address); ok {
+		return c.grpcToken(ctx, target)
+	}
+
+	client, req, err := c.newRequest(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("broker request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read broker response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e broker.ErrorResponse
+		_ = json.Unmarshal(body, &e)
+		if len(c.Secret) > 0 {
+			if err := broker.VerifyErrorResponse(c.Secret, c.Name, &e, c.maxAge()); err != nil {
+				return nil, err
+			}
+		}
+		return nil, &oidcprovider.TokenError{
+			Provider:    "broker",
+			StatusCode:  resp.StatusCode,
+			Description: e.Error,
+			RetryAfter:  oidcprovider.ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+		}
+	}
+	var tr broker.TokenResponse
+	if err := json.Unmarshal(body, &tr); err != nil {
+		return nil, fmt.Errorf("failed to parse broker response: %w", err)
+	}
+	if err := c.store(&tr); err != nil {
+		return nil, err
+	}
+	return &tr, nil
+}
+
+// valid reports whether resp can be handed out without asking the broker.
+func (c *Client) valid(resp *broker.TokenResponse) bool {
+	if resp == nil {
+		return false
+	}
+	leeway := c.Leeway
+	if leeway <= 0 {
+		leeway = DefaultLeeway
+	}
+	return time.Now().Add(leeway).Before(resp.Expiry)
+}
+
+// store verifies resp and makes it the cached token.
+func (c *Client) store(resp *broker.TokenResponse) error {
+	if resp.Token == "" {
+		return errors.New("broker response has no token")
+	}
+	if len(c.Secret) > 0 {
+		if err := broker.VerifyTokenResponse(c.Secret, c.Name, resp, c.maxAge()); err != nil {
+			return err
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.current = resp
+	return nil
+}
+
+func (c *Client) maxAge() time.Duration {
+	if c.MaxAge <= 0 {
+		return DefaultMaxAge
+	}
+	return c.MaxAge
+}
+
+// Run subscribes to the credential's event stream and caches every token it pushes until ctx is done,
+// reconnecting with backoff when the stream breaks. It returns ctx's error.
+func (c *Client) Run(ctx context.Context) error {
+	policy := defaultReconnect
+	if c.Reconnect != nil {
+		policy = *c.Reconnect
+	}
+	failures := 0
+	for {
+		received, _ := c.stream(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if received {
+			failures = 0
+		}
+		failures++
+		wait, _ := policy.Backoff(failures, 0)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// stream reads the event stream until it breaks, received reports whether a token arrived.
+func (c *Client) stream(ctx context.Context) (received bool, err error) {
+	if err := c.validate(); err != nil {
+		return false, err
+	}
+	if target, ok := grpcTarget(c.Address); ok {
+		return c.grpcStream(ctx, target)
+	}
+	client, req, err := c.newRequest(ctx, "/stream")
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	// The stream stays open indefinitely, so the client must not carry a request timeout
+	streaming := *client
+	streaming.Timeout = 0
+	resp, err := streaming.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("broker stream returned status %d", resp.StatusCode)
+	}
+	r := bufio.NewReader(resp.Body)
+	var event, data string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return received, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		case line == "":
+			// Error events keep the cached token, FetchToken asks the broker once it expires
+			if event == "token" {
+				var tr broker.TokenResponse
+				if json.Unmarshal([]byte(data), &tr) == nil && c.store(&tr) == nil {
+					received = true
+				}
+			}
+			event, data = "", ""
+		}
+	}
+}
+
+// validate reports an incomplete configuration or an HTTPClient that would not reach Address.
+func (c *Client) validate() error {
+	if c.Address == "" || c.Name == "" {
+		return errors.New("broker client configuration is incomplete: Address and Name must be provided")
+	}
+	if c.HTTPClient == nil {
+		return nil
+	}
+	if strings.HasPrefix(c.Address, unixPrefix) {
+		return errors.New("broker client configuration is invalid: HTTPClient cannot be used with a unix:// Address, its transport would not dial the socket")
+	}
+	if _, ok := grpcTarget(c.Address); ok {
+		return errors.New("broker client configuration is invalid: HTTPClient cannot be used with a gRPC Address, use DialOptions")
+	}
+	return nil
+}
+
+// newRequest builds a GET request for the credential endpoint with suffix and the client sending it.
+func (c *Client) newRequest(ctx context.Context, suffix string) (*http.Client, *http.Request, error) {
+	client, baseURL := c.connection()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+"/token/"+url.PathEscape(c.Name)+suffix, nil)
+	return client, req, err
+}
+
+// connection returns the client talking to the broker and its base URL, dialing the Unix socket of
+// Address if needed. validate rejects an HTTPClient for a Unix socket beforehand.
+func (c *Client) connection() (*http.Client, string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.client != nil {
+		return c.client, c.baseURL
+	}
+	c.baseURL = strings.TrimRight(c.Address, "/")
+	client := c.HTTPClient
+	if path, ok := strings.CutPrefix(c.Address, unixPrefix); ok {
+		// The host of a Unix socket URL is ignored, requests are dialed to the socket
+		c.baseURL = "http://broker"
+		var d net.Dialer
+		client = &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return d.DialContext(ctx, "unix", path)
+			},
+		}, Timeout: 30 * time.Second}
+	}
+	if client == nil {
+		client = oidcprovider.NewHTTPClient("broker", false)
+	}
+	c.client = client
+	return client, c.baseURL
+}
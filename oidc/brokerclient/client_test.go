@@ -0,0 +1,275 @@
+package brokerclient_test
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/PCS-Indonesia/pcs-oidc/oidc/broker"
+	"github.com/PCS-Indonesia/pcs-oidc/oidc/brokerclient"
+	oidcprovider "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+	"google.golang.org/grpc"
+)
+
+// counterProvider returns a new unsigned JWT valid for lifetime on every fetch
+type counterProvider struct {
+	lifetime time.Duration
+	calls    atomic.Int32
+}
+
+func (p *counterProvider) FetchToken(context.Context) (string, error) {
+	n := p.calls.Add(1)
+	payload := fmt.Sprintf(`{"exp":%d,"n":%d}`, time.Now().Add(p.lifetime).Unix(), n)
+	return "eyJhbGciOiJub25lIn0." + base64.RawURLEncoding.EncodeToString([]byte(payload)) + ".sig", nil
+}
+
+// newBroker returns a broker serving the credential "orders" and its provider
+func newBroker(t *testing.T, secret []byte) (*broker.Broker, *counterProvider) {
+	t.Helper()
+	provider := &counterProvider{lifetime: time.Hour}
+	m := oidcprovider.NewManager()
+	require.NoError(t, m.Add(oidcprovider.ManagedCredential{Name: "orders", Cache: oidcprovider.NewTokenCache(provider)}))
+	b := broker.New(m)
+	b.Secret = secret
+	return b, provider
+}
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("token is cached", func(t *testing.T) {
+		b, _ := newBroker(t, nil)
+		var requests atomic.Int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests.Add(1)
+			b.Handler().ServeHTTP(w, r)
+		}))
+		defer srv.Close()
+
+		c := brokerclient.New(srv.URL, "orders")
+		first, err := c.FetchToken(ctx)
+		require.NoError(t, err)
+		second, err := c.FetchToken(ctx)
+		require.NoError(t, err)
+		require.Equal(t, first, second)
+		require.EqualValues(t, 1, requests.Load())
+
+		var ts oauth2.TokenSource = c
+		tok, err := ts.Token()
+		require.NoError(t, err)
+		require.Equal(t, first, tok.AccessToken)
+		require.WithinDuration(t, time.Now().Add(time.Hour), tok.Expiry, 5*time.Second)
+	})
+
+	t.Run("unix socket", func(t *testing.T) {
+		b, _ := newBroker(t, nil)
+		dir, err := os.MkdirTemp("", "broker")
+		require.NoError(t, err)
+		defer os.RemoveAll(dir)
+		socket := filepath.Join(dir, "broker.sock")
+		ln, err := net.Listen("unix", socket)
+		require.NoError(t, err)
+		srv := &http.Server{Handler: b.Handler()}
+		go func() { _ = srv.Serve(ln) }()
+		defer srv.Close()
+
+		token, err := brokerclient.New("unix://"+socket, "orders").FetchToken(ctx)
+		require.NoError(t, err)
+		require.NotEmpty(t, token)
+	})
+
+	t.Run("signature is verified", func(t *testing.T) {
+		b, _ := newBroker(t, []byte("local-secret"))
+		srv := httptest.NewServer(b.Handler())
+		defer srv.Close()
+
+		c := brokerclient.New(srv.URL, "orders")
+		c.Secret = []byte("local-secret")
+		_, err := c.FetchToken(ctx)
+		require.NoError(t, err)
+
+		spoofed := brokerclient.New(srv.URL, "orders")
+		spoofed.Secret = []byte("other-secret")
+		_, err = spoofed.FetchToken(ctx)
+		require.ErrorIs(t, err, broker.ErrInvalidSignature)
+
+		// Signed errors are verified too
+		unknown := brokerclient.New(srv.URL, "billing")
+		unknown.Secret = []byte("local-secret")
+		_, err = unknown.FetchToken(ctx)
+		var tErr *oidcprovider.TokenError
+		require.ErrorAs(t, err, &tErr)
+		require.Equal(t, http.StatusNotFound, tErr.StatusCode)
+		unknown = brokerclient.New(srv.URL, "billing")
+		unknown.Secret = []byte("other-secret")
+		_, err = unknown.FetchToken(ctx)
+		require.ErrorIs(t, err, broker.ErrInvalidSignature)
+	})
+
+	t.Run("unknown credential", func(t *testing.T) {
+		b, _ := newBroker(t, nil)
+		srv := httptest.NewServer(b.Handler())
+		defer srv.Close()
+
+		_, err := brokerclient.New(srv.URL, "billing").FetchToken(ctx)
+		var tErr *oidcprovider.TokenError
+		require.ErrorAs(t, err, &tErr)
+		require.Equal(t, http.StatusNotFound, tErr.StatusCode)
+		require.Contains(t, tErr.Description, "billing")
+	})
+
+	t.Run("run follows the stream and reconnects", func(t *testing.T) {
+		b, _ := newBroker(t, nil)
+		var streams, requests atomic.Int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/token/orders/stream" {
+				requests.Add(1)
+				b.Handler().ServeHTTP(w, r)
+				return
+			}
+			// Every stream breaks after a short while, as a restarting broker would
+			streams.Add(1)
+			ctx, cancel := context.WithTimeout(r.Context(), 100*time.Millisecond)
+			defer cancel()
+			b.Handler().ServeHTTP(w, r.WithContext(ctx))
+		}))
+		defer srv.Close()
+
+		c := brokerclient.New(srv.URL, "orders")
+		c.Reconnect = &oidcprovider.RetryPolicy{BaseDelay: 10 * time.Millisecond, MaxDelay: 20 * time.Millisecond}
+		runCtx, cancel := context.WithCancel(ctx)
+		done := make(chan error, 1)
+		go func() { done <- c.Run(runCtx) }()
+
+		require.Eventually(t, func() bool { return streams.Load() >= 3 }, 5*time.Second, 10*time.Millisecond)
+		_, err := c.FetchToken(ctx)
+		require.NoError(t, err)
+		require.Zero(t, requests.Load(), "the streamed token is served from the local cache")
+
+		cancel()
+		require.ErrorIs(t, <-done, context.Canceled)
+	})
+
+	t.Run("incomplete configuration", func(t *testing.T) {
+		_, err := brokerclient.New("", "orders").FetchToken(ctx)
+		require.ErrorContains(t, err, "Address and Name")
+	})
+
+	t.Run("custom HTTPClient with a unix socket is rejected", func(t *testing.T) {
+		c := brokerclient.New("unix:///run/pcs-oidc/broker.sock", "orders")
+		c.HTTPClient = &http.Client{}
+		_, err := c.FetchToken(ctx)
+		require.ErrorContains(t, err, "unix://")
+
+		c = brokerclient.New("grpc://127.0.0.1:8098", "orders")
+		c.HTTPClient = &http.Client{}
+		_, err = c.FetchToken(ctx)
+		require.ErrorContains(t, err, "gRPC")
+	})
+}
+
+// serveGRPC serves the gRPC service of b on network and returns the client address
+func serveGRPC(t *testing.T, b *broker.Broker, network string, opts ...grpc.ServerOption) string {
+	t.Helper()
+	var lis net.Listener
+	var err error
+	var address string
+	if network == "unix" {
+		socket := filepath.Join(t.TempDir(), "broker.sock")
+		lis, err = net.Listen("unix", socket)
+		address = "grpc+unix://" + socket
+	} else {
+		lis, err = net.Listen("tcp", "127.0.0.1:0")
+		if err == nil {
+			address = "grpc://" + lis.Addr().String()
+		}
+	}
+	require.NoError(t, err)
+	srv := grpc.NewServer(opts...)
+	b.RegisterGRPC(srv)
+	go func() { _ = srv.Serve(lis) }()
+	t.Cleanup(srv.Stop)
+	return address
+}
+
+func TestClientGRPC(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("token is cached", func(t *testing.T) {
+		b, provider := newBroker(t, []byte("local-secret"))
+		c := brokerclient.New(serveGRPC(t, b, "tcp"), "orders")
+		c.Secret = []byte("local-secret")
+		defer c.Close()
+
+		first, err := c.FetchToken(ctx)
+		require.NoError(t, err)
+		second, err := c.FetchToken(ctx)
+		require.NoError(t, err)
+		require.Equal(t, first, second)
+		require.EqualValues(t, 1, provider.calls.Load())
+	})
+
+	t.Run("unix socket", func(t *testing.T) {
+		b, _ := newBroker(t, nil)
+		c := brokerclient.New(serveGRPC(t, b, "unix"), "orders")
+		defer c.Close()
+		token, err := c.FetchToken(ctx)
+		require.NoError(t, err)
+		require.NotEmpty(t, token)
+	})
+
+	t.Run("signed errors are verified", func(t *testing.T) {
+		b, _ := newBroker(t, []byte("local-secret"))
+		address := serveGRPC(t, b, "tcp")
+
+		unknown := brokerclient.New(address, "billing")
+		unknown.Secret = []byte("local-secret")
+		defer unknown.Close()
+		_, err := unknown.FetchToken(ctx)
+		var tErr *oidcprovider.TokenError
+		require.ErrorAs(t, err, &tErr)
+		require.Equal(t, http.StatusNotFound, tErr.StatusCode)
+		require.Contains(t, tErr.Description, "billing")
+
+		spoofed := brokerclient.New(address, "billing")
+		spoofed.Secret = []byte("other-secret")
+		defer spoofed.Close()
+		_, err = spoofed.FetchToken(ctx)
+		require.ErrorIs(t, err, broker.ErrInvalidSignature)
+	})
+
+	t.Run("run follows the stream", func(t *testing.T) {
+		b, provider := newBroker(t, nil)
+		var requests atomic.Int32
+		count := grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			requests.Add(1)
+			return handler(ctx, req)
+		})
+		c := brokerclient.New(serveGRPC(t, b, "tcp", count), "orders")
+		defer c.Close()
+		runCtx, cancel := context.WithCancel(ctx)
+		done := make(chan error, 1)
+		go func() { done <- c.Run(runCtx) }()
+
+		require.Eventually(t, func() bool { return provider.calls.Load() >= 1 }, 5*time.Second, 10*time.Millisecond)
+		// The stream delivers the token right after the fetch, give it a moment to be cached
+		time.Sleep(100 * time.Millisecond)
+		_, err := c.FetchToken(ctx)
+		require.NoError(t, err)
+		require.Zero(t, requests.Load(), "the streamed token is served from the local cache")
+
+		cancel()
+		require.ErrorIs(t, <-done, context.Canceled)
+	})
+}
@@ -0,0 +1,140 @@
+package brokerclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/PCS-Indonesia/pcs-oidc/oidc/broker"
+	oidcprovider "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Prefixes of the addresses of a broker's gRPC service.
+const (
+	grpcPrefix     = "grpc://"
+	grpcUnixPrefix = "grpc+unix://"
+)
+
+var grpcStreamDesc = grpc.StreamDesc{StreamName: "Stream", ServerStreams: true}
+
+// grpcTarget returns the gRPC target of address, ok is false for an HTTP or Unix socket address.
+func grpcTarget(address string) (target string, ok bool) {
+	if path, ok := strings.CutPrefix(address, grpcUnixPrefix); ok {
+		return "unix://" + path, true
+	}
+	if host, ok := strings.CutPrefix(address, grpcPrefix); ok {
+		return strings.TrimRight(host, "/"), true
+	}
+	return "", false
+}
+
+// grpcConn returns the connection to the gRPC service at target, created on first use.
+func (c *Client) grpcConn(target string) (*grpc.ClientConn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != nil {
+		return c.conn, nil
+	}
+	// Later options override the plaintext default, e.g. transport credentials
+	opts := append([]grpc.DialOption{
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.CallContentSubtype(broker.GRPCContentSubtype)),
+	}, c.DialOptions...)
+	conn, err := grpc.NewClient(target, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create broker gRPC connection: %w", err)
+	}
+	c.conn = conn
+	return conn, nil
+}
+
+// grpcToken asks the gRPC service at target for the credential's token and caches it.
+func (c *Client) grpcToken(ctx context.Context, target string) (*broker.TokenResponse, error) {
+	conn, err := c.grpcConn(target)
+	if err != nil {
+		return nil, err
+	}
+	var tr broker.TokenResponse
+	var trailer metadata.MD
+	if err := conn.Invoke(ctx, broker.GRPCTokenMethod, &broker.TokenRequest{Name: c.Name}, &tr, grpc.Trailer(&trailer)); err != nil {
+		return nil, c.grpcError(err, trailer)
+	}
+	if err := c.store(&tr); err != nil {
+		return nil, err
+	}
+	return &tr, nil
+}
+
+// grpcStream reads the gRPC stream at target until it breaks, received reports whether a token arrived.
+func (c *Client) grpcStream(ctx context.Context, target string) (received bool, err error) {
+	conn, err := c.grpcConn(target)
+	if err != nil {
+		return false, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := conn.NewStream(ctx, &grpcStreamDesc, broker.GRPCStreamMethod)
+	if err != nil {
+		return false, err
+	}
+	if err := stream.SendMsg(&broker.TokenRequest{Name: c.Name}); err != nil {
+		return false, err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return false, err
+	}
+	for {
+		var event broker.StreamEvent
+		if err := stream.RecvMsg(&event); err != nil {
+			return received, c.grpcError(err, stream.Trailer())
+		}
+		// Error events keep the cached token, FetchToken asks the broker once it expires
+		if event.Token != nil && c.store(event.Token) == nil {
+			received = true
+		}
+	}
+}
+
+// grpcError converts the status of a failed call into a TokenError carrying the broker's error response,
+// with the status code the HTTP endpoints answer, or wraps err when the broker sent no error response.
+func (c *Client) grpcError(err error, trailer metadata.MD) error {
+	values := trailer.Get(broker.GRPCErrorTrailer)
+	if len(values) == 0 {
+		return fmt.Errorf("broker request failed: %w", err)
+	}
+	var e broker.ErrorResponse
+	_ = json.Unmarshal([]byte(values[0]), &e)
+	if len(c.Secret) > 0 {
+		if err := broker.VerifyErrorResponse(c.Secret, c.Name, &e, c.maxAge()); err != nil {
+			return err
+		}
+	}
+	statusCode := http.StatusInternalServerError
+	switch status.Code(err) {
+	case codes.NotFound:
+		statusCode = http.StatusNotFound
+	case codes.Unavailable:
+		statusCode = http.StatusBadGateway
+	}
+	return &oidcprovider.TokenError{Provider: "broker", StatusCode: statusCode, Description: e.Error, Err: err}
+}
+
+// Close closes the gRPC connection of the client, if any. The client dials again when it is used later.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	conn := c.conn
+	c.conn = nil
+	c.mu.Unlock()
+	if conn == nil {
+		return nil
+	}
+	return conn.Close()
+}
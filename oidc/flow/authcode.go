@@ -0,0 +1,244 @@
+// Package flow runs interactive OAuth 2.0 flows for developer tooling that acts as a human user,
+// e.g. a CLI calling internal APIs with the developer's own Keycloak identity.
+package flow
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html"
+	"net"
+	"net/http"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	oidcprovider "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"golang.org/x/oauth2"
+)
+
+// DefaultLoginTimeout is how long AuthCodeFlow waits for the user to finish logging in.
+const DefaultLoginTimeout = 5 * time.Minute
+
+// DefaultCallbackPath is the path of the loopback redirect URI.
+const DefaultCallbackPath = "/callback"
+
+// ErrLoginTimeout is returned when the user did not finish logging in within the timeout.
+var ErrLoginTimeout = errors.New("login was not completed in time")
+
+// KeycloakEndpoint returns the authorization, device authorization and token endpoints of a Keycloak realm.
+func KeycloakEndpoint(realmURL string) oauth2.Endpoint {
+	realmURL = strings.TrimRight(realmURL, "/")
+	return oauth2.Endpoint{
+		AuthURL:       realmURL + "/protocol/openid-connect/auth",
+		DeviceAuthURL: realmURL + "/protocol/openid-connect/auth/device",
+		TokenURL:      realmURL + "/protocol/openid-connect/token",
+	}
+}
+
+// AuthCodeFlow obtains tokens for a human user with the authorization code flow and PKCE (RFC 7636).
+// It opens the system browser at the IdP's login page and receives the code on a loopback HTTP
+// server (RFC 8252), so the client must allow a redirect URI like http://127.0.0.1:*/callback.
+//
+// AuthCodeFlow implements oidcprovider.TokenProvider: the first FetchToken runs the interactive
+// login, later calls renew with the refresh token and only ask the user again when that fails.
+type AuthCodeFlow struct {
+	Endpoint     oauth2.Endpoint // e.g. KeycloakEndpoint(realmURL)
+	ClientID     string
+	ClientSecret string   // empty for public clients
+	Scopes       []string // "openid" is always requested
+
+	// Token selects the token returned by FetchToken, default oidcprovider.TokenKindAccess.
+	Token oidcprovider.TokenKind
+	// ListenAddr is the loopback address of the callback server, default "127.0.0.1:0" (any free port).
+	ListenAddr string
+	// CallbackPath is the path of the redirect URI, default DefaultCallbackPath.
+	CallbackPath string
+	// OpenBrowser opens the login URL, default the system browser. Tools running on a remote host
+	// can print the URL instead.
+	OpenBrowser func(url string) error
+	// Timeout bounds the interactive login, default DefaultLoginTimeout.
+	Timeout  time.Duration
+	Insecure bool // skip TLS verification (development only)
+
+	session session
+}
+
+// Kind returns the provider kind reported in snapshots.
+func (f *AuthCodeFlow) Kind() string {
+	return "auth-code"
+}
+
+// Capabilities reports what the flow supports.
+func (f *AuthCodeFlow) Capabilities() oidcprovider.Capabilities {
+	return oidcprovider.Capabilities{AccessToken: true, IDToken: true, RefreshToken: true}
+}
+
+// FetchToken returns the selected token, logging the user in when no refresh token is available.
+func (f *AuthCodeFlow) FetchToken(ctx context.Context) (string, error) {
+	token, err := f.Tokens(ctx)
+	if err != nil {
+		return "", err
+	}
+	return selectToken(ctx, token, f.Token)
+}
+
+// Tokens returns a valid token response, renewing the last login or logging the user in again.
+func (f *AuthCodeFlow) Tokens(ctx context.Context) (*oauth2.Token, error) {
+	return f.session.tokens(ctx, false, f.login)
+}
+
+// Login runs the interactive login and returns its token response.
+func (f *AuthCodeFlow) Login(ctx context.Context) (*oauth2.Token, error) {
+	return f.session.tokens(ctx, true, f.login)
+}
+
+// callbackResult is the outcome of the redirect to the loopback server.
+type callbackResult struct {
+	code string
+	err  error
+}
+
+// login runs the browser flow and returns its token and the source renewing it.
+func (f *AuthCodeFlow) login(ctx context.Context) (*oauth2.Token, oauth2.TokenSource, error) {
+	if f.Endpoint.AuthURL == "" || f.Endpoint.TokenURL == "" || f.ClientID == "" {
+		return nil, nil, errors.New("auth code flow configuration is incomplete: Endpoint and ClientID must be provided")
+	}
+	timeout := f.Timeout
+	if timeout <= 0 {
+		timeout = DefaultLoginTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	addr := f.ListenAddr
+	if addr == "" {
+		addr = "127.0.0.1:0"
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to start callback server: %w", err)
+	}
+	path := f.CallbackPath
+	if path == "" {
+		path = DefaultCallbackPath
+	}
+	redirectURL := "http://" + ln.Addr().String() + path
+	conf := f.config(redirectURL)
+
+	state, err := randomString()
+	if err != nil {
+		ln.Close()
+		return nil, nil, err
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	results := make(chan callbackResult, 1)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+path, func(w http.ResponseWriter, r *http.Request) {
+		res := callbackFrom(r, state)
+		if res.err != nil {
+			http.Error(w, "Login failed: "+html.EscapeString(res.err.Error()), http.StatusBadRequest)
+		} else {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			_, _ = w.Write([]byte("<html><body>Login complete, you can close this window.</body></html>"))
+		}
+		select {
+		case results <- res:
+		default:
+		}
+	})
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() { _ = srv.Serve(ln) }()
+	defer srv.Close()
+
+	authURL := conf.AuthCodeURL(state, oauth2.S256ChallengeOption(verifier))
+	open := f.OpenBrowser
+	if open == nil {
+		open = openBrowser
+	}
+	if err := open(authURL); err != nil {
+		return nil, nil, fmt.Errorf("failed to open browser, visit %s: %w", authURL, err)
+	}
+
+	var res callbackResult
+	select {
+	case res = <-results:
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, nil, ErrLoginTimeout
+		}
+		return nil, nil, ctx.Err()
+	}
+	if res.err != nil {
+		return nil, nil, res.err
+	}
+
+	exchangeCtx := context.WithValue(ctx, oauth2.HTTPClient, f.httpClient())
+	token, err := conf.Exchange(exchangeCtx, res.code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to exchange authorization code: %w", err)
+	}
+	return token, renewSource(exchangeCtx, conf, token), nil
+}
+
+// callbackFrom validates the redirect and extracts the authorization code.
+func callbackFrom(r *http.Request, state string) callbackResult {
+	q := r.URL.Query()
+	if q.Get("state") != state {
+		return callbackResult{err: errors.New("state mismatch, the login may have been forged")}
+	}
+	if e := q.Get("error"); e != "" {
+		if desc := q.Get("error_description"); desc != "" {
+			e += ": " + desc
+		}
+		return callbackResult{err: fmt.Errorf("authorization failed: %s", e)}
+	}
+	code := q.Get("code")
+	if code == "" {
+		return callbackResult{err: errors.New("authorization response has no code")}
+	}
+	return callbackResult{code: code}
+}
+
+// config returns the OAuth2 client configuration with redirectURL.
+func (f *AuthCodeFlow) config(redirectURL string) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     f.ClientID,
+		ClientSecret: f.ClientSecret,
+		Endpoint:     endpointFor(f.Endpoint, f.ClientSecret),
+		RedirectURL:  redirectURL,
+		Scopes:       withOpenID(f.Scopes),
+	}
+}
+
+func (f *AuthCodeFlow) httpClient() *http.Client {
+	return oidcprovider.NewHTTPClient("auth-code", f.Insecure)
+}
+
+// randomString returns 32 random bytes, base64url encoded.
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// openBrowser opens url with the system's default browser.
+func openBrowser(url string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", url)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+	return cmd.Start()
+}
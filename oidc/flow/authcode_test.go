@@ -0,0 +1,211 @@
+package flow_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/PCS-Indonesia/pcs-oidc/oidc/flow"
+	oidcprovider "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeIdP approves every login and checks the PKCE verifier of the code exchange
+type fakeIdP struct {
+	mu         sync.Mutex
+	challenges map[string]string // code -> code_challenge
+	logins     atomic.Int32
+	refreshes  atomic.Int32
+	lifetime   time.Duration
+	denyLogin  bool
+	failRenew  atomic.Bool
+}
+
+func newFakeIdP(t *testing.T) (*fakeIdP, string) {
+	t.Helper()
+	idp := &fakeIdP{challenges: map[string]string{}, lifetime: time.Hour}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/realms/dev/protocol/openid-connect/auth", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		redirect, _ := url.Parse(q.Get("redirect_uri"))
+		back := url.Values{"state": {q.Get("state")}}
+		if idp.denyLogin {
+			back.Set("error", "access_denied")
+		} else {
+			require.Equal(t, "S256", q.Get("code_challenge_method"))
+			code := "code-" + q.Get("state")[:8]
+			idp.mu.Lock()
+			idp.challenges[code] = q.Get("code_challenge")
+			idp.mu.Unlock()
+			back.Set("code", code)
+		}
+		redirect.RawQuery = back.Encode()
+		http.Redirect(w, r, redirect.String(), http.StatusFound)
+	})
+	mux.HandleFunc("/realms/dev/protocol/openid-connect/token", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		switch r.Form.Get("grant_type") {
+		case "authorization_code":
+			idp.mu.Lock()
+			challenge := idp.challenges[r.Form.Get("code")]
+			idp.mu.Unlock()
+			sum := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+			if challenge == "" || base64.RawURLEncoding.EncodeToString(sum[:]) != challenge {
+				writeError(w, "invalid_grant")
+				return
+			}
+			idp.logins.Add(1)
+		case "refresh_token":
+			if idp.failRenew.Load() {
+				writeError(w, "invalid_grant")
+				return
+			}
+			idp.refreshes.Add(1)
+		default:
+			writeError(w, "unsupported_grant_type")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token":  "access-" + r.Form.Get("grant_type"),
+			"id_token":      "id-token",
+			"refresh_token": "refresh",
+			"token_type":    "Bearer",
+			"expires_in":    int(idp.lifetime.Seconds()),
+		})
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return idp, srv.URL + "/realms/dev"
+}
+
+func writeError(w http.ResponseWriter, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": code})
+}
+
+// browser follows the login URL like a user approving the consent screen
+func browser(loginURL string) error {
+	go func() {
+		resp, err := http.Get(loginURL)
+		if err == nil {
+			resp.Body.Close()
+		}
+	}()
+	return nil
+}
+
+func TestAuthCodeFlow(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("login, renew and login again", func(t *testing.T) {
+		idp, realmURL := newFakeIdP(t)
+		idp.lifetime = 0
+		f := &flow.AuthCodeFlow{
+			Endpoint:    flow.KeycloakEndpoint(realmURL),
+			ClientID:    "dev-cli",
+			OpenBrowser: browser,
+		}
+		token, err := f.FetchToken(ctx)
+		require.NoError(t, err)
+		require.Equal(t, "access-authorization_code", token)
+		require.EqualValues(t, 1, idp.logins.Load())
+
+		// A token without expires_in is reused without asking the user again
+		token, err = f.FetchToken(ctx)
+		require.NoError(t, err)
+		require.Equal(t, "access-authorization_code", token)
+
+		idp.failRenew.Store(true)
+		_, err = f.Login(ctx)
+		require.NoError(t, err)
+		require.EqualValues(t, 2, idp.logins.Load())
+	})
+
+	t.Run("refresh token renews expired tokens", func(t *testing.T) {
+		idp, realmURL := newFakeIdP(t)
+		idp.lifetime = time.Second
+		f := &flow.AuthCodeFlow{
+			Endpoint:    flow.KeycloakEndpoint(realmURL),
+			ClientID:    "dev-cli",
+			Token:       oidcprovider.TokenKindID,
+			OpenBrowser: browser,
+		}
+		token, err := f.FetchToken(ctx)
+		require.NoError(t, err)
+		require.Equal(t, "id-token", token)
+
+		// oauth2 renews tokens expiring within 10 seconds
+		tokens, err := f.Tokens(ctx)
+		require.NoError(t, err)
+		require.Equal(t, "access-refresh_token", tokens.AccessToken)
+		require.EqualValues(t, 1, idp.logins.Load())
+		require.EqualValues(t, 1, idp.refreshes.Load())
+
+		// A rejected refresh token starts a new login
+		idp.failRenew.Store(true)
+		tokens, err = f.Tokens(ctx)
+		require.NoError(t, err)
+		require.Equal(t, "access-authorization_code", tokens.AccessToken)
+		require.EqualValues(t, 2, idp.logins.Load())
+	})
+
+	t.Run("through a TokenCache", func(t *testing.T) {
+		idp, realmURL := newFakeIdP(t)
+		f := &flow.AuthCodeFlow{Endpoint: flow.KeycloakEndpoint(realmURL), ClientID: "dev-cli", OpenBrowser: browser}
+		cache := oidcprovider.NewTokenCache(f)
+		for i := 0; i < 2; i++ {
+			token, err := cache.GetValidToken(ctx)
+			require.NoError(t, err)
+			require.Equal(t, "access-authorization_code", token)
+		}
+		require.EqualValues(t, 1, idp.logins.Load())
+		require.True(t, cache.Capabilities().RefreshToken)
+	})
+
+	t.Run("denied login", func(t *testing.T) {
+		idp, realmURL := newFakeIdP(t)
+		idp.denyLogin = true
+		f := &flow.AuthCodeFlow{Endpoint: flow.KeycloakEndpoint(realmURL), ClientID: "dev-cli", OpenBrowser: browser}
+		_, err := f.FetchToken(ctx)
+		require.ErrorContains(t, err, "access_denied")
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		_, realmURL := newFakeIdP(t)
+		f := &flow.AuthCodeFlow{
+			Endpoint:    flow.KeycloakEndpoint(realmURL),
+			ClientID:    "dev-cli",
+			OpenBrowser: func(string) error { return nil }, // the user never logs in
+			Timeout:     50 * time.Millisecond,
+		}
+		_, err := f.FetchToken(ctx)
+		require.ErrorIs(t, err, flow.ErrLoginTimeout)
+	})
+
+	t.Run("forged callback", func(t *testing.T) {
+		_, realmURL := newFakeIdP(t)
+		f := &flow.AuthCodeFlow{
+			Endpoint: flow.KeycloakEndpoint(realmURL),
+			ClientID: "dev-cli",
+			OpenBrowser: func(loginURL string) error {
+				u, _ := url.Parse(loginURL)
+				callback, _ := url.Parse(u.Query().Get("redirect_uri"))
+				callback.RawQuery = url.Values{"state": {"forged"}, "code": {"stolen"}}.Encode()
+				return browser(callback.String())
+			},
+		}
+		_, err := f.FetchToken(ctx)
+		require.ErrorContains(t, err, "state mismatch")
+	})
+}
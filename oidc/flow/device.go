@@ -0,0 +1,139 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	oidcprovider "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"golang.org/x/oauth2"
+)
+
+var (
+	// ErrAccessDenied is returned when the user declined the device authorization.
+	ErrAccessDenied = errors.New("the user denied the authorization request")
+	// ErrDeviceCodeExpired is returned when the user did not approve the device before the code expired.
+	ErrDeviceCodeExpired = errors.New("the device code expired before the user approved it")
+)
+
+// DeviceCode is what the user needs to approve a device login, shown by DeviceFlow.OnCode.
+type DeviceCode struct {
+	UserCode                string
+	VerificationURI         string
+	VerificationURIComplete string    // optional, includes the user code, e.g. for a QR code
+	Expiry                  time.Time // when the code expires
+}
+
+// DeviceFlow obtains tokens for a human user with the device authorization grant (RFC 8628), for
+// headless environments without a browser (SSH sessions, containers). The user opens the verification
+// URI on another device and enters the user code while the flow polls the token endpoint, honouring the
+// server's interval and slow_down responses.
+//
+// DeviceFlow implements oidcprovider.TokenProvider: the first FetchToken starts the device login,
+// later calls renew with the refresh token and only ask the user again when that fails.
+type DeviceFlow struct {
+	Endpoint     oauth2.Endpoint // needs DeviceAuthURL, e.g. KeycloakEndpoint(realmURL)
+	ClientID     string
+	ClientSecret string   // empty for public clients
+	Scopes       []string // "openid" is always requested
+
+	// Token selects the token returned by FetchToken, default oidcprovider.TokenKindAccess.
+	Token oidcprovider.TokenKind
+	// OnCode shows the user code and verification URI to the user, default a message on stderr.
+	OnCode   func(DeviceCode)
+	Insecure bool // skip TLS verification (development only)
+
+	session session
+}
+
+// Kind returns the provider kind reported in snapshots.
+func (f *DeviceFlow) Kind() string {
+	return "device-code"
+}
+
+// Capabilities reports what the flow supports.
+func (f *DeviceFlow) Capabilities() oidcprovider.Capabilities {
+	return oidcprovider.Capabilities{AccessToken: true, IDToken: true, RefreshToken: true}
+}
+
+// FetchToken returns the selected token, starting a device login when no refresh token is available.
+func (f *DeviceFlow) FetchToken(ctx context.Context) (string, error) {
+	token, err := f.Tokens(ctx)
+	if err != nil {
+		return "", err
+	}
+	return selectToken(ctx, token, f.Token)
+}
+
+// Tokens returns a valid token response, renewing the last login or starting a device login.
+func (f *DeviceFlow) Tokens(ctx context.Context) (*oauth2.Token, error) {
+	return f.session.tokens(ctx, false, f.login)
+}
+
+// Login starts a device login and returns its token response.
+func (f *DeviceFlow) Login(ctx context.Context) (*oauth2.Token, error) {
+	return f.session.tokens(ctx, true, f.login)
+}
+
+// login requests a device code, shows it and polls until the user approved, denied or the code expired.
+func (f *DeviceFlow) login(ctx context.Context) (*oauth2.Token, oauth2.TokenSource, error) {
+	if f.Endpoint.DeviceAuthURL == "" || f.Endpoint.TokenURL == "" || f.ClientID == "" {
+		return nil, nil, errors.New("device flow configuration is incomplete: Endpoint with DeviceAuthURL and ClientID must be provided")
+	}
+	conf := &oauth2.Config{
+		ClientID:     f.ClientID,
+		ClientSecret: f.ClientSecret,
+		Endpoint:     endpointFor(f.Endpoint, f.ClientSecret),
+		Scopes:       withOpenID(f.Scopes),
+	}
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, f.httpClient())
+	auth, err := conf.DeviceAuth(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to start device authorization: %w", err)
+	}
+	code := DeviceCode{
+		UserCode:                auth.UserCode,
+		VerificationURI:         auth.VerificationURI,
+		VerificationURIComplete: auth.VerificationURIComplete,
+		Expiry:                  auth.Expiry,
+	}
+	if f.OnCode != nil {
+		f.OnCode(code)
+	} else {
+		fmt.Fprintf(os.Stderr, "To sign in, open %s and enter the code %s\n", code.VerificationURI, code.UserCode)
+	}
+
+	// DeviceAccessToken waits the server's interval between polls (5s without one), keeps polling on
+	// authorization_pending and adds 5s to the interval on every slow_down
+	token, err := conf.DeviceAccessToken(ctx, auth)
+	if err != nil {
+		return nil, nil, deviceError(ctx, auth, err)
+	}
+	return token, renewSource(ctx, conf, token), nil
+}
+
+// deviceError maps the final polling error to ErrAccessDenied or ErrDeviceCodeExpired where it applies.
+func deviceError(ctx context.Context, auth *oauth2.DeviceAuthResponse, err error) error {
+	var rErr *oauth2.RetrieveError
+	if errors.As(err, &rErr) {
+		switch rErr.ErrorCode {
+		case "access_denied":
+			return fmt.Errorf("%w: %w", ErrAccessDenied, err)
+		case "expired_token":
+			return fmt.Errorf("%w: %w", ErrDeviceCodeExpired, err)
+		}
+	}
+	// Polling stops at the code's expiry with a deadline error while the caller's context is still alive
+	if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil && !auth.Expiry.IsZero() && !time.Now().Before(auth.Expiry) {
+		return fmt.Errorf("%w: %w", ErrDeviceCodeExpired, err)
+	}
+	return fmt.Errorf("failed to complete device authorization: %w", err)
+}
+
+func (f *DeviceFlow) httpClient() *http.Client {
+	return oidcprovider.NewHTTPClient("device-code", f.Insecure)
+}
@@ -0,0 +1,149 @@
+package flow_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/PCS-Indonesia/pcs-oidc/oidc/flow"
+	oidcprovider "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDeviceIdP answers device token polls with the given errors in order, then issues a token
+type fakeDeviceIdP struct {
+	mu      sync.Mutex
+	answers []string
+	polls   []time.Time
+}
+
+func newFakeDeviceIdP(t *testing.T, answers ...string) (*fakeDeviceIdP, string) {
+	t.Helper()
+	idp := &fakeDeviceIdP{answers: answers}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/realms/dev/protocol/openid-connect/auth/device", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		require.Equal(t, "dev-cli", r.Form.Get("client_id"))
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"device_code":               "device-123",
+			"user_code":                 "ABCD-EFGH",
+			"verification_uri":          "https://kc.example.com/realms/dev/device",
+			"verification_uri_complete": "https://kc.example.com/realms/dev/device?user_code=ABCD-EFGH",
+			"expires_in":                600,
+			"interval":                  1,
+		})
+	})
+	mux.HandleFunc("/realms/dev/protocol/openid-connect/token", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		if r.Form.Get("grant_type") == "refresh_token" {
+			writeError(w, "invalid_grant")
+			return
+		}
+		require.Equal(t, "urn:ietf:params:oauth:grant-type:device_code", r.Form.Get("grant_type"))
+		require.Equal(t, "device-123", r.Form.Get("device_code"))
+		idp.mu.Lock()
+		idp.polls = append(idp.polls, time.Now())
+		var answer string
+		if len(idp.answers) > 0 {
+			answer, idp.answers = idp.answers[0], idp.answers[1:]
+		}
+		idp.mu.Unlock()
+		if answer != "" {
+			writeError(w, answer)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token":  "device-access",
+			"id_token":      "device-id",
+			"refresh_token": "refresh",
+			"token_type":    "Bearer",
+			"expires_in":    3600,
+		})
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return idp, srv.URL + "/realms/dev"
+}
+
+func (idp *fakeDeviceIdP) pollTimes() []time.Time {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	return append([]time.Time(nil), idp.polls...)
+}
+
+func TestDeviceFlow(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("pending until approved", func(t *testing.T) {
+		t.Parallel()
+		idp, realmURL := newFakeDeviceIdP(t, "authorization_pending")
+		var shown flow.DeviceCode
+		f := &flow.DeviceFlow{
+			Endpoint: flow.KeycloakEndpoint(realmURL),
+			ClientID: "dev-cli",
+			Token:    oidcprovider.TokenKindID,
+			OnCode:   func(c flow.DeviceCode) { shown = c },
+		}
+		token, err := f.FetchToken(ctx)
+		require.NoError(t, err)
+		require.Equal(t, "device-id", token)
+		require.Equal(t, "ABCD-EFGH", shown.UserCode)
+		require.Equal(t, "https://kc.example.com/realms/dev/device", shown.VerificationURI)
+		require.NotEmpty(t, shown.VerificationURIComplete)
+		require.WithinDuration(t, time.Now().Add(10*time.Minute), shown.Expiry, 5*time.Second)
+
+		polls := idp.pollTimes()
+		require.Len(t, polls, 2)
+		require.GreaterOrEqual(t, polls[1].Sub(polls[0]), 900*time.Millisecond, "polls respect the interval")
+
+		// The valid token is reused without a new device login
+		token, err = f.FetchToken(ctx)
+		require.NoError(t, err)
+		require.Equal(t, "device-id", token)
+		require.Len(t, idp.pollTimes(), 2)
+	})
+
+	t.Run("slow_down increases the interval", func(t *testing.T) {
+		t.Parallel()
+		idp, realmURL := newFakeDeviceIdP(t, "slow_down")
+		f := &flow.DeviceFlow{Endpoint: flow.KeycloakEndpoint(realmURL), ClientID: "dev-cli", OnCode: func(flow.DeviceCode) {}}
+		token, err := f.FetchToken(ctx)
+		require.NoError(t, err)
+		require.Equal(t, "device-access", token)
+
+		polls := idp.pollTimes()
+		require.Len(t, polls, 2)
+		require.GreaterOrEqual(t, polls[1].Sub(polls[0]), 5*time.Second)
+	})
+
+	t.Run("denied", func(t *testing.T) {
+		t.Parallel()
+		_, realmURL := newFakeDeviceIdP(t, "access_denied")
+		f := &flow.DeviceFlow{Endpoint: flow.KeycloakEndpoint(realmURL), ClientID: "dev-cli", OnCode: func(flow.DeviceCode) {}}
+		_, err := f.FetchToken(ctx)
+		require.ErrorIs(t, err, flow.ErrAccessDenied)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		t.Parallel()
+		_, realmURL := newFakeDeviceIdP(t, "authorization_pending", "expired_token")
+		f := &flow.DeviceFlow{Endpoint: flow.KeycloakEndpoint(realmURL), ClientID: "dev-cli", OnCode: func(flow.DeviceCode) {}}
+		_, err := f.FetchToken(ctx)
+		require.ErrorIs(t, err, flow.ErrDeviceCodeExpired)
+	})
+
+	t.Run("incomplete configuration", func(t *testing.T) {
+		t.Parallel()
+		f := &flow.DeviceFlow{Endpoint: flow.KeycloakEndpoint("http://127.0.0.1:1/realms/dev"), ClientID: "dev-cli"}
+		f.Endpoint.DeviceAuthURL = ""
+		_, err := f.FetchToken(ctx)
+		require.ErrorContains(t, err, "DeviceAuthURL")
+	})
+}
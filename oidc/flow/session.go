@@ -0,0 +1,83 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	oidcprovider "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"golang.org/x/oauth2"
+)
+
+// loginFunc runs an interactive login and returns its token and the source renewing it.
+type loginFunc func(ctx context.Context) (*oauth2.Token, oauth2.TokenSource, error)
+
+// session keeps the token source of the last login, which renews it with the refresh token.
+type session struct {
+	mu     sync.Mutex
+	source oauth2.TokenSource
+}
+
+// tokens returns a valid token, renewing the last login or running login when there is none, the
+// renewal failed (the refresh token expired or the SSO session ended) or force is set.
+func (s *session) tokens(ctx context.Context, force bool, login loginFunc) (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.source != nil && !force {
+		token, err := s.source.Token()
+		if err == nil {
+			return token, nil
+		}
+	}
+	s.source = nil
+	token, source, err := login(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.source = source
+	return token, nil
+}
+
+// renewSource returns a source renewing token with its refresh token.
+// Renewals run outside of any request, they are bounded by the HTTP client of ctx.
+func renewSource(ctx context.Context, conf *oauth2.Config, token *oauth2.Token) oauth2.TokenSource {
+	return oauth2.ReuseTokenSource(token, conf.TokenSource(context.WithoutCancel(ctx), token))
+}
+
+// selectToken returns the token of kind from a token response and reports its expiry to the cache.
+func selectToken(ctx context.Context, token *oauth2.Token, kind oidcprovider.TokenKind) (string, error) {
+	oidcprovider.ReportExpiry(ctx, token.Expiry)
+	if kind == oidcprovider.TokenKindID {
+		idToken, _ := token.Extra("id_token").(string)
+		if idToken == "" {
+			return "", errors.New("token response has no id_token")
+		}
+		return idToken, nil
+	}
+	return token.AccessToken, nil
+}
+
+// withOpenID returns scopes with "openid" first.
+func withOpenID(scopes []string) []string {
+	out := []string{"openid"}
+	for _, s := range scopes {
+		if s != "openid" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// endpointFor resolves oauth2.AuthStyleAutoDetect, which repeats a rejected request with the other
+// client authentication style: that would redeem a one-time authorization code twice and double every
+// device poll. Public clients send the client_id in the form, confidential ones use HTTP Basic.
+func endpointFor(endpoint oauth2.Endpoint, clientSecret string) oauth2.Endpoint {
+	if endpoint.AuthStyle == oauth2.AuthStyleAutoDetect {
+		endpoint.AuthStyle = oauth2.AuthStyleInHeader
+		if clientSecret == "" {
+			endpoint.AuthStyle = oauth2.AuthStyleInParams
+		}
+	}
+	return endpoint
+}
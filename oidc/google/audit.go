@@ -0,0 +1,194 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	oidcprovider "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google/externalaccount"
+)
+
+// ImpersonationRecord is the JSON line NewJSONAuditWriter writes for an impersonation event. The JSON
+// field names are stable and snake_case, so records can be loaded into BigQuery or parsed by Cloud
+// Logging as they are.
+type ImpersonationRecord struct {
+	Time            time.Time `json:"time"`
+	Provider        string    `json:"provider"` // "sts" (WIFConfig) or "iamcredentials" (ImpersonationConfig)
+	ServiceAccount  string    `json:"service_account"`
+	Delegates       []string  `json:"delegates,omitempty"`
+	Scopes          []string  `json:"scopes,omitempty"`
+	LifetimeSeconds int64     `json:"lifetime_seconds,omitempty"` // requested lifetime, 0 for the default
+	ExpireTime      time.Time `json:"expire_time"`                // expiry of the minted token, zero on failure
+	// Caller holds identity claims of the caller: for WIF the iss, sub, aud, azp, client_id, email and
+	// preferred_username of the subject token (as sent, not verified), for ADC ImpersonationConfig.Caller.
+	Caller         map[string]string         `json:"caller,omitempty"`
+	Attestation    *oidcprovider.Attestation `json:"attestation,omitempty"`
+	StatusCode     int                       `json:"status_code,omitempty"` // status of a rejected call
+	Error          string                    `json:"error,omitempty"`
+	DurationMillis int64                     `json:"duration_ms"`
+}
+
+// NewImpersonationRecord returns the record of an impersonation event.
+func NewImpersonationRecord(ev oidcprovider.Event) ImpersonationRecord {
+	rec := ImpersonationRecord{
+		Time:           ev.Time,
+		Provider:       ev.Provider,
+		Attestation:    ev.Attestation,
+		DurationMillis: ev.Duration.Milliseconds(),
+	}
+	if imp := ev.Impersonation; imp != nil {
+		rec.ServiceAccount = imp.ServiceAccount
+		rec.Delegates = imp.Delegates
+		rec.Scopes = imp.Scopes
+		rec.LifetimeSeconds = int64(imp.Lifetime / time.Second)
+		rec.ExpireTime = imp.Expiry
+		rec.Caller = imp.Caller
+	}
+	if ev.Err != nil {
+		rec.Error = ev.Err.Error()
+		var tErr *oidcprovider.TokenError
+		if errors.As(ev.Err, &tErr) {
+			rec.StatusCode = tErr.StatusCode
+		}
+	}
+	return rec
+}
+
+// NewJSONAuditWriter returns an EventHandler writing every impersonation event as one JSON line to w
+// (see ImpersonationRecord), with the severity and message fields Cloud Logging recognizes; other
+// events are ignored. Written to stdout on GKE or Cloud Run the lines become structured log entries,
+// which a log sink can route to BigQuery; a file of such lines can be loaded into BigQuery as
+// newline-delimited JSON directly.
+func NewJSONAuditWriter(w io.Writer) oidcprovider.EventHandler {
+	var mu sync.Mutex
+	return func(ev oidcprovider.Event) {
+		if ev.Type != oidcprovider.EventImpersonation {
+			return
+		}
+		rec := NewImpersonationRecord(ev)
+		entry := struct {
+			Severity string `json:"severity"`
+			Message  string `json:"message"`
+			ImpersonationRecord
+		}{Severity: "NOTICE", Message: "impersonated " + rec.ServiceAccount, ImpersonationRecord: rec}
+		if rec.Error != "" {
+			entry.Severity, entry.Message = "ERROR", "failed to impersonate "+rec.ServiceAccount
+		}
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		_, _ = w.Write(append(line, '\n'))
+	}
+}
+
+// impersonationAudit emits an impersonation event, described by the configuration of the source, for
+// every token its source mints and every failed attempt. Token calls are serialized, so the caller
+// claims Subjects took from the subject token of a WIF exchange belong to the token being minted.
+type impersonationAudit struct {
+	Source        oauth2.TokenSource
+	Provider      string
+	OnEvent       oidcprovider.EventHandler
+	Impersonation oidcprovider.Impersonation
+	Subjects      *subjectRecorder // WIF only, replaces Impersonation.Caller
+
+	mu   sync.Mutex
+	last string // access token of the last reported impersonation
+}
+
+func (a *impersonationAudit) Token() (*oauth2.Token, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	start := time.Now()
+	tok, err := a.Source.Token()
+	// The source caches its token, only a new one was minted by this call
+	if err != nil || tok.AccessToken != a.last {
+		a.report(start, tok, err)
+	}
+	return tok, err
+}
+
+// report emits the event of the impersonation started at start, the caller must hold a.mu unless a is
+// not shared yet.
+func (a *impersonationAudit) report(start time.Time, tok *oauth2.Token, err error) {
+	imp := a.Impersonation
+	if a.Subjects != nil {
+		imp.Caller = a.Subjects.caller()
+	}
+	if err == nil {
+		a.last = tok.AccessToken
+		imp.Expiry = tok.Expiry
+	}
+	a.OnEvent(oidcprovider.Event{
+		Type:          oidcprovider.EventImpersonation,
+		Provider:      a.Provider,
+		Time:          time.Now(),
+		Duration:      time.Since(start),
+		Err:           err,
+		Impersonation: &imp,
+	})
+}
+
+// subjectRecorder passes the subject tokens of a WIF source through and keeps the identity claims of
+// the last one, the subject of the federated token the impersonation is authorized with.
+type subjectRecorder struct {
+	Supplier TokenSupplier
+
+	mu     sync.Mutex
+	claims map[string]string
+}
+
+func (s *subjectRecorder) SubjectToken(ctx context.Context, opts externalaccount.SupplierOptions) (string, error) {
+	token, err := s.Supplier.SubjectToken(ctx, opts)
+	if err == nil {
+		s.mu.Lock()
+		s.claims = subjectClaims(token)
+		s.mu.Unlock()
+	}
+	return token, err
+}
+
+func (s *subjectRecorder) caller() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.claims
+}
+
+// callerClaimNames are the subject token claims recorded as the caller of a WIF impersonation.
+var callerClaimNames = []string{"iss", "sub", "aud", "azp", "client_id", "email", "preferred_username"}
+
+// subjectClaims returns the identity claims of a JWT subject token, nil for opaque tokens.
+func subjectClaims(token string) map[string]string {
+	claims, err := oidcprovider.DecodeJWTClaims(token, false)
+	if err != nil {
+		return nil
+	}
+	caller := map[string]string{}
+	for _, name := range callerClaimNames {
+		switch v := claims[name].(type) {
+		case string:
+			caller[name] = v
+		case []interface{}:
+			values := make([]string, 0, len(v))
+			for _, s := range v {
+				if s, ok := s.(string); ok {
+					values = append(values, s)
+				}
+			}
+			caller[name] = strings.Join(values, ",")
+		}
+	}
+	if len(caller) == 0 {
+		return nil
+	}
+	return caller
+}
@@ -0,0 +1,210 @@
+package oidc_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	gcpwif "github.com/PCS-Indonesia/pcs-oidc/oidc/google"
+	oidcprovider "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestImpersonationAudit(t *testing.T) {
+	ctx := context.Background()
+	expiry := time.Now().Add(30 * time.Minute).UTC().Truncate(time.Second)
+	iamHandler := func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "denied@") {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"error":{"code":403,"message":"denied"}}`))
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"accessToken": "impersonated", "expireTime": expiry.Format(time.RFC3339)})
+	}
+	collect := func() (*[]gcpwif.ImpersonationRecord, oidcprovider.EventHandler) {
+		var mu sync.Mutex
+		var records []gcpwif.ImpersonationRecord
+		return &records, func(ev oidcprovider.Event) {
+			if ev.Type != oidcprovider.EventImpersonation {
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			records = append(records, gcpwif.NewImpersonationRecord(ev))
+		}
+	}
+
+	t.Run("ADC impersonation", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(iamHandler))
+		t.Cleanup(srv.Close)
+		target, err := url.Parse(srv.URL)
+		require.NoError(t, err)
+		records, onEvent := collect()
+		ts, err := gcpwif.GetImpersonatedTokenSource(ctx, gcpwif.ImpersonationConfig{
+			TargetPrincipal: "app@p.iam.gserviceaccount.com",
+			Delegates:       []string{"hop@p.iam.gserviceaccount.com"},
+			Lifetime:        30 * time.Minute,
+			HTTPClient:      &http.Client{Transport: &redirectTransport{target: target}},
+			OnEvent:         onEvent,
+			Caller:          map[string]string{"sub": "batch-job"},
+		})
+		require.NoError(t, err)
+		tok, err := ts.Token()
+		require.NoError(t, err)
+		require.Equal(t, "impersonated", tok.AccessToken)
+
+		require.Len(t, *records, 1)
+		rec := (*records)[0]
+		require.Equal(t, "iamcredentials", rec.Provider)
+		require.Equal(t, "app@p.iam.gserviceaccount.com", rec.ServiceAccount)
+		require.Equal(t, []string{"hop@p.iam.gserviceaccount.com"}, rec.Delegates)
+		require.Equal(t, []string{"https://www.googleapis.com/auth/cloud-platform"}, rec.Scopes)
+		require.EqualValues(t, 1800, rec.LifetimeSeconds)
+		require.True(t, expiry.Equal(rec.ExpireTime))
+		require.Equal(t, map[string]string{"sub": "batch-job"}, rec.Caller)
+		require.Empty(t, rec.Error)
+
+		// The cached token is not minted again
+		_, err = ts.Token()
+		require.NoError(t, err)
+		require.Len(t, *records, 1)
+	})
+
+	t.Run("failed impersonation is recorded", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(iamHandler))
+		t.Cleanup(srv.Close)
+		target, err := url.Parse(srv.URL)
+		require.NoError(t, err)
+		records, onEvent := collect()
+		ts, err := gcpwif.GetImpersonatedTokenSource(ctx, gcpwif.ImpersonationConfig{
+			TargetPrincipal: "denied@p.iam.gserviceaccount.com",
+			HTTPClient:      &http.Client{Transport: &redirectTransport{target: target}},
+			OnEvent:         onEvent,
+		})
+		require.NoError(t, err)
+		_, err = ts.Token()
+		require.Error(t, err)
+
+		require.Len(t, *records, 1)
+		require.Equal(t, http.StatusForbidden, (*records)[0].StatusCode)
+		require.NotEmpty(t, (*records)[0].Error)
+		require.True(t, (*records)[0].ExpireTime.IsZero())
+	})
+
+	t.Run("WIF impersonation records subject token claims", func(t *testing.T) {
+		tokenURL := newFakeSTS(t, func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/v1/token" {
+				iamHandler(w, r)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"access_token":      "federated",
+				"issued_token_type": "urn:ietf:params:oauth:token-type:access_token",
+				"token_type":        "Bearer",
+				"expires_in":        3600,
+			})
+		})
+		impersonationURL := strings.TrimSuffix(tokenURL, "/token") + "/projects/-/serviceAccounts/wif@p.iam.gserviceaccount.com:generateAccessToken"
+		enc := base64.RawURLEncoding.EncodeToString
+		subject := enc([]byte(`{"alg":"none"}`)) + "." +
+			enc([]byte(`{"iss":"https://sso.example.com/realms/pcs","sub":"svc-1","aud":["gcp","api"],"azp":"pcs-app","exp":4102444800}`)) + ".sig"
+
+		records, onEvent := collect()
+		cfg := wifConfig(tokenURL)
+		cfg.ServiceAccountImpersonationURL = impersonationURL
+		cfg.TokenSupplier = &gcpwif.StaticTokenSupplier{Token: subject}
+		cfg.Attestation = &oidcprovider.Attestation{Source: "keycloak", Subject: "svc-1"}
+		cfg.OnEvent = onEvent
+		ts, err := gcpwif.GetGCPTokenSource(ctx, cfg)
+		require.NoError(t, err)
+		tok, err := ts.Token()
+		require.NoError(t, err)
+		require.Equal(t, "impersonated", tok.AccessToken)
+
+		require.Len(t, *records, 1)
+		rec := (*records)[0]
+		require.Equal(t, "sts", rec.Provider)
+		require.Equal(t, "wif@p.iam.gserviceaccount.com", rec.ServiceAccount)
+		require.Equal(t, map[string]string{
+			"iss": "https://sso.example.com/realms/pcs",
+			"sub": "svc-1",
+			"aud": "gcp,api",
+			"azp": "pcs-app",
+		}, rec.Caller)
+		require.Equal(t, "keycloak", rec.Attestation.Source)
+	})
+
+	t.Run("concurrent WIF sources keep their own caller", func(t *testing.T) {
+		tokenURL := newFakeSTS(t, func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/v1/token" {
+				iamHandler(w, r)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"access_token":      "federated",
+				"issued_token_type": "urn:ietf:params:oauth:token-type:access_token",
+				"token_type":        "Bearer",
+				"expires_in":        3600,
+			})
+		})
+		impersonationURL := strings.TrimSuffix(tokenURL, "/token") + "/projects/-/serviceAccounts/wif@p.iam.gserviceaccount.com:generateAccessToken"
+		enc := base64.RawURLEncoding.EncodeToString
+		shared := &http.Client{}
+
+		var wg sync.WaitGroup
+		for _, sub := range []string{"svc-1", "svc-2", "svc-3", "svc-4"} {
+			records, onEvent := collect()
+			cfg := wifConfig(tokenURL)
+			cfg.ServiceAccountImpersonationURL = impersonationURL
+			cfg.TokenSupplier = &gcpwif.StaticTokenSupplier{Token: enc([]byte(`{"alg":"none"}`)) + "." + enc([]byte(`{"sub":"`+sub+`"}`)) + ".sig"}
+			cfg.HTTPClient = shared
+			cfg.OnEvent = onEvent
+			ts, err := gcpwif.GetGCPTokenSource(ctx, cfg)
+			require.NoError(t, err)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := ts.Token()
+				require.NoError(t, err)
+				require.Len(t, *records, 1)
+				require.Equal(t, map[string]string{"sub": sub}, (*records)[0].Caller)
+			}()
+		}
+		wg.Wait()
+	})
+
+	t.Run("JSON audit writer", func(t *testing.T) {
+		var buf bytes.Buffer
+		write := gcpwif.NewJSONAuditWriter(&buf)
+		write(oidcprovider.Event{Type: oidcprovider.EventImpersonation, Impersonation: &oidcprovider.Impersonation{
+			ServiceAccount: "app@p.iam.gserviceaccount.com",
+			Delegates:      []string{"hop@p.iam.gserviceaccount.com"},
+		}})
+		write(oidcprovider.Event{Type: oidcprovider.EventTokenFetched, Provider: "sts"})
+		write(oidcprovider.Event{
+			Type:          oidcprovider.EventImpersonation,
+			Err:           &oidcprovider.TokenError{Provider: "iamcredentials", StatusCode: http.StatusForbidden},
+			Impersonation: &oidcprovider.Impersonation{ServiceAccount: "denied@p.iam.gserviceaccount.com"},
+		})
+
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		require.Len(t, lines, 2)
+		var entry map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(lines[0]), &entry))
+		require.Equal(t, "NOTICE", entry["severity"])
+		require.Equal(t, "app@p.iam.gserviceaccount.com", entry["service_account"])
+		require.Equal(t, []interface{}{"hop@p.iam.gserviceaccount.com"}, entry["delegates"])
+		require.NoError(t, json.Unmarshal([]byte(lines[1]), &entry))
+		require.Equal(t, "ERROR", entry["severity"])
+		require.EqualValues(t, http.StatusForbidden, entry["status_code"])
+	})
+}
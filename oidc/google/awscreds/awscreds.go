@@ -0,0 +1,127 @@
+// Package awscreds supplies AWS credentials for AWS to GCP workload identity federation. It is a
+// separate package so that only services federating from AWS link the AWS SDK, the oidc/google package
+// itself does not depend on it.
+package awscreds
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/ec2/imds"
+	"golang.org/x/oauth2/google/externalaccount"
+)
+
+// DefaultIMDSEndpoint is the EC2 instance metadata service.
+const DefaultIMDSEndpoint = "http://169.254.169.254"
+
+// credentialsLeeway is how long before their expiration temporary credentials are renewed.
+const credentialsLeeway = 5 * time.Minute
+
+// ErrNoCredentials is returned when no source of the default AWS credential chain has credentials.
+var ErrNoCredentials = errors.New("no AWS credentials found by the default AWS credential chain")
+
+// Supplier implements externalaccount.AwsSecurityCredentialsSupplier, the WIFConfig.AwsSupplier of
+// oidc/google, with the default AWS credential chain of the AWS SDK: environment variables, web identity
+// (AWS_WEB_IDENTITY_TOKEN_FILE, e.g. EKS IRSA), the shared credentials and config files including
+// role_arn, credential_process and SSO profiles, ECS container credentials and EC2 instance metadata
+// (IMDSv2). Temporary credentials are cached until shortly before they expire, as the external account
+// token source does not cache them.
+type Supplier struct {
+	Profile               string       // default $AWS_PROFILE or "default"
+	Region                string       // default $AWS_REGION, $AWS_DEFAULT_REGION, the profile or instance metadata
+	SharedCredentialsFile string       // default $AWS_SHARED_CREDENTIALS_FILE or ~/.aws/credentials
+	ConfigFile            string       // default $AWS_CONFIG_FILE or ~/.aws/config
+	IMDSEndpoint          string       // default $AWS_EC2_METADATA_SERVICE_ENDPOINT or DefaultIMDSEndpoint
+	DisableIMDS           bool         // also disabled by AWS_EC2_METADATA_DISABLED=true
+	HTTPClient            *http.Client // default the AWS SDK client, which also honours AWS_CA_BUNDLE
+
+	mu     sync.Mutex
+	config *aws.Config
+}
+
+// AwsRegion returns the configured region, falling back to the profile and instance metadata.
+func (s *Supplier) AwsRegion(ctx context.Context, _ externalaccount.SupplierOptions) (string, error) {
+	cfg, err := s.awsConfig(ctx)
+	if err != nil {
+		return "", err
+	}
+	if cfg.Region != "" {
+		return cfg.Region, nil
+	}
+	if s.DisableIMDS {
+		return "", errors.New("AWS region is not configured: set Region or AWS_REGION")
+	}
+	// Looked up here rather than while loading the config, so credentials resolve without a region
+	out, err := imds.NewFromConfig(*cfg).GetRegion(ctx, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to get AWS region from instance metadata: %w", err)
+	}
+	return out.Region, nil
+}
+
+// AwsSecurityCredentials returns credentials from the first source of the chain that has them.
+func (s *Supplier) AwsSecurityCredentials(ctx context.Context, _ externalaccount.SupplierOptions) (*externalaccount.AwsSecurityCredentials, error) {
+	cfg, err := s.awsConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Credentials == nil {
+		return nil, ErrNoCredentials
+	}
+	// The credentials cache of the config renews temporary credentials within credentialsLeeway
+	creds, err := cfg.Credentials.Retrieve(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNoCredentials, err)
+	}
+	return &externalaccount.AwsSecurityCredentials{
+		AccessKeyID:     creds.AccessKeyID,
+		SecretAccessKey: creds.SecretAccessKey,
+		SessionToken:    creds.SessionToken,
+	}, nil
+}
+
+// awsConfig loads the shared AWS configuration once.
+func (s *Supplier) awsConfig(ctx context.Context) (*aws.Config, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.config != nil {
+		return s.config, nil
+	}
+	opts := []func(*config.LoadOptions) error{
+		config.WithCredentialsCacheOptions(func(o *aws.CredentialsCacheOptions) {
+			o.ExpiryWindow = credentialsLeeway
+		}),
+	}
+	if s.HTTPClient != nil {
+		opts = append(opts, config.WithHTTPClient(s.HTTPClient))
+	}
+	if s.Profile != "" {
+		opts = append(opts, config.WithSharedConfigProfile(s.Profile))
+	}
+	if s.Region != "" {
+		opts = append(opts, config.WithRegion(s.Region))
+	}
+	if s.SharedCredentialsFile != "" {
+		opts = append(opts, config.WithSharedCredentialsFiles([]string{s.SharedCredentialsFile}))
+	}
+	if s.ConfigFile != "" {
+		opts = append(opts, config.WithSharedConfigFiles([]string{s.ConfigFile}))
+	}
+	if s.DisableIMDS {
+		opts = append(opts, config.WithEC2IMDSClientEnableState(imds.ClientDisabled))
+	} else if s.IMDSEndpoint != "" {
+		opts = append(opts, config.WithEC2IMDSEndpoint(s.IMDSEndpoint))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS configuration: %w", err)
+	}
+	s.config = &cfg
+	return s.config, nil
+}
@@ -0,0 +1,185 @@
+package awscreds_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	gcpwif "github.com/PCS-Indonesia/pcs-oidc/oidc/google"
+	"github.com/PCS-Indonesia/pcs-oidc/oidc/google/awscreds"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2/google/externalaccount"
+)
+
+// clearAwsEnv isolates a test from the AWS configuration of the machine running it
+func clearAwsEnv(t *testing.T) {
+	t.Helper()
+	for _, env := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN", "AWS_REGION",
+		"AWS_DEFAULT_REGION", "AWS_PROFILE", "AWS_EC2_METADATA_DISABLED", "AWS_EC2_METADATA_SERVICE_ENDPOINT",
+		"AWS_WEB_IDENTITY_TOKEN_FILE", "AWS_ROLE_ARN", "AWS_CONTAINER_CREDENTIALS_RELATIVE_URI", "AWS_CONTAINER_CREDENTIALS_FULL_URI", "AWS_CONTAINER_AUTHORIZATION_TOKEN"} {
+		t.Setenv(env, "")
+	}
+	missing := filepath.Join(t.TempDir(), "missing")
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", missing)
+	t.Setenv("AWS_CONFIG_FILE", missing)
+}
+
+func TestSupplier(t *testing.T) {
+	ctx := context.Background()
+	opts := externalaccount.SupplierOptions{}
+
+	t.Run("environment", func(t *testing.T) {
+		clearAwsEnv(t)
+		t.Setenv("AWS_ACCESS_KEY_ID", "AKIDENV")
+		t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+		t.Setenv("AWS_REGION", "eu-west-1")
+		s := &awscreds.Supplier{DisableIMDS: true}
+		creds, err := s.AwsSecurityCredentials(ctx, opts)
+		require.NoError(t, err)
+		require.Equal(t, "AKIDENV", creds.AccessKeyID)
+		region, err := s.AwsRegion(ctx, opts)
+		require.NoError(t, err)
+		require.Equal(t, "eu-west-1", region)
+	})
+
+	t.Run("shared config", func(t *testing.T) {
+		clearAwsEnv(t)
+		dir := t.TempDir()
+		credentials := filepath.Join(dir, "credentials")
+		config := filepath.Join(dir, "config")
+		require.NoError(t, os.WriteFile(credentials, []byte("[default]\naws_access_key_id = AKIDDEFAULT\naws_secret_access_key = s1\n\n[ci]\naws_access_key_id = AKIDCI\naws_secret_access_key = s2\naws_session_token = tok\n"), 0o600))
+		require.NoError(t, os.WriteFile(config, []byte("[profile ci]\nregion = ap-southeast-3\n"), 0o600))
+		t.Setenv("AWS_PROFILE", "ci")
+		s := &awscreds.Supplier{SharedCredentialsFile: credentials, ConfigFile: config, DisableIMDS: true}
+		creds, err := s.AwsSecurityCredentials(ctx, opts)
+		require.NoError(t, err)
+		require.Equal(t, externalaccount.AwsSecurityCredentials{AccessKeyID: "AKIDCI", SecretAccessKey: "s2", SessionToken: "tok"}, *creds)
+		region, err := s.AwsRegion(ctx, opts)
+		require.NoError(t, err)
+		require.Equal(t, "ap-southeast-3", region)
+	})
+
+	t.Run("instance metadata", func(t *testing.T) {
+		clearAwsEnv(t)
+		var credentialCalls atomic.Int32
+		imds := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/latest/api/token" {
+				require.Equal(t, http.MethodPut, r.Method)
+				w.Header().Set("X-Aws-Ec2-Metadata-Token-Ttl-Seconds", r.Header.Get("X-Aws-Ec2-Metadata-Token-Ttl-Seconds"))
+				_, _ = w.Write([]byte("session"))
+				return
+			}
+			if r.Header.Get("X-aws-ec2-metadata-token") != "session" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			switch r.URL.Path {
+			case "/latest/dynamic/instance-identity/document":
+				_, _ = w.Write([]byte(`{"region":"us-east-2"}`))
+			case "/latest/meta-data/iam/security-credentials/":
+				_, _ = w.Write([]byte("app-role\n"))
+			case "/latest/meta-data/iam/security-credentials/app-role":
+				credentialCalls.Add(1)
+				_, _ = w.Write([]byte(`{"Code":"Success","AccessKeyId":"ASIAIMDS","SecretAccessKey":"s3","Token":"session-token","Expiration":"` +
+					time.Now().Add(time.Hour).UTC().Format(time.RFC3339) + `"}`))
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		t.Cleanup(imds.Close)
+		s := &awscreds.Supplier{IMDSEndpoint: imds.URL}
+		for i := 0; i < 2; i++ {
+			creds, err := s.AwsSecurityCredentials(ctx, opts)
+			require.NoError(t, err)
+			require.Equal(t, "ASIAIMDS", creds.AccessKeyID)
+			require.Equal(t, "session-token", creds.SessionToken)
+		}
+		require.EqualValues(t, 1, credentialCalls.Load(), "temporary credentials are cached until they expire")
+		region, err := s.AwsRegion(ctx, opts)
+		require.NoError(t, err)
+		require.Equal(t, "us-east-2", region)
+	})
+
+	t.Run("credential process", func(t *testing.T) {
+		clearAwsEnv(t)
+		dir := t.TempDir()
+		script := filepath.Join(dir, "creds.sh")
+		require.NoError(t, os.WriteFile(script, []byte(`#!/bin/sh
+echo '{"Version":1,"AccessKeyId":"AKIDPROC","SecretAccessKey":"s4","SessionToken":"proc-token"}'
+`), 0o700))
+		config := filepath.Join(dir, "config")
+		require.NoError(t, os.WriteFile(config, []byte("[profile proc]\ncredential_process = "+script+"\n"), 0o600))
+		s := &awscreds.Supplier{Profile: "proc", ConfigFile: config, DisableIMDS: true}
+		creds, err := s.AwsSecurityCredentials(ctx, opts)
+		require.NoError(t, err)
+		require.Equal(t, externalaccount.AwsSecurityCredentials{AccessKeyID: "AKIDPROC", SecretAccessKey: "s4", SessionToken: "proc-token"}, *creds)
+	})
+
+	t.Run("container credentials", func(t *testing.T) {
+		clearAwsEnv(t)
+		ecs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.Equal(t, "/creds", r.URL.Path)
+			require.Equal(t, "task-auth", r.Header.Get("Authorization"))
+			_, _ = w.Write([]byte(`{"AccessKeyId":"ASIAECS","SecretAccessKey":"s5","Token":"ecs-token","Expiration":"` +
+				time.Now().Add(time.Hour).UTC().Format(time.RFC3339) + `"}`))
+		}))
+		t.Cleanup(ecs.Close)
+		t.Setenv("AWS_CONTAINER_CREDENTIALS_FULL_URI", ecs.URL+"/creds")
+		t.Setenv("AWS_CONTAINER_AUTHORIZATION_TOKEN", "task-auth")
+		s := &awscreds.Supplier{DisableIMDS: true}
+		creds, err := s.AwsSecurityCredentials(ctx, opts)
+		require.NoError(t, err)
+		require.Equal(t, "ASIAECS", creds.AccessKeyID)
+		require.Equal(t, "ecs-token", creds.SessionToken)
+	})
+
+	t.Run("nothing configured", func(t *testing.T) {
+		clearAwsEnv(t)
+		_, err := (&awscreds.Supplier{DisableIMDS: true}).AwsSecurityCredentials(ctx, opts)
+		require.ErrorIs(t, err, awscreds.ErrNoCredentials)
+	})
+}
+
+func TestGetGCPTokenSourceAws(t *testing.T) {
+	clearAwsEnv(t)
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDENV")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_REGION", "us-east-1")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		require.Equal(t, gcpwif.AwsSubjectTokenType, r.PostForm.Get("subject_token_type"))
+		// The subject token is the signed GetCallerIdentity request
+		subject, err := url.QueryUnescape(r.PostForm.Get("subject_token"))
+		require.NoError(t, err)
+		require.True(t, strings.Contains(subject, "sts.us-east-1.amazonaws.com"), subject)
+		require.True(t, strings.Contains(subject, "AKIDENV"), subject)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"gcp","issued_token_type":"urn:ietf:params:oauth:token-type:access_token","token_type":"Bearer","expires_in":3600}`))
+	}))
+	t.Cleanup(srv.Close)
+	tokenURL := srv.URL + "/v1/token"
+	cfg := gcpwif.WIFConfig{
+		Audience:         "//iam.googleapis.com/projects/1/locations/global/workloadIdentityPools/aws/providers/aws",
+		SubjectTokenType: gcpwif.AwsSubjectTokenType,
+		TokenURL:         tokenURL,
+		Scopes:           []string{"https://www.googleapis.com/auth/cloud-platform"},
+		AwsSupplier:      &awscreds.Supplier{DisableIMDS: true},
+	}
+	ts, err := gcpwif.GetGCPTokenSource(context.Background(), cfg)
+	require.NoError(t, err)
+	tok, err := ts.Token()
+	require.NoError(t, err)
+	require.Equal(t, "gcp", tok.AccessToken)
+
+	cfg.TokenSupplier = &gcpwif.StaticTokenSupplier{Token: "subject"}
+	_, err = gcpwif.GetGCPTokenSource(context.Background(), cfg)
+	require.ErrorContains(t, err, "set exactly one of TokenSupplier and AwsSupplier")
+}
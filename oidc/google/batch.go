@@ -0,0 +1,110 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	oidcprovider "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google/externalaccount"
+)
+
+// ExchangeAll performs one STS exchange per audience concurrently and returns a token source per audience.
+// All exchanges share one subject token: subjectToken when given, otherwise a token fetched once from
+// cfg.TokenSupplier and fetched again only when it expires. Each returned source already holds its
+// first Google token. Audiences whose exchange failed are missing from the map and reported in the error.
+func (cfg WIFConfig) ExchangeAll(ctx context.Context, subjectToken string, audiences []string) (map[string]oauth2.TokenSource, error) {
+	var base TokenSupplier = cfg.TokenSupplier
+	if subjectToken != "" {
+		base = &StaticTokenSupplier{Token: subjectToken}
+	}
+	if base == nil {
+		return nil, fmt.Errorf("missing required WIFConfig fields")
+	}
+	ttl := cfg.SubjectTTL
+	if ttl <= 0 {
+		ttl = DefaultSubjectTTL
+	}
+	shared := &sharedSubjectSupplier{base: base, opaqueTTL: ttl}
+
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		sources = make(map[string]oauth2.TokenSource, len(audiences))
+		errs    []error
+	)
+	for _, audience := range audiences {
+		wg.Add(1)
+		go func(audience string) {
+			defer wg.Done()
+			ts, err := func() (ts oauth2.TokenSource, err error) {
+				// A panic for one audience fails that audience instead of the whole process
+				defer oidcprovider.Recover("batch:"+audience, &err)
+				c := cfg
+				c.Audience = audience
+				c.TokenSupplier = shared
+				ts, err = GetGCPTokenSource(ctx, c)
+				if err == nil {
+					_, err = ts.Token()
+				}
+				return ts, err
+			}()
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", audience, err))
+				return
+			}
+			sources[audience] = ts
+		}(audience)
+	}
+	wg.Wait()
+	return sources, errors.Join(errs...)
+}
+
+// sharedSubjectSupplier fetches the subject token once and hands it to every exchange until it expires.
+// Opaque tokens carry no expiry and are only shared for opaqueTTL.
+type sharedSubjectSupplier struct {
+	base      TokenSupplier
+	opaqueTTL time.Duration
+
+	mu        sync.Mutex
+	token     string
+	refreshAt time.Time
+}
+
+func (s *sharedSubjectSupplier) SubjectToken(ctx context.Context, opts externalaccount.SupplierOptions) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != "" && time.Now().Before(s.refreshAt) {
+		return s.token, nil
+	}
+	token, err := s.base.SubjectToken(ctx, opts)
+	if err != nil {
+		return "", err
+	}
+	s.token = token
+	if exp := subjectExpiry(token); !exp.IsZero() {
+		s.refreshAt = exp.Add(-30 * time.Second)
+	} else {
+		s.refreshAt = time.Now().Add(s.opaqueTTL)
+	}
+	return token, nil
+}
+
+// subjectExpiry returns the exp claim of a JWT subject token, zero for opaque tokens
+func subjectExpiry(token string) time.Time {
+	claims, err := oidcprovider.DecodeJWTClaims(token, false)
+	if err != nil {
+		return time.Time{}
+	}
+	exp, ok := claims["exp"].(float64)
+	if !ok || exp == 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(exp), 0)
+}
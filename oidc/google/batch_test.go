@@ -0,0 +1,82 @@
+package oidc_test
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2/google/externalaccount"
+)
+
+// countingSupplier counts how often the subject token is fetched
+type countingSupplier struct {
+	calls  atomic.Int32
+	opaque bool
+}
+
+func (c *countingSupplier) SubjectToken(ctx context.Context, opts externalaccount.SupplierOptions) (string, error) {
+	c.calls.Add(1)
+	if c.opaque {
+		return "opaque-subject", nil
+	}
+	// header {"alg":"none"}, payload {"exp":4102444800}
+	return "eyJhbGciOiJub25lIn0.eyJleHAiOjQxMDI0NDQ4MDB9.sig", nil
+}
+
+func TestExchangeAll(t *testing.T) {
+	tokenURL := newFakeSTS(t, func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		audience := r.PostForm.Get("audience")
+		if audience == "pool-broken" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"invalid_target"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"gcp-` + audience + `","issued_token_type":"urn:ietf:params:oauth:token-type:access_token","token_type":"Bearer","expires_in":3600}`))
+	})
+	supplier := &countingSupplier{}
+	cfg := wifConfig(tokenURL)
+	cfg.TokenSupplier = supplier
+
+	sources, err := cfg.ExchangeAll(context.Background(), "", []string{"pool-a", "pool-b", "pool-broken"})
+	require.ErrorContains(t, err, "pool-broken")
+	require.Len(t, sources, 2)
+	require.EqualValues(t, 1, supplier.calls.Load())
+
+	for _, aud := range []string{"pool-a", "pool-b"} {
+		tok, err := sources[aud].Token()
+		require.NoError(t, err)
+		require.Equal(t, "gcp-"+aud, tok.AccessToken)
+	}
+}
+
+func TestExchangeAllOpaqueSubject(t *testing.T) {
+	tokenURL := newFakeSTS(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"gcp","issued_token_type":"urn:ietf:params:oauth:token-type:access_token","token_type":"Bearer","expires_in":1}`))
+	})
+	audiences := []string{"pool-a", "pool-b"}
+
+	t.Run("shared within the TTL", func(t *testing.T) {
+		supplier := &countingSupplier{opaque: true}
+		cfg := wifConfig(tokenURL)
+		cfg.TokenSupplier = supplier
+		_, err := cfg.ExchangeAll(context.Background(), "", audiences)
+		require.NoError(t, err)
+		require.EqualValues(t, 1, supplier.calls.Load())
+	})
+
+	t.Run("fetched again after the TTL", func(t *testing.T) {
+		supplier := &countingSupplier{opaque: true}
+		cfg := wifConfig(tokenURL)
+		cfg.TokenSupplier = supplier
+		cfg.SubjectTTL = time.Nanosecond
+		_, err := cfg.ExchangeAll(context.Background(), "", audiences)
+		require.NoError(t, err)
+		require.EqualValues(t, 2, supplier.calls.Load())
+	})
+}
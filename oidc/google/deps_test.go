@@ -0,0 +1,28 @@
+package oidc_test
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestGoogleDependencies keeps the AWS SDK out of the package, it is only linked by importers of
+// awscreds, and checks that the nopubsub tag drops the Pub/Sub client
+func TestGoogleDependencies(t *testing.T) {
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go tool not available")
+	}
+	for _, tags := range []string{"", "nopubsub"} {
+		out, err := exec.Command(goTool, "list", "-tags", tags, "-deps", ".").Output()
+		require.NoError(t, err)
+		for _, dep := range strings.Fields(string(out)) {
+			require.False(t, strings.HasPrefix(dep, "github.com/aws/"), "unexpected dependency %s (tags %q)", dep, tags)
+			if tags == "nopubsub" {
+				require.False(t, strings.HasPrefix(dep, "cloud.google.com/go/pubsub"), "unexpected dependency %s (tags %q)", dep, tags)
+			}
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
+)
+
+// DefaultKeepalive is the gRPC keepalive used by GoogleClientFactory clients
+// Long-lived channels through NATs and load balancers are otherwise silently dropped when idle
+var DefaultKeepalive = keepalive.ClientParameters{
+	Time:                30 * time.Second,
+	Timeout:             10 * time.Second,
+	PermitWithoutStream: true,
+}
+
+// GoogleClientFactory hands out Google API clients that are created once and reused.
+// Token sources are shared per (audience, scopes) and clients per (kind, project, audience, scopes),
+// so creating a client per message or request does not open new gRPC channels or STS exchanges.
+// Base is the WIF template; Audience and Scopes are replaced by the values of each call.
+type GoogleClientFactory struct {
+	Base      WIFConfig
+	Leeway    time.Duration               // passed to GetGCPTokenSource
+	Keepalive *keepalive.ClientParameters // default DefaultKeepalive
+
+	mu      sync.Mutex
+	sources map[string]oauth2.TokenSource
+	clients map[string]io.Closer
+	closed  bool
+}
+
+// NewGoogleClientFactory creates a factory using base as WIF template
+func NewGoogleClientFactory(base WIFConfig) *GoogleClientFactory {
+	return &GoogleClientFactory{Base: base}
+}
+
+// factoryKey builds a cache key, scopes are sorted so their order does not matter
+func factoryKey(parts []string, scopes []string) string {
+	sorted := append([]string(nil), scopes...)
+	sort.Strings(sorted)
+	return strings.Join(parts, "|") + "|" + strings.Join(sorted, " ")
+}
+
+// TokenSource returns the shared WIF token source for audience and scopes
+// An empty audience or no scopes fall back to Base
+func (f *GoogleClientFactory) TokenSource(ctx context.Context, audience string, scopes ...string) (oauth2.TokenSource, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.tokenSource(ctx, audience, scopes)
+}
+
+func (f *GoogleClientFactory) tokenSource(ctx context.Context, audience string, scopes []string) (oauth2.TokenSource, error) {
+	cfg := f.Base
+	if audience != "" {
+		cfg.Audience = audience
+	}
+	if len(scopes) > 0 {
+		cfg.Scopes = scopes
+	}
+	key := factoryKey([]string{cfg.Audience}, cfg.Scopes)
+	if ts, ok := f.sources[key]; ok {
+		return ts, nil
+	}
+	// The token source outlives the call, so it must not inherit the caller's cancellation
+	ts, err := GetGCPTokenSource(context.WithoutCancel(ctx), cfg, f.Leeway)
+	if err != nil {
+		return nil, err
+	}
+	if f.sources == nil {
+		f.sources = make(map[string]oauth2.TokenSource)
+	}
+	f.sources[key] = ts
+	return ts, nil
+}
+
+// ClientOptions returns the options for building a Google API client on the shared token source
+func (f *GoogleClientFactory) ClientOptions(ctx context.Context, audience string, scopes ...string) ([]option.ClientOption, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.clientOptions(ctx, audience, scopes)
+}
+
+func (f *GoogleClientFactory) clientOptions(ctx context.Context, audience string, scopes []string) ([]option.ClientOption, error) {
+	ts, err := f.tokenSource(ctx, audience, scopes)
+	if err != nil {
+		return nil, err
+	}
+	ka := DefaultKeepalive
+	if f.Keepalive != nil {
+		ka = *f.Keepalive
+	}
+	return []option.ClientOption{
+		option.WithTokenSource(ts),
+		option.WithGRPCDialOption(grpc.WithKeepaliveParams(ka)),
+	}, nil
+}
+
+// Client returns the cached client of the given kind, building it with build on first use
+// kind distinguishes client types and projects, e.g. "pubsub/my-project"
+func (f *GoogleClientFactory) Client(ctx context.Context, kind, audience string, scopes []string, build func(ctx context.Context, opts ...option.ClientOption) (io.Closer, error)) (io.Closer, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return nil, errors.New("google client factory is closed")
+	}
+	key := factoryKey([]string{kind, audience}, scopes)
+	if c, ok := f.clients[key]; ok {
+		return c, nil
+	}
+	opts, err := f.clientOptions(ctx, audience, scopes)
+	if err != nil {
+		return nil, err
+	}
+	// Clients keep background connections, so they are built without the caller's cancellation
+	c, err := build(context.WithoutCancel(ctx), opts...)
+	if err != nil {
+		return nil, err
+	}
+	if f.clients == nil {
+		f.clients = make(map[string]io.Closer)
+	}
+	f.clients[key] = c
+	return c, nil
+}
+
+// Close closes every client created by the factory
+func (f *GoogleClientFactory) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	var errs []error
+	for _, c := range f.clients {
+		if err := c.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	f.clients = nil
+	f.sources = nil
+	return errors.Join(errs...)
+}
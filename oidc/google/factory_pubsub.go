@@ -0,0 +1,27 @@
+//go:build !nopubsub
+
+package oidc
+
+import (
+	"context"
+	"io"
+
+	"cloud.google.com/go/pubsub"
+	"google.golang.org/api/option"
+)
+
+// PubSubClient returns the shared Pub/Sub client for projectID
+// Without scopes it uses Base.Scopes, or the Pub/Sub scope when Base has none
+// Do not close the returned client, it is closed by Close
+func (f *GoogleClientFactory) PubSubClient(ctx context.Context, projectID, audience string, scopes ...string) (*pubsub.Client, error) {
+	if len(scopes) == 0 && len(f.Base.Scopes) == 0 {
+		scopes = append([]string(nil), serviceScopes[ServicePubSub]...)
+	}
+	c, err := f.Client(ctx, "pubsub/"+projectID, audience, scopes, func(ctx context.Context, opts ...option.ClientOption) (io.Closer, error) {
+		return pubsub.NewClient(ctx, projectID, opts...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c.(*pubsub.Client), nil
+}
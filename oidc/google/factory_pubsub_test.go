@@ -0,0 +1,30 @@
+//go:build !nopubsub
+
+package oidc_test
+
+import (
+	"context"
+	"testing"
+
+	gcpwif "github.com/PCS-Indonesia/pcs-oidc/oidc/google"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGoogleClientFactoryPubSub(t *testing.T) {
+	ctx := context.Background()
+	factory := gcpwif.NewGoogleClientFactory(wifConfig("https://sts.example.com/v1/token"))
+
+	c1, err := factory.PubSubClient(ctx, "project-a", "")
+	require.NoError(t, err)
+	c2, err := factory.PubSubClient(ctx, "project-a", "")
+	require.NoError(t, err)
+	require.Same(t, c1, c2)
+	c3, err := factory.PubSubClient(ctx, "project-b", "")
+	require.NoError(t, err)
+	require.NotSame(t, c1, c3)
+
+	require.NoError(t, factory.Close())
+	_, err = factory.PubSubClient(ctx, "project-a", "")
+	require.Error(t, err)
+}
@@ -0,0 +1,41 @@
+package oidc_test
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	gcpwif "github.com/PCS-Indonesia/pcs-oidc/oidc/google"
+	"google.golang.org/api/option"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGoogleClientFactory(t *testing.T) {
+	ctx := context.Background()
+	factory := gcpwif.NewGoogleClientFactory(wifConfig("https://sts.example.com/v1/token"))
+
+	ts1, err := factory.TokenSource(ctx, "", "scope-a", "scope-b")
+	require.NoError(t, err)
+	ts2, err := factory.TokenSource(ctx, "", "scope-b", "scope-a")
+	require.NoError(t, err)
+	require.Same(t, ts1, ts2)
+
+	other, err := factory.TokenSource(ctx, "//iam.googleapis.com/other", "scope-a")
+	require.NoError(t, err)
+	require.NotSame(t, ts1, other)
+
+	build := func(ctx context.Context, opts ...option.ClientOption) (io.Closer, error) {
+		return io.NopCloser(nil), nil
+	}
+	c1, err := factory.Client(ctx, "custom/project-a", "", nil, build)
+	require.NoError(t, err)
+	c2, err := factory.Client(ctx, "custom/project-a", "", nil, build)
+	require.NoError(t, err)
+	require.Equal(t, c1, c2)
+
+	// A closed factory hands out no more clients
+	require.NoError(t, factory.Close())
+	_, err = factory.Client(ctx, "custom/project-a", "", nil, build)
+	require.Error(t, err)
+}
@@ -0,0 +1,240 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	oidcprovider "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/idtoken"
+	"google.golang.org/api/impersonate"
+	"google.golang.org/api/option"
+)
+
+// Endpoints used by ServiceAccountIDTokenProvider when the key or provider does not override them.
+const (
+	DefaultGoogleTokenURL     = "https://oauth2.googleapis.com/token"
+	DefaultIAMCredentialsURL  = "https://iamcredentials.googleapis.com"
+	selfSignedIDTokenLifetime = time.Hour
+)
+
+// ServiceAccountKey is the part of a service account JSON key used to mint id_tokens.
+type ServiceAccountKey struct {
+	Type         string `json:"type"`
+	ClientEmail  string `json:"client_email"`
+	PrivateKeyID string `json:"private_key_id"`
+	PrivateKey   string `json:"private_key"`
+	TokenURI     string `json:"token_uri"`
+}
+
+// ParseServiceAccountKey parses a service account JSON key as downloaded from the Cloud console.
+func ParseServiceAccountKey(data []byte) (*ServiceAccountKey, error) {
+	var key ServiceAccountKey
+	if err := json.Unmarshal(data, &key); err != nil {
+		return nil, fmt.Errorf("failed to parse service account key: %w", err)
+	}
+	if key.Type != "service_account" {
+		return nil, fmt.Errorf("unsupported credentials type %q, expected a service_account key", key.Type)
+	}
+	if key.ClientEmail == "" || key.PrivateKey == "" {
+		return nil, errors.New("service account key is incomplete: client_email and private_key must be present")
+	}
+	return &key, nil
+}
+
+// IDTokenMode selects how ServiceAccountIDTokenProvider produces id_tokens.
+type IDTokenMode int
+
+const (
+	// IDTokenGoogleSigned exchanges a self-signed assertion at the Google token endpoint for a
+	// Google-signed id_token. It needs no IAM permission and is accepted by Cloud Run, Cloud
+	// Functions and IAP.
+	IDTokenGoogleSigned IDTokenMode = iota
+	// IDTokenSelfSigned signs the id_token with the key itself (iss and sub are the service account).
+	// Only services verifying against the service account's own certificates accept it, e.g. API
+	// Gateway and Cloud Endpoints.
+	IDTokenSelfSigned
+	// IDTokenIAM calls the IAM Credentials generateIdToken API, which can mint id_tokens for another
+	// service account (TargetPrincipal) when the key's account holds roles/iam.serviceAccountTokenCreator.
+	IDTokenIAM
+)
+
+// ServiceAccountIDTokenProvider implements oidcprovider.TokenProvider with audience-scoped id_tokens
+// for a service account key, for calling Cloud Run or IAP protected services from outside GCP.
+type ServiceAccountIDTokenProvider struct {
+	Key             *ServiceAccountKey
+	Audience        string // target audience, e.g. the Cloud Run URL or the IAP OAuth client ID
+	Mode            IDTokenMode
+	TargetPrincipal string   // IDTokenIAM only, default Key.ClientEmail
+	Delegates       []string // IDTokenIAM only
+	IAMEndpoint     string   // IDTokenIAM only, default DefaultIAMCredentialsURL
+	HTTPClient      *http.Client
+	OnEvent         oidcprovider.EventHandler // optional, receives request, fetched and failed events
+}
+
+// NewServiceAccountIDTokenProvider parses a service account JSON key and returns a provider minting
+// Google-signed id_tokens for audience.
+func NewServiceAccountIDTokenProvider(keyJSON []byte, audience string) (*ServiceAccountIDTokenProvider, error) {
+	key, err := ParseServiceAccountKey(keyJSON)
+	if err != nil {
+		return nil, err
+	}
+	return &ServiceAccountIDTokenProvider{Key: key, Audience: audience}, nil
+}
+
+// Kind returns the provider kind reported in snapshots.
+func (p *ServiceAccountIDTokenProvider) Kind() string {
+	return "google-idtoken"
+}
+
+// FetchToken returns a new id_token for the configured audience.
+func (p *ServiceAccountIDTokenProvider) FetchToken(ctx context.Context) (string, error) {
+	if p.Key == nil || p.Audience == "" {
+		return "", errors.New("service account id_token configuration is incomplete: Key and Audience must be provided")
+	}
+	emit(p.OnEvent, oidcprovider.Event{Type: oidcprovider.EventTokenRequest, Provider: p.Kind()})
+	start := time.Now()
+	token, err := p.fetch(ctx)
+	if err != nil {
+		emit(p.OnEvent, oidcprovider.Event{Type: oidcprovider.EventTokenFailed, Provider: p.Kind(), Duration: time.Since(start), Err: err})
+		return "", err
+	}
+	emit(p.OnEvent, oidcprovider.Event{Type: oidcprovider.EventTokenFetched, Provider: p.Kind(), Duration: time.Since(start)})
+	return token, nil
+}
+
+func (p *ServiceAccountIDTokenProvider) fetch(ctx context.Context) (string, error) {
+	if p.Mode == IDTokenSelfSigned {
+		return p.selfSigned()
+	}
+	keyJSON, err := json.Marshal(p.Key)
+	if err != nil {
+		return "", err
+	}
+	client := p.HTTPClient
+	if client == nil {
+		client = oidcprovider.NewHTTPClient(p.Kind(), false)
+	}
+	client, recorder := recordingHTTPClient(client, nil, p.Kind(), nil)
+	var ts oauth2.TokenSource
+	switch p.Mode {
+	case IDTokenGoogleSigned:
+		// idtoken exchanges a JWT-bearer assertion with target_audience at the key's token_uri
+		ts, err = idtoken.NewTokenSource(context.WithValue(ctx, oauth2.HTTPClient, client), p.Audience, option.WithCredentialsJSON(keyJSON))
+	case IDTokenIAM:
+		ts, err = p.iamTokenSource(ctx, keyJSON, client)
+	default:
+		return "", fmt.Errorf("unsupported id_token mode %d", p.Mode)
+	}
+	if err == nil {
+		var tok *oauth2.Token
+		src := &stsTokenSource{ctx: ctx, src: ts, recorder: recorder, provider: p.Kind()}
+		if tok, err = src.Token(); err == nil {
+			return tok.AccessToken, nil
+		}
+	}
+	// idtoken fetches the first token while creating the source, its errors need the same conversion
+	if tErr := asTokenError(p.Kind(), recorder, err); tErr != nil {
+		return "", tErr
+	}
+	return "", fmt.Errorf("failed to get id_token: %w", err)
+}
+
+// iamTokenSource returns an impersonate id_token source authenticated with a self-signed JWT access
+// token of the key for the IAM Credentials API.
+func (p *ServiceAccountIDTokenProvider) iamTokenSource(ctx context.Context, keyJSON []byte, client *http.Client) (oauth2.TokenSource, error) {
+	access, err := google.JWTAccessTokenSourceFromJSON(keyJSON, DefaultIAMCredentialsURL+"/")
+	if err != nil {
+		return nil, err
+	}
+	base := client.Transport
+	if endpoint := strings.TrimRight(p.IAMEndpoint, "/"); endpoint != "" && endpoint != DefaultIAMCredentialsURL {
+		target, err := url.Parse(endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IAMEndpoint: %w", err)
+		}
+		base = &endpointTransport{Base: base, Target: target}
+	}
+	target := p.TargetPrincipal
+	if target == "" {
+		target = p.Key.ClientEmail
+	}
+	return impersonate.IDTokenSource(ctx, impersonate.IDTokenConfig{
+		Audience:        p.Audience,
+		TargetPrincipal: target,
+		IncludeEmail:    true,
+		Delegates:       p.Delegates,
+	}, option.WithHTTPClient(&http.Client{Transport: &oauth2.Transport{Source: access, Base: base}}))
+}
+
+// selfSigned signs a JWT issued by the service account for the audience.
+func (p *ServiceAccountIDTokenProvider) selfSigned() (string, error) {
+	signer, err := oidcprovider.NewSignerFromPEM([]byte(p.Key.PrivateKey), p.Key.PrivateKeyID)
+	if err != nil {
+		return "", fmt.Errorf("failed to load service account private key: %w", err)
+	}
+	now := time.Now()
+	return signer.Sign(map[string]interface{}{
+		"iss": p.Key.ClientEmail,
+		"sub": p.Key.ClientEmail,
+		"aud": p.Audience,
+		"iat": now.Unix(),
+		"exp": now.Add(selfSignedIDTokenLifetime).Unix(),
+	}, nil)
+}
+
+// asTokenError converts the oauth2 retrieve error of the Google token endpoint into a
+// *oidcprovider.TokenError, nil when err is not one.
+func asTokenError(provider string, recorder *retryAfterRecorder, err error) *oidcprovider.TokenError {
+	var tErr *oidcprovider.TokenError
+	if errors.As(err, &tErr) {
+		return tErr
+	}
+	var rErr *oauth2.RetrieveError
+	if !errors.As(err, &rErr) || rErr.Response == nil {
+		return nil
+	}
+	code, description := decodeErrorBody(string(rErr.Body))
+	return &oidcprovider.TokenError{
+		Provider:    provider,
+		StatusCode:  rErr.Response.StatusCode,
+		Code:        code,
+		Description: description,
+		RetryAfter:  recorder.take(rErr.Response.StatusCode, string(rErr.Body)),
+		Err:         err,
+	}
+}
+
+// endpointTransport sends the requests the impersonate package addresses to
+// iamcredentials.googleapis.com to another endpoint, e.g. a private service connect address.
+type endpointTransport struct {
+	Base   http.RoundTripper
+	Target *url.URL
+}
+
+func (t *endpointTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme, req.URL.Host = t.Target.Scheme, t.Target.Host
+	req.URL.Path = strings.TrimRight(t.Target.Path, "/") + req.URL.Path
+	req.Host = ""
+	return t.Base.RoundTrip(req)
+}
+
+// emit calls h with ev if a handler is configured.
+func emit(h oidcprovider.EventHandler, ev oidcprovider.Event) {
+	if h == nil {
+		return
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	h(ev)
+}
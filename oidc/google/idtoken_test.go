@@ -0,0 +1,142 @@
+package oidc_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	gcpwif "github.com/PCS-Indonesia/pcs-oidc/oidc/google"
+	oidcprovider "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+// serviceAccountKeyJSON generates a service account JSON key whose token_uri is tokenURI
+func serviceAccountKeyJSON(t *testing.T, tokenURI string) []byte {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	data, err := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"client_email":   "caller@p.iam.gserviceaccount.com",
+		"private_key_id": "key-1",
+		"private_key":    string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"token_uri":      tokenURI,
+	})
+	require.NoError(t, err)
+	return data
+}
+
+// jwtClaims decodes the payload of a compact JWT without verifying it
+func jwtClaims(t *testing.T, token string) map[string]interface{} {
+	t.Helper()
+	parts := strings.Split(token, ".")
+	require.Len(t, parts, 3)
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	require.NoError(t, err)
+	var claims map[string]interface{}
+	require.NoError(t, json.Unmarshal(payload, &claims))
+	return claims
+}
+
+func TestServiceAccountIDTokenProvider(t *testing.T) {
+	const audience = "https://orders-abc123-uc.a.run.app"
+	// The Google token endpoint answers with a JWT, idtoken reads its exp
+	payload, err := json.Marshal(map[string]interface{}{"aud": audience, "exp": time.Now().Add(time.Hour).Unix()})
+	require.NoError(t, err)
+	googleSigned := "eyJhbGciOiJSUzI1NiJ9." + base64.RawURLEncoding.EncodeToString(payload) + ".sig"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			require.NoError(t, r.ParseForm())
+			require.Equal(t, "urn:ietf:params:oauth:grant-type:jwt-bearer", r.PostForm.Get("grant_type"))
+			claims := jwtClaims(t, r.PostForm.Get("assertion"))
+			require.Equal(t, audience, claims["target_audience"])
+			require.Equal(t, "caller@p.iam.gserviceaccount.com", claims["iss"])
+			_ = json.NewEncoder(w).Encode(map[string]string{"id_token": googleSigned})
+		case "/v1/projects/-/serviceAccounts/target@p.iam.gserviceaccount.com:generateIdToken":
+			access := jwtClaims(t, strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+			require.Equal(t, "https://iamcredentials.googleapis.com/", access["aud"])
+			var body struct {
+				Audience     string   `json:"audience"`
+				Delegates    []string `json:"delegates"`
+				IncludeEmail bool     `json:"includeEmail"`
+			}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			require.Equal(t, audience, body.Audience)
+			require.Equal(t, []string{"projects/-/serviceAccounts/hop@p.iam.gserviceaccount.com"}, body.Delegates)
+			require.True(t, body.IncludeEmail)
+			_, _ = w.Write([]byte(`{"token":"iam-signed"}`))
+		default:
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"error":{"code":403,"message":"Permission denied"}}`))
+		}
+	}))
+	t.Cleanup(srv.Close)
+	keyJSON := serviceAccountKeyJSON(t, srv.URL+"/token")
+	ctx := context.Background()
+
+	t.Run("google signed", func(t *testing.T) {
+		p, err := gcpwif.NewServiceAccountIDTokenProvider(keyJSON, audience)
+		require.NoError(t, err)
+		got, err := p.FetchToken(ctx)
+		require.NoError(t, err)
+		require.Equal(t, googleSigned, got)
+	})
+
+	t.Run("google signed rejected", func(t *testing.T) {
+		p, err := gcpwif.NewServiceAccountIDTokenProvider(serviceAccountKeyJSON(t, srv.URL+"/denied"), audience)
+		require.NoError(t, err)
+		_, err = p.FetchToken(ctx)
+		var tErr *oidcprovider.TokenError
+		require.True(t, errors.As(err, &tErr))
+		require.Equal(t, http.StatusForbidden, tErr.StatusCode)
+		require.Equal(t, "Permission denied", tErr.Description)
+	})
+
+	t.Run("self signed", func(t *testing.T) {
+		p, err := gcpwif.NewServiceAccountIDTokenProvider(keyJSON, audience)
+		require.NoError(t, err)
+		p.Mode = gcpwif.IDTokenSelfSigned
+		got, err := oidcprovider.NewTokenCache(p).GetValidToken(ctx)
+		require.NoError(t, err)
+		claims := jwtClaims(t, got)
+		require.Equal(t, audience, claims["aud"])
+		require.Equal(t, "caller@p.iam.gserviceaccount.com", claims["sub"])
+	})
+
+	t.Run("iam", func(t *testing.T) {
+		p, err := gcpwif.NewServiceAccountIDTokenProvider(keyJSON, audience)
+		require.NoError(t, err)
+		p.Mode = gcpwif.IDTokenIAM
+		p.IAMEndpoint = srv.URL
+		p.TargetPrincipal = "target@p.iam.gserviceaccount.com"
+		p.Delegates = []string{"hop@p.iam.gserviceaccount.com"}
+		got, err := p.FetchToken(ctx)
+		require.NoError(t, err)
+		require.Equal(t, "iam-signed", got)
+
+		p.TargetPrincipal = "other@p.iam.gserviceaccount.com"
+		_, err = p.FetchToken(ctx)
+		var tErr *oidcprovider.TokenError
+		require.True(t, errors.As(err, &tErr))
+		require.Equal(t, http.StatusForbidden, tErr.StatusCode)
+	})
+
+	t.Run("invalid key", func(t *testing.T) {
+		_, err := gcpwif.NewServiceAccountIDTokenProvider([]byte(`{"type":"authorized_user"}`), audience)
+		require.ErrorContains(t, err, "service_account")
+	})
+}
@@ -0,0 +1,125 @@
+package oidc
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	oidcprovider "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/impersonate"
+	"google.golang.org/api/option"
+)
+
+// cloudPlatformScope is the scope of the ADC credentials calling the IAM Credentials API
+const cloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
+
+// ImpersonationConfig holds the settings to impersonate a service account with the Application
+// Default Credentials of the process, without Workload Identity Federation. The ADC principal needs
+// roles/iam.serviceAccountTokenCreator on TargetPrincipal (or on the first of Delegates).
+// HTTPClient is optional and must already be authenticated; when nil an ADC client that honours the
+// provider package debug mode (SetDebug) is used.
+// Retry and OnEvent behave like their WIFConfig counterparts; OnEvent also receives an impersonation
+// event per minted token or failed attempt, naming TargetPrincipal, Delegates, Scopes, Lifetime and Caller.
+type ImpersonationConfig struct {
+	TargetPrincipal string   // service account email
+	Scopes          []string // default cloud-platform
+	Delegates       []string
+	Lifetime        time.Duration // token lifetime, default 1h; tokens with a set lifetime are not refreshed
+	HTTPClient      *http.Client
+	Retry           *oidcprovider.RetryPolicy
+	OnEvent         oidcprovider.EventHandler
+	Caller          map[string]string // identity claims of the caller reported in impersonation events
+	SkewRetryDelay  time.Duration     // wait before retrying a clock skew rejection, default oidcprovider.DefaultSkewRetryDelay
+}
+
+// GetImpersonatedTokenSource returns an oauth2.TokenSource for TargetPrincipal built on
+// impersonate.CredentialsTokenSource. It mirrors GetGCPTokenSource so on-GCP code (ADC) and
+// off-GCP code (WIF) share one API: tokens are cached until shortly before expiry, failed calls are
+// returned as *oidcprovider.TokenError and IAM Credentials requests are reported to OnEvent.
+func GetImpersonatedTokenSource(ctx context.Context, cfg ImpersonationConfig) (oauth2.TokenSource, error) {
+	if cfg.TargetPrincipal == "" {
+		return nil, fmt.Errorf("missing required ImpersonationConfig fields")
+	}
+	scopes := cfg.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{cloudPlatformScope}
+	}
+	client := cfg.HTTPClient
+	if client == nil {
+		creds, err := google.DefaultTokenSource(ctx, cloudPlatformScope)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find default credentials for impersonation: %w", err)
+		}
+		client = &http.Client{Transport: &oauth2.Transport{
+			Source: creds,
+			Base:   oidcprovider.NewHTTPClient("iamcredentials", false).Transport,
+		}}
+	}
+	client, recorder := recordingHTTPClient(client, cfg.Retry, "iamcredentials", cfg.OnEvent)
+	var audit *impersonationAudit
+	if cfg.OnEvent != nil {
+		audit = &impersonationAudit{
+			Provider: "iamcredentials",
+			OnEvent:  cfg.OnEvent,
+			Impersonation: oidcprovider.Impersonation{
+				ServiceAccount: cfg.TargetPrincipal,
+				Delegates:      cfg.Delegates,
+				Scopes:         scopes,
+				Lifetime:       cfg.Lifetime,
+				Caller:         cfg.Caller,
+			},
+		}
+	}
+
+	// The impersonate package caches the token itself and refreshes it shortly before expiry
+	// With a set lifetime it mints the only token right here
+	start := time.Now()
+	ts, err := impersonate.CredentialsTokenSource(ctx, impersonate.CredentialsConfig{
+		TargetPrincipal: cfg.TargetPrincipal,
+		Scopes:          scopes,
+		Delegates:       cfg.Delegates,
+		Lifetime:        cfg.Lifetime,
+	}, option.WithHTTPClient(client))
+	if audit != nil && cfg.Lifetime != 0 {
+		var tok *oauth2.Token
+		if err == nil {
+			tok, err = ts.Token()
+		}
+		audit.report(start, tok, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create impersonated token source: %w", err)
+	}
+	var src oauth2.TokenSource = &stsTokenSource{ctx: ctx, src: ts, recorder: recorder, provider: "iamcredentials", skewDelay: cfg.SkewRetryDelay}
+	if audit != nil {
+		audit.Source = src
+		src = audit
+	}
+	return src, nil
+}
+
+// eventTransport reports every request it sends to an EventHandler
+type eventTransport struct {
+	Base     http.RoundTripper
+	Provider string
+	OnEvent  oidcprovider.EventHandler
+}
+
+func (t *eventTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.OnEvent(oidcprovider.Event{Type: oidcprovider.EventTokenRequest, Provider: t.Provider, Time: time.Now()})
+	start := time.Now()
+	resp, err := t.Base.RoundTrip(req)
+	ev := oidcprovider.Event{Type: oidcprovider.EventTokenFetched, Provider: t.Provider, Time: time.Now(), Duration: time.Since(start)}
+	switch {
+	case err != nil:
+		ev.Type, ev.Err = oidcprovider.EventTokenFailed, err
+	case resp.StatusCode >= http.StatusBadRequest:
+		ev.Type, ev.Err = oidcprovider.EventTokenFailed, fmt.Errorf("%s returned status %d", t.Provider, resp.StatusCode)
+	}
+	t.OnEvent(ev)
+	return resp, err
+}
@@ -0,0 +1,97 @@
+package oidc_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	gcpwif "github.com/PCS-Indonesia/pcs-oidc/oidc/google"
+	oidcprovider "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+// redirectTransport sends every request to target, standing in for iamcredentials.googleapis.com
+type redirectTransport struct {
+	target *url.URL
+}
+
+func (t *redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme, req.URL.Host = t.target.Scheme, t.target.Host
+	req.Header.Set("Authorization", "Bearer adc")
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestGetImpersonatedTokenSource(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if r.URL.Path == "/v1/projects/-/serviceAccounts/denied@p.iam.gserviceaccount.com:generateAccessToken" {
+			w.Header().Set("Retry-After", "5")
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"error":{"code":403,"message":"Permission 'iam.serviceAccounts.getAccessToken' denied","status":"PERMISSION_DENIED"}}`))
+			return
+		}
+		require.Equal(t, "/v1/projects/-/serviceAccounts/app@p.iam.gserviceaccount.com:generateAccessToken", r.URL.Path)
+		require.Equal(t, "Bearer adc", r.Header.Get("Authorization"))
+		var body struct {
+			Scope []string `json:"scope"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		require.Equal(t, []string{"https://www.googleapis.com/auth/cloud-platform"}, body.Scope)
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"accessToken": "impersonated",
+			"expireTime":  time.Now().Add(time.Hour).UTC().Format(time.RFC3339),
+		})
+	}))
+	t.Cleanup(srv.Close)
+	target, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+	client := &http.Client{Transport: &redirectTransport{target: target}}
+
+	var mu sync.Mutex
+	var events []oidcprovider.EventType
+	cfg := gcpwif.ImpersonationConfig{
+		TargetPrincipal: "app@p.iam.gserviceaccount.com",
+		HTTPClient:      client,
+		OnEvent: func(ev oidcprovider.Event) {
+			mu.Lock()
+			defer mu.Unlock()
+			require.Equal(t, "iamcredentials", ev.Provider)
+			events = append(events, ev.Type)
+		},
+	}
+	ts, err := gcpwif.GetImpersonatedTokenSource(context.Background(), cfg)
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		tok, err := ts.Token()
+		require.NoError(t, err)
+		require.Equal(t, "impersonated", tok.AccessToken)
+	}
+	require.EqualValues(t, 1, calls.Load())
+	require.Equal(t, []oidcprovider.EventType{oidcprovider.EventTokenRequest, oidcprovider.EventTokenFetched, oidcprovider.EventImpersonation}, events)
+
+	cfg.TargetPrincipal = "denied@p.iam.gserviceaccount.com"
+	cfg.OnEvent = nil
+	ts, err = gcpwif.GetImpersonatedTokenSource(context.Background(), cfg)
+	require.NoError(t, err)
+	_, err = ts.Token()
+	var tErr *oidcprovider.TokenError
+	require.True(t, errors.As(err, &tErr))
+	require.Equal(t, "iamcredentials", tErr.Provider)
+	require.Equal(t, http.StatusForbidden, tErr.StatusCode)
+	require.Equal(t, "PERMISSION_DENIED", tErr.Code)
+	require.Equal(t, "Permission 'iam.serviceAccounts.getAccessToken' denied", tErr.Description)
+	require.Equal(t, 5*time.Second, tErr.RetryAfter)
+
+	_, err = gcpwif.GetImpersonatedTokenSource(context.Background(), gcpwif.ImpersonationConfig{})
+	require.Error(t, err)
+}
@@ -0,0 +1,52 @@
+package oidc
+
+import (
+	"fmt"
+	"net/http"
+
+	oidcprovider "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+)
+
+// Lint returns advisory warnings for cfg: settings that work but are likely mistakes, e.g. disabled TLS
+// verification or broad scopes. Unlike GetGCPTokenSource it never fails, so a doctor command or the
+// start-up logs (see oidcprovider.LogLint) can report all findings at once.
+func Lint(cfg SetupConfig) []oidcprovider.LintWarning {
+	wif := cfg.WIF
+	var warnings []oidcprovider.LintWarning
+	if insecureClient(wif.HTTPClient) {
+		warnings = append(warnings, oidcprovider.LintWarning{Code: oidcprovider.LintInsecureTLS, Source: "wif", Field: "HTTPClient",
+			Message: "TLS verification of the STS and impersonation calls is disabled, use it in development only"})
+	}
+	if wif.Leeway <= 0 {
+		warnings = append(warnings, oidcprovider.LintWarning{Code: oidcprovider.LintNoLeeway, Source: "wif", Field: "Leeway",
+			Message: fmt.Sprintf("no leeway set, the default of %s is used; set it above the duration of the longest call using the token", DefaultLeeway)})
+	}
+	warnings = append(warnings, oidcprovider.LintScopes("wif", "Scopes", wif.Scopes)...)
+	if wif.ServiceAccountImpersonationURL == "" && hasScope(wif.Scopes, cloudPlatformScope) {
+		warnings = append(warnings, oidcprovider.LintWarning{Code: oidcprovider.LintMissingImpersonation, Source: "wif", Field: "ServiceAccountImpersonationURL",
+			Message: "cloud-platform is requested without service account impersonation: the federated identity needs direct IAM bindings and some APIs reject federated tokens"})
+	}
+	if kc := cfg.Keycloak; kc != nil {
+		warnings = append(warnings, oidcprovider.LintScopes("keycloak", "KeycloakClientScopes", kc.KeycloakClientScopes)...)
+	}
+	return warnings
+}
+
+// insecureClient reports whether client skips TLS verification.
+func insecureClient(client *http.Client) bool {
+	if client == nil {
+		return false
+	}
+	t, ok := client.Transport.(*http.Transport)
+	return ok && t.TLSClientConfig != nil && t.TLSClientConfig.InsecureSkipVerify
+}
+
+// hasScope reports whether scopes contains scope.
+func hasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,59 @@
+package oidc_test
+
+import (
+	"crypto/tls"
+	"net/http"
+	"testing"
+	"time"
+
+	gcpwif "github.com/PCS-Indonesia/pcs-oidc/oidc/google"
+	oidcprovider "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+func lintCodes(warnings []oidcprovider.LintWarning) []string {
+	codes := make([]string, 0, len(warnings))
+	for _, w := range warnings {
+		codes = append(codes, w.Code)
+	}
+	return codes
+}
+
+func TestLint(t *testing.T) {
+	t.Run("clean configuration", func(t *testing.T) {
+		cfg := gcpwif.SetupConfig{WIF: gcpwif.WIFConfig{
+			Scopes: []string{"https://www.googleapis.com/auth/pubsub"},
+			Leeway: 2 * time.Minute,
+		}}
+		require.Empty(t, gcpwif.Lint(cfg))
+	})
+
+	t.Run("cloud-platform without impersonation", func(t *testing.T) {
+		cfg := gcpwif.SetupConfig{
+			WIF: gcpwif.WIFConfig{
+				Scopes:     []string{"https://www.googleapis.com/auth/cloud-platform"},
+				HTTPClient: &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}},
+			},
+			Keycloak: &oidcprovider.ConfigKeyCloak{KeycloakClientScopes: []string{"*"}},
+		}
+		warnings := gcpwif.Lint(cfg)
+		require.Equal(t, []string{
+			oidcprovider.LintInsecureTLS,
+			oidcprovider.LintNoLeeway,
+			oidcprovider.LintBroadScope,
+			oidcprovider.LintMissingImpersonation,
+			oidcprovider.LintBroadScope,
+		}, lintCodes(warnings))
+		require.Equal(t, "keycloak", warnings[4].Source)
+	})
+
+	t.Run("impersonation silences the cloud-platform hint", func(t *testing.T) {
+		cfg := gcpwif.SetupConfig{WIF: gcpwif.WIFConfig{
+			Scopes:                         []string{"https://www.googleapis.com/auth/cloud-platform"},
+			ServiceAccountImpersonationURL: "https://iamcredentials.googleapis.com/v1/projects/-/serviceAccounts/sa@p.iam.gserviceaccount.com:generateAccessToken",
+			Leeway:                         time.Minute,
+		}}
+		require.Equal(t, []string{oidcprovider.LintBroadScope}, lintCodes(gcpwif.Lint(cfg)))
+	})
+}
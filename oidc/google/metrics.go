@@ -0,0 +1,102 @@
+package oidc
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	oidcprovider "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+)
+
+// ExchangeStats reports the Google side of token acquisition, separate from the IdP metrics of the
+// provider package: STS slowness or errors call for different remediation than IdP slowness.
+type ExchangeStats struct {
+	STS           EndpointStats // sts.googleapis.com token exchanges
+	Impersonation EndpointStats // IAM Credentials generateAccessToken / generateIdToken calls
+}
+
+// EndpointStats counts the requests sent to one Google endpoint, every retry attempt included.
+// Canceled requests say nothing about the endpoint, so they are kept out of Failures and ErrorCodes;
+// ErrorCodes holds the OAuth error of STS ("invalid_grant"), the status of IAM Credentials
+// ("PERMISSION_DENIED"), "http_<status>" when the body has none, or "transport".
+type EndpointStats = oidcprovider.FetchStats
+
+// exchangeMetrics holds the process wide ExchangeStats.
+var exchangeMetrics struct {
+	mu            sync.Mutex
+	sts           EndpointStats
+	impersonation EndpointStats
+}
+
+// ExchangeMetrics returns the STS and impersonation metrics of every token source of the process.
+func ExchangeMetrics() ExchangeStats {
+	exchangeMetrics.mu.Lock()
+	defer exchangeMetrics.mu.Unlock()
+	return ExchangeStats{STS: exchangeMetrics.sts.Clone(), Impersonation: exchangeMetrics.impersonation.Clone()}
+}
+
+// metricsTransport records the latency and error code of every STS and impersonation request.
+type metricsTransport struct {
+	Base http.RoundTripper
+}
+
+func (t *metricsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.Base.RoundTrip(req)
+	latency := time.Since(start)
+	var code string
+	switch {
+	case err != nil && req.Context().Err() != nil:
+		code = oidcprovider.FetchCanceled
+	case err != nil:
+		code = "transport"
+	case resp.StatusCode >= http.StatusBadRequest:
+		code = errorCode(resp)
+	}
+	exchangeMetrics.mu.Lock()
+	if isImpersonationRequest(req) {
+		exchangeMetrics.impersonation.Record(latency, code)
+	} else {
+		exchangeMetrics.sts.Record(latency, code)
+	}
+	exchangeMetrics.mu.Unlock()
+	return resp, err
+}
+
+// isImpersonationRequest reports whether req targets the IAM Credentials API.
+func isImpersonationRequest(req *http.Request) bool {
+	path := req.URL.Path
+	return strings.HasSuffix(path, ":generateAccessToken") || strings.HasSuffix(path, ":generateIdToken") ||
+		strings.HasPrefix(req.URL.Host, "iamcredentials.")
+}
+
+// errorCode extracts the error code of a failed response, restoring the body for the caller.
+func errorCode(resp *http.Response) string {
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	fallback := "http_" + strconv.Itoa(resp.StatusCode)
+	if err != nil {
+		return fallback
+	}
+	var oauthErr struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(body, &oauthErr) == nil && oauthErr.Error != "" {
+		return oauthErr.Error
+	}
+	var apiErr struct {
+		Error struct {
+			Status string `json:"status"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(body, &apiErr) == nil && apiErr.Error.Status != "" {
+		return apiErr.Error.Status
+	}
+	return fallback
+}
@@ -0,0 +1,89 @@
+package oidc_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	gcpwif "github.com/PCS-Indonesia/pcs-oidc/oidc/google"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExchangeMetrics(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("STS latency and error codes", func(t *testing.T) {
+		before := gcpwif.ExchangeMetrics()
+		ok := newFakeSTS(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"access_token":"gcp","issued_token_type":"urn:ietf:params:oauth:token-type:access_token","token_type":"Bearer","expires_in":3600}`))
+		})
+		ts, err := gcpwif.GetGCPTokenSource(ctx, wifConfig(ok))
+		require.NoError(t, err)
+		_, err = ts.Token()
+		require.NoError(t, err)
+
+		denied := newFakeSTS(t, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"invalid_grant","error_description":"audience mismatch"}`))
+		})
+		ts, err = gcpwif.GetGCPTokenSource(ctx, wifConfig(denied))
+		require.NoError(t, err)
+		_, err = ts.Token()
+		require.Error(t, err)
+
+		after := gcpwif.ExchangeMetrics()
+		require.Equal(t, before.STS.Requests+2, after.STS.Requests)
+		require.Equal(t, before.STS.Failures+1, after.STS.Failures)
+		require.Equal(t, before.STS.ErrorCodes["invalid_grant"]+1, after.STS.ErrorCodes["invalid_grant"])
+		require.Positive(t, after.STS.LastLatency)
+		require.GreaterOrEqual(t, after.STS.MaxLatency, after.STS.LastLatency)
+		require.Positive(t, after.STS.AverageLatency())
+		require.Equal(t, before.Impersonation.Requests, after.Impersonation.Requests)
+	})
+
+	t.Run("impersonation is counted separately", func(t *testing.T) {
+		before := gcpwif.ExchangeMetrics()
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"error":{"code":403,"status":"PERMISSION_DENIED"}}`))
+		}))
+		t.Cleanup(srv.Close)
+		target, err := url.Parse(srv.URL)
+		require.NoError(t, err)
+
+		ts, err := gcpwif.GetImpersonatedTokenSource(ctx, gcpwif.ImpersonationConfig{
+			TargetPrincipal: "app@p.iam.gserviceaccount.com",
+			HTTPClient:      &http.Client{Transport: &redirectTransport{target: target}},
+		})
+		require.NoError(t, err)
+		_, err = ts.Token()
+		require.Error(t, err)
+
+		after := gcpwif.ExchangeMetrics()
+		require.Equal(t, before.Impersonation.Requests+1, after.Impersonation.Requests)
+		require.Equal(t, before.Impersonation.ErrorCodes["PERMISSION_DENIED"]+1, after.Impersonation.ErrorCodes["PERMISSION_DENIED"])
+		require.Equal(t, before.STS.Requests, after.STS.Requests)
+	})
+
+	t.Run("canceled requests are not failures", func(t *testing.T) {
+		before := gcpwif.ExchangeMetrics()
+		slow := newFakeSTS(t, func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+		})
+		canceled, cancel := context.WithCancel(ctx)
+		ts, err := gcpwif.GetGCPTokenSource(canceled, wifConfig(slow))
+		require.NoError(t, err)
+		cancel()
+		_, err = ts.Token()
+		require.Error(t, err)
+
+		after := gcpwif.ExchangeMetrics()
+		require.Equal(t, before.STS.Canceled+1, after.STS.Canceled)
+		require.Equal(t, before.STS.Failures, after.STS.Failures)
+		require.Equal(t, before.STS.ErrorCodes["transport"], after.STS.ErrorCodes["transport"])
+	})
+}
@@ -0,0 +1,161 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	oidcprovider "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google/externalaccount"
+)
+
+// WIFProvider is one provider of a workload identity pool and the IdP it trusts.
+type WIFProvider struct {
+	ID     string // provider ID, e.g. "keycloak-prod"
+	Issuer string // iss of the subject tokens the provider accepts, e.g. the Keycloak realm URL
+}
+
+// MultiProviderConfig configures several providers of one pool, e.g. keycloak-prod and keycloak-dr,
+// so the exchange keeps working when a disaster recovery switch changes the issuer of subject tokens.
+// WIF is the template of every exchange; its Audience is replaced by the audience of each provider.
+type MultiProviderConfig struct {
+	WIF WIFConfig
+	// Pool is the pool resource name, e.g.
+	// //iam.googleapis.com/projects/123/locations/global/workloadIdentityPools/pcs.
+	Pool      string
+	Providers []WIFProvider // in order of preference
+}
+
+// ProviderAudience returns the STS audience of providerID in pool.
+func ProviderAudience(pool, providerID string) string {
+	return strings.TrimRight(pool, "/") + "/providers/" + providerID
+}
+
+// GetMultiProviderTokenSource returns a token source exchanging the subject token with the provider
+// trusting its issuer. The subject token is fetched once and shared by all providers. When the
+// exchange fails, the remaining candidates are tried in order and a fallback event is reported to
+// WIF.OnEvent. A subject token whose issuer matches a provider is only sent to the providers of that
+// issuer and to those without Issuer, never to providers configured for another IdP; otherwise
+// (opaque token, unknown issuer) every provider is a candidate, so a stale issuer mapping degrades to
+// extra STS calls instead of an outage.
+func GetMultiProviderTokenSource(ctx context.Context, cfg MultiProviderConfig) (oauth2.TokenSource, error) {
+	if cfg.Pool == "" || len(cfg.Providers) == 0 {
+		return nil, errors.New("multi-provider configuration is incomplete: Pool and Providers must be provided")
+	}
+	if cfg.WIF.TokenSupplier == nil {
+		return nil, errors.New("multi-provider configuration needs a TokenSupplier")
+	}
+	shared := &sharedSubjectSupplier{base: cfg.WIF.TokenSupplier}
+	m := &multiProviderSource{ctx: ctx, cfg: cfg, shared: shared}
+	for _, p := range cfg.Providers {
+		if p.ID == "" {
+			return nil, errors.New("multi-provider configuration has a provider without ID")
+		}
+		c := cfg.WIF
+		c.Audience = ProviderAudience(cfg.Pool, p.ID)
+		c.TokenSupplier = shared
+		ts, err := GetGCPTokenSource(ctx, c)
+		if err != nil {
+			return nil, fmt.Errorf("provider %s: %w", p.ID, err)
+		}
+		m.sources = append(m.sources, ts)
+	}
+	return m, nil
+}
+
+// multiProviderSource routes each exchange to the provider matching the subject token's issuer.
+type multiProviderSource struct {
+	ctx     context.Context
+	cfg     MultiProviderConfig
+	shared  *sharedSubjectSupplier
+	sources []oauth2.TokenSource // per provider, same order as cfg.Providers
+
+	mu   sync.Mutex
+	last int // provider that succeeded last, preferred when the issuer matches no provider
+}
+
+func (m *multiProviderSource) Token() (*oauth2.Token, error) {
+	subject, err := m.shared.SubjectToken(m.ctx, externalaccount.SupplierOptions{
+		Audience:         ProviderAudience(m.cfg.Pool, m.cfg.Providers[0].ID),
+		SubjectTokenType: m.cfg.WIF.SubjectTokenType,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var errs []error
+	order := m.order(subjectIssuer(subject))
+	for n, i := range order {
+		tok, err := m.sources[i].Token()
+		if err == nil {
+			m.mu.Lock()
+			m.last = i
+			m.mu.Unlock()
+			return tok, nil
+		}
+		errs = append(errs, fmt.Errorf("provider %s: %w", m.cfg.Providers[i].ID, err))
+		if m.ctx.Err() != nil {
+			break
+		}
+		if n < len(order)-1 {
+			emit(m.cfg.WIF.OnEvent, oidcprovider.Event{Type: oidcprovider.EventFallback, Provider: "sts:" + m.cfg.Providers[i].ID, Err: err})
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+// order returns the provider indexes to try. When issuer matches a provider, those trusting it come
+// first, then the providers without Issuer; providers of other issuers never see the token. Otherwise
+// the one that succeeded last comes first, then the rest in configuration order.
+func (m *multiProviderSource) order(issuer string) []int {
+	m.mu.Lock()
+	last := m.last
+	m.mu.Unlock()
+	var order []int
+	seen := make([]bool, len(m.cfg.Providers))
+	add := func(i int) {
+		if !seen[i] {
+			seen[i] = true
+			order = append(order, i)
+		}
+	}
+	for i, p := range m.cfg.Providers {
+		if issuer != "" && sameIssuer(p.Issuer, issuer) {
+			add(i)
+		}
+	}
+	if len(order) > 0 {
+		if m.cfg.Providers[last].Issuer == "" {
+			add(last)
+		}
+		for i, p := range m.cfg.Providers {
+			if p.Issuer == "" {
+				add(i)
+			}
+		}
+		return order
+	}
+	add(last)
+	for i := range m.cfg.Providers {
+		add(i)
+	}
+	return order
+}
+
+// sameIssuer compares issuer URLs ignoring a trailing slash.
+func sameIssuer(a, b string) bool {
+	return strings.TrimRight(a, "/") == strings.TrimRight(b, "/")
+}
+
+// subjectIssuer returns the iss claim of a JWT subject token, empty for opaque tokens.
+func subjectIssuer(token string) string {
+	claims, err := oidcprovider.DecodeJWTClaims(token, false)
+	if err != nil {
+		return ""
+	}
+	iss, _ := claims["iss"].(string)
+	return iss
+}
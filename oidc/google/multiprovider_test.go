@@ -0,0 +1,139 @@
+package oidc_test
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	gcpwif "github.com/PCS-Indonesia/pcs-oidc/oidc/google"
+	oidcprovider "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testPool = "//iam.googleapis.com/projects/1/locations/global/workloadIdentityPools/p"
+
+// subjectWithIssuer returns an unsigned JWT subject token issued by iss
+func subjectWithIssuer(iss string) string {
+	enc := base64.RawURLEncoding.EncodeToString
+	return enc([]byte(`{"alg":"none"}`)) + "." + enc([]byte(`{"iss":"`+iss+`"}`)) + ".sig"
+}
+
+func TestMultiProviderTokenSource(t *testing.T) {
+	ctx := context.Background()
+	providers := []gcpwif.WIFProvider{
+		{ID: "keycloak-prod", Issuer: "https://sso.example.com/realms/pcs"},
+		{ID: "keycloak-dr", Issuer: "https://sso-dr.example.com/realms/pcs"},
+	}
+
+	// newSTS accepts only the providers in accepted and records the audience of every request
+	newSTS := func(t *testing.T, accepted ...string) (string, func() []string) {
+		var mu sync.Mutex
+		var audiences []string
+		tokenURL := newFakeSTS(t, func(w http.ResponseWriter, r *http.Request) {
+			require.NoError(t, r.ParseForm())
+			audience := r.PostForm.Get("audience")
+			mu.Lock()
+			audiences = append(audiences, audience)
+			mu.Unlock()
+			for _, id := range accepted {
+				if strings.HasSuffix(audience, "/providers/"+id) {
+					_, _ = w.Write([]byte(`{"access_token":"gcp-` + id + `","issued_token_type":"urn:ietf:params:oauth:token-type:access_token","token_type":"Bearer","expires_in":3600}`))
+					return
+				}
+			}
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"invalid_grant","error_description":"issuer mismatch"}`))
+		})
+		return tokenURL, func() []string {
+			mu.Lock()
+			defer mu.Unlock()
+			return append([]string(nil), audiences...)
+		}
+	}
+
+	// config records the fallback events in events when it is not nil
+	config := func(tokenURL, subject string, events *[]oidcprovider.Event) gcpwif.MultiProviderConfig {
+		wif := wifConfig(tokenURL)
+		wif.TokenSupplier = &gcpwif.StaticTokenSupplier{Token: subject}
+		if events != nil {
+			wif.OnEvent = func(ev oidcprovider.Event) {
+				if ev.Type == oidcprovider.EventFallback {
+					*events = append(*events, ev)
+				}
+			}
+		}
+		return gcpwif.MultiProviderConfig{WIF: wif, Pool: testPool, Providers: providers}
+	}
+
+	t.Run("issuer selects the provider", func(t *testing.T) {
+		tokenURL, audiences := newSTS(t, "keycloak-dr")
+		var events []oidcprovider.Event
+		ts, err := gcpwif.GetMultiProviderTokenSource(ctx, config(tokenURL, subjectWithIssuer("https://sso-dr.example.com/realms/pcs"), &events))
+		require.NoError(t, err)
+		tok, err := ts.Token()
+		require.NoError(t, err)
+		require.Equal(t, "gcp-keycloak-dr", tok.AccessToken)
+		require.Equal(t, []string{gcpwif.ProviderAudience(testPool, "keycloak-dr")}, audiences())
+		require.Empty(t, events)
+	})
+
+	t.Run("falls back when the issuer is unknown", func(t *testing.T) {
+		tokenURL, audiences := newSTS(t, "keycloak-dr")
+		var events []oidcprovider.Event
+		ts, err := gcpwif.GetMultiProviderTokenSource(ctx, config(tokenURL, subjectWithIssuer("https://other.example.com"), &events))
+		require.NoError(t, err)
+		tok, err := ts.Token()
+		require.NoError(t, err)
+		require.Equal(t, "gcp-keycloak-dr", tok.AccessToken)
+		require.Len(t, audiences(), 2)
+		require.Len(t, events, 1)
+		require.Equal(t, oidcprovider.EventFallback, events[0].Type)
+		require.Equal(t, "sts:keycloak-prod", events[0].Provider)
+	})
+
+	t.Run("matching issuer never reaches the providers of other issuers", func(t *testing.T) {
+		// keycloak-prod would accept the token but trusts another issuer
+		tokenURL, audiences := newSTS(t, "keycloak-prod", "shared")
+		cfg := config(tokenURL, subjectWithIssuer("https://sso-dr.example.com/realms/pcs/"), nil)
+		cfg.Providers = append(cfg.Providers, gcpwif.WIFProvider{ID: "shared"})
+		ts, err := gcpwif.GetMultiProviderTokenSource(ctx, cfg)
+		require.NoError(t, err)
+		tok, err := ts.Token()
+		require.NoError(t, err)
+		require.Equal(t, "gcp-shared", tok.AccessToken)
+		require.Equal(t, []string{
+			gcpwif.ProviderAudience(testPool, "keycloak-dr"),
+			gcpwif.ProviderAudience(testPool, "shared"),
+		}, audiences())
+	})
+
+	t.Run("all providers failing joins the errors", func(t *testing.T) {
+		tokenURL, _ := newSTS(t)
+		ts, err := gcpwif.GetMultiProviderTokenSource(ctx, config(tokenURL, "opaque", nil))
+		require.NoError(t, err)
+		_, err = ts.Token()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "provider keycloak-prod")
+		require.Contains(t, err.Error(), "provider keycloak-dr")
+	})
+
+	t.Run("incomplete configuration", func(t *testing.T) {
+		cfg := config("http://127.0.0.1/v1/token", "subject", nil)
+		cfg.Providers = nil
+		_, err := gcpwif.GetMultiProviderTokenSource(ctx, cfg)
+		require.Error(t, err)
+
+		cfg = config("http://127.0.0.1/v1/token", "subject", nil)
+		cfg.WIF.TokenSupplier = nil
+		_, err = gcpwif.GetMultiProviderTokenSource(ctx, cfg)
+		require.Error(t, err)
+	})
+
+	t.Run("audience of a provider", func(t *testing.T) {
+		require.Equal(t, testPool+"/providers/keycloak-prod", gcpwif.ProviderAudience(testPool+"/", "keycloak-prod"))
+	})
+}
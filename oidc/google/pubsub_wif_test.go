@@ -1,3 +1,5 @@
+//go:build !nopubsub
+
 package oidc_test
 
 import (
@@ -6,6 +8,8 @@ import (
 	"testing"
 	"time"
 
+	gcpwif "github.com/PCS-Indonesia/pcs-oidc/oidc/google"
+
 	"cloud.google.com/go/pubsub"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/oauth2"
@@ -50,3 +54,66 @@ func TestPublishToPubSubWithExternalWIFToken(t *testing.T) {
 	require.NoError(t, err)
 	t.Logf("Published message with ID: %s", id)
 }
+
+func TestMultipleWIFTokensAreValidForPubSub(t *testing.T) {
+	ctx := context.Background()
+	validToken := "YOUR_TOKEN_ACCESS" // JWT with exp in the far future, ganti dengan token valid
+
+	supplier := &gcpwif.StaticTokenSupplier{Token: validToken}
+	cfg := gcpwif.NewWIFConfig(
+		"YOUR_AUDIENCE",            // Change to your audience
+		"YOUR_SUBJECT_TOKEN_TYPE",  // Change to your subject token type
+		"YOUR_TOKEN_URL",           // Change to your token URL
+		[]string{"YOUR_SCOPES"},    // Change to your scopes
+		"YOUR_SERVICE_ACCOUNT_URL", // Change to your service account URL
+		supplier,
+	)
+
+	ts1, err := gcpwif.GetGCPTokenSource(ctx, cfg)
+	require.NoError(t, err)
+	token1, err := ts1.Token()
+	require.NoError(t, err)
+	require.NotEmpty(t, token1.AccessToken)
+
+	ts2, err := gcpwif.GetGCPTokenSource(ctx, cfg)
+	require.NoError(t, err)
+	token2, err := ts2.Token()
+	require.NoError(t, err)
+	require.NotEmpty(t, token2.AccessToken)
+
+	// Kedua token harus berbeda (karena setiap call STS menghasilkan token baru), tapi keduanya valid
+	require.NotEqual(t, token1.AccessToken, token2.AccessToken)
+
+	// Coba gunakan kedua token untuk membuat client Pub/Sub
+	projectID := "YOUR_PROJECT_ID" // Ganti dengan project ID Anda
+	topicID := "YOUR_TOPIC_ID"     // Ganti dengan topic ID Anda
+	if projectID == "" || topicID == "" {
+		t.Skip("Skipping Pub/Sub test: GCP_PROJECT_ID or GCP_PUBSUB_TOPIC env not set")
+	}
+
+	tsPub1 := oauth2.StaticTokenSource(&oauth2.Token{
+		AccessToken: token1.AccessToken,
+		TokenType:   "Bearer",
+		Expiry:      token1.Expiry,
+	})
+	client1, err := pubsub.NewClient(ctx, projectID, option.WithTokenSource(tsPub1))
+	require.NoError(t, err)
+	defer client1.Close()
+	topic1 := client1.Topic(topicID)
+	result1 := topic1.Publish(ctx, &pubsub.Message{Data: []byte("Test with token1")})
+	_, err = result1.Get(ctx)
+	require.NoError(t, err)
+
+	tsPub2 := oauth2.StaticTokenSource(&oauth2.Token{
+		AccessToken: token2.AccessToken,
+		TokenType:   "Bearer",
+		Expiry:      token2.Expiry,
+	})
+	client2, err := pubsub.NewClient(ctx, projectID, option.WithTokenSource(tsPub2))
+	require.NoError(t, err)
+	defer client2.Close()
+	topic2 := client2.Topic(topicID)
+	result2 := topic2.Publish(ctx, &pubsub.Message{Data: []byte("Test with token2")})
+	_, err = result2.Get(ctx)
+	require.NoError(t, err)
+}
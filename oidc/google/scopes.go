@@ -0,0 +1,62 @@
+package oidc
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Service names accepted by ServiceScopes.
+const (
+	ServiceCloudPlatform   = "cloud-platform"
+	ServicePubSub          = "pubsub"
+	ServiceStorage         = "storage"
+	ServiceStorageReadOnly = "storage.readonly"
+	ServiceBigQuery        = "bigquery"
+	ServiceBigQueryRead    = "bigquery.readonly"
+)
+
+// ErrUnknownService is returned by ServiceScopes for a service name it does not know.
+var ErrUnknownService = errors.New("unknown Google service")
+
+// serviceScopes maps a service name to the OAuth scopes its client libraries need.
+var serviceScopes = map[string][]string{
+	ServiceCloudPlatform:   {cloudPlatformScope},
+	ServicePubSub:          {"https://www.googleapis.com/auth/pubsub"},
+	ServiceStorage:         {"https://www.googleapis.com/auth/devstorage.read_write"},
+	ServiceStorageReadOnly: {"https://www.googleapis.com/auth/devstorage.read_only"},
+	ServiceBigQuery:        {"https://www.googleapis.com/auth/bigquery"},
+	ServiceBigQueryRead:    {"https://www.googleapis.com/auth/bigquery.readonly"},
+}
+
+// ServiceScopes returns the scopes needed by the named services (e.g. ServicePubSub, ServiceStorage),
+// so callers do not have to memorize scope URLs. Names are case-insensitive and duplicate scopes are
+// dropped; an unknown name returns an error wrapping ErrUnknownService.
+func ServiceScopes(services ...string) ([]string, error) {
+	var scopes []string
+	seen := map[string]bool{}
+	for _, service := range services {
+		s, ok := serviceScopes[strings.ToLower(strings.TrimSpace(service))]
+		if !ok {
+			return nil, fmt.Errorf("%w %q, known services: %s", ErrUnknownService, service, strings.Join(KnownServices(), ", "))
+		}
+		for _, scope := range s {
+			if !seen[scope] {
+				seen[scope] = true
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+	return scopes, nil
+}
+
+// KnownServices returns the service names accepted by ServiceScopes, sorted.
+func KnownServices() []string {
+	names := make([]string, 0, len(serviceScopes))
+	for name := range serviceScopes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
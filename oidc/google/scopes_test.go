@@ -0,0 +1,34 @@
+package oidc_test
+
+import (
+	"testing"
+
+	gcpwif "github.com/PCS-Indonesia/pcs-oidc/oidc/google"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestServiceScopes(t *testing.T) {
+	t.Run("known services", func(t *testing.T) {
+		scopes, err := gcpwif.ServiceScopes(gcpwif.ServicePubSub, "Storage", gcpwif.ServicePubSub)
+		require.NoError(t, err)
+		require.Equal(t, []string{
+			"https://www.googleapis.com/auth/pubsub",
+			"https://www.googleapis.com/auth/devstorage.read_write",
+		}, scopes)
+	})
+
+	t.Run("unknown service", func(t *testing.T) {
+		_, err := gcpwif.ServiceScopes("pubsub", "spanner")
+		require.ErrorIs(t, err, gcpwif.ErrUnknownService)
+		require.Contains(t, err.Error(), "bigquery")
+	})
+
+	t.Run("known services are listed", func(t *testing.T) {
+		for _, name := range gcpwif.KnownServices() {
+			scopes, err := gcpwif.ServiceScopes(name)
+			require.NoError(t, err)
+			require.NotEmpty(t, scopes)
+		}
+	})
+}
@@ -0,0 +1,158 @@
+package oidc
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+
+	oidcprovider "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+)
+
+// wifAudiencePattern splits a WIF provider audience into project number, pool and provider IDs.
+var wifAudiencePattern = regexp.MustCompile(`^//iam\.googleapis\.com/projects/([^/]+)/locations/global/workloadIdentityPools/([^/]+)/providers/([^/]+)$`)
+
+// impersonationURLPattern extracts the service account email from ServiceAccountImpersonationURL.
+var impersonationURLPattern = regexp.MustCompile(`/serviceAccounts/([^/:]+):generateAccessToken$`)
+
+// SetupConfig is the configuration DescribeRequiredSetup derives the required resources from.
+// Keycloak is optional and describes the IdP issuing the subject token.
+type SetupConfig struct {
+	WIF      WIFConfig
+	Keycloak *oidcprovider.ConfigKeyCloak
+}
+
+// RequiredSetup is a machine-readable description of the IdP and Google Cloud resources the code's
+// configuration expects, e.g. for Terraform to validate or generate the matching pool and client.
+type RequiredSetup struct {
+	WorkloadIdentityPool WorkloadIdentityPoolSetup `json:"workload_identity_pool"`
+	ServiceAccount       *ServiceAccountSetup      `json:"service_account,omitempty"`
+	Keycloak             *KeycloakClientSetup      `json:"keycloak,omitempty"`
+	// Warnings lists settings that are likely to make the exchange fail.
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// WorkloadIdentityPoolSetup describes the pool and its OIDC provider.
+type WorkloadIdentityPoolSetup struct {
+	ProjectNumber     string            `json:"project_number"`
+	PoolID            string            `json:"pool_id"`
+	ProviderID        string            `json:"provider_id"`
+	Audience          string            `json:"audience"`
+	IssuerURI         string            `json:"issuer_uri,omitempty"`
+	AllowedAudiences  []string          `json:"allowed_audiences"`
+	AttributeMapping  map[string]string `json:"attribute_mapping"`
+	SubjectTokenType  string            `json:"subject_token_type"`
+	TokenURL          string            `json:"token_url"`
+	PrincipalSet      string            `json:"principal_set"`
+	RequiredScopes    []string          `json:"required_scopes,omitempty"`
+	ImpersonationUsed bool              `json:"impersonation_used"`
+}
+
+// ServiceAccountSetup describes the impersonated service account and the binding the pool needs.
+type ServiceAccountSetup struct {
+	Email  string `json:"email"`
+	Role   string `json:"role"`
+	Member string `json:"member"`
+}
+
+// KeycloakClientSetup describes the Keycloak client issuing the subject token.
+type KeycloakClientSetup struct {
+	RealmURL               string   `json:"realm_url"`
+	ClientID               string   `json:"client_id"`
+	GrantType              string   `json:"grant_type"`
+	ConfidentialClient     bool     `json:"confidential_client"`
+	ServiceAccountsEnabled bool     `json:"service_accounts_enabled"`
+	DirectAccessGrants     bool     `json:"direct_access_grants_enabled"`
+	TokenExchange          bool     `json:"token_exchange_permission"`
+	ClientScopes           []string `json:"client_scopes,omitempty"`
+	AudienceMapper         string   `json:"audience_mapper,omitempty"`
+}
+
+// DescribeRequiredSetup derives the IdP and Workload Identity Pool configuration matching cfg.
+// Parts that cannot be derived are left empty and reported in Warnings instead of failing, so the
+// result can also be used to diagnose a broken configuration.
+func DescribeRequiredSetup(cfg SetupConfig) RequiredSetup {
+	wif := cfg.WIF
+	pool := WorkloadIdentityPoolSetup{
+		Audience:         wif.Audience,
+		SubjectTokenType: wif.SubjectTokenType,
+		TokenURL:         wif.TokenURL,
+		RequiredScopes:   wif.Scopes,
+		AttributeMapping: map[string]string{
+			"google.subject":       "assertion.sub",
+			"attribute.client_id":  "assertion.azp",
+			"attribute.issuer_uri": "assertion.iss",
+		},
+		ImpersonationUsed: wif.ServiceAccountImpersonationURL != "",
+	}
+	var setup RequiredSetup
+	if m := wifAudiencePattern.FindStringSubmatch(wif.Audience); m != nil {
+		pool.ProjectNumber, pool.PoolID, pool.ProviderID = m[1], m[2], m[3]
+	} else {
+		setup.Warnings = append(setup.Warnings, fmt.Sprintf("audience %q is not a workload identity pool provider name", wif.Audience))
+	}
+
+	// The subject token must carry an aud the provider allows. A Keycloak token carries its Audience,
+	// without one the realm default aud, which cannot be derived here
+	tokenAudience := DefaultProviderAudience(wif.Audience)
+	if kc := cfg.Keycloak; kc != nil {
+		pool.IssuerURI = kc.NormalizedRealmURL()
+		tokenAudience = kc.Audience
+		if tokenAudience == "" {
+			setup.Warnings = append(setup.Warnings, "Keycloak Audience is empty: the token carries the realm default aud, which must be added to the allowed audiences explicitly")
+		}
+		setup.Keycloak = describeKeycloak(kc, tokenAudience)
+	}
+	pool.AllowedAudiences = []string{}
+	if tokenAudience != "" {
+		pool.AllowedAudiences = append(pool.AllowedAudiences, tokenAudience)
+	}
+	if pool.PoolID != "" {
+		principalSet := fmt.Sprintf("principalSet://iam.googleapis.com/projects/%s/locations/global/workloadIdentityPools/%s", pool.ProjectNumber, pool.PoolID)
+		if cfg.Keycloak != nil && cfg.Keycloak.KeycloakClientID != "" {
+			principalSet += "/attribute.client_id/" + cfg.Keycloak.KeycloakClientID
+		} else {
+			principalSet += "/*"
+		}
+		pool.PrincipalSet = principalSet
+	}
+	setup.WorkloadIdentityPool = pool
+
+	if wif.ServiceAccountImpersonationURL != "" {
+		if m := impersonationURLPattern.FindStringSubmatch(wif.ServiceAccountImpersonationURL); m != nil {
+			setup.ServiceAccount = &ServiceAccountSetup{Email: m[1], Role: "roles/iam.workloadIdentityUser", Member: pool.PrincipalSet}
+		} else {
+			setup.Warnings = append(setup.Warnings, fmt.Sprintf("service account impersonation URL %q does not name a service account", wif.ServiceAccountImpersonationURL))
+		}
+	}
+	if wif.Audience == "" || wif.TokenURL == "" || wif.SubjectTokenType == "" {
+		setup.Warnings = append(setup.Warnings, "missing required WIFConfig fields")
+	}
+	return setup
+}
+
+// describeKeycloak lists the client settings the configured grant relies on.
+func describeKeycloak(kc *oidcprovider.ConfigKeyCloak, audience string) *KeycloakClientSetup {
+	grant := kc.GrantType
+	if grant == "" {
+		grant = oidcprovider.GrantClientCredentials
+	}
+	client := &KeycloakClientSetup{
+		RealmURL:               kc.NormalizedRealmURL(),
+		ClientID:               kc.KeycloakClientID,
+		GrantType:              string(grant),
+		ConfidentialClient:     kc.KeycloakClientSecret != "" || kc.AssertionSigner != nil || kc.ClientAssertionSigner != nil || kc.ClientTLS != nil,
+		ServiceAccountsEnabled: grant == oidcprovider.GrantClientCredentials,
+		DirectAccessGrants:     grant == oidcprovider.GrantPassword,
+		TokenExchange:          grant == oidcprovider.GrantTokenExchange || (kc.Audience != "" && kc.AudienceMode == oidcprovider.AudienceExchange),
+		ClientScopes:           append([]string{"openid"}, kc.KeycloakClientScopes...),
+	}
+	if kc.Audience != "" && kc.AudienceMode == oidcprovider.AudienceParam {
+		client.AudienceMapper = audience
+	}
+	return client
+}
+
+// JSON returns the indented JSON document of the setup.
+func (s RequiredSetup) JSON() ([]byte, error) {
+	return json.MarshalIndent(s, "", "  ")
+}
@@ -0,0 +1,60 @@
+package oidc_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	gcpwif "github.com/PCS-Indonesia/pcs-oidc/oidc/google"
+	oidcprovider "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDescribeRequiredSetup(t *testing.T) {
+	const aud = "//iam.googleapis.com/projects/123/locations/global/workloadIdentityPools/keycloak/providers/realm"
+	cfg := gcpwif.SetupConfig{
+		WIF: gcpwif.NewWIFConfig(aud, "urn:ietf:params:oauth:token-type:jwt", "https://sts.googleapis.com/v1/token",
+			[]string{"https://www.googleapis.com/auth/cloud-platform"},
+			"https://iamcredentials.googleapis.com/v1/projects/-/serviceAccounts/app@p.iam.gserviceaccount.com:generateAccessToken",
+			&gcpwif.StaticTokenSupplier{}),
+		Keycloak: &oidcprovider.ConfigKeyCloak{
+			KeycloakRealmURL:     "https://sso.example.com/realms/prod",
+			KeycloakClientID:     "orders",
+			KeycloakClientSecret: "secret",
+			Audience:             "https:" + aud,
+		},
+	}
+
+	setup := gcpwif.DescribeRequiredSetup(cfg)
+	require.Empty(t, setup.Warnings)
+	pool := setup.WorkloadIdentityPool
+	require.Equal(t, "123", pool.ProjectNumber)
+	require.Equal(t, "keycloak", pool.PoolID)
+	require.Equal(t, "realm", pool.ProviderID)
+	require.Equal(t, "https://sso.example.com/realms/prod", pool.IssuerURI)
+	require.Equal(t, []string{"https:" + aud}, pool.AllowedAudiences)
+	require.Equal(t, "assertion.sub", pool.AttributeMapping["google.subject"])
+	require.Equal(t, "principalSet://iam.googleapis.com/projects/123/locations/global/workloadIdentityPools/keycloak/attribute.client_id/orders", pool.PrincipalSet)
+
+	require.Equal(t, "app@p.iam.gserviceaccount.com", setup.ServiceAccount.Email)
+	require.Equal(t, pool.PrincipalSet, setup.ServiceAccount.Member)
+
+	require.True(t, setup.Keycloak.ServiceAccountsEnabled)
+	require.True(t, setup.Keycloak.ConfidentialClient)
+	require.Equal(t, "https:"+aud, setup.Keycloak.AudienceMapper)
+
+	data, err := setup.JSON()
+	require.NoError(t, err)
+	var decoded map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	require.Contains(t, decoded, "workload_identity_pool")
+
+	t.Run("warnings", func(t *testing.T) {
+		cfg.WIF.Audience = "my-audience"
+		cfg.Keycloak.Audience = ""
+		setup := gcpwif.DescribeRequiredSetup(cfg)
+		require.Len(t, setup.Warnings, 2)
+		require.Empty(t, setup.WorkloadIdentityPool.AllowedAudiences)
+		require.Empty(t, setup.Keycloak.AudienceMapper)
+	})
+}
@@ -0,0 +1,226 @@
+package oidc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	oidcprovider "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"golang.org/x/oauth2"
+)
+
+// stsStatusPattern matches the errors produced by the STS and impersonation clients,
+// which only keep the status code and body of a failed response; bodies may span several lines
+var stsStatusPattern = regexp.MustCompile(`(?s)status code (\d+): (.*)$`)
+
+// maxRecordedFailures bounds the failed responses a retryAfterRecorder keeps
+const maxRecordedFailures = 32
+
+// retryAfterRecorder remembers the Retry-After header of failed responses
+// The externalaccount package flattens errors into strings keeping only status code and body, so the
+// body is what matches an error to its response: concurrent exchanges failing with different bodies
+// never see each other's Retry-After
+type retryAfterRecorder struct {
+	Base http.RoundTripper
+
+	mu       sync.Mutex
+	failures []recordedFailure // oldest first
+}
+
+// recordedFailure is a failed response seen by a retryAfterRecorder
+type recordedFailure struct {
+	status     int
+	body       string
+	retryAfter time.Duration
+}
+
+func (r *retryAfterRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := r.Base.RoundTrip(req)
+	if err != nil || resp.StatusCode < http.StatusBadRequest {
+		return resp, err
+	}
+	body, readErr := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if readErr != nil {
+		return resp, nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failures = append(r.failures, recordedFailure{
+		status:     resp.StatusCode,
+		body:       strings.TrimSpace(string(body)),
+		retryAfter: oidcprovider.ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+	})
+	if len(r.failures) > maxRecordedFailures {
+		r.failures = r.failures[len(r.failures)-maxRecordedFailures:]
+	}
+	return resp, nil
+}
+
+// take returns and forgets the Retry-After of the most recent failed response with status and body
+func (r *retryAfterRecorder) take(status int, body string) time.Duration {
+	body = strings.TrimSpace(body)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := len(r.failures) - 1; i >= 0; i-- {
+		if f := r.failures[i]; f.status == status && f.body == body {
+			r.failures = append(r.failures[:i], r.failures[i+1:]...)
+			return f.retryAfter
+		}
+	}
+	return 0
+}
+
+// stsTokenSource converts STS and IAM Credentials error responses into *oidcprovider.TokenError
+type stsTokenSource struct {
+	ctx       context.Context // bounds the clock skew wait, the context the source was created with
+	src       oauth2.TokenSource
+	recorder  *retryAfterRecorder
+	provider  string        // TokenError.Provider, default "sts"
+	skewDelay time.Duration // wait before the clock skew retry, default oidcprovider.DefaultSkewRetryDelay
+}
+
+func (s *stsTokenSource) Token() (*oauth2.Token, error) {
+	tok, err := s.token()
+	if err != nil && oidcprovider.IsClockSkewError(err) {
+		// The subject token was issued "in the future" for Google: wait once for the clocks to line up
+		if waitErr := oidcprovider.WaitClockSkew(s.ctx, s.providerName(), err, s.skewDelay); waitErr != nil {
+			return nil, err
+		}
+		tok, err = s.token()
+	}
+	return tok, err
+}
+
+func (s *stsTokenSource) token() (*oauth2.Token, error) {
+	tok, err := s.src.Token()
+	if err == nil {
+		return tok, nil
+	}
+	m := stsStatusPattern.FindStringSubmatch(err.Error())
+	if m == nil {
+		return nil, err
+	}
+	status, _ := strconv.Atoi(m[1])
+	code, description := decodeErrorBody(m[2])
+	return nil, &oidcprovider.TokenError{
+		Provider:    s.providerName(),
+		StatusCode:  status,
+		Code:        code,
+		Description: description,
+		RetryAfter:  s.recorder.take(status, m[2]),
+		Err:         err,
+	}
+}
+
+func (s *stsTokenSource) providerName() string {
+	if s.provider == "" {
+		return "sts"
+	}
+	return s.provider
+}
+
+// decodeErrorBody returns the error code and description of an error response
+// STS answers with the OAuth2 shape {"error":"...","error_description":"..."}, IAM Credentials with the
+// Google API shape {"error":{"code":403,"message":"...","status":"PERMISSION_DENIED"}}
+func decodeErrorBody(body string) (code, description string) {
+	var resp struct {
+		Error            json.RawMessage `json:"error"`
+		ErrorDescription string          `json:"error_description"`
+	}
+	if json.Unmarshal([]byte(body), &resp) != nil {
+		return "", ""
+	}
+	if json.Unmarshal(resp.Error, &code) == nil {
+		return code, resp.ErrorDescription
+	}
+	var apiErr struct {
+		Message string `json:"message"`
+		Status  string `json:"status"`
+	}
+	if json.Unmarshal(resp.Error, &apiErr) == nil {
+		return apiErr.Status, apiErr.Message
+	}
+	return "", ""
+}
+
+// stsHTTPClient returns the client used for STS and impersonation calls
+// The transport records Retry-After and, when a policy is set, retries throttled requests
+func stsHTTPClient(cfg WIFConfig) (*http.Client, *retryAfterRecorder) {
+	client := cfg.HTTPClient
+	if client == nil {
+		client = oidcprovider.NewHTTPClient("sts", false)
+	}
+	client, recorder := recordingHTTPClient(client, cfg.Retry, "sts", cfg.OnEvent.WithAttestation(cfg.Attestation))
+	if cfg.RequestedTokenType != "" {
+		client.Transport = &stsParamsTransport{
+			Base:     client.Transport,
+			TokenURL: cfg.TokenURL,
+			Params:   url.Values{"requested_token_type": {cfg.RequestedTokenType}},
+		}
+	}
+	return client, recorder
+}
+
+// recordingHTTPClient returns a copy of client whose transport records Retry-After and ExchangeMetrics,
+// retries throttled requests when retry is set and reports requests to onEvent when it is set
+func recordingHTTPClient(client *http.Client, retry *oidcprovider.RetryPolicy, provider string, onEvent oidcprovider.EventHandler) (*http.Client, *retryAfterRecorder) {
+	base := client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	// Metrics see every attempt, retries included
+	base = &metricsTransport{Base: base}
+	if retry != nil {
+		base = &oidcprovider.RetryTransport{Base: base, Policy: *retry}
+	}
+	if onEvent != nil {
+		base = &eventTransport{Base: base, Provider: provider, OnEvent: onEvent}
+	}
+	recorder := &retryAfterRecorder{Base: base}
+	wrapped := *client
+	wrapped.Transport = recorder
+	return &wrapped, recorder
+}
+
+// stsParamsTransport overrides form parameters of requests to the STS token endpoint
+// externalaccount always requests an access token, this lets other RFC 8693 parameters through
+type stsParamsTransport struct {
+	Base     http.RoundTripper
+	TokenURL string
+	Params   url.Values
+}
+
+func (t *stsParamsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodPost || req.URL.String() != t.TokenURL || req.Body == nil {
+		return t.Base.RoundTrip(req)
+	}
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range t.Params {
+		form[k] = v
+	}
+	encoded := form.Encode()
+	// RoundTrippers must not modify the original request
+	req = req.Clone(req.Context())
+	req.Body = io.NopCloser(strings.NewReader(encoded))
+	req.ContentLength = int64(len(encoded))
+	return t.Base.RoundTrip(req)
+}
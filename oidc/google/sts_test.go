@@ -0,0 +1,233 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	gcpwif "github.com/PCS-Indonesia/pcs-oidc/oidc/google"
+	oidcprovider "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newFakeSTS starts a fake Google STS token endpoint
+func newFakeSTS(t *testing.T, handler http.HandlerFunc) string {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	return srv.URL + "/v1/token"
+}
+
+func wifConfig(tokenURL string) gcpwif.WIFConfig {
+	return gcpwif.NewWIFConfig(
+		"//iam.googleapis.com/projects/1/locations/global/workloadIdentityPools/p/providers/k",
+		"urn:ietf:params:oauth:token-type:jwt",
+		tokenURL,
+		[]string{"https://www.googleapis.com/auth/cloud-platform"},
+		"",
+		&gcpwif.StaticTokenSupplier{Token: "subject"},
+	)
+}
+
+func TestSTSRetryAfter(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("typed error carries Retry-After", func(t *testing.T) {
+		tokenURL := newFakeSTS(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Retry-After", "12")
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = w.Write([]byte(`{"error":"rate_limited","error_description":"quota exceeded"}`))
+		})
+		ts, err := gcpwif.GetGCPTokenSource(ctx, wifConfig(tokenURL))
+		require.NoError(t, err)
+		_, err = ts.Token()
+		var tErr *oidcprovider.TokenError
+		require.True(t, errors.As(err, &tErr))
+		require.Equal(t, "sts", tErr.Provider)
+		require.Equal(t, http.StatusTooManyRequests, tErr.StatusCode)
+		require.Equal(t, "rate_limited", tErr.Code)
+		require.Equal(t, 12*time.Second, tErr.RetryAfter)
+	})
+
+	t.Run("multi-line error body", func(t *testing.T) {
+		tokenURL := newFakeSTS(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Retry-After", "7")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte("{\n  \"error\": \"temporarily_unavailable\",\n  \"error_description\": \"backend\\nrestarting\"\n}\n"))
+		})
+		ts, err := gcpwif.GetGCPTokenSource(ctx, wifConfig(tokenURL))
+		require.NoError(t, err)
+		_, err = ts.Token()
+		var tErr *oidcprovider.TokenError
+		require.True(t, errors.As(err, &tErr))
+		require.Equal(t, http.StatusServiceUnavailable, tErr.StatusCode)
+		require.Equal(t, "temporarily_unavailable", tErr.Code)
+		require.Equal(t, "backend\nrestarting", tErr.Description)
+		require.Equal(t, 7*time.Second, tErr.RetryAfter)
+	})
+
+	t.Run("Retry-After belongs to the failed response", func(t *testing.T) {
+		var calls atomic.Int32
+		tokenURL := newFakeSTS(t, func(w http.ResponseWriter, r *http.Request) {
+			if calls.Add(1) == 1 {
+				w.Header().Set("Retry-After", "30")
+				w.WriteHeader(http.StatusTooManyRequests)
+				_, _ = w.Write([]byte(`{"error":"rate_limited"}`))
+				return
+			}
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"invalid_grant"}`))
+		})
+		ts, err := gcpwif.GetGCPTokenSource(ctx, wifConfig(tokenURL))
+		require.NoError(t, err)
+		var tErr *oidcprovider.TokenError
+		_, err = ts.Token()
+		require.True(t, errors.As(err, &tErr))
+		require.Equal(t, 30*time.Second, tErr.RetryAfter)
+		_, err = ts.Token()
+		require.True(t, errors.As(err, &tErr))
+		require.Equal(t, "invalid_grant", tErr.Code)
+		require.Zero(t, tErr.RetryAfter)
+	})
+
+	t.Run("retry policy waits and retries", func(t *testing.T) {
+		var calls atomic.Int32
+		tokenURL := newFakeSTS(t, func(w http.ResponseWriter, r *http.Request) {
+			if calls.Add(1) == 1 {
+				w.Header().Set("Retry-After", "1")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"access_token":"gcp","issued_token_type":"urn:ietf:params:oauth:token-type:access_token","token_type":"Bearer","expires_in":3600}`))
+		})
+		cfg := wifConfig(tokenURL)
+		cfg.Retry = &oidcprovider.RetryPolicy{MaxAttempts: 2}
+		ts, err := gcpwif.GetGCPTokenSource(ctx, cfg)
+		require.NoError(t, err)
+		tok, err := ts.Token()
+		require.NoError(t, err)
+		require.Equal(t, "gcp", tok.AccessToken)
+		require.EqualValues(t, 2, calls.Load())
+	})
+}
+
+func TestGCPTokenSourceLeeway(t *testing.T) {
+	ctx := context.Background()
+	var calls atomic.Int32
+	tokenURL := newFakeSTS(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		// Tokens live two minutes
+		_, _ = w.Write([]byte(`{"access_token":"gcp","issued_token_type":"urn:ietf:params:oauth:token-type:access_token","token_type":"Bearer","expires_in":120}`))
+	})
+
+	t.Run("default leeway reuses the token", func(t *testing.T) {
+		calls.Store(0)
+		ts, err := gcpwif.GetGCPTokenSource(ctx, wifConfig(tokenURL))
+		require.NoError(t, err)
+		for i := 0; i < 3; i++ {
+			_, err := ts.Token()
+			require.NoError(t, err)
+		}
+		require.EqualValues(t, 1, calls.Load())
+	})
+
+	t.Run("leeway longer than the lifetime refreshes every call", func(t *testing.T) {
+		calls.Store(0)
+		cfg := wifConfig(tokenURL)
+		cfg.Leeway = 5 * time.Minute
+		ts, err := gcpwif.GetGCPTokenSource(ctx, cfg)
+		require.NoError(t, err)
+		for i := 0; i < 3; i++ {
+			_, err := ts.Token()
+			require.NoError(t, err)
+		}
+		require.EqualValues(t, 3, calls.Load())
+	})
+}
+
+func TestSTSRequestedTokenType(t *testing.T) {
+	var requested string
+	tokenURL := newFakeSTS(t, func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		requested = r.PostForm.Get("requested_token_type")
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"id-token","issued_token_type":"urn:ietf:params:oauth:token-type:id_token","token_type":"N_A","expires_in":3600}`))
+	})
+	cfg := wifConfig(tokenURL)
+	cfg.RequestedTokenType = oidcprovider.TokenTypeIDToken
+	ts, err := gcpwif.GetGCPTokenSource(context.Background(), cfg)
+	require.NoError(t, err)
+	tok, err := ts.Token()
+	require.NoError(t, err)
+	require.Equal(t, "id-token", tok.AccessToken)
+	require.Equal(t, oidcprovider.TokenTypeIDToken, requested)
+}
+
+func TestSTSClockSkewRetry(t *testing.T) {
+	var calls atomic.Int32
+	tokenURL := newFakeSTS(t, func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"invalid_grant","error_description":"ID Token issued at 1700000100 is in the future"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"gcp","issued_token_type":"urn:ietf:params:oauth:token-type:access_token","token_type":"Bearer","expires_in":3600}`))
+	})
+	cfg := wifConfig(tokenURL)
+	cfg.SkewRetryDelay = time.Millisecond
+	ts, err := gcpwif.GetGCPTokenSource(context.Background(), cfg)
+	require.NoError(t, err)
+	tok, err := ts.Token()
+	require.NoError(t, err)
+	require.Equal(t, "gcp", tok.AccessToken)
+	require.EqualValues(t, 2, calls.Load())
+
+	t.Run("wait ends with the context", func(t *testing.T) {
+		var calls atomic.Int32
+		tokenURL := newFakeSTS(t, func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"invalid_grant","error_description":"ID Token issued at 1700000100 is in the future"}`))
+		})
+		ctx, cancel := context.WithCancel(context.Background())
+		cfg := wifConfig(tokenURL)
+		cfg.SkewRetryDelay = time.Hour
+		ts, err := gcpwif.GetGCPTokenSource(ctx, cfg)
+		require.NoError(t, err)
+		time.AfterFunc(20*time.Millisecond, cancel)
+		start := time.Now()
+		_, err = ts.Token()
+		require.True(t, oidcprovider.IsClockSkewError(err))
+		require.Less(t, time.Since(start), time.Minute)
+		require.EqualValues(t, 1, calls.Load())
+	})
+}
+
+func TestSTSEventAttestation(t *testing.T) {
+	tokenURL := newFakeSTS(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"gcp","issued_token_type":"urn:ietf:params:oauth:token-type:access_token","token_type":"Bearer","expires_in":3600}`))
+	})
+	attestation := oidcprovider.NewAttestation(&oidcprovider.Claims{Subject: "spiffe://example.org/orders"})
+	var events []oidcprovider.Event
+	cfg := wifConfig(tokenURL)
+	cfg.OnEvent = func(ev oidcprovider.Event) { events = append(events, ev) }
+	cfg.Attestation = attestation
+
+	ts, err := gcpwif.GetGCPTokenSource(context.Background(), cfg)
+	require.NoError(t, err)
+	_, err = ts.Token()
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	require.Equal(t, oidcprovider.EventTokenFetched, events[1].Type)
+	require.Equal(t, "sts", events[1].Provider)
+	require.Same(t, attestation, events[1].Attestation)
+}
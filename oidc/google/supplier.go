@@ -0,0 +1,114 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	oidcprovider "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"golang.org/x/oauth2/google/externalaccount"
+)
+
+// TokenCacheSupplier implements TokenSupplier on top of a provider TokenCache,
+// so the Keycloak id_token used as WIF subject token is fetched and refreshed automatically.
+// Set ConfigKeyCloak.Audience to the audience allowed by the WIF provider when the
+// realm's default aud does not satisfy its attribute condition.
+type TokenCacheSupplier struct {
+	Cache *oidcprovider.TokenCache
+}
+
+// SubjectToken returns a valid token from the cache.
+func (s *TokenCacheSupplier) SubjectToken(ctx context.Context, opts externalaccount.SupplierOptions) (string, error) {
+	return s.Cache.GetValidToken(ctx)
+}
+
+// MeshTokenSupplier supplies the verified JWT forwarded by a service mesh as WIF subject token,
+// so a request's caller identity can be federated to Google for downstream calls.
+type MeshTokenSupplier struct {
+	token  string
+	claims *oidcprovider.Claims
+}
+
+// NewMeshTokenSupplier extracts and verifies the mesh forwarded token of r.
+func NewMeshTokenSupplier(r *http.Request, extractor *oidcprovider.MeshTokenExtractor) (*MeshTokenSupplier, error) {
+	token, claims, err := extractor.Extract(r)
+	if err != nil {
+		return nil, err
+	}
+	return &MeshTokenSupplier{token: token, claims: claims}, nil
+}
+
+// Claims returns the verified claims of the supplied token.
+func (s *MeshTokenSupplier) Claims() *oidcprovider.Claims {
+	return s.claims
+}
+
+// SubjectToken returns the forwarded token while it has not expired.
+func (s *MeshTokenSupplier) SubjectToken(ctx context.Context, opts externalaccount.SupplierOptions) (string, error) {
+	if !s.claims.Expiry.IsZero() && time.Now().After(s.claims.Expiry) {
+		return "", errors.New("mesh forwarded token has expired")
+	}
+	return s.token, nil
+}
+
+// NewGitHubActionsSupplier returns a TokenSupplier feeding the GitHub Actions id_token of the running
+// job to WIF, for keyless deploys. audience is the aud requested from GitHub; when empty it is derived
+// from wifAudience (the WIFConfig.Audience, "//iam.googleapis.com/projects/...") the way Google's
+// own GitHub integration does, which is what WIF providers accept by default.
+func NewGitHubActionsSupplier(audience, wifAudience string) *TokenCacheSupplier {
+	if audience == "" {
+		audience = DefaultProviderAudience(wifAudience)
+	}
+	return &TokenCacheSupplier{Cache: oidcprovider.NewTokenCache(&oidcprovider.GitHubActionsProvider{Audience: audience})}
+}
+
+// NewGitLabCISupplier returns a TokenSupplier feeding a GitLab CI id_token to WIF. variable names the
+// id_tokens entry (oidcprovider.DefaultGitLabTokenVariables when empty); the token must carry audience,
+// or DefaultProviderAudience(wifAudience) when audience is empty. Declare it in .gitlab-ci.yml as:
+//
+//	id_tokens:
+//	  GITLAB_OIDC_TOKEN:
+//	    aud: https://iam.googleapis.com/projects/.../providers/gitlab
+func NewGitLabCISupplier(variable, audience, wifAudience string) *TokenCacheSupplier {
+	if audience == "" {
+		audience = DefaultProviderAudience(wifAudience)
+	}
+	return &TokenCacheSupplier{Cache: oidcprovider.NewTokenCache(&oidcprovider.GitLabCIProvider{Variable: variable, Audience: audience})}
+}
+
+// DefaultProviderAudience converts a WIF provider audience ("//iam.googleapis.com/projects/...")
+// into the audience a WIF OIDC provider accepts by default ("https://iam.googleapis.com/projects/...").
+func DefaultProviderAudience(wifAudience string) string {
+	if strings.HasPrefix(wifAudience, "//") {
+		return "https:" + wifAudience
+	}
+	return wifAudience
+}
+
+// GitHubActionsAudience converts a WIF provider audience into the audience a WIF OIDC provider accepts by default.
+//
+// Deprecated: use DefaultProviderAudience, which also serves GitLab CI and SPIFFE subject tokens.
+func GitHubActionsAudience(wifAudience string) string {
+	return DefaultProviderAudience(wifAudience)
+}
+
+// NewSpiffeSupplier returns a TokenSupplier feeding a JWT-SVID fetched from the SPIFFE Workload API
+// (e.g. a spiffe.WorkloadClient) to WIF. The SVID is requested for audience, or for
+// DefaultProviderAudience(wifAudience) when audience is empty; the WIF provider must trust the
+// SPIRE trust domain's JWT issuer.
+func NewSpiffeSupplier(fetcher oidcprovider.JWTSVIDFetcher, audience, wifAudience string) *TokenCacheSupplier {
+	if audience == "" {
+		audience = DefaultProviderAudience(wifAudience)
+	}
+	return &TokenCacheSupplier{Cache: oidcprovider.NewTokenCache(&oidcprovider.SpiffeTokenProvider{Fetcher: fetcher, Audience: audience})}
+}
+
+// NewFileSupplier returns a TokenSupplier feeding a token written to path by a sidecar (istio-agent,
+// spire-agent, a projected service account token) to WIF. A rotated file is picked up when the cached
+// token is refreshed.
+func NewFileSupplier(path string) *TokenCacheSupplier {
+	return &TokenCacheSupplier{Cache: oidcprovider.NewTokenCache(oidcprovider.NewFileTokenProvider(path))}
+}
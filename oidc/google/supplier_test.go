@@ -0,0 +1,137 @@
+package oidc_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	gcpwif "github.com/PCS-Indonesia/pcs-oidc/oidc/google"
+	oidcprovider "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2/google/externalaccount"
+)
+
+type fixedProvider struct {
+	token string
+	calls int
+}
+
+func (f *fixedProvider) FetchToken(ctx context.Context) (string, error) {
+	f.calls++
+	return f.token, nil
+}
+
+func TestTokenCacheSupplier(t *testing.T) {
+	// header {"alg":"none"}, payload {"exp":4102444800}
+	token := "eyJhbGciOiJub25lIn0.eyJleHAiOjQxMDI0NDQ4MDB9.sig"
+	provider := &fixedProvider{token: token}
+	supplier := &gcpwif.TokenCacheSupplier{Cache: oidcprovider.NewTokenCache(provider)}
+
+	for i := 0; i < 2; i++ {
+		got, err := supplier.SubjectToken(context.Background(), externalaccount.SupplierOptions{})
+		require.NoError(t, err)
+		require.Equal(t, token, got)
+	}
+	require.Equal(t, 1, provider.calls)
+}
+
+func TestMeshTokenSupplier(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	signer, err := oidcprovider.NewSigner(key, "")
+	require.NoError(t, err)
+	jwk, err := signer.PublicJWK()
+	require.NoError(t, err)
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": []oidcprovider.JWK{jwk}})
+	}))
+	t.Cleanup(jwks.Close)
+
+	token, err := signer.Sign(map[string]interface{}{"sub": "user-1", "exp": time.Now().Add(time.Hour).Unix()}, nil)
+	require.NoError(t, err)
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Forwarded-Access-Token", token)
+
+	ext := &oidcprovider.MeshTokenExtractor{Verifier: oidcprovider.NewVerifier(oidcprovider.VerifierConfig{JWKSURL: jwks.URL})}
+	supplier, err := gcpwif.NewMeshTokenSupplier(req, ext)
+	require.NoError(t, err)
+	require.Equal(t, "user-1", supplier.Claims().Subject)
+	got, err := supplier.SubjectToken(context.Background(), externalaccount.SupplierOptions{})
+	require.NoError(t, err)
+	require.Equal(t, token, got)
+
+	_, err = gcpwif.NewMeshTokenSupplier(httptest.NewRequest(http.MethodGet, "/", nil), ext)
+	require.Error(t, err)
+}
+
+func TestGitHubActionsSupplier(t *testing.T) {
+	const wifAudience = "//iam.googleapis.com/projects/1/locations/global/workloadIdentityPools/gh/providers/gh"
+	token := "eyJhbGciOiJub25lIn0.eyJleHAiOjQxMDI0NDQ4MDB9.sig"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "https:"+wifAudience, r.URL.Query().Get("audience"))
+		_, _ = w.Write([]byte(`{"value":"` + token + `"}`))
+	}))
+	t.Cleanup(srv.Close)
+	t.Setenv(oidcprovider.GitHubActionsRequestURLEnv, srv.URL+"/token?api-version=1")
+	t.Setenv(oidcprovider.GitHubActionsRequestTokenEnv, "runtime-token")
+
+	supplier := gcpwif.NewGitHubActionsSupplier("", wifAudience)
+	got, err := supplier.SubjectToken(context.Background(), externalaccount.SupplierOptions{})
+	require.NoError(t, err)
+	require.Equal(t, token, got)
+
+	// The old name keeps working
+	require.Equal(t, gcpwif.DefaultProviderAudience(wifAudience), gcpwif.GitHubActionsAudience(wifAudience))
+}
+
+func TestGitLabCISupplier(t *testing.T) {
+	const wifAudience = "//iam.googleapis.com/projects/1/locations/global/workloadIdentityPools/gl/providers/gl"
+	claims, err := json.Marshal(map[string]interface{}{"exp": 4102444800, "aud": "https:" + wifAudience})
+	require.NoError(t, err)
+	token := "eyJhbGciOiJub25lIn0." + base64.RawURLEncoding.EncodeToString(claims) + ".sig"
+	t.Setenv("GITLAB_OIDC_TOKEN", token)
+
+	got, err := gcpwif.NewGitLabCISupplier("", "", wifAudience).SubjectToken(context.Background(), externalaccount.SupplierOptions{})
+	require.NoError(t, err)
+	require.Equal(t, token, got)
+
+	_, err = gcpwif.NewGitLabCISupplier("", "https://other", wifAudience).SubjectToken(context.Background(), externalaccount.SupplierOptions{})
+	require.ErrorIs(t, err, oidcprovider.ErrNoGitLabToken)
+}
+
+type svidFetcher map[string]string
+
+func (f svidFetcher) FetchJWTSVID(_ context.Context, audience, _ string) (string, error) {
+	return f[audience], nil
+}
+
+func TestSpiffeSupplier(t *testing.T) {
+	const wifAudience = "//iam.googleapis.com/projects/1/locations/global/workloadIdentityPools/spire/providers/spire"
+	claims, err := json.Marshal(map[string]interface{}{"exp": 4102444800, "aud": "https:" + wifAudience, "sub": "spiffe://example.org/orders"})
+	require.NoError(t, err)
+	token := "eyJhbGciOiJub25lIn0." + base64.RawURLEncoding.EncodeToString(claims) + ".sig"
+
+	got, err := gcpwif.NewSpiffeSupplier(svidFetcher{"https:" + wifAudience: token}, "", wifAudience).SubjectToken(context.Background(), externalaccount.SupplierOptions{})
+	require.NoError(t, err)
+	require.Equal(t, token, got)
+}
+
+func TestFileSupplier(t *testing.T) {
+	token := "eyJhbGciOiJub25lIn0.eyJleHAiOjQxMDI0NDQ4MDB9.sig"
+	path := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(path, []byte(token), 0o600))
+
+	got, err := gcpwif.NewFileSupplier(path).SubjectToken(context.Background(), externalaccount.SupplierOptions{})
+	require.NoError(t, err)
+	require.Equal(t, token, got)
+}
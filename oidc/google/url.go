@@ -0,0 +1,140 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	oidcprovider "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"golang.org/x/oauth2/google/externalaccount"
+)
+
+// URL token formats, matching credential_source.format.type of external_account files.
+const (
+	URLFormatText = "text"
+	URLFormatJSON = "json"
+)
+
+// URLTokenSupplier fetches the subject token from an HTTP(S) endpoint, like the "url" credential
+// source of external_account files: a GET request with Headers whose response is either the token
+// itself (URLFormatText) or a JSON object holding it in SubjectTokenFieldName (URLFormatJSON).
+type URLTokenSupplier struct {
+	URL                   string
+	Headers               map[string]string
+	Format                string // URLFormatText (default) or URLFormatJSON
+	SubjectTokenFieldName string // JSON field holding the token, required for URLFormatJSON
+	HTTPClient            *http.Client
+}
+
+// urlCredentialSource is the credential_source object of an external_account file.
+type urlCredentialSource struct {
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+	Format  struct {
+		Type                  string `json:"type"`
+		SubjectTokenFieldName string `json:"subject_token_field_name"`
+	} `json:"format"`
+}
+
+// ParseURLCredentialSource builds a URLTokenSupplier from an external_account file or from its
+// credential_source object, so existing configuration can be reused as is.
+func ParseURLCredentialSource(data []byte) (*URLTokenSupplier, error) {
+	var file struct {
+		CredentialSource *urlCredentialSource `json:"credential_source"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid credential source: %w", err)
+	}
+	src := file.CredentialSource
+	if src == nil {
+		src = &urlCredentialSource{}
+		if err := json.Unmarshal(data, src); err != nil {
+			return nil, fmt.Errorf("invalid credential source: %w", err)
+		}
+	}
+	if src.URL == "" {
+		return nil, errors.New("credential source has no url")
+	}
+	s := &URLTokenSupplier{
+		URL:                   src.URL,
+		Headers:               src.Headers,
+		Format:                src.Format.Type,
+		SubjectTokenFieldName: src.Format.SubjectTokenFieldName,
+	}
+	if err := s.validate(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *URLTokenSupplier) validate() error {
+	if s.URL == "" {
+		return errors.New("URL token supplier requires a URL")
+	}
+	switch strings.ToLower(s.Format) {
+	case "", URLFormatText:
+		return nil
+	case URLFormatJSON:
+		if s.SubjectTokenFieldName == "" {
+			return errors.New("URL token supplier with json format requires SubjectTokenFieldName")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported URL token format %q", s.Format)
+	}
+}
+
+// SubjectToken fetches the token from the endpoint.
+func (s *URLTokenSupplier) SubjectToken(ctx context.Context, opts externalaccount.SupplierOptions) (string, error) {
+	if err := s.validate(); err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.URL, nil)
+	if err != nil {
+		return "", err
+	}
+	for k, v := range s.Headers {
+		req.Header.Set(k, v)
+	}
+	client := s.HTTPClient
+	if client == nil {
+		client = oidcprovider.NewHTTPClient("url-token", false)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch subject token from %s: %w", s.URL, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", &oidcprovider.TokenError{
+			Provider:    "url-token",
+			StatusCode:  resp.StatusCode,
+			Description: strings.TrimSpace(string(body)),
+			RetryAfter:  oidcprovider.ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+		}
+	}
+	var token string
+	if strings.EqualFold(s.Format, URLFormatJSON) {
+		var fields map[string]interface{}
+		if err := json.Unmarshal(body, &fields); err != nil {
+			return "", fmt.Errorf("subject token response is not JSON: %w", err)
+		}
+		token, _ = fields[s.SubjectTokenFieldName].(string)
+	} else {
+		token = strings.TrimSpace(string(body))
+	}
+	if token == "" {
+		return "", fmt.Errorf("subject token response from %s has no token", s.URL)
+	}
+	return token, nil
+}
@@ -0,0 +1,66 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	gcpwif "github.com/PCS-Indonesia/pcs-oidc/oidc/google"
+	oidcprovider "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2/google/externalaccount"
+)
+
+func TestURLTokenSupplier(t *testing.T) {
+	ctx := context.Background()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata") != "true" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/text":
+			_, _ = w.Write([]byte("text-token\n"))
+		case "/json":
+			_, _ = w.Write([]byte(`{"access_token":"json-token","expires_in":3600}`))
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	t.Run("text", func(t *testing.T) {
+		s := &gcpwif.URLTokenSupplier{URL: srv.URL + "/text", Headers: map[string]string{"Metadata": "true"}}
+		got, err := s.SubjectToken(ctx, externalaccount.SupplierOptions{})
+		require.NoError(t, err)
+		require.Equal(t, "text-token", got)
+	})
+
+	t.Run("external_account credential source", func(t *testing.T) {
+		s, err := gcpwif.ParseURLCredentialSource([]byte(`{
+			"type": "external_account",
+			"credential_source": {
+				"url": "` + srv.URL + `/json",
+				"headers": {"Metadata": "true"},
+				"format": {"type": "json", "subject_token_field_name": "access_token"}
+			}
+		}`))
+		require.NoError(t, err)
+		got, err := s.SubjectToken(ctx, externalaccount.SupplierOptions{})
+		require.NoError(t, err)
+		require.Equal(t, "json-token", got)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		_, err := (&gcpwif.URLTokenSupplier{URL: srv.URL + "/text"}).SubjectToken(ctx, externalaccount.SupplierOptions{})
+		var tErr *oidcprovider.TokenError
+		require.True(t, errors.As(err, &tErr))
+		require.Equal(t, http.StatusUnauthorized, tErr.StatusCode)
+
+		_, err = gcpwif.ParseURLCredentialSource([]byte(`{"url":"https://x","format":{"type":"json"}}`))
+		require.ErrorContains(t, err, "SubjectTokenFieldName")
+		_, err = gcpwif.ParseURLCredentialSource([]byte(`{"file":"/token"}`))
+		require.ErrorContains(t, err, "no url")
+	})
+}
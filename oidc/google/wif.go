@@ -3,8 +3,12 @@ package oidc
 import (
 	"context"
 	"fmt"
+	"net/http"
+	"sync"
 	"time"
 
+	oidcprovider "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google/externalaccount"
 )
@@ -27,6 +31,16 @@ func (s *StaticTokenSupplier) SubjectToken(ctx context.Context, opts externalacc
 
 // WIFConfig holds configuration for GCP Workload Identity Federation.
 // TokenSupplier is any implementation that returns a valid OIDC token (id_token).
+// For AWS providers set AwsSupplier (e.g. an awscreds.Supplier) and AwsSubjectTokenType instead.
+// HTTPClient is optional and used for the STS and impersonation calls; when nil a client
+// that honours the provider package debug mode (SetDebug) is used.
+// Retry is optional; when set, STS requests answered with 429 or 5xx are retried honouring Retry-After.
+// Failed exchanges are returned as *oidcprovider.TokenError with the server requested RetryAfter.
+// OnEvent is optional and receives a request event and a fetched or failed event per STS and
+// impersonation call; Attestation, when set, is attached to those events so audit logs can tell which
+// workload identity obtained the Google token. With ServiceAccountImpersonationURL OnEvent also receives
+// an impersonation event per minted token or failed attempt, naming the service account, the scopes and
+// the identity claims of the subject token (see NewJSONAuditWriter).
 type WIFConfig struct {
 	Audience                       string
 	SubjectTokenType               string
@@ -34,8 +48,26 @@ type WIFConfig struct {
 	Scopes                         []string
 	ServiceAccountImpersonationURL string
 	TokenSupplier                  TokenSupplier
+	AwsSupplier                    externalaccount.AwsSecurityCredentialsSupplier
+	HTTPClient                     *http.Client
+	Retry                          *oidcprovider.RetryPolicy
+	Leeway                         time.Duration // refresh this long before expiry, default DefaultLeeway
+	RequestedTokenType             string        // RFC 8693 requested_token_type, default access token
+	OnEvent                        oidcprovider.EventHandler
+	Attestation                    *oidcprovider.Attestation
+	SubjectTTL                     time.Duration // ExchangeAll: how long an opaque subject token is shared, default DefaultSubjectTTL
+	SkewRetryDelay                 time.Duration // wait before retrying a clock skew rejection, default oidcprovider.DefaultSkewRetryDelay
 }
 
+// AwsSubjectTokenType is the WIFConfig.SubjectTokenType of AWS workload identity providers.
+const AwsSubjectTokenType = "urn:ietf:params:aws:token-type:aws4_request"
+
+// DefaultLeeway is how long before expiry GetGCPTokenSource refreshes the Google token
+const DefaultLeeway = time.Minute
+
+// DefaultSubjectTTL bounds how long ExchangeAll shares a subject token without exp claim
+const DefaultSubjectTTL = 5 * time.Minute
+
 // NewWIFConfig is a constructor for WIFConfig with all parameters required (no hardcoded defaults).
 func NewWIFConfig(audience, subjectTokenType, tokenURL string, scopes []string, saImpersonationURL string, tokenSupplier TokenSupplier) WIFConfig {
 	return WIFConfig{
@@ -50,12 +82,17 @@ func NewWIFConfig(audience, subjectTokenType, tokenURL string, scopes []string,
 
 // GetGCPTokenSource returns an oauth2.TokenSource for GCP using Workload Identity Federation.
 // This function is flexible: you can supply any TokenSupplier (static or dynamic).
-// Best practice: validate config, wrap with ReuseTokenSourceWithExpiry, and allow leeway config.
+// The returned source is wrapped with oauth2.ReuseTokenSourceWithExpiry, so the STS and IAM Credentials
+// (impersonation) calls only happen when the cached token is within leeway of its expiry.
+// leeway overrides cfg.Leeway; when neither is set DefaultLeeway is used.
 func GetGCPTokenSource(ctx context.Context, cfg WIFConfig, leeway ...time.Duration) (oauth2.TokenSource, error) {
 	// Validate required fields
-	if cfg.Audience == "" || cfg.SubjectTokenType == "" || cfg.TokenURL == "" || cfg.TokenSupplier == nil {
+	if cfg.Audience == "" || cfg.SubjectTokenType == "" || cfg.TokenURL == "" || (cfg.TokenSupplier == nil && cfg.AwsSupplier == nil) {
 		return nil, fmt.Errorf("missing required WIFConfig fields")
 	}
+	if cfg.TokenSupplier != nil && cfg.AwsSupplier != nil {
+		return nil, fmt.Errorf("invalid WIFConfig: set exactly one of TokenSupplier and AwsSupplier")
+	}
 
 	wifConfig := externalaccount.Config{
 		Audience:                       cfg.Audience,
@@ -64,21 +101,61 @@ func GetGCPTokenSource(ctx context.Context, cfg WIFConfig, leeway ...time.Durati
 		Scopes:                         cfg.Scopes,
 		ServiceAccountImpersonationURL: cfg.ServiceAccountImpersonationURL,
 		SubjectTokenSupplier:           cfg.TokenSupplier,
+		AwsSecurityCredentialsSupplier: cfg.AwsSupplier,
 	}
 
+	// Impersonation events name the caller of the exchange from the claims of its subject token
+	var subjects *subjectRecorder
+	if cfg.ServiceAccountImpersonationURL != "" && cfg.OnEvent != nil && cfg.TokenSupplier != nil {
+		subjects = &subjectRecorder{Supplier: cfg.TokenSupplier}
+		wifConfig.SubjectTokenSupplier = subjects
+	}
+
+	// externalaccount picks the HTTP client for STS and impersonation calls from the context
+	httpClient, recorder := stsHTTPClient(cfg)
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, httpClient)
+
 	ts, err := externalaccount.NewTokenSource(ctx, wifConfig)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create GCP WIF token source: %w", err)
 	}
 
-	return ts, nil
+	reuseLeeway := cfg.Leeway
+	if len(leeway) > 0 && leeway[0] > 0 {
+		reuseLeeway = leeway[0]
+	}
+	if reuseLeeway <= 0 {
+		reuseLeeway = DefaultLeeway
+	}
+	var src oauth2.TokenSource = &stsTokenSource{ctx: ctx, src: ts, recorder: recorder, skewDelay: cfg.SkewRetryDelay}
+	if cfg.ServiceAccountImpersonationURL != "" && cfg.OnEvent != nil {
+		var account string
+		if m := impersonationURLPattern.FindStringSubmatch(cfg.ServiceAccountImpersonationURL); m != nil {
+			account = m[1]
+		}
+		src = &impersonationAudit{
+			Source:        src,
+			Provider:      "sts",
+			OnEvent:       cfg.OnEvent.WithAttestation(cfg.Attestation),
+			Impersonation: oidcprovider.Impersonation{ServiceAccount: account, Scopes: cfg.Scopes},
+			Subjects:      subjects,
+		}
+	}
+	// Errors pass through the reuse wrapper unchanged, so callers still see *oidcprovider.TokenError
+	return oauth2.ReuseTokenSourceWithExpiry(nil, src, reuseLeeway), nil
 }
 
 // ValidatingTokenSource wraps an oauth2.TokenSource to allow explicit validity and expiry checks.
+// Store and StoreKey are optional: when set, the cached token is persisted there and reused by the
+// next process (e.g. a CLI run) while it is still valid, instead of exchanging a new STS token.
 type ValidatingTokenSource struct {
 	Source      oauth2.TokenSource
+	Store       oidcprovider.CacheStore
+	StoreKey    string // identifies the credential in Store, e.g. the WIF audience
 	leeway      time.Duration
+	mu          sync.Mutex
 	cachedToken *oauth2.Token
+	loaded      bool // Store was consulted
 }
 
 // NewValidatingTokenSource returns a TokenSource that caches and validates expiry with leeway.
@@ -88,7 +165,13 @@ func NewValidatingTokenSource(src oauth2.TokenSource, leeway time.Duration) *Val
 
 // Token returns a valid token, refreshing if expired or invalid.
 func (v *ValidatingTokenSource) Token() (*oauth2.Token, error) {
-	if v.cachedToken != nil && v.IsValid() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.cachedToken == nil && v.Store != nil && !v.loaded {
+		v.loaded = true
+		v.cachedToken = v.load()
+	}
+	if v.cachedToken != nil && v.isValid() {
 		return v.cachedToken, nil
 	}
 	tok, err := v.Source.Token()
@@ -96,11 +179,39 @@ func (v *ValidatingTokenSource) Token() (*oauth2.Token, error) {
 		return nil, err
 	}
 	v.cachedToken = tok
+	v.save(tok)
 	return tok, nil
 }
 
+// load reads a previously persisted token, nil if there is none or the store fails
+func (v *ValidatingTokenSource) load() *oauth2.Token {
+	stored, err := v.Store.Load(context.Background(), v.StoreKey)
+	if err != nil || stored.Token == "" {
+		return nil
+	}
+	return &oauth2.Token{AccessToken: stored.Token, TokenType: stored.TokenType, Expiry: stored.Expiry}
+}
+
+// save persists tok, failures only cost a new exchange on the next run
+func (v *ValidatingTokenSource) save(tok *oauth2.Token) {
+	if v.Store == nil {
+		return
+	}
+	_ = v.Store.Save(context.Background(), v.StoreKey, oidcprovider.StoredToken{
+		Token:     tok.AccessToken,
+		TokenType: tok.TokenType,
+		Expiry:    tok.Expiry,
+	})
+}
+
 // IsValid checks if the cached token is valid and not expired (with leeway).
 func (v *ValidatingTokenSource) IsValid() bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.isValid()
+}
+
+func (v *ValidatingTokenSource) isValid() bool {
 	if v.cachedToken == nil {
 		return false
 	}
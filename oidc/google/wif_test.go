@@ -3,16 +3,17 @@ package oidc_test
 import (
 	"context"
 	"errors"
+	"fmt"
 	"os"
 	"testing"
+	"time"
 
-	gcpwif "pcs-oidc/oidc/google"
+	gcpwif "github.com/PCS-Indonesia/pcs-oidc/oidc/google"
+	oidcprovider "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
 
-	"cloud.google.com/go/pubsub"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google/externalaccount"
-	"google.golang.org/api/option"
 )
 
 type dummyTokenSupplier struct {
@@ -90,70 +91,49 @@ func TestGetGCPTokenSource(t *testing.T) {
 	})
 }
 
-func TestMultipleWIFTokensAreValidForPubSub(t *testing.T) {
-	ctx := context.Background()
-	validToken := "YOUR_TOKEN_ACCESS" // JWT with exp in the far future, ganti dengan token valid
-
-	supplier := &gcpwif.StaticTokenSupplier{Token: validToken}
-	cfg := gcpwif.NewWIFConfig(
-		"YOUR_AUDIENCE",            // Change to your audience
-		"YOUR_SUBJECT_TOKEN_TYPE",  // Change to your subject token type
-		"YOUR_TOKEN_URL",           // Change to your token URL
-		[]string{"YOUR_SCOPES"},    // Change to your scopes
-		"YOUR_SERVICE_ACCOUNT_URL", // Change to your service account URL
-		supplier,
-	)
-
-	ts1, err := gcpwif.GetGCPTokenSource(ctx, cfg)
-	require.NoError(t, err)
-	token1, err := ts1.Token()
-	require.NoError(t, err)
-	require.NotEmpty(t, token1.AccessToken)
+// Tambahkan fungsi utilitas untuk menulis ke file
+func writeToFile(filename, content string) error {
+	return os.WriteFile(filename, []byte(content), 0600)
+}
 
-	ts2, err := gcpwif.GetGCPTokenSource(ctx, cfg)
-	require.NoError(t, err)
-	token2, err := ts2.Token()
-	require.NoError(t, err)
-	require.NotEmpty(t, token2.AccessToken)
-
-	// Kedua token harus berbeda (karena setiap call STS menghasilkan token baru), tapi keduanya valid
-	require.NotEqual(t, token1.AccessToken, token2.AccessToken)
-
-	// Coba gunakan kedua token untuk membuat client Pub/Sub
-	projectID := "YOUR_PROJECT_ID" // Ganti dengan project ID Anda
-	topicID := "YOUR_TOPIC_ID"     // Ganti dengan topic ID Anda
-	if projectID == "" || topicID == "" {
-		t.Skip("Skipping Pub/Sub test: GCP_PROJECT_ID or GCP_PUBSUB_TOPIC env not set")
-	}
-
-	tsPub1 := oauth2.StaticTokenSource(&oauth2.Token{
-		AccessToken: token1.AccessToken,
-		TokenType:   "Bearer",
-		Expiry:      token1.Expiry,
-	})
-	client1, err := pubsub.NewClient(ctx, projectID, option.WithTokenSource(tsPub1))
+// countingTokenSource returns a new token valid for an hour on every call
+type countingTokenSource struct {
+	calls int
+}
+
+func (c *countingTokenSource) Token() (*oauth2.Token, error) {
+	c.calls++
+	return &oauth2.Token{AccessToken: fmt.Sprintf("gcp-%d", c.calls), TokenType: "Bearer", Expiry: time.Now().Add(time.Hour)}, nil
+}
+
+func TestValidatingTokenSourceStore(t *testing.T) {
+	store, err := oidcprovider.NewFileCacheStore(t.TempDir())
 	require.NoError(t, err)
-	defer client1.Close()
-	topic1 := client1.Topic(topicID)
-	result1 := topic1.Publish(ctx, &pubsub.Message{Data: []byte("Test with token1")})
-	_, err = result1.Get(ctx)
+
+	// First "run" exchanges and persists the token
+	first := &countingTokenSource{}
+	vts := gcpwif.NewValidatingTokenSource(first, time.Minute)
+	vts.Store, vts.StoreKey = store, "wif-audience"
+	tok, err := vts.Token()
 	require.NoError(t, err)
+	require.Equal(t, "gcp-1", tok.AccessToken)
 
-	tsPub2 := oauth2.StaticTokenSource(&oauth2.Token{
-		AccessToken: token2.AccessToken,
-		TokenType:   "Bearer",
-		Expiry:      token2.Expiry,
-	})
-	client2, err := pubsub.NewClient(ctx, projectID, option.WithTokenSource(tsPub2))
+	// Second "run" reuses the persisted token without calling the source
+	second := &countingTokenSource{}
+	vts = gcpwif.NewValidatingTokenSource(second, time.Minute)
+	vts.Store, vts.StoreKey = store, "wif-audience"
+	tok, err = vts.Token()
 	require.NoError(t, err)
-	defer client2.Close()
-	topic2 := client2.Topic(topicID)
-	result2 := topic2.Publish(ctx, &pubsub.Message{Data: []byte("Test with token2")})
-	_, err = result2.Get(ctx)
+	require.Equal(t, "gcp-1", tok.AccessToken)
+	require.Zero(t, second.calls)
+
+	// An expired persisted token is replaced
+	require.NoError(t, store.Save(context.Background(), "wif-audience", oidcprovider.StoredToken{Token: "old", Expiry: time.Now().Add(-time.Minute)}))
+	third := &countingTokenSource{}
+	vts = gcpwif.NewValidatingTokenSource(third, time.Minute)
+	vts.Store, vts.StoreKey = store, "wif-audience"
+	tok, err = vts.Token()
 	require.NoError(t, err)
-}
-
-// Tambahkan fungsi utilitas untuk menulis ke file
-func writeToFile(filename, content string) error {
-	return os.WriteFile(filename, []byte(content), 0600)
+	require.Equal(t, "gcp-1", tok.AccessToken)
+	require.Equal(t, 1, third.calls)
 }
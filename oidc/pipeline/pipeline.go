@@ -0,0 +1,466 @@
+// Package pipeline builds credential pipelines from a declarative definition: a source provider,
+// optional token exchanges, an optional cloud target (GCP, AWS, Vault) and sinks handing the result
+// out (persistent cache, file, broker). Every stage has its own cache, metrics and events, so a
+// pipeline is the library's pieces wired together the same way every time.
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	oidcgoogle "github.com/PCS-Indonesia/pcs-oidc/oidc/google"
+	oidcprovider "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+	"github.com/PCS-Indonesia/pcs-oidc/oidc/tokenexchange"
+)
+
+// Sink kinds, see SinkSpec.
+const (
+	// SinkCache persists the final token in a FileCacheStore, so restarts reuse it.
+	SinkCache = "cache"
+	// SinkFile writes every new final token to a file, e.g. for a sidecar or credential_process.
+	SinkFile = "file"
+	// SinkBroker registers the final cache with the manager served by a broker.
+	SinkBroker = "broker"
+)
+
+// Definition describes one pipeline. It decodes from JSON with the Go field names, like the
+// profiles of oidcprovider.ConfigFile:
+//
+//	{
+//	  "Name": "orders-gcp",
+//	  "Source": {"Keycloak": {"KeycloakRealmURL": "https://kc/realms/pcs", "KeycloakClientID": "orders", "KeycloakClientSecret": "..."}},
+//	  "Target": {"GCP": {"Audience": "//iam.googleapis.com/projects/1/locations/global/workloadIdentityPools/p/providers/kc"}},
+//	  "Sinks": [{"Kind": "broker"}, {"Kind": "file", "Path": "/run/pcs/gcp-token"}]
+//	}
+type Definition struct {
+	Name      string
+	Source    SourceSpec
+	Exchanges []ExchangeSpec // applied in order to the source token
+	Target    *TargetSpec    // optional, without target the last exchanged token is the result
+	Sinks     []SinkSpec
+
+	// Insecure skips TLS verification in every stage (development only).
+	Insecure bool
+	// ClientTLS is the client certificate and CA bundle (CAFile) of the HTTP calls of every stage,
+	// optional. A Keycloak or Generic source with its own ClientTLS keeps it.
+	ClientTLS *oidcprovider.ClientTLS
+
+	// OnEvent receives the events of every stage, optional.
+	OnEvent oidcprovider.EventHandler `json:"-"`
+}
+
+// SourceSpec selects the provider of the first token, exactly one field must be set.
+type SourceSpec struct {
+	Keycloak *oidcprovider.ConfigKeyCloak
+	Generic  *oidcprovider.ConfigGeneric
+	Vault    *oidcprovider.ConfigVault
+	File     string // path of a token written by another process
+}
+
+// ExchangeSpec is one RFC 8693 token exchange, see tokenexchange.Client.
+type ExchangeSpec struct {
+	TokenURL           string
+	ClientID           string
+	ClientSecret       string
+	SubjectTokenType   string // default oidcprovider.TokenTypeAccessToken
+	RequestedTokenType string
+	Audience           []string
+	Resource           []string
+	Scopes             []string
+}
+
+// TargetSpec selects the cloud the token is finally exchanged with, exactly one field must be set.
+type TargetSpec struct {
+	GCP   *GCPTarget
+	AWS   *AWSTarget
+	Vault *VaultTarget
+}
+
+// GCPTarget exchanges the token for a Google access token with Workload Identity Federation.
+type GCPTarget struct {
+	Audience                       string // WIF provider audience, "//iam.googleapis.com/projects/..."
+	SubjectTokenType               string // default "urn:ietf:params:oauth:token-type:jwt"
+	TokenURL                       string // default the Google STS endpoint
+	Scopes                         []string
+	ServiceAccountImpersonationURL string
+}
+
+// SinkSpec is one consumer of the final token.
+type SinkSpec struct {
+	Kind string // SinkCache, SinkFile or SinkBroker
+	// Path is the file of SinkFile, or the directory of SinkCache (default the user cache directory).
+	Path string
+}
+
+// ParseDefinitions decodes a JSON array of definitions.
+func ParseDefinitions(data []byte) ([]Definition, error) {
+	var defs []Definition
+	if err := json.Unmarshal(data, &defs); err != nil {
+		return nil, fmt.Errorf("failed to parse pipeline definitions: %w", err)
+	}
+	return defs, nil
+}
+
+// LoadDefinitions reads a JSON array of definitions from path.
+func LoadDefinitions(path string) ([]Definition, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pipeline definitions: %w", err)
+	}
+	return ParseDefinitions(data)
+}
+
+// Stage is one step of a built pipeline.
+type Stage struct {
+	Name     string // "source", "exchange-1", ..., "target"
+	Cache    *oidcprovider.TokenCache
+	Provider *oidcprovider.MeteredProvider
+}
+
+// Pipeline is a built definition. Cache hands out the final token; Run feeds the file sinks.
+type Pipeline struct {
+	Name   string
+	Stages []Stage
+
+	files []string
+
+	mu      sync.Mutex
+	running bool
+}
+
+// Cache returns the cache of the final stage.
+func (p *Pipeline) Cache() *oidcprovider.TokenCache {
+	return p.Stages[len(p.Stages)-1].Cache
+}
+
+// Stats returns the fetch metrics of every stage, keyed by stage name.
+func (p *Pipeline) Stats() map[string]oidcprovider.FetchStats {
+	stats := make(map[string]oidcprovider.FetchStats, len(p.Stages))
+	for _, s := range p.Stages {
+		stats[s.Name] = s.Provider.Stats()
+	}
+	return stats
+}
+
+// BuildPipeline wires the stages of def, each wrapped in oidcprovider.Metered and cached with opts.
+// A store set by opts (oidcprovider.WithStore) is only used by the final stage, like the cache sink, so
+// the stages never overwrite each other's persisted token.
+// A broker sink registers the final cache with manager under def.Name; manager may be nil otherwise.
+func BuildPipeline(def Definition, manager *oidcprovider.Manager, opts ...oidcprovider.CacheOption) (*Pipeline, error) {
+	if def.Name == "" {
+		return nil, errors.New("pipeline definition has no Name")
+	}
+	p := &Pipeline{Name: def.Name}
+	env := stageEnv{onEvent: def.OnEvent, insecure: def.Insecure, clientTLS: def.ClientTLS}
+	source, err := newSource(def.Source, env)
+	if err != nil {
+		return nil, fmt.Errorf("pipeline %s: %w", def.Name, err)
+	}
+
+	// The store options only apply to the final cache, collect them before building the stages
+	finalOpts := append([]oidcprovider.CacheOption(nil), opts...)
+	stageOpts := append(append([]oidcprovider.CacheOption(nil), opts...), oidcprovider.WithStore(nil, ""))
+	broker := false
+	for _, sink := range def.Sinks {
+		switch sink.Kind {
+		case SinkCache:
+			store, err := oidcprovider.NewFileCacheStore(sink.Path)
+			if err != nil {
+				return nil, fmt.Errorf("pipeline %s: %w", def.Name, err)
+			}
+			finalOpts = append(finalOpts, oidcprovider.WithStore(store, "pipeline-"+def.Name))
+		case SinkFile:
+			if sink.Path == "" {
+				return nil, fmt.Errorf("pipeline %s: file sink has no Path", def.Name)
+			}
+			p.files = append(p.files, sink.Path)
+		case SinkBroker:
+			if manager == nil {
+				return nil, fmt.Errorf("pipeline %s: broker sink needs a manager", def.Name)
+			}
+			broker = true
+		default:
+			return nil, fmt.Errorf("pipeline %s: unknown sink kind %q", def.Name, sink.Kind)
+		}
+	}
+
+	var stages []oidcprovider.TokenProvider
+	var names []string
+	stages, names = append(stages, source), append(names, "source")
+	for i, ex := range def.Exchanges {
+		stages, names = append(stages, &exchangeStage{spec: ex, env: env}), append(names, fmt.Sprintf("exchange-%d", i+1))
+	}
+	if def.Target != nil {
+		target, err := newTarget(*def.Target, env)
+		if err != nil {
+			return nil, fmt.Errorf("pipeline %s: %w", def.Name, err)
+		}
+		stages, names = append(stages, target), append(names, "target")
+	}
+
+	var previous *oidcprovider.TokenCache
+	for i, provider := range stages {
+		if s, ok := provider.(subjectStage); ok {
+			s.setSubject(previous)
+		}
+		metered := oidcprovider.Metered(provider)
+		cacheOpts := stageOpts
+		if i == len(stages)-1 {
+			cacheOpts = finalOpts
+		}
+		previous = oidcprovider.NewTokenCache(metered, cacheOpts...)
+		p.Stages = append(p.Stages, Stage{Name: names[i], Cache: previous, Provider: metered})
+	}
+
+	if broker {
+		if err := manager.Add(oidcprovider.ManagedCredential{Name: def.Name, Kind: "pipeline", Cache: p.Cache()}); err != nil {
+			return nil, fmt.Errorf("pipeline %s: %w", def.Name, err)
+		}
+	}
+	return p, nil
+}
+
+// Run writes every new final token to the file sinks until ctx is done and returns ctx's error.
+// Without file sinks it returns immediately.
+func (p *Pipeline) Run(ctx context.Context) error {
+	if len(p.files) == 0 {
+		return nil
+	}
+	p.mu.Lock()
+	if p.running {
+		p.mu.Unlock()
+		return errors.New("pipeline is already running")
+	}
+	p.running = true
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		p.running = false
+		p.mu.Unlock()
+	}()
+
+	for update := range p.Cache().Watch(ctx) {
+		// A failed refresh keeps the previous file, its consumer still holds a valid token
+		if update.Err != nil {
+			continue
+		}
+		for _, path := range p.files {
+			if err := writeFile(path, update.Token); err != nil {
+				slog.Warn("pipeline file sink failed", "pipeline", p.Name, "path", path, "error", err)
+			}
+		}
+	}
+	return ctx.Err()
+}
+
+// Close stops the watches of every stage.
+func (p *Pipeline) Close() error {
+	for _, s := range p.Stages {
+		_ = s.Cache.Close()
+	}
+	return nil
+}
+
+// writeFile replaces path atomically with token, readable only by the current user.
+func writeFile(path, token string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".pipeline-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(token); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// stageEnv is shared by the stages of a pipeline: the events handler and the TLS settings.
+type stageEnv struct {
+	onEvent   oidcprovider.EventHandler
+	insecure  bool
+	clientTLS *oidcprovider.ClientTLS
+}
+
+// httpClient returns the client of the HTTP calls of stage name.
+func (e stageEnv) httpClient(name string) (*http.Client, error) {
+	return oidcprovider.NewMTLSHTTPClient(name, e.insecure, e.clientTLS)
+}
+
+// observe runs fetch and reports its request, fetched and failed events as provider, for the stages
+// calling endpoints directly rather than through a provider emitting its own events.
+func (e stageEnv) observe(provider string, fetch func() (string, error)) (string, error) {
+	emit(e.onEvent, oidcprovider.Event{Type: oidcprovider.EventTokenRequest, Provider: provider})
+	start := time.Now()
+	token, err := fetch()
+	if err != nil {
+		emit(e.onEvent, oidcprovider.Event{Type: oidcprovider.EventTokenFailed, Provider: provider, Duration: time.Since(start), Err: err})
+		return "", err
+	}
+	emit(e.onEvent, oidcprovider.Event{Type: oidcprovider.EventTokenFetched, Provider: provider, Duration: time.Since(start)})
+	return token, nil
+}
+
+func emit(h oidcprovider.EventHandler, ev oidcprovider.Event) {
+	if h == nil {
+		return
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	h(ev)
+}
+
+// newSource builds the provider selected by spec.
+func newSource(spec SourceSpec, env stageEnv) (oidcprovider.TokenProvider, error) {
+	var providers []oidcprovider.TokenProvider
+	if spec.Keycloak != nil {
+		cfg := *spec.Keycloak
+		if cfg.ClientTLS == nil {
+			cfg.ClientTLS = env.clientTLS
+		}
+		providers = append(providers, &oidcprovider.KeycloakTokenProvider{Config: &cfg, Insecure: env.insecure, OnEvent: env.onEvent})
+	}
+	if spec.Generic != nil {
+		cfg := *spec.Generic
+		if cfg.ClientTLS == nil {
+			cfg.ClientTLS = env.clientTLS
+		}
+		providers = append(providers, &oidcprovider.GenericProvider{Config: &cfg, Insecure: env.insecure, OnEvent: env.onEvent})
+	}
+	if spec.Vault != nil {
+		client, err := env.httpClient("vault")
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, &oidcprovider.VaultTokenProvider{Config: spec.Vault, HTTPClient: client, OnEvent: env.onEvent})
+	}
+	if spec.File != "" {
+		providers = append(providers, &oidcprovider.FileTokenProvider{Path: spec.File, OnEvent: env.onEvent})
+	}
+	if len(providers) != 1 {
+		return nil, errors.New("source must set exactly one of Keycloak, Generic, Vault and File")
+	}
+	return providers[0], nil
+}
+
+// newTarget builds the provider selected by spec.
+func newTarget(spec TargetSpec, env stageEnv) (oidcprovider.TokenProvider, error) {
+	var providers []oidcprovider.TokenProvider
+	if spec.GCP != nil {
+		providers = append(providers, &gcpStage{spec: *spec.GCP, env: env})
+	}
+	if spec.AWS != nil {
+		providers = append(providers, &awsStage{spec: *spec.AWS, env: env})
+	}
+	if spec.Vault != nil {
+		providers = append(providers, &vaultStage{spec: *spec.Vault, env: env})
+	}
+	if len(providers) != 1 {
+		return nil, errors.New("target must set exactly one of GCP, AWS and Vault")
+	}
+	return providers[0], nil
+}
+
+// subjectStage is a stage consuming the token of the previous stage.
+type subjectStage interface {
+	setSubject(cache *oidcprovider.TokenCache)
+}
+
+// exchangeStage exchanges the previous token at an RFC 8693 endpoint.
+type exchangeStage struct {
+	spec    ExchangeSpec
+	env     stageEnv
+	subject *oidcprovider.TokenCache
+}
+
+func (e *exchangeStage) setSubject(cache *oidcprovider.TokenCache) { e.subject = cache }
+
+// Kind returns the provider kind reported in snapshots.
+func (e *exchangeStage) Kind() string { return "token-exchange" }
+
+// FetchToken exchanges a valid token of the previous stage.
+func (e *exchangeStage) FetchToken(ctx context.Context) (string, error) {
+	subject, err := e.subject.GetValidToken(ctx)
+	if err != nil {
+		return "", err
+	}
+	return e.env.observe("token-exchange", func() (string, error) {
+		return e.exchange(ctx, subject)
+	})
+}
+
+func (e *exchangeStage) exchange(ctx context.Context, subject string) (string, error) {
+	httpClient, err := e.env.httpClient("token-exchange")
+	if err != nil {
+		return "", err
+	}
+	client := &tokenexchange.Client{TokenURL: e.spec.TokenURL, ClientID: e.spec.ClientID, ClientSecret: e.spec.ClientSecret, HTTPClient: httpClient}
+	resp, err := client.Exchange(ctx, tokenexchange.ExchangeRequest{
+		SubjectToken:       subject,
+		SubjectTokenType:   e.spec.SubjectTokenType,
+		RequestedTokenType: e.spec.RequestedTokenType,
+		Audience:           e.spec.Audience,
+		Resource:           e.spec.Resource,
+		Scopes:             e.spec.Scopes,
+	})
+	if err != nil {
+		return "", err
+	}
+	oidcprovider.ReportExpiry(ctx, resp.Expiry)
+	return resp.AccessToken, nil
+}
+
+// gcpStage exchanges the previous token for a Google access token.
+type gcpStage struct {
+	spec    GCPTarget
+	env     stageEnv
+	subject *oidcprovider.TokenCache
+
+	once   sync.Once
+	source *oidcprovider.TokenSourceProvider
+	err    error
+}
+
+func (g *gcpStage) setSubject(cache *oidcprovider.TokenCache) { g.subject = cache }
+
+// Kind returns the provider kind reported in snapshots.
+func (g *gcpStage) Kind() string { return "gcp" }
+
+// FetchToken returns a Google access token for a valid token of the previous stage.
+func (g *gcpStage) FetchToken(ctx context.Context) (string, error) {
+	g.once.Do(func() {
+		tokenType := g.spec.SubjectTokenType
+		if tokenType == "" {
+			tokenType = "urn:ietf:params:oauth:token-type:jwt"
+		}
+		tokenURL := g.spec.TokenURL
+		if tokenURL == "" {
+			tokenURL = tokenexchange.GoogleSTSURL
+		}
+		cfg := oidcgoogle.NewWIFConfig(g.spec.Audience, tokenType, tokenURL, g.spec.Scopes,
+			g.spec.ServiceAccountImpersonationURL, &oidcgoogle.TokenCacheSupplier{Cache: g.subject})
+		cfg.OnEvent = g.env.onEvent
+		if cfg.HTTPClient, g.err = g.env.httpClient("sts"); g.err != nil {
+			return
+		}
+		// The token source lives as long as the pipeline, not as long as the first fetch
+		ts, err := oidcgoogle.GetGCPTokenSource(context.Background(), cfg)
+		g.source, g.err = oidcprovider.FromTokenSource(ts, oidcprovider.TokenKindAccess), err
+	})
+	if g.err != nil {
+		return "", g.err
+	}
+	return g.source.FetchToken(ctx)
+}
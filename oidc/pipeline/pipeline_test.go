@@ -0,0 +1,209 @@
+package pipeline_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/PCS-Indonesia/pcs-oidc/oidc/pipeline"
+	oidcprovider "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+// makeJWT returns an unsigned JWT expiring in one hour with sub
+func makeJWT(sub string) string {
+	enc := base64.RawURLEncoding.EncodeToString
+	payload, _ := json.Marshal(map[string]interface{}{"sub": sub, "exp": time.Now().Add(time.Hour).Unix()})
+	return enc([]byte(`{"alg":"none"}`)) + "." + enc(payload) + ".sig"
+}
+
+// sourceFile writes a source token to a temporary file and returns its path
+func sourceFile(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(path, []byte(makeJWT("source")), 0o600))
+	return path
+}
+
+// newExchangeServer starts an RFC 8693 endpoint issuing a token for the received subject
+func newExchangeServer(t *testing.T) string {
+	t.Helper()
+	srv := httptest.NewServer(exchangeHandler(t))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+func exchangeHandler(t *testing.T) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		require.Equal(t, "urn:ietf:params:oauth:grant-type:token-exchange", r.PostForm.Get("grant_type"))
+		require.NotEmpty(t, r.PostForm.Get("subject_token"))
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token":      makeJWT("exchanged-for-" + r.PostForm.Get("audience")),
+			"issued_token_type": oidcprovider.TokenTypeAccessToken,
+			"token_type":        "Bearer",
+			"expires_in":        3600,
+		})
+	})
+}
+
+func TestBuildPipeline(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("source and exchange", func(t *testing.T) {
+		manager := oidcprovider.NewManager()
+		var events []oidcprovider.Event
+		p, err := pipeline.BuildPipeline(pipeline.Definition{
+			Name:      "orders",
+			Source:    pipeline.SourceSpec{File: sourceFile(t)},
+			Exchanges: []pipeline.ExchangeSpec{{TokenURL: newExchangeServer(t), Audience: []string{"billing"}}},
+			Sinks:     []pipeline.SinkSpec{{Kind: pipeline.SinkBroker}},
+			OnEvent:   func(ev oidcprovider.Event) { events = append(events, ev) },
+		}, manager)
+		require.NoError(t, err)
+		require.Len(t, p.Stages, 2)
+
+		token, err := p.Cache().GetValidToken(ctx)
+		require.NoError(t, err)
+		claims, err := oidcprovider.DecodeJWTClaims(token, false)
+		require.NoError(t, err)
+		require.Equal(t, "exchanged-for-billing", claims["sub"])
+
+		stats := p.Stats()
+		require.Equal(t, uint64(1), stats["source"].Requests)
+		require.Equal(t, uint64(1), stats["exchange-1"].Requests)
+		providers := map[string]bool{}
+		for _, ev := range events {
+			providers[ev.Provider] = true
+		}
+		require.True(t, providers["file"], "the source events reach OnEvent")
+		require.True(t, providers["token-exchange"], "the exchange events reach OnEvent")
+
+		cache, ok := manager.Cache("orders")
+		require.True(t, ok)
+		require.Same(t, p.Cache(), cache)
+	})
+
+	t.Run("tls settings reach every stage", func(t *testing.T) {
+		srv := httptest.NewTLSServer(exchangeHandler(t))
+		t.Cleanup(srv.Close)
+		def := pipeline.Definition{
+			Name:      "tls",
+			Source:    pipeline.SourceSpec{File: sourceFile(t)},
+			Exchanges: []pipeline.ExchangeSpec{{TokenURL: srv.URL}},
+		}
+		p, err := pipeline.BuildPipeline(def, nil)
+		require.NoError(t, err)
+		_, err = p.Cache().GetValidToken(ctx)
+		require.ErrorContains(t, err, "certificate")
+
+		def.Insecure = true
+		p, err = pipeline.BuildPipeline(def, nil)
+		require.NoError(t, err)
+		_, err = p.Cache().GetValidToken(ctx)
+		require.NoError(t, err)
+	})
+
+	t.Run("store options only apply to the final stage", func(t *testing.T) {
+		store := &recordingStore{}
+		p, err := pipeline.BuildPipeline(pipeline.Definition{
+			Name:      "stored",
+			Source:    pipeline.SourceSpec{File: sourceFile(t)},
+			Exchanges: []pipeline.ExchangeSpec{{TokenURL: newExchangeServer(t), Audience: []string{"billing"}}},
+		}, nil, oidcprovider.WithStore(store, "stored"))
+		require.NoError(t, err)
+		token, err := p.Cache().GetValidToken(ctx)
+		require.NoError(t, err)
+		require.Equal(t, []string{token}, store.saved)
+	})
+
+	t.Run("file sink", func(t *testing.T) {
+		out := filepath.Join(t.TempDir(), "out")
+		p, err := pipeline.BuildPipeline(pipeline.Definition{
+			Name:   "files",
+			Source: pipeline.SourceSpec{File: sourceFile(t)},
+			Sinks:  []pipeline.SinkSpec{{Kind: pipeline.SinkFile, Path: out}},
+		}, nil)
+		require.NoError(t, err)
+		runCtx, cancel := context.WithCancel(ctx)
+		done := make(chan error, 1)
+		go func() { done <- p.Run(runCtx) }()
+		require.Eventually(t, func() bool {
+			data, err := os.ReadFile(out)
+			return err == nil && len(data) > 0
+		}, 2*time.Second, 10*time.Millisecond)
+		info, err := os.Stat(out)
+		require.NoError(t, err)
+		require.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+		cancel()
+		require.ErrorIs(t, <-done, context.Canceled)
+		require.NoError(t, p.Close())
+	})
+
+	t.Run("invalid definitions", func(t *testing.T) {
+		_, err := pipeline.BuildPipeline(pipeline.Definition{Source: pipeline.SourceSpec{File: "x"}}, nil)
+		require.ErrorContains(t, err, "no Name")
+
+		_, err = pipeline.BuildPipeline(pipeline.Definition{Name: "none"}, nil)
+		require.ErrorContains(t, err, "exactly one")
+
+		_, err = pipeline.BuildPipeline(pipeline.Definition{Name: "broker", Source: pipeline.SourceSpec{File: "x"},
+			Sinks: []pipeline.SinkSpec{{Kind: pipeline.SinkBroker}}}, nil)
+		require.ErrorContains(t, err, "needs a manager")
+
+		_, err = pipeline.BuildPipeline(pipeline.Definition{Name: "sink", Source: pipeline.SourceSpec{File: "x"},
+			Sinks: []pipeline.SinkSpec{{Kind: "s3"}}}, nil)
+		require.ErrorContains(t, err, "unknown sink kind")
+
+		_, err = pipeline.BuildPipeline(pipeline.Definition{Name: "target", Source: pipeline.SourceSpec{File: "x"},
+			Target: &pipeline.TargetSpec{}}, nil)
+		require.ErrorContains(t, err, "exactly one")
+	})
+}
+
+// recordingStore records the saved tokens
+type recordingStore struct {
+	mu    sync.Mutex
+	saved []string
+}
+
+func (s *recordingStore) Load(context.Context, string) (oidcprovider.StoredToken, error) {
+	return oidcprovider.StoredToken{}, nil
+}
+
+func (s *recordingStore) Save(_ context.Context, _ string, token oidcprovider.StoredToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.saved = append(s.saved, token.Token)
+	return nil
+}
+
+func TestParseDefinitions(t *testing.T) {
+	defs, err := pipeline.ParseDefinitions([]byte(`[{
+		"Name": "orders-gcp",
+		"Source": {"Keycloak": {"KeycloakRealmURL": "https://kc/realms/pcs", "KeycloakClientID": "orders", "KeycloakClientSecret": "s"}},
+		"Target": {"GCP": {"Audience": "//iam.googleapis.com/projects/1/locations/global/workloadIdentityPools/p/providers/kc"}},
+		"Sinks": [{"Kind": "broker"}, {"Kind": "file", "Path": "/run/pcs/gcp-token"}]
+	}]`))
+	require.NoError(t, err)
+	require.Len(t, defs, 1)
+	require.Equal(t, "orders", defs[0].Source.Keycloak.KeycloakClientID)
+	require.NotNil(t, defs[0].Target.GCP)
+	require.Equal(t, pipeline.SinkFile, defs[0].Sinks[1].Kind)
+
+	p, err := pipeline.BuildPipeline(defs[0], oidcprovider.NewManager())
+	require.NoError(t, err)
+	require.Equal(t, []string{"source", "target"}, []string{p.Stages[0].Name, p.Stages[1].Name})
+
+	_, err = pipeline.ParseDefinitions([]byte(`{}`))
+	require.Error(t, err)
+}
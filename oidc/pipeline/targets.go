@@ -0,0 +1,210 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	oidcprovider "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+)
+
+// DefaultAWSSTSEndpoint is the global AWS STS endpoint.
+const DefaultAWSSTSEndpoint = "https://sts.amazonaws.com"
+
+// AWSTarget assumes an IAM role with the token (AssumeRoleWithWebIdentity). The result is a
+// credential_process document (see AWSCredentials), so a file sink can feed the AWS SDKs and CLI.
+type AWSTarget struct {
+	RoleARN     string
+	SessionName string        // default "pcs-oidc"
+	Duration    time.Duration // default the role's maximum session duration
+	Endpoint    string        // default DefaultAWSSTSEndpoint, e.g. https://sts.ap-southeast-3.amazonaws.com
+}
+
+// AWSCredentials is the credential_process output format of the AWS SDKs.
+type AWSCredentials struct {
+	Version         int
+	AccessKeyId     string
+	SecretAccessKey string
+	SessionToken    string
+	Expiration      time.Time
+}
+
+// VaultTarget logs in to Vault's JWT auth method with the token; the result is the Vault token.
+type VaultTarget struct {
+	Address   string // e.g. https://vault.example.com:8200
+	Namespace string // Vault Enterprise namespace, optional
+	Mount     string // mount path of the JWT auth method, default "jwt"
+	Role      string
+}
+
+// awsStage assumes the role of spec with the token of the previous stage.
+type awsStage struct {
+	spec    AWSTarget
+	env     stageEnv
+	subject *oidcprovider.TokenCache
+}
+
+func (a *awsStage) setSubject(cache *oidcprovider.TokenCache) { a.subject = cache }
+
+// Kind returns the provider kind reported in snapshots.
+func (a *awsStage) Kind() string { return "aws" }
+
+// FetchToken returns the JSON encoded AWSCredentials of the assumed role.
+func (a *awsStage) FetchToken(ctx context.Context) (string, error) {
+	if a.spec.RoleARN == "" {
+		return "", errors.New("AWS target configuration is incomplete: RoleARN must be provided")
+	}
+	subject, err := a.subject.GetValidToken(ctx)
+	if err != nil {
+		return "", err
+	}
+	return a.env.observe("aws", func() (string, error) {
+		return a.assumeRole(ctx, subject)
+	})
+}
+
+func (a *awsStage) assumeRole(ctx context.Context, subject string) (string, error) {
+	session := a.spec.SessionName
+	if session == "" {
+		session = "pcs-oidc"
+	}
+	form := url.Values{
+		"Action":           {"AssumeRoleWithWebIdentity"},
+		"Version":          {"2011-06-15"},
+		"RoleArn":          {a.spec.RoleARN},
+		"RoleSessionName":  {session},
+		"WebIdentityToken": {subject},
+	}
+	if a.spec.Duration > 0 {
+		form.Set("DurationSeconds", strconv.Itoa(int(a.spec.Duration/time.Second)))
+	}
+	endpoint := a.spec.Endpoint
+	if endpoint == "" {
+		endpoint = DefaultAWSSTSEndpoint
+	}
+	client, err := a.env.httpClient("aws")
+	if err != nil {
+		return "", err
+	}
+	body, status, err := post(ctx, client, "aws", endpoint, []byte(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	if status != http.StatusOK {
+		var e struct {
+			Error struct {
+				Code    string `xml:"Code"`
+				Message string `xml:"Message"`
+			} `xml:"Error"`
+		}
+		_ = xml.Unmarshal(body, &e)
+		return "", &oidcprovider.TokenError{Provider: "aws", StatusCode: status, Code: e.Error.Code, Description: e.Error.Message}
+	}
+	var r struct {
+		Credentials struct {
+			AccessKeyId     string    `xml:"AccessKeyId"`
+			SecretAccessKey string    `xml:"SecretAccessKey"`
+			SessionToken    string    `xml:"SessionToken"`
+			Expiration      time.Time `xml:"Expiration"`
+		} `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+	}
+	if err := xml.Unmarshal(body, &r); err != nil {
+		return "", fmt.Errorf("failed to parse AWS STS response: %w", err)
+	}
+	if r.Credentials.AccessKeyId == "" {
+		return "", errors.New("AWS STS response has no credentials")
+	}
+	creds, err := json.Marshal(AWSCredentials{
+		Version:         1,
+		AccessKeyId:     r.Credentials.AccessKeyId,
+		SecretAccessKey: r.Credentials.SecretAccessKey,
+		SessionToken:    r.Credentials.SessionToken,
+		Expiration:      r.Credentials.Expiration,
+	})
+	if err != nil {
+		return "", err
+	}
+	oidcprovider.ReportExpiry(ctx, r.Credentials.Expiration)
+	return string(creds), nil
+}
+
+// vaultStage logs in to Vault with the token of the previous stage.
+type vaultStage struct {
+	spec    VaultTarget
+	env     stageEnv
+	subject *oidcprovider.TokenCache
+}
+
+func (v *vaultStage) setSubject(cache *oidcprovider.TokenCache) { v.subject = cache }
+
+// Kind returns the provider kind reported in snapshots.
+func (v *vaultStage) Kind() string { return "vault" }
+
+// FetchToken returns a Vault token of the configured role.
+func (v *vaultStage) FetchToken(ctx context.Context) (string, error) {
+	if v.spec.Address == "" || v.spec.Role == "" {
+		return "", errors.New("Vault target configuration is incomplete: Address and Role must be provided")
+	}
+	subject, err := v.subject.GetValidToken(ctx)
+	if err != nil {
+		return "", err
+	}
+	return v.env.observe("vault", func() (string, error) {
+		return v.login(ctx, subject)
+	})
+}
+
+// login uses the Vault client of the provider package, which reports Vault's errors as TokenError.
+func (v *vaultStage) login(ctx context.Context, subject string) (string, error) {
+	client, err := v.env.httpClient("vault")
+	if err != nil {
+		return "", err
+	}
+	mount := strings.Trim(v.spec.Mount, "/")
+	if mount == "" {
+		mount = "jwt"
+	}
+	vault := &oidcprovider.VaultTokenProvider{
+		Config:     &oidcprovider.ConfigVault{Address: v.spec.Address, Namespace: v.spec.Namespace},
+		HTTPClient: client,
+	}
+	token, lease, err := vault.Login(ctx, mount, map[string]string{"role": v.spec.Role, "jwt": subject})
+	if err != nil {
+		return "", err
+	}
+	if lease > 0 {
+		oidcprovider.ReportExpiry(ctx, time.Now().Add(lease))
+	}
+	return token, nil
+}
+
+// post sends the form body to endpoint with client and returns the response body and status.
+func post(ctx context.Context, client *http.Client, name, endpoint string, body []byte) ([]byte, int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, 0, &oidcprovider.CanceledError{Provider: name, Err: err, Cause: context.Cause(ctx)}
+		}
+		return nil, 0, fmt.Errorf("%s request failed: %w", name, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read %s response: %w", name, err)
+	}
+	return data, resp.StatusCode, nil
+}
@@ -0,0 +1,108 @@
+package pipeline_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/PCS-Indonesia/pcs-oidc/oidc/pipeline"
+	oidcprovider "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAWSTarget(t *testing.T) {
+	ctx := context.Background()
+	expiration := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		require.Equal(t, "AssumeRoleWithWebIdentity", r.PostForm.Get("Action"))
+		require.NotEmpty(t, r.PostForm.Get("WebIdentityToken"))
+		if r.PostForm.Get("RoleArn") != "arn:aws:iam::1:role/orders" {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`<ErrorResponse><Error><Code>AccessDenied</Code><Message>not allowed</Message></Error></ErrorResponse>`))
+			return
+		}
+		_, _ = w.Write([]byte(`<AssumeRoleWithWebIdentityResponse><AssumeRoleWithWebIdentityResult><Credentials>` +
+			`<AccessKeyId>AKIA</AccessKeyId><SecretAccessKey>secret</SecretAccessKey><SessionToken>session</SessionToken>` +
+			`<Expiration>` + expiration.Format(time.RFC3339) + `</Expiration></Credentials></AssumeRoleWithWebIdentityResult></AssumeRoleWithWebIdentityResponse>`))
+	}))
+	t.Cleanup(srv.Close)
+
+	build := func(role string) *pipeline.Pipeline {
+		p, err := pipeline.BuildPipeline(pipeline.Definition{
+			Name:   "aws",
+			Source: pipeline.SourceSpec{File: sourceFile(t)},
+			Target: &pipeline.TargetSpec{AWS: &pipeline.AWSTarget{RoleARN: role, Endpoint: srv.URL}},
+		}, nil)
+		require.NoError(t, err)
+		return p
+	}
+
+	t.Run("credential_process document", func(t *testing.T) {
+		p := build("arn:aws:iam::1:role/orders")
+		doc, err := p.Cache().GetValidToken(ctx)
+		require.NoError(t, err)
+		var creds pipeline.AWSCredentials
+		require.NoError(t, json.Unmarshal([]byte(doc), &creds))
+		require.Equal(t, 1, creds.Version)
+		require.Equal(t, "AKIA", creds.AccessKeyId)
+		require.Equal(t, "session", creds.SessionToken)
+		require.True(t, expiration.Equal(creds.Expiration))
+		require.WithinDuration(t, expiration, p.Cache().Status().Expiry, time.Second)
+	})
+
+	t.Run("error response", func(t *testing.T) {
+		_, err := build("arn:aws:iam::1:role/other").Cache().GetValidToken(ctx)
+		var tErr *oidcprovider.TokenError
+		require.ErrorAs(t, err, &tErr)
+		require.Equal(t, "AccessDenied", tErr.Code)
+		require.Equal(t, http.StatusForbidden, tErr.StatusCode)
+	})
+}
+
+func TestVaultTarget(t *testing.T) {
+	ctx := context.Background()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/v1/auth/oidc-jwt/login", r.URL.Path)
+		require.Equal(t, "team", r.Header.Get("X-Vault-Namespace"))
+		var body map[string]string
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		if body["role"] != "orders" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"errors":["role not found"]}`))
+			return
+		}
+		require.NotEmpty(t, body["jwt"])
+		_, _ = w.Write([]byte(`{"auth":{"client_token":"hvs.token","lease_duration":600}}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	build := func(role string) *pipeline.Pipeline {
+		p, err := pipeline.BuildPipeline(pipeline.Definition{
+			Name:   "vault",
+			Source: pipeline.SourceSpec{File: sourceFile(t)},
+			Target: &pipeline.TargetSpec{Vault: &pipeline.VaultTarget{Address: srv.URL, Namespace: "team", Mount: "oidc-jwt", Role: role}},
+		}, nil)
+		require.NoError(t, err)
+		return p
+	}
+
+	t.Run("login", func(t *testing.T) {
+		p := build("orders")
+		token, err := p.Cache().GetValidToken(ctx)
+		require.NoError(t, err)
+		require.Equal(t, "hvs.token", token)
+		require.WithinDuration(t, time.Now().Add(10*time.Minute), p.Cache().Status().Expiry, 5*time.Second)
+	})
+
+	t.Run("error response", func(t *testing.T) {
+		_, err := build("other").Cache().GetValidToken(ctx)
+		var tErr *oidcprovider.TokenError
+		require.ErrorAs(t, err, &tErr)
+		require.Equal(t, "role not found", tErr.Description)
+	})
+}
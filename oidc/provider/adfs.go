@@ -0,0 +1,96 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// ConfigADFS holds configuration for Active Directory Federation Services 2019+ server applications
+// ServerURL is the federation service, e.g. https://adfs.corp.example.com, the /adfs path is appended
+// Resource is the relying party trust identifier the token is issued for and is required by ADFS
+type ConfigADFS struct {
+	ServerURL    string
+	ClientID     string
+	ClientSecret string
+	Resource     string    // relying party identifier, e.g. "https://orders.corp.example.com"
+	Scopes       []string  // optional, must be permitted for the application group
+	Token        TokenKind // default TokenKindAccess, TokenKindID also requests openid
+}
+
+// ADFSTokenProvider implements TokenProvider for ADFS using the client credentials grant
+// Credentials are sent in the request body and the audience as "resource" parameter,
+// which ADFS expects instead of the "audience" or resource scopes of other IdPs
+type ADFSTokenProvider struct {
+	Config   *ConfigADFS
+	Insecure bool         // skip TLS verification, on-prem ADFS often uses an internal CA
+	OnEvent  EventHandler // optional, receives request, fetched and failed events
+}
+
+// Kind returns the provider kind reported in snapshots
+func (a *ADFSTokenProvider) Kind() string {
+	return "adfs"
+}
+
+// Capabilities reports what the provider supports
+func (a *ADFSTokenProvider) Capabilities() Capabilities {
+	var kind TokenKind
+	if a.Config != nil {
+		kind = a.Config.Token
+	}
+	return tokenKindCapabilities(kind)
+}
+
+// Validate checks that server, client credentials and resource are present
+func (c *ConfigADFS) Validate() error {
+	if c == nil {
+		return errors.New("ADFS configuration is nil")
+	}
+	if c.ServerURL == "" || c.ClientID == "" || c.ClientSecret == "" || c.Resource == "" {
+		return errors.New("ADFS configuration is incomplete: ServerURL, ClientID, ClientSecret and Resource must be provided")
+	}
+	return nil
+}
+
+// TokenURL returns the ADFS OAuth2 token endpoint, https is assumed when ServerURL has no scheme
+func (c *ConfigADFS) TokenURL() string {
+	server := strings.TrimRight(c.ServerURL, "/")
+	if !strings.Contains(server, "://") {
+		server = "https://" + server
+	}
+	server = strings.TrimSuffix(server, "/adfs")
+	return server + "/adfs/oauth2/token"
+}
+
+// FetchToken fetches a new token from ADFS
+func (a *ADFSTokenProvider) FetchToken(ctx context.Context) (string, error) {
+	if err := a.Config.Validate(); err != nil {
+		return "", err
+	}
+	scopes := MergeScopes(a.Config.Scopes, ScopesFromContext(ctx))
+	if a.Config.Token == TokenKindID {
+		scopes = MergeScopes(DefaultScopes, scopes)
+	}
+	// ADFS reports errors as MSISxxxx codes in error_description, e.g. MSIS9602 for an unknown resource
+	return fetchClientCredentials(ctx, clientCredentialsRequest{
+		provider: "adfs",
+		idp:      "ADFS",
+		target:   fmt.Sprintf(" for resource %q", a.Config.Resource),
+		conf: &clientcredentials.Config{
+			ClientID:       a.Config.ClientID,
+			ClientSecret:   a.Config.ClientSecret,
+			TokenURL:       a.Config.TokenURL(),
+			Scopes:         scopes,
+			EndpointParams: url.Values{"resource": {a.Config.Resource}},
+			AuthStyle:      oauth2.AuthStyleInParams,
+		},
+		client:  NewHTTPClient("adfs", a.Insecure),
+		kind:    a.Config.Token,
+		onEvent: a.OnEvent,
+	})
+}
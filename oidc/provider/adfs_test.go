@@ -0,0 +1,70 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	oidc "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestADFSTokenProvider(t *testing.T) {
+	token := validJWT(t)
+	idToken := validJWT(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/adfs/oauth2/token", r.URL.Path)
+		require.NoError(t, r.ParseForm())
+		require.Equal(t, "client_credentials", r.PostForm.Get("grant_type"))
+		require.Equal(t, "app", r.PostForm.Get("client_id"))
+		require.Equal(t, "secret", r.PostForm.Get("client_secret"))
+		if r.PostForm.Get("resource") != "https://orders.corp.example.com" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"invalid_resource","error_description":"MSIS9602: The received \"resource\" parameter is invalid."}`))
+			return
+		}
+		resp := map[string]interface{}{"access_token": token, "token_type": "bearer", "expires_in": "3600"}
+		if r.PostForm.Get("scope") == "openid" {
+			resp["id_token"] = idToken
+		}
+		writeTokenResponse(w, resp)
+	}))
+	t.Cleanup(srv.Close)
+
+	cfg := &oidc.ConfigADFS{ServerURL: srv.URL + "/adfs/", ClientID: "app", ClientSecret: "secret", Resource: "https://orders.corp.example.com"}
+	require.Equal(t, srv.URL+"/adfs/oauth2/token", cfg.TokenURL())
+
+	t.Run("access token", func(t *testing.T) {
+		got, err := oidc.NewTokenCache(&oidc.ADFSTokenProvider{Config: cfg}).GetValidToken(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, token, got)
+	})
+
+	t.Run("id token", func(t *testing.T) {
+		idCfg := *cfg
+		idCfg.Token = oidc.TokenKindID
+		got, err := (&oidc.ADFSTokenProvider{Config: &idCfg}).FetchToken(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, idToken, got)
+	})
+
+	t.Run("invalid resource", func(t *testing.T) {
+		wrong := *cfg
+		wrong.Resource = "https://billing.corp.example.com"
+		_, err := (&oidc.ADFSTokenProvider{Config: &wrong}).FetchToken(context.Background())
+		var tErr *oidc.TokenError
+		require.True(t, errors.As(err, &tErr))
+		require.Equal(t, "adfs", tErr.Provider)
+		require.Equal(t, "invalid_resource", tErr.Code)
+		require.Contains(t, err.Error(), "MSIS9602")
+	})
+
+	t.Run("incomplete", func(t *testing.T) {
+		_, err := (&oidc.ADFSTokenProvider{Config: &oidc.ConfigADFS{ServerURL: srv.URL}}).FetchToken(context.Background())
+		require.ErrorContains(t, err, "Resource")
+	})
+}
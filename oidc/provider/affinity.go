@@ -0,0 +1,65 @@
+package oidc
+
+import (
+	"net/http"
+	"net/http/cookiejar"
+	"sync"
+)
+
+// SessionAffinity configures extra headers and cookies sent with every Keycloak token request
+// Load balancers in front of a Keycloak cluster use them to pin a client to one node,
+// so consecutive refreshes are served by the same node
+type SessionAffinity struct {
+	Headers http.Header    // e.g. a routing header read by the load balancer
+	Cookies []*http.Cookie // e.g. a fixed sticky-session cookie such as AUTH_SESSION_ID or a route cookie
+
+	// Sticky keeps cookies set by the load balancer between requests, so a node chosen
+	// on the first request is reused for later refreshes
+	Sticky bool
+
+	once sync.Once
+	jar  http.CookieJar
+}
+
+// cookieJar returns the jar shared by all requests of the provider, nil unless Sticky is set
+func (a *SessionAffinity) cookieJar() http.CookieJar {
+	if a == nil || !a.Sticky {
+		return nil
+	}
+	a.once.Do(func() {
+		// cookiejar.New only fails for an invalid PublicSuffixList option
+		a.jar, _ = cookiejar.New(nil)
+	})
+	return a.jar
+}
+
+// affinityTransport adds the configured headers and cookies to each request
+type affinityTransport struct {
+	Base     http.RoundTripper
+	Affinity *SessionAffinity
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *affinityTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// RoundTrippers must not modify the original request
+	req = req.Clone(req.Context())
+	for name, values := range t.Affinity.Headers {
+		for _, v := range values {
+			req.Header.Add(name, v)
+		}
+	}
+	for _, c := range t.Affinity.Cookies {
+		req.AddCookie(c)
+	}
+	return t.Base.RoundTrip(req)
+}
+
+// withAffinity applies the session affinity settings to client
+func withAffinity(client *http.Client, affinity *SessionAffinity) *http.Client {
+	if affinity == nil {
+		return client
+	}
+	client.Transport = &affinityTransport{Base: client.Transport, Affinity: affinity}
+	client.Jar = affinity.cookieJar()
+	return client
+}
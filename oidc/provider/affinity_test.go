@@ -0,0 +1,42 @@
+package oidc_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	oidc "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeycloakSessionAffinity(t *testing.T) {
+	var headers []http.Header
+	realm := newFakeKeycloak(t, func(w http.ResponseWriter, r *http.Request) {
+		headers = append(headers, r.Header.Clone())
+		// The load balancer pins the client to a node on the first request
+		if _, err := r.Cookie("lb-node"); err != nil {
+			http.SetCookie(w, &http.Cookie{Name: "lb-node", Value: "kc-2", Path: "/"})
+		}
+		writeTokenResponse(w, map[string]interface{}{"access_token": "a", "id_token": validJWT(t)})
+	})
+	p := &oidc.KeycloakTokenProvider{
+		Config: &oidc.ConfigKeyCloak{KeycloakRealmURL: realm, KeycloakClientID: "svc", KeycloakClientSecret: "secret"},
+		Affinity: &oidc.SessionAffinity{
+			Headers: http.Header{"X-Route": {"zone-a"}},
+			Cookies: []*http.Cookie{{Name: "AUTH_SESSION_ID", Value: "s1"}},
+			Sticky:  true,
+		},
+	}
+	for i := 0; i < 2; i++ {
+		_, err := p.FetchToken(context.Background())
+		require.NoError(t, err)
+	}
+	require.Len(t, headers, 2)
+	for _, h := range headers {
+		require.Equal(t, "zone-a", h.Get("X-Route"))
+		require.Contains(t, h.Get("Cookie"), "AUTH_SESSION_ID=s1")
+	}
+	require.NotContains(t, headers[0].Get("Cookie"), "lb-node")
+	require.Contains(t, headers[1].Get("Cookie"), "lb-node=kc-2")
+}
@@ -0,0 +1,105 @@
+package oidc
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// ClientAssertionType is the client_assertion_type for JWT client authentication (RFC 7523)
+const ClientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+// defaultAssertionLifetime is used when no assertion lifetime is configured
+const defaultAssertionLifetime = 2 * time.Minute
+
+// ClientAssertion builds a signed client assertion for private_key_jwt client authentication
+// iss and sub are the client ID, aud is the token endpoint URL and jti is random per call
+func (s *Signer) ClientAssertion(clientID, audience string, lifetime time.Duration) (string, error) {
+	if lifetime <= 0 {
+		lifetime = defaultAssertionLifetime
+	}
+	now := time.Now()
+	return s.Sign(map[string]interface{}{
+		"iss": clientID,
+		"sub": clientID,
+		"aud": audience,
+		"jti": randomID(),
+		"iat": now.Unix(),
+		"exp": now.Add(lifetime).Unix(),
+	}, nil)
+}
+
+// withClientAssertion returns a copy of conf authenticating with a fresh assertion of signer instead of
+// the client secret; every request gets a new jti, so the IdP's replay check never rejects a retry
+func withClientAssertion(conf *clientcredentials.Config, signer JWTSigner, lifetime time.Duration) (*clientcredentials.Config, error) {
+	assertion, err := signer.ClientAssertion(conf.ClientID, conf.TokenURL, lifetime)
+	if err != nil {
+		return nil, err
+	}
+	signed := *conf
+	signed.ClientSecret = ""
+	signed.AuthStyle = oauth2.AuthStyleInParams
+	signed.EndpointParams = url.Values{}
+	for k, v := range conf.EndpointParams {
+		signed.EndpointParams[k] = v
+	}
+	signed.EndpointParams.Set("client_assertion_type", ClientAssertionType)
+	signed.EndpointParams.Set("client_assertion", assertion)
+	return &signed, nil
+}
+
+// BearerGrantAssertion builds the assertion for the JWT-bearer authorization grant (RFC 7523 section 2.1)
+func (s *Signer) BearerGrantAssertion(issuer, subject, audience string, lifetime time.Duration) (string, error) {
+	if lifetime <= 0 {
+		lifetime = defaultAssertionLifetime
+	}
+	now := time.Now()
+	return s.Sign(map[string]interface{}{
+		"iss": issuer,
+		"sub": subject,
+		"aud": audience,
+		"jti": randomID(),
+		"iat": now.Unix(),
+		"exp": now.Add(lifetime).Unix(),
+	}, nil)
+}
+
+// DPoPProof builds a DPoP proof JWT (RFC 9449) for one HTTP request
+// accessToken is optional; when set the proof is bound to it with the ath claim
+func (s *Signer) DPoPProof(method, targetURL, accessToken string) (string, error) {
+	jwk, err := s.PublicJWK()
+	if err != nil {
+		return "", err
+	}
+	// The public key is embedded in the proof, kid and use are not part of it
+	jwk.Kid, jwk.Use, jwk.Alg = "", "", ""
+	claims := map[string]interface{}{
+		"jti": randomID(),
+		"htm": strings.ToUpper(method),
+		"htu": htu(targetURL),
+		"iat": time.Now().Unix(),
+	}
+	if accessToken != "" {
+		sum := sha256.Sum256([]byte(accessToken))
+		claims["ath"] = base64.RawURLEncoding.EncodeToString(sum[:])
+	}
+	proofSigner := *s
+	proofSigner.KeyID = ""
+	return proofSigner.Sign(claims, map[string]interface{}{"typ": "dpop+jwt", "jwk": jwk})
+}
+
+// htu strips query and fragment from the target URL as required for the DPoP htu claim
+func htu(target string) string {
+	u, err := url.Parse(target)
+	if err != nil {
+		return target
+	}
+	u.RawQuery = ""
+	u.Fragment = ""
+	return u.String()
+}
@@ -0,0 +1,77 @@
+package oidc
+
+import (
+	"strings"
+	"time"
+)
+
+// Attestation sources set by NewAttestation
+const (
+	AttestationSPIFFE     = "spiffe"
+	AttestationKubernetes = "kubernetes"
+)
+
+// Attestation holds verified claims about the workload that obtained a token
+// Attached to events and cache snapshots it lets audit logs correlate which workload identity
+// obtained which token
+type Attestation struct {
+	Source     string            `json:"source"`  // e.g. AttestationSPIFFE or AttestationKubernetes
+	Subject    string            `json:"subject"` // workload identity, e.g. the SPIFFE ID
+	Issuer     string            `json:"issuer,omitempty"`
+	Claims     map[string]string `json:"claims,omitempty"` // selected workload claims
+	VerifiedAt time.Time         `json:"verified_at"`
+}
+
+// NewAttestation builds an attestation from claims verified by a Verifier
+// The source is detected from the claims: SPIFFE IDs and Kubernetes projected service account tokens
+// have their workload details copied into Claims, other tokens are recorded with source "jwt"
+// The claims must already be verified, NewAttestation does not check signatures
+func NewAttestation(claims *Claims) *Attestation {
+	if claims == nil {
+		return nil
+	}
+	a := &Attestation{Source: "jwt", Subject: claims.Subject, Issuer: claims.Issuer, Claims: map[string]string{}, VerifiedAt: time.Now()}
+	if strings.HasPrefix(claims.Subject, "spiffe://") {
+		a.Source = AttestationSPIFFE
+	}
+	if k8s, ok := claims.Raw["kubernetes.io"].(map[string]interface{}); ok {
+		a.Source = AttestationKubernetes
+		if ns, ok := k8s["namespace"].(string); ok {
+			a.Claims["namespace"] = ns
+		}
+		for _, obj := range []string{"serviceaccount", "pod", "node"} {
+			if m, ok := k8s[obj].(map[string]interface{}); ok {
+				if name, ok := m["name"].(string); ok {
+					a.Claims[obj] = name
+				}
+				if uid, ok := m["uid"].(string); ok {
+					a.Claims[obj+"_uid"] = uid
+				}
+			}
+		}
+	}
+	if len(a.Claims) == 0 {
+		a.Claims = nil
+	}
+	return a
+}
+
+// WithAttestation returns a handler that attaches a to every event before passing it to h
+func (h EventHandler) WithAttestation(a *Attestation) EventHandler {
+	if h == nil {
+		return nil
+	}
+	return func(ev Event) {
+		if ev.Attestation == nil {
+			ev.Attestation = a
+		}
+		h(ev)
+	}
+}
+
+// WithAttestation attaches the attestation of the workload using the cache to its status and snapshots
+func WithAttestation(a *Attestation) CacheOption {
+	return func(c *TokenCache) {
+		c.attestation = a
+	}
+}
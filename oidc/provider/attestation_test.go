@@ -0,0 +1,64 @@
+package oidc_test
+
+import (
+	"context"
+	"testing"
+
+	oidc "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewAttestation(t *testing.T) {
+	t.Run("kubernetes", func(t *testing.T) {
+		a := oidc.NewAttestation(&oidc.Claims{
+			Issuer:  "https://kubernetes.default.svc",
+			Subject: "system:serviceaccount:orders:api",
+			Raw: map[string]interface{}{"kubernetes.io": map[string]interface{}{
+				"namespace":      "orders",
+				"serviceaccount": map[string]interface{}{"name": "api", "uid": "u-1"},
+				"pod":            map[string]interface{}{"name": "api-7d9", "uid": "u-2"},
+			}},
+		})
+		require.Equal(t, oidc.AttestationKubernetes, a.Source)
+		require.Equal(t, "system:serviceaccount:orders:api", a.Subject)
+		require.Equal(t, map[string]string{"namespace": "orders", "serviceaccount": "api", "serviceaccount_uid": "u-1", "pod": "api-7d9", "pod_uid": "u-2"}, a.Claims)
+	})
+
+	t.Run("spiffe", func(t *testing.T) {
+		a := oidc.NewAttestation(&oidc.Claims{Subject: "spiffe://example.org/orders"})
+		require.Equal(t, oidc.AttestationSPIFFE, a.Source)
+		require.Nil(t, a.Claims)
+	})
+
+	require.Nil(t, oidc.NewAttestation(nil))
+}
+
+func TestAttestationPassthrough(t *testing.T) {
+	a := oidc.NewAttestation(&oidc.Claims{Subject: "spiffe://example.org/orders"})
+
+	var events []oidc.Event
+	handler := oidc.EventHandler(func(ev oidc.Event) { events = append(events, ev) }).WithAttestation(a)
+	p := &oidc.SpiffeTokenProvider{Fetcher: staticSVIDFetcher(validJWT(t)), Audience: "gcp", OnEvent: handler}
+
+	cache := oidc.NewTokenCache(p, oidc.WithAttestation(a))
+	_, err := cache.GetValidToken(context.Background())
+	require.NoError(t, err)
+	require.NotEmpty(t, events)
+	for _, ev := range events {
+		require.Same(t, a, ev.Attestation)
+	}
+	require.Same(t, a, cache.Status().Attestation)
+
+	m := oidc.NewManager()
+	require.NoError(t, m.Add(oidc.ManagedCredential{Name: "orders", Cache: cache}))
+	require.Same(t, a, m.Snapshot()[0].Attestation)
+
+	require.Nil(t, oidc.EventHandler(nil).WithAttestation(a))
+}
+
+type staticSVIDFetcher string
+
+func (f staticSVIDFetcher) FetchJWTSVID(context.Context, string, string) (string, error) {
+	return string(f), nil
+}
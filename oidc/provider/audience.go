@@ -0,0 +1,143 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrUnknownAudience is returned by an AudienceMapper for an audience it has no mapping for
+var ErrUnknownAudience = errors.New("audience is not mapped")
+
+// ErrTooManyAudiences is returned by AudienceTokens once MaxAudiences audiences have a cache
+var ErrTooManyAudiences = errors.New("too many audiences")
+
+// DefaultMaxAudiences is the default AudienceTokens.MaxAudiences
+const DefaultMaxAudiences = 100
+
+// AudienceRequest describes how one audience is requested from Keycloak
+type AudienceRequest struct {
+	Audience string       // sent according to Mode, empty to not send it (e.g. scope based mapping)
+	Mode     AudienceMode // default AudienceParam
+	Scopes   []string     // extra scopes, e.g. a client scope whose audience mapper adds the audience
+}
+
+// AudienceMapper maps a requested audience to its request, an error rejects the audience
+type AudienceMapper func(audience string) (AudienceRequest, error)
+
+// AudienceParamMapper sends every audience as the "audience" parameter, the default of AudienceTokens
+func AudienceParamMapper() AudienceMapper {
+	return func(audience string) (AudienceRequest, error) {
+		return AudienceRequest{Audience: audience, Mode: AudienceParam}, nil
+	}
+}
+
+// AudienceExchangeMapper obtains every audience by token exchange, see AudienceExchange
+func AudienceExchangeMapper() AudienceMapper {
+	return func(audience string) (AudienceRequest, error) {
+		return AudienceRequest{Audience: audience, Mode: AudienceExchange}, nil
+	}
+}
+
+// AllowAudiences restricts mapper to the listed audiences, others are rejected with ErrUnknownAudience
+// Use it when the audience comes from callers, e.g. a request header, so they cannot mint tokens
+// for arbitrary services; a nil mapper uses AudienceParamMapper
+func AllowAudiences(mapper AudienceMapper, allowed ...string) AudienceMapper {
+	if mapper == nil {
+		mapper = AudienceParamMapper()
+	}
+	ok := make(map[string]bool, len(allowed))
+	for _, audience := range allowed {
+		ok[audience] = true
+	}
+	return func(audience string) (AudienceRequest, error) {
+		if !ok[audience] {
+			return AudienceRequest{}, fmt.Errorf("%w: %q", ErrUnknownAudience, audience)
+		}
+		return mapper(audience)
+	}
+}
+
+// ScopeAudienceMapper requests an audience with its client scopes, for realms where each service has
+// a client scope with an audience mapper instead of honouring the audience parameter
+// Audiences missing from scopes are rejected with ErrUnknownAudience
+func ScopeAudienceMapper(scopes map[string][]string) AudienceMapper {
+	return func(audience string) (AudienceRequest, error) {
+		s, ok := scopes[audience]
+		if !ok {
+			return AudienceRequest{}, fmt.Errorf("%w: %q", ErrUnknownAudience, audience)
+		}
+		return AudienceRequest{Scopes: s}, nil
+	}
+}
+
+// AudienceTokens requests tokens for many audiences from one Keycloak client, e.g. a gateway calling
+// several services that each only accept tokens carrying their own aud
+// Every audience gets its own provider and TokenCache on first use, listed by Manager under the audience
+// At most MaxAudiences audiences get a cache, restrict caller supplied audiences with AllowAudiences
+type AudienceTokens struct {
+	config   *ConfigKeyCloak
+	insecure bool
+	mapper   AudienceMapper
+	caches   *keyedCaches
+}
+
+// NewAudienceTokens validates cfg and returns per-audience tokens of its client
+// A nil mapper uses AudienceParamMapper, options are applied to every audience cache
+// With WithStore every audience persists its token under the store key followed by ":<audience>"
+func NewAudienceTokens(cfg *ConfigKeyCloak, insecure bool, mapper AudienceMapper, opts ...CacheOption) (*AudienceTokens, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if mapper == nil {
+		mapper = AudienceParamMapper()
+	}
+	caches := newKeyedCaches(opts)
+	caches.limit, caches.limitErr = DefaultMaxAudiences, ErrTooManyAudiences
+	return &AudienceTokens{config: cfg, insecure: insecure, mapper: mapper, caches: caches}, nil
+}
+
+// SetMaxAudiences changes how many audiences get a cache, default DefaultMaxAudiences;
+// zero or less removes the cap
+func (a *AudienceTokens) SetMaxAudiences(n int) {
+	a.caches.mu.Lock()
+	defer a.caches.mu.Unlock()
+	a.caches.limit = n
+}
+
+// Cache returns the cache of audience, creating its provider and cache on first use
+func (a *AudienceTokens) Cache(audience string) (*TokenCache, error) {
+	if audience == "" {
+		return nil, errors.New("audience must not be empty")
+	}
+	return a.caches.get(audience, func() (TokenProvider, string, error) {
+		req, err := a.mapper(audience)
+		if err != nil {
+			return nil, "", err
+		}
+		cfg := *a.config
+		cfg.Audience = req.Audience
+		cfg.AudienceMode = req.Mode
+		cfg.KeycloakClientScopes = append(append([]string(nil), a.config.KeycloakClientScopes...), req.Scopes...)
+		return &KeycloakTokenProvider{Config: &cfg, Insecure: a.insecure}, audience, nil
+	})
+}
+
+// TokenForAudience returns a valid token issued for audience
+func (a *AudienceTokens) TokenForAudience(ctx context.Context, audience string) (string, error) {
+	cache, err := a.Cache(audience)
+	if err != nil {
+		return "", err
+	}
+	return cache.GetValidToken(ctx)
+}
+
+// Audiences returns the audiences requested so far, sorted
+func (a *AudienceTokens) Audiences() []string {
+	return a.caches.keys()
+}
+
+// Manager returns the manager holding the audience caches created so far
+func (a *AudienceTokens) Manager() *Manager {
+	return a.caches.manager
+}
@@ -0,0 +1,113 @@
+package oidc_test
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	oidc "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAudienceTokens(t *testing.T) {
+	ctx := context.Background()
+	var (
+		mu       sync.Mutex
+		requests []string // audience param and scope of every request
+	)
+	realmURL := newFakeKeycloak(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r.Form.Get("audience")+"|"+r.Form.Get("scope"))
+		mu.Unlock()
+		aud := r.Form.Get("audience")
+		if aud == "" {
+			aud = "scope-mapped"
+		}
+		writeTokenResponse(w, map[string]interface{}{
+			"access_token": "at",
+			"id_token":     makeJWT(t, map[string]interface{}{"exp": time.Now().Add(time.Hour).Unix(), "aud": aud, "scope": r.Form.Get("scope")}),
+		})
+	})
+	cfg := &oidc.ConfigKeyCloak{KeycloakRealmURL: realmURL, KeycloakClientID: "gateway", KeycloakClientSecret: "secret", KeycloakClientScopes: []string{"profile"}}
+
+	t.Run("one cache per audience", func(t *testing.T) {
+		requests = nil
+		tokens, err := oidc.NewAudienceTokens(cfg, false, nil)
+		require.NoError(t, err)
+
+		for i := 0; i < 2; i++ {
+			for _, aud := range []string{"orders", "billing"} {
+				token, err := tokens.TokenForAudience(ctx, aud)
+				require.NoError(t, err)
+				claims, err := oidc.DecodeJWTClaims(token, false)
+				require.NoError(t, err)
+				require.Equal(t, aud, claims["aud"])
+			}
+		}
+		require.Equal(t, []string{"orders|openid profile", "billing|openid profile"}, requests)
+		require.Equal(t, []string{"billing", "orders"}, tokens.Audiences())
+		require.Len(t, tokens.Manager().Snapshot(), 2)
+	})
+
+	t.Run("scope per audience", func(t *testing.T) {
+		requests = nil
+		tokens, err := oidc.NewAudienceTokens(cfg, false, oidc.ScopeAudienceMapper(map[string][]string{
+			"orders": {"orders-audience"},
+		}))
+		require.NoError(t, err)
+
+		_, err = tokens.TokenForAudience(ctx, "orders")
+		require.NoError(t, err)
+		require.Len(t, requests, 1)
+		require.True(t, strings.HasPrefix(requests[0], "|"), "no audience parameter is sent")
+		require.Contains(t, requests[0], "orders-audience")
+		require.Equal(t, []string{"profile"}, cfg.KeycloakClientScopes, "the shared configuration is not modified")
+
+		_, err = tokens.TokenForAudience(ctx, "billing")
+		require.ErrorIs(t, err, oidc.ErrUnknownAudience)
+	})
+
+	t.Run("invalid input", func(t *testing.T) {
+		_, err := oidc.NewAudienceTokens(&oidc.ConfigKeyCloak{}, false, nil)
+		require.Error(t, err)
+
+		tokens, err := oidc.NewAudienceTokens(cfg, false, nil)
+		require.NoError(t, err)
+		_, err = tokens.TokenForAudience(ctx, "")
+		require.Error(t, err)
+	})
+	t.Run("caller supplied audiences", func(t *testing.T) {
+		tokens, err := oidc.NewAudienceTokens(cfg, false, oidc.AllowAudiences(nil, "orders", "billing"))
+		require.NoError(t, err)
+		_, err = tokens.TokenForAudience(ctx, "orders")
+		require.NoError(t, err)
+		_, err = tokens.TokenForAudience(ctx, "admin")
+		require.ErrorIs(t, err, oidc.ErrUnknownAudience)
+
+		tokens.SetMaxAudiences(1)
+		_, err = tokens.TokenForAudience(ctx, "billing")
+		require.ErrorIs(t, err, oidc.ErrTooManyAudiences)
+		_, err = tokens.TokenForAudience(ctx, "orders")
+		require.NoError(t, err, "audiences with a cache keep working")
+	})
+
+	t.Run("store key per audience", func(t *testing.T) {
+		store, err := oidc.NewFileCacheStore(t.TempDir())
+		require.NoError(t, err)
+		tokens, err := oidc.NewAudienceTokens(cfg, false, nil, oidc.WithStore(store, "gateway"))
+		require.NoError(t, err)
+		for _, aud := range []string{"orders", "billing"} {
+			_, err := tokens.TokenForAudience(ctx, aud)
+			require.NoError(t, err)
+			stored, err := store.Load(ctx, "gateway:"+aud)
+			require.NoError(t, err)
+			claims, err := oidc.DecodeJWTClaims(stored.Token, false)
+			require.NoError(t, err)
+			require.Equal(t, aud, claims["aud"])
+		}
+	})
+}
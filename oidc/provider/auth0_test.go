@@ -0,0 +1,62 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	oidc "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuth0TokenProvider(t *testing.T) {
+	token := validJWT(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/oauth/token", r.URL.Path)
+		require.NoError(t, r.ParseForm())
+		require.Equal(t, "client_credentials", r.PostForm.Get("grant_type"))
+		require.Equal(t, "app", r.PostForm.Get("client_id"))
+		if r.PostForm.Get("audience") != "https://orders.example.com" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"error":"access_denied","error_description":"Service not enabled within domain: https://billing.example.com"}`))
+			return
+		}
+		writeTokenResponse(w, map[string]interface{}{"access_token": token, "expires_in": 86400})
+	}))
+	t.Cleanup(srv.Close)
+
+	cfg := &oidc.ConfigAuth0{Domain: srv.URL, ClientID: "app", ClientSecret: "secret", Audience: "https://orders.example.com"}
+
+	t.Run("audience", func(t *testing.T) {
+		got, err := oidc.NewTokenCache(&oidc.Auth0TokenProvider{Config: cfg}).GetValidToken(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, token, got)
+	})
+
+	t.Run("error payload", func(t *testing.T) {
+		wrong := *cfg
+		wrong.Audience = "https://billing.example.com"
+		_, err := (&oidc.Auth0TokenProvider{Config: &wrong}).FetchToken(context.Background())
+		var tErr *oidc.TokenError
+		require.True(t, errors.As(err, &tErr))
+		require.Equal(t, "auth0", tErr.Provider)
+		require.Equal(t, http.StatusForbidden, tErr.StatusCode)
+		require.Equal(t, "access_denied", tErr.Code)
+		require.Contains(t, err.Error(), "Service not enabled within domain")
+	})
+
+	t.Run("audience required", func(t *testing.T) {
+		missing := *cfg
+		missing.Audience = ""
+		_, err := (&oidc.Auth0TokenProvider{Config: &missing}).FetchToken(context.Background())
+		require.ErrorContains(t, err, "Audience")
+	})
+}
+
+func TestConfigAuth0TokenURL(t *testing.T) {
+	require.Equal(t, "https://example.eu.auth0.com/oauth/token", (&oidc.ConfigAuth0{Domain: "example.eu.auth0.com"}).TokenURL())
+}
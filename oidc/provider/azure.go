@@ -0,0 +1,117 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// DefaultAzureAuthority is the Microsoft Entra ID (Azure AD) public cloud login endpoint
+const DefaultAzureAuthority = "https://login.microsoftonline.com"
+
+// TokenKind selects which token of a token response a provider returns
+type TokenKind int
+
+const (
+	// TokenKindAccess returns the access_token
+	TokenKindAccess TokenKind = iota
+	// TokenKindID returns the id_token, the response must contain one
+	TokenKindID
+)
+
+// ConfigAzure holds configuration for the Microsoft Entra ID (Azure AD) v2.0 token endpoint
+// Scope is the resource scope to request, for client credentials usually "<app-id-uri>/.default"
+type ConfigAzure struct {
+	TenantID     string
+	ClientID     string
+	ClientSecret string
+	Scope        string    // e.g. "api://orders/.default"
+	Authority    string    // default DefaultAzureAuthority, set for sovereign clouds or tests
+	Token        TokenKind // default TokenKindAccess
+}
+
+// AzureTokenProvider implements TokenProvider for Microsoft Entra ID (Azure AD)
+// using the client credentials grant against the v2.0 token endpoint
+// Entra access tokens are JWTs, so the provider works with TokenCache like KeycloakTokenProvider
+type AzureTokenProvider struct {
+	Config   *ConfigAzure
+	Insecure bool
+	OnEvent  EventHandler // optional, receives request, fetched and failed events
+}
+
+// Validate checks that tenant, client credentials and scope are present
+func (c *ConfigAzure) Validate() error {
+	if c == nil {
+		return errors.New("Azure configuration is nil")
+	}
+	if c.TenantID == "" || c.ClientID == "" || c.ClientSecret == "" || c.Scope == "" {
+		return errors.New("Azure configuration is incomplete: TenantID, ClientID, ClientSecret and Scope must be provided")
+	}
+	return nil
+}
+
+// TokenURL returns the v2.0 token endpoint of the configured tenant
+func (c *ConfigAzure) TokenURL() string {
+	authority := c.Authority
+	if authority == "" {
+		authority = DefaultAzureAuthority
+	}
+	return fmt.Sprintf("%s/%s/oauth2/v2.0/token", strings.TrimRight(authority, "/"), c.TenantID)
+}
+
+// Kind returns the provider kind reported in snapshots
+func (a *AzureTokenProvider) Kind() string {
+	return "azure"
+}
+
+// Capabilities reports what the provider supports
+func (a *AzureTokenProvider) Capabilities() Capabilities {
+	var kind TokenKind
+	if a.Config != nil {
+		kind = a.Config.Token
+	}
+	return tokenKindCapabilities(kind)
+}
+
+// FetchToken fetches a new token from Entra ID
+func (a *AzureTokenProvider) FetchToken(ctx context.Context) (string, error) {
+	if err := a.Config.Validate(); err != nil {
+		return "", err
+	}
+	return fetchClientCredentials(ctx, clientCredentialsRequest{
+		provider: "azure",
+		idp:      "Azure AD",
+		conf: &clientcredentials.Config{
+			ClientID:     a.Config.ClientID,
+			ClientSecret: a.Config.ClientSecret,
+			TokenURL:     a.Config.TokenURL(),
+			Scopes:       []string{a.Config.Scope},
+			// Entra ID expects the client credentials in the request body
+			AuthStyle: oauth2.AuthStyleInParams,
+		},
+		client:  NewHTTPClient("azure", a.Insecure),
+		kind:    a.Config.Token,
+		onEvent: a.OnEvent,
+	})
+}
+
+// tokenOfKind extracts the selected token from a token response, idp names the IdP in errors
+func tokenOfKind(ctx context.Context, token *oauth2.Token, kind TokenKind, idp string) (string, error) {
+	// The cache falls back to the expires_in of the response for tokens without exp claim
+	ReportExpiry(ctx, token.Expiry)
+	if kind == TokenKindID {
+		idToken, _ := token.Extra("id_token").(string)
+		if idToken == "" {
+			return "", fmt.Errorf("failed to extract id_token from %s token response", idp)
+		}
+		return idToken, nil
+	}
+	if token.AccessToken == "" {
+		return "", fmt.Errorf("failed to extract access_token from %s token response", idp)
+	}
+	return token.AccessToken, nil
+}
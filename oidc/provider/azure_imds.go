@@ -0,0 +1,121 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultAzureIMDSEndpoint is the managed identity token endpoint of the Azure Instance Metadata Service
+const DefaultAzureIMDSEndpoint = "http://169.254.169.254/metadata/identity/oauth2/token"
+
+// azureIMDSAPIVersion is the IMDS API version requested for managed identity tokens
+const azureIMDSAPIVersion = "2018-02-01"
+
+// AzureManagedIdentityProvider implements TokenProvider with managed identity access tokens from the
+// Azure Instance Metadata Service, for workloads on Azure VMs, scale sets and AKS node pools
+// The system-assigned identity is used unless one of ClientID, ObjectID or ResourceID selects a
+// user-assigned identity
+type AzureManagedIdentityProvider struct {
+	Resource   string       // required, e.g. "api://orders" or "https://management.azure.com/"
+	ClientID   string       // user-assigned identity client ID, optional
+	ObjectID   string       // user-assigned identity object ID, optional
+	ResourceID string       // user-assigned identity ARM resource ID, optional
+	Endpoint   string       // default DefaultAzureIMDSEndpoint, set for tests
+	HTTPClient *http.Client // optional, default NewHTTPClient("azure-imds", false)
+	OnEvent    EventHandler // optional, receives request, fetched and failed events
+}
+
+// Kind returns the provider kind reported in snapshots
+func (a *AzureManagedIdentityProvider) Kind() string {
+	return "azure-imds"
+}
+
+// FetchToken requests a new access token for Resource from IMDS
+func (a *AzureManagedIdentityProvider) FetchToken(ctx context.Context) (string, error) {
+	if a.Resource == "" {
+		return "", errors.New("Azure managed identity configuration is incomplete: Resource must be provided")
+	}
+	if selected := btoi(a.ClientID != "") + btoi(a.ObjectID != "") + btoi(a.ResourceID != ""); selected > 1 {
+		return "", errors.New("Azure managed identity configuration is invalid: set only one of ClientID, ObjectID and ResourceID")
+	}
+	a.OnEvent.emit(Event{Type: EventTokenRequest, Provider: "azure-imds"})
+	start := time.Now()
+	token, err := a.request(ctx)
+	if err != nil {
+		a.OnEvent.emit(Event{Type: EventTokenFailed, Provider: "azure-imds", Duration: time.Since(start), Err: err})
+		return "", err
+	}
+	a.OnEvent.emit(Event{Type: EventTokenFetched, Provider: "azure-imds", Duration: time.Since(start)})
+	return token, nil
+}
+
+func (a *AzureManagedIdentityProvider) request(ctx context.Context) (string, error) {
+	endpoint := a.Endpoint
+	if endpoint == "" {
+		endpoint = DefaultAzureIMDSEndpoint
+	}
+	q := url.Values{"api-version": {azureIMDSAPIVersion}, "resource": {a.Resource}}
+	switch {
+	case a.ClientID != "":
+		q.Set("client_id", a.ClientID)
+	case a.ObjectID != "":
+		q.Set("object_id", a.ObjectID)
+	case a.ResourceID != "":
+		q.Set("msi_res_id", a.ResourceID)
+	}
+	client := a.HTTPClient
+	if client == nil {
+		client = NewHTTPClient("azure-imds", false)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint+"?"+q.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata", "true")
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to get token from Azure IMDS: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", fmt.Errorf("failed to read Azure IMDS response: %w", err)
+	}
+	var out struct {
+		AccessToken      string `json:"access_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	_ = json.Unmarshal(body, &out)
+	if resp.StatusCode != http.StatusOK {
+		tErr := &TokenError{
+			Provider:    "azure-imds",
+			StatusCode:  resp.StatusCode,
+			Code:        out.Error,
+			Description: out.ErrorDescription,
+			RetryAfter:  ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+		}
+		if tErr.Code == "" {
+			tErr.Description = strings.TrimSpace(string(body))
+		}
+		return "", tErr
+	}
+	if out.AccessToken == "" {
+		return "", errors.New("failed to extract access_token from Azure IMDS response")
+	}
+	return out.AccessToken, nil
+}
+
+func btoi(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
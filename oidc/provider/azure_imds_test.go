@@ -0,0 +1,56 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	oidc "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAzureManagedIdentityProvider(t *testing.T) {
+	token := validJWT(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if r.Header.Get("Metadata") != "true" || q.Get("api-version") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		require.Equal(t, "api://orders", q.Get("resource"))
+		if q.Get("client_id") == "unknown" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"invalid_request","error_description":"Identity not found"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"` + token + `","expires_in":"86399","expires_on":"1700000000","resource":"api://orders","token_type":"Bearer"}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	t.Run("system assigned", func(t *testing.T) {
+		p := &oidc.AzureManagedIdentityProvider{Resource: "api://orders", Endpoint: srv.URL}
+		got, err := oidc.NewTokenCache(p).GetValidToken(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, token, got)
+	})
+
+	t.Run("user assigned", func(t *testing.T) {
+		p := &oidc.AzureManagedIdentityProvider{Resource: "api://orders", ClientID: "unknown", Endpoint: srv.URL}
+		_, err := p.FetchToken(context.Background())
+		var tErr *oidc.TokenError
+		require.True(t, errors.As(err, &tErr))
+		require.Equal(t, "invalid_request", tErr.Code)
+		require.Equal(t, "Identity not found", tErr.Description)
+	})
+
+	t.Run("invalid config", func(t *testing.T) {
+		_, err := (&oidc.AzureManagedIdentityProvider{}).FetchToken(context.Background())
+		require.Error(t, err)
+		_, err = (&oidc.AzureManagedIdentityProvider{Resource: "api://orders", ClientID: "a", ObjectID: "b"}).FetchToken(context.Background())
+		require.ErrorContains(t, err, "only one")
+	})
+}
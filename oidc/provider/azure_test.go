@@ -0,0 +1,106 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	oidc "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newFakeEntra starts a fake Entra ID authority serving the v2.0 token endpoint of tenant "tenant-1"
+func newFakeEntra(t *testing.T, handler http.HandlerFunc) string {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/tenant-1/oauth2/v2.0/token", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		handler(w, r)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+func azureConfig(authority string) *oidc.ConfigAzure {
+	return &oidc.ConfigAzure{
+		TenantID:     "tenant-1",
+		ClientID:     "app",
+		ClientSecret: "secret",
+		Scope:        "api://orders/.default",
+		Authority:    authority,
+	}
+}
+
+func TestAzureTokenProvider(t *testing.T) {
+	access, id := validJWT(t), validJWT(t)+"id"
+	authority := newFakeEntra(t, func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "client_credentials", r.PostForm.Get("grant_type"))
+		require.Equal(t, "app", r.PostForm.Get("client_id"))
+		require.Equal(t, "secret", r.PostForm.Get("client_secret"))
+		require.Equal(t, "api://orders/.default", r.PostForm.Get("scope"))
+		writeTokenResponse(w, map[string]interface{}{"access_token": access, "id_token": id, "expires_in": 3600})
+	})
+
+	t.Run("access token", func(t *testing.T) {
+		cache := oidc.NewTokenCache(&oidc.AzureTokenProvider{Config: azureConfig(authority)})
+		token, err := cache.GetValidToken(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, access, token)
+	})
+
+	t.Run("id token", func(t *testing.T) {
+		cfg := azureConfig(authority)
+		cfg.Token = oidc.TokenKindID
+		token, err := (&oidc.AzureTokenProvider{Config: cfg}).FetchToken(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, id, token)
+	})
+
+	t.Run("missing id_token is reported as failed", func(t *testing.T) {
+		noID := newFakeEntra(t, func(w http.ResponseWriter, r *http.Request) {
+			writeTokenResponse(w, map[string]interface{}{"access_token": access, "expires_in": 3600})
+		})
+		cfg := azureConfig(noID)
+		cfg.Token = oidc.TokenKindID
+		var events []oidc.Event
+		p := &oidc.AzureTokenProvider{Config: cfg, OnEvent: func(ev oidc.Event) { events = append(events, ev) }}
+		_, err := p.FetchToken(context.Background())
+		require.ErrorContains(t, err, "id_token")
+		require.Len(t, events, 2)
+		require.Equal(t, oidc.EventTokenFailed, events[1].Type)
+		require.Equal(t, "azure", events[1].Provider)
+		require.Error(t, events[1].Err)
+	})
+
+	t.Run("incomplete config", func(t *testing.T) {
+		cfg := azureConfig(authority)
+		cfg.Scope = ""
+		_, err := (&oidc.AzureTokenProvider{Config: cfg}).FetchToken(context.Background())
+		require.ErrorContains(t, err, "Azure configuration is incomplete")
+	})
+}
+
+func TestAzureTokenProviderError(t *testing.T) {
+	authority := newFakeEntra(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte(`{"error":"invalid_client","error_description":"AADSTS7000215: Invalid client secret provided."}`))
+	})
+	_, err := (&oidc.AzureTokenProvider{Config: azureConfig(authority)}).FetchToken(context.Background())
+	var tErr *oidc.TokenError
+	require.True(t, errors.As(err, &tErr))
+	require.Equal(t, "azure", tErr.Provider)
+	require.Equal(t, "invalid_client", tErr.Code)
+}
+
+func TestConfigAzureTokenURL(t *testing.T) {
+	cfg := &oidc.ConfigAzure{TenantID: "contoso.onmicrosoft.com"}
+	require.Equal(t, "https://login.microsoftonline.com/contoso.onmicrosoft.com/oauth2/v2.0/token", cfg.TokenURL())
+}
@@ -0,0 +1,88 @@
+package oidc
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// ErrInsufficientLifetime is returned in strict expiry mode (WithMinRemaining) when no token
+// with the required remaining lifetime is available
+var ErrInsufficientLifetime = errors.New("token remaining lifetime is below the required minimum")
+
+// defaultRefreshBuffer is how long before expiry the cache refreshes a token
+const defaultRefreshBuffer = time.Minute
+
+// insufficientLifetimeBackoff is how long ErrInsufficientLifetime is answered without asking the IdP
+// again after it issued a token shorter lived than WithMinRemaining requires
+const insufficientLifetimeBackoff = 30 * time.Second
+
+// CacheOption configures optional TokenCache behaviour
+type CacheOption func(*TokenCache)
+
+// WithRefreshRamp makes the cache spread its refreshes over the ramp window after an IdP outage
+// Share one ramp between all caches that talk to the same IdP
+func WithRefreshRamp(ramp *RefreshRamp) CacheOption {
+	return func(c *TokenCache) {
+		c.ramp = ramp
+	}
+}
+
+// WithSkewCompensation makes the cache convert token expiry to local time using clock,
+// which learns the issuer offset from the iat claim of every fetched token
+// Share clock with the verifiers of the same issuer (VerifierConfig.Clock)
+func WithSkewCompensation(clock *ClockOffset) CacheOption {
+	return func(c *TokenCache) {
+		c.clock = clock
+	}
+}
+
+// WithMinRemaining enables strict expiry: the cache never returns a token with less than d
+// remaining, refreshing earlier when d exceeds the default one minute buffer and returning an
+// error wrapping ErrInsufficientLifetime instead of a token that would expire mid-request
+// When the IdP itself issues shorter lived tokens the error is answered for insufficientLifetimeBackoff
+// before the next fetch, and a warning is logged once
+// Use it for downstream systems where failing fast beats a token expiring in flight
+func WithMinRemaining(d time.Duration) CacheOption {
+	return func(c *TokenCache) {
+		c.minRemaining = d
+	}
+}
+
+// WithStrictJWT makes the cache reject fetched tokens that fail strict JWT parsing (see DecodeJWTClaims),
+// e.g. a token with a string exp, instead of caching them with a misread expiry
+func WithStrictJWT() CacheOption {
+	return func(c *TokenCache) {
+		c.strictJWT = true
+	}
+}
+
+// refreshBuffer returns how long before expiry the cache refreshes a token
+func (c *TokenCache) refreshBuffer() time.Duration {
+	if c.minRemaining > defaultRefreshBuffer {
+		return c.minRemaining
+	}
+	return defaultRefreshBuffer
+}
+
+// checkLifetime rejects a freshly fetched token with less than the strict minimum remaining
+// The caller must hold c.mu
+func (c *TokenCache) checkLifetime() error {
+	remaining := time.Until(c.expiry)
+	if c.minRemaining <= 0 || remaining >= c.minRemaining {
+		c.insufficient = nil
+		return nil
+	}
+	if !c.insufficientWarned {
+		c.insufficientWarned = true
+		slog.Default().Warn("IdP issues tokens shorter lived than the cache minimum, check the token lifetime or WithMinRemaining",
+			slog.String("kind", providerKind(c.provider)),
+			slog.Duration("remaining", remaining.Round(time.Second)),
+			slog.Duration("required", c.minRemaining),
+		)
+	}
+	c.insufficient = fmt.Errorf("%w: fresh token expires in %s, %s required", ErrInsufficientLifetime, remaining.Round(time.Second), c.minRemaining)
+	c.insufficientUntil = time.Now().Add(insufficientLifetimeBackoff)
+	return c.insufficient
+}
@@ -0,0 +1,60 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	oidc "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithMinRemaining(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("refreshes early", func(t *testing.T) {
+		p := &stubProvider{token: makeJWT(t, map[string]interface{}{"exp": time.Now().Add(time.Hour).Unix()})}
+		cache := oidc.NewTokenCache(p, oidc.WithMinRemaining(5*time.Minute))
+		_, err := cache.GetValidToken(ctx)
+		require.NoError(t, err)
+		// Four minutes left: fine for the default buffer, too short for the strict minimum
+		cache.ForceExpire(time.Now().Add(4 * time.Minute))
+		_, err = cache.GetValidToken(ctx)
+		require.NoError(t, err)
+		require.EqualValues(t, 2, p.calls.Load())
+	})
+
+	t.Run("refresh failure is not masked", func(t *testing.T) {
+		p := &stubProvider{token: validJWT(t)}
+		cache := oidc.NewTokenCache(p, oidc.WithMinRemaining(5*time.Minute))
+		_, err := cache.GetValidToken(ctx)
+		require.NoError(t, err)
+		cache.ForceExpire(time.Now().Add(4 * time.Minute))
+		p.token, p.err = "", errors.New("idp down")
+		_, err = cache.GetValidToken(ctx)
+		require.ErrorContains(t, err, "idp down")
+	})
+
+	t.Run("short lived token rejected", func(t *testing.T) {
+		p := &stubProvider{token: makeJWT(t, map[string]interface{}{"exp": time.Now().Add(2 * time.Minute).Unix()})}
+		cache := oidc.NewTokenCache(p, oidc.WithMinRemaining(5*time.Minute))
+		_, err := cache.GetValidToken(ctx)
+		require.ErrorIs(t, err, oidc.ErrInsufficientLifetime)
+
+		// The IdP is not asked again for every call
+		for i := 0; i < 3; i++ {
+			_, err = cache.GetValidToken(ctx)
+			require.ErrorIs(t, err, oidc.ErrInsufficientLifetime)
+		}
+		require.EqualValues(t, 1, p.calls.Load())
+
+		// A forced refresh asks again
+		p.token = makeJWT(t, map[string]interface{}{"exp": time.Now().Add(time.Hour).Unix()})
+		cache.ForceExpire(time.Now())
+		_, err = cache.GetValidToken(ctx)
+		require.NoError(t, err)
+		require.EqualValues(t, 2, p.calls.Load())
+	})
+}
@@ -0,0 +1,48 @@
+package oidc
+
+// Capabilities describes what a provider supports, so generic orchestration code (broker, CLI, ...)
+// can disable unsupported operations instead of failing at runtime
+type Capabilities struct {
+	AccessToken   bool `json:"access_token"`   // FetchToken can return access tokens
+	IDToken       bool `json:"id_token"`       // FetchToken can return id tokens
+	RefreshToken  bool `json:"refresh_token"`  // tokens are renewed with a refresh token
+	Introspection bool `json:"introspection"`  // the IdP offers token introspection (RFC 7662)
+	Revocation    bool `json:"revocation"`     // the IdP offers token revocation (RFC 7009)
+	TokenExchange bool `json:"token_exchange"` // the IdP offers token exchange (RFC 8693)
+}
+
+// CapabilityReporter is implemented by providers that report their Capabilities
+type CapabilityReporter interface {
+	Capabilities() Capabilities
+}
+
+// CapabilitiesOf returns the capabilities of p
+// Providers that do not implement CapabilityReporter are assumed to return access tokens only
+func CapabilitiesOf(p TokenProvider) Capabilities {
+	if r, ok := p.(CapabilityReporter); ok {
+		return r.Capabilities()
+	}
+	return Capabilities{AccessToken: true}
+}
+
+// Intersect returns the capabilities supported by both c and other
+func (c Capabilities) Intersect(other Capabilities) Capabilities {
+	return Capabilities{
+		AccessToken:   c.AccessToken && other.AccessToken,
+		IDToken:       c.IDToken && other.IDToken,
+		RefreshToken:  c.RefreshToken && other.RefreshToken,
+		Introspection: c.Introspection && other.Introspection,
+		Revocation:    c.Revocation && other.Revocation,
+		TokenExchange: c.TokenExchange && other.TokenExchange,
+	}
+}
+
+// tokenKindCapabilities reports the token selected by kind
+func tokenKindCapabilities(kind TokenKind) Capabilities {
+	return Capabilities{AccessToken: kind != TokenKindID, IDToken: kind == TokenKindID}
+}
+
+// Capabilities returns the capabilities of the cached provider
+func (c *TokenCache) Capabilities() Capabilities {
+	return CapabilitiesOf(c.provider)
+}
@@ -0,0 +1,52 @@
+package oidc_test
+
+import (
+	"testing"
+
+	oidc "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCapabilities(t *testing.T) {
+	keycloak := &oidc.KeycloakTokenProvider{Config: &oidc.ConfigKeyCloak{}}
+	offline := &oidc.KeycloakTokenProvider{Config: &oidc.ConfigKeyCloak{OfflineAccess: true}}
+	github := &oidc.GitHubActionsProvider{}
+	idTokens := &oidc.GenericProvider{Config: &oidc.ConfigGeneric{Token: oidc.TokenKindID}}
+
+	t.Run("providers", func(t *testing.T) {
+		caps := oidc.CapabilitiesOf(keycloak)
+		require.False(t, caps.AccessToken)
+		require.True(t, caps.IDToken)
+		require.False(t, caps.RefreshToken)
+		require.True(t, caps.Introspection)
+		require.True(t, caps.Revocation)
+		require.True(t, caps.TokenExchange)
+		require.True(t, oidc.CapabilitiesOf(offline).RefreshToken)
+
+		require.Equal(t, oidc.Capabilities{IDToken: true}, oidc.CapabilitiesOf(github))
+		require.Equal(t, oidc.Capabilities{IDToken: true}, oidc.CapabilitiesOf(idTokens))
+		require.Equal(t, oidc.Capabilities{AccessToken: true}, oidc.CapabilitiesOf(&oidc.GenericProvider{}))
+		require.Equal(t, oidc.Capabilities{AccessToken: true}, oidc.CapabilitiesOf(&stubProvider{}))
+		require.Equal(t, oidc.Capabilities{AccessToken: true, Revocation: true}, oidc.CapabilitiesOf(&oidc.PingTokenProvider{}))
+
+		password := oidc.CapabilitiesOf(&oidc.KeycloakPasswordProvider{})
+		require.True(t, password.AccessToken)
+		require.False(t, password.IDToken)
+		require.True(t, password.Revocation)
+	})
+
+	t.Run("wrappers report the wrapped provider", func(t *testing.T) {
+		require.Equal(t, oidc.CapabilitiesOf(offline), oidc.NewTokenCache(offline).Capabilities())
+		require.Equal(t, oidc.CapabilitiesOf(offline), oidc.CapabilitiesOf(oidc.Metered(offline)))
+		require.Equal(t, oidc.CapabilitiesOf(offline), oidc.CapabilitiesOf(oidc.Logged(offline, nil, "orders")))
+	})
+
+	t.Run("chains report what every provider supports", func(t *testing.T) {
+		chain := &oidc.ChainProvider{Providers: []oidc.TokenProvider{offline, keycloak}}
+		require.Equal(t, oidc.CapabilitiesOf(keycloak), oidc.CapabilitiesOf(chain))
+		mixed := &oidc.ChainProvider{Providers: []oidc.TokenProvider{offline, oidc.NewFileTokenProvider("/dev/null")}}
+		require.Equal(t, oidc.Capabilities{}, oidc.CapabilitiesOf(mixed))
+		require.Equal(t, oidc.Capabilities{}, oidc.CapabilitiesOf(&oidc.ChainProvider{}))
+	})
+}
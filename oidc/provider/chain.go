@@ -0,0 +1,103 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ChainProvider implements TokenProvider over an ordered list of providers, trying the next one when
+// the previous fails, e.g. Keycloak first and a file with an emergency token as fallback:
+//
+//	p := &ChainProvider{Providers: []TokenProvider{keycloak, NewFileTokenProvider("/run/secrets/token")}}
+//
+// When all fail the returned *ChainError holds the error of every provider; a cancelled ctx stops the chain
+type ChainProvider struct {
+	Providers []TokenProvider
+	OnEvent   EventHandler // optional, receives a fallback event for every failed provider but the last
+}
+
+// ProviderError is the error of one provider of a chain
+type ProviderError struct {
+	Index int    // position in ChainProvider.Providers
+	Kind  string // provider kind, e.g. "keycloak"
+	Err   error
+}
+
+// Error returns the provider position, kind and error
+func (e ProviderError) Error() string {
+	return fmt.Sprintf("provider %d (%s): %s", e.Index, e.Kind, e.Err)
+}
+
+// Unwrap returns the provider's error
+func (e ProviderError) Unwrap() error {
+	return e.Err
+}
+
+// ChainError is returned by ChainProvider when no provider returned a token
+// errors.Is and errors.As match the error of any provider
+type ChainError struct {
+	Errors []ProviderError // in the order the providers were tried
+}
+
+// Error combines the errors of all providers tried
+func (e *ChainError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, pErr := range e.Errors {
+		msgs[i] = pErr.Error()
+	}
+	return "all providers failed: " + strings.Join(msgs, "; ")
+}
+
+// Unwrap returns the provider errors
+func (e *ChainError) Unwrap() []error {
+	errs := make([]error, len(e.Errors))
+	for i, pErr := range e.Errors {
+		errs[i] = pErr
+	}
+	return errs
+}
+
+// Kind returns the kind of the first provider
+func (c *ChainProvider) Kind() string {
+	if len(c.Providers) == 0 {
+		return "chain"
+	}
+	return providerKind(c.Providers[0])
+}
+
+// Capabilities reports what every provider of the chain supports, as any of them may serve the token
+func (c *ChainProvider) Capabilities() Capabilities {
+	if len(c.Providers) == 0 {
+		return Capabilities{}
+	}
+	caps := CapabilitiesOf(c.Providers[0])
+	for _, p := range c.Providers[1:] {
+		caps = caps.Intersect(CapabilitiesOf(p))
+	}
+	return caps
+}
+
+// FetchToken returns the token of the first provider that succeeds
+func (c *ChainProvider) FetchToken(ctx context.Context) (string, error) {
+	if len(c.Providers) == 0 {
+		return "", errors.New("provider chain is empty")
+	}
+	chainErr := &ChainError{}
+	for i, p := range c.Providers {
+		token, err := p.FetchToken(ctx)
+		if err == nil {
+			return token, nil
+		}
+		kind := providerKind(p)
+		chainErr.Errors = append(chainErr.Errors, ProviderError{Index: i, Kind: kind, Err: err})
+		if ctx.Err() != nil {
+			break
+		}
+		if i < len(c.Providers)-1 {
+			c.OnEvent.emit(Event{Type: EventFallback, Provider: kind, Err: err})
+		}
+	}
+	return "", chainErr
+}
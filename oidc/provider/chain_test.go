@@ -0,0 +1,87 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	oidc "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestChainProvider(t *testing.T) {
+	ctx := context.Background()
+	realmURL := newFakeKeycloak(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte(`{"error":"temporarily_unavailable"}`))
+	})
+	keycloak := &oidc.KeycloakTokenProvider{Config: &oidc.ConfigKeyCloak{
+		KeycloakRealmURL:     realmURL,
+		KeycloakClientID:     "svc",
+		KeycloakClientSecret: "secret",
+	}}
+	emergency := validJWT(t)
+	path := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(path, []byte(emergency), 0o600))
+
+	t.Run("falls back to the emergency file token", func(t *testing.T) {
+		var events []oidc.Event
+		chain := &oidc.ChainProvider{
+			Providers: []oidc.TokenProvider{keycloak, oidc.NewFileTokenProvider(path)},
+			OnEvent:   func(ev oidc.Event) { events = append(events, ev) },
+		}
+		got, err := chain.FetchToken(ctx)
+		require.NoError(t, err)
+		require.Equal(t, emergency, got)
+		require.Equal(t, "keycloak", chain.Kind())
+
+		require.Len(t, events, 1)
+		require.Equal(t, oidc.EventFallback, events[0].Type)
+		require.Equal(t, "keycloak", events[0].Provider)
+		require.Error(t, events[0].Err)
+	})
+
+	t.Run("combined error", func(t *testing.T) {
+		missing := oidc.NewFileTokenProvider(filepath.Join(t.TempDir(), "missing"))
+		chain := &oidc.ChainProvider{Providers: []oidc.TokenProvider{keycloak, missing}}
+		_, err := chain.FetchToken(ctx)
+
+		var chainErr *oidc.ChainError
+		require.ErrorAs(t, err, &chainErr)
+		require.Len(t, chainErr.Errors, 2)
+		require.Equal(t, 0, chainErr.Errors[0].Index)
+		require.Equal(t, "keycloak", chainErr.Errors[0].Kind)
+		require.Equal(t, 1, chainErr.Errors[1].Index)
+		require.ErrorIs(t, err, os.ErrNotExist)
+
+		var tErr *oidc.TokenError
+		require.ErrorAs(t, err, &tErr)
+		require.Equal(t, "temporarily_unavailable", tErr.Code)
+		require.ErrorContains(t, err, "all providers failed")
+	})
+
+	t.Run("cancelled context stops the chain", func(t *testing.T) {
+		second := &stubProvider{token: emergency}
+		cancelled, cancel := context.WithCancel(ctx)
+		first := oidc.ProviderFunc(func(context.Context) (string, error) {
+			cancel()
+			return "", context.Canceled
+		})
+		chain := &oidc.ChainProvider{Providers: []oidc.TokenProvider{first, second}}
+		_, err := chain.FetchToken(cancelled)
+		require.ErrorIs(t, err, context.Canceled)
+		require.Zero(t, second.calls.Load())
+	})
+
+	t.Run("empty chain", func(t *testing.T) {
+		_, err := (&oidc.ChainProvider{}).FetchToken(ctx)
+		require.Error(t, err)
+		var chainErr *oidc.ChainError
+		require.False(t, errors.As(err, &chainErr))
+	})
+}
@@ -0,0 +1,135 @@
+package oidc
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RFC 6750 section 3.1 error codes
+const (
+	BearerErrorInvalidRequest    = "invalid_request"
+	BearerErrorInvalidToken      = "invalid_token"
+	BearerErrorInsufficientScope = "insufficient_scope"
+)
+
+// AuthChallenge describes why the verification middleware rejects a request (RFC 6750 section 3)
+// A token that could not be checked because the signing keys are unavailable is no invalid token:
+// it is answered with 503 and Retry-After, without a challenge, so clients retry instead of
+// discarding a good token
+type AuthChallenge struct {
+	StatusCode  int    // 401, 403 for insufficient_scope, or 503 when the signing keys are unavailable
+	Code        string // RFC 6750 error code, empty when the request carried no token or for 503
+	Description string // error_description, human readable
+	Scope       string // space separated scopes required, set for insufficient_scope
+	Realm       string
+	RetryAfter  time.Duration // set with 503, sent as Retry-After
+	Err         error         // the verification error, nil when the token is missing
+}
+
+// Header returns the WWW-Authenticate header value
+func (c *AuthChallenge) Header() string {
+	var params []string
+	add := func(name, value string) {
+		if value != "" {
+			params = append(params, name+`="`+quoteEscaper.Replace(value)+`"`)
+		}
+	}
+	add("realm", c.Realm)
+	add("error", c.Code)
+	add("error_description", c.Description)
+	add("scope", c.Scope)
+	if len(params) == 0 {
+		return "Bearer"
+	}
+	return "Bearer " + strings.Join(params, ", ")
+}
+
+// quoteEscaper escapes auth-param quoted strings; RFC 6750 forbids '"' and '\' in the values
+var quoteEscaper = strings.NewReplacer(`\`, "", `"`, "'")
+
+// challengeFor builds the challenge for a verification failure
+func (v *Verifier) challengeFor(err error) *AuthChallenge {
+	c := &AuthChallenge{StatusCode: http.StatusUnauthorized, Realm: v.cfg.Realm, Err: err}
+	var verr *VerificationError
+	if !errors.As(err, &verr) {
+		c.Code, c.Description = BearerErrorInvalidToken, "token verification failed"
+		return c
+	}
+	switch verr.Reason {
+	case ReasonMissingToken:
+		// RFC 6750 section 3.1: no error code when the request lacks authentication
+		c.Err = nil
+	case ReasonKeysUnavailable:
+		c.StatusCode = http.StatusServiceUnavailable
+		c.Description = reasonDescriptions[verr.Reason]
+		c.RetryAfter = v.keysRetryAfter()
+	case ReasonInsufficientScope:
+		c.StatusCode = http.StatusForbidden
+		c.Code = BearerErrorInsufficientScope
+		c.Description = "the token lacks a required scope"
+		c.Scope = strings.Join(v.cfg.RequiredScopes, " ")
+	default:
+		c.Code = BearerErrorInvalidToken
+		c.Description = reasonDescriptions[verr.Reason]
+		if c.Description == "" {
+			c.Description = "the token is invalid"
+		}
+	}
+	return c
+}
+
+// keysRetryAfter returns when a request failing for unavailable keys may be retried: the key set is
+// fetched again after MinRefreshInterval at the earliest
+func (v *Verifier) keysRetryAfter() time.Duration {
+	v.mu.Lock()
+	keys := v.keys
+	v.mu.Unlock()
+	if keys == nil {
+		return (&JWKS{}).minRefreshInterval()
+	}
+	return keys.minRefreshInterval()
+}
+
+// reasonDescriptions are the error_description values sent for each failure reason
+// They stay generic so the response does not help probing the verifier configuration
+var reasonDescriptions = map[FailureReason]string{
+	ReasonMalformed:        "the token is malformed",
+	ReasonUnsupportedAlg:   "the token signing algorithm is not accepted",
+	ReasonUnknownKeyID:     "the token signing key is unknown",
+	ReasonBadSignature:     "the token signature is invalid",
+	ReasonExpired:          "the token expired",
+	ReasonNotYetValid:      "the token is not valid yet",
+	ReasonIssuerMismatch:   "the token issuer is not accepted",
+	ReasonAudienceMismatch: "the token audience is not accepted",
+	ReasonKeysUnavailable:  "the token could not be verified",
+}
+
+// setChallengeHeaders sets WWW-Authenticate, or Retry-After when the keys are unavailable
+func setChallengeHeaders(w http.ResponseWriter, c *AuthChallenge) {
+	if c.StatusCode == http.StatusServiceUnavailable {
+		secs := int64(math.Ceil(c.RetryAfter.Seconds()))
+		w.Header().Set("Retry-After", strconv.FormatInt(max(secs, 1), 10))
+		return
+	}
+	w.Header().Set("WWW-Authenticate", c.Header())
+}
+
+// writeChallenge sends the default response for a rejected request
+func writeChallenge(w http.ResponseWriter, r *http.Request, c *AuthChallenge) {
+	http.Error(w, http.StatusText(c.StatusCode), c.StatusCode)
+}
+
+// hasScopes reports whether the space separated scope claim contains every required scope
+func hasScopes(scope string, required []string) bool {
+	granted := strings.Fields(scope)
+	for _, s := range required {
+		if !containsString(granted, s) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,117 @@
+package oidc_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	oidc "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMiddlewareChallenge(t *testing.T) {
+	iss := newTestIssuer(t)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	call := func(mw http.Handler, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/orders", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		mw.ServeHTTP(rec, req)
+		return rec
+	}
+	mw := oidc.NewVerifier(oidc.VerifierConfig{
+		Issuer:         iss.URL,
+		Audience:       "api",
+		Realm:          "orders",
+		RequiredScopes: []string{"orders.read"},
+	}).Middleware(handler)
+
+	t.Run("missing token", func(t *testing.T) {
+		rec := call(mw, "")
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+		require.Equal(t, `Bearer realm="orders"`, rec.Header().Get("WWW-Authenticate"))
+	})
+
+	t.Run("invalid token", func(t *testing.T) {
+		claims := iss.claims("api")
+		claims["exp"] = 1
+		rec := call(mw, iss.sign(t, "RS256", "rsa", claims))
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+		require.Equal(t, `Bearer realm="orders", error="invalid_token", error_description="the token expired"`, rec.Header().Get("WWW-Authenticate"))
+	})
+
+	t.Run("insufficient scope", func(t *testing.T) {
+		claims := iss.claims("api")
+		claims["scope"] = "openid orders.write"
+		rec := call(mw, iss.sign(t, "RS256", "rsa", claims))
+		require.Equal(t, http.StatusForbidden, rec.Code)
+		require.Equal(t, `Bearer realm="orders", error="insufficient_scope", error_description="the token lacks a required scope", scope="orders.read"`, rec.Header().Get("WWW-Authenticate"))
+	})
+
+	t.Run("scope granted", func(t *testing.T) {
+		claims := iss.claims("api")
+		claims["scope"] = "openid orders.read"
+		require.Equal(t, http.StatusOK, call(mw, iss.sign(t, "RS256", "rsa", claims)).Code)
+	})
+
+	t.Run("keys unavailable", func(t *testing.T) {
+		down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+		}))
+		t.Cleanup(down.Close)
+		unavailable := oidc.NewVerifier(oidc.VerifierConfig{Issuer: iss.URL, JWKSURL: down.URL, Realm: "orders"}).Middleware(handler)
+		rec := call(unavailable, iss.sign(t, "RS256", "rsa", iss.claims("api")))
+		require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		require.Equal(t, "10", rec.Header().Get("Retry-After"))
+		require.Empty(t, rec.Header().Get("WWW-Authenticate"))
+	})
+
+	t.Run("custom body", func(t *testing.T) {
+		custom := oidc.NewVerifier(oidc.VerifierConfig{
+			Issuer: iss.URL,
+			OnReject: func(w http.ResponseWriter, r *http.Request, c *oidc.AuthChallenge) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(c.StatusCode)
+				_, _ = w.Write([]byte(`{"error":"` + c.Code + `"}`))
+			},
+		}).Middleware(handler)
+		rec := call(custom, "garbage")
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+		require.JSONEq(t, `{"error":"invalid_token"}`, rec.Body.String())
+		require.Contains(t, rec.Header().Get("WWW-Authenticate"), `error="invalid_token"`)
+	})
+}
+
+func TestMiddlewareOptional(t *testing.T) {
+	iss := newTestIssuer(t)
+	var anonymous, hasClaims bool
+	mw := oidc.NewVerifier(oidc.VerifierConfig{Issuer: iss.URL, Mode: oidc.VerifyModeOptional}).Middleware(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			anonymous = oidc.IsAnonymous(r.Context())
+			_, hasClaims = oidc.ClaimsFromContext(r.Context())
+		}))
+	call := func(auth string) int {
+		req := httptest.NewRequest(http.MethodGet, "/articles", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		rec := httptest.NewRecorder()
+		mw.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	require.Equal(t, http.StatusOK, call(""))
+	require.True(t, anonymous)
+	require.False(t, hasClaims)
+
+	require.Equal(t, http.StatusOK, call("Bearer "+iss.sign(t, "RS256", "rsa", iss.claims("api"))))
+	require.False(t, anonymous)
+	require.True(t, hasClaims)
+
+	// Presented credentials are never downgraded to anonymous
+	require.Equal(t, http.StatusUnauthorized, call("Bearer garbage"))
+	require.Equal(t, http.StatusUnauthorized, call("Basic dXNlcjpwYXNz"))
+}
@@ -0,0 +1,118 @@
+package oidc
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ClaimAssertion checks one claim of a fetched token
+// Assertions catch realm or client misconfiguration (missing audience mapper, wrong client)
+// when the token is fetched instead of when a downstream service rejects it
+type ClaimAssertion struct {
+	Claim    string
+	Equals   string // claim must be this string, ignored when empty
+	Contains string // claim (string or array) must contain this value, ignored when empty
+}
+
+// ClaimEquals requires claim to equal value, e.g. ClaimEquals("azp", clientID)
+func ClaimEquals(claim, value string) ClaimAssertion {
+	return ClaimAssertion{Claim: claim, Equals: value}
+}
+
+// ClaimContains requires claim to be value or an array containing it, e.g. ClaimContains("aud", "orders-api")
+func ClaimContains(claim, value string) ClaimAssertion {
+	return ClaimAssertion{Claim: claim, Contains: value}
+}
+
+// ClaimAssertionError is returned when a fetched token does not satisfy a ClaimAssertion
+// It points at the IdP configuration, retrying will not help
+type ClaimAssertionError struct {
+	Assertion ClaimAssertion
+	Got       interface{} // actual claim value, nil when missing
+}
+
+func (e *ClaimAssertionError) Error() string {
+	want := fmt.Sprintf("equal %q", e.Assertion.Equals)
+	if e.Assertion.Contains != "" {
+		want = fmt.Sprintf("contain %q", e.Assertion.Contains)
+	}
+	if e.Got == nil {
+		return fmt.Sprintf("token has no %q claim, expected it to %s: check the IdP client configuration", e.Assertion.Claim, want)
+	}
+	return fmt.Sprintf("token claim %q is %v, expected it to %s: check the IdP client configuration", e.Assertion.Claim, e.Got, want)
+}
+
+// Check validates the assertion against decoded claims
+func (a ClaimAssertion) Check(claims map[string]interface{}) error {
+	got, ok := claims[a.Claim]
+	if !ok {
+		return &ClaimAssertionError{Assertion: a}
+	}
+	if a.Equals != "" {
+		if s, _ := got.(string); s != a.Equals {
+			return &ClaimAssertionError{Assertion: a, Got: got}
+		}
+	}
+	if a.Contains != "" {
+		var found bool
+		switch v := got.(type) {
+		case string:
+			found = v == a.Contains
+		case []interface{}:
+			for _, item := range v {
+				if s, _ := item.(string); s == a.Contains {
+					found = true
+					break
+				}
+			}
+		}
+		if !found {
+			return &ClaimAssertionError{Assertion: a, Got: got}
+		}
+	}
+	return nil
+}
+
+// WithClaimAssertions makes the cache reject fetched tokens that do not satisfy every assertion
+// The rejected token is not cached and GetValidToken returns a *ClaimAssertionError
+func WithClaimAssertions(assertions ...ClaimAssertion) CacheOption {
+	return func(c *TokenCache) {
+		c.assertions = append(c.assertions, assertions...)
+	}
+}
+
+// checkClaims runs all assertions against the unverified payload of token
+func checkClaims(token string, assertions []ClaimAssertion) error {
+	if len(assertions) == 0 {
+		return nil
+	}
+	claims, err := decodeJWTClaims(token)
+	if err != nil {
+		return err
+	}
+	for _, a := range assertions {
+		if err := a.Check(claims); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeJWTClaims decodes the payload of a JWT without verifying it
+func decodeJWTClaims(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("invalid token format")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid token payload encoding: %w", err)
+	}
+	var claims map[string]interface{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("invalid token payload: %w", err)
+	}
+	return claims, nil
+}
@@ -0,0 +1,56 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	oidc "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClaimAssertions(t *testing.T) {
+	exp := time.Now().Add(time.Hour).Unix()
+	token := makeJWT(t, map[string]interface{}{"exp": exp, "azp": "svc", "aud": []interface{}{"account", "orders-api"}})
+
+	t.Run("matching token is cached", func(t *testing.T) {
+		stub := &stubProvider{token: token}
+		cache := oidc.NewTokenCache(stub, oidc.WithClaimAssertions(
+			oidc.ClaimEquals("azp", "svc"),
+			oidc.ClaimContains("aud", "orders-api"),
+		))
+		got, err := cache.GetValidToken(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, token, got)
+	})
+
+	t.Run("mismatch is rejected with a configuration error", func(t *testing.T) {
+		stub := &stubProvider{token: token}
+		cache := oidc.NewTokenCache(stub, oidc.WithClaimAssertions(oidc.ClaimContains("aud", "billing-api")))
+		_, err := cache.GetValidToken(context.Background())
+		var aErr *oidc.ClaimAssertionError
+		require.True(t, errors.As(err, &aErr))
+		require.Equal(t, "aud", aErr.Assertion.Claim)
+		require.Contains(t, err.Error(), "check the IdP client configuration")
+
+		// The rejected token was not cached, the next call asks the provider again
+		_, err = cache.GetValidToken(context.Background())
+		require.Error(t, err)
+		require.EqualValues(t, 2, stub.calls.Load())
+	})
+
+	t.Run("missing claim", func(t *testing.T) {
+		err := oidc.ClaimEquals("azp", "svc").Check(map[string]interface{}{})
+		var aErr *oidc.ClaimAssertionError
+		require.True(t, errors.As(err, &aErr))
+		require.Nil(t, aErr.Got)
+		require.Contains(t, err.Error(), `no "azp" claim`)
+	})
+
+	t.Run("single string audience", func(t *testing.T) {
+		require.NoError(t, oidc.ClaimContains("aud", "orders-api").Check(map[string]interface{}{"aud": "orders-api"}))
+		require.Error(t, oidc.ClaimEquals("azp", "svc").Check(map[string]interface{}{"azp": "other"}))
+	})
+}
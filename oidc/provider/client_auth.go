@@ -0,0 +1,152 @@
+package oidc
+
+import (
+	"crypto/hmac"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// ClientAuthMethod selects how a client authenticates at the token endpoint (OIDC Core section 9)
+// It is supported by ConfigKeyCloak, ConfigGeneric and ConfigPing
+type ClientAuthMethod string
+
+const (
+	// ClientAuthAuto keeps the provider default: private_key_jwt when an assertion signer is set,
+	// otherwise the client secret in the way the provider normally sends it
+	ClientAuthAuto ClientAuthMethod = ""
+	// ClientAuthSecretBasic sends the client secret with HTTP Basic authentication
+	ClientAuthSecretBasic ClientAuthMethod = "client_secret_basic"
+	// ClientAuthSecretPost sends the client secret as form parameter
+	ClientAuthSecretPost ClientAuthMethod = "client_secret_post"
+	// ClientAuthSecretJWT sends an assertion signed with the client secret (HS256), the secret itself
+	// never leaves the process
+	ClientAuthSecretJWT ClientAuthMethod = "client_secret_jwt"
+	// ClientAuthPrivateKeyJWT sends an assertion signed with the configured private key
+	ClientAuthPrivateKeyJWT ClientAuthMethod = "private_key_jwt"
+	// ClientAuthTLS authenticates with a CA-issued client certificate (RFC 8705 section 2.1)
+	ClientAuthTLS ClientAuthMethod = "tls_client_auth"
+	// ClientAuthSelfSignedTLS authenticates with a self-signed client certificate registered at the IdP
+	// (RFC 8705 section 2.2)
+	ClientAuthSelfSignedTLS ClientAuthMethod = "self_signed_tls_client_auth"
+)
+
+// validate checks that the credentials needed by the method are present
+func (m ClientAuthMethod) validate(secret string, signer JWTSigner, clientTLS *ClientTLS) error {
+	if err := checkSigner("assertion signer", signer); err != nil {
+		return err
+	}
+	switch m {
+	case ClientAuthAuto:
+	case ClientAuthSecretBasic, ClientAuthSecretPost, ClientAuthSecretJWT:
+		if secret == "" {
+			return fmt.Errorf("client authentication %s requires a client secret", m)
+		}
+	case ClientAuthPrivateKeyJWT:
+		if signer == nil {
+			return fmt.Errorf("client authentication %s requires an assertion signer", m)
+		}
+	case ClientAuthTLS, ClientAuthSelfSignedTLS:
+		if clientTLS == nil {
+			return fmt.Errorf("client authentication %s requires a client certificate", m)
+		}
+	default:
+		return fmt.Errorf("unsupported client authentication method %q", m)
+	}
+	return nil
+}
+
+// apply returns conf authenticating with the method; lifetime bounds signed assertions
+// Without secret and signer, ClientAuthAuto authenticates with the client certificate when one is set
+func (m ClientAuthMethod) apply(conf *clientcredentials.Config, signer JWTSigner, lifetime time.Duration, clientTLS *ClientTLS) (*clientcredentials.Config, error) {
+	if m == ClientAuthAuto && signer == nil && conf.ClientSecret == "" && clientTLS != nil {
+		m = ClientAuthTLS
+	}
+	switch m {
+	case ClientAuthSecretBasic:
+		basic := *conf
+		basic.AuthStyle = oauth2.AuthStyleInHeader
+		return &basic, nil
+	case ClientAuthSecretPost:
+		post := *conf
+		post.AuthStyle = oauth2.AuthStyleInParams
+		return &post, nil
+	case ClientAuthSecretJWT:
+		return withClientAssertion(conf, &SecretSigner{Secret: []byte(conf.ClientSecret)}, lifetime)
+	case ClientAuthPrivateKeyJWT:
+		return withClientAssertion(conf, signer, lifetime)
+	case ClientAuthTLS, ClientAuthSelfSignedTLS:
+		// The certificate authenticates the client, only client_id is sent
+		tlsAuth := *conf
+		tlsAuth.ClientSecret = ""
+		tlsAuth.AuthStyle = oauth2.AuthStyleInParams
+		return &tlsAuth, nil
+	}
+	if signer != nil {
+		return withClientAssertion(conf, signer, lifetime)
+	}
+	return conf, nil
+}
+
+// SecretSigner signs assertions with a shared secret (client_secret_jwt, RFC 7523 with HMAC)
+// It implements JWTSigner for providers taking an assertion signer; DPoP needs an asymmetric key
+type SecretSigner struct {
+	Secret    []byte
+	Algorithm string // HS256 (default), HS384 or HS512
+}
+
+// ClientAssertion builds a client assertion signed with the secret, see Signer.ClientAssertion
+func (s *SecretSigner) ClientAssertion(clientID, audience string, lifetime time.Duration) (string, error) {
+	return s.BearerGrantAssertion(clientID, clientID, audience, lifetime)
+}
+
+// BearerGrantAssertion builds a JWT-bearer grant assertion signed with the secret
+func (s *SecretSigner) BearerGrantAssertion(issuer, subject, audience string, lifetime time.Duration) (string, error) {
+	if lifetime <= 0 {
+		lifetime = defaultAssertionLifetime
+	}
+	now := time.Now()
+	return s.sign(map[string]interface{}{
+		"iss": issuer,
+		"sub": subject,
+		"aud": audience,
+		"jti": randomID(),
+		"iat": now.Unix(),
+		"exp": now.Add(lifetime).Unix(),
+	})
+}
+
+// DPoPProof always fails, DPoP proofs embed a public key
+func (s *SecretSigner) DPoPProof(method, targetURL, accessToken string) (string, error) {
+	return "", errors.New("DPoP proofs need an asymmetric key, use Signer")
+}
+
+func (s *SecretSigner) sign(claims map[string]interface{}) (string, error) {
+	if len(s.Secret) == 0 {
+		return "", errors.New("secret signer has no secret")
+	}
+	alg := s.Algorithm
+	if alg == "" {
+		alg = "HS256"
+	}
+	if alg != "HS256" && alg != "HS384" && alg != "HS512" {
+		return "", fmt.Errorf("unsupported HMAC algorithm %q", alg)
+	}
+	header, err := json.Marshal(map[string]string{"alg": alg, "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(hashForAlg(alg).New, s.Secret)
+	mac.Write([]byte(input))
+	return input + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
@@ -0,0 +1,130 @@
+package oidc_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	oidc "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+// verifyHS256 checks the HMAC signature of token with secret
+func verifyHS256(t *testing.T, token, secret string) {
+	t.Helper()
+	i := strings.LastIndex(token, ".")
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(token[:i]))
+	require.Equal(t, base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), token[i+1:])
+}
+
+func TestClientAuthMethod(t *testing.T) {
+	ctx := context.Background()
+	idToken := validJWT(t)
+	var form url.Values
+	var basicUser string
+	realm := newFakeKeycloak(t, func(w http.ResponseWriter, r *http.Request) {
+		form = r.PostForm
+		basicUser, _, _ = r.BasicAuth()
+		writeTokenResponse(w, map[string]interface{}{"access_token": "at", "id_token": idToken})
+	})
+	newProvider := func(method oidc.ClientAuthMethod) *oidc.KeycloakTokenProvider {
+		return &oidc.KeycloakTokenProvider{Config: &oidc.ConfigKeyCloak{
+			KeycloakRealmURL:     realm,
+			KeycloakClientID:     "client",
+			KeycloakClientSecret: "s3cret",
+			ClientAuthMethod:     method,
+		}}
+	}
+
+	t.Run("client_secret_jwt", func(t *testing.T) {
+		_, err := newProvider(oidc.ClientAuthSecretJWT).FetchToken(ctx)
+		require.NoError(t, err)
+		require.Empty(t, basicUser)
+		require.Empty(t, form.Get("client_secret"), "the secret never leaves the process")
+		require.Equal(t, oidc.ClientAssertionType, form.Get("client_assertion_type"))
+		assertion := form.Get("client_assertion")
+		require.Equal(t, "HS256", decodeSegment(t, assertion, 0)["alg"])
+		claims := decodeSegment(t, assertion, 1)
+		require.Equal(t, "client", claims["iss"])
+		require.Equal(t, realm+"/protocol/openid-connect/token", claims["aud"])
+		verifyHS256(t, assertion, "s3cret")
+	})
+
+	t.Run("client_secret_post", func(t *testing.T) {
+		_, err := newProvider(oidc.ClientAuthSecretPost).FetchToken(ctx)
+		require.NoError(t, err)
+		require.Empty(t, basicUser)
+		require.Equal(t, "s3cret", form.Get("client_secret"))
+	})
+
+	t.Run("client_secret_basic", func(t *testing.T) {
+		_, err := newProvider(oidc.ClientAuthSecretBasic).FetchToken(ctx)
+		require.NoError(t, err)
+		require.Equal(t, "client", basicUser)
+		require.Empty(t, form.Get("client_secret"))
+	})
+
+	t.Run("invalid configuration", func(t *testing.T) {
+		p := newProvider(oidc.ClientAuthSecretJWT)
+		p.Config.KeycloakClientSecret = ""
+		_, err := p.FetchToken(ctx)
+		require.ErrorContains(t, err, "requires a client secret")
+
+		_, err = newProvider(oidc.ClientAuthPrivateKeyJWT).FetchToken(ctx)
+		require.ErrorContains(t, err, "requires an assertion signer")
+
+		_, err = newProvider("none").FetchToken(ctx)
+		require.ErrorContains(t, err, "unsupported client authentication method")
+	})
+
+	t.Run("password and token exchange grants", func(t *testing.T) {
+		cfg := newProvider(oidc.ClientAuthSecretJWT).Config
+		cfg.Username, cfg.Password = "legacy", "pw"
+		_, err := (&oidc.KeycloakPasswordProvider{Config: cfg}).FetchToken(ctx)
+		require.NoError(t, err)
+		require.Equal(t, "password", form.Get("grant_type"))
+		require.Empty(t, form.Get("client_secret"))
+		verifyHS256(t, form.Get("client_assertion"), "s3cret")
+
+		_, err = oidc.NewKeycloakExchanger(cfg, false).Exchange(ctx, "subject", "orders-api")
+		require.NoError(t, err)
+		require.Equal(t, string(oidc.GrantTokenExchange), form.Get("grant_type"))
+		require.Empty(t, basicUser)
+		require.Empty(t, form.Get("client_secret"))
+		require.Equal(t, oidc.ClientAssertionType, form.Get("client_assertion_type"))
+		verifyHS256(t, form.Get("client_assertion"), "s3cret")
+	})
+
+	t.Run("generic provider", func(t *testing.T) {
+		var assertion string
+		issuer, _ := newFakeIssuer(t, "", []string{"client_secret_post"}, func(w http.ResponseWriter, r *http.Request) {
+			require.Empty(t, r.PostForm.Get("client_secret"))
+			assertion = r.PostForm.Get("client_assertion")
+			writeTokenResponse(w, map[string]interface{}{"access_token": "access"})
+		})
+		p := oidc.NewGenericProvider(issuer, "app", "generic-secret")
+		p.Config.ClientAuthMethod = oidc.ClientAuthSecretJWT
+		_, err := p.FetchToken(ctx)
+		require.NoError(t, err)
+		verifyHS256(t, assertion, "generic-secret")
+	})
+}
+
+func TestSecretSigner(t *testing.T) {
+	s := &oidc.SecretSigner{Secret: []byte("secret"), Algorithm: "HS512"}
+	assertion, err := s.ClientAssertion("client", "https://idp.example.com/token", 0)
+	require.NoError(t, err)
+	require.Equal(t, "HS512", decodeSegment(t, assertion, 0)["alg"])
+
+	_, err = (&oidc.SecretSigner{Secret: []byte("secret"), Algorithm: "RS256"}).ClientAssertion("client", "aud", 0)
+	require.Error(t, err)
+	_, err = s.DPoPProof("GET", "https://api.example.com", "")
+	require.Error(t, err)
+}
@@ -0,0 +1,57 @@
+package oidc
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// clientCredentialsRequest is one client credentials token request of a provider
+// Providers only build the endpoint, credentials and parameters, fetchClientCredentials does the rest
+type clientCredentialsRequest struct {
+	provider string // Event.Provider and TokenError.Provider, e.g. "azure"
+	idp      string // IdP named in error messages, e.g. "Azure AD"
+	target   string // optional, appended to the failure message, e.g. ` for audience "orders"`
+	conf     *clientcredentials.Config
+	client   *http.Client
+	kind     TokenKind
+	onEvent  EventHandler
+	check    func(raw string) error // optional, validates the selected token
+}
+
+// fetchClientCredentials requests a token and returns the selected kind of it
+// Request, fetched and failed events are emitted for every outcome, including responses without the
+// selected token and tokens rejected by check
+func fetchClientCredentials(ctx context.Context, req clientCredentialsRequest) (string, error) {
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, req.client)
+	req.onEvent.emit(Event{Type: EventTokenRequest, Provider: req.provider, Scopes: req.conf.Scopes})
+	start := time.Now()
+	raw, err := req.fetch(ctx)
+	if err != nil {
+		req.onEvent.emit(Event{Type: EventTokenFailed, Provider: req.provider, Scopes: req.conf.Scopes, Duration: time.Since(start), Err: err})
+		return "", err
+	}
+	req.onEvent.emit(Event{Type: EventTokenFetched, Provider: req.provider, Scopes: req.conf.Scopes, Duration: time.Since(start)})
+	return raw, nil
+}
+
+func (req clientCredentialsRequest) fetch(ctx context.Context) (string, error) {
+	token, err := req.conf.Token(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to get token from %s%s: %w", req.idp, req.target, asTokenError(req.provider, err))
+	}
+	raw, err := tokenOfKind(ctx, token, req.kind, req.idp)
+	if err != nil {
+		return "", err
+	}
+	if req.check != nil {
+		if err := req.check(raw); err != nil {
+			return "", err
+		}
+	}
+	return raw, nil
+}
@@ -0,0 +1,93 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// ConfigCognito holds configuration for an AWS Cognito user pool app client
+// Domain is the user pool domain, either the prefix domain
+// (https://example.auth.ap-southeast-3.amazoncognito.com) or a custom domain
+// Cognito only issues access tokens for client credentials; resource server scopes are
+// requested as "<resource-server-identifier>/<scope>", see CognitoScope
+type ConfigCognito struct {
+	Domain       string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string  // e.g. CognitoScope("orders", "read"), all allowed scopes when empty
+	Token        TokenKind // default TokenKindAccess
+}
+
+// CognitoScope builds a resource server custom scope ("identifier/scope")
+func CognitoScope(resourceServer, scope string) string {
+	return strings.TrimRight(resourceServer, "/") + "/" + scope
+}
+
+// CognitoTokenProvider implements TokenProvider for AWS Cognito user pools using the client credentials grant
+type CognitoTokenProvider struct {
+	Config   *ConfigCognito
+	Insecure bool
+	OnEvent  EventHandler // optional, receives request, fetched and failed events
+}
+
+// Validate checks that domain and client credentials are present
+func (c *ConfigCognito) Validate() error {
+	if c == nil {
+		return errors.New("Cognito configuration is nil")
+	}
+	if c.Domain == "" || c.ClientID == "" || c.ClientSecret == "" {
+		return errors.New("Cognito configuration is incomplete: Domain, ClientID and ClientSecret must be provided")
+	}
+	return nil
+}
+
+// TokenURL returns the user pool domain's token endpoint, https is assumed when Domain has no scheme
+func (c *ConfigCognito) TokenURL() string {
+	domain := strings.TrimRight(c.Domain, "/")
+	if !strings.Contains(domain, "://") {
+		domain = "https://" + domain
+	}
+	return domain + "/oauth2/token"
+}
+
+// Kind returns the provider kind reported in snapshots
+func (p *CognitoTokenProvider) Kind() string {
+	return "cognito"
+}
+
+// Capabilities reports what the provider supports
+func (p *CognitoTokenProvider) Capabilities() Capabilities {
+	var kind TokenKind
+	if p.Config != nil {
+		kind = p.Config.Token
+	}
+	caps := tokenKindCapabilities(kind)
+	caps.Revocation = true
+	return caps
+}
+
+// FetchToken fetches a new token from the Cognito user pool
+func (p *CognitoTokenProvider) FetchToken(ctx context.Context) (string, error) {
+	if err := p.Config.Validate(); err != nil {
+		return "", err
+	}
+	return fetchClientCredentials(ctx, clientCredentialsRequest{
+		provider: "cognito",
+		idp:      "Cognito",
+		conf: &clientcredentials.Config{
+			ClientID:     p.Config.ClientID,
+			ClientSecret: p.Config.ClientSecret,
+			TokenURL:     p.Config.TokenURL(),
+			Scopes:       MergeScopes(p.Config.Scopes, ScopesFromContext(ctx)),
+			// Cognito requires HTTP basic authentication for confidential app clients
+			AuthStyle: oauth2.AuthStyleInHeader,
+		},
+		client:  NewHTTPClient("cognito", p.Insecure),
+		kind:    p.Config.Token,
+		onEvent: p.OnEvent,
+	})
+}
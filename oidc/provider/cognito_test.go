@@ -0,0 +1,62 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	oidc "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCognitoTokenProvider(t *testing.T) {
+	access, id := validJWT(t), validJWT(t)+"id"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/oauth2/token", r.URL.Path)
+		require.NoError(t, r.ParseForm())
+		user, _, ok := r.BasicAuth()
+		require.True(t, ok)
+		require.Equal(t, "app", user)
+		if r.PostForm.Get("scope") != "https://orders.example.com/read" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"invalid_scope"}`))
+			return
+		}
+		writeTokenResponse(w, map[string]interface{}{"access_token": access, "id_token": id, "expires_in": 3600})
+	}))
+	t.Cleanup(srv.Close)
+
+	cfg := &oidc.ConfigCognito{
+		Domain:       srv.URL,
+		ClientID:     "app",
+		ClientSecret: "secret",
+		Scopes:       []string{oidc.CognitoScope("https://orders.example.com/", "read")},
+	}
+
+	t.Run("access token", func(t *testing.T) {
+		got, err := oidc.NewTokenCache(&oidc.CognitoTokenProvider{Config: cfg}).GetValidToken(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, access, got)
+	})
+
+	t.Run("id token", func(t *testing.T) {
+		idCfg := *cfg
+		idCfg.Token = oidc.TokenKindID
+		got, err := (&oidc.CognitoTokenProvider{Config: &idCfg}).FetchToken(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, id, got)
+	})
+
+	t.Run("invalid scope", func(t *testing.T) {
+		bad := *cfg
+		bad.Scopes = []string{oidc.CognitoScope("https://orders.example.com", "admin")}
+		_, err := (&oidc.CognitoTokenProvider{Config: &bad}).FetchToken(context.Background())
+		var tErr *oidc.TokenError
+		require.True(t, errors.As(err, &tErr))
+		require.Equal(t, "invalid_scope", tErr.Code)
+	})
+}
@@ -0,0 +1,218 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Combinators build credential chains declaratively instead of writing a wrapper struct each time:
+//
+//	p := Cached(Logged(Metered(WithFallback(keycloak, file)), nil, "orders"))
+//
+// An oauth2.TokenSource joins a chain through FromTokenSource, TokenCache.TokenSource turns the result
+// back into a source:
+//
+//	ts := Cached(WithFallback(FromTokenSource(gcp, TokenKindAccess), file)).TokenSource(ctx)
+
+// ProviderFunc adapts a function to TokenProvider
+type ProviderFunc func(ctx context.Context) (string, error)
+
+// FetchToken calls f
+func (f ProviderFunc) FetchToken(ctx context.Context) (string, error) {
+	return f(ctx)
+}
+
+// FirstOf returns a ChainProvider trying providers in order and returning the first token obtained
+func FirstOf(providers ...TokenProvider) TokenProvider {
+	return &ChainProvider{Providers: providers}
+}
+
+// WithFallback returns a provider using fallback only when primary fails
+func WithFallback(primary, fallback TokenProvider) TokenProvider {
+	return FirstOf(primary, fallback)
+}
+
+// Cached returns a TokenCache over p, so tokens are reused until they expire
+func Cached(p TokenProvider, opts ...CacheOption) *TokenCache {
+	return NewTokenCache(p, opts...)
+}
+
+// loggedProvider logs every fetch of the wrapped provider
+type loggedProvider struct {
+	provider TokenProvider
+	logger   *slog.Logger
+	name     string
+}
+
+// Logged returns a provider logging the outcome and latency of every fetch of p under name
+// The token itself is never logged; a nil logger uses slog.Default()
+func Logged(p TokenProvider, logger *slog.Logger, name string) TokenProvider {
+	return &loggedProvider{provider: p, logger: logger, name: name}
+}
+
+// Kind returns the kind of the wrapped provider
+func (l *loggedProvider) Kind() string {
+	return providerKind(l.provider)
+}
+
+// Capabilities returns the capabilities of the wrapped provider
+func (l *loggedProvider) Capabilities() Capabilities {
+	return CapabilitiesOf(l.provider)
+}
+
+// Revoke revokes token through the wrapped provider
+func (l *loggedProvider) Revoke(ctx context.Context, token, tokenTypeHint string) error {
+	return revokeWith(ctx, l.provider, token, tokenTypeHint)
+}
+
+// FetchToken fetches a token from the wrapped provider and logs the outcome
+func (l *loggedProvider) FetchToken(ctx context.Context) (string, error) {
+	logger := l.logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	start := time.Now()
+	token, err := l.provider.FetchToken(ctx)
+	attrs := []slog.Attr{
+		slog.String("provider", l.name),
+		slog.String("kind", providerKind(l.provider)),
+		slog.Duration("latency", time.Since(start)),
+	}
+	if err := canceledByCaller(ctx, providerKind(l.provider), err); IsCanceled(err) {
+		logger.LogAttrs(ctx, slog.LevelDebug, "oidc token fetch canceled", append(attrs, slog.String("error", err.Error()))...)
+		return "", err
+	}
+	if err != nil {
+		logger.LogAttrs(ctx, slog.LevelWarn, "oidc token fetch failed", append(attrs, slog.String("error", err.Error()))...)
+		return "", err
+	}
+	if exp, expErr := getJWTExpiry(token); expErr == nil {
+		attrs = append(attrs, slog.Time("expiry", time.Unix(exp, 0)))
+	}
+	logger.LogAttrs(ctx, slog.LevelDebug, "oidc token fetched", attrs...)
+	return token, nil
+}
+
+// FetchCanceled is the FetchStats.Record code of a request abandoned by the caller, it is not a failure
+const FetchCanceled = "canceled"
+
+// FetchStats counts requests and their latency, of a MeteredProvider or of the Google exchange endpoints
+type FetchStats struct {
+	Requests     uint64
+	Failures     uint64 // without Canceled
+	Canceled     uint64 // requests abandoned because the caller's context ended, see CanceledError
+	TotalLatency time.Duration
+	MaxLatency   time.Duration
+	LastLatency  time.Duration
+	// ErrorCodes counts failures by error code, e.g. the OAuth error ("invalid_grant"),
+	// "http_<status>" when the response has none, or "error" for other failures
+	ErrorCodes map[string]uint64
+}
+
+// AverageLatency returns the mean request latency
+func (s FetchStats) AverageLatency() time.Duration {
+	if s.Requests == 0 {
+		return 0
+	}
+	return s.TotalLatency / time.Duration(s.Requests)
+}
+
+// Record adds one request outcome, code is empty for a successful request and FetchCanceled for a
+// canceled one
+func (s *FetchStats) Record(latency time.Duration, code string) {
+	s.Requests++
+	s.TotalLatency += latency
+	s.LastLatency = latency
+	if latency > s.MaxLatency {
+		s.MaxLatency = latency
+	}
+	switch code {
+	case "":
+		return
+	case FetchCanceled:
+		s.Canceled++
+		return
+	}
+	s.Failures++
+	if s.ErrorCodes == nil {
+		s.ErrorCodes = map[string]uint64{}
+	}
+	s.ErrorCodes[code]++
+}
+
+// Clone returns a copy of s that does not share ErrorCodes
+func (s FetchStats) Clone() FetchStats {
+	codes := make(map[string]uint64, len(s.ErrorCodes))
+	for k, v := range s.ErrorCodes {
+		codes[k] = v
+	}
+	s.ErrorCodes = codes
+	return s
+}
+
+// fetchErrorCode returns the FetchStats code of a fetch outcome
+func fetchErrorCode(err error) string {
+	var tErr *TokenError
+	switch {
+	case err == nil:
+		return ""
+	case IsCanceled(err):
+		return FetchCanceled
+	case errors.As(err, &tErr) && tErr.Code != "":
+		return tErr.Code
+	case errors.As(err, &tErr) && tErr.StatusCode != 0:
+		return "http_" + strconv.Itoa(tErr.StatusCode)
+	}
+	return "error"
+}
+
+// MeteredProvider counts the fetches of a provider and their latency
+type MeteredProvider struct {
+	Provider TokenProvider
+
+	mu    sync.Mutex
+	stats FetchStats
+}
+
+// Metered returns a provider collecting fetch metrics of p, read them with Stats
+func Metered(p TokenProvider) *MeteredProvider {
+	return &MeteredProvider{Provider: p}
+}
+
+// Kind returns the kind of the wrapped provider
+func (m *MeteredProvider) Kind() string {
+	return providerKind(m.Provider)
+}
+
+// Capabilities returns the capabilities of the wrapped provider
+func (m *MeteredProvider) Capabilities() Capabilities {
+	return CapabilitiesOf(m.Provider)
+}
+
+// Revoke revokes token through the wrapped provider
+func (m *MeteredProvider) Revoke(ctx context.Context, token, tokenTypeHint string) error {
+	return revokeWith(ctx, m.Provider, token, tokenTypeHint)
+}
+
+// FetchToken fetches a token from the wrapped provider and records the outcome
+func (m *MeteredProvider) FetchToken(ctx context.Context) (string, error) {
+	start := time.Now()
+	token, err := m.Provider.FetchToken(ctx)
+	latency := time.Since(start)
+	err = canceledByCaller(ctx, providerKind(m.Provider), err)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stats.Record(latency, fetchErrorCode(err))
+	return token, err
+}
+
+// Stats returns the metrics collected so far
+func (m *MeteredProvider) Stats() FetchStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.stats.Clone()
+}
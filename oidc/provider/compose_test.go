@@ -0,0 +1,67 @@
+package oidc_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"testing"
+
+	oidc "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestCombinators(t *testing.T) {
+	ctx := context.Background()
+	token := validJWT(t)
+	ok := oidc.ProviderFunc(func(context.Context) (string, error) { return token, nil })
+	down := oidc.ProviderFunc(func(context.Context) (string, error) { return "", errors.New("keycloak down") })
+
+	t.Run("FirstOf and WithFallback", func(t *testing.T) {
+		got, err := oidc.WithFallback(down, ok).FetchToken(ctx)
+		require.NoError(t, err)
+		require.Equal(t, token, got)
+
+		_, err = oidc.FirstOf(down, down).FetchToken(ctx)
+		require.ErrorContains(t, err, "all providers failed")
+		require.ErrorContains(t, err, "keycloak down")
+
+		_, err = oidc.FirstOf().FetchToken(ctx)
+		require.Error(t, err)
+	})
+
+	t.Run("Metered and Logged", func(t *testing.T) {
+		var buf bytes.Buffer
+		logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+		metered := oidc.Metered(down)
+		cache := oidc.Cached(oidc.Logged(oidc.WithFallback(metered, ok), logger, "orders"))
+
+		for i := 0; i < 2; i++ {
+			got, err := cache.GetValidToken(ctx)
+			require.NoError(t, err)
+			require.Equal(t, token, got)
+		}
+		stats := metered.Stats()
+		require.EqualValues(t, 1, stats.Requests)
+		require.EqualValues(t, 1, stats.Failures)
+		require.Equal(t, map[string]uint64{"error": 1}, stats.ErrorCodes)
+		require.Contains(t, buf.String(), "provider=orders")
+		require.NotContains(t, buf.String(), token)
+	})
+
+	t.Run("token sources", func(t *testing.T) {
+		failing := oidc.NewTokenCache(down).TokenSource(ctx)
+		static := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token})
+		metered := oidc.Metered(oidc.FromTokenSource(failing, oidc.TokenKindAccess))
+		ts := oidc.Cached(oidc.WithFallback(metered, oidc.FromTokenSource(static, oidc.TokenKindAccess))).TokenSource(ctx)
+		got, err := ts.Token()
+		require.NoError(t, err)
+		require.Equal(t, token, got.AccessToken)
+		require.EqualValues(t, 1, metered.Stats().Failures)
+
+		_, err = oidc.FirstOf(oidc.FromTokenSource(failing, oidc.TokenKindAccess)).FetchToken(ctx)
+		require.ErrorContains(t, err, "keycloak down")
+	})
+}
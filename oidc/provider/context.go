@@ -0,0 +1,69 @@
+package oidc
+
+import (
+	"context"
+	"net/http"
+)
+
+// tokenContextKey is the context key for the active token
+type tokenContextKey struct{}
+
+// ContextWithToken returns a copy of ctx carrying the given token
+// Deep call stacks can read it back with TokenFromContext without access to the cache
+func ContextWithToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, tokenContextKey{}, token)
+}
+
+// TokenFromContext returns the token stored by ContextWithToken, if any
+func TokenFromContext(ctx context.Context) (string, bool) {
+	token, ok := ctx.Value(tokenContextKey{}).(string)
+	return token, ok && token != ""
+}
+
+// ContextWithToken fetches a valid token from the cache and stores it in ctx
+func (c *TokenCache) ContextWithToken(ctx context.Context) (context.Context, error) {
+	token, err := c.GetValidToken(ctx)
+	if err != nil {
+		return ctx, err
+	}
+	return ContextWithToken(ctx, token), nil
+}
+
+// TokenMiddleware populates every request context with a valid token from the cache
+// Handlers and everything they call can use TokenFromContext for outgoing requests
+// If no token can be obtained the request fails with 503 Service Unavailable
+func TokenMiddleware(cache *TokenCache) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, err := cache.ContextWithToken(r.Context())
+			if err != nil {
+				http.Error(w, "upstream credentials unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// ContextTransport is an http.RoundTripper that sets the Authorization header
+// from the token stored in the request context (see ContextWithToken)
+// Requests that already carry an Authorization header or have no token in context are sent unchanged
+type ContextTransport struct {
+	Base http.RoundTripper // default http.DefaultTransport
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *ContextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	token, ok := TokenFromContext(req.Context())
+	if !ok || req.Header.Get("Authorization") != "" {
+		return base.RoundTrip(req)
+	}
+	// RoundTrippers must not modify the original request
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+token)
+	return base.RoundTrip(req)
+}
@@ -0,0 +1,54 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	oidc "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenContext(t *testing.T) {
+	token := validJWT(t)
+
+	t.Run("round trip through context", func(t *testing.T) {
+		_, ok := oidc.TokenFromContext(context.Background())
+		require.False(t, ok)
+		got, ok := oidc.TokenFromContext(oidc.ContextWithToken(context.Background(), token))
+		require.True(t, ok)
+		require.Equal(t, token, got)
+	})
+
+	t.Run("middleware populates context and transport forwards it", func(t *testing.T) {
+		var gotAuth string
+		downstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			gotAuth = r.Header.Get("Authorization")
+		}))
+		defer downstream.Close()
+
+		client := &http.Client{Transport: &oidc.ContextTransport{}}
+		handler := oidc.TokenMiddleware(oidc.NewTokenCache(&stubProvider{token: token}))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			req, _ := http.NewRequestWithContext(r.Context(), http.MethodGet, downstream.URL, nil)
+			resp, err := client.Do(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+		}))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, "Bearer "+token, gotAuth)
+	})
+
+	t.Run("middleware fails when no token", func(t *testing.T) {
+		handler := oidc.TokenMiddleware(oidc.NewTokenCache(&stubProvider{err: errors.New("down")}))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			t.Fatal("handler must not be called")
+		}))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	})
+}
@@ -0,0 +1,134 @@
+package oidc
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// debugEnabled toggles request/response tracing for every DebugTransport
+// It is read on each request so tracing can be switched on and off at runtime
+var debugEnabled atomic.Bool
+
+// debugLogger is the logger used by DebugTransport, nil means slog.Default()
+var debugLogger atomic.Pointer[slog.Logger]
+
+// SetDebug enables or disables request/response tracing at runtime
+// Tracing only logs sanitized metadata: method, URL without query, status, latency and oauth error fields
+// Request and response bodies are never logged because they carry tokens and secrets
+func SetDebug(enabled bool) {
+	debugEnabled.Store(enabled)
+}
+
+// DebugEnabled reports whether request/response tracing is currently enabled
+func DebugEnabled() bool {
+	return debugEnabled.Load()
+}
+
+// SetDebugLogger sets the logger used for tracing output, nil restores slog.Default()
+func SetDebugLogger(logger *slog.Logger) {
+	debugLogger.Store(logger)
+}
+
+func debugLog() *slog.Logger {
+	if l := debugLogger.Load(); l != nil {
+		return l
+	}
+	return slog.Default()
+}
+
+// DebugTransport is an http.RoundTripper that traces token and STS calls when debug mode is on
+// When debug mode is off it simply delegates to Base
+type DebugTransport struct {
+	Base http.RoundTripper // default http.DefaultTransport
+	Name string            // component name added to every log record, e.g. "keycloak" or "sts"
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *DebugTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if !DebugEnabled() {
+		return base.RoundTrip(req)
+	}
+
+	start := time.Now()
+	resp, err := base.RoundTrip(req)
+	attrs := []any{
+		slog.String("component", t.Name),
+		slog.String("method", req.Method),
+		slog.String("url", sanitizeURL(req)),
+		slog.Duration("latency", time.Since(start)),
+	}
+	if err != nil {
+		debugLog().Debug("oidc http request failed", append(attrs, slog.String("error", err.Error()))...)
+		return resp, err
+	}
+	attrs = append(attrs, slog.Int("status", resp.StatusCode))
+	if resp.StatusCode >= http.StatusBadRequest {
+		attrs = append(attrs, oauthErrorAttrs(resp)...)
+	}
+	debugLog().Debug("oidc http request", attrs...)
+	return resp, nil
+}
+
+// sanitizeURL drops query string, fragment and user info, which may contain credentials
+func sanitizeURL(req *http.Request) string {
+	if req.URL == nil {
+		return ""
+	}
+	u := *req.URL
+	u.RawQuery = ""
+	u.Fragment = ""
+	u.User = nil
+	return u.String()
+}
+
+// oauthErrorAttrs extracts the RFC 6749 error fields from an error response
+// The body is read and restored so the caller still sees the full response
+// Only the error, error_description and error_uri fields are logged
+func oauthErrorAttrs(resp *http.Response) []any {
+	if resp.Body == nil || !strings.Contains(resp.Header.Get("Content-Type"), "json") {
+		return nil
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return nil
+	}
+	var oauthErr struct {
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+		ErrorURI         string `json:"error_uri"`
+	}
+	if json.Unmarshal(body, &oauthErr) != nil || oauthErr.Error == "" {
+		return nil
+	}
+	return []any{
+		slog.String("oauth_error", oauthErr.Error),
+		slog.String("oauth_error_description", oauthErr.ErrorDescription),
+		slog.String("oauth_error_uri", oauthErr.ErrorURI),
+	}
+}
+
+// NewHTTPClient returns the HTTP client used for token endpoint calls
+// name labels the debug trace records, insecure skips TLS verification (development only)
+func NewHTTPClient(name string, insecure bool) *http.Client {
+	var base http.RoundTripper = http.DefaultTransport
+	if insecure {
+		tr := http.DefaultTransport.(*http.Transport).Clone()
+		tr.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		base = tr
+	}
+	// Responses also feed the clock skew metrics (see ClockSkew)
+	return &http.Client{Transport: &DebugTransport{Base: &skewObserver{Base: base}, Name: name}}
+}
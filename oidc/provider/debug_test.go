@@ -0,0 +1,55 @@
+package oidc_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"testing"
+
+	oidc "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDebugTracing(t *testing.T) {
+	realm := newFakeKeycloak(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "unauthorized_client", "error_description": "Invalid client secret"})
+	})
+	var buf bytes.Buffer
+	oidc.SetDebugLogger(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	t.Cleanup(func() {
+		oidc.SetDebug(false)
+		oidc.SetDebugLogger(nil)
+	})
+
+	provider := &oidc.KeycloakTokenProvider{Config: &oidc.ConfigKeyCloak{
+		KeycloakRealmURL:     realm,
+		KeycloakClientID:     "client",
+		KeycloakClientSecret: "super-secret",
+	}}
+
+	t.Run("disabled by default", func(t *testing.T) {
+		_, err := provider.FetchToken(context.Background())
+		require.Error(t, err)
+		require.Empty(t, buf.String())
+	})
+
+	t.Run("logs sanitized metadata when enabled", func(t *testing.T) {
+		oidc.SetDebug(true)
+		require.True(t, oidc.DebugEnabled())
+		_, err := provider.FetchToken(context.Background())
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "Invalid client secret", "response body must still reach the caller")
+
+		out := buf.String()
+		require.Contains(t, out, `"status":401`)
+		require.Contains(t, out, `"oauth_error":"unauthorized_client"`)
+		require.Contains(t, out, `"method":"POST"`)
+		require.Contains(t, out, "/protocol/openid-connect/token")
+		require.NotContains(t, out, "super-secret")
+	})
+}
@@ -0,0 +1,85 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+)
+
+// ErrNoDefaultProvider is returned by the package-level helpers before SetDefault is called
+var ErrNoDefaultProvider = errors.New("oidc: no default provider configured, call SetDefault first")
+
+// defaultCache holds the cache used by the package-level helpers
+var defaultCache atomic.Pointer[TokenCache]
+
+// SetDefault configures the provider used by Token and HTTPClient
+// It is meant for small services that only ever talk to one IdP; services with several
+// providers should keep using TokenCache directly
+func SetDefault(provider TokenProvider, opts ...CacheOption) {
+	if provider == nil {
+		defaultCache.Store(nil)
+		return
+	}
+	defaultCache.Store(NewTokenCache(provider, opts...))
+}
+
+// Default returns the cache configured with SetDefault, or nil
+func Default() *TokenCache {
+	return defaultCache.Load()
+}
+
+// Token returns a valid token from the default provider, or from the provider set with WithProviderOverride
+func Token(ctx context.Context) (string, error) {
+	if p, ok := ProviderOverride(ctx); ok {
+		return p.FetchToken(ctx)
+	}
+	cache := Default()
+	if cache == nil {
+		return "", ErrNoDefaultProvider
+	}
+	return cache.GetValidToken(ctx)
+}
+
+// HTTPClient returns an HTTP client that authenticates every request with the default provider
+// The provider is resolved per request, so the client can be created before SetDefault is called
+func HTTPClient() *http.Client {
+	return &http.Client{Transport: &Transport{}}
+}
+
+// Transport is an http.RoundTripper that adds a bearer token from a TokenCache
+// A provider set on the request context with WithProviderOverride takes precedence over Cache
+// Requests that already carry an Authorization header are sent unchanged
+type Transport struct {
+	Cache *TokenCache       // nil uses the default cache (see SetDefault)
+	Base  http.RoundTripper // default http.DefaultTransport
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if req.Header.Get("Authorization") != "" {
+		return base.RoundTrip(req)
+	}
+	var provider TokenProvider
+	if p, ok := ProviderOverride(req.Context()); ok {
+		provider = p
+	} else if t.Cache != nil {
+		provider = t.Cache
+	} else if cache := Default(); cache != nil {
+		provider = cache
+	} else {
+		return nil, ErrNoDefaultProvider
+	}
+	token, err := provider.FetchToken(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	// RoundTrippers must not modify the original request
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+token)
+	return base.RoundTrip(req)
+}
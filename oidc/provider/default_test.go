@@ -0,0 +1,42 @@
+package oidc_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	oidc "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDefaultProvider(t *testing.T) {
+	t.Cleanup(func() { oidc.SetDefault(nil) })
+	oidc.SetDefault(nil)
+
+	_, err := oidc.Token(context.Background())
+	require.ErrorIs(t, err, oidc.ErrNoDefaultProvider)
+
+	var gotAuth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+	}))
+	defer srv.Close()
+
+	// The client may be created before SetDefault
+	client := oidc.HTTPClient()
+	_, err = client.Get(srv.URL)
+	require.ErrorIs(t, err, oidc.ErrNoDefaultProvider)
+
+	token := validJWT(t)
+	oidc.SetDefault(&stubProvider{token: token})
+	got, err := oidc.Token(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, token, got)
+
+	resp, err := client.Get(srv.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, "Bearer "+token, gotAuth)
+}
@@ -0,0 +1,26 @@
+package oidc_test
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestProviderDependencies keeps the Keycloak provider free of cloud SDKs,
+// so services that only need it do not pull the GCP dependency tree
+func TestProviderDependencies(t *testing.T) {
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go tool not available")
+	}
+	out, err := exec.Command(goTool, "list", "-deps", ".").Output()
+	require.NoError(t, err)
+	for _, dep := range strings.Fields(string(out)) {
+		if !strings.Contains(strings.SplitN(dep, "/", 2)[0], ".") || strings.HasPrefix(dep, "github.com/PCS-Indonesia/pcs-oidc/") {
+			continue // standard library or this module
+		}
+		require.True(t, strings.HasPrefix(dep, "golang.org/x/oauth2"), "unexpected dependency %s", dep)
+	}
+}
@@ -0,0 +1,55 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// DiscoveryDocument holds the fields of an OIDC discovery document used by this package
+type DiscoveryDocument struct {
+	Issuer                        string   `json:"issuer"`
+	TokenEndpoint                 string   `json:"token_endpoint"`
+	JWKSURI                       string   `json:"jwks_uri"`
+	IntrospectionEndpoint         string   `json:"introspection_endpoint,omitempty"`
+	RevocationEndpoint            string   `json:"revocation_endpoint,omitempty"`
+	DeviceAuthorizationEndpoint   string   `json:"device_authorization_endpoint,omitempty"`
+	AuthorizationEndpoint         string   `json:"authorization_endpoint,omitempty"`
+	GrantTypesSupported           []string `json:"grant_types_supported,omitempty"`
+	TokenEndpointAuthMethods      []string `json:"token_endpoint_auth_methods_supported,omitempty"`
+	RevocationEndpointAuthMethods []string `json:"revocation_endpoint_auth_methods_supported,omitempty"`
+	// IntrospectionEndpointAuthMethods lists the client authentication methods of the introspection endpoint
+	IntrospectionEndpointAuthMethods []string `json:"introspection_endpoint_auth_methods_supported,omitempty"`
+	// MTLSEndpointAliases holds the endpoints to use with mutual TLS, keyed by endpoint name (RFC 8705)
+	MTLSEndpointAliases map[string]string `json:"mtls_endpoint_aliases,omitempty"`
+	// TLSClientCertificateBoundAccessTokens reports support for certificate-bound access tokens
+	TLSClientCertificateBoundAccessTokens bool `json:"tls_client_certificate_bound_access_tokens,omitempty"`
+}
+
+// Discover fetches the OIDC discovery document of an issuer
+// (issuer + "/.well-known/openid-configuration")
+func Discover(ctx context.Context, client *http.Client, issuer string) (*DiscoveryDocument, error) {
+	if client == nil {
+		client = NewHTTPClient("discovery", false)
+	}
+	url := strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch discovery document: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch discovery document %s: status %d", url, resp.StatusCode)
+	}
+	var doc DiscoveryDocument
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("invalid discovery document: %w", err)
+	}
+	return &doc, nil
+}
@@ -0,0 +1,130 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// TokenError is returned when a token endpoint (IdP or STS) rejects a token request
+// RetryAfter carries the backoff requested by the server through Retry-After,
+// so callers and retry policies can wait instead of hammering a rate limited IdP
+type TokenError struct {
+	Provider    string // e.g. "keycloak" or "sts"
+	StatusCode  int
+	Code        string // RFC 6749 "error" field
+	Description string // RFC 6749 "error_description" field
+	RetryAfter  time.Duration
+	Err         error
+}
+
+func (e *TokenError) Error() string {
+	msg := fmt.Sprintf("%s token endpoint returned status %d", e.Provider, e.StatusCode)
+	if e.Code != "" {
+		msg += ": " + e.Code
+		if e.Description != "" {
+			msg += " (" + e.Description + ")"
+		}
+	}
+	if e.RetryAfter > 0 {
+		msg += fmt.Sprintf(", retry after %s", e.RetryAfter)
+	}
+	return msg
+}
+
+func (e *TokenError) Unwrap() error {
+	return e.Err
+}
+
+// Temporary reports whether the request may succeed when retried (rate limited, server error or
+// temporarily_unavailable, which RFC 6749 defines for redirects and some IdPs answer with 400)
+func (e *TokenError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= http.StatusInternalServerError ||
+		e.Code == "temporarily_unavailable"
+}
+
+// CanceledError is returned when a token request ended because the caller's context was canceled or
+// timed out, not because the IdP failed; cache usage, metrics, lifecycle and refresh ramp do not count
+// it as a failure, so client-side timeouts do not show up as IdP outages
+// An IdP that does not answer within the HTTP client timeout leaves the caller's context alive and
+// stays a failure
+type CanceledError struct {
+	Provider string
+	Err      error // the error of the request
+	Cause    error // context.Canceled, context.DeadlineExceeded or the context's cancel cause
+}
+
+func (e *CanceledError) Error() string {
+	return fmt.Sprintf("%s token request canceled by caller (%v): %v", e.Provider, e.Cause, e.Err)
+}
+
+func (e *CanceledError) Unwrap() []error {
+	return []error{e.Err, e.Cause}
+}
+
+// IsCanceled reports whether err was caused by the caller's context rather than by the IdP
+func IsCanceled(err error) bool {
+	var cErr *CanceledError
+	return errors.As(err, &cErr)
+}
+
+// canceledByCaller wraps err in a *CanceledError when ctx ended before the request completed
+func canceledByCaller(ctx context.Context, provider string, err error) error {
+	if err == nil || ctx.Err() == nil || IsCanceled(err) {
+		return err
+	}
+	return &CanceledError{Provider: provider, Err: err, Cause: context.Cause(ctx)}
+}
+
+// asTokenError converts an oauth2 retrieve error into a *TokenError, other errors are returned unchanged
+func asTokenError(provider string, err error) error {
+	var rErr *oauth2.RetrieveError
+	if !errors.As(err, &rErr) || rErr.Response == nil {
+		return err
+	}
+	code, description := rErr.ErrorCode, rErr.ErrorDescription
+	if code == "" {
+		// oauth2 only parses JSON error bodies declared as such, some servers answer text/plain
+		var body struct {
+			Error            string `json:"error"`
+			ErrorDescription string `json:"error_description"`
+		}
+		if json.Unmarshal(rErr.Body, &body) == nil {
+			code, description = body.Error, body.ErrorDescription
+		}
+	}
+	return &TokenError{
+		Provider:    provider,
+		StatusCode:  rErr.Response.StatusCode,
+		Code:        code,
+		Description: description,
+		RetryAfter:  ParseRetryAfter(rErr.Response.Header.Get("Retry-After"), time.Now()),
+		Err:         err,
+	}
+}
+
+// ParseRetryAfter parses a Retry-After header value given in seconds or as an HTTP date
+// It returns zero when the header is absent, invalid or in the past
+func ParseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(value); err == nil {
+		if secs <= 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if d := at.Sub(now); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
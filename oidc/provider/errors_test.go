@@ -0,0 +1,75 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	oidc "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCanceledError(t *testing.T) {
+	ctx := context.Background()
+	realmURL := newFakeKeycloak(t, func(w http.ResponseWriter, r *http.Request) {
+		// Keycloak is slow but healthy, only the caller gives up
+		<-r.Context().Done()
+	})
+	keycloak := &oidc.KeycloakTokenProvider{Config: &oidc.ConfigKeyCloak{
+		KeycloakRealmURL:     realmURL,
+		KeycloakClientID:     "svc",
+		KeycloakClientSecret: "secret",
+	}}
+
+	t.Run("caller timeout is not an IdP failure", func(t *testing.T) {
+		var events []oidc.Event
+		keycloak.OnEvent = func(ev oidc.Event) { events = append(events, ev) }
+		defer func() { keycloak.OnEvent = nil }()
+		cache := oidc.NewTokenCache(keycloak)
+
+		timeoutCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+		defer cancel()
+		_, err := cache.GetValidToken(timeoutCtx)
+		require.True(t, oidc.IsCanceled(err))
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		var cErr *oidc.CanceledError
+		require.ErrorAs(t, err, &cErr)
+		require.Equal(t, "keycloak", cErr.Provider)
+
+		usage := cache.Usage()
+		require.EqualValues(t, 1, usage.Canceled)
+		require.Zero(t, usage.Failures)
+		require.Empty(t, cache.Status().LastError)
+
+		// Event handlers can tell both apart as well
+		require.Equal(t, oidc.EventTokenFailed, events[len(events)-1].Type)
+		require.True(t, oidc.IsCanceled(events[len(events)-1].Err))
+	})
+
+	t.Run("provider failures with a live context", func(t *testing.T) {
+		down := &stubProvider{err: errors.New("dial tcp: i/o timeout")}
+		cache := oidc.NewTokenCache(down)
+		_, err := cache.GetValidToken(ctx)
+		require.Error(t, err)
+		require.False(t, oidc.IsCanceled(err))
+		require.EqualValues(t, 1, cache.Usage().Failures)
+		require.Zero(t, cache.Usage().Canceled)
+	})
+
+	t.Run("metered providers", func(t *testing.T) {
+		metered := oidc.Metered(keycloak)
+		canceled, cancel := context.WithCancel(ctx)
+		cancel()
+		_, err := metered.FetchToken(canceled)
+		require.ErrorIs(t, err, context.Canceled)
+
+		stats := metered.Stats()
+		require.EqualValues(t, 1, stats.Requests)
+		require.EqualValues(t, 1, stats.Canceled)
+		require.Zero(t, stats.Failures)
+		require.Empty(t, stats.ErrorCodes)
+	})
+}
@@ -0,0 +1,64 @@
+package oidc
+
+import "time"
+
+// EventType identifies a provider event
+type EventType string
+
+const (
+	// EventTokenRequest is emitted before a token request, Scopes holds the effective scopes
+	EventTokenRequest EventType = "token_request"
+	// EventTokenFetched is emitted after a token was obtained
+	EventTokenFetched EventType = "token_fetched"
+	// EventTokenFailed is emitted when a token request failed, Err holds the reason
+	EventTokenFailed EventType = "token_failed"
+	// EventScopeReduced is a warning emitted before retrying an invalid_scope request with fewer scopes,
+	// Scopes holds the reduced scopes and Err the rejection
+	EventScopeReduced EventType = "scope_reduced"
+	// EventFallback is emitted by ChainProvider when a provider failed and the next one is tried,
+	// Provider is the kind of the failed provider and Err the reason
+	EventFallback EventType = "fallback"
+	// EventPanic is emitted when a background worker recovered from a panic, Err holds the *PanicError
+	EventPanic EventType = "panic"
+	// EventImpersonation is emitted for every attempt to mint a service account token, Impersonation
+	// describes it and Err holds the reason of a failed attempt
+	EventImpersonation EventType = "impersonation"
+)
+
+// Event describes something that happened while obtaining a token
+type Event struct {
+	Type     EventType
+	Provider string // provider kind, e.g. "keycloak"
+	Time     time.Time
+	Scopes   []string
+	Duration time.Duration // request latency, set for fetched and failed events
+	Err      error
+
+	Attestation *Attestation // workload that requested the token, see EventHandler.WithAttestation
+
+	Impersonation *Impersonation // set for impersonation events
+}
+
+// Impersonation describes a service account impersonation, taken from the configuration of the caller
+type Impersonation struct {
+	ServiceAccount string
+	Delegates      []string
+	Scopes         []string
+	Lifetime       time.Duration     // requested lifetime, 0 for the default
+	Caller         map[string]string // identity claims of the caller, e.g. sub and iss of a WIF subject token
+	Expiry         time.Time         // expiry of the minted token, zero on failure
+}
+
+// EventHandler receives provider events, it must not block
+type EventHandler func(Event)
+
+// emit calls h with ev if a handler is configured
+func (h EventHandler) emit(ev Event) {
+	if h == nil {
+		return
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	h(ev)
+}
@@ -0,0 +1,73 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrNoExpiry is returned when a token has no exp claim and no fallback expiry is known
+var ErrNoExpiry = errors.New("exp not found in token")
+
+// expiryKey is the context key of the expiryRecorder of a cache fetch
+type expiryKey struct{}
+
+// expiryRecorder receives the expiry a provider reports during one cache fetch
+type expiryRecorder struct {
+	mu     sync.Mutex
+	expiry time.Time
+}
+
+// ReportExpiry tells the cache fetching a token the expiry reported by the token response (expires_in)
+// The cache uses it for tokens without exp claim, outside of a cache fetch the call does nothing
+// Built-in providers report it themselves, custom providers can call it from FetchToken
+func ReportExpiry(ctx context.Context, expiry time.Time) {
+	r, ok := ctx.Value(expiryKey{}).(*expiryRecorder)
+	if !ok || expiry.IsZero() {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.expiry = expiry
+}
+
+// withExpiryRecorder returns a context collecting the expiry reported by the provider
+func withExpiryRecorder(ctx context.Context) (context.Context, *expiryRecorder) {
+	r := &expiryRecorder{}
+	return context.WithValue(ctx, expiryKey{}, r), r
+}
+
+func (r *expiryRecorder) reported() time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.expiry
+}
+
+// WithDefaultTTL sets the lifetime assumed for tokens that have no exp claim when the provider did not
+// report an expires_in either, e.g. realms issuing tokens without exp to confidential clients
+// Without it such tokens make GetValidToken fail
+func WithDefaultTTL(ttl time.Duration) CacheOption {
+	return func(c *TokenCache) {
+		c.defaultTTL = ttl
+	}
+}
+
+// tokenExpiry determines the local expiry of a fetched token, falling back from the JWT exp claim
+// to the expiry reported by the provider and then to the default TTL
+// Tokens that fail strict parsing are rejected, the fallbacks only cover a missing or unreadable exp
+func (c *TokenCache) tokenExpiry(token string, reported, received time.Time) (time.Time, error) {
+	exp, err := jwtExpiry(token, c.strictJWT)
+	switch {
+	case err == nil:
+		// Without skew compensation clock is nil and the expiry is used as is
+		return c.clock.ExpiryToLocal(time.Unix(exp, 0)), nil
+	case errors.Is(err, ErrMalformedJWT):
+		return time.Time{}, err
+	case !reported.IsZero():
+		return reported, nil
+	case c.defaultTTL > 0:
+		return received.Add(c.defaultTTL), nil
+	}
+	return time.Time{}, err
+}
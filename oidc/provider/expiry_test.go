@@ -0,0 +1,65 @@
+package oidc_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	oidc "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpiryFallback(t *testing.T) {
+	ctx := context.Background()
+	noExp := makeJWT(t, map[string]interface{}{"sub": "svc"})
+
+	t.Run("expires_in of the token response", func(t *testing.T) {
+		realm := newFakeKeycloak(t, func(w http.ResponseWriter, r *http.Request) {
+			writeTokenResponse(w, map[string]interface{}{"access_token": "opaque", "id_token": noExp, "expires_in": 300})
+		})
+		cache := oidc.NewTokenCache(&oidc.KeycloakTokenProvider{Config: &oidc.ConfigKeyCloak{
+			KeycloakRealmURL: realm, KeycloakClientID: "svc", KeycloakClientSecret: "secret",
+		}}, oidc.WithDefaultTTL(time.Hour))
+		got, err := cache.GetValidToken(ctx)
+		require.NoError(t, err)
+		require.Equal(t, noExp, got)
+		require.WithinDuration(t, time.Now().Add(300*time.Second), cache.Status().Expiry, 5*time.Second)
+	})
+
+	t.Run("reported by a custom provider", func(t *testing.T) {
+		expiry := time.Now().Add(20 * time.Minute)
+		cache := oidc.NewTokenCache(oidc.ProviderFunc(func(ctx context.Context) (string, error) {
+			oidc.ReportExpiry(ctx, expiry)
+			return "opaque-token", nil
+		}))
+		got, err := cache.GetValidToken(ctx)
+		require.NoError(t, err)
+		require.Equal(t, "opaque-token", got)
+		require.WithinDuration(t, expiry, cache.Status().Expiry, time.Second)
+	})
+
+	t.Run("default TTL", func(t *testing.T) {
+		cache := oidc.NewTokenCache(&stubProvider{token: noExp}, oidc.WithDefaultTTL(10*time.Minute))
+		_, err := cache.GetValidToken(ctx)
+		require.NoError(t, err)
+		require.WithinDuration(t, time.Now().Add(10*time.Minute), cache.Status().Expiry, 5*time.Second)
+	})
+
+	t.Run("no fallback", func(t *testing.T) {
+		_, err := oidc.NewTokenCache(&stubProvider{token: noExp}).GetValidToken(ctx)
+		require.ErrorIs(t, err, oidc.ErrNoExpiry)
+	})
+
+	t.Run("strict parsing is not bypassed", func(t *testing.T) {
+		cache := oidc.NewTokenCache(&stubProvider{token: "opaque-token"}, oidc.WithStrictJWT(), oidc.WithDefaultTTL(time.Minute))
+		_, err := cache.GetValidToken(ctx)
+		require.ErrorIs(t, err, oidc.ErrMalformedJWT)
+
+		missing := makeJWT(t, map[string]interface{}{"sub": "svc"})
+		cache = oidc.NewTokenCache(&stubProvider{token: missing}, oidc.WithStrictJWT(), oidc.WithDefaultTTL(time.Minute))
+		_, err = cache.GetValidToken(ctx)
+		require.NoError(t, err)
+	})
+}
@@ -0,0 +1,151 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// FailoverTarget is a named provider taking part in failover
+type FailoverTarget struct {
+	Name     string
+	Provider TokenProvider
+}
+
+// FailoverProvider implements TokenProvider over several Keycloak clusters (or any providers)
+// Targets are tried in order, but targets the Prober currently reports as healthy are tried first,
+// so a known-down cluster does not cost a failed request every time
+// With HedgeDelay set the next target is also started when the current one has not answered
+// within the delay; the first token wins and the remaining requests are cancelled
+type FailoverProvider struct {
+	Targets    []FailoverTarget
+	Prober     *HealthProber // optional, without it targets are tried strictly in order
+	HedgeDelay time.Duration // optional, zero disables hedging
+
+	hedges atomic.Uint64
+	wasted atomic.Uint64
+}
+
+// FailoverStats reports hedging overhead, used to tune HedgeDelay
+type FailoverStats struct {
+	Hedges uint64 // requests started because the previous target was slow
+	Wasted uint64 // requests started whose result was not used (cancelled or lost the race)
+}
+
+// Stats returns the hedging metrics collected so far
+func (f *FailoverProvider) Stats() FailoverStats {
+	return FailoverStats{Hedges: f.hedges.Load(), Wasted: f.wasted.Load()}
+}
+
+// NewFailoverProvider creates a failover provider and registers every target
+// that implements HealthChecker with the prober (if one is given)
+func NewFailoverProvider(prober *HealthProber, targets ...FailoverTarget) *FailoverProvider {
+	if prober != nil {
+		for _, t := range targets {
+			if hc, ok := t.Provider.(HealthChecker); ok {
+				prober.Add(t.Name, hc)
+			}
+		}
+	}
+	return &FailoverProvider{Targets: targets, Prober: prober}
+}
+
+// FetchToken returns the first token obtained, trying healthy targets before unhealthy ones
+func (f *FailoverProvider) FetchToken(ctx context.Context) (string, error) {
+	if len(f.Targets) == 0 {
+		return "", errors.New("failover provider has no targets")
+	}
+	if f.HedgeDelay > 0 {
+		return f.fetchHedged(ctx)
+	}
+	var errs []error
+	for _, t := range f.ordered() {
+		token, err := t.Provider.FetchToken(ctx)
+		if err == nil {
+			return token, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", t.Name, err))
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return "", fmt.Errorf("all failover targets failed: %w", errors.Join(errs...))
+}
+
+// hedgeResult is the outcome of one hedged request
+type hedgeResult struct {
+	name  string
+	token string
+	err   error
+}
+
+// fetchHedged starts targets one after another, HedgeDelay apart or as soon as the previous one failed
+// Requests still in flight when a token is obtained are cancelled and counted as wasted
+func (f *FailoverProvider) fetchHedged(ctx context.Context) (string, error) {
+	targets := f.ordered()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// Buffered so losers never block after FetchToken returned
+	results := make(chan hedgeResult, len(targets))
+	start := func(t FailoverTarget) {
+		go func() {
+			token, err := safeFetch(ctx, t.Provider)
+			results <- hedgeResult{name: t.Name, token: token, err: err}
+		}()
+	}
+
+	var errs []error
+	next, pending := 0, 0
+	timer := time.NewTimer(f.HedgeDelay)
+	defer timer.Stop()
+	start(targets[next])
+	next, pending = next+1, pending+1
+	for pending > 0 {
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				f.wasted.Add(uint64(pending))
+				return r.token, nil
+			}
+			errs = append(errs, fmt.Errorf("%s: %w", r.name, r.err))
+			if ctx.Err() == nil && next < len(targets) {
+				// The failed target is not hedged, so the next one starts without delay
+				start(targets[next])
+				next, pending = next+1, pending+1
+				timer.Reset(f.HedgeDelay)
+			}
+		case <-timer.C:
+			if next < len(targets) {
+				f.hedges.Add(1)
+				start(targets[next])
+				next, pending = next+1, pending+1
+				timer.Reset(f.HedgeDelay)
+			}
+		case <-ctx.Done():
+			errs = append(errs, ctx.Err())
+			f.wasted.Add(uint64(pending))
+			return "", fmt.Errorf("all failover targets failed: %w", errors.Join(errs...))
+		}
+	}
+	return "", fmt.Errorf("all failover targets failed: %w", errors.Join(errs...))
+}
+
+// ordered returns healthy targets first, keeping the configured order within each group
+func (f *FailoverProvider) ordered() []FailoverTarget {
+	if f.Prober == nil {
+		return f.Targets
+	}
+	healthy := make([]FailoverTarget, 0, len(f.Targets))
+	var unhealthy []FailoverTarget
+	for _, t := range f.Targets {
+		if f.Prober.Healthy(t.Name) {
+			healthy = append(healthy, t)
+		} else {
+			unhealthy = append(unhealthy, t)
+		}
+	}
+	return append(healthy, unhealthy...)
+}
@@ -0,0 +1,159 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	oidc "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+// checkedProvider is a stubProvider that also reports health
+type checkedProvider struct {
+	stubProvider
+	healthErr error
+}
+
+func (c *checkedProvider) HealthCheck(ctx context.Context) error { return c.healthErr }
+
+func TestFailoverProviderPrefersHealthy(t *testing.T) {
+	primary := &checkedProvider{stubProvider: stubProvider{token: "primary"}, healthErr: errors.New("down")}
+	secondary := &checkedProvider{stubProvider: stubProvider{token: "secondary"}}
+	prober := oidc.NewHealthProber(time.Hour)
+	failover := oidc.NewFailoverProvider(prober,
+		oidc.FailoverTarget{Name: "kc-a", Provider: primary},
+		oidc.FailoverTarget{Name: "kc-b", Provider: secondary},
+	)
+
+	// Before probing everything is assumed healthy, so configured order wins
+	token, err := failover.FetchToken(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "primary", token)
+
+	prober.ProbeNow(context.Background())
+	require.False(t, prober.Healthy("kc-a"))
+	require.True(t, prober.Healthy("kc-b"))
+	status := prober.Status()
+	require.Len(t, status, 2)
+	require.Equal(t, "kc-a", status[0].Name)
+	require.Equal(t, 1, status[0].ConsecutiveFailures)
+	require.Equal(t, "down", status[0].LastError)
+
+	token, err = failover.FetchToken(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "secondary", token)
+	require.Equal(t, int32(1), primary.calls.Load(), "unhealthy target should not be tried first")
+}
+
+func TestFailoverProviderCombinesErrors(t *testing.T) {
+	failover := oidc.NewFailoverProvider(nil,
+		oidc.FailoverTarget{Name: "kc-a", Provider: &stubProvider{err: errors.New("a failed")}},
+		oidc.FailoverTarget{Name: "kc-b", Provider: &stubProvider{err: errors.New("b failed")}},
+	)
+	_, err := failover.FetchToken(context.Background())
+	require.ErrorContains(t, err, "kc-a: a failed")
+	require.ErrorContains(t, err, "kc-b: b failed")
+}
+
+func TestHealthProberBackground(t *testing.T) {
+	realm := newFakeKeycloak(t, func(w http.ResponseWriter, r *http.Request) {})
+	prober := oidc.NewHealthProber(10 * time.Millisecond)
+	prober.Add("ok", &oidc.KeycloakTokenProvider{Config: &oidc.ConfigKeyCloak{KeycloakRealmURL: realm}})
+	prober.Add("broken", &oidc.KeycloakTokenProvider{Config: &oidc.ConfigKeyCloak{KeycloakRealmURL: realm + "-missing"}})
+	prober.Start(context.Background())
+	defer prober.Close()
+
+	require.Eventually(t, func() bool {
+		for _, st := range prober.Status() {
+			if st.LastChecked.IsZero() {
+				return false
+			}
+		}
+		return true
+	}, time.Second, 5*time.Millisecond)
+	require.True(t, prober.Healthy("ok"))
+	require.False(t, prober.Healthy("broken"))
+}
+
+// slowProvider returns its token after delay, or the context error if cancelled first
+type slowProvider struct {
+	token     string
+	delay     time.Duration
+	cancelled chan struct{}
+}
+
+func (s *slowProvider) FetchToken(ctx context.Context) (string, error) {
+	select {
+	case <-time.After(s.delay):
+		return s.token, nil
+	case <-ctx.Done():
+		close(s.cancelled)
+		return "", ctx.Err()
+	}
+}
+
+func TestFailoverProviderHedging(t *testing.T) {
+	t.Run("slow target is hedged and cancelled", func(t *testing.T) {
+		slow := &slowProvider{token: "slow", delay: time.Minute, cancelled: make(chan struct{})}
+		fast := &stubProvider{token: "fast"}
+		failover := oidc.NewFailoverProvider(nil,
+			oidc.FailoverTarget{Name: "kc-a", Provider: slow},
+			oidc.FailoverTarget{Name: "kc-b", Provider: fast},
+		)
+		failover.HedgeDelay = 20 * time.Millisecond
+
+		token, err := failover.FetchToken(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, "fast", token)
+		select {
+		case <-slow.cancelled:
+		case <-time.After(time.Second):
+			t.Fatal("losing request was not cancelled")
+		}
+		require.Equal(t, oidc.FailoverStats{Hedges: 1, Wasted: 1}, failover.Stats())
+	})
+
+	t.Run("fast primary does not hedge", func(t *testing.T) {
+		secondary := &stubProvider{token: "secondary"}
+		failover := oidc.NewFailoverProvider(nil,
+			oidc.FailoverTarget{Name: "kc-a", Provider: &stubProvider{token: "primary"}},
+			oidc.FailoverTarget{Name: "kc-b", Provider: secondary},
+		)
+		failover.HedgeDelay = time.Second
+
+		token, err := failover.FetchToken(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, "primary", token)
+		require.Zero(t, secondary.calls.Load())
+		require.Equal(t, oidc.FailoverStats{}, failover.Stats())
+	})
+
+	t.Run("failure starts next target immediately", func(t *testing.T) {
+		failover := oidc.NewFailoverProvider(nil,
+			oidc.FailoverTarget{Name: "kc-a", Provider: &stubProvider{err: errors.New("down")}},
+			oidc.FailoverTarget{Name: "kc-b", Provider: &stubProvider{token: "secondary"}},
+		)
+		failover.HedgeDelay = time.Minute
+
+		token, err := failover.FetchToken(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, "secondary", token)
+		require.Equal(t, oidc.FailoverStats{}, failover.Stats())
+	})
+
+	t.Run("all targets failing are reported", func(t *testing.T) {
+		failover := oidc.NewFailoverProvider(nil,
+			oidc.FailoverTarget{Name: "kc-a", Provider: &stubProvider{err: errors.New("down a")}},
+			oidc.FailoverTarget{Name: "kc-b", Provider: &stubProvider{err: errors.New("down b")}},
+		)
+		failover.HedgeDelay = time.Minute
+
+		_, err := failover.FetchToken(context.Background())
+		require.ErrorContains(t, err, "kc-a: down a")
+		require.ErrorContains(t, err, "kc-b: down b")
+	})
+}
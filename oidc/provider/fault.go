@@ -0,0 +1,78 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrInjectedFault is returned (wrapped) by FaultProvider when it injects a failure
+var ErrInjectedFault = errors.New("injected fault")
+
+// FaultProvider wraps a provider and injects failures, latency and malformed tokens
+// It is meant for integration tests and game days, to check how applications behave
+// when authentication degrades. Rates are probabilities between 0 and 1.
+type FaultProvider struct {
+	Provider      TokenProvider
+	ErrorRate     float64       // probability of failing instead of calling Provider
+	Error         error         // returned on injected failures, default a *TokenError with status 503
+	Latency       time.Duration // added before every call
+	Jitter        time.Duration // random extra latency up to this value
+	MalformedRate float64       // probability of returning a token that is not a valid JWT
+
+	mu       sync.Mutex
+	rnd      *rand.Rand
+	injected atomic.Uint64
+}
+
+// NewFaultProvider wraps provider without any fault enabled
+func NewFaultProvider(provider TokenProvider) *FaultProvider {
+	return &FaultProvider{Provider: provider}
+}
+
+// Injected returns how many faults (errors or malformed tokens) were injected
+func (f *FaultProvider) Injected() uint64 {
+	return f.injected.Load()
+}
+
+// chance returns a random number in [0, 1)
+func (f *FaultProvider) chance() float64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.rnd == nil {
+		f.rnd = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	return f.rnd.Float64()
+}
+
+// FetchToken implements TokenProvider
+func (f *FaultProvider) FetchToken(ctx context.Context) (string, error) {
+	delay := f.Latency
+	if f.Jitter > 0 {
+		delay += time.Duration(f.chance() * float64(f.Jitter))
+	}
+	if delay > 0 {
+		if err := sleepContext(ctx, delay); err != nil {
+			return "", err
+		}
+	}
+	if f.ErrorRate > 0 && f.chance() < f.ErrorRate {
+		f.injected.Add(1)
+		if f.Error != nil {
+			return "", f.Error
+		}
+		return "", &TokenError{Provider: "fault", StatusCode: 503, Code: "temporarily_unavailable", Err: ErrInjectedFault}
+	}
+	token, err := f.Provider.FetchToken(ctx)
+	if err != nil {
+		return "", err
+	}
+	if f.MalformedRate > 0 && f.chance() < f.MalformedRate {
+		f.injected.Add(1)
+		return "malformed." + token, nil
+	}
+	return token, nil
+}
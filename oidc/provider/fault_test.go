@@ -0,0 +1,54 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	oidc "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFaultProvider(t *testing.T) {
+	ctx := context.Background()
+	token := validJWT(t)
+
+	t.Run("no faults by default", func(t *testing.T) {
+		f := oidc.NewFaultProvider(&stubProvider{token: token})
+		got, err := f.FetchToken(ctx)
+		require.NoError(t, err)
+		require.Equal(t, token, got)
+		require.Zero(t, f.Injected())
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		stub := &stubProvider{token: token}
+		f := oidc.NewFaultProvider(stub)
+		f.ErrorRate = 1
+		_, err := f.FetchToken(ctx)
+		require.True(t, errors.Is(err, oidc.ErrInjectedFault))
+		var tErr *oidc.TokenError
+		require.True(t, errors.As(err, &tErr))
+		require.True(t, tErr.Temporary())
+		require.Zero(t, stub.calls.Load())
+		require.EqualValues(t, 1, f.Injected())
+	})
+
+	t.Run("malformed tokens are rejected by the cache", func(t *testing.T) {
+		f := oidc.NewFaultProvider(&stubProvider{token: token})
+		f.MalformedRate = 1
+		_, err := oidc.NewTokenCache(f).GetValidToken(ctx)
+		require.Error(t, err)
+	})
+
+	t.Run("latency respects the context", func(t *testing.T) {
+		f := oidc.NewFaultProvider(&stubProvider{token: token})
+		f.Latency = time.Minute
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+		_, err := f.FetchToken(ctx)
+		require.True(t, errors.Is(err, context.DeadlineExceeded))
+	})
+}
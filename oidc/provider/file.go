@@ -0,0 +1,156 @@
+package oidc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultFilePollInterval is the minimum time between two checks of a FileTokenProvider file
+const DefaultFilePollInterval = time.Second
+
+// FileTokenProvider implements TokenProvider with a JWT another process writes to disk,
+// e.g. the istio-agent or spire-agent, so those tokens can be used with TokenCache and WIF
+// The file is polled for rotation by modification time and size, FetchToken returns its latest content
+// To pick up a rotation before the cached token expires, run Watch and expire the cache in OnChange:
+//
+//	p := &FileTokenProvider{Path: "/var/run/secrets/tokens/istio-token"}
+//	cache := NewTokenCache(p)
+//	p.OnChange = func(string) { cache.ForceExpire(time.Time{}) }
+//	go p.Watch(ctx)
+type FileTokenProvider struct {
+	Path         string
+	PollInterval time.Duration      // minimum time between two checks of the file, default DefaultFilePollInterval
+	OnChange     func(token string) // optional, called by Watch after it loaded a rotated token
+	OnEvent      EventHandler       // optional, receives fetched and failed events when the file is read
+
+	mu      sync.Mutex
+	token   string
+	modTime time.Time
+	size    int64
+	checked time.Time
+}
+
+// NewFileTokenProvider creates a provider reading the token at path
+func NewFileTokenProvider(path string) *FileTokenProvider {
+	return &FileTokenProvider{Path: path}
+}
+
+// Kind returns the provider kind reported in snapshots
+func (f *FileTokenProvider) Kind() string {
+	return "file"
+}
+
+// FetchToken returns the current content of the file, reloading it when it has changed
+func (f *FileTokenProvider) FetchToken(ctx context.Context) (string, error) {
+	if f.Path == "" {
+		return "", errors.New("file token configuration is incomplete: Path must be provided")
+	}
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	token, _, err := f.load(false)
+	return token, err
+}
+
+// Watch polls the file every PollInterval until ctx is done, calling OnChange after each rotation
+// Read errors are reported through OnEvent and retried on the next poll; a panic, e.g. in OnChange,
+// is reported as EventPanic and the polling resumes after a backoff
+func (f *FileTokenProvider) Watch(ctx context.Context) {
+	ticker := time.NewTicker(f.pollInterval())
+	defer ticker.Stop()
+	panics := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := protect("file-watch", func() error {
+				token, changed, err := f.load(true)
+				// Only the watch calls OnChange: FetchToken may run under the lock of a cache whose
+				// ForceExpire OnChange calls, and the token it returns is the rotated one anyway
+				if changed && f.OnChange != nil {
+					f.OnChange(token)
+				}
+				return err
+			})
+			var pErr *PanicError
+			if !errors.As(err, &pErr) {
+				panics = 0
+				continue
+			}
+			panics++
+			f.OnEvent.emit(Event{Type: EventPanic, Provider: "file", Err: err})
+			wait, _ := watchRetry.Backoff(panics, 0)
+			if sleepContext(ctx, wait) != nil {
+				return
+			}
+		}
+	}
+}
+
+func (f *FileTokenProvider) pollInterval() time.Duration {
+	if f.PollInterval > 0 {
+		return f.PollInterval
+	}
+	return DefaultFilePollInterval
+}
+
+// load returns the cached token, re-reading the file when it changed since the last check
+// Unless force is set the file is checked at most once per PollInterval
+// The event of a read is emitted after f.mu is released, so a panicking handler cannot leave it locked
+func (f *FileTokenProvider) load(force bool) (string, bool, error) {
+	token, changed, ev, err := f.check(force)
+	if ev != nil {
+		f.OnEvent.emit(*ev)
+	}
+	return token, changed, err
+}
+
+// check runs reload under f.mu unless the cached token was checked within PollInterval
+func (f *FileTokenProvider) check(force bool) (string, bool, *Event, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !force && f.token != "" && time.Since(f.checked) < f.pollInterval() {
+		return f.token, false, nil, nil
+	}
+	return f.reload()
+}
+
+// reload stats the file and reads it when its modification time or size changed, the caller must hold f.mu
+// It returns the fetched or failed event for the caller to emit, nil when the file was not read
+func (f *FileTokenProvider) reload() (string, bool, *Event, error) {
+	start := time.Now()
+	fail := func(err error) (string, bool, *Event, error) {
+		err = fmt.Errorf("failed to read token file %s: %w", f.Path, err)
+		return "", false, &Event{Type: EventTokenFailed, Provider: "file", Duration: time.Since(start), Err: err}, err
+	}
+	info, err := os.Stat(f.Path)
+	if err != nil {
+		return fail(err)
+	}
+	f.checked = time.Now()
+	if f.token != "" && info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return f.token, false, nil, nil
+	}
+	raw, err := os.ReadFile(f.Path)
+	if err != nil {
+		return fail(err)
+	}
+	token := string(bytes.TrimSpace(raw))
+	if token == "" {
+		// Writers that truncate before writing leave an empty file for a moment, keep the previous token
+		// modTime is not updated so the file is read again on the next check
+		if f.token != "" {
+			return f.token, false, nil, nil
+		}
+		return fail(errors.New("file is empty"))
+	}
+	changed := f.token != "" && token != f.token
+	f.token, f.modTime, f.size = token, info.ModTime(), info.Size()
+	return token, changed, &Event{Type: EventTokenFetched, Provider: "file", Duration: time.Since(start)}, nil
+}
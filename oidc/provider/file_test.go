@@ -0,0 +1,135 @@
+package oidc_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	oidc "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+// writeToken writes token to path and moves its mtime forward so the rotation is visible
+func writeToken(t *testing.T, path, token string, mtime time.Time) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(path, []byte(token+"\n"), 0o600))
+	require.NoError(t, os.Chtimes(path, mtime, mtime))
+}
+
+func TestFileTokenProvider(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "token")
+	first := validJWT(t)
+	writeToken(t, path, first, time.Now().Add(-time.Minute))
+
+	t.Run("hot reload", func(t *testing.T) {
+		changed := make(chan string, 1)
+		p := &oidc.FileTokenProvider{Path: path, PollInterval: 10 * time.Millisecond}
+		cache := oidc.NewTokenCache(p)
+		p.OnChange = func(token string) {
+			cache.ForceExpire(time.Time{})
+			changed <- token
+		}
+		got, err := cache.GetValidToken(ctx)
+		require.NoError(t, err)
+		require.Equal(t, first, got)
+
+		watchCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go p.Watch(watchCtx)
+
+		second := makeJWT(t, map[string]interface{}{"exp": time.Now().Add(2 * time.Hour).Unix(), "sub": "rotated"})
+		writeToken(t, path, second, time.Now())
+		select {
+		case token := <-changed:
+			require.Equal(t, second, token)
+		case <-time.After(5 * time.Second):
+			t.Fatal("rotation was not detected")
+		}
+		got, err = cache.GetValidToken(ctx)
+		require.NoError(t, err)
+		require.Equal(t, second, got)
+
+		// A truncated file keeps the last token
+		writeToken(t, path, "", time.Now().Add(time.Minute))
+		time.Sleep(20 * time.Millisecond)
+		got, err = p.FetchToken(ctx)
+		require.NoError(t, err)
+		require.Equal(t, second, got)
+	})
+
+	t.Run("rotation picked up by the cache", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "token")
+		writeToken(t, path, first, time.Now().Add(-time.Minute))
+		p := &oidc.FileTokenProvider{Path: path, PollInterval: time.Nanosecond}
+		cache := oidc.NewTokenCache(p)
+		var changes atomic.Int32
+		p.OnChange = func(string) {
+			changes.Add(1)
+			cache.ForceExpire(time.Time{})
+		}
+		_, err := cache.GetValidToken(ctx)
+		require.NoError(t, err)
+
+		// Without Watch the cache itself reads the rotated file while holding its lock,
+		// OnChange calling back into the cache must not deadlock it
+		second := makeJWT(t, map[string]interface{}{"exp": time.Now().Add(2 * time.Hour).Unix(), "sub": "rotated"})
+		writeToken(t, path, second, time.Now())
+		cache.ForceExpire(time.Time{})
+		done := make(chan string, 1)
+		go func() {
+			got, err := cache.GetValidToken(ctx)
+			require.NoError(t, err)
+			done <- got
+		}()
+		select {
+		case got := <-done:
+			require.Equal(t, second, got)
+		case <-time.After(5 * time.Second):
+			t.Fatal("GetValidToken deadlocked on the rotated file")
+		}
+		require.Zero(t, changes.Load())
+	})
+
+	t.Run("panicking event handler", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "token")
+		writeToken(t, path, first, time.Now().Add(-time.Minute))
+		var panicked atomic.Bool
+		p := &oidc.FileTokenProvider{Path: path, PollInterval: time.Millisecond}
+		p.OnEvent = func(ev oidc.Event) {
+			if ev.Type == oidc.EventTokenFetched && panicked.CompareAndSwap(false, true) {
+				panic("handler bug")
+			}
+		}
+		watchCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go p.Watch(watchCtx)
+		require.Eventually(t, panicked.Load, 5*time.Second, time.Millisecond)
+
+		// The panic was recovered by Watch and must not have left the provider locked
+		done := make(chan string, 1)
+		go func() {
+			got, err := p.FetchToken(ctx)
+			require.NoError(t, err)
+			done <- got
+		}()
+		select {
+		case got := <-done:
+			require.Equal(t, first, got)
+		case <-time.After(5 * time.Second):
+			t.Fatal("FetchToken blocked after a panicking event handler")
+		}
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		_, err := oidc.NewFileTokenProvider(filepath.Join(t.TempDir(), "missing")).FetchToken(ctx)
+		require.ErrorIs(t, err, os.ErrNotExist)
+
+		_, err = (&oidc.FileTokenProvider{}).FetchToken(ctx)
+		require.ErrorContains(t, err, "Path")
+	})
+}
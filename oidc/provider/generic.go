@@ -0,0 +1,167 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// ConfigGeneric holds configuration for any OIDC compliant IdP (Dex, ZITADEL, Authentik, ...)
+// Only the issuer is needed, the token endpoint is resolved from its discovery document
+type ConfigGeneric struct {
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string  // requested scopes, e.g. "openid" to receive an id_token where supported
+	Audience     string    // optional, sent as "audience" parameter (ZITADEL, Authentik and others honour it)
+	Token        TokenKind // default TokenKindAccess
+
+	// ClientAssertionSigner authenticates the client with a signed assertion (private_key_jwt, RFC 7523)
+	// instead of ClientSecret
+	ClientAssertionSigner   JWTSigner
+	ClientAssertionLifetime time.Duration // default 2 minutes, also used by ClientAuthSecretJWT
+	// ClientAuthMethod forces a client authentication method, default ClientAuthAuto: private_key_jwt
+	// with ClientAssertionSigner, tls_client_auth with only ClientTLS, otherwise the secret is sent with
+	// client_secret_basic or client_secret_post as advertised in the discovery document
+	// client_secret_jwt and the other advertised methods are only used when set here
+	ClientAuthMethod ClientAuthMethod
+	// ClientTLS sends token requests over mutual TLS (RFC 8705) to the token endpoint, or to its
+	// mtls_endpoint_aliases entry when the IdP advertises one
+	ClientTLS *ClientTLS
+}
+
+// GenericProvider implements TokenProvider for any OIDC IdP using the client credentials grant
+// The discovery document is fetched on the first request and reused; a failed discovery is retried
+// on the next request
+type GenericProvider struct {
+	Config   *ConfigGeneric
+	Insecure bool
+	OnEvent  EventHandler // optional, receives request, fetched and failed events
+
+	mu  sync.Mutex
+	doc *DiscoveryDocument
+}
+
+// NewGenericProvider creates a provider for the issuer
+func NewGenericProvider(issuerURL, clientID, clientSecret string, scopes ...string) *GenericProvider {
+	return &GenericProvider{Config: &ConfigGeneric{
+		IssuerURL:    issuerURL,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Scopes:       scopes,
+	}}
+}
+
+// Validate checks that issuer and client ID are present
+func (c *ConfigGeneric) Validate() error {
+	if c == nil {
+		return errors.New("OIDC configuration is nil")
+	}
+	if c.IssuerURL == "" || c.ClientID == "" {
+		return errors.New("OIDC configuration is incomplete: IssuerURL and ClientID must be provided")
+	}
+	if err := c.ClientAuthMethod.validate(c.ClientSecret, c.ClientAssertionSigner, c.ClientTLS); err != nil {
+		return fmt.Errorf("OIDC configuration is invalid: %w", err)
+	}
+	return nil
+}
+
+// Kind returns the provider kind reported in snapshots
+func (g *GenericProvider) Kind() string {
+	return "oidc"
+}
+
+// Capabilities reports what the provider supports
+func (g *GenericProvider) Capabilities() Capabilities {
+	var kind TokenKind
+	if g.Config != nil {
+		kind = g.Config.Token
+	}
+	return tokenKindCapabilities(kind)
+}
+
+// Discovery returns the issuer's discovery document, fetching it on first use
+// The document must name the configured issuer, as required by OIDC Discovery section 4.3
+func (g *GenericProvider) Discovery(ctx context.Context) (*DiscoveryDocument, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.doc != nil {
+		return g.doc, nil
+	}
+	// The client certificate is presented here too, ClientTLS may carry the CA of a private issuer
+	client, err := NewMTLSHTTPClient("oidc", g.Insecure, g.Config.ClientTLS)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := Discover(ctx, client, g.Config.IssuerURL)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(doc.Issuer, "/") != strings.TrimSuffix(g.Config.IssuerURL, "/") {
+		return nil, fmt.Errorf("discovery document issuer %q does not match %q", doc.Issuer, g.Config.IssuerURL)
+	}
+	if doc.TokenEndpoint == "" {
+		return nil, errors.New("discovery document has no token_endpoint")
+	}
+	g.doc = doc
+	return doc, nil
+}
+
+// FetchToken fetches a new token from the token endpoint named in the discovery document
+func (g *GenericProvider) FetchToken(ctx context.Context) (string, error) {
+	if err := g.Config.Validate(); err != nil {
+		return "", err
+	}
+	doc, err := g.Discovery(ctx)
+	if err != nil {
+		return "", err
+	}
+	httpClient, err := NewMTLSHTTPClient("oidc", g.Insecure, g.Config.ClientTLS)
+	if err != nil {
+		return "", err
+	}
+	tokenURL := doc.TokenEndpoint
+	if alias := doc.MTLSEndpointAliases["token_endpoint"]; alias != "" && g.Config.ClientTLS != nil {
+		tokenURL = alias
+	}
+	conf := &clientcredentials.Config{
+		ClientID:     g.Config.ClientID,
+		ClientSecret: g.Config.ClientSecret,
+		TokenURL:     tokenURL,
+		Scopes:       MergeScopes(g.Config.Scopes, ScopesFromContext(ctx)),
+		AuthStyle:    authStyleFor(doc.TokenEndpointAuthMethods),
+	}
+	if g.Config.Audience != "" {
+		conf.EndpointParams = url.Values{"audience": {g.Config.Audience}}
+	}
+	if conf, err = g.Config.ClientAuthMethod.apply(conf, g.Config.ClientAssertionSigner, g.Config.ClientAssertionLifetime, g.Config.ClientTLS); err != nil {
+		return "", fmt.Errorf("failed to sign client assertion: %w", err)
+	}
+	return fetchClientCredentials(ctx, clientCredentialsRequest{
+		provider: "oidc",
+		idp:      g.Config.IssuerURL,
+		conf:     conf,
+		client:   httpClient,
+		kind:     g.Config.Token,
+		onEvent:  g.OnEvent,
+	})
+}
+
+// authStyleFor picks the client authentication advertised by the IdP,
+// client_secret_basic is the OIDC default when nothing is advertised
+func authStyleFor(methods []string) oauth2.AuthStyle {
+	if len(methods) == 0 || containsString(methods, "client_secret_basic") {
+		return oauth2.AuthStyleInHeader
+	}
+	if containsString(methods, "client_secret_post") {
+		return oauth2.AuthStyleInParams
+	}
+	return oauth2.AuthStyleAutoDetect
+}
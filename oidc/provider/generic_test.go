@@ -0,0 +1,114 @@
+package oidc_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	oidc "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newFakeIssuer starts an IdP serving discovery and a token endpoint at /oauth/v2/token
+// issuer overrides the issuer advertised in discovery when not empty
+func newFakeIssuer(t *testing.T, issuer string, authMethods []string, handler http.HandlerFunc) (string, *atomic.Int32) {
+	t.Helper()
+	var discoveries atomic.Int32
+	var srv *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		discoveries.Add(1)
+		iss := issuer
+		if iss == "" {
+			iss = srv.URL
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                iss,
+			"token_endpoint":                        srv.URL + "/oauth/v2/token",
+			"token_endpoint_auth_methods_supported": authMethods,
+		})
+	})
+	mux.HandleFunc("/oauth/v2/token", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		handler(w, r)
+	})
+	srv = httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv.URL, &discoveries
+}
+
+func TestGenericProvider(t *testing.T) {
+	token := validJWT(t)
+	issuer, discoveries := newFakeIssuer(t, "", []string{"client_secret_post"}, func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "app", r.PostForm.Get("client_id"))
+		require.Equal(t, "secret", r.PostForm.Get("client_secret"))
+		require.Equal(t, "openid", r.PostForm.Get("scope"))
+		require.Equal(t, "zitadel-project", r.PostForm.Get("audience"))
+		writeTokenResponse(w, map[string]interface{}{"access_token": token, "expires_in": 3600})
+	})
+
+	p := oidc.NewGenericProvider(issuer, "app", "secret", "openid")
+	p.Config.Audience = "zitadel-project"
+	for i := 0; i < 2; i++ {
+		got, err := p.FetchToken(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, token, got)
+	}
+	require.EqualValues(t, 1, discoveries.Load())
+	require.Equal(t, "oidc", p.Kind())
+}
+
+func TestGenericProviderBasicAuth(t *testing.T) {
+	issuer, _ := newFakeIssuer(t, "", nil, func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		require.True(t, ok)
+		require.Equal(t, "app", user)
+		require.Equal(t, "secret", pass)
+		writeTokenResponse(w, map[string]interface{}{"access_token": "access", "id_token": "id"})
+	})
+	p := oidc.NewGenericProvider(issuer, "app", "secret")
+	p.Config.Token = oidc.TokenKindID
+	got, err := p.FetchToken(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "id", got)
+}
+
+func TestGenericProviderIssuerMismatch(t *testing.T) {
+	issuer, discoveries := newFakeIssuer(t, "https://evil.example.com", nil, func(w http.ResponseWriter, r *http.Request) {
+		t.Error("token endpoint must not be called")
+	})
+	p := oidc.NewGenericProvider(issuer, "app", "secret")
+	for i := 0; i < 2; i++ {
+		_, err := p.FetchToken(context.Background())
+		require.ErrorContains(t, err, "does not match")
+	}
+	// Failed discovery is not cached
+	require.EqualValues(t, 2, discoveries.Load())
+}
+
+func TestGenericProviderClientAssertion(t *testing.T) {
+	signer := newTestSigners(t)["p256"]
+	var assertion string
+	issuer, _ := newFakeIssuer(t, "", []string{"private_key_jwt"}, func(w http.ResponseWriter, r *http.Request) {
+		_, _, basic := r.BasicAuth()
+		require.False(t, basic)
+		require.Empty(t, r.PostForm.Get("client_secret"))
+		require.Equal(t, oidc.ClientAssertionType, r.PostForm.Get("client_assertion_type"))
+		assertion = r.PostForm.Get("client_assertion")
+		writeTokenResponse(w, map[string]interface{}{"access_token": "access"})
+	})
+	p := oidc.NewGenericProvider(issuer, "app", "")
+	p.Config.ClientAssertionSigner = signer
+	got, err := p.FetchToken(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "access", got)
+	require.Equal(t, issuer+"/oauth/v2/token", decodeSegment(t, assertion, 1)["aud"])
+}
@@ -0,0 +1,113 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// Environment variables GitHub Actions sets for jobs with "permissions: id-token: write"
+const (
+	GitHubActionsRequestURLEnv   = "ACTIONS_ID_TOKEN_REQUEST_URL"
+	GitHubActionsRequestTokenEnv = "ACTIONS_ID_TOKEN_REQUEST_TOKEN"
+)
+
+// ErrNotInGitHubActions is returned when the GitHub Actions OIDC environment variables are missing,
+// either outside GitHub Actions or in a job without the id-token: write permission
+var ErrNotInGitHubActions = errors.New("GitHub Actions OIDC is not available: " + GitHubActionsRequestURLEnv + " and " + GitHubActionsRequestTokenEnv + " must be set (job needs permissions: id-token: write)")
+
+// GitHubActionsProvider implements TokenProvider with the id_token GitHub Actions issues to the running job
+// RequestURL and RequestToken default to the ACTIONS_ID_TOKEN_REQUEST_* environment variables
+type GitHubActionsProvider struct {
+	Audience     string // aud of the requested token, GitHub's default (the repository owner URL) when empty
+	RequestURL   string
+	RequestToken string
+	HTTPClient   *http.Client // optional, default NewHTTPClient("github-actions", false)
+	OnEvent      EventHandler // optional, receives request, fetched and failed events
+}
+
+// Kind returns the provider kind reported in snapshots
+func (g *GitHubActionsProvider) Kind() string {
+	return "github-actions"
+}
+
+// Capabilities reports what the provider supports
+func (g *GitHubActionsProvider) Capabilities() Capabilities {
+	return Capabilities{IDToken: true}
+}
+
+// FetchToken requests a new id_token from the GitHub Actions token service
+func (g *GitHubActionsProvider) FetchToken(ctx context.Context) (string, error) {
+	requestURL, requestToken := g.RequestURL, g.RequestToken
+	if requestURL == "" {
+		requestURL = os.Getenv(GitHubActionsRequestURLEnv)
+	}
+	if requestToken == "" {
+		requestToken = os.Getenv(GitHubActionsRequestTokenEnv)
+	}
+	if requestURL == "" || requestToken == "" {
+		return "", ErrNotInGitHubActions
+	}
+	u, err := url.Parse(requestURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid %s: %w", GitHubActionsRequestURLEnv, err)
+	}
+	if g.Audience != "" {
+		q := u.Query()
+		q.Set("audience", g.Audience)
+		u.RawQuery = q.Encode()
+	}
+	g.OnEvent.emit(Event{Type: EventTokenRequest, Provider: "github-actions"})
+	start := time.Now()
+	token, err := g.request(ctx, u.String(), requestToken)
+	if err != nil {
+		g.OnEvent.emit(Event{Type: EventTokenFailed, Provider: "github-actions", Duration: time.Since(start), Err: err})
+		return "", err
+	}
+	g.OnEvent.emit(Event{Type: EventTokenFetched, Provider: "github-actions", Duration: time.Since(start)})
+	return token, nil
+}
+
+// request calls the token service and decodes its {"value": "<jwt>"} response
+func (g *GitHubActionsProvider) request(ctx context.Context, requestURL, requestToken string) (string, error) {
+	client := g.HTTPClient
+	if client == nil {
+		client = NewHTTPClient("github-actions", false)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, requestURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+requestToken)
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to get token from GitHub Actions: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", fmt.Errorf("failed to read GitHub Actions token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", &TokenError{
+			Provider:    "github-actions",
+			StatusCode:  resp.StatusCode,
+			Description: string(body),
+			RetryAfter:  ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+		}
+	}
+	var out struct {
+		Value string `json:"value"`
+	}
+	if err := json.Unmarshal(body, &out); err != nil || out.Value == "" {
+		return "", errors.New("failed to extract token from GitHub Actions response")
+	}
+	return out.Value, nil
+}
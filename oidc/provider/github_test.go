@@ -0,0 +1,49 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	oidc "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGitHubActionsProvider(t *testing.T) {
+	token := validJWT(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer runtime-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		require.Equal(t, "1", r.URL.Query().Get("api-version"))
+		require.Equal(t, "https://iam.googleapis.com/projects/1/locations/global/workloadIdentityPools/gh/providers/gh", r.URL.Query().Get("audience"))
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"count":1,"value":"` + token + `"}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	t.Setenv(oidc.GitHubActionsRequestURLEnv, srv.URL+"/token?api-version=1")
+	t.Setenv(oidc.GitHubActionsRequestTokenEnv, "runtime-token")
+
+	p := &oidc.GitHubActionsProvider{Audience: "https://iam.googleapis.com/projects/1/locations/global/workloadIdentityPools/gh/providers/gh"}
+	got, err := oidc.NewTokenCache(p).GetValidToken(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, token, got)
+
+	p.RequestToken = "wrong"
+	_, err = p.FetchToken(context.Background())
+	var tErr *oidc.TokenError
+	require.True(t, errors.As(err, &tErr))
+	require.Equal(t, http.StatusUnauthorized, tErr.StatusCode)
+}
+
+func TestGitHubActionsProviderOutsideActions(t *testing.T) {
+	t.Setenv(oidc.GitHubActionsRequestURLEnv, "")
+	t.Setenv(oidc.GitHubActionsRequestTokenEnv, "")
+	_, err := (&oidc.GitHubActionsProvider{}).FetchToken(context.Background())
+	require.ErrorIs(t, err, oidc.ErrNotInGitHubActions)
+}
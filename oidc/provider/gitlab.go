@@ -0,0 +1,74 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// DefaultGitLabTokenVariables are the variables GitLabCIProvider looks at when Variable is empty:
+// common names for an id_tokens entry, then the deprecated predefined CI_JOB_JWT_V2
+var DefaultGitLabTokenVariables = []string{"GITLAB_OIDC_TOKEN", "ID_TOKEN", "CI_JOB_JWT_V2"}
+
+// ErrNoGitLabToken is returned when no usable GitLab CI job token is present in the environment
+var ErrNoGitLabToken = errors.New("GitLab CI id_token is not available: declare it with id_tokens in .gitlab-ci.yml")
+
+// GitLabCIProvider implements TokenProvider with the id_token GitLab CI injects into a job
+// GitLab sets the audience in the pipeline (id_tokens: NAME: aud: ...), the provider selects and
+// checks the token: with Audience set only a token whose aud contains it is returned
+// The token is read from the environment on every call and rejected once expired
+type GitLabCIProvider struct {
+	Variable string // environment variable holding the token, DefaultGitLabTokenVariables when empty
+	Audience string // optional, required aud of the token
+}
+
+// Kind returns the provider kind reported in snapshots
+func (g *GitLabCIProvider) Kind() string {
+	return "gitlab-ci"
+}
+
+// Capabilities reports what the provider supports
+func (g *GitLabCIProvider) Capabilities() Capabilities {
+	return Capabilities{IDToken: true}
+}
+
+// FetchToken returns the job's id_token after checking it is present, unexpired and for Audience
+func (g *GitLabCIProvider) FetchToken(ctx context.Context) (string, error) {
+	variables := DefaultGitLabTokenVariables
+	if g.Variable != "" {
+		variables = []string{g.Variable}
+	}
+	var errs []error
+	for _, name := range variables {
+		token := os.Getenv(name)
+		if token == "" {
+			continue
+		}
+		if err := g.check(token); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+		return token, nil
+	}
+	if len(errs) == 0 {
+		return "", fmt.Errorf("%w (looked at %v)", ErrNoGitLabToken, variables)
+	}
+	return "", fmt.Errorf("%w: %w", ErrNoGitLabToken, errors.Join(errs...))
+}
+
+// check validates expiry and audience of a job token
+func (g *GitLabCIProvider) check(token string) error {
+	exp, err := getJWTExpiry(token)
+	if err != nil {
+		return fmt.Errorf("invalid token: %w", err)
+	}
+	if !time.Now().Before(time.Unix(exp, 0)) {
+		return fmt.Errorf("token expired at %s", time.Unix(exp, 0).Format(time.RFC3339))
+	}
+	if g.Audience != "" {
+		return checkClaims(token, []ClaimAssertion{ClaimContains("aud", g.Audience)})
+	}
+	return nil
+}
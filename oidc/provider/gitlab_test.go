@@ -0,0 +1,52 @@
+package oidc_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	oidc "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGitLabCIProvider(t *testing.T) {
+	gcp := makeJWT(t, map[string]interface{}{"exp": time.Now().Add(time.Hour).Unix(), "aud": "https://iam.googleapis.com/projects/1"})
+	vault := makeJWT(t, map[string]interface{}{"exp": time.Now().Add(time.Hour).Unix(), "aud": "https://vault.example.com"})
+	legacy := makeJWT(t, map[string]interface{}{"exp": time.Now().Add(time.Hour).Unix(), "aud": "https://gitlab.example.com"})
+	t.Setenv("GITLAB_OIDC_TOKEN", "")
+	t.Setenv("ID_TOKEN", vault)
+	t.Setenv("CI_JOB_JWT_V2", legacy)
+	t.Setenv("GCP_ID_TOKEN", gcp)
+	ctx := context.Background()
+
+	t.Run("named variable", func(t *testing.T) {
+		got, err := (&oidc.GitLabCIProvider{Variable: "GCP_ID_TOKEN", Audience: "https://iam.googleapis.com/projects/1"}).FetchToken(ctx)
+		require.NoError(t, err)
+		require.Equal(t, gcp, got)
+	})
+
+	t.Run("selected by audience", func(t *testing.T) {
+		got, err := (&oidc.GitLabCIProvider{Audience: "https://gitlab.example.com"}).FetchToken(ctx)
+		require.NoError(t, err)
+		require.Equal(t, legacy, got)
+	})
+
+	t.Run("audience mismatch", func(t *testing.T) {
+		_, err := (&oidc.GitLabCIProvider{Variable: "ID_TOKEN", Audience: "https://iam.googleapis.com/projects/1"}).FetchToken(ctx)
+		require.ErrorIs(t, err, oidc.ErrNoGitLabToken)
+		var aErr *oidc.ClaimAssertionError
+		require.ErrorAs(t, err, &aErr)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		t.Setenv("OLD_TOKEN", makeJWT(t, map[string]interface{}{"exp": time.Now().Add(-time.Minute).Unix()}))
+		_, err := (&oidc.GitLabCIProvider{Variable: "OLD_TOKEN"}).FetchToken(ctx)
+		require.ErrorContains(t, err, "expired")
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		_, err := (&oidc.GitLabCIProvider{Variable: "NOT_SET_TOKEN"}).FetchToken(ctx)
+		require.ErrorIs(t, err, oidc.ErrNoGitLabToken)
+	})
+}
@@ -0,0 +1,151 @@
+package oidc
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+)
+
+// GrantType selects the OAuth2 grant used by KeycloakTokenProvider
+// The zero value behaves like GrantClientCredentials so existing configs keep working
+type GrantType string
+
+const (
+	// GrantClientCredentials uses the client_credentials grant (client ID + secret)
+	GrantClientCredentials GrantType = "client_credentials"
+	// GrantPassword uses the resource owner password credentials grant (username + password)
+	GrantPassword GrantType = "password"
+	// GrantRefreshToken renews tokens from an existing refresh token
+	GrantRefreshToken GrantType = "refresh_token"
+	// GrantTokenExchange exchanges a subject token for a new token (RFC 8693)
+	GrantTokenExchange GrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+	// GrantJWTBearer presents a signed JWT assertion as authorization grant (RFC 7523)
+	GrantJWTBearer GrantType = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+)
+
+// Token type identifiers defined by RFC 8693 section 3
+const (
+	TokenTypeAccessToken  = "urn:ietf:params:oauth:token-type:access_token"
+	TokenTypeRefreshToken = "urn:ietf:params:oauth:token-type:refresh_token"
+	TokenTypeIDToken      = "urn:ietf:params:oauth:token-type:id_token"
+	TokenTypeJWT          = "urn:ietf:params:oauth:token-type:jwt"
+)
+
+// AudienceMode selects how ConfigKeyCloak.Audience is requested from Keycloak
+type AudienceMode int
+
+const (
+	// AudienceParam sends Audience as the "audience" parameter of the token request
+	// Keycloak honours it for token exchange and for clients with an audience mapper reading it
+	AudienceParam AudienceMode = iota
+	// AudienceExchange obtains a token with the configured grant and exchanges it (RFC 8693)
+	// for an id_token issued to Audience; the Audience client must permit the exchange
+	AudienceExchange
+)
+
+// NormalizedRealmURL returns KeycloakRealmURL normalized by NormalizeRealmURL, unchanged when it is invalid
+func (c *ConfigKeyCloak) NormalizedRealmURL() string {
+	realmURL, err := NormalizeRealmURL(c.KeycloakRealmURL)
+	if err != nil {
+		return c.KeycloakRealmURL
+	}
+	return realmURL
+}
+
+// TokenURL returns the token endpoint of the realm
+func (c *ConfigKeyCloak) TokenURL() string {
+	return c.NormalizedRealmURL() + "/protocol/openid-connect/token"
+}
+
+// grantType returns the configured grant, defaulting to client_credentials
+func (c *ConfigKeyCloak) grantType() GrantType {
+	if c.GrantType == "" {
+		return GrantClientCredentials
+	}
+	return c.GrantType
+}
+
+// Validate checks that every field required by the selected grant is present
+// KeycloakRealmURL and KeycloakClientID are always required
+// The client secret is only mandatory for client_credentials, other grants may use public clients
+func (c *ConfigKeyCloak) Validate() error {
+	if c == nil {
+		return errors.New("Keycloak configuration is nil")
+	}
+	if c.KeycloakRealmURL == "" || c.KeycloakClientID == "" {
+		return errors.New("Keycloak configuration is incomplete: KeycloakRealmURL and KeycloakClientID must be provided")
+	}
+	if _, err := NormalizeRealmURL(c.KeycloakRealmURL); err != nil {
+		return fmt.Errorf("Keycloak configuration is invalid: %w", err)
+	}
+	if err := c.ClientAuthMethod.validate(c.KeycloakClientSecret, c.ClientAssertionSigner, c.ClientTLS); err != nil {
+		return fmt.Errorf("Keycloak configuration is invalid: %w", err)
+	}
+	if err := checkSigner("AssertionSigner", c.AssertionSigner); err != nil {
+		return fmt.Errorf("Keycloak configuration is invalid: %w", err)
+	}
+	switch c.grantType() {
+	case GrantClientCredentials:
+		if c.KeycloakClientSecret == "" && c.ClientAssertionSigner == nil && c.ClientTLS == nil {
+			return errors.New("Keycloak configuration is incomplete: KeycloakRealmURL, KeycloakClientID, and KeycloakClientSecret must be provided")
+		}
+	case GrantPassword:
+		if c.Username == "" || c.Password == "" {
+			return errors.New("Keycloak configuration is incomplete: Username and Password are required for the password grant")
+		}
+	case GrantRefreshToken:
+		if c.RefreshToken == "" {
+			return errors.New("Keycloak configuration is incomplete: RefreshToken is required for the refresh_token grant")
+		}
+	case GrantTokenExchange:
+		if c.SubjectToken == "" {
+			return errors.New("Keycloak configuration is incomplete: SubjectToken is required for the token-exchange grant")
+		}
+	case GrantJWTBearer:
+		if c.AssertionSigner == nil {
+			return errors.New("Keycloak configuration is incomplete: AssertionSigner is required for the jwt-bearer grant")
+		}
+	default:
+		return fmt.Errorf("unsupported Keycloak grant type %q", c.GrantType)
+	}
+	return nil
+}
+
+// grantParams builds the grant specific form parameters sent to the token endpoint
+// grant_type is always set so the clientcredentials helper sends the selected grant
+func (c *ConfigKeyCloak) grantParams(tokenURL string) (url.Values, error) {
+	grant := c.grantType()
+	v := url.Values{"grant_type": {string(grant)}}
+	switch grant {
+	case GrantPassword:
+		v.Set("username", c.Username)
+		v.Set("password", c.Password)
+	case GrantRefreshToken:
+		v.Set("refresh_token", c.RefreshToken)
+	case GrantTokenExchange:
+		v.Set("subject_token", c.SubjectToken)
+		subjectType := c.SubjectTokenType
+		if subjectType == "" {
+			subjectType = TokenTypeAccessToken
+		}
+		v.Set("subject_token_type", subjectType)
+		if c.RequestedTokenType != "" {
+			v.Set("requested_token_type", c.RequestedTokenType)
+		}
+	case GrantJWTBearer:
+		// A fresh assertion is signed for every request, the subject defaults to the client ID
+		subject := c.AssertionSubject
+		if subject == "" {
+			subject = c.KeycloakClientID
+		}
+		assertion, err := c.AssertionSigner.BearerGrantAssertion(c.KeycloakClientID, subject, tokenURL, 0)
+		if err != nil {
+			return nil, fmt.Errorf("failed to sign jwt-bearer assertion: %w", err)
+		}
+		v.Set("assertion", assertion)
+	}
+	if c.Audience != "" && c.AudienceMode == AudienceParam {
+		v.Set("audience", c.Audience)
+	}
+	return v, nil
+}
@@ -0,0 +1,149 @@
+package oidc_test
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	oidc "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeycloakGrantTypes(t *testing.T) {
+	idToken := validJWT(t)
+	var got map[string]string
+	realm := newFakeKeycloak(t, func(w http.ResponseWriter, r *http.Request) {
+		got = map[string]string{}
+		for k := range r.PostForm {
+			got[k] = r.PostForm.Get(k)
+		}
+		if r.PostForm.Get("requested_token_type") == oidc.TokenTypeIDToken {
+			writeTokenResponse(w, map[string]interface{}{"access_token": idToken, "issued_token_type": oidc.TokenTypeIDToken})
+			return
+		}
+		writeTokenResponse(w, map[string]interface{}{"access_token": "at", "id_token": idToken})
+	})
+
+	tests := []struct {
+		name   string
+		cfg    oidc.ConfigKeyCloak
+		expect map[string]string
+	}{
+		{
+			name:   "client credentials by default",
+			cfg:    oidc.ConfigKeyCloak{KeycloakClientSecret: "secret"},
+			expect: map[string]string{"grant_type": "client_credentials"},
+		},
+		{
+			name:   "password",
+			cfg:    oidc.ConfigKeyCloak{GrantType: oidc.GrantPassword, Username: "alice", Password: "pw"},
+			expect: map[string]string{"grant_type": "password", "username": "alice", "password": "pw"},
+		},
+		{
+			name:   "refresh token",
+			cfg:    oidc.ConfigKeyCloak{GrantType: oidc.GrantRefreshToken, RefreshToken: "rt"},
+			expect: map[string]string{"grant_type": "refresh_token", "refresh_token": "rt"},
+		},
+		{
+			name: "token exchange returning id_token",
+			cfg: oidc.ConfigKeyCloak{
+				GrantType:          oidc.GrantTokenExchange,
+				SubjectToken:       "subject",
+				RequestedTokenType: oidc.TokenTypeIDToken,
+			},
+			expect: map[string]string{
+				"grant_type":         string(oidc.GrantTokenExchange),
+				"subject_token":      "subject",
+				"subject_token_type": oidc.TokenTypeAccessToken,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			cfg.KeycloakRealmURL = realm
+			cfg.KeycloakClientID = "client"
+			provider := &oidc.KeycloakTokenProvider{Config: &cfg}
+			token, err := provider.FetchToken(context.Background())
+			require.NoError(t, err)
+			require.Equal(t, idToken, token)
+			for k, v := range tt.expect {
+				require.Equal(t, v, got[k], k)
+			}
+			require.Equal(t, "openid", got["scope"])
+		})
+	}
+}
+
+func TestConfigKeyCloakValidate(t *testing.T) {
+	base := oidc.ConfigKeyCloak{KeycloakRealmURL: "https://kc/realms/r", KeycloakClientID: "client"}
+	tests := []struct {
+		name    string
+		mutate  func(c *oidc.ConfigKeyCloak)
+		wantErr bool
+	}{
+		{"client credentials needs secret", func(c *oidc.ConfigKeyCloak) {}, true},
+		{"client credentials ok", func(c *oidc.ConfigKeyCloak) { c.KeycloakClientSecret = "s" }, false},
+		{"password needs username", func(c *oidc.ConfigKeyCloak) { c.GrantType = oidc.GrantPassword; c.Password = "pw" }, true},
+		{"password public client ok", func(c *oidc.ConfigKeyCloak) {
+			c.GrantType = oidc.GrantPassword
+			c.Username, c.Password = "alice", "pw"
+		}, false},
+		{"refresh needs token", func(c *oidc.ConfigKeyCloak) { c.GrantType = oidc.GrantRefreshToken }, true},
+		{"exchange needs subject", func(c *oidc.ConfigKeyCloak) { c.GrantType = oidc.GrantTokenExchange }, true},
+		{"unknown grant", func(c *oidc.ConfigKeyCloak) { c.GrantType = "implicit" }, true},
+		{"missing realm", func(c *oidc.ConfigKeyCloak) { c.KeycloakRealmURL = ""; c.KeycloakClientSecret = "s" }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := base
+			tt.mutate(&cfg)
+			err := cfg.Validate()
+			if tt.wantErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestKeycloakAudience(t *testing.T) {
+	exchanged := makeJWT(t, map[string]interface{}{"exp": time.Now().Add(time.Hour).Unix(), "aud": "wif-client"})
+	var requests []url.Values
+	realm := newFakeKeycloak(t, func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.PostForm)
+		if r.PostForm.Get("grant_type") == string(oidc.GrantTokenExchange) {
+			writeTokenResponse(w, map[string]interface{}{"access_token": exchanged, "issued_token_type": oidc.TokenTypeIDToken})
+			return
+		}
+		writeTokenResponse(w, map[string]interface{}{"access_token": "at", "id_token": validJWT(t)})
+	})
+	base := oidc.ConfigKeyCloak{KeycloakRealmURL: realm, KeycloakClientID: "client", KeycloakClientSecret: "secret", Audience: "wif-client"}
+
+	t.Run("audience parameter", func(t *testing.T) {
+		requests = nil
+		cfg := base
+		_, err := (&oidc.KeycloakTokenProvider{Config: &cfg}).FetchToken(context.Background())
+		require.NoError(t, err)
+		require.Len(t, requests, 1)
+		require.Equal(t, "wif-client", requests[0].Get("audience"))
+	})
+
+	t.Run("audience exchange", func(t *testing.T) {
+		requests = nil
+		cfg := base
+		cfg.AudienceMode = oidc.AudienceExchange
+		token, err := (&oidc.KeycloakTokenProvider{Config: &cfg}).FetchToken(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, exchanged, token)
+		require.Len(t, requests, 2)
+		require.Empty(t, requests[0].Get("audience"))
+		require.Equal(t, "at", requests[1].Get("subject_token"))
+		require.Equal(t, "wif-client", requests[1].Get("audience"))
+		require.Equal(t, oidc.TokenTypeIDToken, requests[1].Get("requested_token_type"))
+	})
+}
@@ -0,0 +1,267 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// HealthChecker is implemented by providers that can check whether their IdP is reachable
+type HealthChecker interface {
+	HealthCheck(ctx context.Context) error
+}
+
+// Health check failures wrap one of these errors, so "Keycloak down" can be told apart from
+// "Keycloak up but the realm is broken"
+var (
+	// ErrIdPUnavailable means the IdP itself is unreachable or reports that it is not ready
+	ErrIdPUnavailable = errors.New("identity provider unavailable")
+	// ErrRealmUnavailable means the IdP answers but the realm does not serve its discovery document
+	ErrRealmUnavailable = errors.New("realm unavailable")
+)
+
+// HealthCheck verifies that Keycloak is ready and the realm answers its OIDC discovery document
+// When HealthURL is set Keycloak's health/ready endpoint is checked first
+// Failures wrap ErrIdPUnavailable (Keycloak down or not ready) or ErrRealmUnavailable (realm broken)
+func (k *KeycloakTokenProvider) HealthCheck(ctx context.Context) error {
+	if k.Config == nil || k.Config.KeycloakRealmURL == "" {
+		return fmt.Errorf("Keycloak configuration is incomplete: KeycloakRealmURL must be provided")
+	}
+	client := NewHTTPClient("keycloak", k.Insecure)
+	if k.HealthURL != "" {
+		if err := probeURL(ctx, client, k.HealthURL); err != nil {
+			return fmt.Errorf("%w: %w", ErrIdPUnavailable, err)
+		}
+	}
+	discoveryURL := k.Config.NormalizedRealmURL() + "/.well-known/openid-configuration"
+	err := probeURL(ctx, client, discoveryURL)
+	var statusErr *probeStatusError
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &statusErr):
+		return fmt.Errorf("%w: %w", ErrRealmUnavailable, err)
+	default:
+		return fmt.Errorf("%w: %w", ErrIdPUnavailable, err)
+	}
+}
+
+// probeStatusError is returned by probeURL when the endpoint answers with a non-200 status
+type probeStatusError struct {
+	URL        string
+	StatusCode int
+}
+
+func (e *probeStatusError) Error() string {
+	return fmt.Sprintf("health check %s returned status %d", e.URL, e.StatusCode)
+}
+
+// probeURL performs a GET request and expects 200 OK
+func probeURL(ctx context.Context, client *http.Client, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("health check %s failed: %w", url, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return &probeStatusError{URL: url, StatusCode: resp.StatusCode}
+	}
+	return nil
+}
+
+// EndpointStatus is the last known health of a probed endpoint
+type EndpointStatus struct {
+	Name                string
+	Healthy             bool
+	LastChecked         time.Time
+	LastError           string
+	Latency             time.Duration
+	ConsecutiveFailures int
+}
+
+// HealthProber actively probes a set of named endpoints in the background
+// Its status feeds FailoverProvider so healthy endpoints are preferred before a request fails
+type HealthProber struct {
+	Interval time.Duration // time between probe rounds, default 30s
+	Timeout  time.Duration // timeout of a single probe, default 5s
+
+	mu         sync.RWMutex
+	checkers   map[string]HealthChecker
+	status     map[string]EndpointStatus
+	lifecycles map[string]*Lifecycle
+	stop       chan struct{}
+	wg         sync.WaitGroup
+}
+
+// NewHealthProber creates a prober running a probe round every interval
+func NewHealthProber(interval time.Duration) *HealthProber {
+	return &HealthProber{
+		Interval: interval,
+		checkers: map[string]HealthChecker{},
+		status:   map[string]EndpointStatus{},
+	}
+}
+
+// Add registers an endpoint to probe under the given name
+// Endpoints are considered healthy until a probe says otherwise
+func (p *HealthProber) Add(name string, checker HealthChecker) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.checkers[name] = checker
+	if _, ok := p.status[name]; !ok {
+		p.status[name] = EndpointStatus{Name: name, Healthy: true}
+	}
+}
+
+// Start launches the background probing loop, which runs until Close or ctx is done
+// Calling Start more than once has no effect
+func (p *HealthProber) Start(ctx context.Context) {
+	p.mu.Lock()
+	if p.stop != nil {
+		p.mu.Unlock()
+		return
+	}
+	p.stop = make(chan struct{})
+	stop := p.stop
+	p.mu.Unlock()
+
+	interval := p.Interval
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		probe := func() {
+			_ = protect("health-probe", func() error {
+				p.ProbeNow(ctx)
+				return nil
+			})
+		}
+		probe()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-stop:
+				return
+			case <-ticker.C:
+				probe()
+			}
+		}
+	}()
+}
+
+// ProbeNow runs one probe round over all endpoints concurrently and waits for it to finish
+func (p *HealthProber) ProbeNow(ctx context.Context) {
+	p.mu.RLock()
+	checkers := make(map[string]HealthChecker, len(p.checkers))
+	for name, c := range p.checkers {
+		checkers[name] = c
+	}
+	p.mu.RUnlock()
+
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	var wg sync.WaitGroup
+	for name, checker := range checkers {
+		wg.Add(1)
+		go func(name string, checker HealthChecker) {
+			defer wg.Done()
+			probeCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			start := time.Now()
+			// A panicking checker counts as an unhealthy endpoint
+			err := protect("health-check:"+name, func() error {
+				return checker.HealthCheck(probeCtx)
+			})
+			p.record(name, err, time.Since(start))
+		}(name, checker)
+	}
+	wg.Wait()
+}
+
+// Track feeds the probe results of the named endpoint into a provider lifecycle
+func (p *HealthProber) Track(name string, l *Lifecycle) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.lifecycles == nil {
+		p.lifecycles = map[string]*Lifecycle{}
+	}
+	p.lifecycles[name] = l
+}
+
+func (p *HealthProber) record(name string, err error, latency time.Duration) {
+	lifecycle := func() *Lifecycle {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.recordLocked(name, err, latency)
+		return p.lifecycles[name]
+	}()
+	// Lifecycle handlers run outside the prober lock
+	if lifecycle != nil {
+		lifecycle.ReportHealth(err)
+	}
+}
+
+// recordLocked updates the status of the named endpoint, the caller must hold p.mu
+func (p *HealthProber) recordLocked(name string, err error, latency time.Duration) {
+	st := p.status[name]
+	st.Name = name
+	st.LastChecked = time.Now()
+	st.Latency = latency
+	if err != nil {
+		st.Healthy = false
+		st.LastError = err.Error()
+		st.ConsecutiveFailures++
+	} else {
+		st.Healthy = true
+		st.LastError = ""
+		st.ConsecutiveFailures = 0
+	}
+	p.status[name] = st
+}
+
+// Status returns the current status of every endpoint, sorted by name
+func (p *HealthProber) Status() []EndpointStatus {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	out := make([]EndpointStatus, 0, len(p.status))
+	for _, st := range p.status {
+		out = append(out, st)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// Healthy reports whether the named endpoint is healthy, unknown endpoints count as healthy
+func (p *HealthProber) Healthy(name string) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	st, ok := p.status[name]
+	return !ok || st.Healthy
+}
+
+// Close stops the background probing loop and waits for it to exit
+func (p *HealthProber) Close() error {
+	p.mu.Lock()
+	if p.stop != nil {
+		close(p.stop)
+		p.stop = nil
+	}
+	p.mu.Unlock()
+	p.wg.Wait()
+	return nil
+}
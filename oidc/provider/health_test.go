@@ -0,0 +1,56 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	oidc "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeycloakHealthCheck(t *testing.T) {
+	ctx := context.Background()
+	realm := newFakeKeycloak(t, func(w http.ResponseWriter, r *http.Request) {})
+	ready := true
+	health := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	t.Cleanup(health.Close)
+
+	provider := func(realmURL string) *oidc.KeycloakTokenProvider {
+		return &oidc.KeycloakTokenProvider{
+			Config:    &oidc.ConfigKeyCloak{KeycloakRealmURL: realmURL, KeycloakClientID: "svc"},
+			HealthURL: health.URL + "/health/ready",
+		}
+	}
+
+	t.Run("healthy", func(t *testing.T) {
+		require.NoError(t, provider(realm).HealthCheck(ctx))
+	})
+
+	t.Run("realm broken", func(t *testing.T) {
+		err := provider(realm + "-missing").HealthCheck(ctx)
+		require.True(t, errors.Is(err, oidc.ErrRealmUnavailable))
+		require.False(t, errors.Is(err, oidc.ErrIdPUnavailable))
+	})
+
+	t.Run("keycloak not ready", func(t *testing.T) {
+		ready = false
+		defer func() { ready = true }()
+		err := provider(realm).HealthCheck(ctx)
+		require.True(t, errors.Is(err, oidc.ErrIdPUnavailable))
+	})
+
+	t.Run("keycloak unreachable without health URL", func(t *testing.T) {
+		srv := httptest.NewServer(http.NotFoundHandler())
+		srv.Close()
+		p := &oidc.KeycloakTokenProvider{Config: &oidc.ConfigKeyCloak{KeycloakRealmURL: srv.URL + "/realms/test"}}
+		require.True(t, errors.Is(p.HealthCheck(ctx), oidc.ErrIdPUnavailable))
+	})
+}
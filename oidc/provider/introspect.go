@@ -0,0 +1,137 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// ErrIntrospectionUnsupported is returned by Introspect when the IdP offers no introspection endpoint
+var ErrIntrospectionUnsupported = errors.New("IdP does not offer token introspection")
+
+// IntrospectionResult is the response of an RFC 7662 introspection endpoint
+// Inactive tokens (expired, revoked, unknown, issued to another realm) only have Active false; the
+// embedded Claims hold the standard members and Raw every member, e.g. Keycloak's realm_access
+type IntrospectionResult struct {
+	Active    bool
+	ClientID  string
+	Username  string
+	TokenType string
+	Claims
+}
+
+// Scopes returns the space separated Scope as a slice
+func (r *IntrospectionResult) Scopes() []string {
+	return strings.Fields(r.Scope)
+}
+
+// Introspector is implemented by providers whose IdP introspects tokens (RFC 7662), e.g. to validate
+// opaque access tokens in resource servers
+// The provider's client credentials authenticate the request, the client needs the IdP's permission
+// to introspect tokens issued to other clients
+type Introspector interface {
+	Introspect(ctx context.Context, token string) (*IntrospectionResult, error)
+}
+
+// Introspect asks the realm's introspection endpoint whether token is active
+func (k *KeycloakTokenProvider) Introspect(ctx context.Context, token string) (*IntrospectionResult, error) {
+	if err := k.Config.Validate(); err != nil {
+		return nil, err
+	}
+	client, err := NewMTLSHTTPClient("keycloak", k.Insecure, k.Config.ClientTLS)
+	if err != nil {
+		return nil, err
+	}
+	conf, err := k.Config.ClientAuthMethod.apply(&clientcredentials.Config{
+		ClientID:     k.Config.KeycloakClientID,
+		ClientSecret: k.Config.KeycloakClientSecret,
+		TokenURL:     k.Config.TokenURL(),
+	}, k.Config.ClientAssertionSigner, k.Config.ClientAssertionLifetime, k.Config.ClientTLS)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign Keycloak client assertion: %w", err)
+	}
+	return introspectToken(ctx, client, "keycloak", k.Config.NormalizedRealmURL()+"/protocol/openid-connect/token/introspect", conf, token)
+}
+
+// Introspect asks the realm's introspection endpoint whether token is active
+func (k *KeycloakPasswordProvider) Introspect(ctx context.Context, token string) (*IntrospectionResult, error) {
+	p, err := k.tokenProvider()
+	if err != nil {
+		return nil, err
+	}
+	return p.Introspect(ctx, token)
+}
+
+// Introspect asks the introspection_endpoint of the discovery document whether token is active
+// Errors wrap ErrIntrospectionUnsupported when the IdP does not advertise one
+func (g *GenericProvider) Introspect(ctx context.Context, token string) (*IntrospectionResult, error) {
+	if err := g.Config.Validate(); err != nil {
+		return nil, err
+	}
+	doc, err := g.Discovery(ctx)
+	if err != nil {
+		return nil, err
+	}
+	endpoint := doc.IntrospectionEndpoint
+	if alias := doc.MTLSEndpointAliases["introspection_endpoint"]; alias != "" && g.Config.ClientTLS != nil {
+		endpoint = alias
+	}
+	if endpoint == "" {
+		return nil, fmt.Errorf("%w: %s advertises no introspection_endpoint", ErrIntrospectionUnsupported, g.Config.IssuerURL)
+	}
+	client, err := NewMTLSHTTPClient("oidc", g.Insecure, g.Config.ClientTLS)
+	if err != nil {
+		return nil, err
+	}
+	conf, err := g.Config.ClientAuthMethod.apply(&clientcredentials.Config{
+		ClientID:     g.Config.ClientID,
+		ClientSecret: g.Config.ClientSecret,
+		TokenURL:     doc.TokenEndpoint,
+		AuthStyle:    authStyleFor(doc.IntrospectionEndpointAuthMethods),
+	}, g.Config.ClientAssertionSigner, g.Config.ClientAssertionLifetime, g.Config.ClientTLS)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign client assertion: %w", err)
+	}
+	return introspectToken(ctx, client, "oidc", endpoint, conf, token)
+}
+
+// Introspect asks the authorization server's introspection endpoint whether token is active
+func (o *OktaTokenProvider) Introspect(ctx context.Context, token string) (*IntrospectionResult, error) {
+	if err := o.Config.Validate(); err != nil {
+		return nil, err
+	}
+	endpoint := strings.TrimSuffix(o.Config.TokenURL(), "/token") + "/introspect"
+	conf := &clientcredentials.Config{ClientID: o.Config.ClientID, ClientSecret: o.Config.ClientSecret, AuthStyle: oauth2.AuthStyleInHeader}
+	return introspectToken(ctx, NewHTTPClient("okta", o.Insecure), "okta", endpoint, conf, token)
+}
+
+// introspectToken sends an RFC 7662 introspection request for token to endpoint
+func introspectToken(ctx context.Context, client *http.Client, provider, endpoint string, conf *clientcredentials.Config, token string) (*IntrospectionResult, error) {
+	if token == "" {
+		return nil, errors.New("no token to introspect")
+	}
+	body, err := postClientForm(ctx, client, provider, endpoint, conf, url.Values{"token": {token}})
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]interface{}
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse %s introspection response: %w", provider, err)
+	}
+	active, ok := raw["active"].(bool)
+	if !ok {
+		return nil, fmt.Errorf("%s introspection response has no active member", provider)
+	}
+	res := &IntrospectionResult{Active: active, Claims: *claimsFromMap(raw)}
+	res.ClientID, _ = raw["client_id"].(string)
+	res.Username, _ = raw["username"].(string)
+	res.TokenType, _ = raw["token_type"].(string)
+	return res, nil
+}
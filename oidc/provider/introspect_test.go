@@ -0,0 +1,135 @@
+package oidc_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	oidc "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIntrospect(t *testing.T) {
+	ctx := context.Background()
+	exp := time.Now().Add(time.Hour).Truncate(time.Second)
+	introspection := func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		switch r.PostForm.Get("token") {
+		case "opaque":
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"active":       true,
+				"scope":        "orders:read orders:write",
+				"client_id":    "orders-ui",
+				"username":     "alice",
+				"token_type":   "Bearer",
+				"sub":          "user-1",
+				"iss":          "https://sso.example.com/realms/test",
+				"aud":          []string{"orders-api", "account"},
+				"exp":          exp.Unix(),
+				"realm_access": map[string]interface{}{"roles": []string{"admin"}},
+			})
+		case "denied":
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error":"invalid_client"}`))
+		case "malformed":
+			_, _ = w.Write([]byte(`{"scope":"x"}`))
+		default:
+			_, _ = w.Write([]byte(`{"active":false}`))
+		}
+	}
+
+	t.Run("keycloak", func(t *testing.T) {
+		var user string
+		mux := http.NewServeMux()
+		mux.HandleFunc("/realms/test/protocol/openid-connect/token/introspect", func(w http.ResponseWriter, r *http.Request) {
+			user, _, _ = r.BasicAuth()
+			introspection(w, r)
+		})
+		srv := httptest.NewServer(mux)
+		t.Cleanup(srv.Close)
+		p := &oidc.KeycloakTokenProvider{Config: &oidc.ConfigKeyCloak{
+			KeycloakRealmURL:     srv.URL + "/realms/test",
+			KeycloakClientID:     "orders-api",
+			KeycloakClientSecret: "secret",
+		}}
+		var _ oidc.Introspector = p
+
+		res, err := p.Introspect(ctx, "opaque")
+		require.NoError(t, err)
+		require.Equal(t, "orders-api", user)
+		require.True(t, res.Active)
+		require.Equal(t, []string{"orders:read", "orders:write"}, res.Scopes())
+		require.Equal(t, "orders-ui", res.ClientID)
+		require.Equal(t, "alice", res.Username)
+		require.Equal(t, "Bearer", res.TokenType)
+		require.Equal(t, "user-1", res.Subject)
+		require.Equal(t, []string{"orders-api", "account"}, res.Audience)
+		require.True(t, exp.Equal(res.Expiry))
+		require.Contains(t, res.Raw, "realm_access")
+
+		res, err = p.Introspect(ctx, "revoked")
+		require.NoError(t, err)
+		require.False(t, res.Active)
+		require.Empty(t, res.Subject)
+
+		_, err = p.Introspect(ctx, "denied")
+		var tErr *oidc.TokenError
+		require.True(t, errors.As(err, &tErr))
+		require.Equal(t, "invalid_client", tErr.Code)
+
+		_, err = p.Introspect(ctx, "malformed")
+		require.ErrorContains(t, err, "no active member")
+
+		_, err = p.Introspect(ctx, "")
+		require.Error(t, err)
+	})
+
+	t.Run("generic provider uses the discovered endpoint", func(t *testing.T) {
+		var srv *httptest.Server
+		mux := http.NewServeMux()
+		mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"issuer":                 srv.URL,
+				"token_endpoint":         srv.URL + "/token",
+				"introspection_endpoint": srv.URL + "/introspect",
+				"introspection_endpoint_auth_methods_supported": []string{"client_secret_post"},
+			})
+		})
+		mux.HandleFunc("/introspect", func(w http.ResponseWriter, r *http.Request) {
+			require.NoError(t, r.ParseForm())
+			require.Equal(t, "client", r.PostForm.Get("client_id"))
+			require.Equal(t, "secret", r.PostForm.Get("client_secret"))
+			introspection(w, r)
+		})
+		srv = httptest.NewServer(mux)
+		t.Cleanup(srv.Close)
+
+		res, err := oidc.NewGenericProvider(srv.URL, "client", "secret").Introspect(ctx, "opaque")
+		require.NoError(t, err)
+		require.True(t, res.Active)
+	})
+
+	t.Run("generic provider without endpoint", func(t *testing.T) {
+		issuer, _ := newFakeIssuer(t, "", nil, func(w http.ResponseWriter, r *http.Request) {})
+		_, err := oidc.NewGenericProvider(issuer, "client", "secret").Introspect(ctx, "opaque")
+		require.ErrorIs(t, err, oidc.ErrIntrospectionUnsupported)
+	})
+
+	t.Run("okta endpoint", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.Equal(t, "/oauth2/default/v1/introspect", r.URL.Path)
+			introspection(w, r)
+		}))
+		t.Cleanup(srv.Close)
+
+		okta := &oidc.OktaTokenProvider{Config: &oidc.ConfigOkta{IssuerURL: srv.URL + "/oauth2/default", ClientID: "c", ClientSecret: "s"}}
+		res, err := okta.Introspect(ctx, "opaque")
+		require.NoError(t, err)
+		require.True(t, res.Active)
+	})
+}
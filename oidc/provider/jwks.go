@@ -0,0 +1,292 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrUnknownKeyID is returned when the JWKS has no key with the requested kid
+var ErrUnknownKeyID = errors.New("unknown key id")
+
+// JWK is a single JSON Web Key as published in a JWKS document
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// PublicKey converts the JWK into an *rsa.PublicKey, *ecdsa.PublicKey or ed25519.PublicKey
+func (k JWK) PublicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RSA modulus: %w", err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RSA exponent: %w", err)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported EC curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EC x coordinate: %w", err)
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EC y coordinate: %w", err)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported OKP curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 public key")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+// JWKS fetches and caches the signing keys of an issuer
+// Keys are refreshed every RefreshInterval and when an unknown kid shows up,
+// which is how IdP key rotation is picked up. Refreshes are rate limited by
+// MinRefreshInterval since the last attempt, failed ones included, so garbage tokens
+// or an unreachable IdP cannot be used to flood it. Concurrent refreshes share one fetch.
+type JWKS struct {
+	URL                string
+	HTTPClient         *http.Client  // default NewHTTPClient("jwks", false)
+	RefreshInterval    time.Duration // default 10m
+	MinRefreshInterval time.Duration // default 10s
+
+	mu          sync.RWMutex
+	keys        map[string]crypto.PublicKey
+	fetchedAt   time.Time // time of the last successful fetch
+	attemptedAt time.Time // time of the last fetch attempt
+	lastErr     error     // result of the last fetch attempt
+	inflight    *jwksFetch
+
+	refreshes     atomic.Uint64
+	refreshErrors atomic.Uint64
+	rotations     atomic.Uint64
+}
+
+// JWKSStats reports the health of a key set, for monitoring IdP key rotation
+type JWKSStats struct {
+	URL           string
+	KeyCount      int
+	KeyIDs        []string
+	FetchedAt     time.Time
+	Age           time.Duration // time since the last successful fetch, zero if never fetched
+	Refreshes     uint64        // successful fetches
+	RefreshErrors uint64        // failed fetches
+	Rotations     uint64        // fetches that observed a changed set of key IDs
+}
+
+// Stats returns the current key set metrics
+func (j *JWKS) Stats() JWKSStats {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	st := JWKSStats{
+		URL:           j.URL,
+		KeyCount:      len(j.keys),
+		FetchedAt:     j.fetchedAt,
+		Refreshes:     j.refreshes.Load(),
+		RefreshErrors: j.refreshErrors.Load(),
+		Rotations:     j.rotations.Load(),
+	}
+	if !j.fetchedAt.IsZero() {
+		st.Age = time.Since(j.fetchedAt)
+	}
+	for kid := range j.keys {
+		st.KeyIDs = append(st.KeyIDs, kid)
+	}
+	sort.Strings(st.KeyIDs)
+	return st
+}
+
+// NewJWKS creates a key set fetched from url
+func NewJWKS(url string) *JWKS {
+	return &JWKS{URL: url}
+}
+
+// jwksFetch is a running key set fetch, done is closed once err is set
+type jwksFetch struct {
+	done chan struct{}
+	err  error
+}
+
+// Key returns the public key with the given kid, refreshing the key set if needed
+func (j *JWKS) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	j.mu.RLock()
+	key, ok := j.keys[kid]
+	fresh := time.Since(j.fetchedAt) < j.refreshInterval()
+	recent := time.Since(j.attemptedAt) < j.minRefreshInterval()
+	lastErr := j.lastErr
+	fetching := j.inflight != nil
+	j.mu.RUnlock()
+	if ok && (fresh || recent) {
+		// A stale key is served until the next refresh attempt is allowed
+		return key, nil
+	}
+	// Lookups during a running fetch wait for it in Refresh
+	if recent && !fetching {
+		if lastErr != nil {
+			return nil, lastErr
+		}
+		return nil, fmt.Errorf("%w %q", ErrUnknownKeyID, kid)
+	}
+	if err := j.Refresh(ctx); err != nil {
+		if ok {
+			// Keep serving the known key if the IdP is temporarily unreachable
+			return key, nil
+		}
+		return nil, err
+	}
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	if key, ok = j.keys[kid]; !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKeyID, kid)
+	}
+	return key, nil
+}
+
+// Refresh downloads the key set
+// A call made while another refresh runs waits for that one and returns its result
+func (j *JWKS) Refresh(ctx context.Context) error {
+	j.mu.Lock()
+	if f := j.inflight; f != nil {
+		j.mu.Unlock()
+		select {
+		case <-f.done:
+			return f.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	f := &jwksFetch{done: make(chan struct{})}
+	j.inflight = f
+	j.attemptedAt = time.Now()
+	j.mu.Unlock()
+
+	f.err = j.refresh(ctx)
+	if f.err != nil {
+		j.refreshErrors.Add(1)
+	} else {
+		j.refreshes.Add(1)
+	}
+	j.mu.Lock()
+	j.inflight = nil
+	j.lastErr = f.err
+	j.mu.Unlock()
+	close(f.done)
+	return f.err
+}
+
+func (j *JWKS) refresh(ctx context.Context) error {
+	client := j.HTTPClient
+	if client == nil {
+		client = NewHTTPClient("jwks", false)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, j.URL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch JWKS %s: status %d", j.URL, resp.StatusCode)
+	}
+	var doc struct {
+		Keys []JWK `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return fmt.Errorf("invalid JWKS document: %w", err)
+	}
+	keys := make(map[string]crypto.PublicKey, len(doc.Keys))
+	for _, k := range doc.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		pub, err := k.PublicKey()
+		if err != nil {
+			// Skip keys we cannot use instead of failing the whole set
+			continue
+		}
+		keys[k.Kid] = pub
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.keys != nil && !sameKeyIDs(j.keys, keys) {
+		j.rotations.Add(1)
+	}
+	j.keys = keys
+	j.fetchedAt = time.Now()
+	return nil
+}
+
+// sameKeyIDs reports whether two key sets contain the same key IDs
+func sameKeyIDs(a, b map[string]crypto.PublicKey) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for kid := range a {
+		if _, ok := b[kid]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (j *JWKS) refreshInterval() time.Duration {
+	if j.RefreshInterval > 0 {
+		return j.RefreshInterval
+	}
+	return 10 * time.Minute
+}
+
+func (j *JWKS) minRefreshInterval() time.Duration {
+	if j.MinRefreshInterval > 0 {
+		return j.MinRefreshInterval
+	}
+	return 10 * time.Second
+}
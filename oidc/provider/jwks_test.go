@@ -0,0 +1,108 @@
+package oidc_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	oidc "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestJWKSStatsTrackRotation(t *testing.T) {
+	var rotated atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys := []map[string]string{{"kty": "OKP", "crv": "Ed25519", "kid": "k1", "x": "11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"}}
+		if rotated.Load() {
+			keys = append(keys, map[string]string{"kty": "OKP", "crv": "Ed25519", "kid": "k2", "x": "11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"})
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+	}))
+	defer srv.Close()
+
+	jwks := oidc.NewJWKS(srv.URL)
+	jwks.MinRefreshInterval = 1
+	require.Zero(t, jwks.Stats().Age)
+
+	_, err := jwks.Key(context.Background(), "k1")
+	require.NoError(t, err)
+	st := jwks.Stats()
+	require.Equal(t, 1, st.KeyCount)
+	require.Equal(t, uint64(1), st.Refreshes)
+	require.Zero(t, st.Rotations)
+	require.False(t, st.FetchedAt.IsZero())
+
+	// An unknown kid triggers a refresh which observes the rotation
+	rotated.Store(true)
+	_, err = jwks.Key(context.Background(), "k2")
+	require.NoError(t, err)
+	st = jwks.Stats()
+	require.Equal(t, []string{"k1", "k2"}, st.KeyIDs)
+	require.Equal(t, uint64(1), st.Rotations)
+	require.Equal(t, uint64(2), st.Refreshes)
+}
+
+func TestVerifierStatsFailureReasons(t *testing.T) {
+	iss := newTestIssuer(t)
+	v := oidc.NewVerifier(oidc.VerifierConfig{Issuer: iss.URL, JWKSURL: iss.URL + "/jwks"})
+	ctx := context.Background()
+
+	_, err := v.Verify(ctx, iss.sign(t, "RS256", "rsa", iss.claims("api")))
+	require.NoError(t, err)
+	expired := iss.claims("api")
+	expired["exp"] = 1
+	_, _ = v.Verify(ctx, iss.sign(t, "RS256", "rsa", expired))
+	_, _ = v.Verify(ctx, iss.sign(t, "RS256", "nope", iss.claims("api")))
+	_, _ = v.Verify(ctx, "garbage")
+
+	st := v.Stats()
+	require.Equal(t, uint64(1), st.Verified)
+	require.Equal(t, uint64(1), st.Failures[oidc.ReasonExpired])
+	require.Equal(t, uint64(1), st.Failures[oidc.ReasonUnknownKeyID])
+	require.Equal(t, uint64(1), st.Failures[oidc.ReasonMalformed])
+	require.Equal(t, 3, st.JWKS.KeyCount)
+}
+
+func TestJWKSRefreshRateLimit(t *testing.T) {
+	var fetches atomic.Int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		<-release
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	jwks := oidc.NewJWKS(srv.URL)
+	ctx := context.Background()
+
+	// Concurrent lookups share one fetch
+	var wg sync.WaitGroup
+	errs := make([]error, 8)
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = jwks.Key(ctx, "k1")
+		}()
+	}
+	require.Eventually(t, func() bool { return fetches.Load() == 1 }, time.Second, time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	for _, err := range errs {
+		require.ErrorContains(t, err, "status 503")
+	}
+
+	// A failed fetch counts as an attempt, lookups within MinRefreshInterval get its error
+	_, err := jwks.Key(ctx, "k2")
+	require.ErrorContains(t, err, "status 503")
+	require.NotErrorIs(t, err, oidc.ErrUnknownKeyID)
+	require.EqualValues(t, 1, fetches.Load())
+	require.EqualValues(t, 1, jwks.Stats().RefreshErrors)
+}
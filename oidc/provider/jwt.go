@@ -0,0 +1,196 @@
+package oidc
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ErrMalformedJWT is wrapped by every error of strict JWT parsing
+var ErrMalformedJWT = errors.New("malformed JWT")
+
+// numericDateClaims must be integers in strict mode
+var numericDateClaims = []string{"exp", "nbf", "iat"}
+
+// jwtHeader is the JOSE header of a JWT
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Typ string `json:"typ"`
+}
+
+// parsedJWT is a decoded but not yet verified JWT
+type parsedJWT struct {
+	header       jwtHeader
+	claims       map[string]interface{}
+	signingInput string // header.payload as sent, input of the signature
+	signature    []byte
+}
+
+// parseJWT splits and decodes a compact serialized JWT without verifying it
+// In strict mode the token must also pass checkStrictJWT
+func parseJWT(token string, strict bool) (*parsedJWT, error) {
+	if strict {
+		if err := checkStrictJWT(token); err != nil {
+			return nil, err
+		}
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("invalid token format")
+	}
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid token header encoding: %w", err)
+	}
+	var header jwtHeader
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, fmt.Errorf("invalid token header: %w", err)
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid token payload encoding: %w", err)
+	}
+	var claims map[string]interface{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("invalid token payload: %w", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid token signature encoding: %w", err)
+	}
+	return &parsedJWT{
+		header:       header,
+		claims:       claims,
+		signingInput: parts[0] + "." + parts[1],
+		signature:    signature,
+	}, nil
+}
+
+// DecodeJWTClaims decodes the claims of a JWT without verifying it
+// Lenient decoding only looks at the payload segment, like the token cache does by default
+// Strict decoding also rejects what checkStrictJWT rejects, with errors wrapping ErrMalformedJWT
+func DecodeJWTClaims(token string, strict bool) (map[string]interface{}, error) {
+	if strict {
+		if err := checkStrictJWT(token); err != nil {
+			return nil, err
+		}
+	}
+	return decodeJWTClaims(token)
+}
+
+// jwtExpiry returns the exp claim of token as Unix time, checking the token strictly when asked
+func jwtExpiry(token string, strict bool) (int64, error) {
+	if strict {
+		if err := checkStrictJWT(token); err != nil {
+			return 0, err
+		}
+	}
+	return getJWTExpiry(token)
+}
+
+// checkStrictJWT requires exactly three segments of unpadded base64url, a header and payload that are
+// single JSON objects without duplicate keys, and integer exp, nbf and iat claims
+// Lenient parsing accepts all of these and silently uses the last duplicate or ignores a string exp
+func checkStrictJWT(token string) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return fmt.Errorf("%w: token has %d segments, expected 3", ErrMalformedJWT, len(parts))
+	}
+	names := []string{"header", "payload", "signature"}
+	decoded := make([][]byte, len(parts))
+	for i, part := range parts {
+		raw, err := base64.RawURLEncoding.Strict().DecodeString(part)
+		if err != nil {
+			return fmt.Errorf("%w: invalid %s encoding: %w", ErrMalformedJWT, names[i], err)
+		}
+		decoded[i] = raw
+	}
+	for i := 0; i < 2; i++ {
+		if err := checkJSONObject(decoded[i]); err != nil {
+			return fmt.Errorf("%w: invalid %s: %w", ErrMalformedJWT, names[i], err)
+		}
+	}
+	dec := json.NewDecoder(bytes.NewReader(decoded[1]))
+	dec.UseNumber()
+	var claims map[string]interface{}
+	if err := dec.Decode(&claims); err != nil {
+		return fmt.Errorf("%w: invalid payload: %w", ErrMalformedJWT, err)
+	}
+	for _, name := range numericDateClaims {
+		v, ok := claims[name]
+		if !ok {
+			continue
+		}
+		n, isNumber := v.(json.Number)
+		if !isNumber {
+			return fmt.Errorf("%w: claim %q is %T, expected an integer", ErrMalformedJWT, name, v)
+		}
+		if _, err := strconv.ParseInt(n.String(), 10, 64); err != nil {
+			return fmt.Errorf("%w: claim %q is %s, expected an integer", ErrMalformedJWT, name, n)
+		}
+	}
+	return nil
+}
+
+// checkJSONObject requires data to be exactly one JSON object without duplicate keys at any depth
+func checkJSONObject(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok != json.Delim('{') {
+		return errors.New("not a JSON object")
+	}
+	if err := checkJSONValue(dec, tok, 0); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return errors.New("trailing data after JSON object")
+	}
+	return nil
+}
+
+// maxJSONDepth bounds the nesting checkJSONValue walks, tokens are small and never nest deeply
+const maxJSONDepth = 64
+
+// checkJSONValue walks the value starting with tok and rejects duplicate object keys
+func checkJSONValue(dec *json.Decoder, tok json.Token, depth int) error {
+	delim, ok := tok.(json.Delim)
+	if !ok {
+		return nil
+	}
+	if depth >= maxJSONDepth {
+		return errors.New("JSON nesting too deep")
+	}
+	seen := map[string]bool{}
+	for dec.More() {
+		if delim == '{' {
+			key, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			name, _ := key.(string)
+			if seen[name] {
+				return fmt.Errorf("duplicate key %q", name)
+			}
+			seen[name] = true
+		}
+		value, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		if err := checkJSONValue(dec, value, depth+1); err != nil {
+			return err
+		}
+	}
+	// Consume the closing delimiter
+	_, err := dec.Token()
+	return err
+}
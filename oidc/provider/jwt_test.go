@@ -0,0 +1,86 @@
+package oidc_test
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+
+	oidc "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+// rawJWT builds a token from raw header and payload JSON
+func rawJWT(header, payload string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(header)) + "." + base64.RawURLEncoding.EncodeToString([]byte(payload)) + ".c2ln"
+}
+
+func TestStrictJWT(t *testing.T) {
+	header := `{"alg":"none"}`
+	cases := []struct {
+		name  string
+		token string
+	}{
+		{"string exp", rawJWT(header, `{"exp":"4102444800"}`)},
+		{"fractional exp", rawJWT(header, `{"exp":4102444800.5}`)},
+		{"non-integer iat", rawJWT(header, `{"exp":4102444800,"iat":true}`)},
+		{"duplicate payload key", rawJWT(header, `{"sub":"a","exp":4102444800,"sub":"b"}`)},
+		{"duplicate nested key", rawJWT(header, `{"exp":4102444800,"act":{"sub":"a","sub":"b"}}`)},
+		{"duplicate header key", rawJWT(`{"alg":"none","alg":"RS256"}`, `{"exp":4102444800}`)},
+		{"two segments", rawJWT(header, `{"exp":4102444800}`)[:len(rawJWT(header, `{"exp":4102444800}`))-5]},
+		{"four segments", rawJWT(header, `{"exp":4102444800}`) + ".x"},
+		{"payload not an object", rawJWT(header, `[1]`)},
+		{"trailing data", rawJWT(header, `{"exp":4102444800}{}`)},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := oidc.DecodeJWTClaims(tc.token, true)
+			require.ErrorIs(t, err, oidc.ErrMalformedJWT)
+		})
+	}
+
+	t.Run("valid token", func(t *testing.T) {
+		claims, err := oidc.DecodeJWTClaims(rawJWT(header, `{"exp":4102444800,"aud":["a","b"],"act":{"sub":"x"}}`), true)
+		require.NoError(t, err)
+		require.EqualValues(t, 4102444800, claims["exp"])
+	})
+
+	t.Run("lenient decoding", func(t *testing.T) {
+		claims, err := oidc.DecodeJWTClaims(rawJWT(header, `{"sub":"a","sub":"b"}`), false)
+		require.NoError(t, err)
+		require.Equal(t, "b", claims["sub"])
+	})
+
+	t.Run("cache rejects malformed tokens", func(t *testing.T) {
+		token := rawJWT(header, `{"exp":4102444800,"exp":1}`)
+		_, err := oidc.NewTokenCache(&stubProvider{token: token}, oidc.WithStrictJWT()).GetValidToken(context.Background())
+		require.ErrorIs(t, err, oidc.ErrMalformedJWT)
+
+		_, err = oidc.NewTokenCache(&stubProvider{token: token}).GetValidToken(context.Background())
+		require.NoError(t, err)
+	})
+}
+
+func FuzzDecodeJWTClaims(f *testing.F) {
+	f.Add(rawJWT(`{"alg":"none"}`, `{"exp":4102444800,"sub":"svc"}`))
+	f.Add(rawJWT(`{"alg":"none"}`, `{"exp":"4102444800"}`))
+	f.Add(rawJWT(`{"alg":"none"}`, `{"a":1,"a":2}`))
+	f.Add(rawJWT(`{"alg":"none"}`, `{"x":[[[[{"y":{}}]]]]}`))
+	f.Add("a.b")
+	f.Add("..")
+	f.Fuzz(func(t *testing.T, token string) {
+		strict, err := oidc.DecodeJWTClaims(token, true)
+		if err != nil {
+			return
+		}
+		// Whatever strict parsing accepts, lenient parsing accepts with the same claims
+		lenient, err := oidc.DecodeJWTClaims(token, false)
+		require.NoError(t, err)
+		require.Equal(t, lenient, strict)
+		if exp, ok := strict["exp"]; ok {
+			n, isNumber := exp.(float64)
+			require.True(t, isNumber)
+			require.Equal(t, float64(int64(n)), n)
+		}
+	})
+}
@@ -2,14 +2,15 @@ package oidc
 
 import (
 	"context"
-	"crypto/tls"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/oauth2"
@@ -22,12 +23,50 @@ import (
 // The KeycloakRealmURL is the base URL of the Keycloak server, including the realm path.
 // The KeycloakClientID is the client ID registered in Keycloak.
 // The KeycloakClientSecret is the secret associated with the client ID.
-// The KeycloakClientScopes is a list of OIDC scopes to request. If empty, defaults to ["openid"].
+// The KeycloakClientScopes is a list of additional OIDC scopes to request, merged after DefaultScopes
+// ("openid") and before per-call additions from ContextWithScopes (see EffectiveScopes).
+// GrantType selects the grant; the remaining fields are only used by the grant that needs them
+// and are checked by Validate.
 type ConfigKeyCloak struct {
 	KeycloakRealmURL     string
 	KeycloakClientID     string
 	KeycloakClientSecret string
-	KeycloakClientScopes []string // OIDC scopes, default to ["openid"] if empty
+	KeycloakClientScopes []string // extra OIDC scopes, "openid" is always requested
+
+	// ClientAssertionSigner authenticates the client with a signed assertion (private_key_jwt, RFC 7523)
+	// instead of KeycloakClientSecret; set the client's authenticator to "Signed JWT" in Keycloak
+	ClientAssertionSigner   JWTSigner
+	ClientAssertionLifetime time.Duration // default 2 minutes, also used by ClientAuthSecretJWT
+	// ClientAuthMethod forces a client authentication method, e.g. ClientAuthSecretJWT for realms
+	// requiring "Signed JWT with Client Secret"; default ClientAuthAuto
+	ClientAuthMethod ClientAuthMethod
+	// ClientTLS sends token requests over mutual TLS (RFC 8705): the certificate authenticates the client
+	// when no secret or signer is set and binds access tokens when the client enables
+	// "OAuth 2.0 Mutual TLS Certificate Bound Access Tokens"
+	ClientTLS *ClientTLS
+
+	GrantType          GrantType // default GrantClientCredentials
+	Username           string    // password grant
+	Password           string    // password grant
+	RefreshToken       string    // refresh_token grant
+	SubjectToken       string    // token-exchange grant
+	SubjectTokenType   string    // token-exchange grant, default TokenTypeAccessToken
+	RequestedTokenType string    // token-exchange grant, optional
+	AssertionSigner    JWTSigner // jwt-bearer grant, signs the assertion (Signer or RotatingSigner)
+	AssertionSubject   string    // jwt-bearer grant, default KeycloakClientID
+
+	Audience     string       // optional aud the issued token must carry, e.g. the WIF provider's allowed audience
+	AudienceMode AudienceMode // how Audience is requested, default AudienceParam
+
+	// KnownGoodScopes enables the scope reduction retry: when Keycloak answers invalid_scope the request
+	// is retried once with the requested scopes that are also listed here (plus DefaultScopes)
+	KnownGoodScopes []string
+
+	// OfflineAccess requests the offline_access scope and renews tokens with the offline token
+	OfflineAccess bool
+	// RenewWithRefreshToken renews tokens with the refresh_token grant using the refresh token of the
+	// previous response instead of repeating the configured grant; implied by OfflineAccess
+	RenewWithRefreshToken bool
 }
 
 // TokenCache is a generic cache for any TokenProvider
@@ -40,6 +79,39 @@ type TokenCache struct {
 	token    string
 	expiry   time.Time
 	mu       sync.Mutex
+
+	ramp       *RefreshRamp     // optional, see WithRefreshRamp
+	assertions []ClaimAssertion // optional, see WithClaimAssertions
+	store      CacheStore       // optional, see WithStore
+	storeKey   string
+	clock      *ClockOffset // optional, see WithSkewCompensation
+	// minRemaining is the strict expiry minimum, see WithMinRemaining
+	minRemaining time.Duration
+	// insufficient is the ErrInsufficientLifetime of the last fetch, answered until insufficientUntil
+	insufficient       error
+	insufficientUntil  time.Time
+	insufficientWarned bool               // the IdP lifetime below the minimum was logged
+	lifecycle          *Lifecycle         // optional, see WithLifecycle
+	lifecycleReports   []error            // fetch outcomes reported to lifecycle by unlock
+	attestation        *Attestation       // optional, see WithAttestation
+	strictJWT          bool               // see WithStrictJWT
+	defaultTTL         time.Duration      // see WithDefaultTTL
+	strategy           RefreshStrategy    // optional, see WithRefreshStrategy
+	issuedAt           time.Time          // local issue time of the token, input of strategy
+	short              *ShortLivedOptions // optional, see WithShortLivedMode
+	prefetchAt         time.Time          // jittered start of the next background fetch in short-lived mode
+	prefetching        chan struct{}      // closed when the running background fetch is done, nil when idle
+
+	watchMu  sync.Mutex
+	watchers map[*watcher]struct{} // see Watch
+	watchWG  sync.WaitGroup
+	closed   bool
+
+	lastRefresh time.Time // time of the last fetch attempt
+	lastErr     error     // result of the last fetch attempt
+	usage       CacheUsage
+	usageSnap   atomic.Pointer[CacheUsage]  // copy of usage read by Usage without c.mu
+	status      atomic.Pointer[CacheStatus] // published by publishStatus, read by Status without c.mu
 }
 
 // KeycloakTokenProvider implements TokenProvider for Keycloak
@@ -49,6 +121,23 @@ type TokenCache struct {
 type KeycloakTokenProvider struct {
 	Config   *ConfigKeyCloak
 	Insecure bool
+	OnEvent  EventHandler // optional, receives request, fetched and failed events
+
+	// HealthURL is Keycloak's readiness endpoint used by HealthCheck when exposed,
+	// e.g. https://keycloak:9000/health/ready (management port, KC_HEALTH_ENABLED=true)
+	HealthURL string
+
+	// Affinity adds headers/cookies to token requests to stay on one Keycloak node, optional
+	Affinity *SessionAffinity
+
+	// RefreshStore persists the refresh (offline) tokens between runs when renewal is enabled, optional
+	// It holds long-lived credentials: keep it private to the service
+	RefreshStore CacheStore
+	// RefreshStoreKey prefixes the RefreshStore keys, one per requested scope set,
+	// default derived from realm URL and client ID
+	RefreshStoreKey string
+
+	refresh refreshState
 }
 
 // TokenProvider is a generic interface for OIDC token providers
@@ -59,44 +148,47 @@ type TokenProvider interface {
 
 // FetchToken fetches a new id_token from Keycloak
 func (k *KeycloakTokenProvider) FetchToken(ctx context.Context) (string, error) {
-	// Check if Keycloak configuration is complete
-	// Ensure that KeycloakRealmURL, KeycloakClientID, and KeycloakClientSecret are provided
-	if k.Config.KeycloakRealmURL == "" || k.Config.KeycloakClientID == "" || k.Config.KeycloakClientSecret == "" {
-		return "", errors.New("Keycloak configuration is incomplete: KeycloakRealmURL, KeycloakClientID, and KeycloakClientSecret must be provided")
+	set, err := k.tokens(ctx, "keycloak", TokenKindID)
+	if err != nil {
+		return "", err
+	}
+	return set.IDToken, nil
+}
+
+// tokens requests new tokens and returns every token of the response, which must contain the token of
+// kind; provider names the provider in events
+func (k *KeycloakTokenProvider) tokens(ctx context.Context, provider string, kind TokenKind) (*TokenSet, error) {
+	// Check if Keycloak configuration is complete for the selected grant
+	if err := k.Config.Validate(); err != nil {
+		return nil, err
 	}
 	// Build Keycloak token endpoint URL
-	tokenURL := fmt.Sprintf("%s/protocol/openid-connect/token", k.Config.KeycloakRealmURL)
-	var httpClient *http.Client
-	if k.Insecure {
-		// If insecure, create a custom HTTP client that skips TLS verification
-		// This is not recommended for production use, but useful for testing or self-signed certs
-		tr := &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		}
-		// Use custom transport with insecure TLS config
-		// This allows the client to connect to Keycloak without verifying the server's TLS certificate
-		// This is useful for development or testing environments with self-signed certificates
-		// or when the Keycloak server uses a certificate that is not trusted by the system's CA store
-		// Note: This should not be used in production as it exposes the client
-		httpClient = &http.Client{Transport: tr}
-	} else {
-		// Use the default HTTP client with system CA verification
-		// This is the recommended approach for production use
-		// It ensures that the client verifies the server's TLS certificate against trusted CAs
-		// This prevents
-		httpClient = http.DefaultClient
+	tokenURL := k.Config.TokenURL()
+	// Build the HTTP client, skipping TLS verification only if Insecure is set
+	// Skipping verification is not recommended for production use, but useful for testing or self-signed certs
+	// The client traces requests when debug mode is enabled (see SetDebug)
+	// With ClientTLS the client also presents the client certificate (mutual TLS)
+	httpClient, err := NewMTLSHTTPClient("keycloak", k.Insecure, k.Config.ClientTLS)
+	if err != nil {
+		return nil, err
 	}
-	// If scopes are not provided, default to "openid"
-	scopes := k.Config.KeycloakClientScopes
-	if scopes == nil || len(scopes) == 0 || (len(scopes) > 0 && scopes[0] == "") {
-		scopes = []string{"openid"}
+	httpClient = withAffinity(httpClient, k.Affinity)
+	// Scopes are layered: DefaultScopes, then the configured scopes, then per-call additions
+	scopes := k.Config.EffectiveScopes(ctx)
+	// Build the grant specific parameters (credentials, subject token, assertion, ...)
+	params, err := k.Config.grantParams(tokenURL)
+	if err != nil {
+		return nil, err
 	}
 	// Create OAuth2 client credentials config
+	// The grant_type is overridden through EndpointParams for the other grants,
+	// so every grant shares the same request and error handling path
 	conf := &clientcredentials.Config{
-		ClientID:     k.Config.KeycloakClientID,
-		ClientSecret: k.Config.KeycloakClientSecret,
-		TokenURL:     tokenURL,
-		Scopes:       scopes,
+		ClientID:       k.Config.KeycloakClientID,
+		ClientSecret:   k.Config.KeycloakClientSecret,
+		TokenURL:       tokenURL,
+		Scopes:         scopes,
+		EndpointParams: params,
 	}
 	// Set the HTTP client to use the custom or default client
 	// This allows the OAuth2 library to use the configured HTTP client
@@ -108,6 +200,63 @@ func (k *KeycloakTokenProvider) FetchToken(ctx context.Context) (string, error)
 	// This is important for handling TLS verification and other HTTP settings
 	// This allows the OAuth2 library to use the configured HTTP client
 	ctx = context.WithValue(ctx, oauth2.HTTPClient, httpClient)
+	k.OnEvent.emit(Event{Type: EventTokenRequest, Provider: provider, Scopes: scopes})
+	start := time.Now()
+	// With refresh renewal the refresh token of the previous response replaces the configured grant
+	refreshToken := k.refreshToken(ctx, scopes)
+	if refreshToken != "" {
+		conf.EndpointParams = k.Config.refreshParams(refreshToken)
+	}
+	set, err := k.request(ctx, conf, kind)
+	if refreshToken != "" && isRejectedRefresh(err) {
+		// The refresh token expired, was revoked or no longer covers the scopes, start over with the configured grant
+		k.forgetRefreshToken(ctx, scopes)
+		conf.EndpointParams = params
+		set, err = k.request(ctx, conf, kind)
+	}
+	if reduced, ok := k.Config.reducedScopes(scopes, err); ok {
+		// Keep running on the scopes known to work while the realm's scope mapping is migrated
+		k.OnEvent.emit(Event{Type: EventScopeReduced, Provider: provider, Scopes: reduced, Err: err})
+		retry := *conf
+		retry.Scopes = reduced
+		scopes = reduced
+		set, err = k.request(ctx, &retry, kind)
+	}
+	if err != nil {
+		k.OnEvent.emit(Event{Type: EventTokenFailed, Provider: provider, Scopes: scopes, Duration: time.Since(start), Err: err})
+		return nil, err
+	}
+	k.OnEvent.emit(Event{Type: EventTokenFetched, Provider: provider, Scopes: scopes, Duration: time.Since(start)})
+	return set, nil
+}
+
+// request obtains the tokens with the configured grant, exchanging them for Audience when requested
+func (k *KeycloakTokenProvider) request(ctx context.Context, conf *clientcredentials.Config, kind TokenKind) (*TokenSet, error) {
+	var token *oauth2.Token
+	var err error
+	if k.Config.Audience != "" && k.Config.AudienceMode == AudienceExchange {
+		token, err = k.exchangeAudience(ctx, conf)
+	} else {
+		token, err = k.requestToken(ctx, conf)
+	}
+	if err != nil {
+		return nil, err
+	}
+	set := tokenSetFrom(token, time.Now())
+	if kind == TokenKindID && set.IDToken == "" {
+		// Check if id_token is present and valid
+		// This indicates that the Keycloak token response did not include an id_token
+		return nil, errors.New("failed to extract id_token from Keycloak token response")
+	}
+	return set, nil
+}
+
+// requestToken sends the token request described by conf
+func (k *KeycloakTokenProvider) requestToken(ctx context.Context, conf *clientcredentials.Config) (*oauth2.Token, error) {
+	conf, err := k.Config.ClientAuthMethod.apply(conf, k.Config.ClientAssertionSigner, k.Config.ClientAssertionLifetime, k.Config.ClientTLS)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign Keycloak client assertion: %w", err)
+	}
 	// Create an OAuth2 token source using the client credentials config
 	token, err := conf.Token(ctx)
 	if err != nil {
@@ -116,30 +265,71 @@ func (k *KeycloakTokenProvider) FetchToken(ctx context.Context) (string, error)
 		// The error is wrapped with additional context for better debugging
 		// This provides more context about the error, making it easier to debug
 		// the issue if it occurs
-		return "", fmt.Errorf("failed to get token from Keycloak: %w", err)
+		// Error responses become a *TokenError carrying status, oauth error and Retry-After
+		// A request abandoned by the caller becomes a *CanceledError instead
+		err = canceledByCaller(ctx, "keycloak", asTokenError("keycloak", err))
+		// A 404 means the token URL is wrong, name the realm URL instead of leaving a bare status
+		var tErr *TokenError
+		if errors.As(err, &tErr) && tErr.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("failed to get token from Keycloak: %w (token endpoint %s not found: check the realm name in KeycloakRealmURL and the /auth prefix, which only Keycloak < 17 uses)", err, conf.TokenURL)
+		}
+		return nil, fmt.Errorf("failed to get token from Keycloak: %w", err)
+	}
+	ReportExpiry(ctx, token.Expiry)
+	// The refresh token of an audience exchange belongs to the exchanged token and is not kept
+	if conf.EndpointParams.Get("grant_type") != string(GrantTokenExchange) || k.Config.grantType() == GrantTokenExchange {
+		k.rememberRefreshToken(ctx, conf.Scopes, token)
 	}
+	return token, nil
+}
 
-	// Extract the id_token from the OAuth2 token response
-	idToken, ok := token.Extra("id_token").(string)
-	if !ok || idToken == "" {
-		// Check if id_token is present and valid
-		// If id_token is not present or empty, return an error
-		// This indicates that the Keycloak token response did not include an id_token
-		return "", errors.New("failed to extract id_token from Keycloak token response")
+// exchangeAudience requests a token with the configured grant and exchanges its access token
+// for an id_token issued to Config.Audience (RFC 8693 audience parameter)
+func (k *KeycloakTokenProvider) exchangeAudience(ctx context.Context, conf *clientcredentials.Config) (*oauth2.Token, error) {
+	token, err := k.requestToken(ctx, conf)
+	if err != nil {
+		return nil, err
+	}
+	exchange := *conf
+	exchange.EndpointParams = url.Values{
+		"grant_type":           {string(GrantTokenExchange)},
+		"subject_token":        {token.AccessToken},
+		"subject_token_type":   {TokenTypeAccessToken},
+		"requested_token_type": {TokenTypeIDToken},
+		"audience":             {k.Config.Audience},
+	}
+	exchanged, err := k.requestToken(ctx, &exchange)
+	if err != nil {
+		return nil, fmt.Errorf("audience exchange for %q: %w", k.Config.Audience, err)
 	}
+	return exchanged, nil
+}
 
-	// Return the id_token as a string
-	// This is the final token that can be used for authentication
-	// It can be used to authenticate requests to protected resources
+// idTokenFrom extracts the id_token from a Keycloak token response, empty when there is none
+func idTokenFrom(token *oauth2.Token) string {
+	// Extract the id_token from the OAuth2 token response
+	idToken, _ := token.Extra("id_token").(string)
+	if idToken == "" && token.Extra("issued_token_type") == TokenTypeIDToken {
+		// Token exchange returns a requested id_token in the access_token field
+		idToken = token.AccessToken
+	}
 	// The id_token is a JSON Web Token (JWT) that contains user identity information
 	// The id_token is signed by Keycloak and can be verified by the client
-	return idToken, nil
+	return idToken
 }
 
 // NewTokenCache creates a new cache for a given provider
 // This cache will always return a valid token, refreshing it if needed
-func NewTokenCache(provider TokenProvider) *TokenCache {
-	return &TokenCache{provider: provider}
+// Options tune refresh behaviour, see CacheOption
+func NewTokenCache(provider TokenProvider, opts ...CacheOption) *TokenCache {
+	c := &TokenCache{provider: provider}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.store != nil {
+		c.seed(context.Background())
+	}
+	return c
 }
 
 // getJWTExpiry extracts the exp (expiry) field from a JWT token payload
@@ -182,7 +372,7 @@ func getJWTExpiry(token string) (int64, error) {
 	}
 	exp, ok := claims["exp"].(float64)
 	if !ok {
-		return 0, errors.New("exp not found in token")
+		return 0, ErrNoExpiry
 	}
 	return int64(exp), nil
 }
@@ -190,38 +380,182 @@ func getJWTExpiry(token string) (int64, error) {
 // GetValidToken returns a valid token from cache, or fetches a new one if expired or invalid
 // Thread-safe: uses mutex to protect concurrent access
 func (c *TokenCache) GetValidToken(ctx context.Context) (string, error) {
+	token, _, err := c.GetValidTokenExpiry(ctx)
+	return token, err
+}
+
+// GetValidTokenExpiry is GetValidToken also returning the expiry of the returned token
+// Use it instead of a separate Status call, which may already see the next token
+func (c *TokenCache) GetValidTokenExpiry(ctx context.Context) (string, time.Time, error) {
 	// Lock the cache to ensure thread-safe access
 	// This prevents multiple goroutines from accessing the cache simultaneously
 	c.mu.Lock()
-	defer c.mu.Unlock() // Ensure the lock is released after this function returns
-	// If token exists and not expired (with 1 minute buffer), reuse it
-	if c.token != "" && time.Now().Before(c.expiry.Add(-1*time.Minute)) {
+	defer c.unlock() // Ensure the lock is released after this function returns
+	token, err := c.validToken(ctx)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, c.expiry, nil
+}
+
+// validToken implements GetValidToken
+// The caller must hold c.mu
+func (c *TokenCache) validToken(ctx context.Context) (string, error) {
+	// If token exists and is not due for refresh (1 minute before expiry, the strict minimum when
+	// larger, or as decided by the refresh strategy), reuse it
+	if c.token != "" && time.Now().Before(c.refreshAt()) {
 		// If the token is still valid, return it
 		// This means the token is still valid and can be reused
 		// The expiry is checked with a 1 minute buffer to ensure the token is not close to expiring
+		// In short-lived mode the next token may be fetched in the background meanwhile
+		c.maybePrefetch(ctx)
 		return c.token, nil
 	}
+	// A running prefetch delivers the next token, wait for it instead of fetching twice
+	if c.prefetching != nil {
+		if err := c.awaitPrefetch(ctx); err != nil {
+			return "", err
+		}
+		if c.token != "" && time.Now().Before(c.refreshAt()) {
+			return c.token, nil
+		}
+	}
+	// The IdP just issued a token shorter lived than the strict minimum, asking again right away
+	// would get the same lifetime
+	if c.insufficient != nil && time.Now().Before(c.insufficientUntil) {
+		return "", c.insufficient
+	}
+	// Right after an IdP outage the refresh is delayed by the ramp to spread load
+	// A token that is inside the buffer but not yet expired (or above the strict minimum) is served meanwhile
+	if c.ramp != nil {
+		if c.token != "" && time.Now().Before(c.expiry.Add(-c.minRemaining)) && c.ramp.Delay() > 0 {
+			return c.token, nil
+		}
+		if err := c.ramp.Wait(ctx); err != nil {
+			return "", err
+		}
+	}
 	// Otherwise, fetch new token from provider
-	token, err := c.provider.FetchToken(ctx)
+	renewing := c.token != ""
+	token, err := c.refresh(ctx)
+	c.recordFetch(renewing, err)
+	if err == nil {
+		err = c.checkLifetime()
+	}
 	if err != nil {
-		// If there is an error fetching the token, return an error
-		// This could be due to network issues, invalid credentials, etc.
 		return "", err
 	}
+	return token, nil
+}
+
+// recordFetch remembers the outcome of a fetch for Status and Manager snapshots
+// The caller must hold c.mu
+// Requests canceled by the caller are only counted, the last outcome and the lifecycle are kept
+func (c *TokenCache) recordFetch(renewing bool, err error) {
+	if IsCanceled(err) {
+		c.usage.Canceled++
+		c.publishUsage()
+		return
+	}
+	c.lastRefresh = time.Now()
+	c.lastErr = err
+	c.usage.record(renewing, err, c.expiry.Sub(c.lastRefresh))
+	c.publishUsage()
+	c.publishStatus()
+	if c.lifecycle != nil {
+		// OnTransition runs user code, it is called by unlock once c.mu is released
+		c.lifecycleReports = append(c.lifecycleReports, err)
+	}
+}
+
+// unlock releases c.mu, then reports the fetch outcomes recorded meanwhile to the lifecycle
+func (c *TokenCache) unlock() {
+	reports := c.lifecycleReports
+	c.lifecycleReports = nil
+	c.mu.Unlock()
+	for _, err := range reports {
+		c.lifecycle.ReportFetch(err)
+	}
+}
 
-	// If token is successfully fetched, parse the expiry from the JWT
-	// The expiry is extracted from the token payload using the getJWTExpiry function
-	// This function decodes the JWT token and extracts the exp field
-	exp, err := getJWTExpiry(token)
+// refresh fetches a new token from the provider and stores it with its expiry
+// The caller must hold c.mu
+func (c *TokenCache) refresh(ctx context.Context) (string, error) {
+	f, err := c.fetch(ctx)
 	if err != nil {
 		return "", err
 	}
-
-	c.token = token
-	c.expiry = time.Unix(exp, 0)
+	c.install(ctx, f)
 	return c.token, nil
 }
 
+// fetchedToken is a checked token from the provider that is not stored yet
+type fetchedToken struct {
+	token    string
+	expiry   time.Time
+	issuedAt time.Time
+}
+
+// fetch requests a new token from the provider and determines its expiry without storing it
+// It does not need c.mu, the short-lived mode prefetches with it while the lock is free
+func (c *TokenCache) fetch(ctx context.Context) (f fetchedToken, err error) {
+	// A malformed token tripping a parser fails this fetch instead of crashing the refresh loop
+	defer Recover("fetch:"+providerKind(c.provider), &err)
+	fetchCtx, recorder := withExpiryRecorder(ctx)
+	token, err := c.provider.FetchToken(fetchCtx)
+	received := time.Now()
+	// Requests the caller gave up on say nothing about the IdP
+	err = canceledByCaller(ctx, providerKind(c.provider), err)
+	if c.ramp != nil && !IsCanceled(err) {
+		if err != nil {
+			c.ramp.ReportFailure()
+		} else {
+			c.ramp.ReportSuccess()
+		}
+	}
+	if err != nil {
+		// If there is an error fetching the token, return an error
+		// This could be due to network issues, invalid credentials, etc.
+		return fetchedToken{}, err
+	}
+
+	// Tokens that do not carry the required claims are never cached
+	if err := checkClaims(token, c.assertions); err != nil {
+		return fetchedToken{}, err
+	}
+
+	issuedAt := received
+	if claims, err := decodeJWTClaims(token); err == nil {
+		if iat := numericDate(claims["iat"]); !iat.IsZero() {
+			if c.clock != nil {
+				c.clock.ObserveIssuedAt(iat, received)
+			}
+			issuedAt = c.clock.ToLocal(iat)
+		}
+	}
+
+	// If token is successfully fetched, determine its expiry
+	// The exp claim of the JWT is preferred, then the expires_in reported by the provider
+	// (see ReportExpiry), then the default TTL (see WithDefaultTTL)
+	expiry, err := c.tokenExpiry(token, recorder.reported(), received)
+	if err != nil {
+		return fetchedToken{}, err
+	}
+	return fetchedToken{token: token, expiry: expiry, issuedAt: issuedAt}, nil
+}
+
+// install stores a fetched token, persists it and notifies watchers
+// The caller must hold c.mu
+func (c *TokenCache) install(ctx context.Context, f fetchedToken) {
+	c.token = f.token
+	c.expiry = f.expiry
+	c.issuedAt = f.issuedAt
+	c.publishStatus()
+	c.schedulePrefetch()
+	c.persist(ctx)
+	c.notify(TokenUpdate{Token: c.token, Expiry: c.expiry})
+}
+
 // ForceExpire sets the expiry to a specific time (for testing purposes)
 // This allows unit tests to simulate expired tokens
 func (c *TokenCache) ForceExpire(t time.Time) {
@@ -231,4 +565,6 @@ func (c *TokenCache) ForceExpire(t time.Time) {
 	defer c.mu.Unlock()
 	// Set the token to empty and expiry to the specified time
 	c.expiry = t
+	c.insufficient = nil
+	c.publishStatus()
 }
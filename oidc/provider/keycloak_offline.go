@@ -0,0 +1,134 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// OfflineAccessScope requests a Keycloak offline token, a refresh token that survives SSO session expiry
+const OfflineAccessScope = "offline_access"
+
+// refreshState holds the refresh tokens a KeycloakTokenProvider renews with, keyed by scope set:
+// a refresh token only renews the scopes of the grant it came from
+type refreshState struct {
+	mu     sync.Mutex
+	tokens map[string]string
+	loaded map[string]bool // RefreshStore has been read for the scope set
+}
+
+// renewsWithRefreshToken reports whether tokens are renewed with the refresh_token grant
+func (c *ConfigKeyCloak) renewsWithRefreshToken() bool {
+	return c.OfflineAccess || c.RenewWithRefreshToken
+}
+
+// scopeSetKey identifies a set of scopes independent of their order
+func scopeSetKey(scopes []string) string {
+	sorted := MergeScopes(scopes)
+	sort.Strings(sorted)
+	return strings.Join(sorted, " ")
+}
+
+// refreshStoreKey returns the RefreshStore key of a scope set, by default derived from realm and client
+func (k *KeycloakTokenProvider) refreshStoreKey(scopeKey string) string {
+	key := k.RefreshStoreKey
+	if key == "" {
+		key = "keycloak-refresh:" + k.Config.KeycloakRealmURL + ":" + k.Config.KeycloakClientID
+	}
+	return key + ":" + scopeKey
+}
+
+// refreshToken returns the refresh token renewing scopes, loading it from RefreshStore on first use
+func (k *KeycloakTokenProvider) refreshToken(ctx context.Context, scopes []string) string {
+	if !k.Config.renewsWithRefreshToken() {
+		return ""
+	}
+	scopeKey := scopeSetKey(scopes)
+	k.refresh.mu.Lock()
+	defer k.refresh.mu.Unlock()
+	if !k.refresh.loaded[scopeKey] && k.RefreshStore != nil {
+		k.refresh.setLoaded(scopeKey)
+		stored, err := k.RefreshStore.Load(ctx, k.refreshStoreKey(scopeKey))
+		if err == nil && stored.Token != "" && (stored.Expiry.IsZero() || time.Now().Before(stored.Expiry)) {
+			k.refresh.set(scopeKey, stored.Token)
+		}
+	}
+	return k.refresh.tokens[scopeKey]
+}
+
+// rememberRefreshToken keeps the refresh token of a response for scopes, Keycloak may rotate it on every use
+func (k *KeycloakTokenProvider) rememberRefreshToken(ctx context.Context, scopes []string, token *oauth2.Token) {
+	if !k.Config.renewsWithRefreshToken() || token.RefreshToken == "" {
+		return
+	}
+	var expiry time.Time
+	// refresh_expires_in is 0 for offline tokens, which do not expire while they are used
+	if secs, ok := token.Extra("refresh_expires_in").(float64); ok && secs > 0 {
+		expiry = time.Now().Add(time.Duration(secs) * time.Second)
+	}
+	scopeKey := scopeSetKey(scopes)
+	k.refresh.mu.Lock()
+	defer k.refresh.mu.Unlock()
+	k.refresh.set(scopeKey, token.RefreshToken)
+	k.refresh.setLoaded(scopeKey)
+	if k.RefreshStore != nil {
+		// Failing to persist only costs a full grant after the next restart
+		_ = k.RefreshStore.Save(context.WithoutCancel(ctx), k.refreshStoreKey(scopeKey), StoredToken{
+			Token:     token.RefreshToken,
+			TokenType: "refresh_token",
+			Expiry:    expiry,
+		})
+	}
+}
+
+// forgetRefreshToken drops a refresh token of scopes Keycloak rejected, so the configured grant is used again
+func (k *KeycloakTokenProvider) forgetRefreshToken(ctx context.Context, scopes []string) {
+	scopeKey := scopeSetKey(scopes)
+	k.refresh.mu.Lock()
+	defer k.refresh.mu.Unlock()
+	delete(k.refresh.tokens, scopeKey)
+	if k.RefreshStore != nil {
+		_ = k.RefreshStore.Save(context.WithoutCancel(ctx), k.refreshStoreKey(scopeKey), StoredToken{})
+	}
+}
+
+// set stores the refresh token of a scope set, the caller must hold s.mu
+func (s *refreshState) set(scopeKey, token string) {
+	if s.tokens == nil {
+		s.tokens = make(map[string]string)
+	}
+	s.tokens[scopeKey] = token
+}
+
+// setLoaded marks the RefreshStore entry of a scope set as read, the caller must hold s.mu
+func (s *refreshState) setLoaded(scopeKey string) {
+	if s.loaded == nil {
+		s.loaded = make(map[string]bool)
+	}
+	s.loaded[scopeKey] = true
+}
+
+// refreshParams returns the form parameters renewing with refreshToken
+func (c *ConfigKeyCloak) refreshParams(refreshToken string) url.Values {
+	v := url.Values{
+		"grant_type":    {string(GrantRefreshToken)},
+		"refresh_token": {refreshToken},
+	}
+	if c.Audience != "" && c.AudienceMode == AudienceParam {
+		v.Set("audience", c.Audience)
+	}
+	return v
+}
+
+// isRejectedRefresh reports whether err rejects a refresh token: invalid_grant for an expired or revoked
+// one, invalid_scope when the scopes it was issued for no longer match, e.g. after a realm change
+func isRejectedRefresh(err error) bool {
+	var tErr *TokenError
+	return errors.As(err, &tErr) && (tErr.Code == "invalid_grant" || tErr.Code == "invalid_scope")
+}
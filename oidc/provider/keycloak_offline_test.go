@@ -0,0 +1,100 @@
+package oidc_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	oidc "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeycloakOfflineToken(t *testing.T) {
+	ctx := context.Background()
+	var mu sync.Mutex
+	var grants []string
+	issued, revoked, reportsRemoved := 0, false, false
+	active := map[string]bool{}
+	realm := newFakeKeycloak(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		grant := r.PostForm.Get("grant_type")
+		grants = append(grants, grant)
+		switch grant {
+		case "client_credentials":
+			require.Contains(t, strings.Fields(r.PostForm.Get("scope")), "offline_access")
+		case "refresh_token":
+			refreshToken := r.PostForm.Get("refresh_token")
+			if revoked || !active[refreshToken] {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`{"error":"invalid_grant","error_description":"Offline session not active"}`))
+				return
+			}
+			if reportsRemoved && strings.Contains(r.PostForm.Get("scope"), "reports") {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`{"error":"invalid_scope","error_description":"Invalid scopes: reports"}`))
+				return
+			}
+			delete(active, refreshToken)
+		}
+		// Keycloak rotates the refresh token on every response
+		issued++
+		active[fmt.Sprintf("offline-%d", issued)] = true
+		writeTokenResponse(w, map[string]interface{}{
+			"access_token": "at", "id_token": validJWT(t), "expires_in": 300,
+			"refresh_token": fmt.Sprintf("offline-%d", issued), "refresh_expires_in": 0,
+		})
+	})
+	store, err := oidc.NewFileCacheStore(t.TempDir())
+	require.NoError(t, err)
+	newProvider := func() *oidc.KeycloakTokenProvider {
+		return &oidc.KeycloakTokenProvider{
+			Config: &oidc.ConfigKeyCloak{
+				KeycloakRealmURL: realm, KeycloakClientID: "svc", KeycloakClientSecret: "secret", OfflineAccess: true,
+			},
+			RefreshStore: store,
+		}
+	}
+
+	p := newProvider()
+	for i := 0; i < 3; i++ {
+		_, err := p.FetchToken(ctx)
+		require.NoError(t, err)
+	}
+	require.Equal(t, []string{"client_credentials", "refresh_token", "refresh_token"}, grants)
+
+	// A new process renews with the persisted offline token
+	grants = nil
+	_, err = newProvider().FetchToken(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"refresh_token"}, grants)
+
+	// A revoked offline session falls back to client credentials
+	grants, revoked = nil, true
+	_, err = newProvider().FetchToken(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "refresh_token", grants[0])
+	require.Equal(t, "client_credentials", grants[len(grants)-1])
+
+	// Each scope set renews with the offline token issued for it
+	grants, revoked = nil, false
+	p = newProvider()
+	reports := oidc.ContextWithScopes(ctx, "reports")
+	for _, c := range []context.Context{reports, ctx, reports} {
+		_, err := p.FetchToken(c)
+		require.NoError(t, err)
+	}
+	require.Equal(t, []string{"client_credentials", "refresh_token", "refresh_token"}, grants)
+
+	// A refresh token whose scopes Keycloak no longer grants is dropped like a revoked one
+	grants, reportsRemoved = nil, true
+	_, err = p.FetchToken(reports)
+	require.NoError(t, err)
+	require.Equal(t, "refresh_token", grants[0])
+	require.Equal(t, "client_credentials", grants[len(grants)-1])
+}
@@ -0,0 +1,102 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// TokenSet holds every token of a token response
+type TokenSet struct {
+	AccessToken   string
+	IDToken       string
+	RefreshToken  string
+	Expiry        time.Time // access token expiry
+	RefreshExpiry time.Time // zero when the IdP did not report one, e.g. for offline tokens
+}
+
+// tokenSetFrom builds a TokenSet from a token response
+func tokenSetFrom(token *oauth2.Token, received time.Time) *TokenSet {
+	set := &TokenSet{
+		AccessToken:  token.AccessToken,
+		RefreshToken: token.RefreshToken,
+		Expiry:       token.Expiry,
+	}
+	set.IDToken = idTokenFrom(token)
+	// Keycloak reports the refresh token lifetime in refresh_expires_in, 0 means it does not expire
+	if secs, ok := token.Extra("refresh_expires_in").(float64); ok && secs > 0 {
+		set.RefreshExpiry = received.Add(time.Duration(secs) * time.Second)
+	}
+	return set
+}
+
+// KeycloakPasswordProvider implements TokenProvider with the resource owner password credentials grant,
+// for legacy internal services that cannot use client credentials
+// It is a KeycloakTokenProvider with GrantType GrantPassword and RenewWithRefreshToken set: the refresh
+// token of the last response is used for the next fetch, the password is only sent again when Keycloak
+// rejects it
+// Config needs Username and Password, KeycloakClientSecret is optional for public clients; GrantType is ignored
+// Config and the other fields are read on first use
+type KeycloakPasswordProvider struct {
+	Config   *ConfigKeyCloak
+	Token    TokenKind // token returned by FetchToken, default TokenKindAccess
+	Insecure bool
+	OnEvent  EventHandler     // optional, receives request, fetched and failed events
+	Affinity *SessionAffinity // optional, see KeycloakTokenProvider.Affinity
+
+	once     sync.Once
+	provider *KeycloakTokenProvider
+}
+
+// Kind returns the provider kind reported in snapshots
+func (k *KeycloakPasswordProvider) Kind() string {
+	return "keycloak-password"
+}
+
+// Capabilities reports what the provider supports
+func (k *KeycloakPasswordProvider) Capabilities() Capabilities {
+	caps := tokenKindCapabilities(k.Token)
+	caps.RefreshToken = true
+	caps.Introspection = true
+	caps.Revocation = true
+	caps.TokenExchange = true
+	return caps
+}
+
+// FetchToken returns the selected token of a fresh token response
+func (k *KeycloakPasswordProvider) FetchToken(ctx context.Context) (string, error) {
+	set, err := k.Tokens(ctx)
+	if err != nil {
+		return "", err
+	}
+	if k.Token == TokenKindID {
+		return set.IDToken, nil
+	}
+	return set.AccessToken, nil
+}
+
+// Tokens requests new tokens and returns the id, access and refresh token of the response
+func (k *KeycloakPasswordProvider) Tokens(ctx context.Context) (*TokenSet, error) {
+	p, err := k.tokenProvider()
+	if err != nil {
+		return nil, err
+	}
+	return p.tokens(ctx, "keycloak-password", k.Token)
+}
+
+// tokenProvider returns the KeycloakTokenProvider the requests go through, built on first use
+func (k *KeycloakPasswordProvider) tokenProvider() (*KeycloakTokenProvider, error) {
+	if k.Config == nil {
+		return nil, errors.New("Keycloak configuration is nil")
+	}
+	k.once.Do(func() {
+		cfg := *k.Config
+		cfg.GrantType = GrantPassword
+		cfg.RenewWithRefreshToken = true
+		k.provider = &KeycloakTokenProvider{Config: &cfg, Insecure: k.Insecure, OnEvent: k.OnEvent, Affinity: k.Affinity}
+	})
+	return k.provider, nil
+}
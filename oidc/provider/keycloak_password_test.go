@@ -0,0 +1,95 @@
+package oidc_test
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+
+	oidc "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeycloakPasswordProvider(t *testing.T) {
+	ctx := context.Background()
+	access, id := validJWT(t), validJWT(t)+"id"
+	var mu sync.Mutex
+	var grants, nodes []string
+	revoked := false
+	realm := newFakeKeycloak(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		grant := r.PostForm.Get("grant_type")
+		grants = append(grants, grant)
+		nodes = append(nodes, r.Header.Get("X-Keycloak-Node"))
+		w.Header().Set("Content-Type", "application/json")
+		switch grant {
+		case "password":
+			if r.PostForm.Get("username") != "legacy" || r.PostForm.Get("password") != "s3cret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				_, _ = w.Write([]byte(`{"error":"invalid_grant","error_description":"Invalid user credentials"}`))
+				return
+			}
+		case "refresh_token":
+			if revoked || r.PostForm.Get("refresh_token") != "refresh-1" {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`{"error":"invalid_grant","error_description":"Session not active"}`))
+				return
+			}
+		}
+		writeTokenResponse(w, map[string]interface{}{
+			"access_token": access, "id_token": id, "refresh_token": "refresh-1",
+			"expires_in": 300, "refresh_expires_in": 1800,
+		})
+	})
+	cfg := &oidc.ConfigKeyCloak{KeycloakRealmURL: realm, KeycloakClientID: "legacy-app", Username: "legacy", Password: "s3cret"}
+
+	t.Run("password then refresh", func(t *testing.T) {
+		grants = nil
+		p := &oidc.KeycloakPasswordProvider{Config: cfg}
+		set, err := p.Tokens(ctx)
+		require.NoError(t, err)
+		require.Equal(t, access, set.AccessToken)
+		require.Equal(t, id, set.IDToken)
+		require.Equal(t, "refresh-1", set.RefreshToken)
+		require.False(t, set.RefreshExpiry.IsZero())
+
+		got, err := p.FetchToken(ctx)
+		require.NoError(t, err)
+		require.Equal(t, access, got)
+
+		// A revoked session falls back to the password
+		revoked = true
+		p.Token = oidc.TokenKindID
+		got, err = p.FetchToken(ctx)
+		require.NoError(t, err)
+		require.Equal(t, id, got)
+		// Rejected requests may be sent twice while the client auth style is detected
+		require.Equal(t, "password", grants[0])
+		require.Equal(t, "refresh_token", grants[1])
+		require.Equal(t, "password", grants[len(grants)-1])
+	})
+
+	t.Run("invalid credentials", func(t *testing.T) {
+		bad := *cfg
+		bad.Password = "wrong"
+		_, err := (&oidc.KeycloakPasswordProvider{Config: &bad}).FetchToken(ctx)
+		var tErr *oidc.TokenError
+		require.ErrorAs(t, err, &tErr)
+		require.Equal(t, "invalid_grant", tErr.Code)
+
+		_, err = (&oidc.KeycloakPasswordProvider{Config: &oidc.ConfigKeyCloak{KeycloakRealmURL: realm, KeycloakClientID: "x"}}).FetchToken(ctx)
+		require.ErrorContains(t, err, "Username and Password")
+	})
+	t.Run("affinity", func(t *testing.T) {
+		nodes = nil
+		p := &oidc.KeycloakPasswordProvider{Config: cfg, Affinity: &oidc.SessionAffinity{
+			Headers: http.Header{"X-Keycloak-Node": {"kc-1"}},
+		}}
+		_, err := p.Tokens(ctx)
+		require.NoError(t, err)
+		require.NotEmpty(t, nodes)
+		require.Equal(t, "kc-1", nodes[0])
+	})
+}
@@ -0,0 +1,78 @@
+package oidc
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// keyedCaches holds one TokenCache per key (a realm, an audience, ...), created on first use and
+// registered with a Manager under the key, for the multi-credential helpers of this package
+// A WithStore option is applied per key: the key is appended to the store key, so the caches
+// never read or overwrite each other's persisted token
+type keyedCaches struct {
+	opts    []CacheOption
+	manager *Manager
+	// limit caps the number of caches when positive, get answers limitErr for further keys
+	limit    int
+	limitErr error
+
+	mu     sync.Mutex
+	caches map[string]*TokenCache
+}
+
+func newKeyedCaches(opts []CacheOption) *keyedCaches {
+	return &keyedCaches{opts: opts, manager: NewManager(), caches: map[string]*TokenCache{}}
+}
+
+// get returns the cache of key, building it with create on first use
+// create returns the provider and the audience reported in snapshots, it runs under k.mu
+func (k *keyedCaches) get(key string, create func() (TokenProvider, string, error)) (*TokenCache, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if cache, ok := k.caches[key]; ok {
+		return cache, nil
+	}
+	if k.limit > 0 && len(k.caches) >= k.limit {
+		return nil, fmt.Errorf("%w: %d in use", k.limitErr, k.limit)
+	}
+	provider, audience, err := create()
+	if err != nil {
+		return nil, err
+	}
+	opts := append(append([]CacheOption(nil), k.opts...), func(c *TokenCache) {
+		if c.store != nil {
+			c.storeKey += ":" + key
+		}
+	})
+	cache := NewTokenCache(provider, opts...)
+	if err := k.manager.Add(ManagedCredential{Name: key, Audience: audience, Cache: cache}); err != nil {
+		return nil, err
+	}
+	k.caches[key] = cache
+	return cache, nil
+}
+
+// remove drops the cache of key, if any, and closes it so its watches stop
+func (k *keyedCaches) remove(key string) {
+	k.mu.Lock()
+	cache, ok := k.caches[key]
+	delete(k.caches, key)
+	k.manager.Remove(key)
+	k.mu.Unlock()
+	if ok {
+		_ = cache.Close()
+	}
+}
+
+// keys returns the keys of the caches created so far, sorted
+func (k *keyedCaches) keys() []string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	keys := make([]string, 0, len(k.caches))
+	for key := range k.caches {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
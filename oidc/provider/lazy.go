@@ -0,0 +1,77 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// LazyProvider defers building a provider (discovery, key loading, ...) until its first use
+// Initialization runs at most once on success, concurrent callers wait for the same attempt.
+// A failed initialization is cached for ErrorTTL so a broken tenant does not hammer its IdP,
+// and is retried on the first call after the TTL expires. Context cancellation and deadline errors
+// are never cached.
+type LazyProvider struct {
+	Init     func(ctx context.Context) (TokenProvider, error)
+	ErrorTTL time.Duration // how long an init error is returned before retrying, default 30s
+
+	mu       sync.Mutex
+	provider TokenProvider
+	err      error
+	errAt    time.Time
+}
+
+// NewLazyProvider creates a provider that calls init on first use
+func NewLazyProvider(init func(ctx context.Context) (TokenProvider, error), errorTTL time.Duration) *LazyProvider {
+	return &LazyProvider{Init: init, ErrorTTL: errorTTL}
+}
+
+// Provider returns the initialized provider, running Init if needed
+func (l *LazyProvider) Provider(ctx context.Context) (TokenProvider, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.provider != nil {
+		return l.provider, nil
+	}
+	ttl := l.ErrorTTL
+	if ttl <= 0 {
+		ttl = 30 * time.Second
+	}
+	if l.err != nil && time.Since(l.errAt) < ttl {
+		return nil, l.err
+	}
+	if l.Init == nil {
+		return nil, errors.New("lazy provider has no Init function")
+	}
+	provider, err := l.Init(ctx)
+	if err == nil && provider == nil {
+		err = errors.New("lazy provider Init returned a nil provider")
+	}
+	if err != nil {
+		// An init ended by the caller's context says nothing about the IdP, other callers retry it
+		if ctx.Err() == nil && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
+			l.err = err
+			l.errAt = time.Now()
+		}
+		return nil, err
+	}
+	l.provider, l.err = provider, nil
+	return provider, nil
+}
+
+// Initialized reports whether the underlying provider has been built successfully
+func (l *LazyProvider) Initialized() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.provider != nil
+}
+
+// FetchToken initializes the provider if needed and fetches a token from it
+func (l *LazyProvider) FetchToken(ctx context.Context) (string, error) {
+	provider, err := l.Provider(ctx)
+	if err != nil {
+		return "", err
+	}
+	return provider.FetchToken(ctx)
+}
@@ -0,0 +1,81 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	oidc "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLazyProvider(t *testing.T) {
+	t.Run("initializes once under concurrency", func(t *testing.T) {
+		var inits atomic.Int32
+		lazy := oidc.NewLazyProvider(func(ctx context.Context) (oidc.TokenProvider, error) {
+			inits.Add(1)
+			time.Sleep(10 * time.Millisecond)
+			return &stubProvider{token: "tok"}, nil
+		}, time.Minute)
+		require.False(t, lazy.Initialized())
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				token, err := lazy.FetchToken(context.Background())
+				require.NoError(t, err)
+				require.Equal(t, "tok", token)
+			}()
+		}
+		wg.Wait()
+		require.Equal(t, int32(1), inits.Load())
+		require.True(t, lazy.Initialized())
+	})
+
+	t.Run("caches init error for ttl", func(t *testing.T) {
+		var inits atomic.Int32
+		lazy := oidc.NewLazyProvider(func(ctx context.Context) (oidc.TokenProvider, error) {
+			if inits.Add(1) == 1 {
+				return nil, errors.New("discovery failed")
+			}
+			return &stubProvider{token: "tok"}, nil
+		}, 20*time.Millisecond)
+
+		_, err := lazy.FetchToken(context.Background())
+		require.ErrorContains(t, err, "discovery failed")
+		_, err = lazy.FetchToken(context.Background())
+		require.ErrorContains(t, err, "discovery failed")
+		require.Equal(t, int32(1), inits.Load())
+
+		time.Sleep(30 * time.Millisecond)
+		token, err := lazy.FetchToken(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, "tok", token)
+		require.Equal(t, int32(2), inits.Load())
+	})
+	t.Run("does not cache context errors", func(t *testing.T) {
+		var inits atomic.Int32
+		lazy := oidc.NewLazyProvider(func(ctx context.Context) (oidc.TokenProvider, error) {
+			inits.Add(1)
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			return &stubProvider{token: "tok"}, nil
+		}, time.Minute)
+
+		canceled, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := lazy.FetchToken(canceled)
+		require.ErrorIs(t, err, context.Canceled)
+		token, err := lazy.FetchToken(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, "tok", token)
+		require.Equal(t, int32(2), inits.Load())
+	})
+}
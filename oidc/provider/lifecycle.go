@@ -0,0 +1,183 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// LifecycleState is the coarse state of a provider, for readiness probes and dashboards
+type LifecycleState string
+
+const (
+	// StateInitializing means no token was obtained yet
+	StateInitializing LifecycleState = "initializing"
+	// StateHealthy means the last fetch succeeded and the IdP passes its health checks
+	StateHealthy LifecycleState = "healthy"
+	// StateDegraded means fetches or health checks started failing, cached tokens are still served
+	StateDegraded LifecycleState = "degraded"
+	// StateFailed means FailureThreshold fetches in a row failed; only a successful fetch recovers
+	StateFailed LifecycleState = "failed"
+)
+
+// StateTransition describes a lifecycle state change
+type StateTransition struct {
+	Name string
+	From LifecycleState
+	To   LifecycleState
+	Time time.Time
+	Err  error // the failure causing the transition, nil when recovering
+}
+
+// LifecycleStatus is a snapshot of a Lifecycle
+type LifecycleStatus struct {
+	Name                string         `json:"name"`
+	State               LifecycleState `json:"state"`
+	Since               time.Time      `json:"since"`
+	LastError           string         `json:"last_error,omitempty"`
+	ConsecutiveFailures int            `json:"consecutive_failures"`
+}
+
+// Lifecycle is the state machine of one provider, driven by fetch outcomes (see WithLifecycle)
+// and health checks (see HealthProber.Track)
+//
+//	initializing --fetch ok--> healthy --fetch/health failure--> degraded --FailureThreshold fetch failures--> failed
+//	any state --fetch ok--> healthy, degraded --health ok (no fetch failures)--> healthy
+type Lifecycle struct {
+	Name             string
+	FailureThreshold int                   // consecutive fetch failures before failed, default 3
+	OnTransition     func(StateTransition) // optional, called on every state change without cache or lifecycle locks held
+
+	mu       sync.Mutex
+	state    LifecycleState
+	since    time.Time
+	failures int
+	lastErr  error
+}
+
+// NewLifecycle creates a lifecycle in StateInitializing
+func NewLifecycle(name string, onTransition func(StateTransition)) *Lifecycle {
+	return &Lifecycle{Name: name, OnTransition: onTransition, state: StateInitializing, since: time.Now()}
+}
+
+// State returns the current state
+func (l *Lifecycle) State() LifecycleState {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.current()
+}
+
+// current returns the state, the zero Lifecycle starts initializing; the caller must hold l.mu
+func (l *Lifecycle) current() LifecycleState {
+	if l.state == "" {
+		return StateInitializing
+	}
+	return l.state
+}
+
+// Ready reports whether the provider can serve tokens (healthy or degraded)
+func (l *Lifecycle) Ready() bool {
+	st := l.State()
+	return st == StateHealthy || st == StateDegraded
+}
+
+// Status returns a snapshot of the lifecycle
+func (l *Lifecycle) Status() LifecycleStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	st := LifecycleStatus{Name: l.Name, State: l.current(), Since: l.since, ConsecutiveFailures: l.failures}
+	if l.lastErr != nil {
+		st.LastError = l.lastErr.Error()
+	}
+	return st
+}
+
+// ReportFetch feeds a token fetch outcome into the state machine
+// Fetches cancelled by their caller say nothing about the provider and are ignored
+func (l *Lifecycle) ReportFetch(err error) {
+	if errors.Is(err, context.Canceled) || IsCanceled(err) {
+		return
+	}
+	l.mu.Lock()
+	from := l.current()
+	to := from
+	if err == nil {
+		l.failures, l.lastErr = 0, nil
+		to = StateHealthy
+	} else {
+		l.failures++
+		l.lastErr = err
+		threshold := l.FailureThreshold
+		if threshold <= 0 {
+			threshold = 3
+		}
+		switch {
+		case l.failures >= threshold:
+			to = StateFailed
+		case from == StateHealthy:
+			to = StateDegraded
+		}
+	}
+	l.transitionLocked(from, to, err)
+}
+
+// ReportHealth feeds a health check outcome into the state machine
+// Health checks only move between healthy and degraded, they never fail or recover a provider alone
+func (l *Lifecycle) ReportHealth(err error) {
+	l.mu.Lock()
+	from := l.current()
+	to := from
+	switch {
+	case err != nil && from == StateHealthy:
+		l.lastErr = err
+		to = StateDegraded
+	case err == nil && from == StateDegraded && l.failures == 0:
+		l.lastErr = nil
+		to = StateHealthy
+	}
+	l.transitionLocked(from, to, err)
+}
+
+// transitionLocked applies a state change and notifies OnTransition after unlocking l.mu
+func (l *Lifecycle) transitionLocked(from, to LifecycleState, err error) {
+	if from == to {
+		l.mu.Unlock()
+		return
+	}
+	l.state, l.since = to, time.Now()
+	ev := StateTransition{Name: l.Name, From: from, To: to, Time: l.since, Err: err}
+	handler := l.OnTransition
+	l.mu.Unlock()
+	if handler != nil {
+		handler(ev)
+	}
+}
+
+// WithLifecycle makes the cache report every fetch outcome to l
+func WithLifecycle(l *Lifecycle) CacheOption {
+	return func(c *TokenCache) {
+		c.lifecycle = l
+	}
+}
+
+// ReadinessHandler serves the status of every lifecycle as JSON,
+// with 200 OK when all of them are ready and 503 Service Unavailable otherwise
+func ReadinessHandler(lifecycles ...*Lifecycle) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		statuses := make([]LifecycleStatus, 0, len(lifecycles))
+		code := http.StatusOK
+		for _, l := range lifecycles {
+			st := l.Status()
+			if st.State != StateHealthy && st.State != StateDegraded {
+				code = http.StatusServiceUnavailable
+			}
+			statuses = append(statuses, st)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		_ = json.NewEncoder(w).Encode(statuses)
+	})
+}
@@ -0,0 +1,121 @@
+package oidc_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	oidc "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLifecycle(t *testing.T) {
+	var transitions []string
+	l := oidc.NewLifecycle("orders", func(ev oidc.StateTransition) {
+		transitions = append(transitions, string(ev.From)+">"+string(ev.To))
+	})
+	l.FailureThreshold = 2
+	p := &stubProvider{token: validJWT(t)}
+	cache := oidc.NewTokenCache(p, oidc.WithLifecycle(l))
+	ctx := context.Background()
+	require.Equal(t, oidc.StateInitializing, l.State())
+	require.False(t, l.Ready())
+
+	_, err := cache.GetValidToken(ctx)
+	require.NoError(t, err)
+	require.Equal(t, oidc.StateHealthy, l.State())
+
+	// Health checks move between healthy and degraded
+	l.ReportHealth(errors.New("keycloak down"))
+	require.Equal(t, oidc.StateDegraded, l.State())
+	require.True(t, l.Ready())
+	l.ReportHealth(nil)
+	require.Equal(t, oidc.StateHealthy, l.State())
+
+	// Fetch failures degrade, then fail the provider
+	p.token, p.err = "", errors.New("idp down")
+	for i := 0; i < 2; i++ {
+		cache.ForceExpire(time.Now())
+		_, err = cache.GetValidToken(ctx)
+		require.Error(t, err)
+	}
+	require.Equal(t, oidc.StateFailed, l.State())
+	st := l.Status()
+	require.Equal(t, 2, st.ConsecutiveFailures)
+	require.Equal(t, "idp down", st.LastError)
+
+	// A passing health check does not recover a failed provider, a fetch does
+	l.ReportHealth(nil)
+	require.Equal(t, oidc.StateFailed, l.State())
+	p.token, p.err = validJWT(t), nil
+	_, err = cache.GetValidToken(ctx)
+	require.NoError(t, err)
+
+	require.Equal(t, []string{
+		"initializing>healthy",
+		"healthy>degraded",
+		"degraded>healthy",
+		"healthy>degraded",
+		"degraded>failed",
+		"failed>healthy",
+	}, transitions)
+}
+
+func TestLifecycleTransitionOutsideCacheLock(t *testing.T) {
+	var cache *oidc.TokenCache
+	var status oidc.CacheStatus
+	l := oidc.NewLifecycle("orders", func(ev oidc.StateTransition) {
+		// The handler may use the cache, it runs after the cache lock was released
+		_, _ = cache.GetValidToken(context.Background())
+		status = cache.Status()
+	})
+	cache = oidc.NewTokenCache(&stubProvider{token: validJWT(t)}, oidc.WithLifecycle(l))
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := cache.GetValidToken(context.Background())
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnTransition deadlocked on the cache lock")
+	}
+	require.True(t, status.HasToken)
+	require.Equal(t, oidc.StateHealthy, l.State())
+}
+
+func TestHealthProberTrack(t *testing.T) {
+	l := oidc.NewLifecycle("kc", nil)
+	l.ReportFetch(nil)
+	prober := oidc.NewHealthProber(time.Hour)
+	prober.Add("kc", &checkedProvider{healthErr: errors.New("down")})
+	prober.Track("kc", l)
+	prober.ProbeNow(context.Background())
+	require.Equal(t, oidc.StateDegraded, l.State())
+}
+
+func TestReadinessHandler(t *testing.T) {
+	ready := oidc.NewLifecycle("orders", nil)
+	ready.ReportFetch(nil)
+	starting := oidc.NewLifecycle("billing", nil)
+
+	rec := httptest.NewRecorder()
+	oidc.ReadinessHandler(ready, starting).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	var statuses []oidc.LifecycleStatus
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&statuses))
+	require.Len(t, statuses, 2)
+	require.Equal(t, oidc.StateInitializing, statuses[1].State)
+
+	starting.ReportFetch(nil)
+	rec = httptest.NewRecorder()
+	oidc.ReadinessHandler(ready, starting).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+}
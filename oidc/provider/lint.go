@@ -0,0 +1,97 @@
+package oidc
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+)
+
+// Lint warning codes, stable so tooling can filter or silence them
+const (
+	LintInsecureTLS          = "insecure-tls"
+	LintNoLeeway             = "no-leeway"
+	LintBroadScope           = "broad-scope"
+	LintMissingImpersonation = "missing-impersonation"
+)
+
+// LintWarning is an advisory finding about a configuration that works but is likely a mistake
+type LintWarning struct {
+	Code    string // e.g. LintInsecureTLS
+	Source  string // name of the source or component, empty for the whole configuration
+	Field   string // offending field, e.g. "Insecure"
+	Message string
+}
+
+func (w LintWarning) String() string {
+	prefix := w.Code
+	if w.Source != "" {
+		prefix = w.Source + ": " + prefix
+	}
+	if w.Field != "" {
+		prefix += " (" + w.Field + ")"
+	}
+	return prefix + ": " + w.Message
+}
+
+// broadScopes are scopes granting much more than a single service needs
+var broadScopes = map[string]bool{
+	"*":     true,
+	"all":   true,
+	"admin": true,
+	"https://www.googleapis.com/auth/cloud-platform": true,
+}
+
+// IsBroadScope reports whether scope grants more than a single service needs, e.g. "*" or cloud-platform
+func IsBroadScope(scope string) bool {
+	return broadScopes[strings.ToLower(strings.TrimSpace(scope))] || strings.Contains(scope, "*")
+}
+
+// Lint returns advisory warnings for cfg, sorted by source; unlike NewRegistry it never fails
+// The result is meant for a doctor command or start-up logs, see LogLint
+func Lint(cfg RegistryConfig) []LintWarning {
+	names := make([]string, 0, len(cfg.Sources))
+	for name := range cfg.Sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var warnings []LintWarning
+	for _, name := range names {
+		src := cfg.Sources[name]
+		if src.Insecure {
+			warnings = append(warnings, LintWarning{Code: LintInsecureTLS, Source: name, Field: "Insecure",
+				Message: "TLS verification is disabled, use it in development only"})
+		}
+		if src.Keycloak != nil {
+			warnings = append(warnings, LintScopes(name, "KeycloakClientScopes", src.Keycloak.KeycloakClientScopes)...)
+		}
+	}
+	return warnings
+}
+
+// LintScopes returns a LintBroadScope warning for every broad scope of field
+func LintScopes(source, field string, scopes []string) []LintWarning {
+	var warnings []LintWarning
+	for _, scope := range scopes {
+		if IsBroadScope(scope) {
+			warnings = append(warnings, LintWarning{Code: LintBroadScope, Source: source, Field: field,
+				Message: fmt.Sprintf("scope %q grants more than a single service needs, request narrower scopes", scope)})
+		}
+	}
+	return warnings
+}
+
+// LogLint logs every warning at Warn level, a nil logger uses slog.Default()
+func LogLint(ctx context.Context, logger *slog.Logger, warnings []LintWarning) {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	for _, w := range warnings {
+		logger.LogAttrs(ctx, slog.LevelWarn, "oidc configuration warning",
+			slog.String("code", w.Code),
+			slog.String("source", w.Source),
+			slog.String("field", w.Field),
+			slog.String("message", w.Message))
+	}
+}
@@ -0,0 +1,44 @@
+package oidc_test
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"testing"
+
+	oidc "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLint(t *testing.T) {
+	t.Run("clean configuration", func(t *testing.T) {
+		cfg := oidc.RegistryConfig{Sources: map[string]oidc.SourceConfig{
+			"orders": {Keycloak: &oidc.ConfigKeyCloak{KeycloakClientScopes: []string{"orders.read"}}},
+		}}
+		require.Empty(t, oidc.Lint(cfg))
+	})
+
+	t.Run("insecure and broad scopes", func(t *testing.T) {
+		cfg := oidc.RegistryConfig{Sources: map[string]oidc.SourceConfig{
+			"orders":  {Insecure: true, Keycloak: &oidc.ConfigKeyCloak{}},
+			"billing": {Keycloak: &oidc.ConfigKeyCloak{KeycloakClientScopes: []string{"billing", "admin", "api:*"}}},
+		}}
+		warnings := oidc.Lint(cfg)
+		require.Len(t, warnings, 3)
+		require.Equal(t, oidc.LintBroadScope, warnings[0].Code)
+		require.Equal(t, "billing", warnings[0].Source)
+		require.Contains(t, warnings[1].Message, `"api:*"`)
+		require.Equal(t, oidc.LintWarning{Code: oidc.LintInsecureTLS, Source: "orders", Field: "Insecure",
+			Message: "TLS verification is disabled, use it in development only"}, warnings[2])
+		require.Equal(t, "orders: insecure-tls (Insecure): TLS verification is disabled, use it in development only", warnings[2].String())
+	})
+
+	t.Run("warnings are logged", func(t *testing.T) {
+		var buf bytes.Buffer
+		logger := slog.New(slog.NewTextHandler(&buf, nil))
+		oidc.LogLint(context.Background(), logger, []oidc.LintWarning{{Code: oidc.LintBroadScope, Source: "orders", Message: "too broad"}})
+		require.Contains(t, buf.String(), "level=WARN")
+		require.Contains(t, buf.String(), "code=broad-scope")
+	})
+}
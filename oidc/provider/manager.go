@@ -0,0 +1,246 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CacheStatus is a secret-free view of a TokenCache
+type CacheStatus struct {
+	HasToken    bool
+	Expiry      time.Time
+	LastRefresh time.Time
+	LastError   string
+	Attestation *Attestation // see WithAttestation
+}
+
+// Status returns the current state of the cache without exposing the token
+// It reads the state published after every change, so it never waits for a fetch in flight
+func (c *TokenCache) Status() CacheStatus {
+	if st := c.status.Load(); st != nil {
+		return *st
+	}
+	return CacheStatus{Attestation: c.attestation}
+}
+
+// publishStatus publishes the current state for Status
+// The caller must hold c.mu
+func (c *TokenCache) publishStatus() {
+	st := &CacheStatus{
+		HasToken:    c.token != "",
+		Expiry:      c.expiry,
+		LastRefresh: c.lastRefresh,
+		Attestation: c.attestation,
+	}
+	if c.lastErr != nil {
+		st.LastError = c.lastErr.Error()
+	}
+	c.status.Store(st)
+}
+
+// Kind returns the provider kind reported in snapshots
+func (k *KeycloakTokenProvider) Kind() string {
+	return "keycloak"
+}
+
+// Capabilities reports what the provider supports
+func (k *KeycloakTokenProvider) Capabilities() Capabilities {
+	// FetchToken returns the id_token of the response
+	return Capabilities{
+		IDToken:       true,
+		RefreshToken:  k.Config != nil && k.Config.renewsWithRefreshToken(),
+		Introspection: true,
+		Revocation:    true,
+		TokenExchange: true,
+	}
+}
+
+// providerKind returns the kind of a provider, using its Kind method when it has one
+func providerKind(p TokenProvider) string {
+	if k, ok := p.(interface{ Kind() string }); ok {
+		return k.Kind()
+	}
+	kind := fmt.Sprintf("%T", p)
+	return strings.TrimPrefix(kind[strings.LastIndex(kind, ".")+1:], "*")
+}
+
+// ManagedCredential describes a cache managed by a Manager
+// Kind defaults to the provider kind, Audience is informational
+type ManagedCredential struct {
+	Name     string
+	Kind     string
+	Audience string
+	Cache    *TokenCache
+}
+
+// CredentialSnapshot is a read-only, secret-free view of a managed credential
+// intended for admin dashboards and debug endpoints
+type CredentialSnapshot struct {
+	Name          string    `json:"name"`
+	Kind          string    `json:"kind"`
+	Audience      string    `json:"audience,omitempty"`
+	Expiry        time.Time `json:"expiry,omitempty"`
+	LastRefresh   time.Time `json:"last_refresh,omitempty"`
+	LastRefreshOK bool      `json:"last_refresh_ok"`
+	LastError     string    `json:"last_error,omitempty"`
+
+	Attestation *Attestation `json:"attestation,omitempty"`
+}
+
+// Manager holds a set of named credentials (token caches) managed by the application
+type Manager struct {
+	mu      sync.RWMutex
+	creds   map[string]ManagedCredential
+	closers []io.Closer // see AddCloser
+}
+
+// NewManager creates an empty manager
+func NewManager() *Manager {
+	return &Manager{creds: map[string]ManagedCredential{}}
+}
+
+// Add registers a credential, names must be unique
+func (m *Manager) Add(cred ManagedCredential) error {
+	if cred.Name == "" || cred.Cache == nil {
+		return fmt.Errorf("managed credential requires a name and a cache")
+	}
+	if cred.Kind == "" {
+		cred.Kind = providerKind(cred.Cache.provider)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, exists := m.creds[cred.Name]; exists {
+		return fmt.Errorf("credential %q is already managed", cred.Name)
+	}
+	m.creds[cred.Name] = cred
+	return nil
+}
+
+// Remove stops managing the named credential
+func (m *Manager) Remove(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.creds, name)
+}
+
+// Cache returns the cache of the named credential
+func (m *Manager) Cache(name string) (*TokenCache, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	cred, ok := m.creds[name]
+	return cred.Cache, ok
+}
+
+// Names returns the names of the managed credentials, sorted
+// Unlike Snapshot it does not read the cache states, so it never waits for a running fetch
+func (m *Manager) Names() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	names := make([]string, 0, len(m.creds))
+	for name := range m.creds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Snapshot returns the state of every managed credential sorted by name, without secrets
+func (m *Manager) Snapshot() []CredentialSnapshot {
+	m.mu.RLock()
+	creds := make([]ManagedCredential, 0, len(m.creds))
+	for _, cred := range m.creds {
+		creds = append(creds, cred)
+	}
+	m.mu.RUnlock()
+
+	out := make([]CredentialSnapshot, 0, len(creds))
+	for _, cred := range creds {
+		st := cred.Cache.Status()
+		out = append(out, CredentialSnapshot{
+			Name:          cred.Name,
+			Kind:          cred.Kind,
+			Audience:      cred.Audience,
+			Expiry:        st.Expiry,
+			LastRefresh:   st.LastRefresh,
+			LastRefreshOK: !st.LastRefresh.IsZero() && st.LastError == "",
+			LastError:     st.LastError,
+			Attestation:   st.Attestation,
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// SnapshotHandler serves Snapshot as JSON, for mounting on an internal debug endpoint
+func (m *Manager) SnapshotHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(m.Snapshot())
+	})
+}
+
+// AddCloser registers a background worker built on the manager (HealthProber, ...) closed by CloseAll
+// A UsageReporter created with NewUsageReporter registers itself
+func (m *Manager) AddCloser(c io.Closer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closers = append(m.closers, c)
+}
+
+// CloseAll closes every managed cache (stopping its watches), every provider implementing io.Closer
+// and every worker added with AddCloser, then waits for the watches to exit or ctx to be done
+// Closing happens in the caller's goroutine, so nothing keeps closing after CloseAll returned;
+// provider and worker Close methods should not block
+// Credentials stay registered, so snapshots keep working during shutdown
+func (m *Manager) CloseAll(ctx context.Context) error {
+	m.mu.RLock()
+	creds := make([]ManagedCredential, 0, len(m.creds))
+	for _, cred := range m.creds {
+		creds = append(creds, cred)
+	}
+	closers := append([]io.Closer(nil), m.closers...)
+	m.mu.RUnlock()
+
+	var errs []error
+	for _, cred := range creds {
+		// A panicking Close must not leave the remaining credentials open
+		err := protect("close:"+cred.Name, func() error {
+			cred.Cache.stopWatches()
+			if closer, ok := cred.Cache.provider.(io.Closer); ok {
+				return closer.Close()
+			}
+			return nil
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", cred.Name, err))
+		}
+	}
+	for _, closer := range closers {
+		if err := protect("close:worker", closer.Close); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	// The watches are canceled, so the waiting goroutine ends as soon as their last fetch returns
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, cred := range creds {
+			cred.Cache.watchWG.Wait()
+		}
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		errs = append(errs, fmt.Errorf("closing managed credentials: %w", ctx.Err()))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,172 @@
+package oidc_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	oidc "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestManagerSnapshot(t *testing.T) {
+	token := validJWT(t)
+	m := oidc.NewManager()
+	okCache := oidc.NewTokenCache(&stubProvider{token: token})
+	failing := oidc.NewTokenCache(&stubProvider{err: errors.New("invalid_client")})
+	kc := oidc.NewTokenCache(&oidc.KeycloakTokenProvider{Config: &oidc.ConfigKeyCloak{}})
+
+	require.NoError(t, m.Add(oidc.ManagedCredential{Name: "b-ok", Audience: "pubsub", Cache: okCache}))
+	require.NoError(t, m.Add(oidc.ManagedCredential{Name: "a-failing", Kind: "custom", Cache: failing}))
+	require.NoError(t, m.Add(oidc.ManagedCredential{Name: "c-keycloak", Cache: kc}))
+	require.Error(t, m.Add(oidc.ManagedCredential{Name: "b-ok", Cache: okCache}))
+
+	_, err := okCache.GetValidToken(context.Background())
+	require.NoError(t, err)
+	_, err = failing.GetValidToken(context.Background())
+	require.Error(t, err)
+
+	snap := m.Snapshot()
+	require.Len(t, snap, 3)
+	require.Equal(t, "a-failing", snap[0].Name)
+	require.Equal(t, "custom", snap[0].Kind)
+	require.False(t, snap[0].LastRefreshOK)
+	require.Equal(t, "invalid_client", snap[0].LastError)
+
+	require.Equal(t, "b-ok", snap[1].Name)
+	require.Equal(t, "stubProvider", snap[1].Kind)
+	require.Equal(t, "pubsub", snap[1].Audience)
+	require.True(t, snap[1].LastRefreshOK)
+	require.False(t, snap[1].Expiry.IsZero())
+
+	require.Equal(t, "keycloak", snap[2].Kind)
+	require.True(t, snap[2].LastRefresh.IsZero())
+
+	rec := httptest.NewRecorder()
+	m.SnapshotHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/credentials", nil))
+	require.NotContains(t, rec.Body.String(), token)
+	var decoded []oidc.CredentialSnapshot
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &decoded))
+	require.Len(t, decoded, 3)
+}
+
+func TestManagerSnapshotProviderKinds(t *testing.T) {
+	m := oidc.NewManager()
+	providers := map[string]oidc.TokenProvider{
+		"azure":   &oidc.AzureTokenProvider{},
+		"okta":    &oidc.OktaTokenProvider{},
+		"auth0":   &oidc.Auth0TokenProvider{},
+		"cognito": &oidc.CognitoTokenProvider{},
+		"adfs":    &oidc.ADFSTokenProvider{},
+		"ping":    &oidc.PingTokenProvider{},
+		"oidc":    &oidc.GenericProvider{},
+	}
+	for kind, p := range providers {
+		require.NoError(t, m.Add(oidc.ManagedCredential{Name: kind, Cache: oidc.NewTokenCache(p)}))
+	}
+	for _, snap := range m.Snapshot() {
+		require.Equal(t, snap.Name, snap.Kind)
+	}
+}
+
+func TestManagerCloseAll(t *testing.T) {
+	p := &stubProvider{token: validJWT(t)}
+	cache := oidc.NewTokenCache(p)
+	m := oidc.NewManager()
+	require.NoError(t, m.Add(oidc.ManagedCredential{Name: "orders", Cache: cache}))
+	updates := cache.Watch(context.Background())
+	<-updates
+
+	require.NoError(t, m.CloseAll(context.Background()))
+	_, open := <-updates
+	require.False(t, open)
+	// Watches started after close end immediately
+	_, open = <-cache.Watch(context.Background())
+	require.False(t, open)
+}
+
+func TestManagerCloseAllWorkers(t *testing.T) {
+	m := oidc.NewManager()
+	require.NoError(t, m.Add(oidc.ManagedCredential{Name: "orders", Cache: oidc.NewTokenCache(&stubProvider{token: validJWT(t)})}))
+	prober := oidc.NewHealthProber(time.Hour)
+	prober.Start(context.Background())
+	m.AddCloser(prober)
+	oidc.NewUsageReporter(m, time.Hour).Start(context.Background())
+
+	var closed []string
+	m.AddCloser(closerFunc(func() error { panic("broken worker") }))
+	m.AddCloser(closerFunc(func() error {
+		closed = append(closed, "last")
+		return nil
+	}))
+
+	err := m.CloseAll(context.Background())
+	require.ErrorContains(t, err, "broken worker")
+	require.Equal(t, []string{"last"}, closed)
+}
+
+func TestManagerCloseAllDeadline(t *testing.T) {
+	started := make(chan struct{})
+	var once sync.Once
+	cache := oidc.NewTokenCache(oidc.ProviderFunc(func(ctx context.Context) (string, error) {
+		once.Do(func() { close(started) })
+		<-ctx.Done()
+		// The watch's fetch outlives its cancellation a little
+		time.Sleep(50 * time.Millisecond)
+		return "", ctx.Err()
+	}))
+	m := oidc.NewManager()
+	require.NoError(t, m.Add(oidc.ManagedCredential{Name: "slow", Cache: cache}))
+	updates := cache.Watch(context.Background())
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, m.CloseAll(ctx), context.DeadlineExceeded)
+	// The canceled watch still ends
+	for range updates {
+	}
+}
+
+// closerFunc adapts a function to io.Closer
+type closerFunc func() error
+
+func (f closerFunc) Close() error { return f() }
+
+func TestCacheStatusDuringFetch(t *testing.T) {
+	token := validJWT(t)
+	started, release := make(chan struct{}), make(chan struct{})
+	calls := 0
+	cache := oidc.NewTokenCache(oidc.ProviderFunc(func(context.Context) (string, error) {
+		calls++
+		if calls == 2 {
+			close(started)
+			<-release
+		}
+		return token, nil
+	}))
+	_, err := cache.GetValidToken(context.Background())
+	require.NoError(t, err)
+	before := cache.Status()
+
+	cache.ForceExpire(time.Now())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _ = cache.GetValidToken(context.Background())
+	}()
+	<-started
+	// The fetch holds the cache lock, Status answers from the last published state
+	st := cache.Status()
+	require.True(t, st.HasToken)
+	require.Equal(t, before.LastRefresh, st.LastRefresh)
+	close(release)
+	<-done
+	require.True(t, cache.Status().LastRefresh.After(before.LastRefresh))
+}
@@ -0,0 +1,59 @@
+package oidc
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// DefaultMeshHeaders are checked in order by MeshTokenExtractor when Headers is empty
+// X-Forwarded-Access-Token is set by oauth2-proxy and similar sidecars; Authorization
+// carries the original JWT when Istio's forwardOriginalToken is enabled
+var DefaultMeshHeaders = []string{"X-Forwarded-Access-Token", "Authorization"}
+
+// ErrNoMeshToken is returned when none of the configured headers carries a token
+var ErrNoMeshToken = errors.New("no mesh forwarded token in request")
+
+// MeshTokenExtractor reads the JWT injected by a service mesh or auth sidecar and verifies it
+// Payload-only headers (Istio outputPayloadToHeader) are not supported: without the signature the
+// token can neither be verified nor used as a subject token for federation
+type MeshTokenExtractor struct {
+	Verifier *Verifier
+	Headers  []string // default DefaultMeshHeaders
+}
+
+// Extract returns the verified token forwarded with r and its claims
+func (m *MeshTokenExtractor) Extract(r *http.Request) (string, *Claims, error) {
+	headers := m.Headers
+	if len(headers) == 0 {
+		headers = DefaultMeshHeaders
+	}
+	for _, name := range headers {
+		value := strings.TrimSpace(r.Header.Get(name))
+		if value == "" {
+			continue
+		}
+		if len(value) > 7 && strings.EqualFold(value[:7], "bearer ") {
+			value = strings.TrimSpace(value[7:])
+		}
+		claims, err := m.Verifier.Verify(r.Context(), value)
+		if err != nil {
+			return "", nil, err
+		}
+		return value, claims, nil
+	}
+	return "", nil, ErrNoMeshToken
+}
+
+// Middleware verifies the mesh forwarded token and stores it with its claims in the request
+// context (TokenFromContext, ClaimsFromContext); requests without a valid token get 401
+func (m *MeshTokenExtractor) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, claims, err := m.Extract(r)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(ContextWithClaims(ContextWithToken(r.Context(), token), claims)))
+	})
+}
@@ -0,0 +1,65 @@
+package oidc_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	oidc "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMeshTokenExtractor(t *testing.T) {
+	iss := newTestIssuer(t)
+	ext := &oidc.MeshTokenExtractor{Verifier: oidc.NewVerifier(oidc.VerifierConfig{Issuer: iss.URL, Audience: "svc"})}
+	token := iss.sign(t, "ES256", "ec", iss.claims("svc"))
+
+	t.Run("forwarded access token header", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Forwarded-Access-Token", token)
+		got, claims, err := ext.Extract(req)
+		require.NoError(t, err)
+		require.Equal(t, token, got)
+		require.Equal(t, "user-1", claims.Subject)
+	})
+
+	t.Run("original token in authorization header", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		got, _, err := ext.Extract(req)
+		require.NoError(t, err)
+		require.Equal(t, token, got)
+	})
+
+	t.Run("missing and invalid tokens", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		_, _, err := ext.Extract(req)
+		require.True(t, errors.Is(err, oidc.ErrNoMeshToken))
+
+		req.Header.Set("X-Forwarded-Access-Token", iss.sign(t, "ES256", "ec", iss.claims("other")))
+		_, _, err = ext.Extract(req)
+		var verr *oidc.VerificationError
+		require.True(t, errors.As(err, &verr))
+		require.Equal(t, oidc.ReasonAudienceMismatch, verr.Reason)
+	})
+
+	t.Run("middleware", func(t *testing.T) {
+		var sub string
+		h := ext.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, _ := oidc.ClaimsFromContext(r.Context())
+			sub = claims.Subject
+		}))
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Forwarded-Access-Token", token)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, "user-1", sub)
+
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+}
@@ -0,0 +1,117 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// MetadataHostEnv overrides the metadata server host, the variable the Google client libraries use
+const MetadataHostEnv = "GCE_METADATA_HOST"
+
+// DefaultMetadataHost is the metadata server of GCE, GKE, Cloud Run and Cloud Functions
+const DefaultMetadataHost = "metadata.google.internal"
+
+// MetadataTokenProvider implements TokenProvider with identity tokens from the GCE/Cloud Run metadata server
+// The token's exp claim is used by TokenCache as expiry, Google issues them for one hour
+type MetadataTokenProvider struct {
+	Audience       string       // required, e.g. the URL of the Cloud Run service to call
+	ServiceAccount string       // default "default", the service account attached to the instance
+	Format         string       // "standard" (default) or "full", full adds project and instance claims on GCE
+	Host           string       // default $GCE_METADATA_HOST or DefaultMetadataHost
+	HTTPClient     *http.Client // optional, default NewHTTPClient("metadata", false)
+	OnEvent        EventHandler // optional, receives request, fetched and failed events
+}
+
+// Kind returns the provider kind reported in snapshots
+func (m *MetadataTokenProvider) Kind() string {
+	return "metadata"
+}
+
+// Capabilities reports what the provider supports
+func (m *MetadataTokenProvider) Capabilities() Capabilities {
+	return Capabilities{IDToken: true}
+}
+
+// FetchToken requests a new identity token for Audience from the metadata server
+func (m *MetadataTokenProvider) FetchToken(ctx context.Context) (string, error) {
+	if m.Audience == "" {
+		return "", errors.New("metadata token configuration is incomplete: Audience must be provided")
+	}
+	m.OnEvent.emit(Event{Type: EventTokenRequest, Provider: "metadata"})
+	start := time.Now()
+	token, err := m.request(ctx)
+	if err != nil {
+		m.OnEvent.emit(Event{Type: EventTokenFailed, Provider: "metadata", Duration: time.Since(start), Err: err})
+		return "", err
+	}
+	m.OnEvent.emit(Event{Type: EventTokenFetched, Provider: "metadata", Duration: time.Since(start)})
+	return token, nil
+}
+
+// IdentityURL returns the metadata server URL requested by FetchToken
+func (m *MetadataTokenProvider) IdentityURL() string {
+	host := m.Host
+	if host == "" {
+		host = os.Getenv(MetadataHostEnv)
+	}
+	if host == "" {
+		host = DefaultMetadataHost
+	}
+	if !strings.Contains(host, "://") {
+		host = "http://" + host
+	}
+	account := m.ServiceAccount
+	if account == "" {
+		account = "default"
+	}
+	q := url.Values{"audience": {m.Audience}}
+	if m.Format != "" {
+		q.Set("format", m.Format)
+	}
+	return fmt.Sprintf("%s/computeMetadata/v1/instance/service-accounts/%s/identity?%s", strings.TrimRight(host, "/"), url.PathEscape(account), q.Encode())
+}
+
+func (m *MetadataTokenProvider) request(ctx context.Context) (string, error) {
+	client := m.HTTPClient
+	if client == nil {
+		client = NewHTTPClient("metadata", false)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, m.IdentityURL(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to get identity token from metadata server: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", fmt.Errorf("failed to read metadata server response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", &TokenError{
+			Provider:    "metadata",
+			StatusCode:  resp.StatusCode,
+			Description: strings.TrimSpace(string(body)),
+			RetryAfter:  ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+		}
+	}
+	// The token is returned as plain text, a proxy answering instead of the metadata server is not
+	if resp.Header.Get("Metadata-Flavor") != "Google" {
+		return "", errors.New("response did not come from the metadata server: Metadata-Flavor header missing")
+	}
+	token := strings.TrimSpace(string(body))
+	if token == "" {
+		return "", errors.New("metadata server returned an empty identity token")
+	}
+	return token, nil
+}
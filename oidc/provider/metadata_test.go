@@ -0,0 +1,51 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	oidc "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetadataTokenProvider(t *testing.T) {
+	token := validJWT(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if r.URL.Path != "/computeMetadata/v1/instance/service-accounts/default/identity" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		require.Equal(t, "https://orders.run.app", r.URL.Query().Get("audience"))
+		require.Equal(t, "full", r.URL.Query().Get("format"))
+		w.Header().Set("Metadata-Flavor", "Google")
+		_, _ = w.Write([]byte(token))
+	}))
+	t.Cleanup(srv.Close)
+	t.Setenv(oidc.MetadataHostEnv, srv.Listener.Addr().String())
+
+	p := &oidc.MetadataTokenProvider{Audience: "https://orders.run.app", Format: "full"}
+	cache := oidc.NewTokenCache(p)
+	got, err := cache.GetValidToken(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, token, got)
+	// Expiry comes from the exp claim of the identity token
+	require.WithinDuration(t, time.Now().Add(time.Hour), cache.Status().Expiry, time.Minute)
+
+	p.ServiceAccount = "missing@p.iam.gserviceaccount.com"
+	_, err = p.FetchToken(context.Background())
+	var tErr *oidc.TokenError
+	require.True(t, errors.As(err, &tErr))
+	require.Equal(t, http.StatusNotFound, tErr.StatusCode)
+
+	_, err = (&oidc.MetadataTokenProvider{}).FetchToken(context.Background())
+	require.ErrorContains(t, err, "Audience")
+}
@@ -0,0 +1,102 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// claimsContextKey is the context key for verified claims
+type claimsContextKey struct{}
+
+// ContextWithClaims returns a copy of ctx carrying verified claims
+func ContextWithClaims(ctx context.Context, claims *Claims) context.Context {
+	return context.WithValue(ctx, claimsContextKey{}, claims)
+}
+
+// ClaimsFromContext returns the claims stored by the verification middleware, if any
+func ClaimsFromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(claimsContextKey{}).(*Claims)
+	return claims, ok && claims != nil
+}
+
+// anonymousContextKey marks requests let through without a token in VerifyModeOptional
+type anonymousContextKey struct{}
+
+// IsAnonymous reports whether the request carried no token and was let through by a
+// middleware in VerifyModeOptional; ClaimsFromContext returns no claims for such requests
+func IsAnonymous(ctx context.Context) bool {
+	anonymous, _ := ctx.Value(anonymousContextKey{}).(bool)
+	return anonymous
+}
+
+// bearerToken extracts the token from an "Authorization: Bearer" header
+func bearerToken(r *http.Request) (string, bool) {
+	auth := r.Header.Get("Authorization")
+	if len(auth) < 7 || !strings.EqualFold(auth[:7], "bearer ") {
+		return "", false
+	}
+	token := strings.TrimSpace(auth[7:])
+	return token, token != ""
+}
+
+// Middleware verifies the bearer token of incoming requests
+// Verified claims are available to handlers through ClaimsFromContext and the raw token
+// through TokenFromContext. In VerifyModeObserve failures are logged with their reason and
+// the request continues without claims instead of being rejected.
+// Rejected requests get an RFC 6750 WWW-Authenticate challenge: 401 invalid_token for bad
+// tokens and 403 insufficient_scope when RequiredScopes are missing. When the signing keys
+// cannot be fetched the request is answered with 503 and Retry-After instead.
+func (v *Verifier) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := bearerToken(r)
+		if !ok && v.cfg.Mode == VerifyModeOptional && r.Header.Get("Authorization") == "" {
+			ctx := context.WithValue(ContextWithClaims(r.Context(), nil), anonymousContextKey{}, true)
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+		if !ok {
+			v.reject(w, r, next, &VerificationError{Reason: ReasonMissingToken, Err: errors.New("missing bearer token")})
+			return
+		}
+		claims, err := v.Verify(r.Context(), token)
+		if err != nil {
+			v.reject(w, r, next, err)
+			return
+		}
+		if !hasScopes(claims.Scope, v.cfg.RequiredScopes) {
+			v.reject(w, r, next, verificationError(ReasonInsufficientScope, "scope %q lacks one of %v", claims.Scope, v.cfg.RequiredScopes))
+			return
+		}
+		ctx := ContextWithClaims(ContextWithToken(r.Context(), token), claims)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// reject handles a failed verification according to the verifier mode
+func (v *Verifier) reject(w http.ResponseWriter, r *http.Request, next http.Handler, err error) {
+	reason := ReasonMalformed
+	var verr *VerificationError
+	if errors.As(err, &verr) {
+		reason = verr.Reason
+	}
+	if v.cfg.Mode == VerifyModeObserve {
+		v.logger().Warn("token verification failed (observe mode, request allowed)",
+			slog.String("reason", string(reason)),
+			slog.String("error", err.Error()),
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+		)
+		next.ServeHTTP(w, r)
+		return
+	}
+	c := v.challengeFor(err)
+	setChallengeHeaders(w, c)
+	if v.cfg.OnReject != nil {
+		v.cfg.OnReject(w, r, c)
+		return
+	}
+	writeChallenge(w, r, c)
+}
@@ -0,0 +1,197 @@
+package oidc
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// ErrCertificateBinding is returned when a token is not bound to the presented client certificate
+var ErrCertificateBinding = errors.New("token is not bound to the client certificate")
+
+// ClientTLS configures mutual TLS with the token endpoint (RFC 8705)
+// The client certificate authenticates the client (tls_client_auth, self_signed_tls_client_auth) and
+// binds issued access tokens to the certificate when the IdP supports certificate-bound tokens
+// Files are read whenever a client is built, i.e. on every token request, so rotated certificates
+// (cert-manager, SPIFFE) are picked up without a restart
+type ClientTLS struct {
+	CertFile string // PEM client certificate, optionally followed by its chain
+	KeyFile  string // PEM private key of CertFile
+	CAFile   string // PEM CA bundle verifying the server, default the system roots
+
+	Certificate *tls.Certificate // in-memory alternative to CertFile and KeyFile
+	RootCAs     *x509.CertPool   // in-memory alternative to CAFile
+}
+
+// certificate loads the client certificate with its parsed leaf
+func (c *ClientTLS) certificate() (*tls.Certificate, error) {
+	if c.Certificate != nil {
+		return c.Certificate, nil
+	}
+	if c.CertFile == "" || c.KeyFile == "" {
+		return nil, errors.New("client TLS needs Certificate or CertFile and KeyFile")
+	}
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load client certificate: %w", err)
+	}
+	return &cert, nil
+}
+
+// Leaf returns the client certificate presented to the server, e.g. for CertificateThumbprint
+func (c *ClientTLS) Leaf() (*x509.Certificate, error) {
+	cert, err := c.certificate()
+	if err != nil {
+		return nil, err
+	}
+	if cert.Leaf != nil {
+		return cert.Leaf, nil
+	}
+	if len(cert.Certificate) == 0 {
+		return nil, errors.New("client certificate is empty")
+	}
+	return x509.ParseCertificate(cert.Certificate[0])
+}
+
+// TLSConfig returns the TLS configuration presenting the client certificate
+// insecure skips server verification (development only)
+func (c *ClientTLS) TLSConfig(insecure bool) (*tls.Config, error) {
+	cfg, _, err := c.tlsConfig(insecure)
+	return cfg, err
+}
+
+// tlsConfig returns the TLS configuration and a fingerprint of the certificate chain and CA bundle
+// it was built from, which changes when the files are rotated
+func (c *ClientTLS) tlsConfig(insecure bool) (*tls.Config, string, error) {
+	cert, err := c.certificate()
+	if err != nil {
+		return nil, "", err
+	}
+	cfg := &tls.Config{
+		Certificates:       []tls.Certificate{*cert},
+		RootCAs:            c.RootCAs,
+		InsecureSkipVerify: insecure,
+		MinVersion:         tls.VersionTLS12,
+	}
+	h := sha256.New()
+	for _, der := range cert.Certificate {
+		h.Write(der)
+	}
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to read CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, "", fmt.Errorf("CA file %s has no PEM certificates", c.CAFile)
+		}
+		cfg.RootCAs = pool
+		h.Write(pem)
+	}
+	return cfg, base64.RawURLEncoding.EncodeToString(h.Sum(nil)), nil
+}
+
+// mtlsSlot identifies the configuration a cached mutual TLS transport belongs to
+type mtlsSlot struct {
+	name                      string
+	insecure                  bool
+	certFile, keyFile, caFile string
+	cert                      *tls.Certificate
+	roots                     *x509.CertPool
+}
+
+// mtlsTransport is the transport of a slot and the fingerprint of the files it was built from
+type mtlsTransport struct {
+	fingerprint string
+	transport   *http.Transport
+}
+
+var (
+	mtlsMu         sync.Mutex
+	mtlsTransports = map[mtlsSlot]mtlsTransport{}
+)
+
+// NewMTLSHTTPClient returns NewHTTPClient presenting the client certificate of clientTLS
+// A nil clientTLS returns NewHTTPClient(name, insecure); use the same client to call resource servers
+// that check certificate-bound access tokens
+// The files are read on every call, but the transport and its connections are reused until the
+// certificate or CA bundle changes; the transport of a replaced certificate closes its idle connections
+func NewMTLSHTTPClient(name string, insecure bool, clientTLS *ClientTLS) (*http.Client, error) {
+	if clientTLS == nil {
+		return NewHTTPClient(name, insecure), nil
+	}
+	cfg, fingerprint, err := clientTLS.tlsConfig(insecure)
+	if err != nil {
+		return nil, err
+	}
+	slot := mtlsSlot{
+		name:     name,
+		insecure: insecure,
+		certFile: clientTLS.CertFile,
+		keyFile:  clientTLS.KeyFile,
+		caFile:   clientTLS.CAFile,
+		cert:     clientTLS.Certificate,
+		roots:    clientTLS.RootCAs,
+	}
+	mtlsMu.Lock()
+	cached, ok := mtlsTransports[slot]
+	if !ok || cached.fingerprint != fingerprint {
+		tr := http.DefaultTransport.(*http.Transport).Clone()
+		tr.TLSClientConfig = cfg
+		mtlsTransports[slot] = mtlsTransport{fingerprint: fingerprint, transport: tr}
+		if ok {
+			cached.transport.CloseIdleConnections()
+		}
+		cached.transport = tr
+	}
+	mtlsMu.Unlock()
+	return &http.Client{Transport: &DebugTransport{Base: &skewObserver{Base: cached.transport}, Name: name}}, nil
+}
+
+// CertificateThumbprint returns the x5t#S256 thumbprint of cert (RFC 8705 section 3.1)
+func CertificateThumbprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// VerifyCertificateBinding checks that the cnf claim of verified claims names cert, e.g. in a resource
+// server receiving certificate-bound access tokens over mutual TLS
+// claims must come from Verifier.Verify, see Verifier.VerifyCertificateBound; errors wrap ErrCertificateBinding
+func VerifyCertificateBinding(claims *Claims, cert *x509.Certificate) error {
+	if claims == nil {
+		return fmt.Errorf("%w: no verified claims", ErrCertificateBinding)
+	}
+	if cert == nil {
+		return fmt.Errorf("%w: no client certificate", ErrCertificateBinding)
+	}
+	cnf, _ := claims.Raw["cnf"].(map[string]interface{})
+	thumbprint, _ := cnf["x5t#S256"].(string)
+	if thumbprint == "" {
+		return fmt.Errorf("%w: token has no cnf x5t#S256 claim", ErrCertificateBinding)
+	}
+	if thumbprint != CertificateThumbprint(cert) {
+		return fmt.Errorf("%w: thumbprint mismatch", ErrCertificateBinding)
+	}
+	return nil
+}
+
+// VerifyCertificateBound verifies token and checks that it is bound to cert, the client certificate
+// of the mutual TLS connection it arrived on (r.TLS.PeerCertificates[0])
+func (v *Verifier) VerifyCertificateBound(ctx context.Context, token string, cert *x509.Certificate) (*Claims, error) {
+	claims, err := v.Verify(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	if err := VerifyCertificateBinding(claims, cert); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
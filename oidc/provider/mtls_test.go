@@ -0,0 +1,219 @@
+package oidc_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	oidc "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newClientCertificate writes a self-signed client certificate and key to dir
+func newClientCertificate(t *testing.T, dir string) (certFile, keyFile string, cert *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "client"},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err = x509.ParseCertificate(der)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	certFile, keyFile = filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return certFile, keyFile, cert
+}
+
+// newMTLSServer starts a TLS server requiring a client certificate and returns the client TLS
+// configuration trusting it
+func newMTLSServer(t *testing.T, handler http.Handler) (*httptest.Server, *oidc.ClientTLS, *x509.Certificate) {
+	t.Helper()
+	dir := t.TempDir()
+	srv := httptest.NewUnstartedServer(handler)
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	caFile := filepath.Join(dir, "ca.crt")
+	require.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0o600))
+	certFile, keyFile, cert := newClientCertificate(t, dir)
+	return srv, &oidc.ClientTLS{CertFile: certFile, KeyFile: keyFile, CAFile: caFile}, cert
+}
+
+func TestClientTLS(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("keycloak tls_client_auth", func(t *testing.T) {
+		var peer *x509.Certificate
+		var clientID, secret string
+		mux := http.NewServeMux()
+		mux.HandleFunc("/realms/test/protocol/openid-connect/token", func(w http.ResponseWriter, r *http.Request) {
+			require.NoError(t, r.ParseForm())
+			peer = r.TLS.PeerCertificates[0]
+			clientID, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+			writeTokenResponse(w, map[string]interface{}{"access_token": "at", "id_token": validJWT(t)})
+		})
+		srv, clientTLS, cert := newMTLSServer(t, mux)
+		p := &oidc.KeycloakTokenProvider{Config: &oidc.ConfigKeyCloak{
+			KeycloakRealmURL: srv.URL + "/realms/test",
+			KeycloakClientID: "client",
+			ClientTLS:        clientTLS,
+		}}
+		_, err := p.FetchToken(ctx)
+		require.NoError(t, err)
+		require.Equal(t, cert.Raw, peer.Raw)
+		require.Equal(t, "client", clientID)
+		require.Empty(t, secret)
+	})
+
+	t.Run("keycloak password and token exchange grants", func(t *testing.T) {
+		var peers []*x509.Certificate
+		var grants []string
+		mux := http.NewServeMux()
+		mux.HandleFunc("/realms/test/protocol/openid-connect/token", func(w http.ResponseWriter, r *http.Request) {
+			require.NoError(t, r.ParseForm())
+			peers = append(peers, r.TLS.PeerCertificates[0])
+			grants = append(grants, r.PostForm.Get("grant_type"))
+			require.Equal(t, "client", r.PostForm.Get("client_id"))
+			writeTokenResponse(w, map[string]interface{}{"access_token": "at", "id_token": validJWT(t)})
+		})
+		srv, clientTLS, cert := newMTLSServer(t, mux)
+		cfg := &oidc.ConfigKeyCloak{
+			KeycloakRealmURL: srv.URL + "/realms/test",
+			KeycloakClientID: "client",
+			ClientTLS:        clientTLS,
+			Username:         "legacy",
+			Password:         "pw",
+		}
+		_, err := (&oidc.KeycloakPasswordProvider{Config: cfg}).FetchToken(ctx)
+		require.NoError(t, err)
+		_, err = oidc.NewKeycloakExchanger(cfg, false).Exchange(ctx, "subject", "orders-api")
+		require.NoError(t, err)
+		require.Equal(t, []string{"password", string(oidc.GrantTokenExchange)}, grants)
+		for _, peer := range peers {
+			require.Equal(t, cert.Raw, peer.Raw)
+		}
+	})
+
+	t.Run("generic provider uses the mtls alias", func(t *testing.T) {
+		var srv *httptest.Server
+		var aliasCalls int
+		mux := http.NewServeMux()
+		mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"issuer":                srv.URL,
+				"token_endpoint":        srv.URL + "/token",
+				"mtls_endpoint_aliases": map[string]string{"token_endpoint": srv.URL + "/mtls/token"},
+			})
+		})
+		mux.HandleFunc("/mtls/token", func(w http.ResponseWriter, r *http.Request) {
+			aliasCalls++
+			writeTokenResponse(w, map[string]interface{}{"access_token": "bound"})
+		})
+		srv, clientTLS, _ := newMTLSServer(t, mux)
+		p := &oidc.GenericProvider{Config: &oidc.ConfigGeneric{
+			IssuerURL:        srv.URL,
+			ClientID:         "client",
+			ClientAuthMethod: oidc.ClientAuthSelfSignedTLS,
+			ClientTLS:        clientTLS,
+		}}
+		token, err := p.FetchToken(ctx)
+		require.NoError(t, err)
+		require.Equal(t, "bound", token)
+		require.Equal(t, 1, aliasCalls)
+	})
+
+	t.Run("certificate methods require a certificate", func(t *testing.T) {
+		cfg := &oidc.ConfigKeyCloak{KeycloakRealmURL: "https://kc/realms/test", KeycloakClientID: "client", ClientAuthMethod: oidc.ClientAuthTLS}
+		require.ErrorContains(t, cfg.Validate(), "requires a client certificate")
+	})
+
+	t.Run("missing key file", func(t *testing.T) {
+		_, err := oidc.NewMTLSHTTPClient("test", false, &oidc.ClientTLS{CertFile: "missing.crt"})
+		require.Error(t, err)
+	})
+
+	t.Run("transport is reused until the certificate rotates", func(t *testing.T) {
+		// Requests are sequential, the handler sees one connection per remote address
+		conns := map[string]bool{}
+		var peers []string
+		srv, clientTLS, _ := newMTLSServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			conns[r.RemoteAddr] = true
+			peers = append(peers, oidc.CertificateThumbprint(r.TLS.PeerCertificates[0]))
+		}))
+		get := func() {
+			client, err := oidc.NewMTLSHTTPClient("test", false, clientTLS)
+			require.NoError(t, err)
+			resp, err := client.Get(srv.URL)
+			require.NoError(t, err)
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		get()
+		get()
+		require.Len(t, conns, 1)
+
+		// Rotated files get a new transport presenting the new certificate
+		_, _, rotated := newClientCertificate(t, filepath.Dir(clientTLS.CertFile))
+		get()
+		require.Len(t, conns, 2)
+		require.Equal(t, oidc.CertificateThumbprint(rotated), peers[2])
+	})
+
+	t.Run("leaf", func(t *testing.T) {
+		certFile, keyFile, cert := newClientCertificate(t, t.TempDir())
+		leaf, err := (&oidc.ClientTLS{CertFile: certFile, KeyFile: keyFile}).Leaf()
+		require.NoError(t, err)
+		require.Equal(t, cert.Raw, leaf.Raw)
+	})
+}
+
+func TestVerifyCertificateBinding(t *testing.T) {
+	ctx := context.Background()
+	_, _, cert := newClientCertificate(t, t.TempDir())
+	_, _, other := newClientCertificate(t, t.TempDir())
+	iss := newTestIssuer(t)
+	v := oidc.NewVerifier(oidc.VerifierConfig{Issuer: iss.URL, Audience: "svc"})
+	claims := iss.claims("svc")
+	claims["cnf"] = map[string]interface{}{"x5t#S256": oidc.CertificateThumbprint(cert)}
+	bound := iss.sign(t, "ES256", "ec", claims)
+
+	verified, err := v.VerifyCertificateBound(ctx, bound, cert)
+	require.NoError(t, err)
+	require.NoError(t, oidc.VerifyCertificateBinding(verified, cert))
+	_, err = v.VerifyCertificateBound(ctx, bound, other)
+	require.True(t, errors.Is(err, oidc.ErrCertificateBinding))
+	_, err = v.VerifyCertificateBound(ctx, iss.sign(t, "ES256", "ec", iss.claims("svc")), cert)
+	require.True(t, errors.Is(err, oidc.ErrCertificateBinding))
+
+	// An unsigned token naming the certificate is rejected before the binding is compared
+	forged := makeJWT(t, map[string]interface{}{"cnf": map[string]interface{}{"x5t#S256": oidc.CertificateThumbprint(cert)}})
+	_, err = v.VerifyCertificateBound(ctx, forged, cert)
+	var verr *oidc.VerificationError
+	require.True(t, errors.As(err, &verr))
+	require.True(t, errors.Is(oidc.VerifyCertificateBinding(nil, cert), oidc.ErrCertificateBinding))
+}
@@ -0,0 +1,238 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// TokenExchanger exchanges a subject token for a token issued to audience (RFC 8693)
+type TokenExchanger interface {
+	Exchange(ctx context.Context, subjectToken, audience string) (*oauth2.Token, error)
+}
+
+// STSExchanger performs RFC 8693 token exchange against any security token service,
+// Keycloak included (see NewKeycloakExchanger)
+// The optional RFC 8693 parameters are sent only when set
+type STSExchanger struct {
+	TokenURL           string
+	ClientID           string
+	ClientSecret       string // optional for public clients
+	SubjectTokenType   string // default TokenTypeAccessToken
+	RequestedTokenType string // e.g. TokenTypeIDToken or TokenTypeAccessToken, optional
+	Resource           []string
+	ActorToken         string // delegation: token of the acting party, optional
+	ActorTokenType     string // required with ActorToken, default TokenTypeAccessToken
+	Scopes             []string
+	// Audiences are sent as further audience parameters with every exchange, after the audience
+	// passed to Exchange, optional
+	Audiences  []string
+	HTTPClient *http.Client // default NewMTLSHTTPClient("sts", Insecure, ClientTLS)
+	Insecure   bool         // skips TLS verification of the default client
+	// ClientTLS presents a client certificate with the default client (RFC 8705), authenticating the
+	// client when no secret or signer is set, optional
+	ClientTLS *ClientTLS
+	// AuthStyle defaults to HTTP Basic with ClientSecret, probing form parameters if that fails;
+	// without ClientSecret a non-empty ClientID is sent as form parameter, as public clients do
+	AuthStyle oauth2.AuthStyle
+	// ClientAssertionSigner authenticates the client with a signed assertion (private_key_jwt) instead of
+	// ClientSecret, optional
+	ClientAssertionSigner   JWTSigner
+	ClientAssertionLifetime time.Duration    // default 2 minutes, also used by ClientAuthSecretJWT
+	ClientAuthMethod        ClientAuthMethod // default ClientAuthAuto, see ConfigKeyCloak.ClientAuthMethod
+
+	// RequestedSubject asks Keycloak to impersonate this user (id or username), a Keycloak extension
+	// that needs the impersonation permission; without a subject token it is direct naked impersonation
+	RequestedSubject string
+	// RequestedIssuer asks Keycloak for a token of this linked identity provider alias, a Keycloak extension
+	RequestedIssuer string
+}
+
+// NewKeycloakExchanger creates an exchanger using the realm token endpoint and client authentication of cfg
+func NewKeycloakExchanger(cfg *ConfigKeyCloak, insecure bool) *STSExchanger {
+	return &STSExchanger{
+		TokenURL:                cfg.TokenURL(),
+		ClientID:                cfg.KeycloakClientID,
+		ClientSecret:            cfg.KeycloakClientSecret,
+		Scopes:                  cfg.KeycloakClientScopes,
+		Insecure:                insecure,
+		ClientTLS:               cfg.ClientTLS,
+		ClientAssertionSigner:   cfg.ClientAssertionSigner,
+		ClientAssertionLifetime: cfg.ClientAssertionLifetime,
+		ClientAuthMethod:        cfg.ClientAuthMethod,
+	}
+}
+
+// Exchange implements TokenExchanger
+func (e *STSExchanger) Exchange(ctx context.Context, subjectToken, audience string) (*oauth2.Token, error) {
+	client := e.HTTPClient
+	if client == nil {
+		var err error
+		if client, err = NewMTLSHTTPClient("sts", e.Insecure, e.ClientTLS); err != nil {
+			return nil, err
+		}
+	}
+	subjectType := e.SubjectTokenType
+	if subjectType == "" {
+		subjectType = TokenTypeAccessToken
+	}
+	params := url.Values{}
+	if subjectToken != "" {
+		params.Set("subject_token", subjectToken)
+		params.Set("subject_token_type", subjectType)
+	} else if e.RequestedSubject == "" {
+		return nil, errors.New("token exchange requires a subject token")
+	}
+	if e.RequestedSubject != "" {
+		params.Set("requested_subject", e.RequestedSubject)
+	}
+	if e.RequestedIssuer != "" {
+		params.Set("requested_issuer", e.RequestedIssuer)
+	}
+	if audience != "" {
+		params.Set("audience", audience)
+	}
+	for _, a := range e.Audiences {
+		params.Add("audience", a)
+	}
+	if e.RequestedTokenType != "" {
+		params.Set("requested_token_type", e.RequestedTokenType)
+	}
+	for _, r := range e.Resource {
+		params.Add("resource", r)
+	}
+	if e.ActorToken != "" {
+		actorType := e.ActorTokenType
+		if actorType == "" {
+			actorType = TokenTypeAccessToken
+		}
+		params.Set("actor_token", e.ActorToken)
+		params.Set("actor_token_type", actorType)
+	}
+	// The clientcredentials helper sends the token-exchange grant through EndpointParams,
+	// the same way KeycloakTokenProvider sends its other grants
+	params.Set("grant_type", string(GrantTokenExchange))
+	authStyle := e.AuthStyle
+	if authStyle == oauth2.AuthStyleAutoDetect && e.ClientSecret == "" {
+		authStyle = oauth2.AuthStyleInParams
+	}
+	conf := &clientcredentials.Config{
+		ClientID:       e.ClientID,
+		ClientSecret:   e.ClientSecret,
+		TokenURL:       e.TokenURL,
+		Scopes:         e.Scopes,
+		EndpointParams: params,
+		AuthStyle:      authStyle,
+	}
+	conf, err := e.ClientAuthMethod.apply(conf, e.ClientAssertionSigner, e.ClientAssertionLifetime, e.ClientTLS)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign client assertion: %w", err)
+	}
+	tok, err := conf.Token(context.WithValue(ctx, oauth2.HTTPClient, client))
+	if err != nil {
+		err = canceledByCaller(ctx, "sts", asTokenError("sts", err))
+		return nil, fmt.Errorf("token exchange for audience %q failed: %w", audience, err)
+	}
+	// A requested id_token is returned in the access_token field (RFC 8693 section 2.2.1)
+	return tok, nil
+}
+
+// OnBehalfOf mints downstream tokens for the caller of an incoming request (on-behalf-of pattern)
+// It runs after Verifier.Middleware: the verified incoming token is exchanged for a token
+// issued to Audience, and the result replaces the token in the request context, so
+// ContextTransport forwards it downstream. Tokens are cached per (sub, audience) until shortly
+// before they expire.
+type OnBehalfOf struct {
+	Exchanger TokenExchanger
+	Audience  string
+	Leeway    time.Duration // refresh this long before expiry, default 30s
+
+	mu    sync.Mutex
+	cache map[string]*oauth2.Token
+}
+
+// NewOnBehalfOf creates an on-behalf-of exchanger for audience
+func NewOnBehalfOf(exchanger TokenExchanger, audience string) *OnBehalfOf {
+	return &OnBehalfOf{Exchanger: exchanger, Audience: audience}
+}
+
+// Token returns the downstream token for the verified caller of ctx
+func (o *OnBehalfOf) Token(ctx context.Context) (string, error) {
+	claims, ok := ClaimsFromContext(ctx)
+	if !ok || claims.Subject == "" {
+		return "", errors.New("on-behalf-of exchange requires verified claims with a subject")
+	}
+	subjectToken, ok := TokenFromContext(ctx)
+	if !ok {
+		return "", errors.New("on-behalf-of exchange requires the incoming token in context")
+	}
+	key := claims.Subject + "|" + o.Audience
+	leeway := o.Leeway
+	if leeway <= 0 {
+		leeway = 30 * time.Second
+	}
+
+	o.mu.Lock()
+	if tok, ok := o.cache[key]; ok && time.Now().Add(leeway).Before(tok.Expiry) {
+		o.mu.Unlock()
+		return tok.AccessToken, nil
+	}
+	o.mu.Unlock()
+
+	tok, err := o.Exchanger.Exchange(ctx, subjectToken, o.Audience)
+	if err != nil {
+		return "", err
+	}
+	if tok.Expiry.IsZero() {
+		if exp, err := getJWTExpiry(tok.AccessToken); err == nil {
+			tok.Expiry = time.Unix(exp, 0)
+		}
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.cache == nil {
+		o.cache = make(map[string]*oauth2.Token)
+	}
+	o.evictExpired()
+	if !tok.Expiry.IsZero() {
+		o.cache[key] = tok
+	}
+	return tok.AccessToken, nil
+}
+
+// evictExpired drops expired entries so the cache does not grow with every past caller
+// The caller must hold o.mu
+func (o *OnBehalfOf) evictExpired() {
+	now := time.Now()
+	for key, tok := range o.cache {
+		if now.After(tok.Expiry) {
+			delete(o.cache, key)
+		}
+	}
+}
+
+// Middleware exchanges the verified incoming token and stores the downstream token in the
+// request context (TokenFromContext); failures are answered with 502 Bad Gateway
+// Requests without verified claims (e.g. in observe mode) pass through unchanged
+func (o *OnBehalfOf) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := ClaimsFromContext(r.Context()); !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		token, err := o.Token(r.Context())
+		if err != nil {
+			http.Error(w, "downstream credentials unavailable", http.StatusBadGateway)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(ContextWithToken(r.Context(), token)))
+	})
+}
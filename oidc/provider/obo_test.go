@@ -0,0 +1,87 @@
+package oidc_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	oidc "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOnBehalfOfMiddleware(t *testing.T) {
+	iss := newTestIssuer(t)
+	var exchanges []string
+	realm := newFakeKeycloak(t, func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, string(oidc.GrantTokenExchange), r.PostForm.Get("grant_type"))
+		require.Equal(t, "orders-api", r.PostForm.Get("audience"))
+		exchanges = append(exchanges, r.PostForm.Get("subject_token"))
+		writeTokenResponse(w, map[string]interface{}{
+			"access_token": makeJWT(t, map[string]interface{}{"exp": time.Now().Add(5 * time.Minute).Unix(), "sub": "user-1"}),
+			"token_type":   "Bearer",
+		})
+	})
+
+	verifier := oidc.NewVerifier(oidc.VerifierConfig{Issuer: iss.URL, Audience: "gateway"})
+	obo := oidc.NewOnBehalfOf(oidc.NewKeycloakExchanger(&oidc.ConfigKeyCloak{
+		KeycloakRealmURL: realm, KeycloakClientID: "gateway", KeycloakClientSecret: "secret",
+	}, false), "orders-api")
+
+	var downstream []string
+	handler := verifier.Middleware(obo.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := oidc.TokenFromContext(r.Context())
+		require.True(t, ok)
+		downstream = append(downstream, token)
+	})))
+
+	incoming := iss.sign(t, "RS256", "rsa", iss.claims("gateway"))
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/orders", nil)
+		req.Header.Set("Authorization", "Bearer "+incoming)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+	}
+
+	// One exchange per (sub, audience), the downstream token replaces the incoming one
+	require.Equal(t, []string{incoming}, exchanges)
+	require.Len(t, downstream, 2)
+	require.Equal(t, downstream[0], downstream[1])
+	require.NotEqual(t, incoming, downstream[0])
+}
+
+func TestOnBehalfOfRequiresClaims(t *testing.T) {
+	obo := oidc.NewOnBehalfOf(&oidc.STSExchanger{TokenURL: "http://127.0.0.1:0/token"}, "orders-api")
+	_, err := obo.Token(context.Background())
+	require.Error(t, err)
+}
+
+func TestSTSExchangerParameters(t *testing.T) {
+	idToken := validJWT(t)
+	var form map[string][]string
+	realm := newFakeKeycloak(t, func(w http.ResponseWriter, r *http.Request) {
+		form = r.PostForm
+		writeTokenResponse(w, map[string]interface{}{"access_token": idToken, "issued_token_type": oidc.TokenTypeIDToken, "token_type": "N_A"})
+	})
+	ex := &oidc.STSExchanger{
+		TokenURL:           realm + "/protocol/openid-connect/token",
+		ClientID:           "gateway",
+		ClientSecret:       "secret",
+		SubjectTokenType:   oidc.TokenTypeJWT,
+		RequestedTokenType: oidc.TokenTypeIDToken,
+		Resource:           []string{"https://a.example.com", "https://b.example.com"},
+		ActorToken:         "actor",
+	}
+	tok, err := ex.Exchange(context.Background(), "subject", "orders-api")
+	require.NoError(t, err)
+	require.Equal(t, idToken, tok.AccessToken)
+	require.Equal(t, oidc.TokenTypeIDToken, tok.Extra("issued_token_type"))
+	require.Equal(t, []string{oidc.TokenTypeJWT}, form["subject_token_type"])
+	require.Equal(t, []string{oidc.TokenTypeIDToken}, form["requested_token_type"])
+	require.Equal(t, []string{"https://a.example.com", "https://b.example.com"}, form["resource"])
+	require.Equal(t, []string{"actor"}, form["actor_token"])
+	require.Equal(t, []string{oidc.TokenTypeAccessToken}, form["actor_token_type"])
+}
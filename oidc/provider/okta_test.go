@@ -0,0 +1,66 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	oidc "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOktaTokenProvider(t *testing.T) {
+	token := makeJWT(t, map[string]interface{}{"exp": time.Now().Add(time.Hour).Unix(), "aud": "api://orders"})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/default/v1/token", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		user, pass, ok := r.BasicAuth()
+		require.True(t, ok)
+		require.Equal(t, "app", user)
+		require.Equal(t, "secret", pass)
+		require.Equal(t, "client_credentials", r.PostForm.Get("grant_type"))
+		require.Equal(t, "orders.read orders.write", r.PostForm.Get("scope"))
+		writeTokenResponse(w, map[string]interface{}{"access_token": token, "expires_in": 3600})
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	cfg := &oidc.ConfigOkta{
+		IssuerURL:    srv.URL + "/oauth2/default",
+		ClientID:     "app",
+		ClientSecret: "secret",
+		Scopes:       []string{"orders.read"},
+		Audience:     "api://orders",
+	}
+	ctx := oidc.ContextWithScopes(context.Background(), "orders.write")
+
+	t.Run("custom authorization server", func(t *testing.T) {
+		got, err := oidc.NewTokenCache(&oidc.OktaTokenProvider{Config: cfg}).GetValidToken(ctx)
+		require.NoError(t, err)
+		require.Equal(t, token, got)
+	})
+
+	t.Run("audience mismatch", func(t *testing.T) {
+		wrong := *cfg
+		wrong.Audience = "api://billing"
+		_, err := (&oidc.OktaTokenProvider{Config: &wrong}).FetchToken(ctx)
+		var aErr *oidc.ClaimAssertionError
+		require.True(t, errors.As(err, &aErr))
+	})
+
+	t.Run("id token not issued", func(t *testing.T) {
+		idCfg := *cfg
+		idCfg.Token = oidc.TokenKindID
+		_, err := (&oidc.OktaTokenProvider{Config: &idCfg}).FetchToken(ctx)
+		require.ErrorContains(t, err, "id_token")
+	})
+}
+
+func TestConfigOktaTokenURL(t *testing.T) {
+	require.Equal(t, "https://example.okta.com/oauth2/v1/token", (&oidc.ConfigOkta{IssuerURL: "https://example.okta.com/"}).TokenURL())
+	require.Equal(t, "https://example.okta.com/oauth2/aus1/v1/token", (&oidc.ConfigOkta{IssuerURL: "https://example.okta.com/oauth2/aus1"}).TokenURL())
+}
@@ -0,0 +1,25 @@
+package oidc
+
+import "context"
+
+// providerOverrideKey is the context key for a request scoped provider
+type providerOverrideKey struct{}
+
+// WithProviderOverride returns a copy of ctx that makes Transport and Token use provider instead
+// of their configured cache for this call chain, e.g. to act as a specific tenant through a shared client
+// Pass a *TokenCache (or another caching provider) so the override does not fetch a token per request
+func WithProviderOverride(ctx context.Context, provider TokenProvider) context.Context {
+	return context.WithValue(ctx, providerOverrideKey{}, provider)
+}
+
+// ProviderOverride returns the provider set by WithProviderOverride, if any
+func ProviderOverride(ctx context.Context) (TokenProvider, bool) {
+	p, ok := ctx.Value(providerOverrideKey{}).(TokenProvider)
+	return p, ok && p != nil
+}
+
+// FetchToken implements TokenProvider by returning a valid token from the cache,
+// so a cache can be used wherever a provider is expected (e.g. WithProviderOverride)
+func (c *TokenCache) FetchToken(ctx context.Context) (string, error) {
+	return c.GetValidToken(ctx)
+}
@@ -0,0 +1,50 @@
+package oidc_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	oidc "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithProviderOverride(t *testing.T) {
+	var gotAuth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+	}))
+	t.Cleanup(srv.Close)
+
+	shared := oidc.NewTokenCache(&stubProvider{token: validJWT(t)})
+	tenantToken := validJWT(t) + "tenant"
+	tenantProvider := &stubProvider{token: tenantToken}
+	tenant := oidc.NewTokenCache(tenantProvider)
+	client := &http.Client{Transport: &oidc.Transport{Cache: shared}}
+
+	get := func(ctx context.Context) string {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+		require.NoError(t, err)
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return gotAuth
+	}
+
+	sharedToken, err := shared.GetValidToken(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "Bearer "+sharedToken, get(context.Background()))
+
+	ctx := oidc.WithProviderOverride(context.Background(), tenant)
+	for i := 0; i < 2; i++ {
+		require.Equal(t, "Bearer "+tenantToken, get(ctx))
+	}
+	// The override cache is used, not one fetch per request
+	require.EqualValues(t, 1, tenantProvider.calls.Load())
+
+	token, err := oidc.Token(ctx)
+	require.NoError(t, err)
+	require.Equal(t, tenantToken, token)
+}
@@ -0,0 +1,80 @@
+package oidc
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"sync/atomic"
+)
+
+// PanicError is a panic recovered in a background worker, a provider call or a broker handler
+// A malformed token from the IdP that trips a parser must not kill a refresh loop silently, so the
+// panic becomes an error the worker reports and survives
+type PanicError struct {
+	Worker string // e.g. "watch", "prefetch", "fetch:keycloak"
+	Value  interface{}
+	Stack  []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic in %s: %v", e.Worker, e.Value)
+}
+
+// Unwrap returns the panic value when it is an error
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// panicHandler receives every recovered panic, see SetPanicHandler
+var panicHandler atomic.Pointer[func(*PanicError)]
+
+// SetPanicHandler sets the function receiving every recovered panic, e.g. to count them in metrics
+// or report them to an error tracker; nil restores the default, which logs them with slog.Default()
+// The handler must not block, it runs on the worker that panicked
+func SetPanicHandler(h func(*PanicError)) {
+	if h == nil {
+		panicHandler.Store(nil)
+		return
+	}
+	panicHandler.Store(&h)
+}
+
+// reportPanic passes a recovered panic to the panic handler
+func reportPanic(err *PanicError) {
+	if h := panicHandler.Load(); h != nil {
+		(*h)(err)
+		return
+	}
+	slog.Default().LogAttrs(context.Background(), slog.LevelError, "oidc recovered panic",
+		slog.String("worker", err.Worker),
+		slog.String("panic", fmt.Sprint(err.Value)),
+		slog.String("stack", string(err.Stack)))
+}
+
+// Recover converts a panic of the calling function into a *PanicError stored in *errp and reports it
+// to the panic handler; it must be deferred directly: defer oidc.Recover("worker", &err)
+func Recover(worker string, errp *error) {
+	v := recover()
+	if v == nil {
+		return
+	}
+	pErr := &PanicError{Worker: worker, Value: v, Stack: debug.Stack()}
+	reportPanic(pErr)
+	if errp != nil {
+		*errp = pErr
+	}
+}
+
+// protect runs fn, returning a panic of fn as *PanicError
+func protect(worker string, fn func() error) (err error) {
+	defer Recover(worker, &err)
+	return fn()
+}
+
+// safeFetch calls p.FetchToken, returning a panic of the provider as *PanicError
+func safeFetch(ctx context.Context, p TokenProvider) (token string, err error) {
+	defer Recover("fetch:"+providerKind(p), &err)
+	return p.FetchToken(ctx)
+}
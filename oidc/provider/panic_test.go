@@ -0,0 +1,103 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	oidc "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+// panicProvider panics on the first panics calls, e.g. a parser tripping over a malformed token
+type panicProvider struct {
+	panics int32
+	token  string
+	calls  atomic.Int32
+}
+
+func (p *panicProvider) FetchToken(context.Context) (string, error) {
+	if p.calls.Add(1) <= p.panics {
+		var claims map[string]interface{}
+		claims["exp"] = 0 // nil map write
+	}
+	return p.token, nil
+}
+
+// capturePanics installs a panic handler for the test and returns the recovered panics
+func capturePanics(t *testing.T) func() []*oidc.PanicError {
+	var (
+		mu     sync.Mutex
+		panics []*oidc.PanicError
+	)
+	oidc.SetPanicHandler(func(err *oidc.PanicError) {
+		mu.Lock()
+		defer mu.Unlock()
+		panics = append(panics, err)
+	})
+	t.Cleanup(func() { oidc.SetPanicHandler(nil) })
+	return func() []*oidc.PanicError {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]*oidc.PanicError(nil), panics...)
+	}
+}
+
+func TestPanicRecovery(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("fetch panic becomes an error", func(t *testing.T) {
+		panics := capturePanics(t)
+		cache := oidc.NewTokenCache(&panicProvider{panics: 1, token: validJWT(t)})
+
+		_, err := cache.GetValidToken(ctx)
+		var pErr *oidc.PanicError
+		require.ErrorAs(t, err, &pErr)
+		require.Equal(t, "fetch:panicProvider", pErr.Worker)
+		require.NotEmpty(t, pErr.Stack)
+		require.Len(t, panics(), 1)
+
+		// The cache is not left locked, the next fetch succeeds
+		token, err := cache.GetValidToken(ctx)
+		require.NoError(t, err)
+		require.NotEmpty(t, token)
+	})
+
+	t.Run("watch survives a panic", func(t *testing.T) {
+		panics := capturePanics(t)
+		cache := oidc.NewTokenCache(&panicProvider{panics: 1, token: validJWT(t)})
+		watchCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		updates := cache.Watch(watchCtx)
+
+		update := <-updates
+		var pErr *oidc.PanicError
+		require.ErrorAs(t, update.Err, &pErr)
+
+		select {
+		case update = <-updates:
+			require.NoError(t, update.Err)
+			require.NotEmpty(t, update.Token)
+		case <-time.After(5 * time.Second):
+			t.Fatal("watch did not recover from the panic")
+		}
+		require.Len(t, panics(), 1)
+	})
+
+	t.Run("Recover stores the panic", func(t *testing.T) {
+		panics := capturePanics(t)
+		boom := errors.New("boom")
+		run := func() (err error) {
+			defer oidc.Recover("job", &err)
+			panic(boom)
+		}
+		err := run()
+		require.ErrorIs(t, err, boom)
+		require.EqualError(t, err, "panic in job: boom")
+		require.Len(t, panics(), 1)
+	})
+}
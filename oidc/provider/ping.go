@@ -0,0 +1,121 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// ConfigPing holds configuration for PingFederate OAuth clients using the client credentials grant
+// The client authenticates with ClientSecret (client_secret_post) or, when ClientAssertionSigner is set,
+// with a signed client assertion (private_key_jwt); ClientAuthMethod selects another method
+type ConfigPing struct {
+	BaseURL                 string // runtime engine, e.g. https://sso.example.com:9031
+	ClientID                string
+	ClientSecret            string        // client_secret_post
+	ClientAssertionSigner   JWTSigner     // private_key_jwt, takes precedence over ClientSecret
+	ClientAssertionLifetime time.Duration // assertion lifetime, default 2 minutes, also used by ClientAuthSecretJWT
+	// ClientAuthMethod forces a client authentication method, default ClientAuthAuto
+	ClientAuthMethod ClientAuthMethod
+	Scopes           []string
+	// AccessTokenManagerID selects the access token manager issuing the token, default the client's
+	AccessTokenManagerID string
+	Token                TokenKind // default TokenKindAccess, TokenKindID also requests openid
+}
+
+// PingTokenProvider implements TokenProvider for PingFederate
+type PingTokenProvider struct {
+	Config   *ConfigPing
+	Insecure bool
+	OnEvent  EventHandler // optional, receives request, fetched and failed events
+}
+
+// Kind returns the provider kind reported in snapshots
+func (p *PingTokenProvider) Kind() string {
+	return "ping"
+}
+
+// Capabilities reports what the provider supports
+func (p *PingTokenProvider) Capabilities() Capabilities {
+	var kind TokenKind
+	if p.Config != nil {
+		kind = p.Config.Token
+	}
+	// Introspection is not implemented for PingFederate
+	caps := tokenKindCapabilities(kind)
+	caps.Revocation = true
+	return caps
+}
+
+// Validate checks that the base URL, client ID and one client credential are present
+func (c *ConfigPing) Validate() error {
+	if c == nil {
+		return errors.New("PingFederate configuration is nil")
+	}
+	if c.BaseURL == "" || c.ClientID == "" {
+		return errors.New("PingFederate configuration is incomplete: BaseURL and ClientID must be provided")
+	}
+	if err := c.ClientAuthMethod.validate(c.ClientSecret, c.ClientAssertionSigner, nil); err != nil {
+		return fmt.Errorf("PingFederate configuration is invalid: %w", err)
+	}
+	if c.ClientSecret == "" && c.ClientAssertionSigner == nil {
+		return errors.New("PingFederate configuration is incomplete: ClientSecret or ClientAssertionSigner must be provided")
+	}
+	return nil
+}
+
+// TokenURL returns the PingFederate token endpoint, https is assumed when BaseURL has no scheme
+func (c *ConfigPing) TokenURL() string {
+	base := strings.TrimRight(c.BaseURL, "/")
+	if !strings.Contains(base, "://") {
+		base = "https://" + base
+	}
+	return base + "/as/token.oauth2"
+}
+
+// RevocationURL returns the PingFederate revocation endpoint
+func (c *ConfigPing) RevocationURL() string {
+	return strings.TrimSuffix(c.TokenURL(), "/token.oauth2") + "/revoke_token.oauth2"
+}
+
+// FetchToken fetches a new token from PingFederate
+func (p *PingTokenProvider) FetchToken(ctx context.Context) (string, error) {
+	if err := p.Config.Validate(); err != nil {
+		return "", err
+	}
+	scopes := MergeScopes(p.Config.Scopes, ScopesFromContext(ctx))
+	if p.Config.Token == TokenKindID {
+		scopes = MergeScopes(DefaultScopes, scopes)
+	}
+	tokenURL := p.Config.TokenURL()
+	params := url.Values{}
+	if p.Config.AccessTokenManagerID != "" {
+		params.Set("access_token_manager_id", p.Config.AccessTokenManagerID)
+	}
+	conf := &clientcredentials.Config{
+		ClientID:       p.Config.ClientID,
+		ClientSecret:   p.Config.ClientSecret,
+		TokenURL:       tokenURL,
+		Scopes:         scopes,
+		EndpointParams: params,
+		AuthStyle:      oauth2.AuthStyleInParams,
+	}
+	conf, err := p.Config.ClientAuthMethod.apply(conf, p.Config.ClientAssertionSigner, p.Config.ClientAssertionLifetime, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign PingFederate client assertion: %w", err)
+	}
+	return fetchClientCredentials(ctx, clientCredentialsRequest{
+		provider: "ping",
+		idp:      "PingFederate",
+		conf:     conf,
+		client:   NewHTTPClient("ping", p.Insecure),
+		kind:     p.Config.Token,
+		onEvent:  p.OnEvent,
+	})
+}
@@ -0,0 +1,90 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	oidc "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPingTokenProvider(t *testing.T) {
+	token := validJWT(t)
+	idToken := validJWT(t)
+	var srvURL string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/as/token.oauth2", r.URL.Path)
+		require.NoError(t, r.ParseForm())
+		require.Equal(t, "client_credentials", r.PostForm.Get("grant_type"))
+		require.Equal(t, "app", r.PostForm.Get("client_id"))
+		switch {
+		case r.PostForm.Get("client_assertion") != "":
+			require.Equal(t, oidc.ClientAssertionType, r.PostForm.Get("client_assertion_type"))
+			require.Empty(t, r.PostForm.Get("client_secret"))
+			claims := decodeSegment(t, r.PostForm.Get("client_assertion"), 1)
+			require.Equal(t, "app", claims["iss"])
+			require.Equal(t, srvURL+"/as/token.oauth2", claims["aud"])
+			require.NotEmpty(t, claims["jti"])
+		case r.PostForm.Get("client_secret") != "secret":
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error":"invalid_client","error_description":"Invalid client or client credentials."}`))
+			return
+		}
+		resp := map[string]interface{}{"access_token": token, "token_type": "Bearer", "expires_in": 7200}
+		if r.PostForm.Get("scope") == "openid orders" {
+			resp["id_token"] = idToken
+		}
+		require.Equal(t, "atm-1", r.PostForm.Get("access_token_manager_id"))
+		writeTokenResponse(w, resp)
+	}))
+	t.Cleanup(srv.Close)
+	srvURL = srv.URL
+
+	base := oidc.ConfigPing{BaseURL: srv.URL, ClientID: "app", Scopes: []string{"orders"}, AccessTokenManagerID: "atm-1"}
+
+	t.Run("client_secret_post", func(t *testing.T) {
+		cfg := base
+		cfg.ClientSecret = "secret"
+		got, err := oidc.NewTokenCache(&oidc.PingTokenProvider{Config: &cfg}).GetValidToken(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, token, got)
+	})
+
+	t.Run("private_key_jwt id token", func(t *testing.T) {
+		cfg := base
+		cfg.ClientAssertionSigner = newTestSigners(t)["p256"]
+		cfg.Token = oidc.TokenKindID
+		got, err := (&oidc.PingTokenProvider{Config: &cfg}).FetchToken(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, idToken, got)
+	})
+
+	t.Run("client_secret_jwt", func(t *testing.T) {
+		cfg := base
+		cfg.ClientSecret = "secret"
+		cfg.ClientAuthMethod = oidc.ClientAuthSecretJWT
+		got, err := (&oidc.PingTokenProvider{Config: &cfg}).FetchToken(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, token, got)
+	})
+
+	t.Run("invalid client", func(t *testing.T) {
+		cfg := base
+		cfg.ClientSecret = "wrong"
+		_, err := (&oidc.PingTokenProvider{Config: &cfg}).FetchToken(context.Background())
+		var tErr *oidc.TokenError
+		require.True(t, errors.As(err, &tErr))
+		require.Equal(t, "invalid_client", tErr.Code)
+	})
+
+	t.Run("incomplete", func(t *testing.T) {
+		cfg := base
+		_, err := (&oidc.PingTokenProvider{Config: &cfg}).FetchToken(context.Background())
+		require.ErrorContains(t, err, "ClientAssertionSigner")
+	})
+}
@@ -0,0 +1,135 @@
+package oidc
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/oauth2"
+)
+
+// TenantPool provisions one credential per tenant for workers acting on behalf of many tenants
+// (e.g. Temporal activities). Token sources are created on first use with New and reused by
+// every activity of the same tenant; the pool keeps at most MaxTenants sources and evicts the
+// least recently used one, so long-running workers do not accumulate credentials forever.
+// New can build any source: TokenCache.TokenSource for Keycloak or GetGCPTokenSource for Google.
+type TenantPool struct {
+	New        func(ctx context.Context, tenant string) (oauth2.TokenSource, error)
+	MaxTenants int // default 100
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // front is most recently used
+
+	created atomic.Uint64
+	evicted atomic.Uint64
+}
+
+// poolEntry is a tenant source, built once even when many activities ask at the same time
+type poolEntry struct {
+	tenant string
+	once   sync.Once
+	source oauth2.TokenSource
+	err    error
+}
+
+// TenantPoolStats reports pool usage
+type TenantPoolStats struct {
+	Tenants int
+	Created uint64
+	Evicted uint64
+}
+
+// NewTenantPool creates a pool building sources with newSource
+func NewTenantPool(maxTenants int, newSource func(ctx context.Context, tenant string) (oauth2.TokenSource, error)) *TenantPool {
+	return &TenantPool{New: newSource, MaxTenants: maxTenants}
+}
+
+// TokenSource returns the token source of tenant, creating it if needed
+// Failed creations are not cached, the next call tries again
+func (p *TenantPool) TokenSource(ctx context.Context, tenant string) (oauth2.TokenSource, error) {
+	if p.New == nil {
+		return nil, errors.New("tenant pool has no New function")
+	}
+	p.mu.Lock()
+	if p.entries == nil {
+		p.entries = make(map[string]*list.Element)
+		p.lru = list.New()
+	}
+	elem, ok := p.entries[tenant]
+	if ok {
+		p.lru.MoveToFront(elem)
+	} else {
+		elem = p.lru.PushFront(&poolEntry{tenant: tenant})
+		p.entries[tenant] = elem
+		p.evictLocked()
+	}
+	entry := elem.Value.(*poolEntry)
+	p.mu.Unlock()
+
+	entry.once.Do(func() {
+		// Pooled sources outlive the activity that created them, so they must not inherit its cancellation
+		entry.source, entry.err = p.New(context.WithoutCancel(ctx), tenant)
+		if entry.err == nil {
+			p.created.Add(1)
+		}
+	})
+	if entry.err != nil {
+		p.evictEntry(entry)
+		return nil, entry.err
+	}
+	return entry.source, nil
+}
+
+// Token returns a valid token for tenant
+func (p *TenantPool) Token(ctx context.Context, tenant string) (*oauth2.Token, error) {
+	ts, err := p.TokenSource(ctx, tenant)
+	if err != nil {
+		return nil, err
+	}
+	return ts.Token()
+}
+
+// Evict drops the source of tenant, e.g. after its credentials were revoked
+func (p *TenantPool) Evict(tenant string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if elem, ok := p.entries[tenant]; ok {
+		p.lru.Remove(elem)
+		delete(p.entries, tenant)
+	}
+}
+
+// evictEntry drops entry only while it is still the tenant's current one, so a failed creation
+// never removes a newer entry created after an Evict or LRU eviction
+func (p *TenantPool) evictEntry(entry *poolEntry) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if elem, ok := p.entries[entry.tenant]; ok && elem.Value.(*poolEntry) == entry {
+		p.lru.Remove(elem)
+		delete(p.entries, entry.tenant)
+	}
+}
+
+// evictLocked removes least recently used tenants above MaxTenants, the caller must hold p.mu
+func (p *TenantPool) evictLocked() {
+	max := p.MaxTenants
+	if max <= 0 {
+		max = 100
+	}
+	for p.lru.Len() > max {
+		oldest := p.lru.Back()
+		p.lru.Remove(oldest)
+		delete(p.entries, oldest.Value.(*poolEntry).tenant)
+		p.evicted.Add(1)
+	}
+}
+
+// Stats returns the pool usage counters
+func (p *TenantPool) Stats() TenantPoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return TenantPoolStats{Tenants: len(p.entries), Created: p.created.Load(), Evicted: p.evicted.Load()}
+}
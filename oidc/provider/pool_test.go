@@ -0,0 +1,107 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	oidc "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestTenantPool(t *testing.T) {
+	ctx := context.Background()
+	var built atomic.Int32
+	pool := oidc.NewTenantPool(2, func(ctx context.Context, tenant string) (oauth2.TokenSource, error) {
+		built.Add(1)
+		if tenant == "broken" {
+			return nil, errors.New("no credentials for tenant")
+		}
+		return oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token-" + tenant, Expiry: time.Now().Add(time.Hour)}), nil
+	})
+
+	t.Run("one source per tenant under concurrency", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				tok, err := pool.Token(ctx, "acme")
+				require.NoError(t, err)
+				require.Equal(t, "token-acme", tok.AccessToken)
+			}()
+		}
+		wg.Wait()
+		require.EqualValues(t, 1, built.Load())
+	})
+
+	t.Run("least recently used tenant is evicted", func(t *testing.T) {
+		_, err := pool.Token(ctx, "globex")
+		require.NoError(t, err)
+		_, err = pool.Token(ctx, "acme")
+		require.NoError(t, err)
+		_, err = pool.Token(ctx, "initech")
+		require.NoError(t, err)
+		st := pool.Stats()
+		require.Equal(t, 2, st.Tenants)
+		require.EqualValues(t, 1, st.Evicted)
+
+		// globex was evicted and is rebuilt, acme is still pooled
+		before := built.Load()
+		_, err = pool.Token(ctx, "globex")
+		require.NoError(t, err)
+		require.Equal(t, before+1, built.Load())
+	})
+
+	t.Run("failures are not cached", func(t *testing.T) {
+		before := built.Load()
+		for i := 0; i < 2; i++ {
+			_, err := pool.Token(ctx, "broken")
+			require.Error(t, err)
+		}
+		require.Equal(t, before+2, built.Load())
+	})
+}
+
+func TestTenantPoolFailedCreationKeepsNewerEntry(t *testing.T) {
+	ctx := context.Background()
+	release := make(chan struct{})
+	started := make(chan struct{})
+	var built atomic.Int32
+	pool := oidc.NewTenantPool(10, func(ctx context.Context, tenant string) (oauth2.TokenSource, error) {
+		if built.Add(1) == 1 {
+			close(started)
+			<-release
+			return nil, errors.New("revoked credentials")
+		}
+		return oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "fresh"}), nil
+	})
+
+	failed := make(chan error, 1)
+	go func() {
+		_, err := pool.TokenSource(ctx, "acme")
+		failed <- err
+	}()
+	<-started
+
+	// The tenant is evicted and rebuilt while the first creation is still running
+	pool.Evict("acme")
+	tok, err := pool.Token(ctx, "acme")
+	require.NoError(t, err)
+	require.Equal(t, "fresh", tok.AccessToken)
+
+	close(release)
+	require.Error(t, <-failed)
+
+	// The late failure did not drop the newer source
+	tok, err = pool.Token(ctx, "acme")
+	require.NoError(t, err)
+	require.Equal(t, "fresh", tok.AccessToken)
+	require.EqualValues(t, 2, built.Load())
+	require.Equal(t, 1, pool.Stats().Tenants)
+}
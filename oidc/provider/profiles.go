@@ -0,0 +1,162 @@
+package oidc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// ProfileEnv is the environment variable selecting the active profile of a ConfigFile
+const ProfileEnv = "OIDC_PROFILE"
+
+// ErrUnknownProfile is returned when the selected profile is missing from the config file
+var ErrUnknownProfile = errors.New("profile is not defined")
+
+// ConfigFile describes all environments (dev, staging, prod, ...) in one JSON file:
+//
+//	{
+//	  "default_profile": "dev",
+//	  "profiles": {
+//	    "base": {"sources": {"orders": {"Keycloak": {"KeycloakClientID": "orders", "KeycloakClientScopes": ["orders"]}}}},
+//	    "dev":  {"extends": "base", "sources": {"orders": {"Insecure": true, "Keycloak": {"KeycloakRealmURL": "https://kc.dev/realms/pcs"}}}},
+//	    "prod": {"extends": "base", "sources": {"orders": {"Keycloak": {"KeycloakRealmURL": "https://kc.prod/realms/pcs"}}}}
+//	  }
+//	}
+//
+// A profile is a RegistryConfig, field names match the Go fields case-insensitively
+// A profile extending another is merged over it: objects are merged key by key, other values replace
+// the inherited ones and null removes an inherited key (JSON merge patch, RFC 7386); keys are merged by
+// exact spelling, so spell them the same in every profile
+type ConfigFile struct {
+	DefaultProfile string                     `json:"default_profile,omitempty"`
+	Profiles       map[string]json.RawMessage `json:"profiles"`
+}
+
+// LoadConfigFile reads a config file from path
+func LoadConfigFile(path string) (*ConfigFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	return ParseConfigFile(data)
+}
+
+// ParseConfigFile parses the JSON content of a config file
+func ParseConfigFile(data []byte) (*ConfigFile, error) {
+	var f ConfigFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	}
+	if len(f.Profiles) == 0 {
+		return nil, errors.New("config file defines no profiles")
+	}
+	return &f, nil
+}
+
+// ProfileNames returns the defined profile names, sorted
+func (f *ConfigFile) ProfileNames() []string {
+	names := make([]string, 0, len(f.Profiles))
+	for name := range f.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ActiveProfile returns the profile selected by OIDC_PROFILE, or DefaultProfile when it is unset
+func (f *ConfigFile) ActiveProfile() string {
+	if name := strings.TrimSpace(os.Getenv(ProfileEnv)); name != "" {
+		return name
+	}
+	return f.DefaultProfile
+}
+
+// Resolve returns the configuration of the active profile and its name
+func (f *ConfigFile) Resolve() (RegistryConfig, string, error) {
+	name := f.ActiveProfile()
+	if name == "" {
+		return RegistryConfig{}, "", fmt.Errorf("no profile selected: set %s or default_profile", ProfileEnv)
+	}
+	cfg, err := f.Profile(name)
+	return cfg, name, err
+}
+
+// Profile returns the configuration of profile name with its inheritance chain applied
+func (f *ConfigFile) Profile(name string) (RegistryConfig, error) {
+	merged, err := f.resolve(name, nil)
+	if err != nil {
+		return RegistryConfig{}, err
+	}
+	delete(merged, "extends")
+	data, err := json.Marshal(merged)
+	if err != nil {
+		return RegistryConfig{}, err
+	}
+	var cfg RegistryConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return RegistryConfig{}, fmt.Errorf("profile %q: %w", name, err)
+	}
+	return cfg, nil
+}
+
+// resolve merges profile name over the profiles it extends, seen detects cycles
+func (f *ConfigFile) resolve(name string, seen []string) (map[string]interface{}, error) {
+	for _, s := range seen {
+		if s == name {
+			return nil, fmt.Errorf("profile inheritance cycle: %s -> %s", strings.Join(seen, " -> "), name)
+		}
+	}
+	raw, ok := f.Profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownProfile, name)
+	}
+	var profile map[string]interface{}
+	if err := json.Unmarshal(raw, &profile); err != nil {
+		return nil, fmt.Errorf("profile %q: %w", name, err)
+	}
+	parent, _ := profile["extends"].(string)
+	if parent == "" {
+		return profile, nil
+	}
+	base, err := f.resolve(parent, append(seen, name))
+	if err != nil {
+		return nil, err
+	}
+	return mergePatch(base, profile), nil
+}
+
+// mergePatch applies patch over base as a JSON merge patch
+func mergePatch(base, patch map[string]interface{}) map[string]interface{} {
+	if base == nil {
+		base = map[string]interface{}{}
+	}
+	for key, value := range patch {
+		if value == nil {
+			delete(base, key)
+			continue
+		}
+		if obj, ok := value.(map[string]interface{}); ok {
+			if baseObj, ok := base[key].(map[string]interface{}); ok {
+				base[key] = mergePatch(baseObj, obj)
+				continue
+			}
+			base[key] = mergePatch(nil, obj)
+			continue
+		}
+		base[key] = value
+	}
+	return base
+}
+
+// LoadRegistryConfig reads the config file at path and returns the configuration of the active profile
+func LoadRegistryConfig(path string) (RegistryConfig, error) {
+	f, err := LoadConfigFile(path)
+	if err != nil {
+		return RegistryConfig{}, err
+	}
+	cfg, _, err := f.Resolve()
+	return cfg, err
+}
@@ -0,0 +1,91 @@
+package oidc_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	oidc "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+const profilesJSON = `{
+  "default_profile": "dev",
+  "profiles": {
+    "base": {"sources": {
+      "orders": {"Audience": "orders-api", "Keycloak": {"KeycloakClientID": "orders", "KeycloakClientSecret": "base-secret", "KeycloakClientScopes": ["orders"]}},
+      "debug": {"Keycloak": {"KeycloakClientID": "debug", "KeycloakClientSecret": "debug"}}
+    }},
+    "dev": {"extends": "base", "sources": {
+      "orders": {"Insecure": true, "Keycloak": {"KeycloakRealmURL": "https://kc.dev/realms/pcs"}},
+      "debug": {"Keycloak": {"KeycloakRealmURL": "https://kc.dev/realms/pcs"}}
+    }},
+    "staging": {"extends": "dev", "sources": {
+      "orders": {"Insecure": false, "Keycloak": {"KeycloakRealmURL": "https://kc.staging/realms/pcs", "KeycloakClientScopes": ["orders", "audit"]}},
+      "debug": null
+    }},
+    "loop-a": {"extends": "loop-b"},
+    "loop-b": {"extends": "loop-a"}
+  }
+}`
+
+func TestConfigProfiles(t *testing.T) {
+	f, err := oidc.ParseConfigFile([]byte(profilesJSON))
+	require.NoError(t, err)
+
+	t.Run("inheritance and overrides", func(t *testing.T) {
+		cfg, err := f.Profile("staging")
+		require.NoError(t, err)
+		require.Len(t, cfg.Sources, 1, "null removes the inherited source")
+		orders := cfg.Sources["orders"]
+		require.False(t, orders.Insecure)
+		require.Equal(t, "orders-api", orders.Audience)
+		require.Equal(t, "https://kc.staging/realms/pcs", orders.Keycloak.KeycloakRealmURL)
+		require.Equal(t, "base-secret", orders.Keycloak.KeycloakClientSecret)
+		require.Equal(t, []string{"orders", "audit"}, orders.Keycloak.KeycloakClientScopes)
+
+		_, err = oidc.NewRegistry(cfg)
+		require.NoError(t, err)
+	})
+
+	t.Run("active profile from the environment", func(t *testing.T) {
+		t.Setenv(oidc.ProfileEnv, "")
+		cfg, name, err := f.Resolve()
+		require.NoError(t, err)
+		require.Equal(t, "dev", name)
+		require.True(t, cfg.Sources["orders"].Insecure)
+		require.Len(t, cfg.Sources, 2)
+
+		t.Setenv(oidc.ProfileEnv, "staging")
+		_, name, err = f.Resolve()
+		require.NoError(t, err)
+		require.Equal(t, "staging", name)
+
+		t.Setenv(oidc.ProfileEnv, "prod")
+		_, _, err = f.Resolve()
+		require.ErrorIs(t, err, oidc.ErrUnknownProfile)
+	})
+
+	t.Run("LoadRegistryConfig", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "oidc.json")
+		require.NoError(t, os.WriteFile(path, []byte(profilesJSON), 0o600))
+		t.Setenv(oidc.ProfileEnv, "staging")
+		cfg, err := oidc.LoadRegistryConfig(path)
+		require.NoError(t, err)
+		require.Equal(t, "https://kc.staging/realms/pcs", cfg.Sources["orders"].Keycloak.KeycloakRealmURL)
+	})
+
+	t.Run("invalid files", func(t *testing.T) {
+		_, err := f.Profile("loop-a")
+		require.ErrorContains(t, err, "cycle")
+
+		_, err = oidc.ParseConfigFile([]byte(`{"profiles": {}}`))
+		require.Error(t, err)
+
+		_, _, err = (&oidc.ConfigFile{Profiles: f.Profiles}).Resolve()
+		require.ErrorContains(t, err, oidc.ProfileEnv)
+	})
+
+	require.Equal(t, []string{"base", "dev", "loop-a", "loop-b", "staging"}, f.ProfileNames())
+}
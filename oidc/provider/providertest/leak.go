@@ -0,0 +1,107 @@
+// Package providertest provides test helpers for code embedding the oidc providers
+package providertest
+
+import (
+	"bytes"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// modulePrefix identifies stack frames of this module
+const modulePrefix = "github.com/PCS-Indonesia/pcs-oidc/"
+
+// LeakTimeout is how long VerifyNoLeaks waits for goroutines to exit before failing
+var LeakTimeout = 2 * time.Second
+
+// VerifyNoLeaks fails t when goroutines started during the test and running code of this module
+// (cache watches, health probers, usage reporters, ...) are still alive once the test finished
+// Call it at the start of the test, before creating providers, so its check runs after all
+// other cleanups; close everything (e.g. Manager.CloseAll) before the test returns
+func VerifyNoLeaks(t testing.TB) {
+	t.Helper()
+	before := Snapshot()
+	t.Cleanup(func() {
+		deadline := time.Now().Add(LeakTimeout)
+		for {
+			leaked := Leaked(before)
+			if len(leaked) == 0 {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Errorf("%d goroutine(s) leaked:\n\n%s", len(leaked), strings.Join(leaked, "\n\n"))
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+}
+
+// Leaked returns the stacks of goroutines running code of this module that are not in before
+// Goroutines of test packages themselves are ignored
+func Leaked(before map[string]bool) []string {
+	var leaked []string
+	for _, g := range goroutines() {
+		id := goroutineID(g)
+		if before[id] || !ownedByModule(g) {
+			continue
+		}
+		leaked = append(leaked, g)
+	}
+	return leaked
+}
+
+// Snapshot returns the IDs of all running goroutines, for use with Leaked
+func Snapshot() map[string]bool {
+	ids := map[string]bool{}
+	for _, g := range goroutines() {
+		ids[goroutineID(g)] = true
+	}
+	return ids
+}
+
+// goroutines returns the stack of every goroutine
+func goroutines() []string {
+	buf := make([]byte, 1<<16)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	return strings.Split(string(bytes.TrimSpace(buf)), "\n\n")
+}
+
+// goroutineID extracts N from the "goroutine N [state]:" header
+func goroutineID(stack string) string {
+	header, _, _ := strings.Cut(stack, "\n")
+	fields := strings.Fields(header)
+	if len(fields) < 2 {
+		return ""
+	}
+	return fields[1]
+}
+
+// ownedByModule reports whether a frame or the creator of the goroutine is a non-test package of this module
+func ownedByModule(stack string) bool {
+	for _, line := range strings.Split(stack, "\n") {
+		if strings.HasPrefix(line, "\t") {
+			continue // file:line
+		}
+		fn := strings.TrimPrefix(line, "created by ")
+		if !strings.HasPrefix(fn, modulePrefix) {
+			continue
+		}
+		pkg := fn[len(modulePrefix):]
+		if i := strings.Index(pkg, "."); i >= 0 {
+			pkg = pkg[:i]
+		}
+		if !strings.HasSuffix(pkg, "_test") && !strings.HasSuffix(pkg, "providertest") {
+			return true
+		}
+	}
+	return false
+}
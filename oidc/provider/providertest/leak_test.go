@@ -0,0 +1,51 @@
+package providertest_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+
+	oidc "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+	"github.com/PCS-Indonesia/pcs-oidc/oidc/provider/providertest"
+
+	"github.com/stretchr/testify/require"
+)
+
+type staticProvider struct{ token string }
+
+func (s staticProvider) FetchToken(ctx context.Context) (string, error) { return s.token, nil }
+
+func testToken(t *testing.T) string {
+	payload, err := json.Marshal(map[string]interface{}{"exp": time.Now().Add(time.Hour).Unix()})
+	require.NoError(t, err)
+	return "e30." + base64.RawURLEncoding.EncodeToString(payload) + ".sig"
+}
+
+func TestLeaked(t *testing.T) {
+	before := providertest.Snapshot()
+	cache := oidc.NewTokenCache(staticProvider{token: testToken(t)})
+	updates := cache.Watch(context.Background())
+	<-updates
+
+	leaked := providertest.Leaked(before)
+	require.Len(t, leaked, 1)
+	require.Contains(t, leaked[0], "(*TokenCache).watch")
+
+	require.NoError(t, cache.Close())
+	require.Eventually(t, func() bool { return len(providertest.Leaked(before)) == 0 }, time.Second, 10*time.Millisecond)
+}
+
+func TestVerifyNoLeaks(t *testing.T) {
+	providertest.VerifyNoLeaks(t)
+	m := oidc.NewManager()
+	for _, name := range []string{"orders", "billing"} {
+		cache := oidc.NewTokenCache(staticProvider{token: testToken(t)})
+		require.NoError(t, m.Add(oidc.ManagedCredential{Name: name, Cache: cache}))
+		<-cache.Watch(context.Background())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, m.CloseAll(ctx))
+}
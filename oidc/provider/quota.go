@@ -0,0 +1,177 @@
+package oidc
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// quotaWindow is the length of the sliding window quotas are counted over
+const quotaWindow = time.Minute
+
+// Quota tracks token requests per key (tenant or provider) and optionally limits them per minute,
+// so one noisy tenant cannot starve a shared IdP. Requests are counted when they are sent,
+// failed ones included, as they load the IdP just the same
+// A zero limit only tracks usage
+type Quota struct {
+	PerMinute int            // default limit per key, 0 is unlimited
+	Limits    map[string]int // per key overrides of PerMinute, 0 is unlimited
+
+	mu   sync.Mutex
+	keys map[string]*quotaKey
+}
+
+// quotaKey holds the sliding window and counters of one key
+type quotaKey struct {
+	sent     []time.Time // request times within the window, oldest first
+	requests uint64
+	issued   uint64
+	rejected uint64
+}
+
+// QuotaUsage reports the token requests of one key
+type QuotaUsage struct {
+	Key      string
+	Limit    int    // tokens per minute, 0 is unlimited
+	LastMin  int    // requests within the last minute
+	Requests uint64 // requests sent to the IdP
+	Issued   uint64 // requests that returned a token
+	Rejected uint64 // requests refused by the quota
+}
+
+// QuotaExceededError is returned instead of contacting the IdP when a key used up its quota
+type QuotaExceededError struct {
+	Key        string
+	Limit      int
+	RetryAfter time.Duration // until the oldest request leaves the window
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("token quota exceeded for %q: %d tokens/minute, retry after %s", e.Key, e.Limit, e.RetryAfter.Round(time.Millisecond))
+}
+
+// Temporary reports that the request may succeed later
+func (e *QuotaExceededError) Temporary() bool {
+	return true
+}
+
+// NewQuota creates a quota limiting every key to perMinute token requests
+func NewQuota(perMinute int) *Quota {
+	return &Quota{PerMinute: perMinute}
+}
+
+// limit returns the limit of key, the caller must hold q.mu
+func (q *Quota) limit(key string) int {
+	if l, ok := q.Limits[key]; ok {
+		return l
+	}
+	return q.PerMinute
+}
+
+// entry returns the state of key, creating it, and drops requests outside the window
+// The caller must hold q.mu
+func (q *Quota) entry(key string, now time.Time) *quotaKey {
+	if q.keys == nil {
+		q.keys = make(map[string]*quotaKey)
+	}
+	k, ok := q.keys[key]
+	if !ok {
+		k = &quotaKey{}
+		q.keys[key] = k
+	}
+	cutoff := now.Add(-quotaWindow)
+	i := 0
+	for i < len(k.sent) && !k.sent[i].After(cutoff) {
+		i++
+	}
+	k.sent = k.sent[i:]
+	return k
+}
+
+// Reserve counts a token request for key, or returns a *QuotaExceededError when key is over its limit
+func (q *Quota) Reserve(key string) error {
+	now := time.Now()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	k := q.entry(key, now)
+	if limit := q.limit(key); limit > 0 && len(k.sent) >= limit {
+		k.rejected++
+		return &QuotaExceededError{Key: key, Limit: limit, RetryAfter: k.sent[0].Add(quotaWindow).Sub(now)}
+	}
+	k.sent = append(k.sent, now)
+	k.requests++
+	return nil
+}
+
+// issued records that a reserved request of key returned a token
+func (q *Quota) issued(key string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.entry(key, time.Now()).issued++
+}
+
+// Usage returns the usage of every key seen so far, sorted by key
+func (q *Quota) Usage() []QuotaUsage {
+	now := time.Now()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	out := make([]QuotaUsage, 0, len(q.keys))
+	for key := range q.keys {
+		k := q.entry(key, now)
+		out = append(out, QuotaUsage{
+			Key:      key,
+			Limit:    q.limit(key),
+			LastMin:  len(k.sent),
+			Requests: k.requests,
+			Issued:   k.issued,
+			Rejected: k.rejected,
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out
+}
+
+// Provider wraps p so its token requests are counted and limited under key
+// In a TenantPool, wrap each tenant's provider before caching it:
+//
+//	NewTokenCache(quota.Provider(tenant, provider)).TokenSource(ctx)
+func (q *Quota) Provider(key string, p TokenProvider) *QuotaProvider {
+	return &QuotaProvider{Provider: p, Quota: q, Key: key}
+}
+
+// QuotaProvider is a TokenProvider whose requests are limited by a shared Quota
+type QuotaProvider struct {
+	Provider TokenProvider
+	Quota    *Quota
+	Key      string // tenant or provider name the requests are accounted to
+}
+
+// FetchToken fetches a token if the key has quota left
+func (p *QuotaProvider) FetchToken(ctx context.Context) (string, error) {
+	if err := p.Quota.Reserve(p.Key); err != nil {
+		return "", err
+	}
+	token, err := p.Provider.FetchToken(ctx)
+	if err != nil {
+		return "", err
+	}
+	p.Quota.issued(p.Key)
+	return token, nil
+}
+
+// Kind returns the kind of the wrapped provider
+func (p *QuotaProvider) Kind() string {
+	return providerKind(p.Provider)
+}
+
+// Capabilities returns the capabilities of the wrapped provider
+func (p *QuotaProvider) Capabilities() Capabilities {
+	return CapabilitiesOf(p.Provider)
+}
+
+// Revoke revokes token through the wrapped provider
+func (p *QuotaProvider) Revoke(ctx context.Context, token, tokenTypeHint string) error {
+	return revokeWith(ctx, p.Provider, token, tokenTypeHint)
+}
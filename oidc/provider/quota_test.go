@@ -0,0 +1,65 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	oidc "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuota(t *testing.T) {
+	quota := oidc.NewQuota(2)
+	quota.Limits = map[string]int{"vip": 0}
+	ctx := context.Background()
+
+	noisy := &stubProvider{token: validJWT(t)}
+	quiet := &stubProvider{token: validJWT(t)}
+	vip := &stubProvider{token: validJWT(t)}
+
+	for i := 0; i < 2; i++ {
+		_, err := quota.Provider("noisy", noisy).FetchToken(ctx)
+		require.NoError(t, err)
+	}
+	_, err := quota.Provider("noisy", noisy).FetchToken(ctx)
+	var qErr *oidc.QuotaExceededError
+	require.True(t, errors.As(err, &qErr))
+	require.Equal(t, "noisy", qErr.Key)
+	require.Equal(t, 2, qErr.Limit)
+	require.InDelta(t, time.Minute.Seconds(), qErr.RetryAfter.Seconds(), 1)
+	require.EqualValues(t, 2, noisy.calls.Load(), "rejected requests must not reach the IdP")
+
+	// Other tenants are not affected, unlimited keys are only tracked
+	_, err = quota.Provider("quiet", quiet).FetchToken(ctx)
+	require.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		_, err = quota.Provider("vip", vip).FetchToken(ctx)
+		require.NoError(t, err)
+	}
+
+	quiet.err = errors.New("idp down")
+	_, err = quota.Provider("quiet", quiet).FetchToken(ctx)
+	require.Error(t, err)
+
+	require.Equal(t, []oidc.QuotaUsage{
+		{Key: "noisy", Limit: 2, LastMin: 2, Requests: 2, Issued: 2, Rejected: 1},
+		{Key: "quiet", Limit: 2, LastMin: 2, Requests: 2, Issued: 1},
+		{Key: "vip", LastMin: 5, Requests: 5, Issued: 5},
+	}, quota.Usage())
+}
+
+func TestQuotaRetryAfter(t *testing.T) {
+	quota := oidc.NewQuota(1)
+	p := oidc.WithRetry(quota.Provider("tenant", &stubProvider{token: validJWT(t)}), oidc.RetryPolicy{MaxDelay: time.Second})
+	_, err := p.FetchToken(context.Background())
+	require.NoError(t, err)
+	// The quota asks to wait close to a minute, longer than MaxDelay, so the error is returned at once
+	start := time.Now()
+	_, err = p.FetchToken(context.Background())
+	var qErr *oidc.QuotaExceededError
+	require.True(t, errors.As(err, &qErr))
+	require.Less(t, time.Since(start), time.Second)
+}
@@ -0,0 +1,80 @@
+package oidc
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// RefreshRamp spreads token refreshes over a time window after an IdP outage ends
+// Without it every cached token that expired during the outage refreshes at the same
+// moment the IdP comes back, which can knock it over again
+//
+// Caches report fetch outcomes to the ramp (see WithRefreshRamp); the first success after
+// a failure marks the IdP as restored and opens the window. Refreshes inside the window wait
+// a random delay within the remaining window, so traffic ramps up instead of spiking.
+type RefreshRamp struct {
+	Window time.Duration
+
+	mu         sync.Mutex
+	failing    bool
+	restoredAt time.Time
+	rnd        *rand.Rand
+}
+
+// NewRefreshRamp creates a ramp spreading refreshes over window after an outage
+func NewRefreshRamp(window time.Duration) *RefreshRamp {
+	return &RefreshRamp{Window: window, rnd: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+// ReportFailure records a failed token fetch, marking the IdP as unavailable
+func (r *RefreshRamp) ReportFailure() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failing = true
+}
+
+// ReportSuccess records a successful token fetch, opening the ramp window if the IdP was failing
+func (r *RefreshRamp) ReportSuccess() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.failing {
+		r.failing = false
+		r.restoredAt = time.Now()
+	}
+}
+
+// Delay returns how long a refresh should wait, zero outside the ramp window
+func (r *RefreshRamp) Delay() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.restoredAt.IsZero() || r.Window <= 0 {
+		return 0
+	}
+	remaining := r.Window - time.Since(r.restoredAt)
+	if remaining <= 0 {
+		r.restoredAt = time.Time{}
+		return 0
+	}
+	if r.rnd == nil {
+		r.rnd = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	return time.Duration(r.rnd.Int63n(int64(remaining)))
+}
+
+// Wait blocks for the ramp delay or until ctx is done
+func (r *RefreshRamp) Wait(ctx context.Context) error {
+	d := r.Delay()
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,74 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	oidc "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRefreshRamp(t *testing.T) {
+	t.Run("no delay without outage", func(t *testing.T) {
+		ramp := oidc.NewRefreshRamp(time.Minute)
+		ramp.ReportSuccess()
+		require.Zero(t, ramp.Delay())
+	})
+
+	t.Run("delay within window after restore", func(t *testing.T) {
+		ramp := oidc.NewRefreshRamp(time.Minute)
+		ramp.ReportFailure()
+		require.Zero(t, ramp.Delay(), "no ramp while the IdP is still failing")
+		ramp.ReportSuccess()
+		for i := 0; i < 50; i++ {
+			d := ramp.Delay()
+			require.GreaterOrEqual(t, d, time.Duration(0))
+			require.Less(t, d, time.Minute)
+		}
+	})
+
+	t.Run("window closes", func(t *testing.T) {
+		ramp := oidc.NewRefreshRamp(20 * time.Millisecond)
+		ramp.ReportFailure()
+		ramp.ReportSuccess()
+		time.Sleep(30 * time.Millisecond)
+		require.Zero(t, ramp.Delay())
+	})
+}
+
+func TestTokenCacheReportsToRamp(t *testing.T) {
+	ramp := oidc.NewRefreshRamp(50 * time.Millisecond)
+	provider := &stubProvider{err: errors.New("idp down")}
+	cache := oidc.NewTokenCache(provider, oidc.WithRefreshRamp(ramp))
+
+	_, err := cache.GetValidToken(context.Background())
+	require.Error(t, err)
+
+	// IdP is back: the first refresh opens the window, later ones are spread within it
+	provider.err = nil
+	provider.token = validJWT(t)
+	_, err = cache.GetValidToken(context.Background())
+	require.NoError(t, err)
+
+	other := oidc.NewTokenCache(provider, oidc.WithRefreshRamp(ramp))
+	start := time.Now()
+	_, err = other.GetValidToken(context.Background())
+	require.NoError(t, err)
+	require.Less(t, time.Since(start), 100*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	ramp.ReportFailure()
+	ramp.ReportSuccess()
+	cancelled := oidc.NewTokenCache(provider, oidc.WithRefreshRamp(ramp))
+	for i := 0; i < 10; i++ {
+		if _, err = cancelled.GetValidToken(ctx); err != nil {
+			break
+		}
+		cancelled.ForceExpire(time.Now().Add(-time.Hour))
+	}
+	require.ErrorIs(t, err, context.Canceled)
+}
@@ -0,0 +1,197 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrUnknownRealm is returned by RealmManager for a realm missing from its configuration
+var ErrUnknownRealm = errors.New("realm is not configured")
+
+// RealmCredentials are the client credentials used for one realm of a RealmConfig
+type RealmCredentials struct {
+	ClientID     string
+	ClientSecret string
+	Scopes       []string // extra scopes, "openid" is always requested
+	Audience     string   // optional, see ConfigKeyCloak.Audience
+}
+
+// RealmConfig is the single configuration block of a RealmManager
+// ServerURL is the Keycloak base URL, e.g. https://keycloak.example.com (add /auth for Keycloak < 17)
+type RealmConfig struct {
+	ServerURL string
+	Realms    map[string]RealmCredentials
+	Insecure  bool // skip TLS verification (development only)
+}
+
+// RealmURL returns the URL of realm on the Keycloak server at serverURL
+func RealmURL(serverURL, realm string) string {
+	return strings.TrimRight(serverURL, "/") + "/realms/" + url.PathEscape(realm)
+}
+
+// NormalizeRealmURL returns the canonical form of a Keycloak realm URL, https://host[/auth]/realms/<realm>
+// It accepts a trailing slash, a missing scheme (https is assumed), the legacy /auth prefix of Keycloak
+// < 17, host+realm name (https://host/<realm>, https://host/auth/<realm>) and pasted endpoint URLs such as
+// the token endpoint or discovery document, which are cut after the realm
+// URLs without a /realms/ segment that match none of these forms are returned without a trailing slash
+func NormalizeRealmURL(raw string) (string, error) {
+	s := strings.TrimSpace(raw)
+	if s == "" {
+		return "", errors.New("realm URL is empty")
+	}
+	if !strings.Contains(s, "://") {
+		s = "https://" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", fmt.Errorf("invalid realm URL %q: %w", raw, err)
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("invalid realm URL %q: no host", raw)
+	}
+	u.RawQuery, u.Fragment, u.RawPath = "", "", ""
+	var segments []string
+	for _, seg := range strings.Split(u.Path, "/") {
+		if seg != "" {
+			segments = append(segments, seg)
+		}
+	}
+	realm := -1
+	for i, seg := range segments {
+		if seg == "realms" && i+1 < len(segments) {
+			realm = i + 1
+			break
+		}
+	}
+	switch {
+	case realm >= 0:
+		segments = segments[:realm+1]
+	case len(segments) == 0, segments[len(segments)-1] == "realms", len(segments) == 1 && segments[0] == "auth":
+		return "", fmt.Errorf("realm URL %q names no realm, expected https://host/realms/<realm>", raw)
+	case len(segments) == 1:
+		segments = []string{"realms", segments[0]}
+	case len(segments) == 2 && segments[0] == "auth":
+		segments = []string{"auth", "realms", segments[1]}
+	}
+	u.Path = "/" + strings.Join(segments, "/")
+	return u.String(), nil
+}
+
+// RealmManager holds a KeycloakTokenProvider and TokenCache per realm for multi-tenant services
+// Providers and caches are created on first use of a realm and shared afterwards
+// Every created cache is registered with Manager under the realm name for snapshots
+type RealmManager struct {
+	caches *keyedCaches
+
+	mu        sync.Mutex
+	serverURL string
+	insecure  bool
+	realms    map[string]RealmCredentials
+}
+
+// NewRealmManager validates cfg and creates a manager, options are applied to every realm cache
+// With WithStore every realm persists its token under the store key followed by ":<realm>"
+func NewRealmManager(cfg RealmConfig, opts ...CacheOption) (*RealmManager, error) {
+	if cfg.ServerURL == "" {
+		return nil, errors.New("realm configuration is incomplete: ServerURL must be provided")
+	}
+	m := &RealmManager{
+		caches:    newKeyedCaches(opts),
+		serverURL: cfg.ServerURL,
+		insecure:  cfg.Insecure,
+		realms:    map[string]RealmCredentials{},
+	}
+	for name, creds := range cfg.Realms {
+		if err := validateRealm(name, creds); err != nil {
+			return nil, err
+		}
+		m.realms[name] = creds
+	}
+	return m, nil
+}
+
+func validateRealm(name string, creds RealmCredentials) error {
+	if name == "" {
+		return errors.New("realm name must not be empty")
+	}
+	if creds.ClientID == "" || creds.ClientSecret == "" {
+		return fmt.Errorf("realm %q: ClientID and ClientSecret must be provided", name)
+	}
+	return nil
+}
+
+// SetRealm adds or replaces the credentials of a realm, e.g. when a tenant is onboarded
+// A replaced realm gets a new provider and cache on its next use, its old cache is closed
+func (m *RealmManager) SetRealm(name string, creds RealmCredentials) error {
+	if err := validateRealm(name, creds); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.realms[name] = creds
+	m.caches.remove(name)
+	return nil
+}
+
+// RemoveRealm drops a realm and closes its cache
+func (m *RealmManager) RemoveRealm(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.realms, name)
+	m.caches.remove(name)
+}
+
+// Realms returns the configured realm names, sorted
+func (m *RealmManager) Realms() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	names := make([]string, 0, len(m.realms))
+	for name := range m.realms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Cache returns the cache of realm, creating its provider and cache on first use
+func (m *RealmManager) Cache(realm string) (*TokenCache, error) {
+	// Held while the cache is created, so a concurrent SetRealm cannot leave a cache with old credentials
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.caches.get(realm, func() (TokenProvider, string, error) {
+		creds, ok := m.realms[realm]
+		if !ok {
+			return nil, "", fmt.Errorf("%w: %q", ErrUnknownRealm, realm)
+		}
+		provider := &KeycloakTokenProvider{
+			Config: &ConfigKeyCloak{
+				KeycloakRealmURL:     RealmURL(m.serverURL, realm),
+				KeycloakClientID:     creds.ClientID,
+				KeycloakClientSecret: creds.ClientSecret,
+				KeycloakClientScopes: creds.Scopes,
+				Audience:             creds.Audience,
+			},
+			Insecure: m.insecure,
+		}
+		return provider, creds.Audience, nil
+	})
+}
+
+// Token returns a valid token for realm
+func (m *RealmManager) Token(ctx context.Context, realm string) (string, error) {
+	cache, err := m.Cache(realm)
+	if err != nil {
+		return "", err
+	}
+	return cache.GetValidToken(ctx)
+}
+
+// Manager returns the manager holding the realm caches created so far
+func (m *RealmManager) Manager() *Manager {
+	return m.caches.manager
+}
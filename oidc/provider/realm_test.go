@@ -0,0 +1,155 @@
+package oidc_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	oidc "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRealmManager(t *testing.T) {
+	ctx := context.Background()
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		require.NoError(t, r.ParseForm())
+		realm := strings.Split(strings.TrimPrefix(r.URL.Path, "/realms/"), "/")[0]
+		clientID, _, _ := r.BasicAuth()
+		if clientID == "" {
+			clientID = r.PostForm.Get("client_id")
+		}
+		require.Equal(t, realm+"-gateway", clientID)
+		writeTokenResponse(w, map[string]interface{}{"access_token": "at", "id_token": validJWT(t)})
+	}))
+	t.Cleanup(srv.Close)
+
+	m, err := oidc.NewRealmManager(oidc.RealmConfig{
+		ServerURL: srv.URL + "/",
+		Realms: map[string]oidc.RealmCredentials{
+			"tenant-a": {ClientID: "tenant-a-gateway", ClientSecret: "a"},
+			"tenant-b": {ClientID: "tenant-b-gateway", ClientSecret: "b"},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"tenant-a", "tenant-b"}, m.Realms())
+	require.Empty(t, m.Manager().Snapshot())
+
+	for i := 0; i < 2; i++ {
+		_, err = m.Token(ctx, "tenant-a")
+		require.NoError(t, err)
+	}
+	require.EqualValues(t, 1, calls.Load())
+	a1, err := m.Cache("tenant-a")
+	require.NoError(t, err)
+	require.Len(t, m.Manager().Snapshot(), 1)
+
+	_, err = m.Token(ctx, "tenant-c")
+	require.ErrorIs(t, err, oidc.ErrUnknownRealm)
+
+	require.NoError(t, m.SetRealm("tenant-c", oidc.RealmCredentials{ClientID: "tenant-c-gateway", ClientSecret: "c"}))
+	_, err = m.Token(ctx, "tenant-c")
+	require.NoError(t, err)
+
+	// Replaced credentials get a new cache, the old one is closed
+	updates := a1.Watch(ctx)
+	<-updates
+	require.NoError(t, m.SetRealm("tenant-a", oidc.RealmCredentials{ClientID: "tenant-a-gateway", ClientSecret: "rotated"}))
+	a2, err := m.Cache("tenant-a")
+	require.NoError(t, err)
+	require.NotSame(t, a1, a2)
+	_, open := <-updates
+	require.False(t, open)
+
+	m.RemoveRealm("tenant-b")
+	require.Equal(t, []string{"tenant-a", "tenant-c"}, m.Realms())
+
+	// Every realm persists its token under its own store key
+	store, err := oidc.NewFileCacheStore(t.TempDir())
+	require.NoError(t, err)
+	persisted, err := oidc.NewRealmManager(oidc.RealmConfig{
+		ServerURL: srv.URL,
+		Realms: map[string]oidc.RealmCredentials{
+			"tenant-a": {ClientID: "tenant-a-gateway", ClientSecret: "a"},
+			"tenant-b": {ClientID: "tenant-b-gateway", ClientSecret: "b"},
+		},
+	}, oidc.WithStore(store, "gateway"))
+	require.NoError(t, err)
+	for _, realm := range []string{"tenant-a", "tenant-b"} {
+		_, err = persisted.Token(ctx, realm)
+		require.NoError(t, err)
+		stored, err := store.Load(ctx, "gateway:"+realm)
+		require.NoError(t, err)
+		require.NotEmpty(t, stored.Token)
+	}
+	_, err = store.Load(ctx, "gateway")
+	require.Error(t, err)
+
+	_, err = oidc.NewRealmManager(oidc.RealmConfig{ServerURL: srv.URL, Realms: map[string]oidc.RealmCredentials{"x": {ClientID: "x"}}})
+	require.ErrorContains(t, err, "ClientSecret")
+	require.Equal(t, "https://kc/auth/realms/my%20realm", oidc.RealmURL("https://kc/auth/", "my realm"))
+}
+
+func TestNormalizeRealmURL(t *testing.T) {
+	valid := map[string]string{
+		"https://kc.example.com/realms/pcs":                                        "https://kc.example.com/realms/pcs",
+		"https://kc.example.com/realms/pcs/":                                       "https://kc.example.com/realms/pcs",
+		" https://kc.example.com/realms/pcs// ":                                    "https://kc.example.com/realms/pcs",
+		"kc.example.com/realms/pcs":                                                "https://kc.example.com/realms/pcs",
+		"https://kc.example.com/auth/realms/pcs/":                                  "https://kc.example.com/auth/realms/pcs",
+		"https://kc.example.com/pcs":                                               "https://kc.example.com/realms/pcs",
+		"https://kc.example.com/auth/pcs":                                          "https://kc.example.com/auth/realms/pcs",
+		"http://localhost:8080/realms/pcs/protocol/openid-connect/token":           "http://localhost:8080/realms/pcs",
+		"https://kc.example.com/realms/pcs/.well-known/openid-configuration?x=1#y": "https://kc.example.com/realms/pcs",
+		"https://kc.example.com/realms/my%20realm":                                 "https://kc.example.com/realms/my%20realm",
+		"https://gateway.example.com/sso/keycloak/tenant/":                         "https://gateway.example.com/sso/keycloak/tenant",
+	}
+	for raw, want := range valid {
+		got, err := oidc.NormalizeRealmURL(raw)
+		require.NoError(t, err, raw)
+		require.Equal(t, want, got, raw)
+	}
+	for _, raw := range []string{"", "https://kc.example.com", "https://kc.example.com/", "https://kc.example.com/auth", "https://kc.example.com/realms/", "https:///realms/pcs"} {
+		_, err := oidc.NormalizeRealmURL(raw)
+		require.Error(t, err, raw)
+	}
+}
+
+func TestRealmURLVariants(t *testing.T) {
+	ctx := context.Background()
+	idToken := validJWT(t)
+	realm := newFakeKeycloak(t, func(w http.ResponseWriter, r *http.Request) {
+		writeTokenResponse(w, map[string]interface{}{"access_token": "at", "id_token": idToken})
+	})
+	fetch := func(realmURL string) error {
+		p := &oidc.KeycloakTokenProvider{Config: &oidc.ConfigKeyCloak{KeycloakRealmURL: realmURL, KeycloakClientID: "c", KeycloakClientSecret: "s"}}
+		_, err := p.FetchToken(ctx)
+		return err
+	}
+
+	t.Run("trailing slash", func(t *testing.T) {
+		require.NoError(t, fetch(realm+"/"))
+	})
+
+	t.Run("pasted token endpoint", func(t *testing.T) {
+		require.NoError(t, fetch(realm+"/protocol/openid-connect/token"))
+	})
+
+	t.Run("wrong realm names the realm URL", func(t *testing.T) {
+		err := fetch(strings.TrimSuffix(realm, "/realms/test") + "/auth/realms/test")
+		require.ErrorContains(t, err, "status 404")
+		require.ErrorContains(t, err, "KeycloakRealmURL")
+	})
+
+	t.Run("missing realm is rejected by Validate", func(t *testing.T) {
+		cfg := &oidc.ConfigKeyCloak{KeycloakRealmURL: "https://kc.example.com/", KeycloakClientID: "c", KeycloakClientSecret: "s"}
+		require.ErrorContains(t, cfg.Validate(), "names no realm")
+		require.Equal(t, "https://kc.example.com/realms/pcs/protocol/openid-connect/token",
+			(&oidc.ConfigKeyCloak{KeycloakRealmURL: "kc.example.com/pcs/"}).TokenURL())
+	})
+}
@@ -0,0 +1,60 @@
+package oidc
+
+import "time"
+
+// RefreshStrategy decides when a token issued at issuedAt and expiring at expiry is refreshed
+// issuedAt is the local time of the token's iat claim, or the time it was received without one
+type RefreshStrategy func(issuedAt, expiry time.Time) time.Time
+
+// RefreshBefore refreshes a fixed duration before expiry, the default is RefreshBefore(time.Minute)
+func RefreshBefore(d time.Duration) RefreshStrategy {
+	return func(issuedAt, expiry time.Time) time.Time {
+		return expiry.Add(-d)
+	}
+}
+
+// RefreshAtFraction refreshes once fraction of the lifetime has passed, e.g. 0.8 refreshes a 60s
+// STS token after 48s where the one minute default buffer would refetch it on every call
+// Without a known issue time the one minute default buffer is used
+func RefreshAtFraction(fraction float64) RefreshStrategy {
+	return func(issuedAt, expiry time.Time) time.Time {
+		if issuedAt.IsZero() || !issuedAt.Before(expiry) || fraction <= 0 || fraction > 1 {
+			return expiry.Add(-defaultRefreshBuffer)
+		}
+		lifetime := expiry.Sub(issuedAt)
+		return issuedAt.Add(time.Duration(float64(lifetime) * fraction))
+	}
+}
+
+// WithRefreshStrategy replaces the fixed one minute refresh buffer with strategy
+// The strict minimum of WithMinRemaining still applies on top of it
+func WithRefreshStrategy(strategy RefreshStrategy) CacheOption {
+	return func(c *TokenCache) {
+		c.strategy = strategy
+	}
+}
+
+// refreshAt returns when the cached token is due for refresh, the caller must hold c.mu
+func (c *TokenCache) refreshAt() time.Time {
+	if c.short != nil {
+		return c.shortLivedRefreshAt()
+	}
+	if c.strategy == nil {
+		return c.expiry.Add(-c.refreshBuffer())
+	}
+	at := c.strategy(c.issuedAt, c.expiry)
+	if latest := c.expiry.Add(-c.minRemaining); c.minRemaining > 0 && at.After(latest) {
+		at = latest
+	}
+	return at
+}
+
+// nextRefresh returns when the cached token is due for refresh, or for a prefetch in short-lived mode
+func (c *TokenCache) nextRefresh() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.short != nil && c.prefetching == nil && c.prefetchAt.Before(c.refreshAt()) {
+		return c.prefetchAt
+	}
+	return c.refreshAt()
+}
@@ -0,0 +1,66 @@
+package oidc_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	oidc "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRefreshStrategy(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	// A 60s STS style token issued 10s ago
+	shortLived := makeJWT(t, map[string]interface{}{"iat": now.Add(-10 * time.Second).Unix(), "exp": now.Add(50 * time.Second).Unix()})
+
+	t.Run("fixed buffer refetches short-lived tokens", func(t *testing.T) {
+		p := &stubProvider{token: shortLived}
+		cache := oidc.NewTokenCache(p)
+		for i := 0; i < 3; i++ {
+			_, err := cache.GetValidToken(ctx)
+			require.NoError(t, err)
+		}
+		require.EqualValues(t, 3, p.calls.Load())
+	})
+
+	t.Run("fraction of the lifetime", func(t *testing.T) {
+		p := &stubProvider{token: shortLived}
+		cache := oidc.NewTokenCache(p, oidc.WithRefreshStrategy(oidc.RefreshAtFraction(0.8)))
+		for i := 0; i < 3; i++ {
+			_, err := cache.GetValidToken(ctx)
+			require.NoError(t, err)
+		}
+		require.EqualValues(t, 1, p.calls.Load())
+
+		// Past 80% of the lifetime the token is refreshed
+		late := makeJWT(t, map[string]interface{}{"iat": now.Add(-50 * time.Second).Unix(), "exp": now.Add(10 * time.Second).Unix()})
+		p = &stubProvider{token: late}
+		cache = oidc.NewTokenCache(p, oidc.WithRefreshStrategy(oidc.RefreshAtFraction(0.8)))
+		for i := 0; i < 2; i++ {
+			_, err := cache.GetValidToken(ctx)
+			require.NoError(t, err)
+		}
+		require.EqualValues(t, 2, p.calls.Load())
+	})
+
+	t.Run("strategies", func(t *testing.T) {
+		issued := time.Unix(1_700_000_000, 0)
+		expiry := issued.Add(time.Hour)
+		require.Equal(t, issued.Add(48*time.Minute), oidc.RefreshAtFraction(0.8)(issued, expiry))
+		require.Equal(t, expiry.Add(-time.Minute), oidc.RefreshAtFraction(0.8)(time.Time{}, expiry))
+		require.Equal(t, expiry.Add(-5*time.Minute), oidc.RefreshBefore(5*time.Minute)(issued, expiry))
+	})
+
+	t.Run("strict minimum still applies", func(t *testing.T) {
+		long := makeJWT(t, map[string]interface{}{"iat": now.Unix(), "exp": now.Add(time.Hour).Unix()})
+		p := &stubProvider{token: long}
+		cache := oidc.NewTokenCache(p,
+			oidc.WithRefreshStrategy(func(issuedAt, expiry time.Time) time.Time { return expiry }),
+			oidc.WithMinRemaining(2*time.Hour))
+		_, err := cache.GetValidToken(ctx)
+		require.ErrorIs(t, err, oidc.ErrInsufficientLifetime)
+	})
+}
@@ -0,0 +1,186 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"golang.org/x/oauth2"
+)
+
+// ErrNotRegistered is returned for a name that was not registered with a Registry
+var ErrNotRegistered = errors.New("provider is not registered")
+
+// SourceConfig describes one named credential source in a RegistryConfig
+type SourceConfig struct {
+	Keycloak *ConfigKeyCloak // Keycloak provider configuration
+	Insecure bool            // skip TLS verification (development only)
+	Audience string          // informational, shown in Manager snapshots
+}
+
+// RegistryConfig is the configuration block a Registry is built from
+type RegistryConfig struct {
+	Sources map[string]SourceConfig
+}
+
+// Registry constructs and holds named token caches so credentials can be injected by name
+// Every cache is also registered with the registry's Manager for snapshots
+// The package level Register, Get and NamedToken use a process wide registry
+type Registry struct {
+	ctx     context.Context
+	manager *Manager
+}
+
+// defaultRegistry holds the caches registered with Register
+var defaultRegistry = newRegistry()
+
+func newRegistry() *Registry {
+	return &Registry{ctx: context.Background(), manager: NewManager()}
+}
+
+// NewRegistry builds a cache for every configured source
+// Options are applied to every cache built from config
+func NewRegistry(cfg RegistryConfig, opts ...CacheOption) (*Registry, error) {
+	r := newRegistry()
+	names := make([]string, 0, len(cfg.Sources))
+	for name := range cfg.Sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		src := cfg.Sources[name]
+		if src.Keycloak == nil {
+			return nil, fmt.Errorf("source %q has no provider configuration", name)
+		}
+		if err := src.Keycloak.Validate(); err != nil {
+			return nil, fmt.Errorf("source %q: %w", name, err)
+		}
+		provider := &KeycloakTokenProvider{Config: src.Keycloak, Insecure: src.Insecure}
+		if err := r.manager.Add(ManagedCredential{Name: name, Audience: src.Audience, Cache: NewTokenCache(provider, opts...)}); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Add registers a programmatically built provider under name, wrapped in a TokenCache built with opts
+// Names must be unique, use Remove first to replace a provider
+func (r *Registry) Add(name string, provider TokenProvider, opts ...CacheOption) error {
+	if provider == nil {
+		return fmt.Errorf("provider %q is nil", name)
+	}
+	return r.manager.Add(ManagedCredential{Name: name, Cache: NewTokenCache(provider, opts...)})
+}
+
+// Remove drops the named credential and closes its cache, so its watches stop
+func (r *Registry) Remove(name string) {
+	cache, ok := r.manager.Cache(name)
+	r.manager.Remove(name)
+	if ok {
+		_ = cache.Close()
+	}
+}
+
+// Names returns the registered names, sorted
+func (r *Registry) Names() []string {
+	return r.manager.Names()
+}
+
+// Manager returns the manager holding the registry caches
+func (r *Registry) Manager() *Manager {
+	return r.manager
+}
+
+// Cache returns the named cache
+func (r *Registry) Cache(name string) (*TokenCache, error) {
+	cache, ok := r.manager.Cache(name)
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrNotRegistered, name)
+	}
+	return cache, nil
+}
+
+// Token returns a valid token of the named credential
+func (r *Registry) Token(ctx context.Context, name string) (string, error) {
+	cache, err := r.Cache(name)
+	if err != nil {
+		return "", err
+	}
+	return cache.GetValidToken(ctx)
+}
+
+// TokenSource returns the named credential as an oauth2.TokenSource
+func (r *Registry) TokenSource(name string) (oauth2.TokenSource, error) {
+	cache, err := r.Cache(name)
+	if err != nil {
+		return nil, err
+	}
+	return cache.TokenSource(r.ctx), nil
+}
+
+// MustCache is like Cache but panics if the name is unknown, for use during wiring
+func (r *Registry) MustCache(name string) *TokenCache {
+	cache, err := r.Cache(name)
+	if err != nil {
+		panic(err)
+	}
+	return cache
+}
+
+// MustTokenSource is like TokenSource but panics if the name is unknown, for use during wiring
+func (r *Registry) MustTokenSource(name string) oauth2.TokenSource {
+	ts, err := r.TokenSource(name)
+	if err != nil {
+		panic(err)
+	}
+	return ts
+}
+
+// ProvideRegistry builds a Registry from config
+// Its signature works as a constructor for both google/wire (wire.Build(oidc.ProvideRegistry))
+// and uber/fx (fx.Provide(oidc.ProvideRegistry)) without importing either
+func ProvideRegistry(cfg RegistryConfig) (*Registry, error) {
+	return NewRegistry(cfg)
+}
+
+// ProvideTokenSource returns a constructor for the named token source
+// Use it with fx.Provide(fx.Annotate(oidc.ProvideTokenSource("pubsub"), fx.ResultTags(`name:"pubsub"`)))
+// or as a wire provider function wrapped in a named type
+func ProvideTokenSource(name string) func(*Registry) (oauth2.TokenSource, error) {
+	return func(r *Registry) (oauth2.TokenSource, error) {
+		return r.TokenSource(name)
+	}
+}
+
+// Register configures a provider under a logical name at startup, so code elsewhere can request
+// tokens by name with Get or NamedToken; the provider is wrapped in a TokenCache built with opts
+// Names must be unique, use Unregister first to replace a provider
+func Register(name string, provider TokenProvider, opts ...CacheOption) error {
+	return defaultRegistry.Add(name, provider, opts...)
+}
+
+// Unregister removes the provider registered under name and closes its cache
+func Unregister(name string) {
+	defaultRegistry.Remove(name)
+}
+
+// Get returns the cache of the provider registered under name
+func Get(name string) (*TokenCache, error) {
+	return defaultRegistry.Cache(name)
+}
+
+// NamedToken returns a valid token from the provider registered under name
+func NamedToken(ctx context.Context, name string) (string, error) {
+	return defaultRegistry.Token(ctx, name)
+}
+
+// Registered returns the registered names, sorted
+func Registered() []string {
+	return defaultRegistry.Names()
+}
+
+// NamedManager returns the manager holding the registered caches, e.g. to serve its SnapshotHandler
+func NamedManager() *Manager {
+	return defaultRegistry.manager
+}
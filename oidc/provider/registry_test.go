@@ -0,0 +1,130 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	oidc "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry(t *testing.T) {
+	idToken := validJWT(t)
+	realm := newFakeKeycloak(t, func(w http.ResponseWriter, r *http.Request) {
+		writeTokenResponse(w, map[string]interface{}{"access_token": "at", "id_token": idToken})
+	})
+	reg, err := oidc.ProvideRegistry(oidc.RegistryConfig{Sources: map[string]oidc.SourceConfig{
+		"keycloak": {
+			Keycloak: &oidc.ConfigKeyCloak{KeycloakRealmURL: realm, KeycloakClientID: "c", KeycloakClientSecret: "s"},
+			Audience: "gcp",
+		},
+	}})
+	require.NoError(t, err)
+	require.NoError(t, reg.Add("static", &stubProvider{token: idToken}))
+
+	tok, err := reg.MustTokenSource("keycloak").Token()
+	require.NoError(t, err)
+	require.Equal(t, idToken, tok.AccessToken)
+	require.False(t, tok.Expiry.IsZero())
+
+	ts, err := oidc.ProvideTokenSource("static")(reg)
+	require.NoError(t, err)
+	got, err := ts.Token()
+	require.NoError(t, err)
+	require.Equal(t, idToken, got.AccessToken)
+
+	_, err = reg.TokenSource("missing")
+	require.Error(t, err)
+	require.Panics(t, func() { reg.MustTokenSource("missing") })
+	require.Len(t, reg.Manager().Snapshot(), 2)
+
+	_, err = oidc.NewRegistry(oidc.RegistryConfig{Sources: map[string]oidc.SourceConfig{
+		"broken": {Keycloak: &oidc.ConfigKeyCloak{}},
+	}})
+	require.Error(t, err)
+}
+
+func TestNamedRegistry(t *testing.T) {
+	ctx := context.Background()
+	orders := &stubProvider{token: validJWT(t)}
+	require.NoError(t, oidc.Register("test-orders", orders))
+	t.Cleanup(func() { oidc.Unregister("test-orders") })
+
+	t.Run("tokens by name are cached", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				token, err := oidc.NamedToken(ctx, "test-orders")
+				require.NoError(t, err)
+				require.Equal(t, orders.token, token)
+			}()
+		}
+		wg.Wait()
+		require.EqualValues(t, 1, orders.calls.Load())
+
+		cache, err := oidc.Get("test-orders")
+		require.NoError(t, err)
+		same, err := oidc.Get("test-orders")
+		require.NoError(t, err)
+		require.Same(t, cache, same)
+		require.Contains(t, oidc.Registered(), "test-orders")
+	})
+
+	t.Run("unknown and duplicate names", func(t *testing.T) {
+		_, err := oidc.Get("test-missing")
+		require.ErrorIs(t, err, oidc.ErrNotRegistered)
+		_, err = oidc.NamedToken(ctx, "test-missing")
+		require.ErrorIs(t, err, oidc.ErrNotRegistered)
+
+		require.Error(t, oidc.Register("test-orders", &stubProvider{}))
+		require.Error(t, oidc.Register("test-nil", nil))
+	})
+
+	t.Run("replace after Unregister", func(t *testing.T) {
+		require.NoError(t, oidc.Register("test-billing", &stubProvider{err: errors.New("down")}))
+		t.Cleanup(func() { oidc.Unregister("test-billing") })
+		_, err := oidc.NamedToken(ctx, "test-billing")
+		require.Error(t, err)
+
+		oidc.Unregister("test-billing")
+		billing := validJWT(t)
+		require.NoError(t, oidc.Register("test-billing", &stubProvider{token: billing}))
+		token, err := oidc.NamedToken(ctx, "test-billing")
+		require.NoError(t, err)
+		require.Equal(t, billing, token)
+
+		snapshot := oidc.NamedManager().Snapshot()
+		require.Len(t, snapshot, len(oidc.Registered()))
+	})
+
+	t.Run("Unregister closes the cache", func(t *testing.T) {
+		require.NoError(t, oidc.Register("test-watched", &stubProvider{token: validJWT(t)}))
+		cache, err := oidc.Get("test-watched")
+		require.NoError(t, err)
+		updates := cache.Watch(ctx)
+		<-updates
+		oidc.Unregister("test-watched")
+		_, open := <-updates
+		require.False(t, open)
+	})
+
+	t.Run("Registered does not wait for fetches", func(t *testing.T) {
+		release := make(chan struct{})
+		require.NoError(t, oidc.Register("test-slow", oidc.ProviderFunc(func(ctx context.Context) (string, error) {
+			<-release
+			return "", errors.New("down")
+		})))
+		t.Cleanup(func() { oidc.Unregister("test-slow") })
+		go func() { _, _ = oidc.NamedToken(ctx, "test-slow") }()
+		time.Sleep(20 * time.Millisecond)
+		require.Contains(t, oidc.Registered(), "test-slow")
+		close(release)
+	})
+}
@@ -0,0 +1,189 @@
+package oidc
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+)
+
+// RetryPolicy controls retries of token requests
+// Retry-After from the server always takes precedence over the computed backoff;
+// if it asks for longer than MaxDelay the request is not retried and the error
+// (with its RetryAfter) is returned to the caller instead
+type RetryPolicy struct {
+	MaxAttempts int           // total attempts including the first, default 3
+	BaseDelay   time.Duration // first backoff, doubled per attempt with jitter, default 200ms
+	MaxDelay    time.Duration // cap for a single wait, default 30s
+}
+
+func (p RetryPolicy) maxAttempts() int {
+	if p.MaxAttempts > 0 {
+		return p.MaxAttempts
+	}
+	return 3
+}
+
+func (p RetryPolicy) maxDelay() time.Duration {
+	if p.MaxDelay > 0 {
+		return p.MaxDelay
+	}
+	return 30 * time.Second
+}
+
+// Backoff returns how long to wait before retry number attempt (starting at 1)
+// retryAfter is the server requested delay, zero if none
+// ok is false when the server asked for a delay longer than MaxDelay
+func (p RetryPolicy) Backoff(attempt int, retryAfter time.Duration) (delay time.Duration, ok bool) {
+	if retryAfter > 0 {
+		return retryAfter, retryAfter <= p.maxDelay()
+	}
+	base := p.BaseDelay
+	if base <= 0 {
+		base = 200 * time.Millisecond
+	}
+	d := base << uint(attempt-1)
+	if d <= 0 || d > p.maxDelay() {
+		d = p.maxDelay()
+	}
+	// Full jitter keeps many clients from retrying in lockstep
+	return time.Duration(rand.Int63n(int64(d)) + 1), true
+}
+
+// retryable reports whether a fetch error is worth retrying and the server requested delay
+func retryable(err error) (bool, time.Duration) {
+	if IsCanceled(err) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false, 0
+	}
+	var tErr *TokenError
+	if errors.As(err, &tErr) {
+		return tErr.Temporary(), tErr.RetryAfter
+	}
+	var qErr *QuotaExceededError
+	if errors.As(err, &qErr) {
+		return true, qErr.RetryAfter
+	}
+	// Only failures that never got an HTTP answer are retried, anything else (a bad configuration, an
+	// unparsable response, a rejected claim) would fail the same way again
+	return isTransportError(err), 0
+}
+
+// isTransportError reports whether err is a network level failure: refused or reset connections,
+// timeouts and connections closed before the response was complete
+// A server certificate that fails verification is not, it stays invalid on the next attempt
+func isTransportError(err error) bool {
+	var certErr *tls.CertificateVerificationError
+	if errors.As(err, &certErr) {
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
+}
+
+// sleepContext waits for d or until ctx is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// RetryProvider retries a TokenProvider on temporary failures, honouring Retry-After
+type RetryProvider struct {
+	Provider TokenProvider
+	Policy   RetryPolicy
+}
+
+// WithRetry wraps provider with retries according to policy
+func WithRetry(provider TokenProvider, policy RetryPolicy) *RetryProvider {
+	return &RetryProvider{Provider: provider, Policy: policy}
+}
+
+// FetchToken implements TokenProvider
+func (r *RetryProvider) FetchToken(ctx context.Context) (string, error) {
+	var lastErr error
+	for attempt := 1; attempt <= r.Policy.maxAttempts(); attempt++ {
+		token, err := r.Provider.FetchToken(ctx)
+		if err == nil {
+			return token, nil
+		}
+		lastErr = err
+		ok, retryAfter := retryable(err)
+		if !ok || attempt == r.Policy.maxAttempts() {
+			break
+		}
+		delay, ok := r.Policy.Backoff(attempt, retryAfter)
+		if !ok {
+			break
+		}
+		if err := sleepContext(ctx, delay); err != nil {
+			return "", lastErr
+		}
+	}
+	return "", lastErr
+}
+
+// RetryTransport is an http.RoundTripper retrying 429 and 5xx responses, honouring Retry-After
+// It is meant for token endpoints such as Google STS whose client library does not retry;
+// when retries are exhausted the last response is returned unchanged
+type RetryTransport struct {
+	Base   http.RoundTripper // default http.DefaultTransport
+	Policy RetryPolicy
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *RetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	// The body is buffered so it can be replayed on every attempt
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	for attempt := 1; ; attempt++ {
+		attemptReq := req.Clone(req.Context())
+		if body != nil {
+			attemptReq.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		resp, err := base.RoundTrip(attemptReq)
+		if attempt >= t.Policy.maxAttempts() {
+			return resp, err
+		}
+		var retryAfter time.Duration
+		if err == nil {
+			if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < http.StatusInternalServerError {
+				return resp, nil
+			}
+			retryAfter = ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		} else if req.Context().Err() != nil {
+			return resp, err
+		}
+		delay, ok := t.Policy.Backoff(attempt, retryAfter)
+		if !ok {
+			return resp, err
+		}
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		if err := sleepContext(req.Context(), delay); err != nil {
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	oidc "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	require.Equal(t, 5*time.Second, oidc.ParseRetryAfter("5", now))
+	require.Equal(t, 30*time.Second, oidc.ParseRetryAfter(now.Add(30*time.Second).Format(http.TimeFormat), now))
+	require.Zero(t, oidc.ParseRetryAfter("", now))
+	require.Zero(t, oidc.ParseRetryAfter("soon", now))
+	require.Zero(t, oidc.ParseRetryAfter(now.Add(-time.Minute).Format(http.TimeFormat), now))
+}
+
+func TestKeycloakTokenError(t *testing.T) {
+	realm := newFakeKeycloak(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "7")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusTooManyRequests)
+		_, _ = w.Write([]byte(`{"error":"slow_down","error_description":"rate limited"}`))
+	})
+	p := &oidc.KeycloakTokenProvider{Config: &oidc.ConfigKeyCloak{
+		KeycloakRealmURL: realm, KeycloakClientID: "svc", KeycloakClientSecret: "secret",
+	}}
+	_, err := p.FetchToken(context.Background())
+	var tErr *oidc.TokenError
+	require.True(t, errors.As(err, &tErr))
+	require.Equal(t, "keycloak", tErr.Provider)
+	require.Equal(t, http.StatusTooManyRequests, tErr.StatusCode)
+	require.Equal(t, "slow_down", tErr.Code)
+	require.Equal(t, 7*time.Second, tErr.RetryAfter)
+	require.True(t, tErr.Temporary())
+}
+
+func TestRetryProvider(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("retries temporary errors honouring Retry-After", func(t *testing.T) {
+		// oauth2 retries once itself while auto-detecting the auth style,
+		// so the fake keeps failing until the requested second has passed
+		var first atomic.Int64
+		realm := newFakeKeycloak(t, func(w http.ResponseWriter, r *http.Request) {
+			first.CompareAndSwap(0, time.Now().UnixNano())
+			if time.Since(time.Unix(0, first.Load())) < 900*time.Millisecond {
+				w.Header().Set("Retry-After", "1")
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			writeTokenResponse(w, map[string]interface{}{"access_token": "a", "id_token": validJWT(t)})
+		})
+		p := oidc.WithRetry(&oidc.KeycloakTokenProvider{Config: &oidc.ConfigKeyCloak{
+			KeycloakRealmURL: realm, KeycloakClientID: "svc", KeycloakClientSecret: "secret",
+		}}, oidc.RetryPolicy{MaxAttempts: 2})
+		start := time.Now()
+		token, err := p.FetchToken(ctx)
+		require.NoError(t, err)
+		require.NotEmpty(t, token)
+		require.GreaterOrEqual(t, time.Since(start), time.Second)
+	})
+
+	t.Run("gives up when Retry-After exceeds MaxDelay", func(t *testing.T) {
+		stub := &stubProvider{err: &oidc.TokenError{StatusCode: http.StatusTooManyRequests, RetryAfter: time.Hour}}
+		_, err := oidc.WithRetry(stub, oidc.RetryPolicy{MaxDelay: time.Second}).FetchToken(ctx)
+		var tErr *oidc.TokenError
+		require.True(t, errors.As(err, &tErr))
+		require.Equal(t, time.Hour, tErr.RetryAfter)
+		require.EqualValues(t, 1, stub.calls.Load())
+	})
+
+	t.Run("does not retry client errors", func(t *testing.T) {
+		stub := &stubProvider{err: &oidc.TokenError{StatusCode: http.StatusUnauthorized}}
+		_, err := oidc.WithRetry(stub, oidc.RetryPolicy{}).FetchToken(ctx)
+		require.Error(t, err)
+		require.EqualValues(t, 1, stub.calls.Load())
+	})
+
+	t.Run("retries network errors with backoff", func(t *testing.T) {
+		refused := &url.Error{Op: "Post", URL: "https://kc/token", Err: &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}}
+		stub := &stubProvider{err: fmt.Errorf("failed to get token from Keycloak: %w", refused)}
+		_, err := oidc.WithRetry(stub, oidc.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}).FetchToken(ctx)
+		require.Error(t, err)
+		require.EqualValues(t, 3, stub.calls.Load())
+	})
+
+	t.Run("does not retry unknown errors", func(t *testing.T) {
+		stub := &stubProvider{err: errors.New("failed to extract id_token from Keycloak token response")}
+		_, err := oidc.WithRetry(stub, oidc.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}).FetchToken(ctx)
+		require.Error(t, err)
+		require.EqualValues(t, 1, stub.calls.Load())
+	})
+
+	t.Run("retries temporarily_unavailable", func(t *testing.T) {
+		stub := &stubProvider{err: &oidc.TokenError{StatusCode: http.StatusBadRequest, Code: "temporarily_unavailable"}}
+		_, err := oidc.WithRetry(stub, oidc.RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond}).FetchToken(ctx)
+		require.Error(t, err)
+		require.EqualValues(t, 2, stub.calls.Load())
+	})
+}
+
+func TestRetryTransport(t *testing.T) {
+	var calls atomic.Int32
+	realm := newFakeKeycloak(t, func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "svc", r.PostForm.Get("client_id"))
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	client := &http.Client{Transport: &oidc.RetryTransport{Policy: oidc.RetryPolicy{BaseDelay: time.Millisecond}}}
+	resp, err := client.PostForm(realm+"/protocol/openid-connect/token", map[string][]string{"client_id": {"svc"}})
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.EqualValues(t, 2, calls.Load())
+}
@@ -0,0 +1,281 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// Token type hints of RFC 7009 section 2.1 and RFC 7662 section 2.1
+const (
+	TokenTypeHintAccessToken  = "access_token"
+	TokenTypeHintRefreshToken = "refresh_token"
+)
+
+// ErrRevocationUnsupported is returned by Revoke when the IdP offers no revocation endpoint
+var ErrRevocationUnsupported = errors.New("IdP does not offer token revocation")
+
+// Revoker is implemented by providers whose IdP revokes tokens (RFC 7009), e.g. on shutdown or
+// credential rotation; tokenTypeHint is optional
+type Revoker interface {
+	Revoke(ctx context.Context, token, tokenTypeHint string) error
+}
+
+// Revoke revokes token at the realm's revocation endpoint
+// An empty token revokes the refresh (offline) token the provider renews the scopes of ctx with, if any,
+// and forgets it
+func (k *KeycloakTokenProvider) Revoke(ctx context.Context, token, tokenTypeHint string) error {
+	if err := k.Config.Validate(); err != nil {
+		return err
+	}
+	if token == "" {
+		scopes := k.Config.EffectiveScopes(ctx)
+		token = k.refreshToken(ctx, scopes)
+		if token == "" {
+			return nil
+		}
+		tokenTypeHint = TokenTypeHintRefreshToken
+		defer k.forgetRefreshToken(ctx, scopes)
+	}
+	client, err := NewMTLSHTTPClient("keycloak", k.Insecure, k.Config.ClientTLS)
+	if err != nil {
+		return err
+	}
+	conf, err := k.Config.ClientAuthMethod.apply(&clientcredentials.Config{
+		ClientID:     k.Config.KeycloakClientID,
+		ClientSecret: k.Config.KeycloakClientSecret,
+		TokenURL:     k.Config.TokenURL(),
+	}, k.Config.ClientAssertionSigner, k.Config.ClientAssertionLifetime, k.Config.ClientTLS)
+	if err != nil {
+		return fmt.Errorf("failed to sign Keycloak client assertion: %w", err)
+	}
+	return revokeToken(ctx, client, "keycloak", k.Config.NormalizedRealmURL()+"/protocol/openid-connect/revoke", conf, token, tokenTypeHint)
+}
+
+// Revoke revokes token at the realm's revocation endpoint
+// An empty token revokes the refresh token of the current session and drops the session, so the next
+// fetch logs in with the password again
+func (k *KeycloakPasswordProvider) Revoke(ctx context.Context, token, tokenTypeHint string) error {
+	p, err := k.tokenProvider()
+	if err != nil {
+		return err
+	}
+	return p.Revoke(ctx, token, tokenTypeHint)
+}
+
+// Revoke revokes token at the revocation_endpoint of the discovery document
+// Errors wrap ErrRevocationUnsupported when the IdP does not advertise one
+func (g *GenericProvider) Revoke(ctx context.Context, token, tokenTypeHint string) error {
+	if err := g.Config.Validate(); err != nil {
+		return err
+	}
+	doc, err := g.Discovery(ctx)
+	if err != nil {
+		return err
+	}
+	endpoint := doc.RevocationEndpoint
+	if alias := doc.MTLSEndpointAliases["revocation_endpoint"]; alias != "" && g.Config.ClientTLS != nil {
+		endpoint = alias
+	}
+	if endpoint == "" {
+		return fmt.Errorf("%w: %s advertises no revocation_endpoint", ErrRevocationUnsupported, g.Config.IssuerURL)
+	}
+	client, err := NewMTLSHTTPClient("oidc", g.Insecure, g.Config.ClientTLS)
+	if err != nil {
+		return err
+	}
+	conf, err := g.Config.ClientAuthMethod.apply(&clientcredentials.Config{
+		ClientID:     g.Config.ClientID,
+		ClientSecret: g.Config.ClientSecret,
+		TokenURL:     doc.TokenEndpoint,
+		AuthStyle:    authStyleFor(doc.RevocationEndpointAuthMethods),
+	}, g.Config.ClientAssertionSigner, g.Config.ClientAssertionLifetime, g.Config.ClientTLS)
+	if err != nil {
+		return fmt.Errorf("failed to sign client assertion: %w", err)
+	}
+	return revokeToken(ctx, client, "oidc", endpoint, conf, token, tokenTypeHint)
+}
+
+// Revoke revokes token at the authorization server's revocation endpoint
+func (o *OktaTokenProvider) Revoke(ctx context.Context, token, tokenTypeHint string) error {
+	if err := o.Config.Validate(); err != nil {
+		return err
+	}
+	endpoint := strings.TrimSuffix(o.Config.TokenURL(), "/token") + "/revoke"
+	conf := &clientcredentials.Config{ClientID: o.Config.ClientID, ClientSecret: o.Config.ClientSecret, AuthStyle: oauth2.AuthStyleInHeader}
+	return revokeToken(ctx, NewHTTPClient("okta", o.Insecure), "okta", endpoint, conf, token, tokenTypeHint)
+}
+
+// Revoke revokes token at the user pool domain's revocation endpoint
+// Cognito only revokes refresh tokens, access tokens of the client credentials grant expire on their own
+func (p *CognitoTokenProvider) Revoke(ctx context.Context, token, tokenTypeHint string) error {
+	if err := p.Config.Validate(); err != nil {
+		return err
+	}
+	endpoint := strings.TrimSuffix(p.Config.TokenURL(), "/token") + "/revoke"
+	conf := &clientcredentials.Config{ClientID: p.Config.ClientID, ClientSecret: p.Config.ClientSecret, AuthStyle: oauth2.AuthStyleInHeader}
+	return revokeToken(ctx, NewHTTPClient("cognito", p.Insecure), "cognito", endpoint, conf, token, tokenTypeHint)
+}
+
+// Revoke revokes token at the runtime engine's revocation endpoint, authenticated like the token request
+func (p *PingTokenProvider) Revoke(ctx context.Context, token, tokenTypeHint string) error {
+	if err := p.Config.Validate(); err != nil {
+		return err
+	}
+	conf, err := p.Config.ClientAuthMethod.apply(&clientcredentials.Config{
+		ClientID:     p.Config.ClientID,
+		ClientSecret: p.Config.ClientSecret,
+		TokenURL:     p.Config.TokenURL(),
+		AuthStyle:    oauth2.AuthStyleInParams,
+	}, p.Config.ClientAssertionSigner, p.Config.ClientAssertionLifetime, nil)
+	if err != nil {
+		return fmt.Errorf("failed to sign PingFederate client assertion: %w", err)
+	}
+	return revokeToken(ctx, NewHTTPClient("ping", p.Insecure), "ping", p.Config.RevocationURL(), conf, token, tokenTypeHint)
+}
+
+// Revoke revokes token at the tenant's revocation endpoint
+// Auth0 only revokes refresh tokens, access tokens of the client credentials grant expire on their own
+func (a *Auth0TokenProvider) Revoke(ctx context.Context, token, tokenTypeHint string) error {
+	if err := a.Config.Validate(); err != nil {
+		return err
+	}
+	conf := &clientcredentials.Config{ClientID: a.Config.ClientID, ClientSecret: a.Config.ClientSecret, AuthStyle: oauth2.AuthStyleInParams}
+	return revokeToken(ctx, NewHTTPClient("auth0", a.Insecure), "auth0", a.Config.RevocationURL(), conf, token, tokenTypeHint)
+}
+
+// Revoke always fails with ErrRevocationUnsupported: Entra ID has no RFC 7009 endpoint, its tokens
+// stay valid until they expire (revokeSignInSessions of Microsoft Graph only covers user sessions)
+func (a *AzureTokenProvider) Revoke(ctx context.Context, token, tokenTypeHint string) error {
+	return fmt.Errorf("%w: Entra ID tokens expire on their own", ErrRevocationUnsupported)
+}
+
+// Revoke always fails with ErrRevocationUnsupported: ADFS has no RFC 7009 endpoint, refresh tokens are
+// revoked by an administrator (Revoke-AdfsRefreshToken)
+func (a *ADFSTokenProvider) Revoke(ctx context.Context, token, tokenTypeHint string) error {
+	return fmt.Errorf("%w: ADFS tokens expire on their own", ErrRevocationUnsupported)
+}
+
+// Revoke revokes the cached token through the provider and drops it, also from the store, so the next
+// call fetches a new one, e.g. when rotating credentials
+// Access tokens are revoked with the access_token hint. A provider renewing with a refresh token revokes
+// and forgets it, which ends the session of the tokens issued with it; id tokens themselves cannot be
+// revoked (Keycloak answers unsupported_token_type) and are only dropped
+// The token is dropped even when revocation fails; errors wrap ErrRevocationUnsupported when the
+// provider cannot revoke tokens
+func (c *TokenCache) Revoke(ctx context.Context) error {
+	c.mu.Lock()
+	token := c.token
+	c.mu.Unlock()
+	if token == "" {
+		return nil
+	}
+	err := c.revokeHeld(ctx, token)
+	c.ForceExpire(time.Now())
+	c.mu.Lock()
+	if c.store != nil && c.token == token {
+		// seed skips empty tokens, a restart must not load the revoked one again
+		_ = c.store.Save(context.WithoutCancel(ctx), c.storeKey, StoredToken{})
+	}
+	c.mu.Unlock()
+	return err
+}
+
+// revokeHeld revokes the tokens behind the cached token: the refresh token the provider holds, if it
+// renews with one, and the cached token unless it is an id token
+func (c *TokenCache) revokeHeld(ctx context.Context, token string) error {
+	caps := CapabilitiesOf(c.provider)
+	var errs []error
+	if caps.RefreshToken {
+		// An empty token revokes the refresh token of the provider
+		errs = append(errs, revokeWith(ctx, c.provider, "", ""))
+	}
+	switch {
+	case !caps.IDToken:
+		errs = append(errs, revokeWith(ctx, c.provider, token, TokenTypeHintAccessToken))
+	case !caps.RefreshToken:
+		errs = append(errs, fmt.Errorf("%w: %s provider caches id tokens, which cannot be revoked", ErrRevocationUnsupported, providerKind(c.provider)))
+	}
+	return errors.Join(errs...)
+}
+
+// revokeWith revokes token through p, wrapped by ErrRevocationUnsupported when p has no Revoke method
+func revokeWith(ctx context.Context, p TokenProvider, token, tokenTypeHint string) error {
+	r, ok := p.(Revoker)
+	if !ok {
+		return fmt.Errorf("%w: %s provider has no Revoke method", ErrRevocationUnsupported, providerKind(p))
+	}
+	return r.Revoke(ctx, token, tokenTypeHint)
+}
+
+// revokeToken sends an RFC 7009 revocation request for token to endpoint
+// The revocation endpoint answers 200 for tokens that are already invalid, so revoking twice succeeds
+func revokeToken(ctx context.Context, client *http.Client, provider, endpoint string, conf *clientcredentials.Config, token, tokenTypeHint string) error {
+	if token == "" {
+		return errors.New("no token to revoke")
+	}
+	form := url.Values{"token": {token}}
+	if tokenTypeHint != "" {
+		form.Set("token_type_hint", tokenTypeHint)
+	}
+	_, err := postClientForm(ctx, client, provider, endpoint, conf, form)
+	return err
+}
+
+// postClientForm posts form to endpoint authenticated as the client of conf, the way the token endpoint
+// would be called: HTTP Basic, client_secret_post or the assertion in conf.EndpointParams
+// Error responses are returned as *TokenError, requests ended by the caller as *CanceledError
+func postClientForm(ctx context.Context, client *http.Client, provider, endpoint string, conf *clientcredentials.Config, form url.Values) ([]byte, error) {
+	for k, v := range conf.EndpointParams {
+		form[k] = v
+	}
+	basic := conf.ClientSecret != "" && conf.AuthStyle != oauth2.AuthStyleInParams
+	if !basic {
+		form.Set("client_id", conf.ClientID)
+		if conf.ClientSecret != "" {
+			form.Set("client_secret", conf.ClientSecret)
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if basic {
+		req.SetBasicAuth(url.QueryEscape(conf.ClientID), url.QueryEscape(conf.ClientSecret))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, canceledByCaller(ctx, provider, fmt.Errorf("%s request failed: %w", provider, err))
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s response: %w", provider, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Error            string `json:"error"`
+			ErrorDescription string `json:"error_description"`
+		}
+		_ = json.Unmarshal(body, &e)
+		return nil, &TokenError{
+			Provider:    provider,
+			StatusCode:  resp.StatusCode,
+			Code:        e.Error,
+			Description: e.ErrorDescription,
+			RetryAfter:  ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+		}
+	}
+	return body, nil
+}
@@ -0,0 +1,292 @@
+package oidc_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	oidc "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+// revocations records the requests received by a fake revocation endpoint
+type revocations struct {
+	mu    sync.Mutex
+	forms []url.Values
+	users []string
+}
+
+func (r *revocations) handler(w http.ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	user, _, _ := req.BasicAuth()
+	r.mu.Lock()
+	r.forms = append(r.forms, req.PostForm)
+	r.users = append(r.users, user)
+	r.mu.Unlock()
+	if req.PostForm.Get("token") == "unavailable" {
+		w.Header().Set("Retry-After", "5")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte(`{"error":"temporarily_unavailable"}`))
+	}
+}
+
+func (r *revocations) last() (url.Values, string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.forms) == 0 {
+		return nil, ""
+	}
+	return r.forms[len(r.forms)-1], r.users[len(r.users)-1]
+}
+
+func TestRevoke(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("keycloak", func(t *testing.T) {
+		var revoked revocations
+		var grants []string
+		mux := http.NewServeMux()
+		mux.HandleFunc("/realms/test/protocol/openid-connect/revoke", revoked.handler)
+		mux.HandleFunc("/realms/test/protocol/openid-connect/token", func(w http.ResponseWriter, r *http.Request) {
+			require.NoError(t, r.ParseForm())
+			grants = append(grants, r.PostForm.Get("grant_type"))
+			writeTokenResponse(w, map[string]interface{}{"access_token": "at", "id_token": validJWT(t), "refresh_token": "rt"})
+		})
+		srv := httptest.NewServer(mux)
+		t.Cleanup(srv.Close)
+		p := &oidc.KeycloakTokenProvider{Config: &oidc.ConfigKeyCloak{
+			KeycloakRealmURL:      srv.URL + "/realms/test/",
+			KeycloakClientID:      "client",
+			KeycloakClientSecret:  "secret",
+			RenewWithRefreshToken: true,
+		}}
+		var _ oidc.Revoker = p
+
+		require.NoError(t, p.Revoke(ctx, "at", oidc.TokenTypeHintAccessToken))
+		form, user := revoked.last()
+		require.Equal(t, "client", user)
+		require.Equal(t, "at", form.Get("token"))
+		require.Equal(t, oidc.TokenTypeHintAccessToken, form.Get("token_type_hint"))
+		require.Empty(t, form.Get("client_secret"))
+
+		// Without token the refresh token is revoked and the next fetch starts over with the grant
+		_, err := p.FetchToken(ctx)
+		require.NoError(t, err)
+		require.NoError(t, p.Revoke(ctx, "", ""))
+		form, _ = revoked.last()
+		require.Equal(t, "rt", form.Get("token"))
+		require.Equal(t, oidc.TokenTypeHintRefreshToken, form.Get("token_type_hint"))
+		_, err = p.FetchToken(ctx)
+		require.NoError(t, err)
+		require.Equal(t, []string{"client_credentials", "client_credentials"}, grants)
+
+		err = p.Revoke(ctx, "unavailable", "")
+		var tErr *oidc.TokenError
+		require.True(t, errors.As(err, &tErr))
+		require.Equal(t, http.StatusServiceUnavailable, tErr.StatusCode)
+		require.Equal(t, 5*time.Second, tErr.RetryAfter)
+	})
+
+	t.Run("generic provider uses the discovered endpoint", func(t *testing.T) {
+		var revoked revocations
+		var srv *httptest.Server
+		mux := http.NewServeMux()
+		mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"issuer":              srv.URL,
+				"token_endpoint":      srv.URL + "/token",
+				"revocation_endpoint": srv.URL + "/revoke",
+				"revocation_endpoint_auth_methods_supported": []string{"client_secret_post"},
+			})
+		})
+		mux.HandleFunc("/revoke", revoked.handler)
+		srv = httptest.NewServer(mux)
+		t.Cleanup(srv.Close)
+
+		p := oidc.NewGenericProvider(srv.URL, "client", "secret")
+		require.NoError(t, p.Revoke(ctx, "rt", oidc.TokenTypeHintRefreshToken))
+		form, user := revoked.last()
+		require.Empty(t, user)
+		require.Equal(t, "client", form.Get("client_id"))
+		require.Equal(t, "secret", form.Get("client_secret"))
+		require.Equal(t, "rt", form.Get("token"))
+	})
+
+	t.Run("generic provider without endpoint", func(t *testing.T) {
+		issuer, _ := newFakeIssuer(t, "", nil, func(w http.ResponseWriter, r *http.Request) {})
+		err := oidc.NewGenericProvider(issuer, "client", "secret").Revoke(ctx, "at", "")
+		require.ErrorIs(t, err, oidc.ErrRevocationUnsupported)
+	})
+
+	t.Run("okta and cognito endpoints", func(t *testing.T) {
+		var revoked revocations
+		var paths []string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			paths = append(paths, r.URL.Path)
+			revoked.handler(w, r)
+		}))
+		t.Cleanup(srv.Close)
+
+		okta := &oidc.OktaTokenProvider{Config: &oidc.ConfigOkta{IssuerURL: srv.URL + "/oauth2/default", ClientID: "c", ClientSecret: "s"}}
+		require.NoError(t, okta.Revoke(ctx, "at", ""))
+		cognito := &oidc.CognitoTokenProvider{Config: &oidc.ConfigCognito{Domain: srv.URL, ClientID: "c", ClientSecret: "s"}}
+		require.NoError(t, cognito.Revoke(ctx, "rt", oidc.TokenTypeHintRefreshToken))
+		require.Equal(t, []string{"/oauth2/default/v1/revoke", "/oauth2/revoke"}, paths)
+	})
+
+	t.Run("ping and auth0 endpoints", func(t *testing.T) {
+		var revoked revocations
+		var paths []string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			paths = append(paths, r.URL.Path)
+			revoked.handler(w, r)
+		}))
+		t.Cleanup(srv.Close)
+
+		ping := &oidc.PingTokenProvider{Config: &oidc.ConfigPing{BaseURL: srv.URL, ClientID: "c", ClientSecret: "s"}}
+		require.True(t, oidc.CapabilitiesOf(ping).Revocation)
+		require.NoError(t, ping.Revoke(ctx, "at", oidc.TokenTypeHintAccessToken))
+		form, user := revoked.last()
+		require.Empty(t, user)
+		require.Equal(t, "s", form.Get("client_secret"))
+		auth0 := &oidc.Auth0TokenProvider{Config: &oidc.ConfigAuth0{Domain: srv.URL, ClientID: "c", ClientSecret: "s", Audience: "api"}}
+		require.NoError(t, auth0.Revoke(ctx, "rt", oidc.TokenTypeHintRefreshToken))
+		require.Equal(t, []string{"/as/revoke_token.oauth2", "/oauth/revoke"}, paths)
+	})
+
+	t.Run("azure and adfs are unsupported", func(t *testing.T) {
+		azure := &oidc.AzureTokenProvider{Config: &oidc.ConfigAzure{}}
+		require.ErrorIs(t, azure.Revoke(ctx, "at", ""), oidc.ErrRevocationUnsupported)
+		require.False(t, oidc.CapabilitiesOf(azure).Revocation)
+		adfs := &oidc.ADFSTokenProvider{Config: &oidc.ConfigADFS{}}
+		require.ErrorIs(t, adfs.Revoke(ctx, "at", ""), oidc.ErrRevocationUnsupported)
+		require.False(t, oidc.CapabilitiesOf(adfs).Revocation)
+	})
+
+	t.Run("cache drops the revoked token", func(t *testing.T) {
+		var revoked revocations
+		srv := httptest.NewServer(http.HandlerFunc(revoked.handler))
+		t.Cleanup(srv.Close)
+		tokens := &stubProvider{token: "first"}
+		p := &revokingProvider{stubProvider: tokens, endpoint: srv.URL}
+		store, err := oidc.NewFileCacheStore(t.TempDir())
+		require.NoError(t, err)
+		cache := oidc.NewTokenCache(oidc.Metered(p), oidc.WithStore(store, "orders"), oidc.WithDefaultTTL(time.Hour))
+
+		token, err := cache.GetValidToken(ctx)
+		require.NoError(t, err)
+		require.Equal(t, "first", token)
+		require.NoError(t, cache.Revoke(ctx))
+		form, _ := revoked.last()
+		require.Equal(t, "first", form.Get("token"))
+		stored, err := store.Load(ctx, "orders")
+		require.NoError(t, err)
+		require.Empty(t, stored.Token)
+
+		tokens.token = "second"
+		token, err = cache.GetValidToken(ctx)
+		require.NoError(t, err)
+		require.Equal(t, "second", token)
+		require.EqualValues(t, 2, tokens.calls.Load())
+	})
+
+	t.Run("cache revokes the keycloak refresh token instead of the id token", func(t *testing.T) {
+		var revoked revocations
+		mux := http.NewServeMux()
+		mux.HandleFunc("/realms/test/protocol/openid-connect/revoke", revoked.handler)
+		mux.HandleFunc("/realms/test/protocol/openid-connect/token", func(w http.ResponseWriter, r *http.Request) {
+			writeTokenResponse(w, map[string]interface{}{"access_token": "at", "id_token": validJWT(t), "refresh_token": "rt"})
+		})
+		srv := httptest.NewServer(mux)
+		t.Cleanup(srv.Close)
+		newCache := func(renew bool) *oidc.TokenCache {
+			return oidc.NewTokenCache(&oidc.KeycloakTokenProvider{Config: &oidc.ConfigKeyCloak{
+				KeycloakRealmURL:      srv.URL + "/realms/test",
+				KeycloakClientID:      "client",
+				KeycloakClientSecret:  "secret",
+				RenewWithRefreshToken: renew,
+			}})
+		}
+
+		cache := newCache(true)
+		_, err := cache.GetValidToken(ctx)
+		require.NoError(t, err)
+		require.NoError(t, cache.Revoke(ctx))
+		require.Len(t, revoked.forms, 1)
+		require.Equal(t, "rt", revoked.forms[0].Get("token"))
+		require.Equal(t, oidc.TokenTypeHintRefreshToken, revoked.forms[0].Get("token_type_hint"))
+
+		// Without a refresh token there is nothing to revoke, the id token is only dropped
+		cache = newCache(false)
+		_, err = cache.GetValidToken(ctx)
+		require.NoError(t, err)
+		require.ErrorIs(t, cache.Revoke(ctx), oidc.ErrRevocationUnsupported)
+		require.Len(t, revoked.forms, 1)
+	})
+
+	t.Run("cache revokes the access and refresh token of the password grant", func(t *testing.T) {
+		var revoked revocations
+		mux := http.NewServeMux()
+		mux.HandleFunc("/realms/test/protocol/openid-connect/revoke", revoked.handler)
+		mux.HandleFunc("/realms/test/protocol/openid-connect/token", func(w http.ResponseWriter, r *http.Request) {
+			writeTokenResponse(w, map[string]interface{}{"access_token": validJWT(t), "id_token": "id", "refresh_token": "rt"})
+		})
+		srv := httptest.NewServer(mux)
+		t.Cleanup(srv.Close)
+		cache := oidc.NewTokenCache(&oidc.KeycloakPasswordProvider{Config: &oidc.ConfigKeyCloak{
+			KeycloakRealmURL: srv.URL + "/realms/test",
+			KeycloakClientID: "client",
+			Username:         "legacy",
+			Password:         "pw",
+		}})
+		token, err := cache.GetValidToken(ctx)
+		require.NoError(t, err)
+		require.NoError(t, cache.Revoke(ctx))
+		require.Len(t, revoked.forms, 2)
+		require.Equal(t, "rt", revoked.forms[0].Get("token"))
+		require.Equal(t, oidc.TokenTypeHintRefreshToken, revoked.forms[0].Get("token_type_hint"))
+		require.Equal(t, token, revoked.forms[1].Get("token"))
+		require.Equal(t, oidc.TokenTypeHintAccessToken, revoked.forms[1].Get("token_type_hint"))
+	})
+
+	t.Run("cache drops the token of a provider without revocation", func(t *testing.T) {
+		tokens := &stubProvider{token: "first"}
+		cache := oidc.NewTokenCache(tokens, oidc.WithDefaultTTL(time.Hour))
+		_, err := cache.GetValidToken(ctx)
+		require.NoError(t, err)
+		require.ErrorIs(t, cache.Revoke(ctx), oidc.ErrRevocationUnsupported)
+		_, err = cache.GetValidToken(ctx)
+		require.NoError(t, err)
+		require.EqualValues(t, 2, tokens.calls.Load())
+	})
+}
+
+// revokingProvider is a stubProvider whose tokens are revoked at a fake revocation endpoint
+type revokingProvider struct {
+	*stubProvider
+	endpoint string
+}
+
+func (p *revokingProvider) Revoke(ctx context.Context, token, tokenTypeHint string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.endpoint, strings.NewReader(url.Values{"token": {token}}.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
@@ -0,0 +1,136 @@
+package oidc
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// JWTSigner produces the signed JWTs used for client authentication and DPoP
+// It is implemented by Signer and RotatingSigner
+type JWTSigner interface {
+	ClientAssertion(clientID, audience string, lifetime time.Duration) (string, error)
+	BearerGrantAssertion(issuer, subject, audience string, lifetime time.Duration) (string, error)
+	DPoPProof(method, targetURL, accessToken string) (string, error)
+}
+
+// checkSigner rejects a signer interface holding a nil pointer, e.g. an unassigned *Signer variable
+// Such a signer passes a nil check but panics on first use, so Validate reports it instead
+func checkSigner(name string, s JWTSigner) error {
+	if s == nil {
+		return nil
+	}
+	if v := reflect.ValueOf(s); v.Kind() == reflect.Pointer && v.IsNil() {
+		return fmt.Errorf("%s is a nil %T", name, s)
+	}
+	return nil
+}
+
+// Thumbprint returns the RFC 7638 JWK thumbprint (base64url SHA-256), used as default kid
+func (k JWK) Thumbprint() (string, error) {
+	var members map[string]string
+	switch k.Kty {
+	case "RSA":
+		members = map[string]string{"e": k.E, "kty": k.Kty, "n": k.N}
+	case "EC":
+		members = map[string]string{"crv": k.Crv, "kty": k.Kty, "x": k.X, "y": k.Y}
+	case "OKP":
+		members = map[string]string{"crv": k.Crv, "kty": k.Kty, "x": k.X}
+	default:
+		return "", fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+	// encoding/json sorts map keys, which gives the lexicographic order RFC 7638 requires
+	raw, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(raw)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// RotatingSigner holds a current and an optional next signing key so client keys can be
+// rotated without downtime: publish both keys (PublicJWKS) to the IdP, wait until it has
+// picked up the next key, then call Rotate to start signing with it
+type RotatingSigner struct {
+	mu      sync.RWMutex
+	current *Signer
+	next    *Signer
+}
+
+// NewRotatingSigner creates a rotating signer, next may be nil
+func NewRotatingSigner(current, next *Signer) (*RotatingSigner, error) {
+	if current == nil {
+		return nil, errors.New("rotating signer requires a current key")
+	}
+	return &RotatingSigner{current: current, next: next}, nil
+}
+
+// Current returns the key used for signing
+func (r *RotatingSigner) Current() *Signer {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.current
+}
+
+// Next returns the key staged for the next rotation, or nil
+func (r *RotatingSigner) Next() *Signer {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.next
+}
+
+// SetNext stages a key for the next rotation
+func (r *RotatingSigner) SetNext(next *Signer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.next = next
+}
+
+// Rotate promotes the staged key to current, the previous key is no longer used for signing
+func (r *RotatingSigner) Rotate() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.next == nil {
+		return errors.New("no next signing key staged")
+	}
+	r.current, r.next = r.next, nil
+	return nil
+}
+
+// PublicJWKS returns the public keys of the current and next key, to publish to the IdP
+func (r *RotatingSigner) PublicJWKS() ([]JWK, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var keys []JWK
+	for _, s := range []*Signer{r.current, r.next} {
+		if s == nil {
+			continue
+		}
+		jwk, err := s.PublicJWK()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, jwk)
+	}
+	return keys, nil
+}
+
+// ClientAssertion signs a client assertion with the current key
+func (r *RotatingSigner) ClientAssertion(clientID, audience string, lifetime time.Duration) (string, error) {
+	return r.Current().ClientAssertion(clientID, audience, lifetime)
+}
+
+// BearerGrantAssertion signs a JWT-bearer grant assertion with the current key
+func (r *RotatingSigner) BearerGrantAssertion(issuer, subject, audience string, lifetime time.Duration) (string, error) {
+	return r.Current().BearerGrantAssertion(issuer, subject, audience, lifetime)
+}
+
+// DPoPProof signs a DPoP proof with the current key
+func (r *RotatingSigner) DPoPProof(method, targetURL, accessToken string) (string, error) {
+	return r.Current().DPoPProof(method, targetURL, accessToken)
+}
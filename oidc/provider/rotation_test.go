@@ -0,0 +1,75 @@
+package oidc_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	oidc "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestJWKThumbprint(t *testing.T) {
+	// Example from RFC 7638 section 3.1
+	jwk := oidc.JWK{
+		Kty: "RSA",
+		E:   "AQAB",
+		N:   "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+	}
+	tp, err := jwk.Thumbprint()
+	require.NoError(t, err)
+	require.Equal(t, "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs", tp)
+}
+
+func TestRotatingSigner(t *testing.T) {
+	signers := newTestSigners(t)
+	current, err := oidc.NewSigner(signers["rsa"].Key, "")
+	require.NoError(t, err)
+	require.NotEmpty(t, current.KeyID, "kid defaults to the key thumbprint")
+	next, err := oidc.NewSigner(signers["p256"].Key, "")
+	require.NoError(t, err)
+
+	rs, err := oidc.NewRotatingSigner(current, nil)
+	require.NoError(t, err)
+	require.Error(t, rs.Rotate(), "nothing staged")
+	rs.SetNext(next)
+
+	keys, err := rs.PublicJWKS()
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+
+	var kids []string
+	idToken := validJWT(t)
+	realm := newFakeKeycloak(t, func(w http.ResponseWriter, r *http.Request) {
+		kids = append(kids, decodeSegment(t, r.PostForm.Get("assertion"), 0)["kid"].(string))
+		writeTokenResponse(w, map[string]interface{}{"access_token": "at", "id_token": idToken})
+	})
+	provider := &oidc.KeycloakTokenProvider{Config: &oidc.ConfigKeyCloak{
+		KeycloakRealmURL: realm,
+		KeycloakClientID: "client",
+		GrantType:        oidc.GrantJWTBearer,
+		AssertionSigner:  rs,
+	}}
+	_, err = provider.FetchToken(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, rs.Rotate())
+	require.Nil(t, rs.Next())
+	_, err = provider.FetchToken(context.Background())
+	require.NoError(t, err)
+
+	require.Equal(t, []string{current.KeyID, next.KeyID}, kids)
+}
+
+func TestTypedNilSignerRejected(t *testing.T) {
+	var signer *oidc.Signer
+	kc := &oidc.ConfigKeyCloak{KeycloakRealmURL: "https://kc.example.com/realms/pcs", KeycloakClientID: "c", ClientAssertionSigner: signer}
+	require.ErrorContains(t, kc.Validate(), "nil *oidc.Signer")
+
+	kc = &oidc.ConfigKeyCloak{KeycloakRealmURL: "https://kc.example.com/realms/pcs", KeycloakClientID: "c", GrantType: oidc.GrantJWTBearer, AssertionSigner: signer}
+	require.ErrorContains(t, kc.Validate(), "nil *oidc.Signer")
+
+	var rotating *oidc.RotatingSigner
+	ping := &oidc.ConfigPing{BaseURL: "sso.example.com", ClientID: "c", ClientAssertionSigner: rotating}
+	require.ErrorContains(t, ping.Validate(), "nil *oidc.RotatingSigner")
+}
@@ -0,0 +1,83 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+)
+
+// DefaultScopes are always requested first, an id_token is only issued for the openid scope
+var DefaultScopes = []string{"openid"}
+
+// MergeScopes combines scope layers in order, keeping the first occurrence of each scope
+// Empty entries are ignored, so []string{""} (often produced by splitting an empty env var)
+// simply contributes nothing
+func MergeScopes(layers ...[]string) []string {
+	seen := make(map[string]bool)
+	var merged []string
+	for _, layer := range layers {
+		for _, scope := range layer {
+			if scope == "" || seen[scope] {
+				continue
+			}
+			seen[scope] = true
+			merged = append(merged, scope)
+		}
+	}
+	return merged
+}
+
+// scopesContextKey is the context key for per-call scope additions
+type scopesContextKey struct{}
+
+// ContextWithScopes returns a copy of ctx requesting additional scopes for token fetches made with it
+// Additions accumulate when called more than once
+// TokenCache holds one token per provider, so use a dedicated cache for each distinct scope set
+func ContextWithScopes(ctx context.Context, scopes ...string) context.Context {
+	return context.WithValue(ctx, scopesContextKey{}, MergeScopes(ScopesFromContext(ctx), scopes))
+}
+
+// ScopesFromContext returns the per-call scope additions stored by ContextWithScopes
+func ScopesFromContext(ctx context.Context) []string {
+	scopes, _ := ctx.Value(scopesContextKey{}).([]string)
+	return scopes
+}
+
+// EffectiveScopes returns the scopes requested from Keycloak for a call made with ctx:
+// DefaultScopes, then KeycloakClientScopes, then the per-call additions from ctx,
+// plus OfflineAccessScope when OfflineAccess is set
+func (c *ConfigKeyCloak) EffectiveScopes(ctx context.Context) []string {
+	scopes := MergeScopes(DefaultScopes, c.KeycloakClientScopes, ScopesFromContext(ctx))
+	if c.OfflineAccess {
+		scopes = MergeScopes(scopes, []string{OfflineAccessScope})
+	}
+	return scopes
+}
+
+// reducedScopes returns the scopes to retry with after err, when err is an invalid_scope error,
+// KnownGoodScopes is configured and dropping the unknown scopes changes the request
+func (c *ConfigKeyCloak) reducedScopes(requested []string, err error) ([]string, bool) {
+	var tErr *TokenError
+	if len(c.KnownGoodScopes) == 0 || !errors.As(err, &tErr) || tErr.Code != "invalid_scope" {
+		return nil, false
+	}
+	reduced := IntersectScopes(requested, MergeScopes(DefaultScopes, c.KnownGoodScopes))
+	if len(reduced) == len(requested) {
+		return nil, false
+	}
+	return reduced, true
+}
+
+// IntersectScopes returns the scopes of requested that are also in allowed, in requested order
+func IntersectScopes(requested, allowed []string) []string {
+	ok := make(map[string]bool, len(allowed))
+	for _, scope := range allowed {
+		ok[scope] = true
+	}
+	var out []string
+	for _, scope := range requested {
+		if ok[scope] {
+			out = append(out, scope)
+		}
+	}
+	return out
+}
@@ -0,0 +1,103 @@
+package oidc_test
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+
+	oidc "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergeScopes(t *testing.T) {
+	require.Equal(t, []string{"openid", "profile", "email"},
+		oidc.MergeScopes([]string{"openid"}, []string{"", "profile", "openid"}, []string{"email", "profile"}))
+	require.Nil(t, oidc.MergeScopes(nil, []string{""}))
+}
+
+func TestKeycloakEffectiveScopes(t *testing.T) {
+	var requested []string
+	realm := newFakeKeycloak(t, func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, r.PostForm.Get("scope"))
+		writeTokenResponse(w, map[string]interface{}{"access_token": "a", "id_token": validJWT(t)})
+	})
+
+	var mu sync.Mutex
+	var events []oidc.Event
+	p := &oidc.KeycloakTokenProvider{
+		Config: &oidc.ConfigKeyCloak{
+			KeycloakRealmURL: realm, KeycloakClientID: "svc", KeycloakClientSecret: "secret",
+			KeycloakClientScopes: []string{"profile"},
+		},
+		OnEvent: func(ev oidc.Event) {
+			mu.Lock()
+			defer mu.Unlock()
+			events = append(events, ev)
+		},
+	}
+
+	ctx := oidc.ContextWithScopes(context.Background(), "email")
+	ctx = oidc.ContextWithScopes(ctx, "profile", "roles")
+	_, err := p.FetchToken(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"openid profile email roles"}, requested)
+
+	require.Len(t, events, 2)
+	require.Equal(t, oidc.EventTokenRequest, events[0].Type)
+	require.Equal(t, []string{"openid", "profile", "email", "roles"}, events[0].Scopes)
+	require.Equal(t, oidc.EventTokenFetched, events[1].Type)
+	require.Equal(t, "keycloak", events[1].Provider)
+
+	t.Run("empty configured scope still requests openid", func(t *testing.T) {
+		cfg := &oidc.ConfigKeyCloak{KeycloakClientScopes: []string{""}}
+		require.Equal(t, []string{"openid"}, cfg.EffectiveScopes(context.Background()))
+	})
+}
+
+func TestScopeReductionRetry(t *testing.T) {
+	var requested []string
+	realm := newFakeKeycloak(t, func(w http.ResponseWriter, r *http.Request) {
+		scope := r.PostForm.Get("scope")
+		requested = append(requested, scope)
+		if scope != "openid profile" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"invalid_scope","error_description":"Invalid scopes: openid profile orders:write"}`))
+			return
+		}
+		writeTokenResponse(w, map[string]interface{}{"access_token": "a", "id_token": validJWT(t)})
+	})
+	var events []oidc.Event
+	cfg := &oidc.ConfigKeyCloak{
+		KeycloakRealmURL: realm, KeycloakClientID: "svc", KeycloakClientSecret: "secret",
+		KeycloakClientScopes: []string{"profile", "orders:write"},
+	}
+	p := &oidc.KeycloakTokenProvider{Config: cfg, OnEvent: func(ev oidc.Event) { events = append(events, ev) }}
+
+	// Without known-good scopes the error is returned as is
+	_, err := p.FetchToken(context.Background())
+	var tErr *oidc.TokenError
+	require.ErrorAs(t, err, &tErr)
+	require.Equal(t, "invalid_scope", tErr.Code)
+	require.NotContains(t, requested, "openid profile")
+
+	requested, events = nil, nil
+	cfg.KnownGoodScopes = []string{"profile", "email"}
+	_, err = p.FetchToken(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "openid profile orders:write", requested[0])
+	require.Equal(t, "openid profile", requested[len(requested)-1])
+	require.Len(t, events, 3)
+	require.Equal(t, oidc.EventScopeReduced, events[1].Type)
+	require.Equal(t, []string{"openid", "profile"}, events[1].Scopes)
+	require.ErrorAs(t, events[1].Err, &tErr)
+	require.Equal(t, oidc.EventTokenFetched, events[2].Type)
+	require.Equal(t, []string{"openid", "profile"}, events[2].Scopes)
+}
+
+func TestIntersectScopes(t *testing.T) {
+	require.Equal(t, []string{"openid", "email"}, oidc.IntersectScopes([]string{"openid", "orders", "email"}, []string{"email", "openid"}))
+	require.Nil(t, oidc.IntersectScopes([]string{"orders"}, nil))
+}
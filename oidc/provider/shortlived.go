@@ -0,0 +1,130 @@
+package oidc
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// Defaults of ShortLivedOptions
+const (
+	DefaultPrefetchAt = 0.5
+	DefaultOverlap    = 5 * time.Second
+	DefaultJitter     = 0.1
+)
+
+// shortLivedRetry is the pause before a failed prefetch is tried again
+const shortLivedRetry = time.Second
+
+// ShortLivedOptions tune the short-lived token mode, zero fields use the defaults
+type ShortLivedOptions struct {
+	// PrefetchAt is the fraction of the lifetime after which the next token is fetched in the background
+	PrefetchAt float64
+	// Overlap is how long before expiry callers stop getting the current token and wait for the next one
+	Overlap time.Duration
+	// Jitter spreads the prefetch point by up to this fraction of the lifetime in either direction,
+	// so replicas started together do not hit the STS in lockstep; negative disables it
+	Jitter float64
+}
+
+// WithShortLivedMode optimizes the cache for sub-minute token lifetimes, e.g. a partner STS issuing
+// 45 second tokens where the one minute default buffer would refetch on every call
+// Once PrefetchAt of the lifetime has passed the next token is fetched in the background while callers
+// keep getting the current one; callers only block when the token is within Overlap of its expiry
+// It replaces WithRefreshStrategy, the strict minimum of WithMinRemaining still applies
+func WithShortLivedMode(opts ShortLivedOptions) CacheOption {
+	if opts.PrefetchAt <= 0 || opts.PrefetchAt >= 1 {
+		opts.PrefetchAt = DefaultPrefetchAt
+	}
+	if opts.Overlap <= 0 {
+		opts.Overlap = DefaultOverlap
+	}
+	if opts.Jitter == 0 {
+		opts.Jitter = DefaultJitter
+	} else if opts.Jitter < 0 {
+		opts.Jitter = 0
+	}
+	return func(c *TokenCache) {
+		c.short = &opts
+	}
+}
+
+// shortLivedRefreshAt returns when callers stop getting the cached token, the caller must hold c.mu
+func (c *TokenCache) shortLivedRefreshAt() time.Time {
+	overlap := c.short.Overlap
+	if c.minRemaining > overlap {
+		overlap = c.minRemaining
+	}
+	return c.expiry.Add(-overlap)
+}
+
+// schedulePrefetch picks the jittered prefetch point of the cached token, the caller must hold c.mu
+func (c *TokenCache) schedulePrefetch() {
+	if c.short == nil {
+		return
+	}
+	start := c.issuedAt
+	if start.IsZero() || !start.Before(c.expiry) {
+		start = time.Now()
+	}
+	lifetime := float64(c.expiry.Sub(start))
+	spread := (rand.Float64()*2 - 1) * c.short.Jitter
+	at := start.Add(time.Duration(lifetime * (c.short.PrefetchAt + spread)))
+	if latest := c.shortLivedRefreshAt(); at.After(latest) {
+		at = latest
+	}
+	c.prefetchAt = at
+}
+
+// maybePrefetch starts a background fetch once the prefetch point has passed, the caller must hold c.mu
+// At most one prefetch runs at a time, its token replaces the cached one when it arrives
+// The fetch keeps the values of the triggering call's ctx (per-call scopes, subject, tracing, ...)
+// but not its cancellation, the caller returns before the prefetch ends
+func (c *TokenCache) maybePrefetch(ctx context.Context) {
+	if c.short == nil || c.prefetching != nil || time.Now().Before(c.prefetchAt) {
+		return
+	}
+	done := make(chan struct{})
+	c.prefetching = done
+	// The prefetch is useless after the current token expired, callers fetch in the foreground then
+	timeout := time.Until(c.expiry)
+	if timeout < shortLivedRetry {
+		timeout = shortLivedRetry
+	}
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		defer close(done)
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		f, err := c.fetch(ctx)
+
+		c.mu.Lock()
+		defer c.unlock()
+		c.prefetching = nil
+		if err == nil {
+			// Stores and watchers run user code, a panic there must not end the process
+			err = protect("prefetch", func() error {
+				c.install(ctx, f)
+				return nil
+			})
+		}
+		c.recordFetch(true, err)
+		if err != nil {
+			// The current token is still served, try again shortly
+			c.prefetchAt = time.Now().Add(shortLivedRetry)
+		}
+	}()
+}
+
+// awaitPrefetch waits for the running prefetch, the caller must hold c.mu which is released meanwhile
+func (c *TokenCache) awaitPrefetch(ctx context.Context) error {
+	done := c.prefetching
+	c.mu.Unlock()
+	defer c.mu.Lock()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,156 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	oidc "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+// shortLivedSTS issues opaque tokens t1, t2, ... reporting lifetime as expires_in
+// Fetches after the first block until release is closed when gate is set
+type shortLivedSTS struct {
+	lifetime time.Duration
+	gate     chan struct{}
+	fail     atomic.Bool
+	calls    atomic.Int32
+}
+
+func (s *shortLivedSTS) FetchToken(ctx context.Context) (string, error) {
+	n := s.calls.Add(1)
+	if n > 1 && s.gate != nil {
+		select {
+		case <-s.gate:
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+	if s.fail.Load() {
+		return "", errors.New("sts unavailable")
+	}
+	oidc.ReportExpiry(ctx, time.Now().Add(s.lifetime))
+	return fmt.Sprintf("t%d", n), nil
+}
+
+func TestShortLivedMode(t *testing.T) {
+	ctx := context.Background()
+	opts := oidc.ShortLivedOptions{PrefetchAt: 0.3, Overlap: 200 * time.Millisecond, Jitter: -1}
+
+	t.Run("current token before the prefetch point", func(t *testing.T) {
+		sts := &shortLivedSTS{lifetime: time.Second}
+		cache := oidc.NewTokenCache(sts, oidc.WithShortLivedMode(opts))
+		for i := 0; i < 3; i++ {
+			token, err := cache.GetValidToken(ctx)
+			require.NoError(t, err)
+			require.Equal(t, "t1", token)
+		}
+		require.EqualValues(t, 1, sts.calls.Load())
+	})
+
+	t.Run("prefetch does not block callers", func(t *testing.T) {
+		sts := &shortLivedSTS{lifetime: time.Second, gate: make(chan struct{})}
+		cache := oidc.NewTokenCache(sts, oidc.WithShortLivedMode(opts))
+		_, err := cache.GetValidToken(ctx)
+		require.NoError(t, err)
+
+		time.Sleep(400 * time.Millisecond)
+		token, err := cache.GetValidToken(ctx)
+		require.NoError(t, err)
+		require.Equal(t, "t1", token, "the current token is served while the next one is fetched")
+		require.Eventually(t, func() bool { return sts.calls.Load() == 2 }, time.Second, 5*time.Millisecond)
+
+		close(sts.gate)
+		require.Eventually(t, func() bool {
+			token, err := cache.GetValidToken(ctx)
+			return err == nil && token == "t2"
+		}, time.Second, 5*time.Millisecond)
+		require.EqualValues(t, 2, sts.calls.Load())
+	})
+
+	t.Run("prefetch keeps the values of the triggering call", func(t *testing.T) {
+		var scopes atomic.Value
+		sts := &shortLivedSTS{lifetime: time.Second}
+		cache := oidc.NewTokenCache(oidc.ProviderFunc(func(ctx context.Context) (string, error) {
+			scopes.Store(oidc.ScopesFromContext(ctx))
+			return sts.FetchToken(ctx)
+		}), oidc.WithShortLivedMode(opts))
+		_, err := cache.GetValidToken(ctx)
+		require.NoError(t, err)
+
+		time.Sleep(400 * time.Millisecond)
+		call, cancel := context.WithCancel(oidc.ContextWithScopes(ctx, "orders:read"))
+		_, err = cache.GetValidToken(call)
+		require.NoError(t, err)
+		// Returning ends the caller's request, the prefetch must not be canceled with it
+		cancel()
+		require.Eventually(t, func() bool {
+			token, err := cache.GetValidToken(ctx)
+			return err == nil && token == "t2"
+		}, time.Second, 5*time.Millisecond)
+		require.Equal(t, []string{"orders:read"}, scopes.Load())
+	})
+
+	t.Run("overlap window waits for the prefetch", func(t *testing.T) {
+		sts := &shortLivedSTS{lifetime: time.Second, gate: make(chan struct{})}
+		cache := oidc.NewTokenCache(sts, oidc.WithShortLivedMode(opts))
+		_, err := cache.GetValidToken(ctx)
+		require.NoError(t, err)
+
+		time.Sleep(400 * time.Millisecond)
+		_, err = cache.GetValidToken(ctx)
+		require.NoError(t, err)
+
+		// Inside the overlap window the caller blocks on the running prefetch instead of fetching again
+		time.Sleep(500 * time.Millisecond)
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			close(sts.gate)
+		}()
+		token, err := cache.GetValidToken(ctx)
+		require.NoError(t, err)
+		require.Equal(t, "t2", token)
+		require.EqualValues(t, 2, sts.calls.Load())
+	})
+
+	t.Run("failed prefetch keeps the current token", func(t *testing.T) {
+		sts := &shortLivedSTS{lifetime: time.Second}
+		cache := oidc.NewTokenCache(sts, oidc.WithShortLivedMode(opts))
+		_, err := cache.GetValidToken(ctx)
+		require.NoError(t, err)
+
+		sts.fail.Store(true)
+		time.Sleep(400 * time.Millisecond)
+		token, err := cache.GetValidToken(ctx)
+		require.NoError(t, err)
+		require.Equal(t, "t1", token)
+		require.Eventually(t, func() bool { return cache.Status().LastError != "" }, time.Second, 5*time.Millisecond)
+
+		token, err = cache.GetValidToken(ctx)
+		require.NoError(t, err)
+		require.Equal(t, "t1", token)
+	})
+
+	t.Run("caller context cancels the wait", func(t *testing.T) {
+		sts := &shortLivedSTS{lifetime: 500 * time.Millisecond, gate: make(chan struct{})}
+		defer close(sts.gate)
+		cache := oidc.NewTokenCache(sts, oidc.WithShortLivedMode(opts))
+		_, err := cache.GetValidToken(ctx)
+		require.NoError(t, err)
+
+		time.Sleep(200 * time.Millisecond)
+		_, err = cache.GetValidToken(ctx)
+		require.NoError(t, err)
+		time.Sleep(150 * time.Millisecond)
+
+		waitCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+		defer cancel()
+		_, err = cache.GetValidToken(waitCtx)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
@@ -0,0 +1,277 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// Signer signs JWTs (client assertions, JWT-bearer grants, DPoP proofs) with a private key
+// RSA, EC (P-256/P-384/P-521) and Ed25519 keys are supported
+type Signer struct {
+	Key       crypto.Signer
+	KeyID     string // kid header, optional
+	Algorithm string // JWS alg, inferred from the key when empty
+}
+
+// NewSigner creates a signer and infers the JWS algorithm from the key type
+// RSA keys use RS256, EC keys ES256/ES384/ES512 by curve and Ed25519 keys EdDSA
+// An empty keyID is replaced by the RFC 7638 thumbprint of the public key
+func NewSigner(key crypto.Signer, keyID string) (*Signer, error) {
+	alg, err := algorithmForKey(key)
+	if err != nil {
+		return nil, err
+	}
+	s := &Signer{Key: key, KeyID: keyID, Algorithm: alg}
+	if keyID == "" {
+		jwk, err := s.PublicJWK()
+		if err != nil {
+			return nil, err
+		}
+		if s.KeyID, err = jwk.Thumbprint(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// NewSignerFromPEM parses a PEM encoded private key (PKCS#8, PKCS#1 or SEC 1) and creates a signer
+func NewSignerFromPEM(pemBytes []byte, keyID string) (*Signer, error) {
+	key, err := ParsePrivateKeyPEM(pemBytes)
+	if err != nil {
+		return nil, err
+	}
+	return NewSigner(key, keyID)
+}
+
+// ParsePrivateKeyPEM parses a PEM encoded RSA, EC or Ed25519 private key
+func ParsePrivateKeyPEM(pemBytes []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, errors.New("no PEM block found in private key")
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type %T", key)
+		}
+		return signer, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	return nil, errors.New("unsupported private key format, expected PKCS#8, PKCS#1 or SEC 1")
+}
+
+// NewSignerFromJWK creates a signer from a private JWK (RFC 7517), the key's kid is used when set
+func NewSignerFromJWK(data []byte) (*Signer, error) {
+	key, kid, err := ParsePrivateKeyJWK(data)
+	if err != nil {
+		return nil, err
+	}
+	return NewSigner(key, kid)
+}
+
+// privateJWK is a JWK including the private members of RSA, EC and OKP keys
+type privateJWK struct {
+	JWK
+	D  string `json:"d"`
+	P  string `json:"p"`
+	Q  string `json:"q"`
+	DP string `json:"dp"`
+	DQ string `json:"dq"`
+	QI string `json:"qi"`
+}
+
+// ParsePrivateKeyJWK parses a private RSA, EC or Ed25519 JWK and returns the key and its kid
+func ParsePrivateKeyJWK(data []byte) (crypto.Signer, string, error) {
+	var k privateJWK
+	if err := json.Unmarshal(data, &k); err != nil {
+		return nil, "", fmt.Errorf("invalid JWK: %w", err)
+	}
+	if k.D == "" {
+		return nil, "", errors.New("JWK has no private key")
+	}
+	pub, err := k.PublicKey()
+	if err != nil {
+		return nil, "", err
+	}
+	d, err := base64.RawURLEncoding.DecodeString(k.D)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid JWK private key: %w", err)
+	}
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		key := &rsa.PrivateKey{PublicKey: *pub, D: new(big.Int).SetBytes(d)}
+		for _, prime := range []string{k.P, k.Q} {
+			b, err := base64.RawURLEncoding.DecodeString(prime)
+			if err != nil || len(b) == 0 {
+				return nil, "", errors.New("RSA JWK must include the primes p and q")
+			}
+			key.Primes = append(key.Primes, new(big.Int).SetBytes(b))
+		}
+		if err := key.Validate(); err != nil {
+			return nil, "", fmt.Errorf("invalid RSA JWK: %w", err)
+		}
+		key.Precompute()
+		return key, k.Kid, nil
+	case *ecdsa.PublicKey:
+		key := &ecdsa.PrivateKey{PublicKey: *pub, D: new(big.Int).SetBytes(d)}
+		// The private scalar must belong to the public point, a mismatch would only show up at the IdP
+		if x, y := pub.Curve.ScalarBaseMult(d); x.Cmp(pub.X) != 0 || y.Cmp(pub.Y) != 0 {
+			return nil, "", errors.New("invalid EC JWK: private key does not match the public key")
+		}
+		return key, k.Kid, nil
+	case ed25519.PublicKey:
+		if len(d) != ed25519.SeedSize {
+			return nil, "", errors.New("invalid Ed25519 JWK private key")
+		}
+		key := ed25519.NewKeyFromSeed(d)
+		if !key.Public().(ed25519.PublicKey).Equal(pub) {
+			return nil, "", errors.New("invalid Ed25519 JWK: private key does not match the public key")
+		}
+		return key, k.Kid, nil
+	}
+	return nil, "", fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// algorithmForKey returns the default JWS algorithm for a private key
+func algorithmForKey(key crypto.Signer) (string, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return "RS256", nil
+	case *ecdsa.PrivateKey:
+		switch k.Curve {
+		case elliptic.P256():
+			return "ES256", nil
+		case elliptic.P384():
+			return "ES384", nil
+		case elliptic.P521():
+			return "ES512", nil
+		}
+		return "", fmt.Errorf("unsupported EC curve %s", k.Curve.Params().Name)
+	case ed25519.PrivateKey:
+		return "EdDSA", nil
+	}
+	return "", fmt.Errorf("unsupported signing key type %T", key)
+}
+
+// Sign serializes and signs claims as a compact JWT
+// extraHeader fields are added to the JOSE header (e.g. "typ" or "jwk" for DPoP)
+func (s *Signer) Sign(claims map[string]interface{}, extraHeader map[string]interface{}) (string, error) {
+	if s == nil || s.Key == nil {
+		return "", errors.New("signer has no key")
+	}
+	alg := s.Algorithm
+	if alg == "" {
+		var err error
+		if alg, err = algorithmForKey(s.Key); err != nil {
+			return "", err
+		}
+	}
+	header := map[string]interface{}{"alg": alg, "typ": "JWT"}
+	if s.KeyID != "" {
+		header["kid"] = s.KeyID
+	}
+	for k, v := range extraHeader {
+		header[k] = v
+	}
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	input := base64.RawURLEncoding.EncodeToString(headerJSON) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	sig, err := s.signInput(alg, []byte(input))
+	if err != nil {
+		return "", err
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// signInput produces the JWS signature bytes for alg
+func (s *Signer) signInput(alg string, input []byte) ([]byte, error) {
+	if alg == "EdDSA" {
+		return s.Key.Sign(rand.Reader, input, crypto.Hash(0))
+	}
+	hash := hashForAlg(alg)
+	h := hash.New()
+	h.Write(input)
+	digest := h.Sum(nil)
+	switch alg[:2] {
+	case "RS":
+		return s.Key.Sign(rand.Reader, digest, hash)
+	case "PS":
+		return s.Key.Sign(rand.Reader, digest, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: hash})
+	case "ES":
+		der, err := s.Key.Sign(rand.Reader, digest, hash)
+		if err != nil {
+			return nil, err
+		}
+		pub, ok := s.Key.Public().(*ecdsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("key type does not match %s", alg)
+		}
+		// JWS uses the fixed size r||s encoding instead of ASN.1
+		var sig struct{ R, S *big.Int }
+		if _, err := asn1.Unmarshal(der, &sig); err != nil {
+			return nil, fmt.Errorf("invalid ECDSA signature: %w", err)
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		out := make([]byte, 2*size)
+		sig.R.FillBytes(out[:size])
+		sig.S.FillBytes(out[size:])
+		return out, nil
+	}
+	return nil, fmt.Errorf("unsupported signing algorithm %q", alg)
+}
+
+// PublicJWK returns the public half of the signing key as a JWK, e.g. for registering it in the IdP
+func (s *Signer) PublicJWK() (JWK, error) {
+	b64 := base64.RawURLEncoding.EncodeToString
+	jwk := JWK{Kid: s.KeyID, Use: "sig", Alg: s.Algorithm}
+	switch pub := s.Key.Public().(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = b64(pub.N.Bytes())
+		jwk.E = b64(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		jwk.Kty = "EC"
+		jwk.Crv = pub.Curve.Params().Name
+		jwk.X = b64(pub.X.FillBytes(make([]byte, size)))
+		jwk.Y = b64(pub.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = b64(pub)
+	default:
+		return JWK{}, fmt.Errorf("unsupported public key type %T", pub)
+	}
+	return jwk, nil
+}
+
+// randomID returns a random hex identifier, used for jti claims
+func randomID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
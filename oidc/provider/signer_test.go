@@ -0,0 +1,250 @@
+package oidc_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	oidc "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+// decodeSegment decodes one JWT segment into a map
+func decodeSegment(t *testing.T, token string, i int) map[string]interface{} {
+	t.Helper()
+	raw, err := base64.RawURLEncoding.DecodeString(strings.Split(token, ".")[i])
+	require.NoError(t, err)
+	var out map[string]interface{}
+	require.NoError(t, json.Unmarshal(raw, &out))
+	return out
+}
+
+// serveJWKS publishes the public keys of the given signers
+func serveJWKS(t *testing.T, signers ...*oidc.Signer) string {
+	t.Helper()
+	var keys []oidc.JWK
+	for _, s := range signers {
+		jwk, err := s.PublicJWK()
+		require.NoError(t, err)
+		keys = append(keys, jwk)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+func newTestSigners(t *testing.T) map[string]*oidc.Signer {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	out := map[string]*oidc.Signer{}
+	for kid, key := range map[string]crypto.Signer{"rsa": rsaKey, "p256": p256, "p384": p384, "ed": edKey} {
+		s, err := oidc.NewSigner(key, kid)
+		require.NoError(t, err)
+		out[kid] = s
+	}
+	return out
+}
+
+func TestSignerAlgorithms(t *testing.T) {
+	signers := newTestSigners(t)
+	require.Equal(t, "RS256", signers["rsa"].Algorithm)
+	require.Equal(t, "ES256", signers["p256"].Algorithm)
+	require.Equal(t, "ES384", signers["p384"].Algorithm)
+	require.Equal(t, "EdDSA", signers["ed"].Algorithm)
+
+	var all []*oidc.Signer
+	for _, s := range signers {
+		all = append(all, s)
+	}
+	verifier := oidc.NewVerifier(oidc.VerifierConfig{JWKSURL: serveJWKS(t, all...), Audience: "https://kc/token"})
+	for kid, s := range signers {
+		t.Run(kid, func(t *testing.T) {
+			assertion, err := s.ClientAssertion("my-client", "https://kc/token", time.Minute)
+			require.NoError(t, err)
+			claims, err := verifier.Verify(context.Background(), assertion)
+			require.NoError(t, err)
+			require.Equal(t, "my-client", claims.Subject)
+			require.Equal(t, "my-client", claims.Raw["iss"])
+			require.NotEmpty(t, claims.Raw["jti"])
+		})
+	}
+}
+
+func TestNewSignerFromPEM(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	s, err := oidc.NewSignerFromPEM(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), "k1")
+	require.NoError(t, err)
+	require.Equal(t, "ES256", s.Algorithm)
+
+	sec1, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	_, err = oidc.NewSignerFromPEM(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: sec1}), "k1")
+	require.NoError(t, err)
+
+	_, err = oidc.NewSignerFromPEM([]byte("not pem"), "k1")
+	require.Error(t, err)
+}
+
+func TestDPoPProof(t *testing.T) {
+	s := newTestSigners(t)["p256"]
+	proof, err := s.DPoPProof("post", "https://kc/token?x=1#frag", "access-token")
+	require.NoError(t, err)
+
+	header := decodeSegment(t, proof, 0)
+	require.Equal(t, "dpop+jwt", header["typ"])
+	require.Equal(t, "ES256", header["alg"])
+	require.Nil(t, header["kid"])
+	jwk := header["jwk"].(map[string]interface{})
+	require.Equal(t, "EC", jwk["kty"])
+	require.Equal(t, "P-256", jwk["crv"])
+
+	claims := decodeSegment(t, proof, 1)
+	require.Equal(t, "POST", claims["htm"])
+	require.Equal(t, "https://kc/token", claims["htu"])
+	require.NotEmpty(t, claims["ath"])
+	require.NotEmpty(t, claims["jti"])
+}
+
+func TestKeycloakJWTBearerGrant(t *testing.T) {
+	signer := newTestSigners(t)["ed"]
+	idToken := validJWT(t)
+	var assertion string
+	realm := newFakeKeycloak(t, func(w http.ResponseWriter, r *http.Request) {
+		assertion = r.PostForm.Get("assertion")
+		writeTokenResponse(w, map[string]interface{}{"access_token": "at", "id_token": idToken})
+	})
+	provider := &oidc.KeycloakTokenProvider{Config: &oidc.ConfigKeyCloak{
+		KeycloakRealmURL: realm,
+		KeycloakClientID: "client",
+		GrantType:        oidc.GrantJWTBearer,
+		AssertionSigner:  signer,
+		AssertionSubject: "alice",
+	}}
+	token, err := provider.FetchToken(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, idToken, token)
+	require.Equal(t, "alice", decodeSegment(t, assertion, 1)["sub"])
+	require.Equal(t, "EdDSA", decodeSegment(t, assertion, 0)["alg"])
+}
+
+// privateJWK encodes the private members of key as a JWK
+func privateJWK(t *testing.T, key crypto.Signer, kid string) []byte {
+	t.Helper()
+	b64 := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+	jwk := map[string]string{"kid": kid}
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		jwk["kty"], jwk["n"], jwk["e"] = "RSA", b64(k.N.Bytes()), b64(big.NewInt(int64(k.E)).Bytes())
+		jwk["d"], jwk["p"], jwk["q"] = b64(k.D.Bytes()), b64(k.Primes[0].Bytes()), b64(k.Primes[1].Bytes())
+	case *ecdsa.PrivateKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		jwk["kty"], jwk["crv"] = "EC", k.Curve.Params().Name
+		jwk["x"], jwk["y"], jwk["d"] = b64(k.X.FillBytes(make([]byte, size))), b64(k.Y.FillBytes(make([]byte, size))), b64(k.D.FillBytes(make([]byte, size)))
+	case ed25519.PrivateKey:
+		jwk["kty"], jwk["crv"], jwk["x"], jwk["d"] = "OKP", "Ed25519", b64(k.Public().(ed25519.PublicKey)), b64(k.Seed())
+	}
+	data, err := json.Marshal(jwk)
+	require.NoError(t, err)
+	return data
+}
+
+func TestNewSignerFromJWK(t *testing.T) {
+	for kid, s := range newTestSigners(t) {
+		t.Run(kid, func(t *testing.T) {
+			parsed, err := oidc.NewSignerFromJWK(privateJWK(t, s.Key, kid))
+			require.NoError(t, err)
+			require.Equal(t, kid, parsed.KeyID)
+			require.Equal(t, s.Algorithm, parsed.Algorithm)
+			want, err := s.PublicJWK()
+			require.NoError(t, err)
+			got, err := parsed.PublicJWK()
+			require.NoError(t, err)
+			require.Equal(t, want, got)
+			_, err = parsed.ClientAssertion("client", "https://idp.example.com/token", 0)
+			require.NoError(t, err)
+		})
+	}
+
+	t.Run("invalid keys", func(t *testing.T) {
+		signers := newTestSigners(t)
+		var mixed map[string]string
+		require.NoError(t, json.Unmarshal(privateJWK(t, signers["p256"].Key, "k"), &mixed))
+		other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		mixed["d"] = base64.RawURLEncoding.EncodeToString(other.D.FillBytes(make([]byte, 32)))
+		data, err := json.Marshal(mixed)
+		require.NoError(t, err)
+		_, err = oidc.NewSignerFromJWK(data)
+		require.ErrorContains(t, err, "does not match")
+
+		public, err := signers["rsa"].PublicJWK()
+		require.NoError(t, err)
+		data, err = json.Marshal(public)
+		require.NoError(t, err)
+		_, err = oidc.NewSignerFromJWK(data)
+		require.ErrorContains(t, err, "no private key")
+	})
+}
+
+func TestKeycloakClientAssertion(t *testing.T) {
+	signer := newTestSigners(t)["rsa"]
+	idToken := validJWT(t)
+	var assertions []string
+	var tokenURL string
+	realm := newFakeKeycloak(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _, basic := r.BasicAuth()
+		require.False(t, basic)
+		require.Empty(t, r.PostForm.Get("client_secret"))
+		require.Equal(t, "client", r.PostForm.Get("client_id"))
+		require.Equal(t, oidc.ClientAssertionType, r.PostForm.Get("client_assertion_type"))
+		require.Equal(t, "client_credentials", r.PostForm.Get("grant_type"))
+		assertions = append(assertions, r.PostForm.Get("client_assertion"))
+		writeTokenResponse(w, map[string]interface{}{"access_token": "at", "id_token": idToken})
+	})
+	tokenURL = realm + "/protocol/openid-connect/token"
+	provider := &oidc.KeycloakTokenProvider{Config: &oidc.ConfigKeyCloak{
+		KeycloakRealmURL:      realm,
+		KeycloakClientID:      "client",
+		ClientAssertionSigner: signer,
+	}}
+	for i := 0; i < 2; i++ {
+		token, err := provider.FetchToken(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, idToken, token)
+	}
+	require.Len(t, assertions, 2)
+	claims := decodeSegment(t, assertions[0], 1)
+	require.Equal(t, "client", claims["iss"])
+	require.Equal(t, "client", claims["sub"])
+	require.Equal(t, tokenURL, claims["aud"])
+	require.NotEqual(t, claims["jti"], decodeSegment(t, assertions[1], 1)["jti"], "every request gets a new jti")
+	require.Equal(t, "RS256", decodeSegment(t, assertions[0], 0)["alg"])
+}
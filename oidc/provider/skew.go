@@ -0,0 +1,234 @@
+package oidc
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultSkewRetryDelay is how long a request failing because of clock skew waits before its single retry
+const DefaultSkewRetryDelay = 2 * time.Second
+
+// clockSkewPattern matches error descriptions of IdPs, STS and resource servers rejecting
+// a token that is not valid yet because the issuer clock is ahead of the verifier clock
+// Only token wording (iat/nbf, "token ... not yet valid", "token ... in the future") matches, so
+// TLS errors such as "certificate has expired or is not yet valid" are never retried
+var clockSkewPattern = regexp.MustCompile(`(?i)(\b(iat|nbf)\b[^.;:]*\b(future|not yet|before|skew)|\b(future|not yet|before)\b[^.;:]*\b(iat|nbf)\b|\btoken\b[^.;:]*\b(not yet valid|in the future|used before)|clock skew)`)
+
+// IsClockSkewError reports whether err looks like a token rejected for iat/nbf in the future
+func IsClockSkewError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var tErr *TokenError
+	if errors.As(err, &tErr) {
+		return clockSkewPattern.MatchString(tErr.Description) || clockSkewPattern.MatchString(tErr.Code)
+	}
+	var vErr *VerificationError
+	if errors.As(err, &vErr) {
+		return vErr.Reason == ReasonNotYetValid
+	}
+	var certErr x509.CertificateInvalidError
+	if errors.As(err, &certErr) {
+		return false
+	}
+	return clockSkewPattern.MatchString(err.Error())
+}
+
+// SkewStats reports the clock offset measured from the Date header of IdP/STS responses
+// A positive offset means the remote clock is ahead of the local clock
+// Date headers have one second resolution, offsets below that are noise
+type SkewStats struct {
+	Last    time.Duration
+	Max     time.Duration // largest absolute offset seen
+	Samples uint64
+	Retries uint64 // requests retried because of a clock skew error
+}
+
+var skew struct {
+	mu      sync.Mutex
+	last    time.Duration
+	max     time.Duration
+	samples uint64
+	retries atomic.Uint64
+}
+
+// ClockSkew returns the measured clock skew metrics
+func ClockSkew() SkewStats {
+	skew.mu.Lock()
+	defer skew.mu.Unlock()
+	return SkewStats{Last: skew.last, Max: skew.max, Samples: skew.samples, Retries: skew.retries.Load()}
+}
+
+// observeDate records the offset between the response Date header and the local clock
+// sent and received bracket the request, their midpoint approximates when the server answered
+func observeDate(resp *http.Response, sent, received time.Time) {
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return
+	}
+	offset := date.Sub(sent.Add(received.Sub(sent) / 2)).Round(time.Second)
+	skew.mu.Lock()
+	defer skew.mu.Unlock()
+	skew.last = offset
+	if offset.Abs() > skew.max {
+		skew.max = offset.Abs()
+	}
+	skew.samples++
+}
+
+// WarnClockSkew logs that a request is retried because of clock skew and counts the retry
+func WarnClockSkew(source string, err error) {
+	skew.retries.Add(1)
+	slog.Default().Warn("token rejected as not yet valid, host clock may be skewed; retrying once",
+		slog.String("source", source),
+		slog.Duration("measured_skew", ClockSkew().Last),
+		slog.String("error", err.Error()),
+	)
+}
+
+// WaitClockSkew reports a clock skew retry like WarnClockSkew, then waits delay before the retry
+// A zero delay waits DefaultSkewRetryDelay; the wait ends early with ctx's error when ctx is done
+func WaitClockSkew(ctx context.Context, source string, err error, delay time.Duration) error {
+	WarnClockSkew(source, err)
+	if delay <= 0 {
+		delay = DefaultSkewRetryDelay
+	}
+	return sleepContext(ctx, delay)
+}
+
+// skewObserver measures clock skew from the Date header of every response
+type skewObserver struct {
+	Base http.RoundTripper
+}
+
+func (t *skewObserver) RoundTrip(req *http.Request) (*http.Response, error) {
+	sent := time.Now()
+	resp, err := t.Base.RoundTrip(req)
+	if err == nil {
+		observeDate(resp, sent, time.Now())
+	}
+	return resp, err
+}
+
+// SkewRetryTransport retries a request to a resource server once, after Delay,
+// when it is rejected with 401 because the presented token is not valid yet
+type SkewRetryTransport struct {
+	Base  http.RoundTripper // default http.DefaultTransport
+	Delay time.Duration     // wait before the retry, default DefaultSkewRetryDelay
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *SkewRetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+	}
+	attempt := func() (*http.Response, error) {
+		r := req.Clone(req.Context())
+		if body != nil {
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		return base.RoundTrip(r)
+	}
+	resp, err := attempt()
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	challenge := resp.Header.Get("WWW-Authenticate")
+	if !strings.Contains(challenge, "invalid_token") || !clockSkewPattern.MatchString(challenge) {
+		return resp, nil
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if err := WaitClockSkew(req.Context(), req.URL.Host, errors.New(challenge), t.Delay); err != nil {
+		return nil, err
+	}
+	return attempt()
+}
+
+// ClockOffset estimates how far an issuer's clock is ahead of the local clock, for hosts
+// without NTP whose clock drifts. Caches and verifiers given a ClockOffset translate
+// token times (exp, nbf) into local time instead of trusting the local clock
+// The offset is the median of the last clockOffsetSamples iat observations of tokens fetched
+// through a cache using it, so one token minted by a lagging IdP node does not move it;
+// until a token was seen the Date header measurement of ClockSkew is used
+// A nil *ClockOffset applies no compensation
+type ClockOffset struct {
+	mu     sync.Mutex
+	recent []time.Duration // last observed offsets, oldest first
+}
+
+// clockOffsetSamples is how many observations the offset estimate is smoothed over
+const clockOffsetSamples = 5
+
+// NewClockOffset returns a ClockOffset without samples
+func NewClockOffset() *ClockOffset {
+	return &ClockOffset{}
+}
+
+// ObserveIssuedAt records a token issued at iat (issuer clock) and received at received (local clock)
+func (o *ClockOffset) ObserveIssuedAt(iat, received time.Time) {
+	if o == nil || iat.IsZero() {
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.recent = append(o.recent, iat.Sub(received).Round(time.Second))
+	if len(o.recent) > clockOffsetSamples {
+		o.recent = o.recent[len(o.recent)-clockOffsetSamples:]
+	}
+}
+
+// Offset returns the estimated issuer minus local clock difference
+func (o *ClockOffset) Offset() time.Duration {
+	if o == nil {
+		return 0
+	}
+	o.mu.Lock()
+	sorted := append([]time.Duration(nil), o.recent...)
+	o.mu.Unlock()
+	if len(sorted) == 0 {
+		return ClockSkew().Last
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[len(sorted)/2]
+}
+
+// Now returns the current time on the issuer's clock
+func (o *ClockOffset) Now() time.Time {
+	return time.Now().Add(o.Offset())
+}
+
+// ToLocal converts an issuer time (e.g. a token's iat) to local time
+func (o *ClockOffset) ToLocal(t time.Time) time.Time {
+	return t.Add(-o.Offset())
+}
+
+// ExpiryToLocal converts a token's exp to local time like ToLocal, but never later than exp itself:
+// an issuer clock behind the local one only ever shortens a token's life, as resource servers with a
+// correct clock reject it at exp
+func (o *ClockOffset) ExpiryToLocal(exp time.Time) time.Time {
+	if local := o.ToLocal(exp); local.Before(exp) {
+		return local
+	}
+	return exp
+}
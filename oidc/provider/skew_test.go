@@ -0,0 +1,167 @@
+package oidc_test
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	oidc "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsClockSkewError(t *testing.T) {
+	require.True(t, oidc.IsClockSkewError(&oidc.TokenError{StatusCode: 400, Code: "invalid_grant", Description: "ID Token issued at 1700000100 is in the future"}))
+	require.True(t, oidc.IsClockSkewError(&oidc.VerificationError{Reason: oidc.ReasonNotYetValid, Err: errors.New("nbf")}))
+	require.False(t, oidc.IsClockSkewError(&oidc.TokenError{StatusCode: 401, Code: "invalid_client"}))
+	require.False(t, oidc.IsClockSkewError(nil))
+
+	t.Run("token wording", func(t *testing.T) {
+		for _, desc := range []string{
+			"Token is not yet valid (nbf)",
+			"token used before nbf",
+			"iat is in the future",
+			"The token's iat claim is in the future",
+			"JWT nbf claim is after the current time, possible clock skew",
+		} {
+			require.True(t, oidc.IsClockSkewError(&oidc.TokenError{StatusCode: 400, Code: "invalid_grant", Description: desc}), desc)
+		}
+	})
+
+	t.Run("TLS and unrelated errors", func(t *testing.T) {
+		for _, err := range []error{
+			errors.New(`Post "https://idp.example.com/token": tls: failed to verify certificate: x509: certificate has expired or is not yet valid: current time 2026-01-01T00:00:00Z is before 2026-02-01T00:00:00Z`),
+			x509.CertificateInvalidError{Reason: x509.Expired, Detail: "token not yet valid"},
+			&oidc.TokenError{StatusCode: 400, Code: "invalid_request", Description: "missing iat claim"},
+			&oidc.TokenError{StatusCode: 400, Code: "invalid_grant", Description: "session not valid"},
+		} {
+			require.False(t, oidc.IsClockSkewError(err), err.Error())
+		}
+	})
+}
+
+func TestClockSkewFromDateHeader(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", time.Now().Add(90*time.Second).UTC().Format(http.TimeFormat))
+	}))
+	t.Cleanup(srv.Close)
+	resp, err := oidc.NewHTTPClient("test", false).Get(srv.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	st := oidc.ClockSkew()
+	require.InDelta(t, 90, st.Last.Seconds(), 2)
+	require.GreaterOrEqual(t, st.Max, 88*time.Second)
+	require.NotZero(t, st.Samples)
+}
+
+func TestSkewRetryTransport(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token", error_description="token used before nbf"`)
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	before := oidc.ClockSkew().Retries
+	client := &http.Client{Transport: &oidc.SkewRetryTransport{Delay: time.Millisecond}}
+	req, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, srv.URL, nil)
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.EqualValues(t, 2, calls.Load())
+	require.Equal(t, before+1, oidc.ClockSkew().Retries)
+
+	// Other 401s are returned unchanged
+	calls.Store(10)
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token", error_description="signature invalid"`)
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+	resp, err = client.Get(srv.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	require.EqualValues(t, 11, calls.Load())
+}
+
+func TestSkewCompensation(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("issuer ahead shortens the expiry", func(t *testing.T) {
+		// The issuer clock is one hour ahead: without compensation the token looks valid for 70 minutes
+		ahead := time.Now().Add(time.Hour)
+		token := makeJWT(t, map[string]interface{}{"iat": ahead.Unix(), "exp": ahead.Add(10 * time.Minute).Unix()})
+
+		uncompensated := oidc.NewTokenCache(&stubProvider{token: token})
+		_, err := uncompensated.GetValidToken(ctx)
+		require.NoError(t, err)
+		require.WithinDuration(t, time.Now().Add(70*time.Minute), uncompensated.Status().Expiry, 2*time.Second)
+
+		clock := oidc.NewClockOffset()
+		p := &stubProvider{token: token}
+		cache := oidc.NewTokenCache(p, oidc.WithSkewCompensation(clock))
+		for i := 0; i < 2; i++ {
+			_, err := cache.GetValidToken(ctx)
+			require.NoError(t, err)
+		}
+		require.EqualValues(t, 1, p.calls.Load())
+		require.InDelta(t, time.Hour.Seconds(), clock.Offset().Seconds(), 2)
+		require.WithinDuration(t, time.Now().Add(10*time.Minute), cache.Status().Expiry, 2*time.Second)
+	})
+
+	t.Run("issuer behind never extends the expiry", func(t *testing.T) {
+		behind := time.Now().Add(-time.Hour)
+		exp := behind.Add(10 * time.Minute)
+		token := makeJWT(t, map[string]interface{}{"iat": behind.Unix(), "exp": exp.Unix()})
+
+		clock := oidc.NewClockOffset()
+		cache := oidc.NewTokenCache(&stubProvider{token: token}, oidc.WithSkewCompensation(clock))
+		_, err := cache.GetValidToken(ctx)
+		require.NoError(t, err)
+		require.InDelta(t, -time.Hour.Seconds(), clock.Offset().Seconds(), 2)
+		require.Equal(t, exp.Unix(), cache.Status().Expiry.Unix())
+	})
+}
+
+func TestClockOffsetSmoothing(t *testing.T) {
+	now := time.Now()
+	clock := oidc.NewClockOffset()
+	for _, offset := range []time.Duration{30 * time.Second, 31 * time.Second, 29 * time.Second, 30 * time.Second} {
+		clock.ObserveIssuedAt(now.Add(offset), now)
+	}
+	// One token minted by a lagging node does not move the estimate
+	clock.ObserveIssuedAt(now.Add(-10*time.Minute), now)
+	require.Equal(t, 30*time.Second, clock.Offset())
+
+	// Only the last samples count, a lasting change is picked up
+	for i := 0; i < 3; i++ {
+		clock.ObserveIssuedAt(now.Add(-10*time.Minute), now)
+	}
+	require.Equal(t, -10*time.Minute, clock.Offset())
+}
+
+func TestVerifierSkewCompensation(t *testing.T) {
+	iss := newTestIssuer(t)
+	// The issuer clock is two hours ahead, so nbf is in the local future
+	claims := iss.claims("api")
+	ahead := time.Now().Add(2 * time.Hour)
+	claims["iat"], claims["nbf"], claims["exp"] = ahead.Unix(), ahead.Unix(), ahead.Add(time.Hour).Unix()
+	token := iss.sign(t, "RS256", "rsa", claims)
+
+	_, err := oidc.NewVerifier(oidc.VerifierConfig{Issuer: iss.URL}).Verify(context.Background(), token)
+	require.True(t, oidc.IsClockSkewError(err))
+
+	clock := oidc.NewClockOffset()
+	clock.ObserveIssuedAt(ahead, time.Now())
+	_, err = oidc.NewVerifier(oidc.VerifierConfig{Issuer: iss.URL, Clock: clock}).Verify(context.Background(), token)
+	require.NoError(t, err)
+}
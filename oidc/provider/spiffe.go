@@ -0,0 +1,47 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// JWTSVIDFetcher fetches JWT-SVIDs from a SPIFFE Workload API (e.g. a SPIRE agent)
+// The spiffe package provides a Workload API client; the interface keeps this package free of gRPC
+type JWTSVIDFetcher interface {
+	FetchJWTSVID(ctx context.Context, audience, spiffeID string) (string, error)
+}
+
+// SpiffeTokenProvider implements TokenProvider with JWT-SVIDs issued to the workload for Audience
+type SpiffeTokenProvider struct {
+	Fetcher  JWTSVIDFetcher
+	Audience string       // aud of the SVID, e.g. the WIF provider audience
+	SPIFFEID string       // optional, selects the identity when the workload has several
+	OnEvent  EventHandler // optional, receives request, fetched and failed events
+}
+
+// Kind returns the provider kind reported in snapshots
+func (s *SpiffeTokenProvider) Kind() string {
+	return "spiffe"
+}
+
+// FetchToken fetches a new JWT-SVID
+func (s *SpiffeTokenProvider) FetchToken(ctx context.Context) (string, error) {
+	if s.Fetcher == nil || s.Audience == "" {
+		return "", errors.New("SPIFFE configuration is incomplete: Fetcher and Audience must be provided")
+	}
+	s.OnEvent.emit(Event{Type: EventTokenRequest, Provider: "spiffe"})
+	start := time.Now()
+	svid, err := s.Fetcher.FetchJWTSVID(ctx, s.Audience, s.SPIFFEID)
+	if err == nil && svid == "" {
+		err = errors.New("Workload API returned no JWT-SVID")
+	}
+	if err != nil {
+		err = fmt.Errorf("failed to fetch JWT-SVID for audience %q: %w", s.Audience, err)
+		s.OnEvent.emit(Event{Type: EventTokenFailed, Provider: "spiffe", Duration: time.Since(start), Err: err})
+		return "", err
+	}
+	s.OnEvent.emit(Event{Type: EventTokenFetched, Provider: "spiffe", Duration: time.Since(start)})
+	return svid, nil
+}
@@ -0,0 +1,40 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	oidc "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSVIDFetcher returns svid for any request and records the last one
+type fakeSVIDFetcher struct {
+	svid               string
+	err                error
+	audience, spiffeID string
+}
+
+func (f *fakeSVIDFetcher) FetchJWTSVID(ctx context.Context, audience, spiffeID string) (string, error) {
+	f.audience, f.spiffeID = audience, spiffeID
+	return f.svid, f.err
+}
+
+func TestSpiffeTokenProvider(t *testing.T) {
+	fetcher := &fakeSVIDFetcher{svid: validJWT(t)}
+	p := &oidc.SpiffeTokenProvider{Fetcher: fetcher, Audience: "gcp", SPIFFEID: "spiffe://example.org/orders"}
+	got, err := oidc.NewTokenCache(p).GetValidToken(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, fetcher.svid, got)
+	require.Equal(t, "gcp", fetcher.audience)
+	require.Equal(t, "spiffe://example.org/orders", fetcher.spiffeID)
+
+	fetcher.err = errors.New("agent unavailable")
+	_, err = p.FetchToken(context.Background())
+	require.ErrorContains(t, err, "agent unavailable")
+
+	_, err = (&oidc.SpiffeTokenProvider{Fetcher: fetcher}).FetchToken(context.Background())
+	require.ErrorContains(t, err, "Audience")
+}
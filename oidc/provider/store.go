@@ -0,0 +1,156 @@
+package oidc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+// ErrCacheMiss is returned by CacheStore.Load when nothing is stored under the key
+var ErrCacheMiss = errors.New("cache miss")
+
+// StoredToken is a token persisted by a CacheStore
+type StoredToken struct {
+	Token     string    `json:"token"`
+	TokenType string    `json:"token_type,omitempty"`
+	Expiry    time.Time `json:"expiry"`
+}
+
+// CacheStore persists tokens between process runs, e.g. for short-lived CLI invocations
+// Implementations must be safe for concurrent use
+type CacheStore interface {
+	Load(ctx context.Context, key string) (StoredToken, error)
+	Save(ctx context.Context, key string, token StoredToken) error
+}
+
+// FileCacheStore stores each token as a JSON file in Dir, readable only by the current user
+type FileCacheStore struct {
+	Dir string
+}
+
+// NewFileCacheStore creates a file store, an empty dir defaults to <user cache dir>/pcs-oidc
+func NewFileCacheStore(dir string) (*FileCacheStore, error) {
+	if dir == "" {
+		base, err := os.UserCacheDir()
+		if err != nil {
+			return nil, err
+		}
+		dir = filepath.Join(base, "pcs-oidc")
+	}
+	return &FileCacheStore{Dir: dir}, nil
+}
+
+// unsafeKeyChars are replaced in the readable part of a file name
+var unsafeKeyChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// maxKeyPrefix bounds the readable part so long keys stay below file name limits
+const maxKeyPrefix = 64
+
+// path maps key to a file name: a sanitized prefix for humans plus a hash of the full key,
+// so keys differing only in replaced characters ("a/b" and "a_b") never share a file
+func (s *FileCacheStore) path(key string) string {
+	prefix := unsafeKeyChars.ReplaceAllString(key, "_")
+	if len(prefix) > maxKeyPrefix {
+		prefix = prefix[:maxKeyPrefix]
+	}
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(s.Dir, prefix+"-"+hex.EncodeToString(sum[:8])+".json")
+}
+
+// Load implements CacheStore
+func (s *FileCacheStore) Load(ctx context.Context, key string) (StoredToken, error) {
+	data, err := os.ReadFile(s.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return StoredToken{}, ErrCacheMiss
+	}
+	if err != nil {
+		return StoredToken{}, err
+	}
+	var tok StoredToken
+	if err := json.Unmarshal(data, &tok); err != nil {
+		return StoredToken{}, err
+	}
+	return tok, nil
+}
+
+// Save implements CacheStore, the file is replaced atomically
+func (s *FileCacheStore) Save(ctx context.Context, key string, token StoredToken) error {
+	if err := os.MkdirAll(s.Dir, 0o700); err != nil {
+		return err
+	}
+	data, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(s.Dir, ".token-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path(key))
+}
+
+// WithStore seeds the cache from store at construction and persists every fetched token there
+// A seeded token is used only while it is still valid, so after a restart during an IdP outage
+// the process can keep serving the previously issued token without any network call
+// The store holds bearer tokens: keep it private to the service (FileCacheStore uses 0600 files)
+func WithStore(store CacheStore, key string) CacheOption {
+	return func(c *TokenCache) {
+		c.store = store
+		c.storeKey = key
+	}
+}
+
+// seed loads a persisted token into an empty cache if it is still valid
+func (c *TokenCache) seed(ctx context.Context) {
+	stored, err := c.store.Load(ctx, c.storeKey)
+	if err != nil || stored.Token == "" {
+		return
+	}
+	expiry := stored.Expiry
+	if exp, err := getJWTExpiry(stored.Token); err == nil {
+		expiry = c.clock.ExpiryToLocal(time.Unix(exp, 0))
+	}
+	// Tokens without exp claim rely on the expiry saved with them
+	if expiry.IsZero() || !time.Now().Before(expiry) {
+		return
+	}
+	if checkClaims(stored.Token, c.assertions) != nil {
+		return
+	}
+	var issuedAt time.Time
+	if claims, err := decodeJWTClaims(stored.Token); err == nil {
+		if iat := numericDate(claims["iat"]); !iat.IsZero() {
+			issuedAt = c.clock.ToLocal(iat)
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.token = stored.Token
+	c.expiry = expiry
+	c.issuedAt = issuedAt
+	c.publishStatus()
+	c.schedulePrefetch()
+}
+
+// persist saves the current token, failures only cost a fetch after the next restart
+// The caller must hold c.mu
+func (c *TokenCache) persist(ctx context.Context) {
+	if c.store == nil {
+		return
+	}
+	_ = c.store.Save(context.WithoutCancel(ctx), c.storeKey, StoredToken{Token: c.token, TokenType: "Bearer", Expiry: c.expiry})
+}
@@ -0,0 +1,92 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	oidc "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileCacheStore(t *testing.T) {
+	ctx := context.Background()
+	store, err := oidc.NewFileCacheStore(t.TempDir())
+	require.NoError(t, err)
+
+	_, err = store.Load(ctx, "gcp/audience")
+	require.True(t, errors.Is(err, oidc.ErrCacheMiss))
+
+	want := oidc.StoredToken{Token: "tok", TokenType: "Bearer", Expiry: time.Now().Add(time.Hour).Round(time.Second)}
+	require.NoError(t, store.Save(ctx, "gcp/audience", want))
+	got, err := store.Load(ctx, "gcp/audience")
+	require.NoError(t, err)
+	require.Equal(t, want.Token, got.Token)
+	require.True(t, want.Expiry.Equal(got.Expiry))
+
+	entries, err := os.ReadDir(store.Dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.True(t, strings.HasPrefix(entries[0].Name(), "gcp_audience-"))
+	require.True(t, strings.HasSuffix(entries[0].Name(), ".json"))
+	info, err := entries[0].Info()
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+}
+
+func TestFileCacheStoreKeysDoNotCollide(t *testing.T) {
+	ctx := context.Background()
+	store, err := oidc.NewFileCacheStore(t.TempDir())
+	require.NoError(t, err)
+
+	require.NoError(t, store.Save(ctx, "tenant/a", oidc.StoredToken{Token: "slash"}))
+	require.NoError(t, store.Save(ctx, "tenant_a", oidc.StoredToken{Token: "underscore"}))
+	require.NoError(t, store.Save(ctx, strings.Repeat("k", 300), oidc.StoredToken{Token: "long"}))
+
+	got, err := store.Load(ctx, "tenant/a")
+	require.NoError(t, err)
+	require.Equal(t, "slash", got.Token)
+	got, err = store.Load(ctx, "tenant_a")
+	require.NoError(t, err)
+	require.Equal(t, "underscore", got.Token)
+	got, err = store.Load(ctx, strings.Repeat("k", 300))
+	require.NoError(t, err)
+	require.Equal(t, "long", got.Token)
+}
+
+func TestTokenCacheWithStore(t *testing.T) {
+	ctx := context.Background()
+	store, err := oidc.NewFileCacheStore(t.TempDir())
+	require.NoError(t, err)
+	issued := validJWT(t)
+
+	// First process fetches and persists the token
+	first := &stubProvider{token: issued}
+	token, err := oidc.NewTokenCache(first, oidc.WithStore(store, "orders")).GetValidToken(ctx)
+	require.NoError(t, err)
+	require.Equal(t, issued, token)
+	stored, err := store.Load(ctx, "orders")
+	require.NoError(t, err)
+	require.Equal(t, "Bearer", stored.TokenType)
+
+	// After a restart during an IdP outage the persisted token is served without a fetch
+	down := &stubProvider{err: errors.New("idp down")}
+	cache := oidc.NewTokenCache(down, oidc.WithStore(store, "orders"))
+	require.True(t, cache.Status().HasToken)
+	token, err = cache.GetValidToken(ctx)
+	require.NoError(t, err)
+	require.Equal(t, issued, token)
+	require.Zero(t, down.calls.Load())
+
+	// Expired persisted tokens are ignored
+	expired := makeJWT(t, map[string]interface{}{"exp": time.Now().Add(-time.Minute).Unix()})
+	require.NoError(t, store.Save(ctx, "orders", oidc.StoredToken{Token: expired}))
+	cache = oidc.NewTokenCache(down, oidc.WithStore(store, "orders"))
+	require.False(t, cache.Status().HasToken)
+	_, err = cache.GetValidToken(ctx)
+	require.Error(t, err)
+}
@@ -0,0 +1,85 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// TokenExchangeProvider implements TokenProvider by exchanging a subject token for a token issued
+// to Audience (RFC 8693), e.g. swapping a front-end user token for a backend service token
+// The subject token comes from Subject, or from the verified incoming request (TokenFromContext)
+// When the subject comes from the request, do not share one TokenCache between callers: use the
+// provider per request or OnBehalfOf, which caches per subject
+type TokenExchangeProvider struct {
+	Exchanger *STSExchanger // e.g. NewKeycloakExchanger(cfg, false)
+	Audience  string        // client the new token is issued to, optional
+	Subject   TokenProvider // optional source of the subject token, default the incoming token of ctx
+	// RequestedSubject impersonates this Keycloak user (id or username) instead of the subject
+	// token's user; the exchanging client needs the impersonation permission
+	RequestedSubject string
+	OnEvent          EventHandler // optional, receives request, fetched and failed events
+}
+
+// NewKeycloakTokenExchangeProvider creates a provider exchanging with the realm and client of cfg
+// RequestedTokenType and SubjectTokenType can be changed on the returned provider's Exchanger
+func NewKeycloakTokenExchangeProvider(cfg *ConfigKeyCloak, insecure bool, audience string) *TokenExchangeProvider {
+	return &TokenExchangeProvider{Exchanger: NewKeycloakExchanger(cfg, insecure), Audience: audience}
+}
+
+// Kind returns the provider kind reported in snapshots
+func (p *TokenExchangeProvider) Kind() string {
+	return "token-exchange"
+}
+
+// Capabilities reports what the provider supports
+func (p *TokenExchangeProvider) Capabilities() Capabilities {
+	return Capabilities{AccessToken: true, TokenExchange: true}
+}
+
+// FetchToken exchanges the subject token, a requested id_token is returned from the access_token field
+func (p *TokenExchangeProvider) FetchToken(ctx context.Context) (string, error) {
+	if p.Exchanger == nil || p.Exchanger.TokenURL == "" {
+		return "", errors.New("token exchange configuration is incomplete: Exchanger with a TokenURL must be provided")
+	}
+	subjectToken, err := p.subjectToken(ctx)
+	if err != nil {
+		return "", err
+	}
+	exchanger := *p.Exchanger
+	if p.RequestedSubject != "" {
+		exchanger.RequestedSubject = p.RequestedSubject
+	}
+	p.OnEvent.emit(Event{Type: EventTokenRequest, Provider: "token-exchange", Scopes: exchanger.Scopes})
+	start := time.Now()
+	token, err := exchanger.Exchange(ctx, subjectToken, p.Audience)
+	if err == nil && token.AccessToken == "" {
+		err = errors.New("token exchange response has no access_token")
+	}
+	if err != nil {
+		p.OnEvent.emit(Event{Type: EventTokenFailed, Provider: "token-exchange", Scopes: exchanger.Scopes, Duration: time.Since(start), Err: err})
+		return "", err
+	}
+	ReportExpiry(ctx, token.Expiry)
+	p.OnEvent.emit(Event{Type: EventTokenFetched, Provider: "token-exchange", Scopes: exchanger.Scopes, Duration: time.Since(start)})
+	return token.AccessToken, nil
+}
+
+// subjectToken returns the token to exchange, empty for direct impersonation without a subject
+func (p *TokenExchangeProvider) subjectToken(ctx context.Context) (string, error) {
+	if p.Subject != nil {
+		token, err := p.Subject.FetchToken(ctx)
+		if err != nil {
+			return "", fmt.Errorf("failed to get subject token: %w", err)
+		}
+		return token, nil
+	}
+	if token, ok := TokenFromContext(ctx); ok {
+		return token, nil
+	}
+	if p.RequestedSubject != "" || p.Exchanger.RequestedSubject != "" {
+		return "", nil
+	}
+	return "", errors.New("token exchange requires a subject token: set Subject or run behind Verifier.Middleware")
+}
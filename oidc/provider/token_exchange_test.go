@@ -0,0 +1,55 @@
+package oidc_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	oidc "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenExchangeProvider(t *testing.T) {
+	ctx := context.Background()
+	var form map[string]string
+	realm := newFakeKeycloak(t, func(w http.ResponseWriter, r *http.Request) {
+		form = map[string]string{}
+		for k := range r.PostForm {
+			form[k] = r.PostForm.Get(k)
+		}
+		writeTokenResponse(w, map[string]interface{}{"access_token": "backend-token", "expires_in": 300})
+	})
+	cfg := &oidc.ConfigKeyCloak{KeycloakRealmURL: realm, KeycloakClientID: "gateway", KeycloakClientSecret: "secret"}
+
+	t.Run("incoming token", func(t *testing.T) {
+		p := oidc.NewKeycloakTokenExchangeProvider(cfg, false, "orders-backend")
+		got, err := p.FetchToken(oidc.ContextWithToken(ctx, "user-token"))
+		require.NoError(t, err)
+		require.Equal(t, "backend-token", got)
+		require.Equal(t, string(oidc.GrantTokenExchange), form["grant_type"])
+		require.Equal(t, "user-token", form["subject_token"])
+		require.Equal(t, "orders-backend", form["audience"])
+		require.NotContains(t, form, "requested_subject")
+
+		_, err = p.FetchToken(ctx)
+		require.ErrorContains(t, err, "subject token")
+	})
+
+	t.Run("subject provider", func(t *testing.T) {
+		p := oidc.NewKeycloakTokenExchangeProvider(cfg, false, "orders-backend")
+		p.Subject = &stubProvider{token: "service-token"}
+		_, err := p.FetchToken(ctx)
+		require.NoError(t, err)
+		require.Equal(t, "service-token", form["subject_token"])
+	})
+
+	t.Run("impersonation", func(t *testing.T) {
+		p := oidc.NewKeycloakTokenExchangeProvider(cfg, false, "orders-backend")
+		p.RequestedSubject = "alice"
+		_, err := p.FetchToken(ctx)
+		require.NoError(t, err)
+		require.Equal(t, "alice", form["requested_subject"])
+		require.NotContains(t, form, "subject_token")
+	})
+}
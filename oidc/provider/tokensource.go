@@ -0,0 +1,72 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+
+	"golang.org/x/oauth2"
+)
+
+// cacheTokenSource adapts a TokenCache to oauth2.TokenSource
+type cacheTokenSource struct {
+	ctx   context.Context
+	cache *TokenCache
+}
+
+// TokenSource returns an oauth2.TokenSource backed by the cache
+// ctx is used for every fetch, as oauth2.TokenSource has no context parameter
+func (c *TokenCache) TokenSource(ctx context.Context) oauth2.TokenSource {
+	return &cacheTokenSource{ctx: ctx, cache: c}
+}
+
+// Token implements oauth2.TokenSource
+func (s *cacheTokenSource) Token() (*oauth2.Token, error) {
+	token, expiry, err := s.cache.GetValidTokenExpiry(s.ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &oauth2.Token{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		Expiry:      expiry,
+	}, nil
+}
+
+// TokenSourceProvider adapts an oauth2.TokenSource created elsewhere (google.DefaultTokenSource,
+// an oauth2.Config, ...) to TokenProvider, so its tokens flow through TokenCache, the broker and FileCacheStore
+// Token selects the access_token (default) or the id_token found in the token extras
+type TokenSourceProvider struct {
+	Source oauth2.TokenSource
+	Token  TokenKind
+}
+
+// FromTokenSource wraps ts as a TokenProvider returning the token of the given kind
+func FromTokenSource(ts oauth2.TokenSource, kind TokenKind) *TokenSourceProvider {
+	return &TokenSourceProvider{Source: ts, Token: kind}
+}
+
+// Kind returns the provider kind reported in snapshots
+func (p *TokenSourceProvider) Kind() string {
+	return "token-source"
+}
+
+// Capabilities reports what the provider supports
+func (p *TokenSourceProvider) Capabilities() Capabilities {
+	return tokenKindCapabilities(p.Token)
+}
+
+// FetchToken returns the selected token of the source
+// oauth2.TokenSource has no context parameter, so ctx is only checked before the call
+func (p *TokenSourceProvider) FetchToken(ctx context.Context) (string, error) {
+	if p.Source == nil {
+		return "", errors.New("token source configuration is incomplete: Source must be provided")
+	}
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	token, err := p.Source.Token()
+	if err != nil {
+		return "", err
+	}
+	return tokenOfKind(ctx, token, p.Token, "token source")
+}
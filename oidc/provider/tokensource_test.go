@@ -0,0 +1,65 @@
+package oidc_test
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	oidc "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestTokenSourceProvider(t *testing.T) {
+	ctx := context.Background()
+	access, id := validJWT(t), makeJWT(t, map[string]interface{}{"exp": time.Now().Add(time.Hour).Unix(), "sub": "id"})
+	ts := oauth2.StaticTokenSource((&oauth2.Token{AccessToken: access}).WithExtra(map[string]interface{}{"id_token": id}))
+
+	got, err := oidc.NewTokenCache(oidc.FromTokenSource(ts, oidc.TokenKindAccess)).GetValidToken(ctx)
+	require.NoError(t, err)
+	require.Equal(t, access, got)
+
+	got, err = oidc.FromTokenSource(ts, oidc.TokenKindID).FetchToken(ctx)
+	require.NoError(t, err)
+	require.Equal(t, id, got)
+
+	_, err = oidc.FromTokenSource(oauth2.StaticTokenSource(&oauth2.Token{AccessToken: access}), oidc.TokenKindID).FetchToken(ctx)
+	require.ErrorContains(t, err, "id_token")
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = oidc.FromTokenSource(ts, oidc.TokenKindAccess).FetchToken(cancelled)
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestCacheTokenSourceExpiry(t *testing.T) {
+	ctx := context.Background()
+	var n atomic.Int64
+	cache := oidc.NewTokenCache(oidc.ProviderFunc(func(context.Context) (string, error) {
+		// Every token has a different lifetime, so a mismatched expiry is detected
+		return makeJWT(t, map[string]interface{}{"exp": time.Now().Add(time.Hour + time.Duration(n.Add(1))*time.Minute).Unix()}), nil
+	}))
+	ts := cache.TokenSource(ctx)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				cache.ForceExpire(time.Now())
+			}
+		}()
+	}
+	for i := 0; i < 200; i++ {
+		tok, err := ts.Token()
+		require.NoError(t, err)
+		claims, err := oidc.DecodeJWTClaims(tok.AccessToken, false)
+		require.NoError(t, err)
+		require.Equal(t, int64(claims["exp"].(float64)), tok.Expiry.Unix())
+	}
+	wg.Wait()
+}
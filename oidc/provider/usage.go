@@ -0,0 +1,191 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// CacheUsage counts the token fetches made by a TokenCache since it was created
+type CacheUsage struct {
+	Issued        uint64        // tokens fetched successfully
+	Refreshes     uint64        // successful fetches replacing an expiring cached token
+	Failures      uint64        // failed fetches, without Canceled
+	Canceled      uint64        // fetches abandoned because the caller's context ended, see CanceledError
+	TotalLifetime time.Duration // sum of the lifetimes of the issued tokens
+}
+
+func (u *CacheUsage) record(renewing bool, err error, lifetime time.Duration) {
+	if err != nil {
+		u.Failures++
+		return
+	}
+	if renewing {
+		u.Refreshes++
+	}
+	u.Issued++
+	u.TotalLifetime += lifetime
+}
+
+// Usage returns the fetch counters of the cache
+// It does not wait for a running fetch, the counters are published after each one
+func (c *TokenCache) Usage() CacheUsage {
+	if u := c.usageSnap.Load(); u != nil {
+		return *u
+	}
+	return CacheUsage{}
+}
+
+// publishUsage publishes a copy of the counters for Usage
+// The caller must hold c.mu
+func (c *TokenCache) publishUsage() {
+	u := c.usage
+	c.usageSnap.Store(&u)
+}
+
+// CredentialUsage summarizes the token fetches of one managed credential during a report period
+type CredentialUsage struct {
+	Name                   string  `json:"name"`
+	Kind                   string  `json:"kind"`
+	Audience               string  `json:"audience,omitempty"`
+	Issued                 uint64  `json:"issued"`
+	Refreshes              uint64  `json:"refreshes"`
+	Failures               uint64  `json:"failures"`
+	Canceled               uint64  `json:"canceled"`
+	AverageLifetimeSeconds float64 `json:"average_lifetime_seconds"`
+}
+
+// UsageReport is the per-credential token usage over one period, e.g. for IdP capacity planning
+type UsageReport struct {
+	Start       time.Time         `json:"start"`
+	End         time.Time         `json:"end"`
+	Credentials []CredentialUsage `json:"credentials"`
+}
+
+// UsageReporter periodically summarizes the usage of every credential held by a Manager
+// Each report covers the period since the previous one and is passed to OnReport
+// and/or written as one JSON line to Writer
+type UsageReporter struct {
+	Manager  *Manager
+	Interval time.Duration     // default 1h
+	OnReport func(UsageReport) // optional
+	Writer   io.Writer         // optional, receives one JSON document per report
+
+	mu       sync.Mutex
+	last     map[string]CacheUsage
+	lastTime time.Time
+	stop     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewUsageReporter creates a reporter for the credentials of manager
+// The reporter is registered with manager, so Manager.CloseAll stops it
+func NewUsageReporter(manager *Manager, interval time.Duration) *UsageReporter {
+	r := &UsageReporter{Manager: manager, Interval: interval, lastTime: time.Now()}
+	manager.AddCloser(r)
+	return r
+}
+
+// Start launches the reporting loop, which runs until Close or ctx is done
+// Calling Start more than once has no effect
+func (r *UsageReporter) Start(ctx context.Context) {
+	r.mu.Lock()
+	if r.stop != nil {
+		r.mu.Unlock()
+		return
+	}
+	r.stop = make(chan struct{})
+	stop := r.stop
+	r.mu.Unlock()
+
+	interval := r.Interval
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-stop:
+				return
+			case <-ticker.C:
+				// A panicking OnReport or Writer skips this report, the next one covers the period
+				_ = protect("usage-report", func() error {
+					r.ReportNow()
+					return nil
+				})
+			}
+		}
+	}()
+}
+
+// ReportNow builds the report for the period since the previous one and delivers it
+func (r *UsageReporter) ReportNow() UsageReport {
+	r.Manager.mu.RLock()
+	creds := make([]ManagedCredential, 0, len(r.Manager.creds))
+	for _, cred := range r.Manager.creds {
+		creds = append(creds, cred)
+	}
+	r.Manager.mu.RUnlock()
+
+	report := r.collect(creds)
+	sort.Slice(report.Credentials, func(i, j int) bool { return report.Credentials[i].Name < report.Credentials[j].Name })
+	// OnReport and Writer run outside r.mu, a panic there must not leave the reporter locked
+	if r.OnReport != nil {
+		r.OnReport(report)
+	}
+	if r.Writer != nil {
+		_ = json.NewEncoder(r.Writer).Encode(report)
+	}
+	return report
+}
+
+// collect builds the report of creds for the period since the previous one and starts the next period
+func (r *UsageReporter) collect(creds []ManagedCredential) UsageReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	report := UsageReport{Start: r.lastTime, End: now, Credentials: make([]CredentialUsage, 0, len(creds))}
+	current := make(map[string]CacheUsage, len(creds))
+	for _, cred := range creds {
+		u := cred.Cache.Usage()
+		current[cred.Name] = u
+		prev := r.last[cred.Name]
+		cu := CredentialUsage{
+			Name:      cred.Name,
+			Kind:      cred.Kind,
+			Audience:  cred.Audience,
+			Issued:    u.Issued - prev.Issued,
+			Refreshes: u.Refreshes - prev.Refreshes,
+			Failures:  u.Failures - prev.Failures,
+			Canceled:  u.Canceled - prev.Canceled,
+		}
+		if cu.Issued > 0 {
+			cu.AverageLifetimeSeconds = (u.TotalLifetime - prev.TotalLifetime).Seconds() / float64(cu.Issued)
+		}
+		report.Credentials = append(report.Credentials, cu)
+	}
+	r.last = current
+	r.lastTime = now
+	return report
+}
+
+// Close stops the reporting loop and waits for it to exit
+func (r *UsageReporter) Close() error {
+	r.mu.Lock()
+	if r.stop != nil {
+		close(r.stop)
+		r.stop = nil
+	}
+	r.mu.Unlock()
+	r.wg.Wait()
+	return nil
+}
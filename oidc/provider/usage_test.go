@@ -0,0 +1,78 @@
+package oidc_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	oidc "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUsageReporter(t *testing.T) {
+	ctx := context.Background()
+	stub := &stubProvider{token: makeJWT(t, map[string]interface{}{"exp": time.Now().Add(30 * time.Minute).Unix()})}
+	cache := oidc.NewTokenCache(stub)
+	failing := oidc.NewTokenCache(&stubProvider{err: errors.New("down")})
+
+	m := oidc.NewManager()
+	require.NoError(t, m.Add(oidc.ManagedCredential{Name: "orders", Audience: "orders-api", Cache: cache}))
+	require.NoError(t, m.Add(oidc.ManagedCredential{Name: "billing", Cache: failing}))
+
+	var buf bytes.Buffer
+	var reports []oidc.UsageReport
+	r := oidc.NewUsageReporter(m, time.Hour)
+	r.Writer = &buf
+	r.OnReport = func(rep oidc.UsageReport) { reports = append(reports, rep) }
+
+	_, err := cache.GetValidToken(ctx)
+	require.NoError(t, err)
+	cache.ForceExpire(time.Now())
+	_, err = cache.GetValidToken(ctx)
+	require.NoError(t, err)
+	_, err = failing.GetValidToken(ctx)
+	require.Error(t, err)
+
+	rep := r.ReportNow()
+	require.Len(t, rep.Credentials, 2)
+	billing, orders := rep.Credentials[0], rep.Credentials[1]
+	require.Equal(t, "billing", billing.Name)
+	require.EqualValues(t, 1, billing.Failures)
+	require.Equal(t, "orders-api", orders.Audience)
+	require.EqualValues(t, 2, orders.Issued)
+	require.EqualValues(t, 1, orders.Refreshes)
+	require.InDelta(t, 30*60, orders.AverageLifetimeSeconds, 5)
+
+	var decoded oidc.UsageReport
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &decoded))
+	require.Equal(t, rep.Credentials, decoded.Credentials)
+	require.Len(t, reports, 1)
+
+	// The next report only covers the new period
+	rep = r.ReportNow()
+	require.Zero(t, rep.Credentials[1].Issued)
+	require.Zero(t, rep.Credentials[1].AverageLifetimeSeconds)
+}
+
+func TestUsageCountsOnlySuccessfulRefreshes(t *testing.T) {
+	ctx := context.Background()
+	stub := &stubProvider{token: validJWT(t)}
+	cache := oidc.NewTokenCache(stub)
+	require.Zero(t, cache.Usage())
+
+	_, err := cache.GetValidToken(ctx)
+	require.NoError(t, err)
+	cache.ForceExpire(time.Now())
+	stub.token, stub.err = "", errors.New("down")
+	_, err = cache.GetValidToken(ctx)
+	require.Error(t, err)
+
+	u := cache.Usage()
+	require.EqualValues(t, 1, u.Issued)
+	require.EqualValues(t, 1, u.Failures)
+	require.Zero(t, u.Refreshes)
+}
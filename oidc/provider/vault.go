@@ -0,0 +1,240 @@
+package oidc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Environment variables read by VaultTokenProvider when the matching ConfigVault field is empty,
+// the same ones the vault CLI uses
+const (
+	VaultAddrEnv      = "VAULT_ADDR"
+	VaultTokenEnv     = "VAULT_TOKEN"
+	VaultNamespaceEnv = "VAULT_NAMESPACE"
+)
+
+// ConfigVault holds the settings to obtain identity tokens from Vault's OIDC identity provider
+// Vault authenticates with Token, or with AppRole when RoleID is set
+type ConfigVault struct {
+	Address   string // e.g. https://vault.example.com:8200, default $VAULT_ADDR
+	Namespace string // Vault Enterprise namespace, default $VAULT_NAMESPACE
+	Role      string // identity token role, the token is read from identity/oidc/token/<Role>
+
+	Token string // Vault token, default $VAULT_TOKEN
+
+	RoleID       string // AppRole role_id, takes precedence over Token
+	SecretID     string // AppRole secret_id
+	AppRoleMount string // mount path of the AppRole auth method, default "approle"
+}
+
+// VaultTokenProvider implements TokenProvider with identity tokens issued by Vault for a role
+// so Vault can act as the OIDC issuer of a WIF pool
+// The AppRole login token is kept until its lease expires or Vault rejects it
+type VaultTokenProvider struct {
+	Config     *ConfigVault
+	HTTPClient *http.Client // optional, default NewHTTPClient("vault", false)
+	OnEvent    EventHandler // optional, receives request, fetched and failed events
+
+	mu          sync.Mutex
+	loginToken  string
+	loginExpiry time.Time
+}
+
+// Kind returns the provider kind reported in snapshots
+func (v *VaultTokenProvider) Kind() string {
+	return "vault"
+}
+
+// FetchToken reads a new identity token for the configured role
+func (v *VaultTokenProvider) FetchToken(ctx context.Context) (string, error) {
+	if v.Config == nil || v.Config.Role == "" {
+		return "", errors.New("Vault configuration is incomplete: Role must be provided")
+	}
+	address := v.address()
+	if address == "" {
+		return "", errors.New("Vault configuration is incomplete: Address or " + VaultAddrEnv + " must be provided")
+	}
+	v.OnEvent.emit(Event{Type: EventTokenRequest, Provider: "vault"})
+	start := time.Now()
+	token, err := v.fetch(ctx, address)
+	if err != nil {
+		v.OnEvent.emit(Event{Type: EventTokenFailed, Provider: "vault", Duration: time.Since(start), Err: err})
+		return "", err
+	}
+	v.OnEvent.emit(Event{Type: EventTokenFetched, Provider: "vault", Duration: time.Since(start)})
+	return token, nil
+}
+
+func (v *VaultTokenProvider) fetch(ctx context.Context, address string) (string, error) {
+	vaultToken, err := v.vaultToken(ctx, address)
+	if err != nil {
+		return "", err
+	}
+	var out struct {
+		Data struct {
+			Token string `json:"token"`
+		} `json:"data"`
+	}
+	path := "/v1/identity/oidc/token/" + url.PathEscape(v.Config.Role)
+	err = v.do(ctx, http.MethodGet, address+path, vaultToken, nil, &out)
+	var tErr *TokenError
+	if errors.As(err, &tErr) && tErr.StatusCode == http.StatusForbidden && v.Config.RoleID != "" {
+		// The AppRole token was revoked or expired early, log in again once
+		v.resetLogin()
+		if vaultToken, err = v.vaultToken(ctx, address); err != nil {
+			return "", err
+		}
+		err = v.do(ctx, http.MethodGet, address+path, vaultToken, nil, &out)
+	}
+	if err != nil {
+		return "", err
+	}
+	if out.Data.Token == "" {
+		return "", errors.New("failed to extract token from Vault response")
+	}
+	return out.Data.Token, nil
+}
+
+// vaultToken returns the token authenticating requests to Vault, logging in with AppRole when configured
+func (v *VaultTokenProvider) vaultToken(ctx context.Context, address string) (string, error) {
+	if v.Config.RoleID == "" {
+		token := v.Config.Token
+		if token == "" {
+			token = os.Getenv(VaultTokenEnv)
+		}
+		if token == "" {
+			return "", errors.New("Vault configuration is incomplete: Token, " + VaultTokenEnv + " or RoleID must be provided")
+		}
+		return token, nil
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.loginToken != "" && (v.loginExpiry.IsZero() || time.Now().Before(v.loginExpiry)) {
+		return v.loginToken, nil
+	}
+	mount := v.Config.AppRoleMount
+	if mount == "" {
+		mount = "approle"
+	}
+	token, lease, err := v.login(ctx, address, mount, map[string]string{"role_id": v.Config.RoleID, "secret_id": v.Config.SecretID})
+	if err != nil {
+		return "", fmt.Errorf("Vault AppRole login failed: %w", err)
+	}
+	v.loginToken = token
+	v.loginExpiry = time.Time{}
+	if lease > 0 {
+		// Log in again shortly before the lease runs out
+		v.loginExpiry = time.Now().Add(lease - lease/10)
+	}
+	return v.loginToken, nil
+}
+
+// Login authenticates at the auth method mounted at mount with payload, e.g. "jwt" with the role and
+// the jwt, and returns the client token and its lease, zero when the token does not expire
+// It uses the Address, Namespace and HTTPClient of the provider; Role and Token are not needed
+func (v *VaultTokenProvider) Login(ctx context.Context, mount string, payload map[string]string) (string, time.Duration, error) {
+	address := v.address()
+	if address == "" {
+		return "", 0, errors.New("Vault configuration is incomplete: Address or " + VaultAddrEnv + " must be provided")
+	}
+	return v.login(ctx, address, mount, payload)
+}
+
+func (v *VaultTokenProvider) login(ctx context.Context, address, mount string, payload map[string]string) (string, time.Duration, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return "", 0, err
+	}
+	var out struct {
+		Auth struct {
+			ClientToken   string `json:"client_token"`
+			LeaseDuration int64  `json:"lease_duration"`
+		} `json:"auth"`
+	}
+	if err := v.do(ctx, http.MethodPost, address+"/v1/auth/"+strings.Trim(mount, "/")+"/login", "", body, &out); err != nil {
+		return "", 0, err
+	}
+	if out.Auth.ClientToken == "" {
+		return "", 0, errors.New("failed to extract client token from Vault login response")
+	}
+	return out.Auth.ClientToken, time.Duration(out.Auth.LeaseDuration) * time.Second, nil
+}
+
+func (v *VaultTokenProvider) resetLogin() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.loginToken = ""
+}
+
+func (v *VaultTokenProvider) address() string {
+	address := v.Config.Address
+	if address == "" {
+		address = os.Getenv(VaultAddrEnv)
+	}
+	return strings.TrimRight(address, "/")
+}
+
+// do sends a request to the Vault HTTP API and decodes the JSON response into out
+func (v *VaultTokenProvider) do(ctx context.Context, method, endpoint, vaultToken string, body []byte, out interface{}) error {
+	client := v.HTTPClient
+	if client == nil {
+		client = NewHTTPClient("vault", false)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if vaultToken != "" {
+		req.Header.Set("X-Vault-Token", vaultToken)
+	}
+	namespace := v.Config.Namespace
+	if namespace == "" {
+		namespace = os.Getenv(VaultNamespaceEnv)
+	}
+	if namespace != "" {
+		req.Header.Set("X-Vault-Namespace", namespace)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call Vault: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("failed to read Vault response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		// Vault reports {"errors": [...]}, other bodies (proxies, ...) are kept as they are
+		description := strings.TrimSpace(string(data))
+		var e struct {
+			Errors []string `json:"errors"`
+		}
+		if json.Unmarshal(data, &e) == nil && len(e.Errors) > 0 {
+			description = strings.Join(e.Errors, "; ")
+		}
+		return &TokenError{
+			Provider:    "vault",
+			StatusCode:  resp.StatusCode,
+			Description: description,
+			RetryAfter:  ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+		}
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("failed to decode Vault response: %w", err)
+	}
+	return nil
+}
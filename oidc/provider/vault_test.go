@@ -0,0 +1,87 @@
+package oidc_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	oidc "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVaultTokenProvider(t *testing.T) {
+	token := validJWT(t)
+	var logins atomic.Int32
+	var revoked atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "team-a", r.Header.Get("X-Vault-Namespace"))
+		switch r.URL.Path {
+		case "/v1/auth/approle/login":
+			var body map[string]string
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			if body["role_id"] != "role" || body["secret_id"] != "secret" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			n := logins.Add(1)
+			revoked.Store(false)
+			_, _ = w.Write([]byte(`{"auth":{"client_token":"s.login` + string(rune('0'+n)) + `","lease_duration":3600}}`))
+		case "/v1/identity/oidc/token/wif":
+			if r.Header.Get("X-Vault-Token") == "" || revoked.Load() {
+				w.WriteHeader(http.StatusForbidden)
+				_, _ = w.Write([]byte(`{"errors":["permission denied"]}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"data":{"client_id":"abc","token":"` + token + `","ttl":3600}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	t.Run("approle", func(t *testing.T) {
+		p := &oidc.VaultTokenProvider{Config: &oidc.ConfigVault{Address: srv.URL + "/", Namespace: "team-a", Role: "wif", RoleID: "role", SecretID: "secret"}}
+		got, err := oidc.NewTokenCache(p).GetValidToken(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, token, got)
+
+		// The login token is reused
+		_, err = p.FetchToken(context.Background())
+		require.NoError(t, err)
+		require.EqualValues(t, 1, logins.Load())
+
+		// A revoked login token triggers one new login
+		revoked.Store(true)
+		_, err = p.FetchToken(context.Background())
+		require.NoError(t, err)
+		require.EqualValues(t, 2, logins.Load())
+	})
+
+	t.Run("token from env", func(t *testing.T) {
+		t.Setenv(oidc.VaultAddrEnv, srv.URL)
+		t.Setenv(oidc.VaultTokenEnv, "s.static")
+		t.Setenv(oidc.VaultNamespaceEnv, "team-a")
+		got, err := (&oidc.VaultTokenProvider{Config: &oidc.ConfigVault{Role: "wif"}}).FetchToken(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, token, got)
+	})
+
+	t.Run("unknown role", func(t *testing.T) {
+		p := &oidc.VaultTokenProvider{Config: &oidc.ConfigVault{Address: srv.URL, Namespace: "team-a", Role: "other", Token: "s.static"}}
+		_, err := p.FetchToken(context.Background())
+		var tErr *oidc.TokenError
+		require.True(t, errors.As(err, &tErr))
+		require.Equal(t, http.StatusNotFound, tErr.StatusCode)
+	})
+
+	t.Run("incomplete", func(t *testing.T) {
+		t.Setenv(oidc.VaultAddrEnv, "")
+		_, err := (&oidc.VaultTokenProvider{Config: &oidc.ConfigVault{Role: "wif"}}).FetchToken(context.Background())
+		require.ErrorContains(t, err, oidc.VaultAddrEnv)
+	})
+}
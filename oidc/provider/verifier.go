@@ -0,0 +1,380 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// FailureReason classifies why a token failed verification
+type FailureReason string
+
+const (
+	ReasonMalformed        FailureReason = "malformed"
+	ReasonUnsupportedAlg   FailureReason = "unsupported_alg"
+	ReasonUnknownKeyID     FailureReason = "unknown_kid"
+	ReasonBadSignature     FailureReason = "bad_signature"
+	ReasonExpired          FailureReason = "expired"
+	ReasonNotYetValid      FailureReason = "not_yet_valid"
+	ReasonIssuerMismatch   FailureReason = "issuer_mismatch"
+	ReasonAudienceMismatch FailureReason = "audience_mismatch"
+	ReasonKeysUnavailable  FailureReason = "keys_unavailable"
+	// Reasons only reported by the middleware
+	ReasonMissingToken      FailureReason = "missing_token"
+	ReasonInsufficientScope FailureReason = "insufficient_scope"
+)
+
+// VerificationError is returned when a token fails verification
+type VerificationError struct {
+	Reason FailureReason
+	Err    error
+}
+
+func (e *VerificationError) Error() string {
+	return fmt.Sprintf("token verification failed (%s): %v", e.Reason, e.Err)
+}
+
+func (e *VerificationError) Unwrap() error {
+	return e.Err
+}
+
+func verificationError(reason FailureReason, format string, args ...interface{}) error {
+	return &VerificationError{Reason: reason, Err: fmt.Errorf(format, args...)}
+}
+
+// VerifyMode selects what the verification middleware does with invalid tokens
+type VerifyMode int
+
+const (
+	// VerifyModeEnforce rejects requests with invalid tokens (default)
+	VerifyModeEnforce VerifyMode = iota
+	// VerifyModeObserve logs verification failures with their reason but lets the request through
+	// without claims, so validation can be rolled out before it is enforced
+	VerifyModeObserve
+	// VerifyModeOptional lets requests without a bearer token through as anonymous (see IsAnonymous),
+	// for publicly readable endpoints that personalize when authenticated; a token that is present
+	// is verified and rejected when invalid, like in VerifyModeEnforce
+	VerifyModeOptional
+)
+
+// VerifierConfig configures a Verifier for incoming tokens
+// JWKSURL is optional, when empty it is resolved from the issuer discovery document
+type VerifierConfig struct {
+	Issuer   string
+	Audience string        // expected aud value, empty skips the audience check
+	JWKSURL  string        // optional, discovered from Issuer when empty
+	Leeway   time.Duration // tolerated clock difference for exp/nbf/iat, default 30s
+	Clock    *ClockOffset  // optional, checks exp/nbf against the issuer's clock (see WithSkewCompensation)
+	Mode     VerifyMode
+	Logger   *slog.Logger // used in observe mode, default slog.Default()
+
+	// Realm is sent in the WWW-Authenticate challenge of rejected requests, optional
+	Realm string
+	// RequiredScopes must all be in the token's scope claim for the middleware to accept it;
+	// otherwise the request is rejected with 403 insufficient_scope naming them
+	RequiredScopes []string
+	// OnReject writes the response of a rejected request (status and body), the WWW-Authenticate
+	// (or, for 503, Retry-After) header is already set; by default c.StatusCode is written with its
+	// status text
+	OnReject func(w http.ResponseWriter, r *http.Request, c *AuthChallenge)
+
+	// AllowedAlgorithms restricts the accepted JWS "alg" values, default DefaultAllowedAlgorithms
+	// Symmetric algorithms (HS*) and "none" are always rejected, even when listed
+	AllowedAlgorithms []string
+
+	// StrictJWT rejects malformed tokens before their signature is checked, see DecodeJWTClaims
+	StrictJWT bool
+}
+
+// DefaultAllowedAlgorithms are the algorithms accepted when VerifierConfig.AllowedAlgorithms is empty
+var DefaultAllowedAlgorithms = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+// Claims holds the standard claims of a verified token plus all raw claims
+type Claims struct {
+	Issuer    string
+	Subject   string
+	Audience  []string
+	Expiry    time.Time
+	IssuedAt  time.Time
+	NotBefore time.Time
+	Scope     string
+	Raw       map[string]interface{}
+}
+
+// Verifier validates signed JWTs issued by one issuer
+type Verifier struct {
+	cfg  VerifierConfig
+	mu   sync.Mutex
+	keys *JWKS
+
+	verified atomic.Uint64
+	failures sync.Map // FailureReason -> *atomic.Uint64
+}
+
+// VerifierStats reports verification outcomes and the health of the key set
+type VerifierStats struct {
+	Verified uint64
+	Failures map[FailureReason]uint64
+	JWKS     JWKSStats
+}
+
+// Stats returns the verification metrics collected so far
+func (v *Verifier) Stats() VerifierStats {
+	st := VerifierStats{Verified: v.verified.Load(), Failures: map[FailureReason]uint64{}}
+	v.failures.Range(func(key, value interface{}) bool {
+		st.Failures[key.(FailureReason)] = value.(*atomic.Uint64).Load()
+		return true
+	})
+	v.mu.Lock()
+	keys := v.keys
+	v.mu.Unlock()
+	if keys != nil {
+		st.JWKS = keys.Stats()
+	}
+	return st
+}
+
+// countResult updates the verification metrics for one Verify call
+func (v *Verifier) countResult(err error) {
+	if err == nil {
+		v.verified.Add(1)
+		return
+	}
+	reason := ReasonMalformed
+	var verr *VerificationError
+	if errors.As(err, &verr) {
+		reason = verr.Reason
+	}
+	counter, _ := v.failures.LoadOrStore(reason, new(atomic.Uint64))
+	counter.(*atomic.Uint64).Add(1)
+}
+
+// NewVerifier creates a verifier, keys are fetched lazily on first use
+func NewVerifier(cfg VerifierConfig) *Verifier {
+	v := &Verifier{cfg: cfg}
+	if cfg.JWKSURL != "" {
+		v.keys = NewJWKS(cfg.JWKSURL)
+	}
+	return v
+}
+
+// Mode returns the configured verification mode
+func (v *Verifier) Mode() VerifyMode {
+	return v.cfg.Mode
+}
+
+// JWKS returns the key set used by the verifier, resolving it through discovery if needed
+// Discovery runs without v.mu, so verifications with known keys are not held up by it
+func (v *Verifier) JWKS(ctx context.Context) (*JWKS, error) {
+	v.mu.Lock()
+	keys := v.keys
+	v.mu.Unlock()
+	if keys != nil {
+		return keys, nil
+	}
+	doc, err := Discover(ctx, nil, v.cfg.Issuer)
+	if err != nil {
+		return nil, err
+	}
+	if doc.JWKSURI == "" {
+		return nil, errors.New("discovery document has no jwks_uri")
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	// A concurrent call may have resolved the key set meanwhile, keep the first one
+	if v.keys == nil {
+		v.keys = NewJWKS(doc.JWKSURI)
+	}
+	return v.keys, nil
+}
+
+// Verify checks the signature and the standard claims of token and returns its claims
+// Failures are returned as *VerificationError with a FailureReason
+func (v *Verifier) Verify(ctx context.Context, token string) (*Claims, error) {
+	claims, err := v.verify(ctx, token)
+	v.countResult(err)
+	return claims, err
+}
+
+func (v *Verifier) verify(ctx context.Context, token string) (*Claims, error) {
+	jwt, err := parseJWT(token, v.cfg.StrictJWT)
+	if err != nil {
+		return nil, &VerificationError{Reason: ReasonMalformed, Err: err}
+	}
+	if !v.algorithmAllowed(jwt.header.Alg) {
+		return nil, verificationError(ReasonUnsupportedAlg, "algorithm %q is not accepted", jwt.header.Alg)
+	}
+	keys, err := v.JWKS(ctx)
+	if err != nil {
+		return nil, &VerificationError{Reason: ReasonKeysUnavailable, Err: err}
+	}
+	key, err := keys.Key(ctx, jwt.header.Kid)
+	if err != nil {
+		if errors.Is(err, ErrUnknownKeyID) {
+			return nil, &VerificationError{Reason: ReasonUnknownKeyID, Err: err}
+		}
+		return nil, &VerificationError{Reason: ReasonKeysUnavailable, Err: err}
+	}
+	if err := verifySignature(jwt.header.Alg, key, jwt.signingInput, jwt.signature); err != nil {
+		return nil, &VerificationError{Reason: ReasonBadSignature, Err: err}
+	}
+
+	claims := claimsFromMap(jwt.claims)
+	leeway := v.cfg.Leeway
+	if leeway <= 0 {
+		leeway = 30 * time.Second
+	}
+	now := v.cfg.Clock.Now()
+	if claims.Expiry.IsZero() {
+		return nil, verificationError(ReasonMalformed, "token has no exp claim")
+	}
+	if now.After(claims.Expiry.Add(leeway)) {
+		return nil, verificationError(ReasonExpired, "token expired at %s", claims.Expiry.Format(time.RFC3339))
+	}
+	if !claims.NotBefore.IsZero() && now.Add(leeway).Before(claims.NotBefore) {
+		return nil, verificationError(ReasonNotYetValid, "token not valid before %s", claims.NotBefore.Format(time.RFC3339))
+	}
+	if v.cfg.Issuer != "" && claims.Issuer != v.cfg.Issuer {
+		return nil, verificationError(ReasonIssuerMismatch, "issuer %q does not match %q", claims.Issuer, v.cfg.Issuer)
+	}
+	if v.cfg.Audience != "" && !containsString(claims.Audience, v.cfg.Audience) {
+		return nil, verificationError(ReasonAudienceMismatch, "audience %v does not contain %q", claims.Audience, v.cfg.Audience)
+	}
+	return claims, nil
+}
+
+// algorithmAllowed reports whether alg is in the allowlist and is a public key algorithm
+func (v *Verifier) algorithmAllowed(alg string) bool {
+	allowed := v.cfg.AllowedAlgorithms
+	if len(allowed) == 0 {
+		allowed = DefaultAllowedAlgorithms
+	}
+	return isAsymmetricAlg(alg) && containsString(allowed, alg)
+}
+
+// logger returns the logger used for observe mode
+func (v *Verifier) logger() *slog.Logger {
+	if v.cfg.Logger != nil {
+		return v.cfg.Logger
+	}
+	return slog.Default()
+}
+
+// claimsFromMap extracts the standard claims from a decoded payload
+func claimsFromMap(raw map[string]interface{}) *Claims {
+	c := &Claims{Raw: raw}
+	c.Issuer, _ = raw["iss"].(string)
+	c.Subject, _ = raw["sub"].(string)
+	c.Scope, _ = raw["scope"].(string)
+	switch aud := raw["aud"].(type) {
+	case string:
+		c.Audience = []string{aud}
+	case []interface{}:
+		for _, a := range aud {
+			if s, ok := a.(string); ok {
+				c.Audience = append(c.Audience, s)
+			}
+		}
+	}
+	c.Expiry = numericDate(raw["exp"])
+	c.IssuedAt = numericDate(raw["iat"])
+	c.NotBefore = numericDate(raw["nbf"])
+	return c
+}
+
+// numericDate converts a JWT NumericDate claim to time.Time, zero if absent
+func numericDate(v interface{}) time.Time {
+	f, ok := v.(float64)
+	if !ok {
+		return time.Time{}
+	}
+	return time.Unix(int64(f), 0)
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// isAsymmetricAlg reports whether alg is a supported public key JWS algorithm
+// Symmetric (HS*) and "none" are never accepted for tokens verified against a JWKS
+func isAsymmetricAlg(alg string) bool {
+	switch alg {
+	case "RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA":
+		return true
+	}
+	return false
+}
+
+// hashForAlg returns the hash function used by a JWS algorithm
+func hashForAlg(alg string) crypto.Hash {
+	switch {
+	case strings.HasSuffix(alg, "384"):
+		return crypto.SHA384
+	case strings.HasSuffix(alg, "512"):
+		return crypto.SHA512
+	default:
+		return crypto.SHA256
+	}
+}
+
+// verifySignature checks a JWS signature over signingInput
+func verifySignature(alg string, key crypto.PublicKey, signingInput string, sig []byte) error {
+	if alg == "EdDSA" {
+		pub, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return errors.New("key type does not match EdDSA")
+		}
+		if !ed25519.Verify(pub, []byte(signingInput), sig) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	}
+
+	hash := hashForAlg(alg)
+	h := hash.New()
+	h.Write([]byte(signingInput))
+	digest := h.Sum(nil)
+	switch alg[:2] {
+	case "RS", "PS":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key type does not match %s", alg)
+		}
+		if alg[0] == 'P' {
+			return rsa.VerifyPSS(pub, hash, digest, sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		}
+		return rsa.VerifyPKCS1v15(pub, hash, digest, sig)
+	case "ES":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key type does not match %s", alg)
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return errors.New("invalid ECDSA signature length")
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported algorithm %q", alg)
+}
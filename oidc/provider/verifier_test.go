@@ -0,0 +1,142 @@
+package oidc_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	oidc "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifier(t *testing.T) {
+	iss := newTestIssuer(t)
+	v := oidc.NewVerifier(oidc.VerifierConfig{Issuer: iss.URL, Audience: "api"})
+	ctx := context.Background()
+
+	for _, tc := range []struct{ alg, kid string }{{"RS256", "rsa"}, {"ES256", "ec"}, {"EdDSA", "ed"}} {
+		t.Run("valid "+tc.alg, func(t *testing.T) {
+			claims, err := v.Verify(ctx, iss.sign(t, tc.alg, tc.kid, iss.claims("api")))
+			require.NoError(t, err)
+			require.Equal(t, "user-1", claims.Subject)
+			require.Equal(t, []string{"api"}, claims.Audience)
+		})
+	}
+
+	expired := iss.claims("api")
+	expired["exp"] = time.Now().Add(-time.Hour).Unix()
+	future := iss.claims("api")
+	future["nbf"] = time.Now().Add(time.Hour).Unix()
+	wrongIss := iss.claims("api")
+	wrongIss["iss"] = "https://other"
+	tampered := iss.sign(t, "RS256", "rsa", iss.claims("api"))
+	tampered = tampered[:len(tampered)-4] + "AAAA"
+
+	failures := []struct {
+		name   string
+		token  string
+		reason oidc.FailureReason
+	}{
+		{"malformed", "not-a-jwt", oidc.ReasonMalformed},
+		{"alg none", iss.sign(t, "none", "rsa", iss.claims("api")), oidc.ReasonUnsupportedAlg},
+		{"unknown kid", iss.sign(t, "RS256", "missing", iss.claims("api")), oidc.ReasonUnknownKeyID},
+		{"bad signature", tampered, oidc.ReasonBadSignature},
+		{"key type mismatch", iss.sign(t, "ES256", "rsa", iss.claims("api")), oidc.ReasonBadSignature},
+		{"expired", iss.sign(t, "RS256", "rsa", expired), oidc.ReasonExpired},
+		{"not yet valid", iss.sign(t, "RS256", "rsa", future), oidc.ReasonNotYetValid},
+		{"issuer mismatch", iss.sign(t, "RS256", "rsa", wrongIss), oidc.ReasonIssuerMismatch},
+		{"audience mismatch", iss.sign(t, "RS256", "rsa", iss.claims("other")), oidc.ReasonAudienceMismatch},
+	}
+	for _, tc := range failures {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := v.Verify(ctx, tc.token)
+			var verr *oidc.VerificationError
+			require.True(t, errors.As(err, &verr), "got %v", err)
+			require.Equal(t, tc.reason, verr.Reason)
+		})
+	}
+}
+
+func TestVerifierMiddlewareModes(t *testing.T) {
+	iss := newTestIssuer(t)
+	valid := iss.sign(t, "RS256", "rsa", iss.claims("api"))
+	var gotClaims *oidc.Claims
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotClaims, _ = oidc.ClaimsFromContext(r.Context())
+	})
+	call := func(mw http.Handler, token string) int {
+		gotClaims = nil
+		req := httptest.NewRequest(http.MethodGet, "/orders", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		mw.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	t.Run("enforce", func(t *testing.T) {
+		mw := oidc.NewVerifier(oidc.VerifierConfig{Issuer: iss.URL, Audience: "api"}).Middleware(handler)
+		require.Equal(t, http.StatusOK, call(mw, valid))
+		require.NotNil(t, gotClaims)
+		require.Equal(t, http.StatusUnauthorized, call(mw, ""))
+		require.Equal(t, http.StatusUnauthorized, call(mw, "garbage"))
+	})
+
+	t.Run("observe logs and allows", func(t *testing.T) {
+		var buf bytes.Buffer
+		mw := oidc.NewVerifier(oidc.VerifierConfig{
+			Issuer:   iss.URL,
+			Audience: "api",
+			Mode:     oidc.VerifyModeObserve,
+			Logger:   slog.New(slog.NewJSONHandler(&buf, nil)),
+		}).Middleware(handler)
+		require.Equal(t, http.StatusOK, call(mw, iss.sign(t, "RS256", "rsa", iss.claims("other"))))
+		require.Nil(t, gotClaims)
+		require.Contains(t, buf.String(), `"reason":"audience_mismatch"`)
+		require.Contains(t, buf.String(), `"path":"/orders"`)
+
+		require.Equal(t, http.StatusOK, call(mw, valid))
+		require.NotNil(t, gotClaims)
+	})
+}
+
+func TestVerifierAlgorithmAllowlist(t *testing.T) {
+	iss := newTestIssuer(t)
+	ctx := context.Background()
+	reason := func(err error) oidc.FailureReason {
+		var verr *oidc.VerificationError
+		if errors.As(err, &verr) {
+			return verr.Reason
+		}
+		return ""
+	}
+
+	t.Run("restricted list", func(t *testing.T) {
+		v := oidc.NewVerifier(oidc.VerifierConfig{Issuer: iss.URL, AllowedAlgorithms: []string{"RS256", "ES256"}})
+		_, err := v.Verify(ctx, iss.sign(t, "ES256", "ec", iss.claims("api")))
+		require.NoError(t, err)
+		_, err = v.Verify(ctx, iss.sign(t, "EdDSA", "ed", iss.claims("api")))
+		require.Equal(t, oidc.ReasonUnsupportedAlg, reason(err))
+	})
+
+	t.Run("symmetric and none rejected even when listed", func(t *testing.T) {
+		v := oidc.NewVerifier(oidc.VerifierConfig{Issuer: iss.URL, AllowedAlgorithms: []string{"HS256", "none", "RS256"}})
+		_, err := v.Verify(ctx, iss.sign(t, "HS256", "rsa", iss.claims("api")))
+		require.Equal(t, oidc.ReasonUnsupportedAlg, reason(err))
+		_, err = v.Verify(ctx, iss.sign(t, "none", "rsa", iss.claims("api")))
+		require.Equal(t, oidc.ReasonUnsupportedAlg, reason(err))
+	})
+
+	t.Run("defaults accept all asymmetric algorithms", func(t *testing.T) {
+		v := oidc.NewVerifier(oidc.VerifierConfig{Issuer: iss.URL})
+		_, err := v.Verify(ctx, iss.sign(t, "EdDSA", "ed", iss.claims("api")))
+		require.NoError(t, err)
+	})
+}
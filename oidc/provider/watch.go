@@ -0,0 +1,148 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// TokenUpdate is delivered by TokenCache.Watch for every new token or failed refresh
+type TokenUpdate struct {
+	Token  string
+	Expiry time.Time
+	Err    error // set when a refresh failed, Token is empty then
+}
+
+// watchRetry spaces the refresh attempts of a watch after failures
+var watchRetry = RetryPolicy{BaseDelay: time.Second, MaxDelay: time.Minute}
+
+// watcher is one Watch subscription, last is the token delivered most recently
+type watcher struct {
+	ch     chan TokenUpdate
+	last   string
+	cancel context.CancelFunc
+}
+
+// Watch returns a channel receiving the current token and every token the cache obtains afterwards,
+// whether the refresh was triggered by the watch itself or by a GetValidToken caller
+// The watch refreshes the token shortly before it expires, so consumers do not need to poll
+// The channel holds only the latest update: a slow consumer skips intermediate tokens
+// It is closed once ctx is done or the cache is closed
+func (c *TokenCache) Watch(ctx context.Context) <-chan TokenUpdate {
+	ctx, cancel := context.WithCancel(ctx)
+	w := &watcher{ch: make(chan TokenUpdate, 1), cancel: cancel}
+	c.watchMu.Lock()
+	defer c.watchMu.Unlock()
+	if c.closed {
+		cancel()
+		close(w.ch)
+		return w.ch
+	}
+	if c.watchers == nil {
+		c.watchers = make(map[*watcher]struct{})
+	}
+	c.watchers[w] = struct{}{}
+	c.watchWG.Add(1)
+	go c.watch(ctx, w)
+	return w.ch
+}
+
+// Close stops every Watch of the cache and waits for them to exit
+// The cache still serves GetValidToken afterwards, but new watches are closed immediately
+func (c *TokenCache) Close() error {
+	c.stopWatches()
+	c.watchWG.Wait()
+	return nil
+}
+
+// stopWatches cancels every Watch of the cache without waiting for them, see Close
+func (c *TokenCache) stopWatches() {
+	c.watchMu.Lock()
+	defer c.watchMu.Unlock()
+	c.closed = true
+	for w := range c.watchers {
+		w.cancel()
+	}
+}
+
+// watch keeps the token fresh until ctx is done
+func (c *TokenCache) watch(ctx context.Context, w *watcher) {
+	defer c.watchWG.Done()
+	defer func() {
+		c.watchMu.Lock()
+		delete(c.watchers, w)
+		c.watchMu.Unlock()
+		w.cancel()
+		close(w.ch)
+	}()
+	failures := 0
+	for {
+		var token string
+		var expiry time.Time
+		// A panic while refreshing is delivered as error update and retried with backoff like a failure
+		err := protect("watch", func() (err error) {
+			token, expiry, err = c.GetValidTokenExpiry(ctx)
+			return err
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		var wait time.Duration
+		if err != nil {
+			failures++
+			c.deliver(w, TokenUpdate{Err: err})
+			var tErr *TokenError
+			var retryAfter time.Duration
+			if errors.As(err, &tErr) {
+				retryAfter = tErr.RetryAfter
+			}
+			wait, _ = watchRetry.Backoff(failures, retryAfter)
+		} else {
+			failures = 0
+			c.deliver(w, TokenUpdate{Token: token, Expiry: expiry})
+			// GetValidToken refreshes one minute (or the strict minimum) before expiry,
+			// or when the refresh strategy says so
+			wait = time.Until(c.nextRefresh())
+			if wait < time.Second {
+				wait = time.Second
+			}
+		}
+		if sleepContext(ctx, wait) != nil {
+			return
+		}
+	}
+}
+
+// notify delivers a refreshed token to every watcher
+func (c *TokenCache) notify(u TokenUpdate) {
+	c.watchMu.Lock()
+	defer c.watchMu.Unlock()
+	for w := range c.watchers {
+		c.send(w, u)
+	}
+}
+
+// deliver sends u to one watcher
+func (c *TokenCache) deliver(w *watcher, u TokenUpdate) {
+	c.watchMu.Lock()
+	defer c.watchMu.Unlock()
+	if _, ok := c.watchers[w]; ok {
+		c.send(w, u)
+	}
+}
+
+// send replaces a pending update of w with u, tokens already delivered are skipped
+// The caller must hold c.watchMu
+func (c *TokenCache) send(w *watcher, u TokenUpdate) {
+	if u.Err == nil && u.Token == w.last {
+		return
+	}
+	if u.Err == nil {
+		w.last = u.Token
+	}
+	select {
+	case <-w.ch:
+	default:
+	}
+	w.ch <- u
+}
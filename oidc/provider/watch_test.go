@@ -0,0 +1,80 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	oidc "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/stretchr/testify/require"
+)
+
+// sequenceProvider returns a new token on every fetch, or an error while failing is set
+type sequenceProvider struct {
+	t       *testing.T
+	calls   atomic.Int32
+	failing atomic.Bool
+}
+
+func (s *sequenceProvider) FetchToken(ctx context.Context) (string, error) {
+	n := s.calls.Add(1)
+	if s.failing.Load() {
+		return "", errors.New("idp down")
+	}
+	return makeJWT(s.t, map[string]interface{}{"exp": time.Now().Add(time.Hour).Unix(), "n": n}), nil
+}
+
+func receive(t *testing.T, ch <-chan oidc.TokenUpdate) oidc.TokenUpdate {
+	t.Helper()
+	select {
+	case u, ok := <-ch:
+		require.True(t, ok, "watch channel closed")
+		return u
+	case <-time.After(5 * time.Second):
+		t.Fatal("no token update")
+	}
+	return oidc.TokenUpdate{}
+}
+
+func TestTokenCacheWatch(t *testing.T) {
+	p := &sequenceProvider{t: t}
+	cache := oidc.NewTokenCache(p)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	updates := cache.Watch(ctx)
+	first := receive(t, updates)
+	require.NoError(t, first.Err)
+	require.NotEmpty(t, first.Token)
+	require.WithinDuration(t, time.Now().Add(time.Hour), first.Expiry, 2*time.Second)
+
+	// A refresh triggered by another caller is pushed to the watcher
+	cache.ForceExpire(time.Now())
+	token, err := cache.GetValidToken(context.Background())
+	require.NoError(t, err)
+	second := receive(t, updates)
+	require.Equal(t, token, second.Token)
+	require.NotEqual(t, first.Token, second.Token)
+
+	cancel()
+	for range updates {
+	}
+}
+
+func TestTokenCacheWatchRetriesFailures(t *testing.T) {
+	p := &sequenceProvider{t: t}
+	p.failing.Store(true)
+	cache := oidc.NewTokenCache(p)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	updates := cache.Watch(ctx)
+	require.ErrorContains(t, receive(t, updates).Err, "idp down")
+	p.failing.Store(false)
+	u := receive(t, updates)
+	require.NoError(t, u.Err)
+	require.NotEmpty(t, u.Token)
+}
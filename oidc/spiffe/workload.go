@@ -0,0 +1,104 @@
+// Package spiffe is a minimal SPIFFE Workload API client fetching JWT-SVIDs,
+// to be used with oidcprovider.SpiffeTokenProvider (and through it as WIF subject token).
+package spiffe
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+	"github.com/spiffe/go-spiffe/v2/svid/jwtsvid"
+	"github.com/spiffe/go-spiffe/v2/workloadapi"
+)
+
+// EndpointSocketEnv is the environment variable holding the Workload API address.
+const EndpointSocketEnv = workloadapi.SocketEnv
+
+// WorkloadClient fetches JWT-SVIDs from the SPIFFE Workload API (e.g. a SPIRE agent socket).
+// It implements oidcprovider.JWTSVIDFetcher.
+type WorkloadClient struct {
+	// Address of the Workload API, e.g. "unix:///run/spire/sockets/agent.sock" or
+	// "tcp:127.0.0.1:8081". Defaults to SPIFFE_ENDPOINT_SOCKET.
+	Address string
+
+	mu     sync.Mutex
+	client *workloadapi.Client
+}
+
+// NewWorkloadClient creates a client for address, SPIFFE_ENDPOINT_SOCKET when empty.
+func NewWorkloadClient(address string) *WorkloadClient {
+	return &WorkloadClient{Address: address}
+}
+
+// FetchJWTSVID fetches a JWT-SVID for audience. spiffeID selects one of the workload's
+// identities and may be empty; the first SVID returned by the agent is used then.
+func (c *WorkloadClient) FetchJWTSVID(ctx context.Context, audience, spiffeID string) (string, error) {
+	params := jwtsvid.Params{Audience: audience}
+	if spiffeID != "" {
+		id, err := spiffeid.FromString(spiffeID)
+		if err != nil {
+			return "", fmt.Errorf("invalid SPIFFE ID %q: %w", spiffeID, err)
+		}
+		params.Subject = id
+	}
+	client, err := c.workloadClient(ctx)
+	if err != nil {
+		return "", err
+	}
+	svid, err := client.FetchJWTSVID(ctx, params)
+	if err != nil {
+		return "", fmt.Errorf("workload API: %w", err)
+	}
+	return svid.Marshal(), nil
+}
+
+// Close closes the connection to the Workload API.
+func (c *WorkloadClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.client == nil {
+		return nil
+	}
+	err := c.client.Close()
+	c.client = nil
+	return err
+}
+
+// workloadClient returns the Workload API client, creating it on first use.
+func (c *WorkloadClient) workloadClient(ctx context.Context) (*workloadapi.Client, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.client != nil {
+		return c.client, nil
+	}
+	address := c.Address
+	if address == "" {
+		address = os.Getenv(EndpointSocketEnv)
+	}
+	if address == "" {
+		return nil, fmt.Errorf("workload API address is not configured, set %s", EndpointSocketEnv)
+	}
+	address = normalizeAddress(address)
+	if err := workloadapi.ValidateAddress(address); err != nil {
+		return nil, fmt.Errorf("invalid workload API address %q: %w", address, err)
+	}
+	// Dialing does not block, the connection is established by the first call
+	client, err := workloadapi.New(ctx, workloadapi.WithAddr(address))
+	if err != nil {
+		return nil, fmt.Errorf("workload API: %w", err)
+	}
+	c.client = client
+	return client, nil
+}
+
+// normalizeAddress turns the "tcp:host:port" form allowed by the SPIFFE Workload Endpoint
+// spec into the URL form go-spiffe parses.
+func normalizeAddress(address string) string {
+	if rest, ok := strings.CutPrefix(address, "tcp:"); ok && !strings.HasPrefix(rest, "//") {
+		return "tcp://" + rest
+	}
+	return address
+}
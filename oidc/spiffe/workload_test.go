@@ -0,0 +1,131 @@
+package spiffe
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	oidcprovider "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/spiffe/go-spiffe/v2/proto/spiffe/workload"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// fakeAgent issues JWT-SVIDs for its identities, selected by the requested SPIFFE ID like SPIRE does
+type fakeAgent struct {
+	workload.UnimplementedSpiffeWorkloadAPIServer
+	t      *testing.T
+	ids    []string
+	signer jose.Signer
+}
+
+func (a *fakeAgent) FetchJWTSVID(ctx context.Context, req *workload.JWTSVIDRequest) (*workload.JWTSVIDResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if len(md.Get("workload.spiffe.io")) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "security header missing from request")
+	}
+	resp := &workload.JWTSVIDResponse{}
+	for _, id := range a.ids {
+		if req.SpiffeId != "" && req.SpiffeId != id {
+			continue
+		}
+		token, err := jwt.Signed(a.signer).Claims(jwt.Claims{
+			Subject:  id,
+			Audience: req.Audience,
+			Expiry:   jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		}).Serialize()
+		require.NoError(a.t, err)
+		resp.Svids = append(resp.Svids, &workload.JWTSVID{SpiffeId: id, Svid: token})
+	}
+	return resp, nil
+}
+
+// newFakeAgent serves the Workload API on network and returns its address
+func newFakeAgent(t *testing.T, network string, ids ...string) string {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: key}, nil)
+	require.NoError(t, err)
+
+	var lis net.Listener
+	var address string
+	if network == "unix" {
+		socket := filepath.Join(t.TempDir(), "agent.sock")
+		lis, err = net.Listen("unix", socket)
+		address = "unix://" + socket
+	} else {
+		lis, err = net.Listen("tcp", "127.0.0.1:0")
+		if err == nil {
+			address = "tcp:" + lis.Addr().String()
+		}
+	}
+	require.NoError(t, err)
+	srv := grpc.NewServer()
+	workload.RegisterSpiffeWorkloadAPIServer(srv, &fakeAgent{t: t, ids: ids, signer: signer})
+	go func() { _ = srv.Serve(lis) }()
+	t.Cleanup(srv.Stop)
+	return address
+}
+
+// subject returns the sub claim of an SVID
+func subject(t *testing.T, svid string) string {
+	t.Helper()
+	claims, err := oidcprovider.DecodeJWTClaims(svid, false)
+	require.NoError(t, err)
+	sub, _ := claims["sub"].(string)
+	return sub
+}
+
+func TestWorkloadClient(t *testing.T) {
+	for _, network := range []string{"unix", "tcp"} {
+		t.Run(network, func(t *testing.T) {
+			address := newFakeAgent(t, network, "spiffe://example.org/orders", "spiffe://example.org/billing")
+			client := NewWorkloadClient(address)
+			t.Cleanup(func() { _ = client.Close() })
+
+			svid, err := client.FetchJWTSVID(context.Background(), "gcp", "")
+			require.NoError(t, err)
+			require.Equal(t, "spiffe://example.org/orders", subject(t, svid))
+
+			svid, err = client.FetchJWTSVID(context.Background(), "gcp", "spiffe://example.org/billing")
+			require.NoError(t, err)
+			require.Equal(t, "spiffe://example.org/billing", subject(t, svid))
+
+			_, err = client.FetchJWTSVID(context.Background(), "gcp", "spiffe://example.org/unknown")
+			require.ErrorContains(t, err, "no SVIDs")
+
+			// Usable as the fetcher of the provider
+			p := &oidcprovider.SpiffeTokenProvider{Fetcher: client, Audience: "gcp", SPIFFEID: "spiffe://example.org/orders"}
+			svid, err = p.FetchToken(context.Background())
+			require.NoError(t, err)
+			require.Equal(t, "spiffe://example.org/orders", subject(t, svid))
+		})
+	}
+}
+
+func TestWorkloadClientAddress(t *testing.T) {
+	t.Setenv(EndpointSocketEnv, "")
+	_, err := NewWorkloadClient("").FetchJWTSVID(context.Background(), "gcp", "")
+	require.ErrorContains(t, err, EndpointSocketEnv)
+
+	_, err = NewWorkloadClient("/run/agent.sock").FetchJWTSVID(context.Background(), "gcp", "")
+	require.ErrorContains(t, err, `"tcp" or "unix" scheme`)
+
+	_, err = NewWorkloadClient("tcp:agent.local:8081").FetchJWTSVID(context.Background(), "gcp", "")
+	require.ErrorContains(t, err, "must be an IP:port")
+
+	_, err = NewWorkloadClient("unix:///run/agent.sock").FetchJWTSVID(context.Background(), "gcp", "orders")
+	require.ErrorContains(t, err, "invalid SPIFFE ID")
+}
@@ -0,0 +1,158 @@
+// Package tokenexchange is a client for the OAuth 2.0 token exchange grant (RFC 8693), usable against
+// Keycloak, Okta, Google STS and any other endpoint implementing the standard.
+package tokenexchange
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	oidcprovider "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+
+	"golang.org/x/oauth2"
+)
+
+// GrantType is the grant_type of token exchange requests.
+const GrantType = string(oidcprovider.GrantTokenExchange)
+
+// GoogleSTSURL is the token endpoint of Google's Security Token Service.
+const GoogleSTSURL = "https://sts.googleapis.com/v1/token"
+
+// ExchangeRequest holds the parameters of one exchange, empty optional fields are not sent.
+type ExchangeRequest struct {
+	SubjectToken     string
+	SubjectTokenType string // default oidcprovider.TokenTypeAccessToken
+	// ActorToken is the token of the party acting on behalf of the subject (delegation), optional.
+	ActorToken     string
+	ActorTokenType string // default oidcprovider.TokenTypeAccessToken when ActorToken is set
+	// RequestedTokenType is the type of the issued token, e.g. oidcprovider.TokenTypeIDToken, optional.
+	RequestedTokenType string
+	Audience           []string // logical names of the target services
+	Resource           []string // URIs of the target services
+	Scopes             []string
+}
+
+// Response is the token exchange response (RFC 8693 section 2.2.1).
+type Response struct {
+	AccessToken     string // the issued token, also when it is not an access token
+	IssuedTokenType string
+	TokenType       string // usually "Bearer", "N_A" when the token is not an access token
+	Expiry          time.Time
+	Scopes          []string // granted scopes when they differ from the requested ones
+	RefreshToken    string
+}
+
+// Token returns the response as an oauth2.Token.
+func (r *Response) Token() *oauth2.Token {
+	return &oauth2.Token{AccessToken: r.AccessToken, TokenType: r.TokenType, RefreshToken: r.RefreshToken, Expiry: r.Expiry}
+}
+
+// Client sends token exchange requests to TokenURL.
+// With ClientSecret set the client authenticates with HTTP Basic (client_secret_basic), otherwise
+// a non-empty ClientID is sent as form parameter, as public clients do; Google STS needs neither.
+type Client struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	// AuthStyle can force oauth2.AuthStyleInParams for servers rejecting Basic authentication.
+	AuthStyle  oauth2.AuthStyle
+	HTTPClient *http.Client // default oidcprovider.NewHTTPClient("token-exchange", false)
+}
+
+// NewKeycloakClient returns a client exchanging with the realm and client credentials of cfg.
+// The Keycloak client needs the token exchange permission.
+func NewKeycloakClient(cfg *oidcprovider.ConfigKeyCloak, insecure bool) *Client {
+	return &Client{
+		TokenURL:     cfg.TokenURL(),
+		ClientID:     cfg.KeycloakClientID,
+		ClientSecret: cfg.KeycloakClientSecret,
+		HTTPClient:   oidcprovider.NewHTTPClient("keycloak", insecure),
+	}
+}
+
+// NewOktaClient returns a client exchanging with the Okta authorization server issuer, e.g.
+// https://example.okta.com/oauth2/default.
+func NewOktaClient(issuer, clientID, clientSecret string) *Client {
+	return &Client{
+		TokenURL:     strings.TrimRight(issuer, "/") + "/v1/token",
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+	}
+}
+
+// NewGoogleSTSClient returns a client exchanging with Google's Security Token Service, e.g. a
+// Workload Identity Federation subject token for a federated access token.
+func NewGoogleSTSClient() *Client {
+	return &Client{TokenURL: GoogleSTSURL}
+}
+
+// Exchange sends req and returns the issued token, using oidcprovider.STSExchanger.
+// Error responses are returned as *oidcprovider.TokenError, requests ended by ctx as
+// *oidcprovider.CanceledError.
+func (c *Client) Exchange(ctx context.Context, req ExchangeRequest) (*Response, error) {
+	if c.TokenURL == "" {
+		return nil, errors.New("token exchange configuration is incomplete: TokenURL must be provided")
+	}
+	if req.SubjectToken == "" {
+		return nil, errors.New("token exchange requires a subject token")
+	}
+	client := c.HTTPClient
+	if client == nil {
+		client = oidcprovider.NewHTTPClient("token-exchange", false)
+	}
+	exchanger := &oidcprovider.STSExchanger{
+		TokenURL:           c.TokenURL,
+		ClientID:           c.ClientID,
+		ClientSecret:       c.ClientSecret,
+		AuthStyle:          c.AuthStyle,
+		SubjectTokenType:   req.SubjectTokenType,
+		RequestedTokenType: req.RequestedTokenType,
+		Resource:           req.Resource,
+		ActorToken:         req.ActorToken,
+		ActorTokenType:     req.ActorTokenType,
+		Scopes:             req.Scopes,
+		Audiences:          req.Audience,
+		HTTPClient:         client,
+	}
+	tok, err := exchanger.Exchange(ctx, req.SubjectToken, "")
+	if err != nil {
+		return nil, err
+	}
+	resp := &Response{
+		AccessToken:  tok.AccessToken,
+		TokenType:    tok.TokenType,
+		Expiry:       tok.Expiry,
+		RefreshToken: tok.RefreshToken,
+	}
+	resp.IssuedTokenType, _ = tok.Extra("issued_token_type").(string)
+	if scope, ok := tok.Extra("scope").(string); ok {
+		resp.Scopes = strings.Fields(scope)
+	}
+	return resp, nil
+}
+
+// Exchanger adapts the client to oidcprovider.TokenExchanger, e.g. for oidcprovider.OnBehalfOf.
+// Every exchange sends template with the given subject token and audience.
+func (c *Client) Exchanger(template ExchangeRequest) oidcprovider.TokenExchanger {
+	return &exchanger{client: c, template: template}
+}
+
+type exchanger struct {
+	client   *Client
+	template ExchangeRequest
+}
+
+func (e *exchanger) Exchange(ctx context.Context, subjectToken, audience string) (*oauth2.Token, error) {
+	req := e.template
+	req.SubjectToken = subjectToken
+	if audience != "" {
+		req.Audience = []string{audience}
+	}
+	resp, err := e.client.Exchange(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Token(), nil
+}
@@ -0,0 +1,149 @@
+package tokenexchange_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	oidcprovider "github.com/PCS-Indonesia/pcs-oidc/oidc/provider"
+	"github.com/PCS-Indonesia/pcs-oidc/oidc/tokenexchange"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+// newFakeSTS serves exchanges, handing each request's form and Basic credentials to check
+func newFakeSTS(t *testing.T, check func(form url.Values, user, pass string)) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		user, pass, _ := r.BasicAuth()
+		check(r.PostForm, user, pass)
+		if r.PostForm.Get("subject_token") == "revoked" {
+			w.Header().Set("Retry-After", "3")
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"invalid_grant","error_description":"subject token revoked"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token":      "exchanged-" + r.PostForm.Get("subject_token"),
+			"issued_token_type": oidcprovider.TokenTypeAccessToken,
+			"token_type":        "Bearer",
+			"expires_in":        300,
+			"scope":             "orders.read",
+		})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestExchange(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("confidential client", func(t *testing.T) {
+		srv := newFakeSTS(t, func(form url.Values, user, pass string) {
+			require.Equal(t, tokenexchange.GrantType, form.Get("grant_type"))
+			require.Equal(t, oidcprovider.TokenTypeAccessToken, form.Get("subject_token_type"))
+			require.Equal(t, "actor", form.Get("actor_token"))
+			require.Equal(t, oidcprovider.TokenTypeJWT, form.Get("actor_token_type"))
+			require.Equal(t, oidcprovider.TokenTypeIDToken, form.Get("requested_token_type"))
+			require.Equal(t, []string{"orders", "billing"}, form["audience"])
+			require.Equal(t, []string{"https://orders.example.com"}, form["resource"])
+			require.Equal(t, "orders.read orders.write", form.Get("scope"))
+			require.Empty(t, form.Get("client_id"))
+			require.Equal(t, "gateway", user)
+			require.Equal(t, "s3cret", pass)
+		})
+		c := &tokenexchange.Client{TokenURL: srv.URL, ClientID: "gateway", ClientSecret: "s3cret"}
+		resp, err := c.Exchange(ctx, tokenexchange.ExchangeRequest{
+			SubjectToken:       "user",
+			ActorToken:         "actor",
+			ActorTokenType:     oidcprovider.TokenTypeJWT,
+			RequestedTokenType: oidcprovider.TokenTypeIDToken,
+			Audience:           []string{"orders", "billing"},
+			Resource:           []string{"https://orders.example.com"},
+			Scopes:             []string{"orders.read", "orders.write"},
+		})
+		require.NoError(t, err)
+		require.Equal(t, "exchanged-user", resp.AccessToken)
+		require.Equal(t, oidcprovider.TokenTypeAccessToken, resp.IssuedTokenType)
+		require.Equal(t, []string{"orders.read"}, resp.Scopes)
+		require.WithinDuration(t, time.Now().Add(5*time.Minute), resp.Expiry, 5*time.Second)
+	})
+
+	t.Run("credentials in params", func(t *testing.T) {
+		srv := newFakeSTS(t, func(form url.Values, user, _ string) {
+			require.Empty(t, user)
+			require.Empty(t, form.Get("actor_token"))
+			require.Equal(t, "gateway", form.Get("client_id"))
+			require.Equal(t, "s3cret", form.Get("client_secret"))
+		})
+		c := &tokenexchange.Client{TokenURL: srv.URL, ClientID: "gateway", ClientSecret: "s3cret", AuthStyle: oauth2.AuthStyleInParams}
+		_, err := c.Exchange(ctx, tokenexchange.ExchangeRequest{SubjectToken: "user"})
+		require.NoError(t, err)
+	})
+
+	t.Run("expires_in as string", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"access_token":"exchanged","token_type":"Bearer","expires_in":"300"}`))
+		}))
+		t.Cleanup(srv.Close)
+		resp, err := (&tokenexchange.Client{TokenURL: srv.URL}).Exchange(ctx, tokenexchange.ExchangeRequest{SubjectToken: "user"})
+		require.NoError(t, err)
+		require.WithinDuration(t, time.Now().Add(5*time.Minute), resp.Expiry, 5*time.Second)
+	})
+
+	t.Run("error response", func(t *testing.T) {
+		srv := newFakeSTS(t, func(url.Values, string, string) {})
+		c := &tokenexchange.Client{TokenURL: srv.URL}
+		_, err := c.Exchange(ctx, tokenexchange.ExchangeRequest{SubjectToken: "revoked"})
+		var tErr *oidcprovider.TokenError
+		require.ErrorAs(t, err, &tErr)
+		require.Equal(t, http.StatusBadRequest, tErr.StatusCode)
+		require.Equal(t, "invalid_grant", tErr.Code)
+		require.Equal(t, "subject token revoked", tErr.Description)
+		require.Equal(t, 3*time.Second, tErr.RetryAfter)
+	})
+
+	t.Run("canceled by caller", func(t *testing.T) {
+		srv := newFakeSTS(t, func(url.Values, string, string) {})
+		canceled, cancel := context.WithCancel(ctx)
+		cancel()
+		_, err := (&tokenexchange.Client{TokenURL: srv.URL}).Exchange(canceled, tokenexchange.ExchangeRequest{SubjectToken: "user"})
+		require.True(t, oidcprovider.IsCanceled(err))
+		require.ErrorIs(t, err, context.Canceled)
+	})
+
+	t.Run("incomplete request", func(t *testing.T) {
+		_, err := (&tokenexchange.Client{}).Exchange(ctx, tokenexchange.ExchangeRequest{SubjectToken: "user"})
+		require.ErrorContains(t, err, "TokenURL")
+		_, err = (&tokenexchange.Client{TokenURL: "http://127.0.0.1:1"}).Exchange(ctx, tokenexchange.ExchangeRequest{})
+		require.ErrorContains(t, err, "subject token")
+	})
+
+	t.Run("exchanger for on-behalf-of", func(t *testing.T) {
+		srv := newFakeSTS(t, func(form url.Values, _, _ string) {
+			require.Equal(t, []string{"billing"}, form["audience"])
+			require.Equal(t, "orders.read", form.Get("scope"))
+		})
+		c := &tokenexchange.Client{TokenURL: srv.URL}
+		var ex oidcprovider.TokenExchanger = c.Exchanger(tokenexchange.ExchangeRequest{Scopes: []string{"orders.read"}})
+		tok, err := ex.Exchange(ctx, "user", "billing")
+		require.NoError(t, err)
+		require.Equal(t, "exchanged-user", tok.AccessToken)
+		require.Equal(t, "Bearer", tok.TokenType)
+	})
+
+	t.Run("provider endpoints", func(t *testing.T) {
+		kc := tokenexchange.NewKeycloakClient(&oidcprovider.ConfigKeyCloak{KeycloakRealmURL: "https://kc.example.com/realms/pcs/", KeycloakClientID: "gateway"}, false)
+		require.Equal(t, "https://kc.example.com/realms/pcs/protocol/openid-connect/token", kc.TokenURL)
+		require.Equal(t, "https://example.okta.com/oauth2/default/v1/token", tokenexchange.NewOktaClient("https://example.okta.com/oauth2/default", "id", "secret").TokenURL)
+		require.Equal(t, tokenexchange.GoogleSTSURL, tokenexchange.NewGoogleSTSClient().TokenURL)
+	})
+}